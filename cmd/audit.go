@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/internal/tokens"
+	"github.com/nbrglm/nexeres/opts"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	auditVerifyOrg string
+	auditPruneDays int
+	auditTestSink  string
+)
+
+func initAuditCommand() {
+	auditCmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Manage the audit logs.",
+		Long:  "Manage the audit logs. Verify the hash chains of the audit logs, delete old logs, or test the audit sinks.",
+	}
+	auditCmd.PersistentFlags().StringVar(opts.ConfigPath, "config", "/etc/nbrglm/workspace/nexeres/config.yaml", "Path to the config file")
+	auditCmd.MarkPersistentFlagFilename("config", "yaml", "yml")
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the audit log hash chains.",
+		Long: "Verify the audit log hash chains. Walks the chain of every organization, recomputing the hashes of the entries and checking the signed checkpoints, " +
+			"and reports where a chain breaks. Exits with status 1 if any chain is broken. " +
+			"Checkpoints signed with a rotated key are verified with jwt.retiredPublicKeyFiles, or reported as unverifiable without failing.",
+		Run: func(cmd *cobra.Command, args []string) {
+			auditVerify(cmd)
+		},
+	}
+	verifyCmd.Flags().StringVar(&auditVerifyOrg, "org", "", "Only verify the chain of this organization ID, use the nil UUID for events outside of organizations")
+
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete old audit logs.",
+		Long:  "Delete the audit logs older than the given number of days. A signed tombstone is left in every chain, so that the remaining entries still verify.",
+		Run: func(cmd *cobra.Command, args []string) {
+			auditPrune(cmd)
+		},
+	}
+	pruneCmd.Flags().IntVar(&auditPruneDays, "older-than-days", 0, "Delete the logs older than this many days")
+	pruneCmd.MarkFlagRequired("older-than-days")
+
+	testSinksCmd := &cobra.Command{
+		Use:   "test-sinks",
+		Short: "Send a test event to the audit sinks.",
+		Long:  "Send a test event to the audit sinks of the config file, eg. to check the connection to a syslog server. Exits with status 1 if any sink fails.",
+		Run: func(cmd *cobra.Command, args []string) {
+			auditTestSinks(cmd)
+		},
+	}
+	testSinksCmd.Flags().StringVar(&auditTestSink, "sink", "", "Only test the sink with this name")
+
+	auditCmd.AddCommand(verifyCmd, pruneCmd, testSinksCmd)
+	rootCmd.AddCommand(auditCmd)
+}
+
+// initAuditCLI loads the config, the token keys and the database connection, which are needed by the audit commands.
+func initAuditCLI(cmd *cobra.Command) {
+	// Initialize the validator before everything else, since validation is used by the config file loader.
+	utils.InitValidator()
+
+	if err := config.LoadConfigOptions(*opts.ConfigPath); err != nil {
+		cmd.PrintErrf("Error loading config file: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Log to the console, the commands are run interactively.
+	logging.ReplaceWithDebugLogger()
+
+	if err := tokens.InitTokens(); err != nil {
+		cmd.PrintErrf("Error loading the token keys: %v\n", err)
+		os.Exit(1)
+	}
+	if err := store.InitDB(context.Background()); err != nil {
+		cmd.PrintErrf("Error connecting to the database: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func auditVerify(cmd *cobra.Command) {
+	var orgID *uuid.UUID
+	if auditVerifyOrg != "" {
+		id, err := uuid.Parse(auditVerifyOrg)
+		if err != nil {
+			cmd.PrintErrf("Invalid organization ID: %v\n", err)
+			os.Exit(1)
+		}
+		orgID = &id
+	}
+
+	initAuditCLI(cmd)
+	defer store.PgPool.Close()
+
+	reports, err := audit.Verify(context.Background(), orgID)
+	if err != nil {
+		cmd.PrintErrf("Error verifying the audit logs: %v\n", err)
+		os.Exit(1)
+	}
+	if len(reports) == 0 {
+		cmd.Println("No audit log chains found.")
+		return
+	}
+
+	broken, unverifiable := 0, 0
+	for _, report := range reports {
+		name := "org " + report.ChainID.String()
+		if report.ChainID == audit.GlobalChainID {
+			name = "global"
+		}
+		if report.OK() {
+			cmd.Printf("OK      %s: %d entries from seq %d, %d checkpoints\n", name, report.Entries, report.FromSeq, report.Checkpoints)
+			if report.Unverifiable > 0 {
+				unverifiable++
+				cmd.Printf("        %d checkpoints are unverifiable (rotated key), add the previous public key to jwt.retiredPublicKeyFiles to verify them\n", report.Unverifiable)
+			}
+			continue
+		}
+		broken++
+		cmd.Printf("BROKEN  %s: at seq %d: %s (%d entries verified before)\n", name, report.BrokenAt, report.Reason, report.Entries)
+	}
+
+	if broken > 0 {
+		cmd.PrintErrf("%d of %d audit log chains are broken!\n", broken, len(reports))
+		os.Exit(1)
+	}
+	if unverifiable > 0 {
+		cmd.Printf("All %d audit log chains are intact, %d with checkpoints signed with a rotated key which could not be verified.\n", len(reports), unverifiable)
+		return
+	}
+	cmd.Printf("All %d audit log chains are intact.\n", len(reports))
+}
+
+func auditPrune(cmd *cobra.Command) {
+	if auditPruneDays <= 0 {
+		cmd.PrintErrln("--older-than-days must be positive")
+		os.Exit(1)
+	}
+
+	initAuditCLI(cmd)
+	defer store.PgPool.Close()
+
+	before := time.Now().AddDate(0, 0, -auditPruneDays)
+	deleted, err := audit.DeleteBefore(context.Background(), before)
+	if err != nil {
+		cmd.PrintErrf("Error deleting the audit logs (%d deleted): %v\n", deleted, err)
+		os.Exit(1)
+	}
+	cmd.Printf("Deleted %d audit logs created before %s.\n", deleted, before.UTC().Format(time.RFC3339))
+}
+
+func auditTestSinks(cmd *cobra.Command) {
+	utils.InitValidator()
+	if err := config.LoadConfigOptions(*opts.ConfigPath); err != nil {
+		cmd.PrintErrf("Error loading config file: %v\n", err)
+		os.Exit(1)
+	}
+
+	tested, failed := 0, 0
+	for i := range config.Security.AuditLogs.Sinks {
+		sink := &config.Security.AuditLogs.Sinks[i]
+		if auditTestSink != "" && sink.Name != auditTestSink {
+			continue
+		}
+		tested++
+		if err := audit.SendTestEvent(sink); err != nil {
+			failed++
+			cmd.Printf("FAILED  %s (%s): %v\n", sink.Name, sink.Type, err)
+			continue
+		}
+		cmd.Printf("OK      %s (%s)\n", sink.Name, sink.Type)
+	}
+
+	if tested == 0 {
+		cmd.PrintErrln("No audit sinks to test.")
+		os.Exit(1)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
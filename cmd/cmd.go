@@ -24,7 +24,10 @@ var rootCmd = &cobra.Command{
 // Only supposed to be called once, when the application is started, by the main function.
 func Exec() {
 	initServeCommand()
+	initAuditCommand()
 	initKeygenCommand()
+	initTemplatesCommand()
+	initMigrateCommand()
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
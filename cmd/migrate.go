@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/migrate"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/opts"
+	"github.com/nbrglm/nexeres/sqlc/migrations"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func initMigrateCommand() {
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage the database schema.",
+		Long: "Manage the database schema with the migrations embedded in the binary. " +
+			"Migrations are applied under an advisory lock, so that several instances cannot run them at the same time.",
+	}
+	migrateCmd.PersistentFlags().StringVar(opts.ConfigPath, "config", "/etc/nbrglm/workspace/nexeres/config.yaml", "Path to the config file")
+	migrateCmd.MarkPersistentFlagFilename("config", "yaml", "yml")
+
+	upCmd := &cobra.Command{
+		Use:   "up",
+		Short: "Apply all the pending migrations.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			migrator := initMigrateCLI(cmd)
+			defer store.PgPool.Close()
+			printMigrated(cmd, "Applied", func() ([]migrate.Migration, error) {
+				return migrator.Up(context.Background())
+			})
+		},
+	}
+
+	downCmd := &cobra.Command{
+		Use:   "down [N]",
+		Short: "Revert the last N migrations, default 1.",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			steps := 1
+			if len(args) == 1 {
+				n, err := strconv.Atoi(args[0])
+				if err != nil || n <= 0 {
+					cmd.PrintErrln("N must be a positive number")
+					os.Exit(1)
+				}
+				steps = n
+			}
+			migrator := initMigrateCLI(cmd)
+			defer store.PgPool.Close()
+			printMigrated(cmd, "Reverted", func() ([]migrate.Migration, error) {
+				return migrator.Down(context.Background(), steps)
+			})
+		},
+	}
+
+	toCmd := &cobra.Command{
+		Use:   "to <version>",
+		Short: "Apply or revert migrations until the schema is at the version, 0 to revert all.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			version := parseMigrationVersion(cmd, args[0])
+			migrator := initMigrateCLI(cmd)
+			defer store.PgPool.Close()
+			printMigrated(cmd, "Migrated", func() ([]migrate.Migration, error) {
+				return migrator.To(context.Background(), version)
+			})
+		},
+	}
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the version of the schema and the pending migrations.",
+		Long:  "Show the version of the schema and the pending migrations. Exits with status 1 if migrations are pending or the schema is dirty.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			migrator := initMigrateCLI(cmd)
+			defer store.PgPool.Close()
+			migrateStatus(cmd, migrator)
+		},
+	}
+
+	forceCmd := &cobra.Command{
+		Use:   "force <version>",
+		Short: "Set the version of the schema without applying migrations.",
+		Long:  "Set the version of the schema, and clear the dirty flag, without applying migrations. Use it after fixing the schema by hand, when a migration failed halfway.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			version := parseMigrationVersion(cmd, args[0])
+			migrator := initMigrateCLI(cmd)
+			defer store.PgPool.Close()
+			if err := migrator.Force(context.Background(), version); err != nil {
+				cmd.PrintErrf("Error setting the schema version: %v\n", err)
+				os.Exit(1)
+			}
+			cmd.Printf("The schema version is now %d.\n", version)
+		},
+	}
+
+	migrateCmd.AddCommand(upCmd, downCmd, toCmd, statusCmd, forceCmd)
+	rootCmd.AddCommand(migrateCmd)
+}
+
+// initMigrateCLI loads the config and the database connection, which are needed by the migrate commands.
+func initMigrateCLI(cmd *cobra.Command) *migrate.Migrator {
+	// Initialize the validator before everything else, since validation is used by the config file loader.
+	utils.InitValidator()
+
+	if err := config.LoadConfigOptions(*opts.ConfigPath); err != nil {
+		cmd.PrintErrf("Error loading config file: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Log to the console, the commands are run interactively.
+	logging.ReplaceWithDebugLogger()
+
+	if err := store.InitDB(context.Background()); err != nil {
+		cmd.PrintErrf("Error connecting to the database: %v\n", err)
+		os.Exit(1)
+	}
+
+	migrator, err := newMigrator()
+	if err != nil {
+		cmd.PrintErrf("Error loading the migrations: %v\n", err)
+		os.Exit(1)
+	}
+	return migrator
+}
+
+// newMigrator creates a Migrator with the embedded migrations, for the database of store.PgPool.
+func newMigrator() (*migrate.Migrator, error) {
+	loaded, err := migrate.Load(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(store.PgPool, loaded), nil
+}
+
+func parseMigrationVersion(cmd *cobra.Command, arg string) uint64 {
+	version, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		cmd.PrintErrf("Invalid version %q, use the number at the start of the migration file names\n", arg)
+		os.Exit(1)
+	}
+	return version
+}
+
+// printMigrated runs the migrations, and prints the applied or reverted migrations, even if one failed.
+func printMigrated(cmd *cobra.Command, verb string, run func() ([]migrate.Migration, error)) {
+	applied, err := run()
+	for _, migration := range applied {
+		cmd.Printf("%s %s\n", verb, migration)
+	}
+	if err != nil {
+		cmd.PrintErrf("Error migrating the database: %v\n", err)
+		os.Exit(1)
+	}
+	if len(applied) == 0 {
+		cmd.Println("Nothing to migrate, the schema is up to date.")
+		return
+	}
+	cmd.Printf("%s %d migrations.\n", verb, len(applied))
+}
+
+func migrateStatus(cmd *cobra.Command, migrator *migrate.Migrator) {
+	status, err := migrator.Status(context.Background())
+	if err != nil {
+		cmd.PrintErrf("Error getting the schema status: %v\n", err)
+		os.Exit(1)
+	}
+
+	cmd.Printf("Schema version: %d\n", status.Version)
+	cmd.Printf("Latest version: %d\n", status.Latest)
+	if status.Dirty {
+		cmd.PrintErrln("The schema is dirty: a migration failed halfway. Fix the schema by hand, then run `migrate force <version>`.")
+		os.Exit(1)
+	}
+	if status.Ahead() {
+		cmd.Println("The schema is newer than this binary.")
+		return
+	}
+	if !status.Behind() {
+		cmd.Println("The schema is up to date.")
+		return
+	}
+	cmd.Printf("%d pending migrations:\n", len(status.Pending))
+	for _, migration := range status.Pending {
+		cmd.Printf("  %s\n", migration)
+	}
+	os.Exit(1)
+}
+
+// Values of the --migrations flag of the serve command.
+const (
+	migrationsCheck  = "check"
+	migrationsAuto   = "auto"
+	migrationsIgnore = "ignore"
+)
+
+// ensureSchema checks the schema version when the server starts, and applies the pending migrations in the "auto" mode.
+//
+// The server must not start on a schema older than the binary, since queries would fail on missing tables and columns.
+// A newer schema is allowed, with a warning, so that the binary can be rolled back after a migration.
+func ensureSchema(ctx context.Context, mode string) error {
+	if mode == migrationsIgnore {
+		return nil
+	}
+
+	migrator, err := newMigrator()
+	if err != nil {
+		return fmt.Errorf("failed to load the migrations: %w", err)
+	}
+
+	if mode == migrationsAuto {
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			logging.Logger.Info("Applied migration", zap.String("migration", migration.String()))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if status.Dirty {
+		return fmt.Errorf("%w at version %d, fix it by hand and run `nexeres migrate force <version>`", migrate.ErrDirty, status.Version)
+	}
+	if status.Behind() {
+		return fmt.Errorf("the database schema is at version %d, behind version %d of this binary, %d migrations are pending. "+
+			"Run `nexeres migrate up`, or serve with --migrations=auto", status.Version, status.Latest, len(status.Pending))
+	}
+	if status.Ahead() {
+		logging.Logger.Warn("The database schema is newer than this binary", zap.Uint64("version", status.Version), zap.Uint64("latest", status.Latest))
+	}
+	return nil
+}
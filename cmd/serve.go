@@ -12,7 +12,11 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/nbrglm/nexeres/config"
 	"github.com/nbrglm/nexeres/handlers"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/authz"
 	"github.com/nbrglm/nexeres/internal/cache"
+	"github.com/nbrglm/nexeres/internal/hooks"
+	"github.com/nbrglm/nexeres/internal/janitor"
 	"github.com/nbrglm/nexeres/internal/logging"
 	"github.com/nbrglm/nexeres/internal/metrics"
 	"github.com/nbrglm/nexeres/internal/middlewares"
@@ -21,6 +25,7 @@ import (
 	"github.com/nbrglm/nexeres/internal/store"
 	"github.com/nbrglm/nexeres/internal/tokens"
 	"github.com/nbrglm/nexeres/internal/tracing"
+	"github.com/nbrglm/nexeres/internal/webhooks"
 	_ "github.com/nbrglm/nexeres/oapispec"
 	"github.com/nbrglm/nexeres/opts"
 	"github.com/nbrglm/nexeres/utils"
@@ -30,11 +35,18 @@ import (
 	"go.uber.org/zap"
 )
 
+// serveMigrations is the value of the --migrations flag, one of "check", "auto" or "ignore".
+var serveMigrations string
+
 func initServeCommand() {
 	var serveCommand = &cobra.Command{
 		Use:   "serve",
 		Short: "Start the server and listen for incoming requests",
 		Run: func(cmd *cobra.Command, args []string) {
+			if serveMigrations != migrationsCheck && serveMigrations != migrationsAuto && serveMigrations != migrationsIgnore {
+				cmd.PrintErrf("Invalid --migrations value %q, use check, auto or ignore\n", serveMigrations)
+				os.Exit(1)
+			}
 			// Start the server
 			runServer(cmd)
 		},
@@ -42,6 +54,8 @@ func initServeCommand() {
 
 	serveCommand.Flags().StringVar(opts.ConfigPath, "config", "/etc/nbrglm/workspace/nexeres/config.yaml", "Path to the config file")
 	serveCommand.MarkPersistentFlagFilename("config", "yaml", "yml")
+	serveCommand.Flags().StringVar(&serveMigrations, "migrations", migrationsCheck,
+		`What to do when the database schema is behind the binary: "check" refuses to start, "auto" applies the pending migrations (under an advisory lock), "ignore" starts anyway`)
 
 	rootCmd.AddCommand(serveCommand)
 }
@@ -69,7 +83,7 @@ func runServer(cmd *cobra.Command) {
 	engine := gin.Default()
 	if opts.Debug {
 		gin.SetMode(gin.DebugMode)
-		logging.Logger.Warn("Debug mode is enabled! This is not recommended for production environments. Use with caution. The following behaviour is used.", zap.String("Debug Mode", "Enabled"), zap.String("API Docs", fmt.Sprintf("%s/docs", config.Public.GetBaseURL())), zap.String("CSRF Protection", "Disabled"))
+		logging.Logger.Warn("Debug mode is enabled! This is not recommended for production environments. Use with caution. The following behaviour is used.", zap.String("Debug Mode", "Enabled"), zap.String("API Docs", fmt.Sprintf("%s/docs", config.Public.GetBaseURL())), zap.String("CSRF Protection", "Disabled"), zap.String("Mailbox", fmt.Sprintf("%s/debug/mailbox", config.Public.GetBaseURL())))
 		// Setup docs
 		engine.GET("/docs", func(ctx *gin.Context) {
 			ctx.Header("Content-Type", "text/html")
@@ -91,6 +105,8 @@ func runServer(cmd *cobra.Command) {
 	</html>`)
 		})
 		engine.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+		// Serve the emails of the file and memory email providers, before the API key middleware, so that they can be read in a browser.
+		handlers.NewDebugMailboxHandler().Register(engine)
 	} else {
 		gin.SetMode(gin.ReleaseMode)
 	}
@@ -98,10 +114,31 @@ func runServer(cmd *cobra.Command) {
 	// Add CORS middleware
 	middlewares.InitCORS(engine)
 
-	// Add the API Key middleware, before rate limiting middlewares,
-	// since those need access to keys for rate limiting
+	// Serve the objects of the filesystem object store, before the API Key middleware, so that their URLs can be opened in a browser.
+	if config.Stores.Objects.Backend == "filesystem" {
+		handlers.NewObjectsHandler().Register(engine)
+	}
+
+	// Add the API Key middleware, before the rate limiting middleware,
+	// since rate limit policies can count requests per API key.
 	engine.Use(middlewares.APIKeyMiddleware())
 
+	// Resolve the tenant from the Host header, after the API Key middleware,
+	// since it rejects session tokens of other tenants.
+	if config.Public.TenantSubdomains {
+		engine.Use(middlewares.TenantMiddleware())
+	}
+
+	// Connect to Redis, if it is the cache backend, before the rate limiter and the cache, which share the client.
+	if err := store.InitRedis(); err != nil {
+		logging.Logger.Error("Failed to initialize Redis client", zap.Error(err))
+		logging.ShutdownLogger(context.Background())
+		os.Exit(1)
+	}
+	if config.Stores.Cache.Backend == "memory" {
+		logging.Logger.Warn("Using the in-memory cache backend. Flows, admin sessions and rate limits are lost on restart and not shared between instances, only use it for single instance deployments.")
+	}
+
 	// Initialize the rate limiter, before adding the handler routes.
 	if err := middlewares.InitRateLimitStore(); err != nil {
 		logging.Logger.Error("Failed to initialize rate limit store", zap.Error(err))
@@ -110,7 +147,7 @@ func runServer(cmd *cobra.Command) {
 	}
 
 	// Add the rate limit middleware AFTER the API Key middleware,
-	// since it needs access to the API key to apply rate limits.
+	// since it needs access to the API key to apply per key rate limits.
 	engine.Use(middlewares.RateLimitMiddleware())
 
 	// Register the routes
@@ -123,6 +160,17 @@ func runServer(cmd *cobra.Command) {
 		})
 	})
 
+	// Initialize the audit logs, webhooks, hooks, notification queue and janitor, before the metrics, since they register their own collectors.
+	if err := audit.InitAudit(); err != nil {
+		logging.Logger.Error("Failed to initialize audit logs", zap.Error(err))
+		logging.ShutdownLogger(context.Background())
+		os.Exit(1)
+	}
+	webhooks.InitWebhooks()
+	hooks.InitHooks()
+	notifications.InitQueue()
+	janitor.InitJanitor()
+
 	// Initialize the metrics collection system
 	//
 	// NOTE: Always do this after registering the API routes.
@@ -177,6 +225,15 @@ func runServer(cmd *cobra.Command) {
 		os.Exit(1)
 	}
 
+	// Compile the authorization schema
+	if config.Authz.Enable {
+		if err := authz.InitAuthz(); err != nil {
+			logging.Logger.Error("Failed to initialize authorization schema", zap.Error(err))
+			logging.ShutdownLogger(context.Background())
+			os.Exit(1)
+		}
+	}
+
 	// Connect with the database
 	if err := store.InitDB(context.Background()); err != nil {
 		logging.Logger.Error("Failed to initialize database connection pool", zap.Error(err))
@@ -184,12 +241,31 @@ func runServer(cmd *cobra.Command) {
 		os.Exit(1)
 	}
 
-	// Initialize the s3 store
-	if err := store.InitS3Store(context.Background()); err != nil {
-		logging.Logger.Error("Failed to initialize S3 store", zap.Error(err))
+	// Check the schema version, or apply the pending migrations, before anything uses the database.
+	if err := ensureSchema(context.Background(), serveMigrations); err != nil {
+		logging.Logger.Error("The database schema is not ready", zap.Error(err))
+		logging.ShutdownLogger(context.Background())
+		os.Exit(1)
+	}
+
+	// Start delivering the webhooks in the outbox
+	webhooks.StartDispatcher()
+
+	// Start sending the queued notifications
+	notifications.StartWorkers()
+
+	// Initialize the object store
+	if err := store.InitObjectStore(context.Background()); err != nil {
+		logging.Logger.Error("Failed to initialize object store", zap.Error(err))
 		logging.ShutdownLogger(context.Background())
 		os.Exit(1)
 	}
+	if config.Stores.Objects.Backend == "memory" {
+		logging.Logger.Warn("Using the in-memory objects backend. Avatars, data exports and custom templates are lost on restart and their URLs are not served, only use it for tests.")
+	}
+
+	// Start purging the expired and soft deleted rows, after the object store is initialized, since the erasures delete objects
+	janitor.Start()
 
 	// Start the server
 	serverAddress := fmt.Sprintf("%s:%s", config.Server.Host, config.Server.Port)
@@ -214,11 +290,48 @@ func runServer(cmd *cobra.Command) {
 
 	logging.Logger.Info("Received shutdown signal, shutting down server gracefully...")
 
+	// Finish the webhook deliveries in progress before closing the database connection pool
+	logging.Logger.Info("Stopping webhook dispatcher")
+	webhooksCtx, webhooksCancel := context.WithTimeout(context.Background(), time.Second*15)
+	if err := webhooks.Shutdown(webhooksCtx); err != nil {
+		logging.Logger.Error("Failed to stop webhook dispatcher", zap.Error(err))
+	}
+	webhooksCancel()
+
+	// Finish the notifications being sent before closing the database connection pool
+	logging.Logger.Info("Stopping notification workers")
+	notificationsCtx, notificationsCancel := context.WithTimeout(context.Background(), time.Second*15)
+	if err := notifications.Shutdown(notificationsCtx); err != nil {
+		logging.Logger.Error("Failed to stop notification workers", zap.Error(err))
+	}
+	notificationsCancel()
+
+	// Finish the batch being purged before closing the database connection pool
+	logging.Logger.Info("Stopping janitor")
+	janitorCtx, janitorCancel := context.WithTimeout(context.Background(), time.Second*35)
+	if err := janitor.Shutdown(janitorCtx); err != nil {
+		logging.Logger.Error("Failed to stop janitor", zap.Error(err))
+	}
+	janitorCancel()
+
+	// Write the queued audit events before closing the database connection pool
+	logging.Logger.Info("Writing queued audit events")
+	auditCtx, auditCancel := context.WithTimeout(context.Background(), time.Second*10)
+	if err := audit.Shutdown(auditCtx); err != nil {
+		logging.Logger.Error("Failed to write queued audit events", zap.Error(err))
+	}
+	auditCancel()
+
 	logging.Logger.Info("Closing database connection pool")
 	if err := store.CloseDB(); err != nil {
 		logging.Logger.Error("Failed to close database connection pool", zap.Error(err))
 	}
 
+	logging.Logger.Info("Closing Redis client")
+	if err := store.CloseRedis(); err != nil {
+		logging.Logger.Error("Failed to close Redis client", zap.Error(err))
+	}
+
 	logging.Logger.Info("Shutting down OTEL tracer provider")
 	if err := tracing.ShutdownTracer(context.Background()); err != nil {
 		logging.Logger.Error("Failed to shutdown OTEL tracer provider", zap.Error(err))
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/nbrglm/nexeres/internal/notifications/templates"
+	"github.com/spf13/cobra"
+)
+
+var (
+	templatesLintEmailDir      string
+	templatesLintSMSDir        string
+	templatesLintDefaultLocale string
+)
+
+func initTemplatesCommand() {
+	templatesCmd := &cobra.Command{
+		Use:   "templates",
+		Short: "Manage the notification templates.",
+		Long:  "Manage the notification templates. Check custom template directories before deploying them.",
+	}
+
+	lintCmd := &cobra.Command{
+		Use:   "lint",
+		Short: "Check the notification templates.",
+		Long: "Check the email and SMS templates of the given directories, or the built-in templates if not set. " +
+			"Reports missing translations, templates missing in the default locale, and templates which fail to parse or render. " +
+			"Exits with status 1 if any issue is found.",
+		Run: func(cmd *cobra.Command, args []string) {
+			templatesLint(cmd)
+		},
+	}
+	lintCmd.Flags().StringVar(&templatesLintEmailDir, "email-dir", "", "Directory of the email templates, the notifications.email.templatesDir config")
+	lintCmd.Flags().StringVar(&templatesLintSMSDir, "sms-dir", "", "Directory of the SMS templates, the notifications.sms.templatesDir config")
+	lintCmd.Flags().StringVar(&templatesLintDefaultLocale, "default-locale", "en", "The default locale, the notifications.defaultLocale config")
+	lintCmd.MarkFlagDirname("email-dir")
+	lintCmd.MarkFlagDirname("sms-dir")
+
+	templatesCmd.AddCommand(lintCmd)
+	rootCmd.AddCommand(templatesCmd)
+}
+
+func templatesLint(cmd *cobra.Command) {
+	defaultLocale, err := templates.NormalizeLocale(templatesLintDefaultLocale)
+	if err != nil {
+		cmd.PrintErrf("Invalid default locale: %v\n", err)
+		os.Exit(1)
+	}
+
+	var emailDir, smsDir *string
+	if templatesLintEmailDir != "" {
+		emailDir = &templatesLintEmailDir
+	}
+	if templatesLintSMSDir != "" {
+		smsDir = &templatesLintSMSDir
+	}
+
+	issues, err := templates.Lint(emailDir, smsDir, defaultLocale)
+	if err != nil {
+		cmd.PrintErrf("Error checking the templates: %v\n", err)
+		os.Exit(1)
+	}
+	if len(issues) == 0 {
+		cmd.Println("No issues found.")
+		return
+	}
+
+	for _, issue := range issues {
+		cmd.Println(issue.String())
+	}
+	cmd.PrintErrf("%d issues found!\n", len(issues))
+	os.Exit(1)
+}
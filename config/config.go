@@ -7,6 +7,7 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"slices"
@@ -39,6 +40,10 @@ var (
 	Branding      *BrandingConfig
 	Security      *SecurityConfig
 	Stores        *StoresConfig
+	Authz         *AuthzConfig
+	Webhooks      *WebhooksConfig
+	Hooks         *HooksConfig
+	Janitor       *JanitorConfig
 
 	// Admins is a list of credentials for admin users
 	Admins AdminConfig
@@ -99,6 +104,18 @@ type PublicConfig struct {
 	SubDomain string `json:"subDomain" yaml:"subDomain" validate:"required"`
 
 	DebugBaseURL string `json:"debugBaseURL,omitempty" yaml:"debugBaseURL,omitempty" validate:"omitempty,url"`
+
+	// TenantSubdomains enables tenant resolution from the Host header, requires multitenancy.
+	//
+	// Requests to "{org-slug}.{subdomain}.{domain}" are restricted to that organization,
+	// and tokens of every organization are issued by its tenant base URL.
+	// This requires a wildcard DNS record (and certificate) for "*.{subdomain}.{domain}".
+	TenantSubdomains bool `json:"tenantSubdomains" yaml:"tenantSubdomains"`
+
+	// TrustForwardedHost uses the X-Forwarded-Host header, instead of Host, to resolve the tenant.
+	//
+	// Only enable this if Nexeres is behind a reverse proxy which sets the header.
+	TrustForwardedHost bool `json:"trustForwardedHost" yaml:"trustForwardedHost"`
 }
 
 func (p *PublicConfig) GetBaseURL() string {
@@ -109,7 +126,29 @@ func (p *PublicConfig) GetBaseURL() string {
 }
 
 func (p *PublicConfig) GetTenantBaseURL(tenant string) string {
-	return fmt.Sprintf("%s://%s.%s.%s", p.Scheme, tenant, p.SubDomain, p.Domain)
+	return fmt.Sprintf("%s://%s", p.Scheme, p.GetTenantHost(tenant))
+}
+
+// GetTenantHost returns the host of the given tenant, eg. "acme.auth.example.com".
+//
+// This is also the cookie domain for the tenant, so that cookies are not shared with other tenants.
+func (p *PublicConfig) GetTenantHost(tenant string) string {
+	return fmt.Sprintf("%s.%s.%s", tenant, p.SubDomain, p.Domain)
+}
+
+// GetTenantFromHost returns the tenant (org slug) for the given host, which may include a port.
+//
+// The second return value is false if the host is not a tenant host.
+func (p *PublicConfig) GetTenantFromHost(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	tenant, ok := strings.CutSuffix(host, "."+strings.ToLower(p.SubDomain)+"."+strings.ToLower(p.Domain))
+	if !ok || tenant == "" || strings.Contains(tenant, ".") {
+		return "", false
+	}
+	return tenant, true
 }
 
 // ServerConfig holds the configuration for the server
@@ -204,6 +243,10 @@ type JWTConfig struct {
 	// Path to the public key file for RS256 algorithm
 	PublicKeyFile string `json:"-" yaml:"publicKeyFile" validate:"required,file"`
 
+	// Paths to the public key files of previous signing keys, kept after a key rotation,
+	// so that the audit log checkpoints signed with them can still be verified.
+	RetiredPublicKeyFiles []string `json:"-" yaml:"retiredPublicKeyFiles,omitempty" validate:"omitempty,dive,file"`
+
 	// Audiences claim for the JWT.
 	//
 	// NOTE: This is NOT for OIDC. This is for the session tokens! OIDC configuration is stored in the DB per tenant.
@@ -220,12 +263,49 @@ type NotificationsConfig struct {
 
 	// SMS Configuration for sending notifications
 	SMS *SMSNotificationConfig `json:"sms,omitempty" yaml:"sms,omitempty" validate:"omitempty"`
+
+	// DefaultLocale is the locale notifications are sent in, when neither the user nor their organization has one, default "en".
+	// Every template must exist in this locale.
+	DefaultLocale string `json:"defaultLocale" yaml:"defaultLocale,omitempty" validate:"omitempty,bcp47_language_tag"`
+
+	// Queue configures the queue through which the notifications are sent.
+	Queue NotificationQueueConfig `json:"queue" yaml:"queue,omitempty"`
+}
+
+// NotificationQueueConfig holds the configuration for the notification queue.
+//
+// Notifications are written to the notification_jobs table, and sent by background workers on every instance,
+// with exponential backoff between the attempts, so that they survive provider outages.
+type NotificationQueueConfig struct {
+	// The number of worker goroutines per instance, default 4.
+	Workers int `json:"workers" yaml:"workers" validate:"min=0,max=100"`
+
+	// The time, in milliseconds, between polls of the queue for due jobs, default 1000.
+	PollInterval int `json:"pollInterval" yaml:"pollInterval" validate:"min=0"`
+
+	// The maximum number of jobs claimed by a worker per poll, default 10.
+	BatchSize int `json:"batchSize" yaml:"batchSize" validate:"min=0,max=1000"`
+
+	// The time, in seconds, after which a claimed job that was not completed, eg. because the instance crashed, is sent again, default 120.
+	Lease int `json:"lease" yaml:"lease" validate:"min=0"`
+
+	// The number of attempts after which a job is given up ("dead" status), default 12.
+	MaxAttempts int `json:"maxAttempts" yaml:"maxAttempts" validate:"min=0"`
+
+	// The delay, in seconds, before the first retry, default 10. It doubles after every failed attempt.
+	InitialBackoff int `json:"initialBackoff" yaml:"initialBackoff" validate:"min=0"`
+
+	// The maximum delay, in seconds, between retries, default 3600 (1 hour).
+	MaxBackoff int `json:"maxBackoff" yaml:"maxBackoff" validate:"min=0"`
 }
 
 // EmailNotificationConfig holds the configuration for email notifications.
 type EmailNotificationConfig struct {
 	// Provider is the email provider to use for sending emails.
-	Provider string `json:"provider" yaml:"provider" validate:"required,oneof=ses sendgrid smtp"`
+	//
+	// The "file" and "memory" providers do not send emails, they store them in a mailbox for local development and tests,
+	// which is served at /debug/mailbox in debug mode.
+	Provider string `json:"provider" yaml:"provider" validate:"required,oneof=ses sendgrid smtp file memory"`
 
 	// SendGridConfig holds the configuration for SendGrid email provider.
 	SendGrid *SendGridProviderConfig `json:"-" yaml:"sendgrid,omitempty" validate:"omitempty,required_if=Provider sendgrid"`
@@ -236,11 +316,14 @@ type EmailNotificationConfig struct {
 	// SESConfig holds the configuration for AWS SES email provider.
 	SES *SESProviderConfig `json:"-" yaml:"ses,omitempty" validate:"omitempty,required_if=Provider ses"`
 
+	// Mailbox holds the configuration for the file and memory providers.
+	Mailbox *MailboxProviderConfig `json:"-" yaml:"mailbox,omitempty" validate:"omitempty"`
+
 	// Endpoints holds the configuration for URLs inside emails.
 	Endpoints EmailEndpointsConfig `json:"endpoints" yaml:"endpoints" validate:"required"`
 
 	// TemplatesDir is the directory where email templates are stored.
-	TemplatesDir *string `json:"-" yaml:"templatesDir,omitempty" validate:"omitempty,file"`
+	TemplatesDir *string `json:"-" yaml:"templatesDir,omitempty" validate:"omitempty,dir"`
 }
 
 // EmailEndpointsConfig holds the configuration for URLs inside emails.
@@ -271,7 +354,7 @@ type SendGridProviderConfig struct {
 // SMTPProviderConfig holds the configuration for SMTP email provider.
 //
 // Note: SMTP is a generic email provider that can be used with any SMTP server.
-// IT DOES NOT SUPPORT RETRIES, OR ANY ADVANCED FEATURES.
+// Failed sends are retried by the notification queue, but it does not support any advanced features, eg. bounce tracking.
 // It is recommended to use a more robust provider like SendGrid or AWS SES for production use.
 type SMTPProviderConfig struct {
 	// SMTP server host
@@ -305,13 +388,70 @@ type SESProviderConfig struct {
 	FromName *string `json:"-" yaml:"fromName,omitempty"`
 }
 
+// MailboxProviderConfig holds the configuration for the file and memory email providers.
+type MailboxProviderConfig struct {
+	// Dir is the directory the file provider stores emails in, one JSON file per email, default "<temp dir>/nexeres-mailbox".
+	// Instances sharing the directory share the mailbox.
+	Dir string `json:"-" yaml:"dir,omitempty"`
+
+	// MaxMessages is the number of emails kept, the oldest are deleted first, default 500.
+	MaxMessages int `json:"-" yaml:"maxMessages,omitempty" validate:"min=0"`
+}
+
 // SMSNotificationConfig holds the configuration for SMS notifications.
 type SMSNotificationConfig struct {
-	// TODO: Implement SMSNotificationConfig
-	Provider string `json:"provider" yaml:"provider" validate:"required,oneof=twilio"`
+	// Provider is the SMS provider to use for sending messages, "twilio" or "http" (a generic HTTP gateway).
+	Provider string `json:"provider" yaml:"provider" validate:"required,oneof=twilio http"`
+
+	// Twilio holds the configuration for the Twilio SMS provider.
+	Twilio *TwilioProviderConfig `json:"-" yaml:"twilio,omitempty" validate:"omitempty,required_if=Provider twilio"`
+
+	// HTTP holds the configuration for the generic HTTP SMS provider.
+	HTTP *HTTPSMSProviderConfig `json:"-" yaml:"http,omitempty" validate:"omitempty,required_if=Provider http"`
 
 	// TemplatesDir is the directory where SMS templates are stored.
-	TemplatesDir *string `json:"-" yaml:"templatesDir,omitempty" validate:"omitempty,file"`
+	TemplatesDir *string `json:"-" yaml:"templatesDir,omitempty" validate:"omitempty,dir"`
+}
+
+// TwilioProviderConfig holds the configuration for the Twilio SMS provider.
+type TwilioProviderConfig struct {
+	// Account SID of the Twilio account
+	AccountSID string `json:"-" yaml:"accountSID" validate:"required"`
+
+	// Auth token of the Twilio account
+	AuthToken string `json:"-" yaml:"authToken" validate:"required"`
+
+	// Phone number from which messages are sent, in E.164 format, eg. +14155552671.
+	// Either this or MessagingServiceSID is required.
+	FromNumber string `json:"-" yaml:"fromNumber,omitempty" validate:"required_without=MessagingServiceSID,omitempty,e164"`
+
+	// SID of the messaging service from which messages are sent, used instead of FromNumber.
+	MessagingServiceSID string `json:"-" yaml:"messagingServiceSID,omitempty"`
+
+	// BaseURL of the Twilio API, default https://api.twilio.com. Only change this for testing.
+	BaseURL string `json:"-" yaml:"baseURL,omitempty" validate:"omitempty,url"`
+}
+
+// HTTPSMSProviderConfig holds the configuration for the generic HTTP SMS provider.
+//
+// Messages are POSTed as JSON, {"to": "+14155552671", "from": "...", "message": "..."}, to the URL, and any response
+// other than 2xx is a failure. If a secret is set, requests are signed like the webhook deliveries, with the
+// X-Nexeres-SMS-Timestamp and X-Nexeres-SMS-Signature headers.
+type HTTPSMSProviderConfig struct {
+	// URL of the gateway.
+	URL string `json:"-" yaml:"url" validate:"required,url"`
+
+	// Sender of the messages, eg. a phone number or an alphanumeric sender ID, passed as "from".
+	From string `json:"-" yaml:"from,omitempty"`
+
+	// Headers added to every request, eg. an Authorization header.
+	Headers map[string]string `json:"-" yaml:"headers,omitempty"`
+
+	// Secret to sign the requests with, optional.
+	Secret string `json:"-" yaml:"secret,omitempty" validate:"omitempty,min=32"`
+
+	// The timeout, in milliseconds, of a request, default 10000.
+	Timeout int `json:"-" yaml:"timeout" validate:"min=0"`
 }
 
 // BrandingConfig holds the configuration for branding elements such as names.
@@ -327,6 +467,12 @@ type BrandingConfig struct {
 
 	// SupportURL is the URL for support, used in emails, UI, etc.
 	SupportURL string `json:"supportURL" yaml:"supportURL" validate:"required,url"`
+
+	// LogoURL is the URL of the logo shown in emails. Optional.
+	LogoURL string `json:"logoURL,omitempty" yaml:"logoURL,omitempty" validate:"omitempty,url"`
+
+	// PrimaryColor is the colour of buttons and links in emails, as a hex colour. (Default #306dd6)
+	PrimaryColor string `json:"primaryColor" yaml:"primaryColor,omitempty" validate:"omitempty,hexcolor"`
 }
 
 // SecurityConfig holds the security-related configurations for the application.
@@ -334,8 +480,9 @@ type SecurityConfig struct {
 	// Enable or disable audit logs.
 	AuditLogs AuditLogsConfig `json:"auditLogs" yaml:"auditLogs" validate:"required"`
 
-	// The list of API Keys which are allowed to access the API endpoints.
-	// Requests without an API key, or with a key not specified here, will be denied with 401.
+	// The list of bootstrap API Keys which are allowed to access the API endpoints, with every scope.
+	// Managed keys, with scopes, organization binding, expiry and rotation, are created in the database through /api/admin/api-keys.
+	// Requests without an API key, or with a key not specified here or in the database, will be denied with 401.
 	APIKeys []APIKeyConfig `json:"apiKeys" yaml:"apiKeys" validate:"required,dive"`
 
 	// CORS configuration for the application.
@@ -343,11 +490,137 @@ type SecurityConfig struct {
 
 	// Rate limiting configuration.
 	RateLimit RateLimitConfig `json:"rateLimit" yaml:"rateLimit" validate:"required"`
+
+	// Data export and erasure of users, on their request.
+	Privacy PrivacyConfig `json:"privacy" yaml:"privacy,omitempty"`
+}
+
+// PrivacyConfig holds the configuration for the exports and erasures of user data.
+type PrivacyConfig struct {
+	// The time, in seconds, the pre-signed URL of a data export is valid for, default 3600 (1 hour).
+	ExportURLExpiry int `json:"exportUrlExpiry" yaml:"exportUrlExpiry" validate:"min=0,max=604800"`
+
+	// The time, in seconds, a data export archive is kept in the object store, default 86400 (1 day).
+	// Expired archives are deleted by the janitor.
+	ExportRetention int `json:"exportRetention" yaml:"exportRetention" validate:"min=0"`
+
+	// The time, in seconds, between the request of an erasure and the erasure of the user's data, default 604800 (7 days).
+	// The erasure can be cancelled until then, after which it is done by the janitor.
+	ErasureGracePeriod int `json:"erasureGracePeriod" yaml:"erasureGracePeriod" validate:"min=0"`
 }
 
 type AuditLogsConfig struct {
 	// Enable or disable audit logs.
 	Enable bool `json:"enable" yaml:"enable"`
+
+	// The maximum number of events waiting to be written, default 10000.
+	QueueSize int `json:"queueSize" yaml:"queueSize" validate:"min=0"`
+
+	// The maximum number of events written in a single batch, default 100.
+	BatchSize int `json:"batchSize" yaml:"batchSize" validate:"min=0"`
+
+	// The time, in milliseconds, after which a partial batch is written, default 1000.
+	FlushInterval int `json:"flushInterval" yaml:"flushInterval" validate:"min=0"`
+
+	// The time, in milliseconds, a request waits for space in a full queue before the event is dropped, default 50.
+	// Set to -1 to drop events immediately when the queue is full.
+	EnqueueTimeout int `json:"enqueueTimeout" yaml:"enqueueTimeout" validate:"min=-1"`
+
+	// The time, in seconds, between signed checkpoints of the audit log hash chains, default 3600.
+	// Chains without new events since their last checkpoint are skipped.
+	CheckpointInterval int `json:"checkpointInterval" yaml:"checkpointInterval" validate:"min=0"`
+
+	// Secret used to hash, with HMAC-SHA256, the email addresses of unknown users in the audit logs, eg. of failed logins.
+	// It is required when audit logs are enabled, and must be at least 32 characters long.
+	// Changing it breaks the correlation of the hashes recorded before and after the change.
+	EmailHashSecret string `json:"-" yaml:"emailHashSecret,omitempty" validate:"required_if=Enable true,omitempty,min=32"`
+
+	// Sinks are additional destinations of the audit events, eg. a SIEM, beside the audit_logs table.
+	Sinks []AuditSinkConfig `json:"sinks,omitempty" yaml:"sinks,omitempty" validate:"omitempty,unique=Name,dive"`
+}
+
+// AuditSinkConfig holds the configuration of an audit sink.
+type AuditSinkConfig struct {
+	// Name of the sink, eg. "siem", used in metrics and as the name of its buffer file.
+	Name string `json:"name" yaml:"name" validate:"required,hostname_rfc1123"`
+
+	// Type of the sink, one of "syslog" or "file".
+	Type string `json:"type" yaml:"type" validate:"required,oneof=syslog file"`
+
+	// Format of the events, "json" (default) or "cef" (ArcSight Common Event Format).
+	// Syslog sinks wrap the formatted event in an RFC 5424 message.
+	Format string `json:"format" yaml:"format" validate:"omitempty,oneof=json cef"`
+
+	// Actions to send, eg. "auth.login.*", matched with path.Match. All actions if empty.
+	Actions []string `json:"actions,omitempty" yaml:"actions,omitempty"`
+
+	// ExcludeActions are actions not to send, eg. "admin.config.read", matched after Actions.
+	ExcludeActions []string `json:"excludeActions,omitempty" yaml:"excludeActions,omitempty"`
+
+	// Syslog holds the configuration of "syslog" sinks.
+	Syslog *AuditSyslogConfig `json:"syslog,omitempty" yaml:"syslog,omitempty" validate:"required_if=Type syslog"`
+
+	// File holds the configuration of "file" sinks.
+	File *AuditFileConfig `json:"file,omitempty" yaml:"file,omitempty" validate:"required_if=Type file"`
+
+	// The maximum number of events waiting to be sent, default 1000. Events are dropped when it is full.
+	QueueSize int `json:"queueSize" yaml:"queueSize" validate:"min=0"`
+
+	// The time, in seconds, between attempts to send the buffered events while the sink is failing, default 10.
+	RetryInterval int `json:"retryInterval" yaml:"retryInterval" validate:"min=0"`
+
+	// BufferDir is the directory of the disk buffer, where events are kept while the sink is failing, so that they
+	// survive restarts. If empty, events which cannot be sent are dropped.
+	BufferDir string `json:"bufferDir,omitempty" yaml:"bufferDir,omitempty" validate:"omitempty,dirpath"`
+
+	// The maximum size, in MB, of the disk buffer, default 100. Events are dropped when it is full.
+	BufferMaxSize int `json:"bufferMaxSize" yaml:"bufferMaxSize" validate:"min=0"`
+}
+
+// AuditSyslogConfig holds the configuration of a syslog audit sink.
+type AuditSyslogConfig struct {
+	// Network is one of "udp", "tcp" or "tls". TCP and TLS messages are framed with octet counting (RFC 6587).
+	Network string `json:"network" yaml:"network" validate:"required,oneof=udp tcp tls"`
+
+	// Address of the syslog server, eg. "siem.example.com:6514".
+	Address string `json:"address" yaml:"address" validate:"required,hostname_port"`
+
+	// AppName is the APP-NAME of the messages, default "nexeres".
+	AppName string `json:"appName" yaml:"appName"`
+
+	// Facility of the messages, default 10 (authpriv).
+	Facility int `json:"facility" yaml:"facility" validate:"min=0,max=23"`
+
+	// TLS holds the TLS options of the "tls" network.
+	TLS *AuditSyslogTLSConfig `json:"tls,omitempty" yaml:"tls,omitempty" validate:"omitempty"`
+}
+
+// AuditSyslogTLSConfig holds the TLS options of a syslog audit sink.
+type AuditSyslogTLSConfig struct {
+	// CAFile is the PEM file of the CAs which sign the server certificate, the system CAs if empty.
+	CAFile string `json:"caFile,omitempty" yaml:"caFile,omitempty" validate:"omitempty,file"`
+
+	// CertFile and KeyFile are the client certificate and key, for servers which require client authentication.
+	CertFile string `json:"certFile,omitempty" yaml:"certFile,omitempty" validate:"omitempty,file,required_with=KeyFile"`
+	KeyFile  string `json:"-" yaml:"keyFile,omitempty" validate:"omitempty,file,required_with=CertFile"`
+
+	// ServerName overrides the name used to verify the server certificate, the host of the address if empty.
+	ServerName string `json:"serverName,omitempty" yaml:"serverName,omitempty"`
+
+	// InsecureSkipVerify disables the verification of the server certificate. Only use it for testing.
+	InsecureSkipVerify bool `json:"insecureSkipVerify" yaml:"insecureSkipVerify"`
+}
+
+// AuditFileConfig holds the configuration of a file audit sink, which writes one event per line.
+type AuditFileConfig struct {
+	// Path of the file, eg. "/var/log/nexeres/audit.jsonl".
+	Path string `json:"path" yaml:"path" validate:"required,filepath"`
+
+	// The size, in MB, after which the file is rotated, default 100.
+	MaxSize int `json:"maxSize" yaml:"maxSize" validate:"min=0"`
+
+	// The number of rotated files kept, as path.1 (newest) to path.N, default 5.
+	MaxBackups int `json:"maxBackups" yaml:"maxBackups" validate:"min=0"`
 }
 
 type APIKeyConfig struct {
@@ -375,20 +648,107 @@ type CORSConfig struct {
 
 // RateLimitConfig holds the configuration for rate limiting the API.
 type RateLimitConfig struct {
-	// Rate limit for API requests.
+	// Rate limit for all API requests, per client IP address.
 	// Format: "R-U", where R is requests and U is the time unit (s - per second, m - per minute, h - per hour, d - per day)
 	Rate string `json:"rate" yaml:"rate" validate:"required"`
+
+	// Policies are additional rate limits, for route groups, API keys and target emails.
+	// Every policy matching a request must pass, on top of Rate.
+	// Defaults to DefaultRateLimitPolicies if not set, set to an empty list to only use Rate.
+	Policies []RateLimitPolicyConfig `json:"policies" yaml:"policies" validate:"omitempty,unique=Name,dive"`
+}
+
+// RateLimitPolicyConfig is a rate limit for some routes, counted per client IP address, API key or target email.
+type RateLimitPolicyConfig struct {
+	// Name of the policy, unique. Counters are kept per policy.
+	Name string `json:"name" yaml:"name" validate:"required"`
+
+	// Routes the policy applies to, eg. "/api/auth/login".
+	// A route ending with "*" matches every route starting with the rest, eg. "/api/orgs/*".
+	// The policy applies to every route if empty.
+	Routes []string `json:"routes,omitempty" yaml:"routes,omitempty" validate:"omitempty,dive,startswith=/"`
+
+	// By is what requests are counted by:
+	// "ip" (the client IP address), "apiKey" (the API key of the request),
+	// or "email" (the "email" field of the JSON request body, eg. the account a login is attempted for).
+	// Requests without an API key are not limited by an "apiKey" policy, requests without an email share a single "email" limit,
+	// and the bodies over 64 KiB of the requests limited by email are rejected.
+	By string `json:"by" yaml:"by" validate:"required,oneof=ip apiKey email"`
+
+	// Rate limit of the policy, in the same format as RateLimitConfig.Rate.
+	Rate string `json:"rate" yaml:"rate" validate:"required"`
 }
 
-// StoresConfig holds the configuration for the different stores like postgres,redis, s3-like.
+// DefaultRateLimitPolicies are the rate limit policies used when none are configured.
+//
+// Sending verification emails and logging in are limited much more strictly than refreshing tokens,
+// since they are used to flood inboxes and guess passwords.
+var DefaultRateLimitPolicies = []RateLimitPolicyConfig{
+	{Name: "login-ip", Routes: []string{"/api/auth/login", "/api/admin/login", "/api/admin/login/verify"}, By: "ip", Rate: "30-m"},
+	{Name: "login-email", Routes: []string{"/api/auth/login"}, By: "email", Rate: "10-m"},
+	{Name: "admin-login-email", Routes: []string{"/api/admin/login"}, By: "email", Rate: "5-h"},
+	{Name: "signup-ip", Routes: []string{"/api/auth/signup"}, By: "ip", Rate: "20-h"},
+	{Name: "signup-email", Routes: []string{"/api/auth/signup"}, By: "email", Rate: "5-h"},
+	{Name: "verify-email-send-ip", Routes: []string{"/api/auth/verify-email/send"}, By: "ip", Rate: "20-h"},
+	{Name: "verify-email-send-email", Routes: []string{"/api/auth/verify-email/send"}, By: "email", Rate: "3-h"},
+	{Name: "refresh-ip", Routes: []string{"/api/auth/refresh"}, By: "ip", Rate: "300-m"},
+}
+
+// StoresConfig holds the configuration for the different stores like postgres, redis, s3-like.
 type StoresConfig struct {
 	// PostgreSQL configuration
 	PostgreSQL PostgreSQLConfig `json:"-" yaml:"postgres" validate:"required"`
 
-	// Redis configuration
-	Redis RedisConfig `json:"-" yaml:"redis" validate:"required"`
+	// Redis configuration, required when the cache backend is "redis".
+	Redis RedisConfig `json:"-" yaml:"redis"`
+
+	// Cache configuration, the backend of the flows, admin sessions, cached checks and rate limits.
+	Cache CacheConfig `json:"cache" yaml:"cache"`
+
+	// Objects configuration, the backend of the stored files, eg. avatars, data exports and custom email templates.
+	Objects ObjectsConfig `json:"objects" yaml:"objects"`
+
+	// S3 configuration, required when the objects backend is "s3".
+	S3 *S3Config `json:"-" yaml:"s3,omitempty" validate:"omitempty"`
+}
+
+// ObjectsConfig holds the configuration of the object store backend.
+type ObjectsConfig struct {
+	// Backend of the object store, "s3", "filesystem" or "memory". (Default "s3")
+	//
+	// The filesystem backend stores the objects in a local directory, and serves them at /objects,
+	// use it for single instance deployments, or with a directory shared between the instances.
+	// The memory backend keeps the objects in the process, they are lost on restart, use it for tests only.
+	Backend string `json:"backend" yaml:"backend" validate:"required,oneof=s3 filesystem memory"`
 
-	S3 S3Config `json:"-" yaml:"s3" validate:"required"`
+	// Filesystem holds the configuration of the filesystem backend.
+	Filesystem *FilesystemObjectsConfig `json:"-" yaml:"filesystem,omitempty" validate:"omitempty,required_if=Backend filesystem"`
+}
+
+// FilesystemObjectsConfig holds the configuration of the filesystem object store backend.
+type FilesystemObjectsConfig struct {
+	// Dir is the directory the objects are stored in, it is created if it does not exist.
+	Dir string `json:"-" yaml:"dir" validate:"required"`
+
+	// Secret used to sign the URLs of private objects with HMAC-SHA256.
+	// Changing it invalidates the URLs already handed out, eg. the links of the data exports.
+	Secret string `json:"-" yaml:"secret" validate:"required,min=32"`
+
+	// BaseURL the objects are served at, eg. by a CDN in front of Nexeres, default "<public base URL>/objects".
+	BaseURL string `json:"-" yaml:"baseURL,omitempty" validate:"omitempty,url"`
+}
+
+// CacheConfig holds the configuration of the cache backend.
+type CacheConfig struct {
+	// Backend of the cache, "redis" or "memory". (Default "redis")
+	//
+	// The memory backend keeps the data in the process, it is lost on restart and not shared between instances.
+	// Use it for single instance deployments, eg. small internal tools, and tests only.
+	Backend string `json:"backend" yaml:"backend" validate:"required,oneof=redis memory"`
+
+	// MaxEntries is the maximum number of entries of the memory backend, default 100000.
+	// The least recently used entries are evicted when it is full. Rate limit counters are not counted.
+	MaxEntries int `json:"maxEntries" yaml:"maxEntries" validate:"min=0"`
 }
 
 // S3Config holds the configuration for S3-like object storage.
@@ -420,14 +780,276 @@ type PostgreSQLConfig struct {
 
 // RedisConfig holds the configuration for connecting to a Redis database
 type RedisConfig struct {
-	// Address of the Redis server, e.g., "localhost:6379"
-	Address string `json:"-" yaml:"address" validate:"required"`
+	// Mode is "standalone" (a single server), "sentinel" (a master monitored by sentinels, with failover)
+	// or "cluster" (a Redis Cluster). (Default "standalone")
+	Mode string `json:"-" yaml:"mode" validate:"omitempty,oneof=standalone sentinel cluster"`
+
+	// Address of the Redis server, e.g., "localhost:6379", in standalone mode.
+	Address string `json:"-" yaml:"address"`
+
+	// Addresses of the sentinels, in sentinel mode, or of the nodes the cluster is discovered from, in cluster mode.
+	Addresses []string `json:"-" yaml:"addresses,omitempty" validate:"omitempty,dive,hostname_port"`
+
+	// MasterName is the name of the master monitored by the sentinels, required in sentinel mode.
+	MasterName string `json:"-" yaml:"masterName,omitempty"`
+
+	// Username for the Redis server, with ACLs (Redis 6+), if any
+	Username *string `json:"-" yaml:"username,omitempty"`
 
 	// Password for the Redis server, if any
 	Password *string `json:"-" yaml:"password,omitempty"`
 
-	// Database index to use (default is 0)
+	// SentinelUsername and SentinelPassword authenticate with the sentinels, if they require it, in sentinel mode.
+	SentinelUsername *string `json:"-" yaml:"sentinelUsername,omitempty"`
+	SentinelPassword *string `json:"-" yaml:"sentinelPassword,omitempty"`
+
+	// Database index to use (default is 0), must be 0 in cluster mode.
 	DB int `json:"-" yaml:"db" validate:"min=0"`
+
+	// TLS enables TLS for the connections to Redis (and the sentinels), if set.
+	TLS *RedisTLSConfig `json:"-" yaml:"tls,omitempty" validate:"omitempty"`
+
+	// Pool tunes the connection pool, the defaults of go-redis are used for the values not set.
+	Pool RedisPoolConfig `json:"-" yaml:"pool,omitempty"`
+}
+
+// RedisTLSConfig holds the TLS options of the connections to Redis.
+type RedisTLSConfig struct {
+	// CAFile is the PEM file of the CAs which sign the server certificate, the system CAs if empty.
+	CAFile string `json:"-" yaml:"caFile,omitempty" validate:"omitempty,file"`
+
+	// CertFile and KeyFile are the client certificate and key, for servers which require client authentication.
+	CertFile string `json:"-" yaml:"certFile,omitempty" validate:"omitempty,file,required_with=KeyFile"`
+	KeyFile  string `json:"-" yaml:"keyFile,omitempty" validate:"omitempty,file,required_with=CertFile"`
+
+	// ServerName overrides the name used to verify the server certificate, the host of the address if empty.
+	ServerName string `json:"-" yaml:"serverName,omitempty"`
+
+	// InsecureSkipVerify disables the verification of the server certificate. Only use it for testing.
+	InsecureSkipVerify bool `json:"-" yaml:"insecureSkipVerify"`
+}
+
+// RedisPoolConfig tunes the connection pool of the Redis client. Zero values keep the defaults of go-redis.
+type RedisPoolConfig struct {
+	// Size is the maximum number of connections per node, default 10 per CPU.
+	Size int `json:"-" yaml:"size,omitempty" validate:"min=0"`
+
+	// MinIdleConns is the minimum number of idle connections kept open per node.
+	MinIdleConns int `json:"-" yaml:"minIdleConns,omitempty" validate:"min=0"`
+
+	// MaxIdleConns is the maximum number of idle connections kept open per node.
+	MaxIdleConns int `json:"-" yaml:"maxIdleConns,omitempty" validate:"min=0"`
+
+	// ConnMaxIdleTime is the time, in seconds, after which an idle connection is closed, default 1800.
+	ConnMaxIdleTime int `json:"-" yaml:"connMaxIdleTime,omitempty" validate:"min=0"`
+
+	// ConnMaxLifetime is the time, in seconds, after which a connection is closed, connections are reused forever if 0.
+	ConnMaxLifetime int `json:"-" yaml:"connMaxLifetime,omitempty" validate:"min=0"`
+
+	// PoolTimeout is the time, in milliseconds, a command waits for a connection when all are busy, default ReadTimeout + 1 second.
+	PoolTimeout int `json:"-" yaml:"poolTimeout,omitempty" validate:"min=0"`
+
+	// DialTimeout is the time, in milliseconds, to establish a connection, default 5000.
+	DialTimeout int `json:"-" yaml:"dialTimeout,omitempty" validate:"min=0"`
+
+	// ReadTimeout and WriteTimeout are the times, in milliseconds, to read and write a command, default 3000.
+	ReadTimeout  int `json:"-" yaml:"readTimeout,omitempty" validate:"min=0"`
+	WriteTimeout int `json:"-" yaml:"writeTimeout,omitempty" validate:"min=0"`
+
+	// MaxRetries is the maximum number of retries of a failed command, default 3. Set to -1 to disable retries.
+	MaxRetries int `json:"-" yaml:"maxRetries,omitempty" validate:"min=-1"`
+}
+
+// AuthzConfig holds the configuration for the relationship-based authorization API.
+//
+// Relations are stored as tuples in the format `object#relation@subject`, eg. `document:readme#viewer@user:alice`.
+type AuthzConfig struct {
+	// Enable or disable the authorization API endpoints. (Default false)
+	Enable bool `json:"enable" yaml:"enable"`
+
+	// The time, in seconds, for which the result of a check is cached, default 30.
+	// Writing tuples for an org invalidates all its cached checks.
+	// Set to -1 to disable caching.
+	CheckCacheTTL int `json:"checkCacheTTL" yaml:"checkCacheTTL" validate:"min=-1"`
+
+	// The maximum depth of nested relations followed during a check or expand, default 16.
+	MaxDepth int `json:"maxDepth" yaml:"maxDepth" validate:"min=0,max=64"`
+
+	// The maximum number of candidate objects evaluated by a list-objects request, default 1000.
+	ListObjectsLimit int `json:"listObjectsLimit" yaml:"listObjectsLimit" validate:"min=0"`
+
+	// Namespaces is the schema of the object types and their relations.
+	Namespaces []AuthzNamespaceConfig `json:"namespaces" yaml:"namespaces" validate:"required_if=Enable true,dive"`
+}
+
+// AuthzNamespaceConfig defines an object type, eg. "document", and the relations it supports.
+type AuthzNamespaceConfig struct {
+	// The name of the namespace, eg. "document".
+	Name string `json:"name" yaml:"name" validate:"required"`
+
+	// The relations objects of this namespace can have, eg. "owner", "editor", "viewer".
+	Relations []AuthzRelationConfig `json:"relations" yaml:"relations" validate:"required,dive"`
+}
+
+// AuthzRelationConfig defines a relation of a namespace.
+//
+// A subject has the relation if a tuple exists for it directly (or via a userset),
+// or if any of the rules in `union` grant it.
+type AuthzRelationConfig struct {
+	// The name of the relation, eg. "viewer".
+	Name string `json:"name" yaml:"name" validate:"required"`
+
+	// Additional rules that grant this relation.
+	Union []AuthzUsersetRewriteConfig `json:"union,omitempty" yaml:"union,omitempty" validate:"omitempty,dive"`
+}
+
+// AuthzUsersetRewriteConfig is a single rule of a relation's union. Exactly one field must be set.
+type AuthzUsersetRewriteConfig struct {
+	// ComputedUserset grants the relation to everyone having another relation on the same object.
+	//
+	// Eg. for relation "viewer", `computedUserset: editor` means every editor is also a viewer.
+	ComputedUserset string `json:"computedUserset,omitempty" yaml:"computedUserset,omitempty"`
+
+	// TupleToUserset grants the relation to everyone having a relation on a related object.
+	TupleToUserset *AuthzTupleToUsersetConfig `json:"tupleToUserset,omitempty" yaml:"tupleToUserset,omitempty" validate:"omitempty"`
+}
+
+// AuthzTupleToUsersetConfig follows the `tupleset` relation of an object and checks `computedUserset` on the related objects.
+//
+// Eg. for relation "viewer" of "document", `{tupleset: parent, computedUserset: viewer}` means
+// every viewer of the document's parent folder is also a viewer of the document.
+type AuthzTupleToUsersetConfig struct {
+	// The relation pointing to the related objects, eg. "parent".
+	Tupleset string `json:"tupleset" yaml:"tupleset" validate:"required"`
+
+	// The relation to check on the related objects, eg. "viewer".
+	ComputedUserset string `json:"computedUserset" yaml:"computedUserset" validate:"required"`
+}
+
+// WebhooksConfig holds the configuration for the outgoing webhooks of the organizations.
+//
+// Events are written to an outbox in the same transaction as the change that caused them,
+// and delivered by a background dispatcher, with exponential backoff between the attempts.
+type WebhooksConfig struct {
+	// Enable or disable webhooks. (Default false)
+	Enable bool `json:"enable" yaml:"enable"`
+
+	// The time, in milliseconds, between polls of the outbox for due deliveries, default 1000.
+	PollInterval int `json:"pollInterval" yaml:"pollInterval" validate:"min=0"`
+
+	// The maximum number of deliveries sent per poll, default 50.
+	BatchSize int `json:"batchSize" yaml:"batchSize" validate:"min=0,max=1000"`
+
+	// The timeout, in seconds, of a single delivery request, default 10.
+	Timeout int `json:"timeout" yaml:"timeout" validate:"min=0"`
+
+	// The number of attempts after which a delivery is moved to the dead letters, default 10.
+	MaxAttempts int `json:"maxAttempts" yaml:"maxAttempts" validate:"min=0"`
+
+	// The delay, in seconds, before the first retry, default 30. It doubles after every failed attempt.
+	InitialBackoff int `json:"initialBackoff" yaml:"initialBackoff" validate:"min=0"`
+
+	// The maximum delay, in seconds, between retries, default 21600 (6 hours).
+	MaxBackoff int `json:"maxBackoff" yaml:"maxBackoff" validate:"min=0"`
+
+	// AllowHTTP allows endpoint URLs with the http scheme, eg. for local development. (Default false)
+	AllowHTTP bool `json:"allowHTTP" yaml:"allowHTTP"`
+
+	// AllowPrivateNetworks allows endpoints on loopback, link-local and private addresses, eg. for local development. (Default false)
+	// Otherwise, they are rejected when registered and when dialed, so that endpoints cannot reach internal services.
+	AllowPrivateNetworks bool `json:"allowPrivateNetworks" yaml:"allowPrivateNetworks"`
+}
+
+// HooksConfig holds the synchronous HTTP hooks called during logins and token issuance.
+//
+// Hooks of the same kind are called in order. Every request is signed like the webhook deliveries,
+// with the X-Nexeres-Hook-Timestamp and X-Nexeres-Hook-Signature headers.
+type HooksConfig struct {
+	// PreLogin hooks decide whether a login is allowed or denied,
+	// after the credentials are verified and before the session is created.
+	// Requiring MFA from a hook is not supported yet, the "require_mfa" decision fails the hook.
+	PreLogin []HookConfig `json:"preLogin,omitempty" yaml:"preLogin,omitempty" validate:"omitempty,unique=Name,dive"`
+
+	// PreToken hooks return custom claims, which are added to the session token under the hook's namespace,
+	// when tokens are issued by a login or refresh.
+	PreToken []HookConfig `json:"preToken,omitempty" yaml:"preToken,omitempty" validate:"omitempty,unique=Name,dive"`
+}
+
+// HookConfig holds the configuration of a single hook.
+type HookConfig struct {
+	// Name of the hook, eg. "billing", used in metrics and as the default claims namespace.
+	Name string `json:"name" yaml:"name" validate:"required,hostname_rfc1123"`
+
+	// URL the hook requests are POSTed to.
+	URL string `json:"url" yaml:"url" validate:"required,url"`
+
+	// Secret used to sign the hook requests with HMAC-SHA256.
+	Secret string `json:"-" yaml:"secret" validate:"required,min=32"`
+
+	// The timeout, in milliseconds, of a hook request, default 2000.
+	Timeout int `json:"timeout" yaml:"timeout" validate:"min=0"`
+
+	// FailOpen continues the login without the hook's result if the hook fails or times out.
+	// Otherwise (default), the login or refresh fails.
+	FailOpen bool `json:"failOpen" yaml:"failOpen"`
+
+	// The time, in seconds, for which the result of the hook is cached.
+	// Default 0, which disables caching. A result is only reused for an identical request, including the client's IP address and user agent.
+	CacheTTL int `json:"cacheTTL" yaml:"cacheTTL" validate:"min=0"`
+
+	// Namespace of the claims of a pre-token hook, under the "ext" claim, defaults to the name.
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty" validate:"omitempty,hostname_rfc1123"`
+}
+
+// JanitorConfig holds the configuration of the janitor, which purges expired and soft deleted rows from the database.
+//
+// Every instance runs the janitor, but a pass is skipped while another instance holds its advisory lock,
+// so that a single instance purges at a time.
+type JanitorConfig struct {
+	// Enable or disable the janitor. (Default true)
+	Enable *bool `json:"enable" yaml:"enable,omitempty"`
+
+	// The time, in seconds, between passes of the janitor, default 300 (5 minutes).
+	Interval int `json:"interval" yaml:"interval" validate:"min=0"`
+
+	// The maximum number of rows deleted per statement, default 1000.
+	// Rows are deleted in batches, so that a pass does not hold locks on many rows at once.
+	BatchSize int `json:"batchSize" yaml:"batchSize" validate:"min=0,max=100000"`
+
+	// Retention configures how long rows are kept before they are purged.
+	Retention JanitorRetentionConfig `json:"retention" yaml:"retention,omitempty"`
+}
+
+// JanitorRetentionConfig holds the retention windows of the janitor, in seconds.
+//
+// A window of -1 disables the purging of the rows, eg. to keep soft deleted users forever.
+type JanitorRetentionConfig struct {
+	// The time, in seconds, sessions are kept after they expire, default 86400 (1 day).
+	Sessions int `json:"sessions" yaml:"sessions" validate:"min=-1"`
+
+	// The time, in seconds, verification tokens are kept after they expire, default 86400 (1 day).
+	VerificationTokens int `json:"verificationTokens" yaml:"verificationTokens" validate:"min=-1"`
+
+	// The time, in seconds, invitations are kept after they expire, default 604800 (7 days).
+	Invitations int `json:"invitations" yaml:"invitations" validate:"min=-1"`
+
+	// The time, in seconds, OIDC authorization codes, access tokens and refresh tokens are kept after they expire, default 86400 (1 day).
+	OIDC int `json:"oidc" yaml:"oidc" validate:"min=-1"`
+
+	// The time, in seconds, soft deleted users are kept before they are deleted, default 2592000 (30 days).
+	DeletedUsers int `json:"deletedUsers" yaml:"deletedUsers" validate:"min=-1"`
+
+	// The time, in seconds, soft deleted organizations are kept before they are deleted, default 2592000 (30 days).
+	DeletedOrgs int `json:"deletedOrgs" yaml:"deletedOrgs" validate:"min=-1"`
+
+	// The time, in seconds, sent, dead and expired notification jobs are kept after they are created, default 2592000 (30 days).
+	NotificationJobs int `json:"notificationJobs" yaml:"notificationJobs" validate:"min=-1"`
+
+	// The time, in seconds, API keys are kept after they are revoked or expire, default 2592000 (30 days).
+	APIKeys int `json:"apiKeys" yaml:"apiKeys" validate:"min=-1"`
+
+	// The time, in seconds, delivered and dead webhook deliveries are kept after they are created, default 2592000 (30 days).
+	WebhookDeliveries int `json:"webhookDeliveries" yaml:"webhookDeliveries" validate:"min=-1"`
 }
 
 // This represents a temporary struct for configuration extraction from the config file.
@@ -446,6 +1068,10 @@ type CompleteConfig struct {
 	Branding      BrandingConfig      `json:"branding" yaml:"branding" validate:"required"`
 	Security      SecurityConfig      `json:"security" yaml:"security" validate:"required"`
 	Stores        StoresConfig        `json:"-" yaml:"stores" validate:"required"`
+	Authz         AuthzConfig         `json:"authz" yaml:"authz,omitempty"`
+	Webhooks      WebhooksConfig      `json:"webhooks" yaml:"webhooks,omitempty"`
+	Hooks         HooksConfig         `json:"hooks" yaml:"hooks,omitempty"`
+	Janitor       JanitorConfig       `json:"-" yaml:"janitor,omitempty"`
 }
 
 // ConfigError represents an error that occurs during configuration initialization/reinitialization
@@ -510,6 +1136,10 @@ func LoadConfigOptions(configFile string) error {
 	Branding = &Config.Branding
 	Security = &Config.Security
 	Stores = &Config.Stores
+	Authz = &Config.Authz
+	Webhooks = &Config.Webhooks
+	Hooks = &Config.Hooks
+	Janitor = &Config.Janitor
 
 	return nil
 }
@@ -611,6 +1241,10 @@ func setDefaults() error {
 		Config.Public.SubDomain = "auth" // Default subdomain for debug mode
 	}
 
+	if Config.Public.TenantSubdomains && (Config.Multitenancy == nil || !*Config.Multitenancy) {
+		return ConfigError{Message: "Tenant subdomains require multitenancy to be enabled"}
+	}
+
 	if strings.TrimSpace(Config.Public.DebugBaseURL) == "" {
 		if Config.Debug {
 			scheme := "http"
@@ -642,6 +1276,10 @@ func setDefaults() error {
 		}
 	}
 
+	if strings.TrimSpace(Config.Branding.PrimaryColor) == "" {
+		Config.Branding.PrimaryColor = "#306dd6"
+	}
+
 	if strings.TrimSpace(Config.JWT.PrivateKeyFile) == "" {
 		return ConfigError{Message: "RS256 Private Key File cannot be empty"}
 	}
@@ -699,8 +1337,53 @@ func setDefaults() error {
 		return ConfigError{Message: "PostgreSQL DSN cannot be empty"}
 	}
 
-	if strings.TrimSpace(Config.Stores.Redis.Address) == "" {
-		return ConfigError{Message: "Redis address cannot be empty"}
+	if Config.Stores.Cache.Backend == "" {
+		Config.Stores.Cache.Backend = "redis"
+	}
+	if Config.Stores.Cache.MaxEntries == 0 {
+		Config.Stores.Cache.MaxEntries = 100000
+	}
+
+	if Config.Stores.Objects.Backend == "" {
+		Config.Stores.Objects.Backend = "s3"
+	}
+	if Config.Stores.Objects.Backend == "s3" && Config.Stores.S3 == nil {
+		return ConfigError{Message: "S3 configuration is required when the objects backend is \"s3\""}
+	}
+	if Config.Stores.Objects.Backend == "filesystem" {
+		if Config.Stores.Objects.Filesystem == nil {
+			return ConfigError{Message: "Filesystem configuration is required when the objects backend is \"filesystem\""}
+		}
+		if strings.TrimSpace(Config.Stores.Objects.Filesystem.BaseURL) == "" {
+			Config.Stores.Objects.Filesystem.BaseURL = Config.Public.GetBaseURL() + "/objects"
+		}
+		Config.Stores.Objects.Filesystem.BaseURL = strings.TrimSuffix(Config.Stores.Objects.Filesystem.BaseURL, "/")
+	}
+
+	if Config.Stores.Redis.Mode == "" {
+		Config.Stores.Redis.Mode = "standalone"
+	}
+	if Config.Stores.Cache.Backend == "redis" {
+		switch Config.Stores.Redis.Mode {
+		case "standalone":
+			if strings.TrimSpace(Config.Stores.Redis.Address) == "" {
+				return ConfigError{Message: "Redis address cannot be empty"}
+			}
+		case "sentinel":
+			if len(Config.Stores.Redis.Addresses) == 0 {
+				return ConfigError{Message: "Redis sentinel addresses cannot be empty in sentinel mode"}
+			}
+			if strings.TrimSpace(Config.Stores.Redis.MasterName) == "" {
+				return ConfigError{Message: "Redis master name cannot be empty in sentinel mode"}
+			}
+		case "cluster":
+			if len(Config.Stores.Redis.Addresses) == 0 {
+				return ConfigError{Message: "Redis cluster addresses cannot be empty in cluster mode"}
+			}
+			if Config.Stores.Redis.DB != 0 {
+				return ConfigError{Message: "Redis DB index must be 0 in cluster mode"}
+			}
+		}
 	}
 	if Config.Stores.Redis.DB < 0 {
 		return ConfigError{Message: "Redis DB index cannot be negative"}
@@ -708,6 +1391,192 @@ func setDefaults() error {
 	if Config.Stores.Redis.Password != nil && strings.TrimSpace(*Config.Stores.Redis.Password) == "" {
 		return ConfigError{Message: "Redis password cannot be empty if provided"}
 	}
+	if Config.Stores.Redis.Username != nil && strings.TrimSpace(*Config.Stores.Redis.Username) == "" {
+		return ConfigError{Message: "Redis username cannot be empty if provided"}
+	}
+
+	if Config.Security.RateLimit.Policies == nil {
+		Config.Security.RateLimit.Policies = DefaultRateLimitPolicies
+	}
+
+	if Config.Security.AuditLogs.QueueSize == 0 {
+		Config.Security.AuditLogs.QueueSize = 10000
+	}
+	if Config.Security.AuditLogs.BatchSize == 0 {
+		Config.Security.AuditLogs.BatchSize = 100
+	}
+	if Config.Security.AuditLogs.FlushInterval == 0 {
+		Config.Security.AuditLogs.FlushInterval = 1000
+	}
+	if Config.Security.AuditLogs.EnqueueTimeout == 0 {
+		Config.Security.AuditLogs.EnqueueTimeout = 50
+	}
+	if Config.Security.AuditLogs.CheckpointInterval == 0 {
+		Config.Security.AuditLogs.CheckpointInterval = 3600
+	}
+	if Config.Security.Privacy.ExportURLExpiry == 0 {
+		Config.Security.Privacy.ExportURLExpiry = 3600 // 1 hour
+	}
+	if Config.Security.Privacy.ExportRetention == 0 {
+		Config.Security.Privacy.ExportRetention = 86400 // 1 day
+	}
+	if Config.Security.Privacy.ErasureGracePeriod == 0 {
+		Config.Security.Privacy.ErasureGracePeriod = 604800 // 7 days
+	}
+	for i := range Config.Security.AuditLogs.Sinks {
+		sink := &Config.Security.AuditLogs.Sinks[i]
+		if sink.Format == "" {
+			sink.Format = "json"
+		}
+		if sink.QueueSize == 0 {
+			sink.QueueSize = 1000
+		}
+		if sink.RetryInterval == 0 {
+			sink.RetryInterval = 10
+		}
+		if sink.BufferMaxSize == 0 {
+			sink.BufferMaxSize = 100
+		}
+		if sink.Syslog != nil {
+			if sink.Syslog.AppName == "" {
+				sink.Syslog.AppName = "nexeres"
+			}
+			if sink.Syslog.Facility == 0 {
+				sink.Syslog.Facility = 10 // authpriv
+			}
+		}
+		if sink.File != nil {
+			if sink.File.MaxSize == 0 {
+				sink.File.MaxSize = 100
+			}
+			if sink.File.MaxBackups == 0 {
+				sink.File.MaxBackups = 5
+			}
+		}
+	}
+
+	if Config.Authz.CheckCacheTTL == 0 {
+		Config.Authz.CheckCacheTTL = 30 // Default to 30 seconds
+	}
+	if Config.Authz.MaxDepth == 0 {
+		Config.Authz.MaxDepth = 16
+	}
+	if Config.Authz.ListObjectsLimit == 0 {
+		Config.Authz.ListObjectsLimit = 1000
+	}
+
+	if Config.Notifications.DefaultLocale == "" {
+		Config.Notifications.DefaultLocale = "en"
+	}
+	if provider := Config.Notifications.Email.Provider; provider == "file" || provider == "memory" {
+		if Config.Notifications.Email.Mailbox == nil {
+			Config.Notifications.Email.Mailbox = &MailboxProviderConfig{}
+		}
+		if Config.Notifications.Email.Mailbox.Dir == "" {
+			Config.Notifications.Email.Mailbox.Dir = filepath.Join(os.TempDir(), "nexeres-mailbox")
+		}
+		if Config.Notifications.Email.Mailbox.MaxMessages == 0 {
+			Config.Notifications.Email.Mailbox.MaxMessages = 500
+		}
+	}
+	if Config.Notifications.SMS != nil {
+		if Config.Notifications.SMS.Twilio != nil && Config.Notifications.SMS.Twilio.BaseURL == "" {
+			Config.Notifications.SMS.Twilio.BaseURL = "https://api.twilio.com"
+		}
+		if Config.Notifications.SMS.HTTP != nil && Config.Notifications.SMS.HTTP.Timeout == 0 {
+			Config.Notifications.SMS.HTTP.Timeout = 10000
+		}
+	}
+
+	if Config.Notifications.Queue.Workers == 0 {
+		Config.Notifications.Queue.Workers = 4
+	}
+	if Config.Notifications.Queue.PollInterval == 0 {
+		Config.Notifications.Queue.PollInterval = 1000
+	}
+	if Config.Notifications.Queue.BatchSize == 0 {
+		Config.Notifications.Queue.BatchSize = 10
+	}
+	if Config.Notifications.Queue.Lease == 0 {
+		Config.Notifications.Queue.Lease = 120
+	}
+	if Config.Notifications.Queue.MaxAttempts == 0 {
+		Config.Notifications.Queue.MaxAttempts = 12
+	}
+	if Config.Notifications.Queue.InitialBackoff == 0 {
+		Config.Notifications.Queue.InitialBackoff = 10
+	}
+	if Config.Notifications.Queue.MaxBackoff == 0 {
+		Config.Notifications.Queue.MaxBackoff = 3600 // 1 hour
+	}
+
+	if Config.Webhooks.PollInterval == 0 {
+		Config.Webhooks.PollInterval = 1000
+	}
+	if Config.Webhooks.BatchSize == 0 {
+		Config.Webhooks.BatchSize = 50
+	}
+	if Config.Webhooks.Timeout == 0 {
+		Config.Webhooks.Timeout = 10
+	}
+	if Config.Webhooks.MaxAttempts == 0 {
+		Config.Webhooks.MaxAttempts = 10
+	}
+	if Config.Webhooks.InitialBackoff == 0 {
+		Config.Webhooks.InitialBackoff = 30
+	}
+	if Config.Webhooks.MaxBackoff == 0 {
+		Config.Webhooks.MaxBackoff = 21600 // 6 hours
+	}
+
+	if Config.Janitor.Enable == nil {
+		enable := true
+		Config.Janitor.Enable = &enable
+	}
+	if Config.Janitor.Interval == 0 {
+		Config.Janitor.Interval = 300 // 5 minutes
+	}
+	if Config.Janitor.BatchSize == 0 {
+		Config.Janitor.BatchSize = 1000
+	}
+	if Config.Janitor.Retention.Sessions == 0 {
+		Config.Janitor.Retention.Sessions = 86400 // 1 day
+	}
+	if Config.Janitor.Retention.VerificationTokens == 0 {
+		Config.Janitor.Retention.VerificationTokens = 86400 // 1 day
+	}
+	if Config.Janitor.Retention.Invitations == 0 {
+		Config.Janitor.Retention.Invitations = 604800 // 7 days
+	}
+	if Config.Janitor.Retention.OIDC == 0 {
+		Config.Janitor.Retention.OIDC = 86400 // 1 day
+	}
+	if Config.Janitor.Retention.DeletedUsers == 0 {
+		Config.Janitor.Retention.DeletedUsers = 2592000 // 30 days
+	}
+	if Config.Janitor.Retention.DeletedOrgs == 0 {
+		Config.Janitor.Retention.DeletedOrgs = 2592000 // 30 days
+	}
+	if Config.Janitor.Retention.NotificationJobs == 0 {
+		Config.Janitor.Retention.NotificationJobs = 2592000 // 30 days
+	}
+	if Config.Janitor.Retention.APIKeys == 0 {
+		Config.Janitor.Retention.APIKeys = 2592000 // 30 days
+	}
+	if Config.Janitor.Retention.WebhookDeliveries == 0 {
+		Config.Janitor.Retention.WebhookDeliveries = 2592000 // 30 days
+	}
+
+	for _, hooks := range [][]HookConfig{Config.Hooks.PreLogin, Config.Hooks.PreToken} {
+		for i := range hooks {
+			if hooks[i].Timeout == 0 {
+				hooks[i].Timeout = 2000
+			}
+			if hooks[i].Namespace == "" {
+				hooks[i].Namespace = hooks[i].Name
+			}
+		}
+	}
 
 	return nil
 }
@@ -11,9 +11,46 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type ApiKey struct {
+	ID          uuid.UUID          `db:"id" json:"id"`
+	Name        string             `db:"name" json:"name"`
+	Description *string            `db:"description" json:"description"`
+	Prefix      string             `db:"prefix" json:"prefix"`
+	KeyHash     []byte             `db:"key_hash" json:"keyHash"`
+	Scopes      []string           `db:"scopes" json:"scopes"`
+	OrgID       *uuid.UUID         `db:"org_id" json:"orgId"`
+	ExpiresAt   pgtype.Timestamptz `db:"expires_at" json:"expiresAt"`
+	LastUsedAt  pgtype.Timestamptz `db:"last_used_at" json:"lastUsedAt"`
+	RevokedAt   pgtype.Timestamptz `db:"revoked_at" json:"revokedAt"`
+	RotatedTo   *uuid.UUID         `db:"rotated_to" json:"rotatedTo"`
+	CreatedBy   *string            `db:"created_by" json:"createdBy"`
+	CreatedAt   pgtype.Timestamptz `db:"created_at" json:"createdAt"`
+	UpdatedAt   pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
+}
+
+type AuditChainHead struct {
+	ChainID       uuid.UUID          `db:"chain_id" json:"chainId"`
+	Seq           int64              `db:"seq" json:"seq"`
+	Hash          []byte             `db:"hash" json:"hash"`
+	CheckpointSeq int64              `db:"checkpoint_seq" json:"checkpointSeq"`
+	UpdatedAt     pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
+}
+
+type AuditCheckpoint struct {
+	ID           uuid.UUID          `db:"id" json:"id"`
+	ChainID      uuid.UUID          `db:"chain_id" json:"chainId"`
+	Kind         string             `db:"kind" json:"kind"`
+	Seq          int64              `db:"seq" json:"seq"`
+	Hash         []byte             `db:"hash" json:"hash"`
+	DeletedCount int64              `db:"deleted_count" json:"deletedCount"`
+	KeyID        string             `db:"key_id" json:"keyId"`
+	Signature    []byte             `db:"signature" json:"signature"`
+	CreatedAt    pgtype.Timestamptz `db:"created_at" json:"createdAt"`
+}
+
 type AuditLog struct {
 	ID           uuid.UUID          `db:"id" json:"id"`
-	OrgID        uuid.UUID          `db:"org_id" json:"orgId"`
+	OrgID        *uuid.UUID         `db:"org_id" json:"orgId"`
 	UserID       *uuid.UUID         `db:"user_id" json:"userId"`
 	Action       string             `db:"action" json:"action"`
 	ResourceType string             `db:"resource_type" json:"resourceType"`
@@ -22,6 +59,30 @@ type AuditLog struct {
 	UserAgent    *string            `db:"user_agent" json:"userAgent"`
 	Metadata     []byte             `db:"metadata" json:"metadata"`
 	CreatedAt    pgtype.Timestamptz `db:"created_at" json:"createdAt"`
+	ChainID      uuid.UUID          `db:"chain_id" json:"chainId"`
+	Seq          *int64             `db:"seq" json:"seq"`
+	PrevHash     []byte             `db:"prev_hash" json:"prevHash"`
+	Hash         []byte             `db:"hash" json:"hash"`
+}
+
+type AuthzTuple struct {
+	OrgID            uuid.UUID          `db:"org_id" json:"orgId"`
+	ObjectNamespace  string             `db:"object_namespace" json:"objectNamespace"`
+	ObjectID         string             `db:"object_id" json:"objectId"`
+	Relation         string             `db:"relation" json:"relation"`
+	SubjectNamespace string             `db:"subject_namespace" json:"subjectNamespace"`
+	SubjectID        string             `db:"subject_id" json:"subjectId"`
+	SubjectRelation  string             `db:"subject_relation" json:"subjectRelation"`
+	CreatedAt        pgtype.Timestamptz `db:"created_at" json:"createdAt"`
+}
+
+type DataExport struct {
+	ID        uuid.UUID          `db:"id" json:"id"`
+	UserID    uuid.UUID          `db:"user_id" json:"userId"`
+	ObjectKey string             `db:"object_key" json:"objectKey"`
+	Format    string             `db:"format" json:"format"`
+	ExpiresAt pgtype.Timestamptz `db:"expires_at" json:"expiresAt"`
+	CreatedAt pgtype.Timestamptz `db:"created_at" json:"createdAt"`
 }
 
 type Invitation struct {
@@ -49,6 +110,23 @@ type MfaFactor struct {
 	UpdatedAt  pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
 }
 
+type NotificationJob struct {
+	ID             uuid.UUID          `db:"id" json:"id"`
+	IdempotencyKey string             `db:"idempotency_key" json:"idempotencyKey"`
+	Channel        string             `db:"channel" json:"channel"`
+	Kind           string             `db:"kind" json:"kind"`
+	Recipient      string             `db:"recipient" json:"recipient"`
+	Payload        []byte             `db:"payload" json:"payload"`
+	Status         string             `db:"status" json:"status"`
+	Attempts       int32              `db:"attempts" json:"attempts"`
+	NextAttemptAt  pgtype.Timestamptz `db:"next_attempt_at" json:"nextAttemptAt"`
+	ExpiresAt      pgtype.Timestamptz `db:"expires_at" json:"expiresAt"`
+	LastError      *string            `db:"last_error" json:"lastError"`
+	SentAt         pgtype.Timestamptz `db:"sent_at" json:"sentAt"`
+	CreatedAt      pgtype.Timestamptz `db:"created_at" json:"createdAt"`
+	UpdatedAt      pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
+}
+
 type OauthProvider struct {
 	ID           uuid.UUID          `db:"id" json:"id"`
 	OrgID        uuid.UUID          `db:"org_id" json:"orgId"`
@@ -130,6 +208,16 @@ type OrgDomain struct {
 	UpdatedAt       pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
 }
 
+type OrgRole struct {
+	ID          uuid.UUID          `db:"id" json:"id"`
+	OrgID       uuid.UUID          `db:"org_id" json:"orgId"`
+	Name        string             `db:"name" json:"name"`
+	Description *string            `db:"description" json:"description"`
+	Permissions []string           `db:"permissions" json:"permissions"`
+	CreatedAt   pgtype.Timestamptz `db:"created_at" json:"createdAt"`
+	UpdatedAt   pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
+}
+
 type Scope struct {
 	ID          uuid.UUID          `db:"id" json:"id"`
 	Name        string             `db:"name" json:"name"`
@@ -155,17 +243,19 @@ type Session struct {
 }
 
 type User struct {
-	ID            uuid.UUID          `db:"id" json:"id"`
-	Email         string             `db:"email" json:"email"`
-	EmailVerified bool               `db:"email_verified" json:"emailVerified"`
-	PasswordHash  *string            `db:"password_hash" json:"passwordHash"`
-	BackupCodes   []string           `db:"backup_codes" json:"backupCodes"`
-	FirstName     *string            `db:"first_name" json:"firstName"`
-	LastName      *string            `db:"last_name" json:"lastName"`
-	AvatarUrl     *string            `db:"avatar_url" json:"avatarUrl"`
-	CreatedAt     pgtype.Timestamptz `db:"created_at" json:"createdAt"`
-	UpdatedAt     pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
-	DeletedAt     pgtype.Timestamptz `db:"deleted_at" json:"deletedAt"`
+	ID                 uuid.UUID          `db:"id" json:"id"`
+	Email              string             `db:"email" json:"email"`
+	EmailVerified      bool               `db:"email_verified" json:"emailVerified"`
+	PasswordHash       *string            `db:"password_hash" json:"passwordHash"`
+	BackupCodes        []string           `db:"backup_codes" json:"backupCodes"`
+	FirstName          *string            `db:"first_name" json:"firstName"`
+	LastName           *string            `db:"last_name" json:"lastName"`
+	AvatarUrl          *string            `db:"avatar_url" json:"avatarUrl"`
+	CreatedAt          pgtype.Timestamptz `db:"created_at" json:"createdAt"`
+	UpdatedAt          pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
+	DeletedAt          pgtype.Timestamptz `db:"deleted_at" json:"deletedAt"`
+	Locale             *string            `db:"locale" json:"locale"`
+	ErasureScheduledAt pgtype.Timestamptz `db:"erasure_scheduled_at" json:"erasureScheduledAt"`
 }
 
 type UserOauthIdentity struct {
@@ -195,4 +285,34 @@ type VerificationToken struct {
 	TokenHash []byte             `db:"token_hash" json:"tokenHash"`
 	ExpiresAt pgtype.Timestamptz `db:"expires_at" json:"expiresAt"`
 	CreatedAt pgtype.Timestamptz `db:"created_at" json:"createdAt"`
+	NewEmail  *string            `db:"new_email" json:"newEmail"`
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID          `db:"id" json:"id"`
+	EndpointID     uuid.UUID          `db:"endpoint_id" json:"endpointId"`
+	OrgID          uuid.UUID          `db:"org_id" json:"orgId"`
+	EventID        uuid.UUID          `db:"event_id" json:"eventId"`
+	EventType      string             `db:"event_type" json:"eventType"`
+	Payload        []byte             `db:"payload" json:"payload"`
+	Status         string             `db:"status" json:"status"`
+	Attempts       int32              `db:"attempts" json:"attempts"`
+	NextAttemptAt  pgtype.Timestamptz `db:"next_attempt_at" json:"nextAttemptAt"`
+	LastError      *string            `db:"last_error" json:"lastError"`
+	LastStatusCode *int32             `db:"last_status_code" json:"lastStatusCode"`
+	DeliveredAt    pgtype.Timestamptz `db:"delivered_at" json:"deliveredAt"`
+	CreatedAt      pgtype.Timestamptz `db:"created_at" json:"createdAt"`
+	UpdatedAt      pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
+}
+
+type WebhookEndpoint struct {
+	ID          uuid.UUID          `db:"id" json:"id"`
+	OrgID       uuid.UUID          `db:"org_id" json:"orgId"`
+	Url         string             `db:"url" json:"url"`
+	Description *string            `db:"description" json:"description"`
+	EventTypes  []string           `db:"event_types" json:"eventTypes"`
+	Secret      string             `db:"secret" json:"secret"`
+	Enabled     bool               `db:"enabled" json:"enabled"`
+	CreatedAt   pgtype.Timestamptz `db:"created_at" json:"createdAt"`
+	UpdatedAt   pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
 }
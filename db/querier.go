@@ -8,28 +8,75 @@ import (
 	"context"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type Querier interface {
 	AddDomainToOrg(ctx context.Context, arg AddDomainToOrgParams) (OrgDomain, error)
 	BanUserFromOrg(ctx context.Context, arg BanUserFromOrgParams) error
+	CancelUserErasure(ctx context.Context, id uuid.UUID) (int64, error)
+	// Changes the email address of the user to a verified address.
+	ChangeUserEmail(ctx context.Context, arg ChangeUserEmailParams) error
+	// Claims the due jobs, by moving their next attempt past the lease, so that other workers skip them while they are sent.
+	ClaimNotificationJobs(ctx context.Context, arg ClaimNotificationJobsParams) ([]ClaimNotificationJobsRow, error)
+	// Claims the due deliveries, by moving their next attempt past the lease, so that other instances skip them while they are sent.
+	ClaimWebhookDeliveries(ctx context.Context, arg ClaimWebhookDeliveriesParams) ([]ClaimWebhookDeliveriesRow, error)
+	// Counts the jobs which are waiting to be sent, or need attention.
+	CountNotificationJobsByStatus(ctx context.Context) ([]CountNotificationJobsByStatusRow, error)
+	CountOrgMembersWithRole(ctx context.Context, arg CountOrgMembersWithRoleParams) (int64, error)
+	CreateAPIKey(ctx context.Context, arg CreateAPIKeyParams) (ApiKey, error)
+	CreateAuditCheckpoint(ctx context.Context, arg CreateAuditCheckpointParams) (AuditCheckpoint, error)
+	CreateAuditLogs(ctx context.Context, arg []CreateAuditLogsParams) (int64, error)
+	CreateDataExport(ctx context.Context, arg CreateDataExportParams) (DataExport, error)
 	CreateInvitation(ctx context.Context, arg CreateInvitationParams) (Invitation, error)
+	// Enqueues a notification, unless a job with the same idempotency key exists, in which case no row is affected.
+	CreateNotificationJob(ctx context.Context, arg CreateNotificationJobParams) (int64, error)
 	CreateOrg(ctx context.Context, arg CreateOrgParams) (Org, error)
+	CreateOrgRole(ctx context.Context, arg CreateOrgRoleParams) (OrgRole, error)
 	CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error)
 	CreateUser(ctx context.Context, arg CreateUserParams) (CreateUserRow, error)
+	CreateWebhookDelivery(ctx context.Context, arg CreateWebhookDeliveryParams) error
+	CreateWebhookEndpoint(ctx context.Context, arg CreateWebhookEndpointParams) (WebhookEndpoint, error)
+	DeleteAuditChainLogs(ctx context.Context, arg DeleteAuditChainLogsParams) (int64, error)
+	DeleteAuthzTuple(ctx context.Context, arg DeleteAuthzTupleParams) error
+	DeleteDataExport(ctx context.Context, id uuid.UUID) error
+	// Deletes the invitations of every org sent to the email address.
+	DeleteInvitationsByEmail(ctx context.Context, email string) error
+	DeleteNotificationJobsByRecipient(ctx context.Context, recipient string) error
+	DeleteOrgRole(ctx context.Context, arg DeleteOrgRoleParams) error
 	DeleteSession(ctx context.Context, id uuid.UUID) error
-	DeleteSessionByRefreshToken(ctx context.Context, refreshTokenHash string) error
-	DeleteSessionByToken(ctx context.Context, tokenHash string) error
+	DeleteSessionByRefreshToken(ctx context.Context, refreshTokenHash string) ([]DeleteSessionByRefreshTokenRow, error)
+	DeleteSessionByToken(ctx context.Context, tokenHash string) ([]DeleteSessionByTokenRow, error)
+	// Deletes the entries written before the audit logs were chained, created before the given time.
+	DeleteUnchainedAuditLogs(ctx context.Context, before pgtype.Timestamptz) (int64, error)
+	// Deletes the user, with their memberships, sessions, tokens, identities and MFA factors.
+	DeleteUser(ctx context.Context, id uuid.UUID) error
+	// Deletes the relation tuples of every org with the user as their subject or object, eg. 'user:<user id>'.
+	DeleteUserAuthzTuples(ctx context.Context, userID string) error
+	// Revokes every session of the user.
+	DeleteUserSessions(ctx context.Context, userID uuid.UUID) ([]DeleteUserSessionsRow, error)
+	// Deletes the verification tokens of the given type of the user.
+	DeleteUserVerificationTokens(ctx context.Context, arg DeleteUserVerificationTokensParams) error
+	DeleteWebhookEndpoint(ctx context.Context, arg DeleteWebhookEndpointParams) (int64, error)
+	EnsureAuditChainHead(ctx context.Context, arg EnsureAuditChainHeadParams) error
+	GetAPIKey(ctx context.Context, id uuid.UUID) (ApiKey, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (ApiKey, error)
 	GetInfoForSessionRefresh(ctx context.Context, arg GetInfoForSessionRefreshParams) (GetInfoForSessionRefreshRow, error)
 	GetInvitationByID(ctx context.Context, id uuid.UUID) (Invitation, error)
 	GetInvitationByIDUnsafe(ctx context.Context, id uuid.UUID) (Invitation, error)
 	GetInvitationByToken(ctx context.Context, token string) (Invitation, error)
 	GetInvitationByTokenUnsafe(ctx context.Context, token string) (Invitation, error)
+	GetLastAuditChainLogBefore(ctx context.Context, arg GetLastAuditChainLogBeforeParams) (AuditLog, error)
 	GetLoginInfoForUser(ctx context.Context, email string) (User, error)
 	GetOrgByDomain(ctx context.Context, domain string) (Org, error)
 	GetOrgByID(ctx context.Context, id uuid.UUID) (Org, error)
+	// Locks the row of the organization until the end of the transaction, so that concurrent updates of its settings are serialized.
+	GetOrgByIDForUpdate(ctx context.Context, id uuid.UUID) (Org, error)
 	GetOrgBySlug(ctx context.Context, slug string) (Org, error)
 	GetOrgForDomainIfAutoJoin(ctx context.Context, domain string) (Org, error)
+	GetOrgRoleByName(ctx context.Context, arg GetOrgRoleByNameParams) (OrgRole, error)
+	// Locks the row of the role until the end of the transaction, so that it is not deleted while it is assigned, and the other way around.
+	GetOrgRoleByNameForUpdate(ctx context.Context, arg GetOrgRoleByNameForUpdateParams) (OrgRole, error)
 	GetSessionByID(ctx context.Context, id uuid.UUID) (Session, error)
 	GetSessionByRefreshToken(ctx context.Context, refreshTokenHash string) (Session, error)
 	GetSessionByToken(ctx context.Context, tokenHash string) (Session, error)
@@ -38,27 +85,105 @@ type Querier interface {
 	GetSessionsByUserIDAndOrgID(ctx context.Context, arg GetSessionsByUserIDAndOrgIDParams) ([]Session, error)
 	GetUserByEmail(ctx context.Context, email string) (GetUserByEmailRow, error)
 	GetUserByID(ctx context.Context, id uuid.UUID) (GetUserByIDRow, error)
+	GetUserOrgMembership(ctx context.Context, arg GetUserOrgMembershipParams) (UserOrg, error)
+	// Lists the organizations of the user, with the user's membership.
+	GetUserOrgMemberships(ctx context.Context, userID uuid.UUID) ([]GetUserOrgMembershipsRow, error)
 	GetUserOrgsByEmail(ctx context.Context, email *string) ([]GetUserOrgsByEmailRow, error)
 	GetUserOrgsByID(ctx context.Context, id *uuid.UUID) ([]GetUserOrgsByIDRow, error)
 	GetVerificationTokenByHash(ctx context.Context, tokenHash []byte) (VerificationToken, error)
+	GetWebhookEndpoint(ctx context.Context, arg GetWebhookEndpointParams) (WebhookEndpoint, error)
 	LinkUserToOrg(ctx context.Context, arg LinkUserToOrgParams) error
+	// Lists the keys, newest first. Revoked and expired keys are only included if include_inactive is true.
+	ListAPIKeys(ctx context.Context, arg ListAPIKeysParams) ([]ApiKey, error)
+	ListAuditChainHeads(ctx context.Context) ([]AuditChainHead, error)
+	ListAuditChainLogs(ctx context.Context, arg ListAuditChainLogsParams) ([]AuditLog, error)
+	ListAuditCheckpoints(ctx context.Context, chainID uuid.UUID) ([]AuditCheckpoint, error)
+	// Lists the audit logs of all organizations, for platform admins.
+	ListAuditLogs(ctx context.Context, arg ListAuditLogsParams) ([]AuditLog, error)
+	ListAuthzObjectIDs(ctx context.Context, arg ListAuthzObjectIDsParams) ([]string, error)
+	ListAuthzTuplesForObject(ctx context.Context, arg ListAuthzTuplesForObjectParams) ([]AuthzTuple, error)
+	// Lists up to limit users which were soft deleted before the given time, to be erased.
+	ListDeletedUsers(ctx context.Context, arg ListDeletedUsersParams) ([]uuid.UUID, error)
+	// Lists up to limit users whose erasure is scheduled before the given time.
+	ListDueUserErasures(ctx context.Context, arg ListDueUserErasuresParams) ([]uuid.UUID, error)
+	// Lists up to limit data exports which expired before the given time.
+	ListExpiredDataExports(ctx context.Context, arg ListExpiredDataExportsParams) ([]DataExport, error)
+	ListNotificationJobs(ctx context.Context, arg ListNotificationJobsParams) ([]NotificationJob, error)
+	ListOrgAuditLogs(ctx context.Context, arg ListOrgAuditLogsParams) ([]AuditLog, error)
+	ListOrgRoles(ctx context.Context, orgID uuid.UUID) ([]OrgRole, error)
+	ListUserDataExports(ctx context.Context, userID uuid.UUID) ([]DataExport, error)
+	// Lists the MFA factors of the user, without their secrets.
+	ListUserMFAFactors(ctx context.Context, userID uuid.UUID) ([]ListUserMFAFactorsRow, error)
+	// Lists the OAuth identities linked to the user, without the data returned by the providers.
+	ListUserOAuthIdentities(ctx context.Context, userID uuid.UUID) ([]ListUserOAuthIdentitiesRow, error)
+	ListWebhookDeliveries(ctx context.Context, arg ListWebhookDeliveriesParams) ([]WebhookDelivery, error)
+	ListWebhookEndpoints(ctx context.Context, orgID uuid.UUID) ([]WebhookEndpoint, error)
+	// Lists the enabled endpoints of the org which receive the event type.
+	ListWebhookEndpointsForEvent(ctx context.Context, arg ListWebhookEndpointsForEventParams) ([]WebhookEndpoint, error)
+	LockAuditChainHead(ctx context.Context, chainID uuid.UUID) (AuditChainHead, error)
+	// Marks an active key as replaced by a new key, and makes it expire at the end of the overlap window, unless it expires before.
+	MarkAPIKeyRotated(ctx context.Context, arg MarkAPIKeyRotatedParams) (ApiKey, error)
+	MarkNotificationJobExpired(ctx context.Context, id uuid.UUID) error
+	MarkNotificationJobFailed(ctx context.Context, arg MarkNotificationJobFailedParams) error
+	MarkNotificationJobSent(ctx context.Context, id uuid.UUID) error
 	MarkUserEmailVerified(ctx context.Context, id uuid.UUID) error
+	MarkWebhookDeliveryDelivered(ctx context.Context, arg MarkWebhookDeliveryDeliveredParams) error
+	MarkWebhookDeliveryFailed(ctx context.Context, arg MarkWebhookDeliveryFailedParams) error
 	NewVerificationToken(ctx context.Context, arg NewVerificationTokenParams) (VerificationToken, error)
+	// Deletes up to limit orgs which were soft deleted before the given time, with everything scoped to them.
+	// The default org is never deleted.
+	PurgeDeletedOrgs(ctx context.Context, arg PurgeDeletedOrgsParams) (int64, error)
+	// Deletes up to limit invitations which expired before the given time, whatever their status.
+	PurgeExpiredInvitations(ctx context.Context, arg PurgeExpiredInvitationsParams) (int64, error)
+	// Deletes up to limit OIDC access tokens which expired before the given time.
+	// Tokens with a refresh token which has not expired are kept, since deleting them would delete the refresh token.
+	PurgeExpiredOIDCAccessTokens(ctx context.Context, arg PurgeExpiredOIDCAccessTokensParams) (int64, error)
+	// Deletes up to limit OIDC authorization codes which expired before the given time.
+	PurgeExpiredOIDCAuthCodes(ctx context.Context, arg PurgeExpiredOIDCAuthCodesParams) (int64, error)
+	// Deletes up to limit OIDC refresh tokens which expired before the given time.
+	PurgeExpiredOIDCRefreshTokens(ctx context.Context, arg PurgeExpiredOIDCRefreshTokensParams) (int64, error)
+	// Deletes up to limit sessions which expired before the given time.
+	PurgeExpiredSessions(ctx context.Context, arg PurgeExpiredSessionsParams) (int64, error)
+	// Deletes up to limit verification tokens which expired before the given time.
+	PurgeExpiredVerificationTokens(ctx context.Context, arg PurgeExpiredVerificationTokensParams) (int64, error)
+	// Deletes up to limit API keys which were revoked or expired before the given time.
+	PurgeInactiveAPIKeys(ctx context.Context, arg PurgeInactiveAPIKeysParams) (int64, error)
+	// Deletes up to limit notification jobs which are no longer pending, created before the given time.
+	PurgeNotificationJobs(ctx context.Context, arg PurgeNotificationJobsParams) (int64, error)
+	// Deletes up to limit webhook deliveries which were delivered or are dead, created before the given time.
+	PurgeWebhookDeliveries(ctx context.Context, arg PurgeWebhookDeliveriesParams) (int64, error)
+	// Removes the email from the payloads of the webhook deliveries of the user, eg. user.created, when they are erased.
+	RedactUserWebhookDeliveries(ctx context.Context, userID string) error
+	RedeliverWebhookDelivery(ctx context.Context, arg RedeliverWebhookDeliveryParams) (WebhookDelivery, error)
 	RefreshSession(ctx context.Context, arg RefreshSessionParams) (Session, error)
 	RemoveDomainFromOrg(ctx context.Context, arg RemoveDomainFromOrgParams) error
+	// Schedules a dead job to be sent again immediately, with a new set of attempts.
+	RetryNotificationJob(ctx context.Context, id uuid.UUID) (NotificationJob, error)
+	RevokeAPIKey(ctx context.Context, id uuid.UUID) (ApiKey, error)
 	RevokeInvitation(ctx context.Context, id uuid.UUID) error
 	RevokeInvitationByEmail(ctx context.Context, arg RevokeInvitationByEmailParams) error
 	RevokeInvitationByToken(ctx context.Context, token string) error
+	// Schedules the erasure of the user at the given time, unless it is already scheduled, and returns the time of the erasure.
+	ScheduleUserErasure(ctx context.Context, arg ScheduleUserErasureParams) (pgtype.Timestamptz, error)
+	SetAuditChainCheckpoint(ctx context.Context, arg SetAuditChainCheckpointParams) error
 	SetUserBackupCodes(ctx context.Context, arg SetUserBackupCodesParams) error
 	SoftDeleteOrg(ctx context.Context, id uuid.UUID) error
 	SoftDeleteUser(ctx context.Context, email string) error
+	// Records the use of the key. last_used_at is updated at most once per minute, to limit the writes.
+	TouchAPIKey(ctx context.Context, id uuid.UUID) error
 	UnlinkUserFromOrg(ctx context.Context, arg UnlinkUserFromOrgParams) error
+	UpdateAuditChainHead(ctx context.Context, arg UpdateAuditChainHeadParams) error
 	UpdateOrg(ctx context.Context, arg UpdateOrgParams) (Org, error)
+	UpdateOrgRole(ctx context.Context, arg UpdateOrgRoleParams) (OrgRole, error)
 	UpdateOrgWhereSlug(ctx context.Context, arg UpdateOrgWhereSlugParams) (Org, error)
 	UpdateSessionMFA(ctx context.Context, arg UpdateSessionMFAParams) (Session, error)
-	UpdateUser(ctx context.Context, arg UpdateUserParams) (UpdateUserRow, error)
+	// Updates the profile of a user. The user is identified by ID, so that a concurrent change of the email does not make the update miss.
+	UpdateUserByID(ctx context.Context, arg UpdateUserByIDParams) (UpdateUserByIDRow, error)
+	UpdateUserOrgRole(ctx context.Context, arg UpdateUserOrgRoleParams) error
 	UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error
 	UpdateUserSessionAgentAndIP(ctx context.Context, arg UpdateUserSessionAgentAndIPParams) (Session, error)
+	UpdateWebhookEndpoint(ctx context.Context, arg UpdateWebhookEndpointParams) (WebhookEndpoint, error)
+	WriteAuthzTuple(ctx context.Context, arg WriteAuthzTupleParams) error
 }
 
 var _ Querier = (*Queries)(nil)
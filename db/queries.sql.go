@@ -58,6 +58,420 @@ func (q *Queries) BanUserFromOrg(ctx context.Context, arg BanUserFromOrgParams)
 	return err
 }
 
+const cancelUserErasure = `-- name: CancelUserErasure :execrows
+UPDATE users
+SET erasure_scheduled_at = NULL,
+  updated_at = NOW()
+WHERE id = $1
+  AND erasure_scheduled_at IS NOT NULL
+`
+
+func (q *Queries) CancelUserErasure(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, cancelUserErasure, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const changeUserEmail = `-- name: ChangeUserEmail :exec
+UPDATE users
+SET email = $1,
+  email_verified = TRUE,
+  updated_at = NOW()
+WHERE id = $2
+`
+
+type ChangeUserEmailParams struct {
+	Email string    `db:"email" json:"email"`
+	ID    uuid.UUID `db:"id" json:"id"`
+}
+
+// Changes the email address of the user to a verified address.
+func (q *Queries) ChangeUserEmail(ctx context.Context, arg ChangeUserEmailParams) error {
+	_, err := q.db.Exec(ctx, changeUserEmail, arg.Email, arg.ID)
+	return err
+}
+
+const claimNotificationJobs = `-- name: ClaimNotificationJobs :many
+UPDATE notification_jobs
+SET next_attempt_at = NOW() + make_interval(secs => $1::int),
+  updated_at = NOW()
+WHERE id IN (
+    SELECT nj.id
+    FROM notification_jobs nj
+    WHERE nj.status = 'pending'
+      AND nj.next_attempt_at <= NOW()
+    ORDER BY nj.next_attempt_at
+    LIMIT $2 FOR
+    UPDATE SKIP LOCKED
+  )
+RETURNING id,
+  channel,
+  kind,
+  recipient,
+  payload,
+  attempts,
+  expires_at
+`
+
+type ClaimNotificationJobsParams struct {
+	LeaseSeconds int32 `db:"lease_seconds" json:"leaseSeconds"`
+	Limit        int32 `db:"limit" json:"limit"`
+}
+
+type ClaimNotificationJobsRow struct {
+	ID        uuid.UUID          `db:"id" json:"id"`
+	Channel   string             `db:"channel" json:"channel"`
+	Kind      string             `db:"kind" json:"kind"`
+	Recipient string             `db:"recipient" json:"recipient"`
+	Payload   []byte             `db:"payload" json:"payload"`
+	Attempts  int32              `db:"attempts" json:"attempts"`
+	ExpiresAt pgtype.Timestamptz `db:"expires_at" json:"expiresAt"`
+}
+
+// Claims the due jobs, by moving their next attempt past the lease, so that other workers skip them while they are sent.
+func (q *Queries) ClaimNotificationJobs(ctx context.Context, arg ClaimNotificationJobsParams) ([]ClaimNotificationJobsRow, error) {
+	rows, err := q.db.Query(ctx, claimNotificationJobs, arg.LeaseSeconds, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClaimNotificationJobsRow
+	for rows.Next() {
+		var i ClaimNotificationJobsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Channel,
+			&i.Kind,
+			&i.Recipient,
+			&i.Payload,
+			&i.Attempts,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const claimWebhookDeliveries = `-- name: ClaimWebhookDeliveries :many
+UPDATE webhook_deliveries d
+SET next_attempt_at = NOW() + make_interval(secs => $1::int),
+  updated_at = NOW()
+FROM webhook_endpoints e
+WHERE d.id IN (
+    SELECT wd.id
+    FROM webhook_deliveries wd
+    WHERE wd.status = 'pending'
+      AND wd.next_attempt_at <= NOW()
+    ORDER BY wd.next_attempt_at
+    LIMIT $2 FOR
+    UPDATE SKIP LOCKED
+  )
+  AND e.id = d.endpoint_id
+RETURNING d.id,
+  d.event_id,
+  d.event_type,
+  d.payload,
+  d.attempts,
+  e.url,
+  e.secret
+`
+
+type ClaimWebhookDeliveriesParams struct {
+	LeaseSeconds int32 `db:"lease_seconds" json:"leaseSeconds"`
+	Limit        int32 `db:"limit" json:"limit"`
+}
+
+type ClaimWebhookDeliveriesRow struct {
+	ID        uuid.UUID `db:"id" json:"id"`
+	EventID   uuid.UUID `db:"event_id" json:"eventId"`
+	EventType string    `db:"event_type" json:"eventType"`
+	Payload   []byte    `db:"payload" json:"payload"`
+	Attempts  int32     `db:"attempts" json:"attempts"`
+	Url       string    `db:"url" json:"url"`
+	Secret    string    `db:"secret" json:"secret"`
+}
+
+// Claims the due deliveries, by moving their next attempt past the lease, so that other instances skip them while they are sent.
+func (q *Queries) ClaimWebhookDeliveries(ctx context.Context, arg ClaimWebhookDeliveriesParams) ([]ClaimWebhookDeliveriesRow, error) {
+	rows, err := q.db.Query(ctx, claimWebhookDeliveries, arg.LeaseSeconds, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClaimWebhookDeliveriesRow
+	for rows.Next() {
+		var i ClaimWebhookDeliveriesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.EventID,
+			&i.EventType,
+			&i.Payload,
+			&i.Attempts,
+			&i.Url,
+			&i.Secret,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countNotificationJobsByStatus = `-- name: CountNotificationJobsByStatus :many
+SELECT status,
+  count(*)
+FROM notification_jobs
+WHERE status IN ('pending', 'dead')
+GROUP BY status
+`
+
+type CountNotificationJobsByStatusRow struct {
+	Status string `db:"status" json:"status"`
+	Count  int64  `db:"count" json:"count"`
+}
+
+// Counts the jobs which are waiting to be sent, or need attention.
+func (q *Queries) CountNotificationJobsByStatus(ctx context.Context) ([]CountNotificationJobsByStatusRow, error) {
+	rows, err := q.db.Query(ctx, countNotificationJobsByStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountNotificationJobsByStatusRow
+	for rows.Next() {
+		var i CountNotificationJobsByStatusRow
+		if err := rows.Scan(&i.Status, &i.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countOrgMembersWithRole = `-- name: CountOrgMembersWithRole :one
+SELECT count(*)
+FROM user_orgs
+WHERE org_id = $1
+  AND role = $2
+`
+
+type CountOrgMembersWithRoleParams struct {
+	OrgID uuid.UUID `db:"org_id" json:"orgId"`
+	Role  string    `db:"role" json:"role"`
+}
+
+func (q *Queries) CountOrgMembersWithRole(ctx context.Context, arg CountOrgMembersWithRoleParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countOrgMembersWithRole, arg.OrgID, arg.Role)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+type CreateAuditLogsParams struct {
+	ID           uuid.UUID          `db:"id" json:"id"`
+	OrgID        *uuid.UUID         `db:"org_id" json:"orgId"`
+	UserID       *uuid.UUID         `db:"user_id" json:"userId"`
+	Action       string             `db:"action" json:"action"`
+	ResourceType string             `db:"resource_type" json:"resourceType"`
+	ResourceID   *uuid.UUID         `db:"resource_id" json:"resourceId"`
+	IpAddress    *netip.Addr        `db:"ip_address" json:"ipAddress"`
+	UserAgent    *string            `db:"user_agent" json:"userAgent"`
+	Metadata     []byte             `db:"metadata" json:"metadata"`
+	CreatedAt    pgtype.Timestamptz `db:"created_at" json:"createdAt"`
+	ChainID      uuid.UUID          `db:"chain_id" json:"chainId"`
+	Seq          *int64             `db:"seq" json:"seq"`
+	PrevHash     []byte             `db:"prev_hash" json:"prevHash"`
+	Hash         []byte             `db:"hash" json:"hash"`
+}
+
+const createAPIKey = `-- name: CreateAPIKey :one
+INSERT INTO api_keys (
+    id,
+    name,
+    description,
+    prefix,
+    key_hash,
+    scopes,
+    org_id,
+    expires_at,
+    created_by
+  )
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9
+  )
+RETURNING id, name, description, prefix, key_hash, scopes, org_id, expires_at, last_used_at, revoked_at, rotated_to, created_by, created_at, updated_at
+`
+
+type CreateAPIKeyParams struct {
+	ID          uuid.UUID          `db:"id" json:"id"`
+	Name        string             `db:"name" json:"name"`
+	Description *string            `db:"description" json:"description"`
+	Prefix      string             `db:"prefix" json:"prefix"`
+	KeyHash     []byte             `db:"key_hash" json:"keyHash"`
+	Scopes      []string           `db:"scopes" json:"scopes"`
+	OrgID       *uuid.UUID         `db:"org_id" json:"orgId"`
+	ExpiresAt   pgtype.Timestamptz `db:"expires_at" json:"expiresAt"`
+	CreatedBy   *string            `db:"created_by" json:"createdBy"`
+}
+
+func (q *Queries) CreateAPIKey(ctx context.Context, arg CreateAPIKeyParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, createAPIKey,
+		arg.ID,
+		arg.Name,
+		arg.Description,
+		arg.Prefix,
+		arg.KeyHash,
+		arg.Scopes,
+		arg.OrgID,
+		arg.ExpiresAt,
+		arg.CreatedBy,
+	)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.OrgID,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+		&i.RotatedTo,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createAuditCheckpoint = `-- name: CreateAuditCheckpoint :one
+INSERT INTO audit_checkpoints (
+    id,
+    chain_id,
+    kind,
+    seq,
+    hash,
+    deleted_count,
+    key_id,
+    signature,
+    created_at
+  )
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9
+  )
+RETURNING id, chain_id, kind, seq, hash, deleted_count, key_id, signature, created_at
+`
+
+type CreateAuditCheckpointParams struct {
+	ID           uuid.UUID          `db:"id" json:"id"`
+	ChainID      uuid.UUID          `db:"chain_id" json:"chainId"`
+	Kind         string             `db:"kind" json:"kind"`
+	Seq          int64              `db:"seq" json:"seq"`
+	Hash         []byte             `db:"hash" json:"hash"`
+	DeletedCount int64              `db:"deleted_count" json:"deletedCount"`
+	KeyID        string             `db:"key_id" json:"keyId"`
+	Signature    []byte             `db:"signature" json:"signature"`
+	CreatedAt    pgtype.Timestamptz `db:"created_at" json:"createdAt"`
+}
+
+func (q *Queries) CreateAuditCheckpoint(ctx context.Context, arg CreateAuditCheckpointParams) (AuditCheckpoint, error) {
+	row := q.db.QueryRow(ctx, createAuditCheckpoint,
+		arg.ID,
+		arg.ChainID,
+		arg.Kind,
+		arg.Seq,
+		arg.Hash,
+		arg.DeletedCount,
+		arg.KeyID,
+		arg.Signature,
+		arg.CreatedAt,
+	)
+	var i AuditCheckpoint
+	err := row.Scan(
+		&i.ID,
+		&i.ChainID,
+		&i.Kind,
+		&i.Seq,
+		&i.Hash,
+		&i.DeletedCount,
+		&i.KeyID,
+		&i.Signature,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createDataExport = `-- name: CreateDataExport :one
+INSERT INTO data_exports (
+    id,
+    user_id,
+    object_key,
+    format,
+    expires_at
+  )
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, user_id, object_key, format, expires_at, created_at
+`
+
+type CreateDataExportParams struct {
+	ID        uuid.UUID          `db:"id" json:"id"`
+	UserID    uuid.UUID          `db:"user_id" json:"userId"`
+	ObjectKey string             `db:"object_key" json:"objectKey"`
+	Format    string             `db:"format" json:"format"`
+	ExpiresAt pgtype.Timestamptz `db:"expires_at" json:"expiresAt"`
+}
+
+func (q *Queries) CreateDataExport(ctx context.Context, arg CreateDataExportParams) (DataExport, error) {
+	row := q.db.QueryRow(ctx, createDataExport,
+		arg.ID,
+		arg.UserID,
+		arg.ObjectKey,
+		arg.Format,
+		arg.ExpiresAt,
+	)
+	var i DataExport
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.ObjectKey,
+		&i.Format,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const createInvitation = `-- name: CreateInvitation :one
 INSERT INTO invitations (
     id,
@@ -116,6 +530,54 @@ func (q *Queries) CreateInvitation(ctx context.Context, arg CreateInvitationPara
 	return i, err
 }
 
+const createNotificationJob = `-- name: CreateNotificationJob :execrows
+INSERT INTO notification_jobs (
+    id,
+    idempotency_key,
+    channel,
+    kind,
+    recipient,
+    payload,
+    expires_at
+  )
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+  ) ON CONFLICT (idempotency_key) DO NOTHING
+`
+
+type CreateNotificationJobParams struct {
+	ID             uuid.UUID          `db:"id" json:"id"`
+	IdempotencyKey string             `db:"idempotency_key" json:"idempotencyKey"`
+	Channel        string             `db:"channel" json:"channel"`
+	Kind           string             `db:"kind" json:"kind"`
+	Recipient      string             `db:"recipient" json:"recipient"`
+	Payload        []byte             `db:"payload" json:"payload"`
+	ExpiresAt      pgtype.Timestamptz `db:"expires_at" json:"expiresAt"`
+}
+
+// Enqueues a notification, unless a job with the same idempotency key exists, in which case no row is affected.
+func (q *Queries) CreateNotificationJob(ctx context.Context, arg CreateNotificationJobParams) (int64, error) {
+	result, err := q.db.Exec(ctx, createNotificationJob,
+		arg.ID,
+		arg.IdempotencyKey,
+		arg.Channel,
+		arg.Kind,
+		arg.Recipient,
+		arg.Payload,
+		arg.ExpiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const createOrg = `-- name: CreateOrg :one
 INSERT INTO orgs (
     id,
@@ -169,6 +631,53 @@ func (q *Queries) CreateOrg(ctx context.Context, arg CreateOrgParams) (Org, erro
 	return i, err
 }
 
+const createOrgRole = `-- name: CreateOrgRole :one
+INSERT INTO org_roles (
+    id,
+    org_id,
+    name,
+    description,
+    permissions
+  )
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+  )
+RETURNING id, org_id, name, description, permissions, created_at, updated_at
+`
+
+type CreateOrgRoleParams struct {
+	ID          uuid.UUID `db:"id" json:"id"`
+	OrgID       uuid.UUID `db:"org_id" json:"orgId"`
+	Name        string    `db:"name" json:"name"`
+	Description *string   `db:"description" json:"description"`
+	Permissions []string  `db:"permissions" json:"permissions"`
+}
+
+func (q *Queries) CreateOrgRole(ctx context.Context, arg CreateOrgRoleParams) (OrgRole, error) {
+	row := q.db.QueryRow(ctx, createOrgRole,
+		arg.ID,
+		arg.OrgID,
+		arg.Name,
+		arg.Description,
+		arg.Permissions,
+	)
+	var i OrgRole
+	err := row.Scan(
+		&i.ID,
+		&i.OrgID,
+		&i.Name,
+		&i.Description,
+		&i.Permissions,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const createSession = `-- name: CreateSession :one
 INSERT INTO sessions (
     id,
@@ -247,7 +756,8 @@ INSERT INTO users (
     password_hash,
     first_name,
     last_name,
-    avatar_url
+    avatar_url,
+    locale
   )
 VALUES (
     $1,
@@ -255,7 +765,8 @@ VALUES (
     $3,
     $4,
     $5,
-    $6
+    $6,
+    $7
   )
 RETURNING id,
   email,
@@ -263,6 +774,7 @@ RETURNING id,
   first_name,
   last_name,
   avatar_url,
+  locale,
   created_at,
   updated_at
 `
@@ -274,6 +786,7 @@ type CreateUserParams struct {
 	FirstName    *string   `db:"first_name" json:"firstName"`
 	LastName     *string   `db:"last_name" json:"lastName"`
 	AvatarUrl    *string   `db:"avatar_url" json:"avatarUrl"`
+	Locale       *string   `db:"locale" json:"locale"`
 }
 
 type CreateUserRow struct {
@@ -283,6 +796,7 @@ type CreateUserRow struct {
 	FirstName     *string            `db:"first_name" json:"firstName"`
 	LastName      *string            `db:"last_name" json:"lastName"`
 	AvatarUrl     *string            `db:"avatar_url" json:"avatarUrl"`
+	Locale        *string            `db:"locale" json:"locale"`
 	CreatedAt     pgtype.Timestamptz `db:"created_at" json:"createdAt"`
 	UpdatedAt     pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
 }
@@ -295,6 +809,7 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateU
 		arg.FirstName,
 		arg.LastName,
 		arg.AvatarUrl,
+		arg.Locale,
 	)
 	var i CreateUserRow
 	err := row.Scan(
@@ -304,42 +819,480 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateU
 		&i.FirstName,
 		&i.LastName,
 		&i.AvatarUrl,
+		&i.Locale,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const deleteSession = `-- name: DeleteSession :exec
-DELETE FROM sessions
-WHERE id = $1
-`
-
+const createWebhookDelivery = `-- name: CreateWebhookDelivery :exec
+INSERT INTO webhook_deliveries (
+    id,
+    endpoint_id,
+    org_id,
+    event_id,
+    event_type,
+    payload
+  )
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+  )
+`
+
+type CreateWebhookDeliveryParams struct {
+	ID         uuid.UUID `db:"id" json:"id"`
+	EndpointID uuid.UUID `db:"endpoint_id" json:"endpointId"`
+	OrgID      uuid.UUID `db:"org_id" json:"orgId"`
+	EventID    uuid.UUID `db:"event_id" json:"eventId"`
+	EventType  string    `db:"event_type" json:"eventType"`
+	Payload    []byte    `db:"payload" json:"payload"`
+}
+
+func (q *Queries) CreateWebhookDelivery(ctx context.Context, arg CreateWebhookDeliveryParams) error {
+	_, err := q.db.Exec(ctx, createWebhookDelivery,
+		arg.ID,
+		arg.EndpointID,
+		arg.OrgID,
+		arg.EventID,
+		arg.EventType,
+		arg.Payload,
+	)
+	return err
+}
+
+const createWebhookEndpoint = `-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (
+    id,
+    org_id,
+    url,
+    description,
+    event_types,
+    secret,
+    enabled
+  )
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+  )
+RETURNING id, org_id, url, description, event_types, secret, enabled, created_at, updated_at
+`
+
+type CreateWebhookEndpointParams struct {
+	ID          uuid.UUID `db:"id" json:"id"`
+	OrgID       uuid.UUID `db:"org_id" json:"orgId"`
+	Url         string    `db:"url" json:"url"`
+	Description *string   `db:"description" json:"description"`
+	EventTypes  []string  `db:"event_types" json:"eventTypes"`
+	Secret      string    `db:"secret" json:"secret"`
+	Enabled     bool      `db:"enabled" json:"enabled"`
+}
+
+func (q *Queries) CreateWebhookEndpoint(ctx context.Context, arg CreateWebhookEndpointParams) (WebhookEndpoint, error) {
+	row := q.db.QueryRow(ctx, createWebhookEndpoint,
+		arg.ID,
+		arg.OrgID,
+		arg.Url,
+		arg.Description,
+		arg.EventTypes,
+		arg.Secret,
+		arg.Enabled,
+	)
+	var i WebhookEndpoint
+	err := row.Scan(
+		&i.ID,
+		&i.OrgID,
+		&i.Url,
+		&i.Description,
+		&i.EventTypes,
+		&i.Secret,
+		&i.Enabled,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const deleteAuditChainLogs = `-- name: DeleteAuditChainLogs :execrows
+DELETE FROM audit_logs
+WHERE chain_id = $1
+  AND seq <= $2::bigint
+`
+
+type DeleteAuditChainLogsParams struct {
+	ChainID uuid.UUID `db:"chain_id" json:"chainId"`
+	Seq     int64     `db:"seq" json:"seq"`
+}
+
+func (q *Queries) DeleteAuditChainLogs(ctx context.Context, arg DeleteAuditChainLogsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteAuditChainLogs, arg.ChainID, arg.Seq)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteAuthzTuple = `-- name: DeleteAuthzTuple :exec
+DELETE FROM authz_tuples
+WHERE org_id = $1
+  AND object_namespace = $2
+  AND object_id = $3
+  AND relation = $4
+  AND subject_namespace = $5
+  AND subject_id = $6
+  AND subject_relation = $7
+`
+
+type DeleteAuthzTupleParams struct {
+	OrgID            uuid.UUID `db:"org_id" json:"orgId"`
+	ObjectNamespace  string    `db:"object_namespace" json:"objectNamespace"`
+	ObjectID         string    `db:"object_id" json:"objectId"`
+	Relation         string    `db:"relation" json:"relation"`
+	SubjectNamespace string    `db:"subject_namespace" json:"subjectNamespace"`
+	SubjectID        string    `db:"subject_id" json:"subjectId"`
+	SubjectRelation  string    `db:"subject_relation" json:"subjectRelation"`
+}
+
+func (q *Queries) DeleteAuthzTuple(ctx context.Context, arg DeleteAuthzTupleParams) error {
+	_, err := q.db.Exec(ctx, deleteAuthzTuple,
+		arg.OrgID,
+		arg.ObjectNamespace,
+		arg.ObjectID,
+		arg.Relation,
+		arg.SubjectNamespace,
+		arg.SubjectID,
+		arg.SubjectRelation,
+	)
+	return err
+}
+
+const deleteDataExport = `-- name: DeleteDataExport :exec
+DELETE FROM data_exports
+WHERE id = $1
+`
+
+func (q *Queries) DeleteDataExport(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, deleteDataExport, id)
+	return err
+}
+
+const deleteInvitationsByEmail = `-- name: DeleteInvitationsByEmail :exec
+DELETE FROM invitations
+WHERE lower(email) = lower($1)
+`
+
+// Deletes the invitations of every org sent to the email address.
+func (q *Queries) DeleteInvitationsByEmail(ctx context.Context, email string) error {
+	_, err := q.db.Exec(ctx, deleteInvitationsByEmail, email)
+	return err
+}
+
+const deleteNotificationJobsByRecipient = `-- name: DeleteNotificationJobsByRecipient :exec
+DELETE FROM notification_jobs
+WHERE recipient = $1
+`
+
+func (q *Queries) DeleteNotificationJobsByRecipient(ctx context.Context, recipient string) error {
+	_, err := q.db.Exec(ctx, deleteNotificationJobsByRecipient, recipient)
+	return err
+}
+
+const deleteOrgRole = `-- name: DeleteOrgRole :exec
+DELETE FROM org_roles
+WHERE org_id = $1
+  AND name = $2
+`
+
+type DeleteOrgRoleParams struct {
+	OrgID uuid.UUID `db:"org_id" json:"orgId"`
+	Name  string    `db:"name" json:"name"`
+}
+
+func (q *Queries) DeleteOrgRole(ctx context.Context, arg DeleteOrgRoleParams) error {
+	_, err := q.db.Exec(ctx, deleteOrgRole, arg.OrgID, arg.Name)
+	return err
+}
+
+const deleteSession = `-- name: DeleteSession :exec
+DELETE FROM sessions
+WHERE id = $1
+`
+
 func (q *Queries) DeleteSession(ctx context.Context, id uuid.UUID) error {
 	_, err := q.db.Exec(ctx, deleteSession, id)
 	return err
 }
 
-const deleteSessionByRefreshToken = `-- name: DeleteSessionByRefreshToken :exec
+const deleteSessionByRefreshToken = `-- name: DeleteSessionByRefreshToken :many
 DELETE FROM sessions
 WHERE refresh_token_hash = $1
+RETURNING id,
+  user_id,
+  org_id
 `
 
-func (q *Queries) DeleteSessionByRefreshToken(ctx context.Context, refreshTokenHash string) error {
-	_, err := q.db.Exec(ctx, deleteSessionByRefreshToken, refreshTokenHash)
-	return err
+type DeleteSessionByRefreshTokenRow struct {
+	ID     uuid.UUID `db:"id" json:"id"`
+	UserID uuid.UUID `db:"user_id" json:"userId"`
+	OrgID  uuid.UUID `db:"org_id" json:"orgId"`
+}
+
+func (q *Queries) DeleteSessionByRefreshToken(ctx context.Context, refreshTokenHash string) ([]DeleteSessionByRefreshTokenRow, error) {
+	rows, err := q.db.Query(ctx, deleteSessionByRefreshToken, refreshTokenHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeleteSessionByRefreshTokenRow
+	for rows.Next() {
+		var i DeleteSessionByRefreshTokenRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.OrgID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const deleteSessionByToken = `-- name: DeleteSessionByToken :exec
+const deleteSessionByToken = `-- name: DeleteSessionByToken :many
 DELETE FROM sessions
 WHERE token_hash = $1
+RETURNING id,
+  user_id,
+  org_id
 `
 
-func (q *Queries) DeleteSessionByToken(ctx context.Context, tokenHash string) error {
-	_, err := q.db.Exec(ctx, deleteSessionByToken, tokenHash)
+type DeleteSessionByTokenRow struct {
+	ID     uuid.UUID `db:"id" json:"id"`
+	UserID uuid.UUID `db:"user_id" json:"userId"`
+	OrgID  uuid.UUID `db:"org_id" json:"orgId"`
+}
+
+func (q *Queries) DeleteSessionByToken(ctx context.Context, tokenHash string) ([]DeleteSessionByTokenRow, error) {
+	rows, err := q.db.Query(ctx, deleteSessionByToken, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeleteSessionByTokenRow
+	for rows.Next() {
+		var i DeleteSessionByTokenRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.OrgID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deleteUnchainedAuditLogs = `-- name: DeleteUnchainedAuditLogs :execrows
+DELETE FROM audit_logs
+WHERE seq IS NULL
+  AND created_at < $1
+`
+
+// Deletes the entries written before the audit logs were chained, created before the given time.
+func (q *Queries) DeleteUnchainedAuditLogs(ctx context.Context, before pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUnchainedAuditLogs, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteUser = `-- name: DeleteUser :exec
+DELETE FROM users
+WHERE id = $1
+`
+
+// Deletes the user, with their memberships, sessions, tokens, identities and MFA factors.
+func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, deleteUser, id)
+	return err
+}
+
+const deleteUserAuthzTuples = `-- name: DeleteUserAuthzTuples :exec
+DELETE FROM authz_tuples
+WHERE subject_id = $1
+  OR object_id = $1
+`
+
+// Deletes the relation tuples of every org with the user as their subject or object, eg. 'user:<user id>'.
+func (q *Queries) DeleteUserAuthzTuples(ctx context.Context, userID string) error {
+	_, err := q.db.Exec(ctx, deleteUserAuthzTuples, userID)
+	return err
+}
+
+const deleteUserSessions = `-- name: DeleteUserSessions :many
+DELETE FROM sessions
+WHERE user_id = $1
+RETURNING id,
+  user_id,
+  org_id
+`
+
+type DeleteUserSessionsRow struct {
+	ID     uuid.UUID `db:"id" json:"id"`
+	UserID uuid.UUID `db:"user_id" json:"userId"`
+	OrgID  uuid.UUID `db:"org_id" json:"orgId"`
+}
+
+// Revokes every session of the user.
+func (q *Queries) DeleteUserSessions(ctx context.Context, userID uuid.UUID) ([]DeleteUserSessionsRow, error) {
+	rows, err := q.db.Query(ctx, deleteUserSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []DeleteUserSessionsRow{}
+	for rows.Next() {
+		var i DeleteUserSessionsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.OrgID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deleteUserVerificationTokens = `-- name: DeleteUserVerificationTokens :exec
+DELETE FROM verification_tokens
+WHERE user_id = $1
+  AND TYPE = $2
+`
+
+type DeleteUserVerificationTokensParams struct {
+	UserID uuid.UUID `db:"user_id" json:"userId"`
+	Type   string    `db:"type" json:"type"`
+}
+
+// Deletes the verification tokens of the given type of the user.
+func (q *Queries) DeleteUserVerificationTokens(ctx context.Context, arg DeleteUserVerificationTokensParams) error {
+	_, err := q.db.Exec(ctx, deleteUserVerificationTokens, arg.UserID, arg.Type)
+	return err
+}
+
+const deleteWebhookEndpoint = `-- name: DeleteWebhookEndpoint :execrows
+DELETE FROM webhook_endpoints
+WHERE id = $1
+  AND org_id = $2
+`
+
+type DeleteWebhookEndpointParams struct {
+	ID    uuid.UUID `db:"id" json:"id"`
+	OrgID uuid.UUID `db:"org_id" json:"orgId"`
+}
+
+func (q *Queries) DeleteWebhookEndpoint(ctx context.Context, arg DeleteWebhookEndpointParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteWebhookEndpoint, arg.ID, arg.OrgID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const ensureAuditChainHead = `-- name: EnsureAuditChainHead :exec
+INSERT INTO audit_chain_heads (chain_id, hash)
+VALUES ($1, $2) ON CONFLICT DO NOTHING
+`
+
+type EnsureAuditChainHeadParams struct {
+	ChainID uuid.UUID `db:"chain_id" json:"chainId"`
+	Hash    []byte    `db:"hash" json:"hash"`
+}
+
+func (q *Queries) EnsureAuditChainHead(ctx context.Context, arg EnsureAuditChainHeadParams) error {
+	_, err := q.db.Exec(ctx, ensureAuditChainHead, arg.ChainID, arg.Hash)
 	return err
 }
 
+const getAPIKey = `-- name: GetAPIKey :one
+SELECT id, name, description, prefix, key_hash, scopes, org_id, expires_at, last_used_at, revoked_at, rotated_to, created_by, created_at, updated_at
+FROM api_keys
+WHERE id = $1
+`
+
+func (q *Queries) GetAPIKey(ctx context.Context, id uuid.UUID) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, getAPIKey, id)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.OrgID,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+		&i.RotatedTo,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getAPIKeyByPrefix = `-- name: GetAPIKeyByPrefix :one
+SELECT id, name, description, prefix, key_hash, scopes, org_id, expires_at, last_used_at, revoked_at, rotated_to, created_by, created_at, updated_at
+FROM api_keys
+WHERE prefix = $1
+`
+
+func (q *Queries) GetAPIKeyByPrefix(ctx context.Context, prefix string) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, getAPIKeyByPrefix, prefix)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.OrgID,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+		&i.RotatedTo,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const getInfoForSessionRefresh = `-- name: GetInfoForSessionRefresh :one
 SELECT u.first_name AS user_fname,
   u.last_name AS user_lname,
@@ -487,14 +1440,51 @@ func (q *Queries) GetInvitationByTokenUnsafe(ctx context.Context, token string)
 	return i, err
 }
 
-const getLoginInfoForUser = `-- name: GetLoginInfoForUser :one
-SELECT id, email, email_verified, password_hash, backup_codes, first_name, last_name, avatar_url, created_at, updated_at, deleted_at
-FROM users
-WHERE email = $1
+const getLastAuditChainLogBefore = `-- name: GetLastAuditChainLogBefore :one
+SELECT id, org_id, user_id, action, resource_type, resource_id, ip_address, user_agent, metadata, created_at, chain_id, seq, prev_hash, hash
+FROM audit_logs
+WHERE chain_id = $1
+  AND seq IS NOT NULL
+  AND created_at < $2
+ORDER BY seq DESC
+LIMIT 1
 `
 
-func (q *Queries) GetLoginInfoForUser(ctx context.Context, email string) (User, error) {
-	row := q.db.QueryRow(ctx, getLoginInfoForUser, email)
+type GetLastAuditChainLogBeforeParams struct {
+	ChainID uuid.UUID          `db:"chain_id" json:"chainId"`
+	Before  pgtype.Timestamptz `db:"before" json:"before"`
+}
+
+func (q *Queries) GetLastAuditChainLogBefore(ctx context.Context, arg GetLastAuditChainLogBeforeParams) (AuditLog, error) {
+	row := q.db.QueryRow(ctx, getLastAuditChainLogBefore, arg.ChainID, arg.Before)
+	var i AuditLog
+	err := row.Scan(
+		&i.ID,
+		&i.OrgID,
+		&i.UserID,
+		&i.Action,
+		&i.ResourceType,
+		&i.ResourceID,
+		&i.IpAddress,
+		&i.UserAgent,
+		&i.Metadata,
+		&i.CreatedAt,
+		&i.ChainID,
+		&i.Seq,
+		&i.PrevHash,
+		&i.Hash,
+	)
+	return i, err
+}
+
+const getLoginInfoForUser = `-- name: GetLoginInfoForUser :one
+SELECT id, email, email_verified, password_hash, backup_codes, first_name, last_name, avatar_url, created_at, updated_at, deleted_at, locale, erasure_scheduled_at
+FROM users
+WHERE email = $1
+`
+
+func (q *Queries) GetLoginInfoForUser(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRow(ctx, getLoginInfoForUser, email)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -508,6 +1498,8 @@ func (q *Queries) GetLoginInfoForUser(ctx context.Context, email string) (User,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.DeletedAt,
+		&i.Locale,
+		&i.ErasureScheduledAt,
 	)
 	return i, err
 }
@@ -559,6 +1551,31 @@ func (q *Queries) GetOrgByID(ctx context.Context, id uuid.UUID) (Org, error) {
 	return i, err
 }
 
+const getOrgByIDForUpdate = `-- name: GetOrgByIDForUpdate :one
+SELECT id, slug, name, description, avatar_url, settings, created_at, updated_at, deleted_at
+FROM orgs
+WHERE id = $1
+FOR UPDATE
+`
+
+// Locks the row of the organization until the end of the transaction, so that concurrent updates of its settings are serialized.
+func (q *Queries) GetOrgByIDForUpdate(ctx context.Context, id uuid.UUID) (Org, error) {
+	row := q.db.QueryRow(ctx, getOrgByIDForUpdate, id)
+	var i Org
+	err := row.Scan(
+		&i.ID,
+		&i.Slug,
+		&i.Name,
+		&i.Description,
+		&i.AvatarUrl,
+		&i.Settings,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
 const getOrgBySlug = `-- name: GetOrgBySlug :one
 SELECT id, slug, name, description, avatar_url, settings, created_at, updated_at, deleted_at
 FROM orgs
@@ -608,6 +1625,62 @@ func (q *Queries) GetOrgForDomainIfAutoJoin(ctx context.Context, domain string)
 	return i, err
 }
 
+const getOrgRoleByName = `-- name: GetOrgRoleByName :one
+SELECT id, org_id, name, description, permissions, created_at, updated_at
+FROM org_roles
+WHERE org_id = $1
+  AND name = $2
+`
+
+type GetOrgRoleByNameParams struct {
+	OrgID uuid.UUID `db:"org_id" json:"orgId"`
+	Name  string    `db:"name" json:"name"`
+}
+
+func (q *Queries) GetOrgRoleByName(ctx context.Context, arg GetOrgRoleByNameParams) (OrgRole, error) {
+	row := q.db.QueryRow(ctx, getOrgRoleByName, arg.OrgID, arg.Name)
+	var i OrgRole
+	err := row.Scan(
+		&i.ID,
+		&i.OrgID,
+		&i.Name,
+		&i.Description,
+		&i.Permissions,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getOrgRoleByNameForUpdate = `-- name: GetOrgRoleByNameForUpdate :one
+SELECT id, org_id, name, description, permissions, created_at, updated_at
+FROM org_roles
+WHERE org_id = $1
+  AND name = $2
+FOR UPDATE
+`
+
+type GetOrgRoleByNameForUpdateParams struct {
+	OrgID uuid.UUID `db:"org_id" json:"orgId"`
+	Name  string    `db:"name" json:"name"`
+}
+
+// Locks the row of the role until the end of the transaction, so that it is not deleted while it is assigned, and the other way around.
+func (q *Queries) GetOrgRoleByNameForUpdate(ctx context.Context, arg GetOrgRoleByNameForUpdateParams) (OrgRole, error) {
+	row := q.db.QueryRow(ctx, getOrgRoleByNameForUpdate, arg.OrgID, arg.Name)
+	var i OrgRole
+	err := row.Scan(
+		&i.ID,
+		&i.OrgID,
+		&i.Name,
+		&i.Description,
+		&i.Permissions,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const getSessionByID = `-- name: GetSessionByID :one
 SELECT id, user_id, org_id, token_hash, refresh_token_hash, mfa_verified, ip_address, user_agent, mfa_verified_at, expires_at, created_at
 FROM sessions
@@ -810,6 +1883,7 @@ SELECT id,
   first_name,
   last_name,
   avatar_url,
+  locale,
   created_at,
   updated_at
 FROM users
@@ -823,6 +1897,7 @@ type GetUserByEmailRow struct {
 	FirstName     *string            `db:"first_name" json:"firstName"`
 	LastName      *string            `db:"last_name" json:"lastName"`
 	AvatarUrl     *string            `db:"avatar_url" json:"avatarUrl"`
+	Locale        *string            `db:"locale" json:"locale"`
 	CreatedAt     pgtype.Timestamptz `db:"created_at" json:"createdAt"`
 	UpdatedAt     pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
 }
@@ -837,6 +1912,7 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (GetUserByEm
 		&i.FirstName,
 		&i.LastName,
 		&i.AvatarUrl,
+		&i.Locale,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
@@ -850,21 +1926,25 @@ SELECT id,
   first_name,
   last_name,
   avatar_url,
+  locale,
   created_at,
-  updated_at
+  updated_at,
+  erasure_scheduled_at
 FROM users
 WHERE id = $1
 `
 
 type GetUserByIDRow struct {
-	ID            uuid.UUID          `db:"id" json:"id"`
-	Email         string             `db:"email" json:"email"`
-	EmailVerified bool               `db:"email_verified" json:"emailVerified"`
-	FirstName     *string            `db:"first_name" json:"firstName"`
-	LastName      *string            `db:"last_name" json:"lastName"`
-	AvatarUrl     *string            `db:"avatar_url" json:"avatarUrl"`
-	CreatedAt     pgtype.Timestamptz `db:"created_at" json:"createdAt"`
-	UpdatedAt     pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
+	ID                 uuid.UUID          `db:"id" json:"id"`
+	Email              string             `db:"email" json:"email"`
+	EmailVerified      bool               `db:"email_verified" json:"emailVerified"`
+	FirstName          *string            `db:"first_name" json:"firstName"`
+	LastName           *string            `db:"last_name" json:"lastName"`
+	AvatarUrl          *string            `db:"avatar_url" json:"avatarUrl"`
+	Locale             *string            `db:"locale" json:"locale"`
+	CreatedAt          pgtype.Timestamptz `db:"created_at" json:"createdAt"`
+	UpdatedAt          pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
+	ErasureScheduledAt pgtype.Timestamptz `db:"erasure_scheduled_at" json:"erasureScheduledAt"`
 }
 
 func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (GetUserByIDRow, error) {
@@ -877,12 +1957,91 @@ func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (GetUserByIDRow
 		&i.FirstName,
 		&i.LastName,
 		&i.AvatarUrl,
+		&i.Locale,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.ErasureScheduledAt,
+	)
+	return i, err
+}
+
+const getUserOrgMembership = `-- name: GetUserOrgMembership :one
+SELECT user_id, org_id, role, joined_at, last_active_at, status
+FROM user_orgs
+WHERE user_id = $1
+  AND org_id = $2
+`
+
+type GetUserOrgMembershipParams struct {
+	UserID uuid.UUID `db:"user_id" json:"userId"`
+	OrgID  uuid.UUID `db:"org_id" json:"orgId"`
+}
+
+func (q *Queries) GetUserOrgMembership(ctx context.Context, arg GetUserOrgMembershipParams) (UserOrg, error) {
+	row := q.db.QueryRow(ctx, getUserOrgMembership, arg.UserID, arg.OrgID)
+	var i UserOrg
+	err := row.Scan(
+		&i.UserID,
+		&i.OrgID,
+		&i.Role,
+		&i.JoinedAt,
+		&i.LastActiveAt,
+		&i.Status,
 	)
 	return i, err
 }
 
+const getUserOrgMemberships = `-- name: GetUserOrgMemberships :many
+SELECT o.id, o.slug, o.name, o.description, o.avatar_url, o.settings, o.created_at, o.updated_at, o.deleted_at,
+  uo.user_id, uo.org_id, uo.role, uo.joined_at, uo.last_active_at, uo.status
+FROM orgs o
+  INNER JOIN user_orgs uo ON o.id = uo.org_id
+WHERE uo.user_id = $1
+ORDER BY uo.joined_at
+`
+
+type GetUserOrgMembershipsRow struct {
+	Org     Org     `db:"org" json:"org"`
+	UserOrg UserOrg `db:"user_org" json:"userOrg"`
+}
+
+// Lists the organizations of the user, with the user's membership.
+func (q *Queries) GetUserOrgMemberships(ctx context.Context, userID uuid.UUID) ([]GetUserOrgMembershipsRow, error) {
+	rows, err := q.db.Query(ctx, getUserOrgMemberships, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetUserOrgMembershipsRow{}
+	for rows.Next() {
+		var i GetUserOrgMembershipsRow
+		if err := rows.Scan(
+			&i.Org.ID,
+			&i.Org.Slug,
+			&i.Org.Name,
+			&i.Org.Description,
+			&i.Org.AvatarUrl,
+			&i.Org.Settings,
+			&i.Org.CreatedAt,
+			&i.Org.UpdatedAt,
+			&i.Org.DeletedAt,
+			&i.UserOrg.UserID,
+			&i.UserOrg.OrgID,
+			&i.UserOrg.Role,
+			&i.UserOrg.JoinedAt,
+			&i.UserOrg.LastActiveAt,
+			&i.UserOrg.Status,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getUserOrgsByEmail = `-- name: GetUserOrgsByEmail :many
 SELECT o.id, o.slug, o.name, o.description, o.avatar_url, o.settings, o.created_at, o.updated_at, o.deleted_at,
   uo.user_id, uo.org_id, uo.role, uo.joined_at, uo.last_active_at, uo.status
@@ -980,7 +2139,7 @@ func (q *Queries) GetUserOrgsByID(ctx context.Context, id *uuid.UUID) ([]GetUser
 }
 
 const getVerificationTokenByHash = `-- name: GetVerificationTokenByHash :one
-SELECT id, user_id, type, token_hash, expires_at, created_at
+SELECT id, user_id, type, token_hash, expires_at, created_at, new_email
 FROM verification_tokens
 WHERE token_hash = $1
   AND expires_at > NOW()
@@ -996,6 +2155,36 @@ func (q *Queries) GetVerificationTokenByHash(ctx context.Context, tokenHash []by
 		&i.TokenHash,
 		&i.ExpiresAt,
 		&i.CreatedAt,
+		&i.NewEmail,
+	)
+	return i, err
+}
+
+const getWebhookEndpoint = `-- name: GetWebhookEndpoint :one
+SELECT id, org_id, url, description, event_types, secret, enabled, created_at, updated_at
+FROM webhook_endpoints
+WHERE id = $1
+  AND org_id = $2
+`
+
+type GetWebhookEndpointParams struct {
+	ID    uuid.UUID `db:"id" json:"id"`
+	OrgID uuid.UUID `db:"org_id" json:"orgId"`
+}
+
+func (q *Queries) GetWebhookEndpoint(ctx context.Context, arg GetWebhookEndpointParams) (WebhookEndpoint, error) {
+	row := q.db.QueryRow(ctx, getWebhookEndpoint, arg.ID, arg.OrgID)
+	var i WebhookEndpoint
+	err := row.Scan(
+		&i.ID,
+		&i.OrgID,
+		&i.Url,
+		&i.Description,
+		&i.EventTypes,
+		&i.Secret,
+		&i.Enabled,
+		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
@@ -1020,15 +2209,1160 @@ func (q *Queries) LinkUserToOrg(ctx context.Context, arg LinkUserToOrgParams) er
 	return err
 }
 
-const markUserEmailVerified = `-- name: MarkUserEmailVerified :exec
-UPDATE users
-SET email_verified = TRUE,
-  updated_at = NOW()
-WHERE id = $1
+const listAPIKeys = `-- name: ListAPIKeys :many
+SELECT id, name, description, prefix, key_hash, scopes, org_id, expires_at, last_used_at, revoked_at, rotated_to, created_by, created_at, updated_at
+FROM api_keys
+WHERE (
+    $1::uuid IS NULL
+    OR org_id = $1
+  )
+  AND (
+    $2::boolean
+    OR (
+      revoked_at IS NULL
+      AND (
+        expires_at IS NULL
+        OR expires_at > NOW()
+      )
+    )
+  )
+ORDER BY created_at DESC
 `
 
-func (q *Queries) MarkUserEmailVerified(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.Exec(ctx, markUserEmailVerified, id)
+type ListAPIKeysParams struct {
+	OrgID           *uuid.UUID `db:"org_id" json:"orgId"`
+	IncludeInactive bool       `db:"include_inactive" json:"includeInactive"`
+}
+
+// Lists the keys, newest first. Revoked and expired keys are only included if include_inactive is true.
+func (q *Queries) ListAPIKeys(ctx context.Context, arg ListAPIKeysParams) ([]ApiKey, error) {
+	rows, err := q.db.Query(ctx, listAPIKeys, arg.OrgID, arg.IncludeInactive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ApiKey
+	for rows.Next() {
+		var i ApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.Prefix,
+			&i.KeyHash,
+			&i.Scopes,
+			&i.OrgID,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.RevokedAt,
+			&i.RotatedTo,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditChainHeads = `-- name: ListAuditChainHeads :many
+SELECT chain_id, seq, hash, checkpoint_seq, updated_at
+FROM audit_chain_heads
+ORDER BY chain_id
+`
+
+func (q *Queries) ListAuditChainHeads(ctx context.Context) ([]AuditChainHead, error) {
+	rows, err := q.db.Query(ctx, listAuditChainHeads)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditChainHead
+	for rows.Next() {
+		var i AuditChainHead
+		if err := rows.Scan(
+			&i.ChainID,
+			&i.Seq,
+			&i.Hash,
+			&i.CheckpointSeq,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditChainLogs = `-- name: ListAuditChainLogs :many
+SELECT id, org_id, user_id, action, resource_type, resource_id, ip_address, user_agent, metadata, created_at, chain_id, seq, prev_hash, hash
+FROM audit_logs
+WHERE chain_id = $1
+  AND seq > $2::bigint
+ORDER BY seq
+LIMIT $3
+`
+
+type ListAuditChainLogsParams struct {
+	ChainID  uuid.UUID `db:"chain_id" json:"chainId"`
+	AfterSeq int64     `db:"after_seq" json:"afterSeq"`
+	Limit    int32     `db:"limit" json:"limit"`
+}
+
+func (q *Queries) ListAuditChainLogs(ctx context.Context, arg ListAuditChainLogsParams) ([]AuditLog, error) {
+	rows, err := q.db.Query(ctx, listAuditChainLogs, arg.ChainID, arg.AfterSeq, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditLog
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrgID,
+			&i.UserID,
+			&i.Action,
+			&i.ResourceType,
+			&i.ResourceID,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.Metadata,
+			&i.CreatedAt,
+			&i.ChainID,
+			&i.Seq,
+			&i.PrevHash,
+			&i.Hash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditCheckpoints = `-- name: ListAuditCheckpoints :many
+SELECT id, chain_id, kind, seq, hash, deleted_count, key_id, signature, created_at
+FROM audit_checkpoints
+WHERE chain_id = $1
+ORDER BY seq,
+  created_at
+`
+
+func (q *Queries) ListAuditCheckpoints(ctx context.Context, chainID uuid.UUID) ([]AuditCheckpoint, error) {
+	rows, err := q.db.Query(ctx, listAuditCheckpoints, chainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditCheckpoint
+	for rows.Next() {
+		var i AuditCheckpoint
+		if err := rows.Scan(
+			&i.ID,
+			&i.ChainID,
+			&i.Kind,
+			&i.Seq,
+			&i.Hash,
+			&i.DeletedCount,
+			&i.KeyID,
+			&i.Signature,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditLogs = `-- name: ListAuditLogs :many
+SELECT id, org_id, user_id, action, resource_type, resource_id, ip_address, user_agent, metadata, created_at, chain_id, seq, prev_hash, hash
+FROM audit_logs
+WHERE (
+    $1::text [] IS NULL
+    OR action = ANY($1::text [])
+  )
+  AND (
+    $2::uuid IS NULL
+    OR user_id = $2
+  )
+  AND (
+    $3::text IS NULL
+    OR resource_type = $3
+  )
+  AND (
+    $4::uuid IS NULL
+    OR resource_id = $4
+  )
+  AND (
+    $5::cidr IS NULL
+    OR ip_address <<= $5
+  )
+  AND (
+    $6::timestamptz IS NULL
+    OR created_at >= $6
+  )
+  AND (
+    $7::timestamptz IS NULL
+    OR created_at < $7
+  )
+  AND (
+    $8::timestamptz IS NULL
+    OR (created_at, id) < (
+      $8,
+      $9::uuid
+    )
+  )
+ORDER BY created_at DESC,
+  id DESC
+LIMIT $10
+`
+
+type ListAuditLogsParams struct {
+	Actions         []string           `db:"actions" json:"actions"`
+	ActorID         *uuid.UUID         `db:"actor_id" json:"actorId"`
+	ResourceType    *string            `db:"resource_type" json:"resourceType"`
+	ResourceID      *uuid.UUID         `db:"resource_id" json:"resourceId"`
+	IpRange         *netip.Prefix      `db:"ip_range" json:"ipRange"`
+	From            pgtype.Timestamptz `db:"from" json:"from"`
+	To              pgtype.Timestamptz `db:"to" json:"to"`
+	CursorCreatedAt pgtype.Timestamptz `db:"cursor_created_at" json:"cursorCreatedAt"`
+	CursorID        *uuid.UUID         `db:"cursor_id" json:"cursorId"`
+	Limit           int32              `db:"limit" json:"limit"`
+}
+
+// Lists the audit logs of all organizations, for platform admins.
+func (q *Queries) ListAuditLogs(ctx context.Context, arg ListAuditLogsParams) ([]AuditLog, error) {
+	rows, err := q.db.Query(ctx, listAuditLogs,
+		arg.Actions,
+		arg.ActorID,
+		arg.ResourceType,
+		arg.ResourceID,
+		arg.IpRange,
+		arg.From,
+		arg.To,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []AuditLog{}
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrgID,
+			&i.UserID,
+			&i.Action,
+			&i.ResourceType,
+			&i.ResourceID,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.Metadata,
+			&i.CreatedAt,
+			&i.ChainID,
+			&i.Seq,
+			&i.PrevHash,
+			&i.Hash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuthzObjectIDs = `-- name: ListAuthzObjectIDs :many
+SELECT DISTINCT object_id
+FROM authz_tuples
+WHERE org_id = $1
+  AND object_namespace = $2
+ORDER BY object_id
+LIMIT $3
+`
+
+type ListAuthzObjectIDsParams struct {
+	OrgID           uuid.UUID `db:"org_id" json:"orgId"`
+	ObjectNamespace string    `db:"object_namespace" json:"objectNamespace"`
+	Limit           int32     `db:"limit" json:"limit"`
+}
+
+func (q *Queries) ListAuthzObjectIDs(ctx context.Context, arg ListAuthzObjectIDsParams) ([]string, error) {
+	rows, err := q.db.Query(ctx, listAuthzObjectIDs, arg.OrgID, arg.ObjectNamespace, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var object_id string
+		if err := rows.Scan(&object_id); err != nil {
+			return nil, err
+		}
+		items = append(items, object_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuthzTuplesForObject = `-- name: ListAuthzTuplesForObject :many
+SELECT org_id, object_namespace, object_id, relation, subject_namespace, subject_id, subject_relation, created_at
+FROM authz_tuples
+WHERE org_id = $1
+  AND object_namespace = $2
+  AND object_id = $3
+  AND relation = $4
+ORDER BY subject_namespace,
+  subject_id,
+  subject_relation
+`
+
+type ListAuthzTuplesForObjectParams struct {
+	OrgID           uuid.UUID `db:"org_id" json:"orgId"`
+	ObjectNamespace string    `db:"object_namespace" json:"objectNamespace"`
+	ObjectID        string    `db:"object_id" json:"objectId"`
+	Relation        string    `db:"relation" json:"relation"`
+}
+
+func (q *Queries) ListAuthzTuplesForObject(ctx context.Context, arg ListAuthzTuplesForObjectParams) ([]AuthzTuple, error) {
+	rows, err := q.db.Query(ctx, listAuthzTuplesForObject,
+		arg.OrgID,
+		arg.ObjectNamespace,
+		arg.ObjectID,
+		arg.Relation,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []AuthzTuple{}
+	for rows.Next() {
+		var i AuthzTuple
+		if err := rows.Scan(
+			&i.OrgID,
+			&i.ObjectNamespace,
+			&i.ObjectID,
+			&i.Relation,
+			&i.SubjectNamespace,
+			&i.SubjectID,
+			&i.SubjectRelation,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDeletedUsers = `-- name: ListDeletedUsers :many
+SELECT id
+FROM users
+WHERE deleted_at < $1
+ORDER BY deleted_at
+LIMIT $2
+`
+
+type ListDeletedUsersParams struct {
+	Before pgtype.Timestamptz `db:"before" json:"before"`
+	Limit  int32              `db:"limit" json:"limit"`
+}
+
+// Lists up to limit users which were soft deleted before the given time, to be erased.
+func (q *Queries) ListDeletedUsers(ctx context.Context, arg ListDeletedUsersParams) ([]uuid.UUID, error) {
+	rows, err := q.db.Query(ctx, listDeletedUsers, arg.Before, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDueUserErasures = `-- name: ListDueUserErasures :many
+SELECT id
+FROM users
+WHERE erasure_scheduled_at < $1
+ORDER BY erasure_scheduled_at
+LIMIT $2
+`
+
+type ListDueUserErasuresParams struct {
+	Before pgtype.Timestamptz `db:"before" json:"before"`
+	Limit  int32              `db:"limit" json:"limit"`
+}
+
+// Lists up to limit users whose erasure is scheduled before the given time.
+func (q *Queries) ListDueUserErasures(ctx context.Context, arg ListDueUserErasuresParams) ([]uuid.UUID, error) {
+	rows, err := q.db.Query(ctx, listDueUserErasures, arg.Before, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExpiredDataExports = `-- name: ListExpiredDataExports :many
+SELECT id, user_id, object_key, format, expires_at, created_at
+FROM data_exports
+WHERE expires_at < $1
+ORDER BY expires_at
+LIMIT $2
+`
+
+type ListExpiredDataExportsParams struct {
+	Before pgtype.Timestamptz `db:"before" json:"before"`
+	Limit  int32              `db:"limit" json:"limit"`
+}
+
+// Lists up to limit data exports which expired before the given time.
+func (q *Queries) ListExpiredDataExports(ctx context.Context, arg ListExpiredDataExportsParams) ([]DataExport, error) {
+	rows, err := q.db.Query(ctx, listExpiredDataExports, arg.Before, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []DataExport{}
+	for rows.Next() {
+		var i DataExport
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.ObjectKey,
+			&i.Format,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listNotificationJobs = `-- name: ListNotificationJobs :many
+SELECT id, idempotency_key, channel, kind, recipient, payload, status, attempts, next_attempt_at, expires_at, last_error, sent_at, created_at, updated_at
+FROM notification_jobs
+WHERE (
+    $1::text IS NULL
+    OR status = $1
+  )
+  AND (
+    $2::text IS NULL
+    OR recipient = $2
+  )
+  AND (
+    $3::timestamptz IS NULL
+    OR created_at < $3
+  )
+ORDER BY created_at DESC
+LIMIT $4
+`
+
+type ListNotificationJobsParams struct {
+	Status    *string            `db:"status" json:"status"`
+	Recipient *string            `db:"recipient" json:"recipient"`
+	Before    pgtype.Timestamptz `db:"before" json:"before"`
+	Limit     int32              `db:"limit" json:"limit"`
+}
+
+func (q *Queries) ListNotificationJobs(ctx context.Context, arg ListNotificationJobsParams) ([]NotificationJob, error) {
+	rows, err := q.db.Query(ctx, listNotificationJobs,
+		arg.Status,
+		arg.Recipient,
+		arg.Before,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []NotificationJob
+	for rows.Next() {
+		var i NotificationJob
+		if err := rows.Scan(
+			&i.ID,
+			&i.IdempotencyKey,
+			&i.Channel,
+			&i.Kind,
+			&i.Recipient,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.ExpiresAt,
+			&i.LastError,
+			&i.SentAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrgAuditLogs = `-- name: ListOrgAuditLogs :many
+SELECT id, org_id, user_id, action, resource_type, resource_id, ip_address, user_agent, metadata, created_at, chain_id, seq, prev_hash, hash
+FROM audit_logs
+WHERE org_id = $1
+  AND (
+    $2::text [] IS NULL
+    OR action = ANY($2::text [])
+  )
+  AND (
+    $3::uuid IS NULL
+    OR user_id = $3
+  )
+  AND (
+    $4::text IS NULL
+    OR resource_type = $4
+  )
+  AND (
+    $5::uuid IS NULL
+    OR resource_id = $5
+  )
+  AND (
+    $6::cidr IS NULL
+    OR ip_address <<= $6
+  )
+  AND (
+    $7::timestamptz IS NULL
+    OR created_at >= $7
+  )
+  AND (
+    $8::timestamptz IS NULL
+    OR created_at < $8
+  )
+  AND (
+    $9::timestamptz IS NULL
+    OR (created_at, id) < (
+      $9,
+      $10::uuid
+    )
+  )
+ORDER BY created_at DESC,
+  id DESC
+LIMIT $11
+`
+
+type ListOrgAuditLogsParams struct {
+	OrgID           *uuid.UUID         `db:"org_id" json:"orgId"`
+	Actions         []string           `db:"actions" json:"actions"`
+	ActorID         *uuid.UUID         `db:"actor_id" json:"actorId"`
+	ResourceType    *string            `db:"resource_type" json:"resourceType"`
+	ResourceID      *uuid.UUID         `db:"resource_id" json:"resourceId"`
+	IpRange         *netip.Prefix      `db:"ip_range" json:"ipRange"`
+	From            pgtype.Timestamptz `db:"from" json:"from"`
+	To              pgtype.Timestamptz `db:"to" json:"to"`
+	CursorCreatedAt pgtype.Timestamptz `db:"cursor_created_at" json:"cursorCreatedAt"`
+	CursorID        *uuid.UUID         `db:"cursor_id" json:"cursorId"`
+	Limit           int32              `db:"limit" json:"limit"`
+}
+
+func (q *Queries) ListOrgAuditLogs(ctx context.Context, arg ListOrgAuditLogsParams) ([]AuditLog, error) {
+	rows, err := q.db.Query(ctx, listOrgAuditLogs,
+		arg.OrgID,
+		arg.Actions,
+		arg.ActorID,
+		arg.ResourceType,
+		arg.ResourceID,
+		arg.IpRange,
+		arg.From,
+		arg.To,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []AuditLog{}
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrgID,
+			&i.UserID,
+			&i.Action,
+			&i.ResourceType,
+			&i.ResourceID,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.Metadata,
+			&i.CreatedAt,
+			&i.ChainID,
+			&i.Seq,
+			&i.PrevHash,
+			&i.Hash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrgRoles = `-- name: ListOrgRoles :many
+SELECT id, org_id, name, description, permissions, created_at, updated_at
+FROM org_roles
+WHERE org_id = $1
+ORDER BY name
+`
+
+func (q *Queries) ListOrgRoles(ctx context.Context, orgID uuid.UUID) ([]OrgRole, error) {
+	rows, err := q.db.Query(ctx, listOrgRoles, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []OrgRole{}
+	for rows.Next() {
+		var i OrgRole
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrgID,
+			&i.Name,
+			&i.Description,
+			&i.Permissions,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserDataExports = `-- name: ListUserDataExports :many
+SELECT id, user_id, object_key, format, expires_at, created_at
+FROM data_exports
+WHERE user_id = $1
+`
+
+func (q *Queries) ListUserDataExports(ctx context.Context, userID uuid.UUID) ([]DataExport, error) {
+	rows, err := q.db.Query(ctx, listUserDataExports, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []DataExport{}
+	for rows.Next() {
+		var i DataExport
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.ObjectKey,
+			&i.Format,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserMFAFactors = `-- name: ListUserMFAFactors :many
+SELECT id,
+  type,
+  name,
+  verified,
+  last_used_at,
+  created_at,
+  updated_at
+FROM mfa_factors
+WHERE user_id = $1
+ORDER BY created_at
+`
+
+type ListUserMFAFactorsRow struct {
+	ID         uuid.UUID          `db:"id" json:"id"`
+	Type       string             `db:"type" json:"type"`
+	Name       string             `db:"name" json:"name"`
+	Verified   bool               `db:"verified" json:"verified"`
+	LastUsedAt pgtype.Timestamptz `db:"last_used_at" json:"lastUsedAt"`
+	CreatedAt  pgtype.Timestamptz `db:"created_at" json:"createdAt"`
+	UpdatedAt  pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
+}
+
+// Lists the MFA factors of the user, without their secrets.
+func (q *Queries) ListUserMFAFactors(ctx context.Context, userID uuid.UUID) ([]ListUserMFAFactorsRow, error) {
+	rows, err := q.db.Query(ctx, listUserMFAFactors, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListUserMFAFactorsRow{}
+	for rows.Next() {
+		var i ListUserMFAFactorsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Type,
+			&i.Name,
+			&i.Verified,
+			&i.LastUsedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserOAuthIdentities = `-- name: ListUserOAuthIdentities :many
+SELECT id,
+  provider,
+  provider_user_id,
+  provider_user_email,
+  created_at,
+  updated_at
+FROM user_oauth_identities
+WHERE user_id = $1
+ORDER BY created_at
+`
+
+type ListUserOAuthIdentitiesRow struct {
+	ID                uuid.UUID          `db:"id" json:"id"`
+	Provider          string             `db:"provider" json:"provider"`
+	ProviderUserID    string             `db:"provider_user_id" json:"providerUserId"`
+	ProviderUserEmail string             `db:"provider_user_email" json:"providerUserEmail"`
+	CreatedAt         pgtype.Timestamptz `db:"created_at" json:"createdAt"`
+	UpdatedAt         pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
+}
+
+// Lists the OAuth identities linked to the user, without the data returned by the providers.
+func (q *Queries) ListUserOAuthIdentities(ctx context.Context, userID uuid.UUID) ([]ListUserOAuthIdentitiesRow, error) {
+	rows, err := q.db.Query(ctx, listUserOAuthIdentities, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListUserOAuthIdentitiesRow{}
+	for rows.Next() {
+		var i ListUserOAuthIdentitiesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Provider,
+			&i.ProviderUserID,
+			&i.ProviderUserEmail,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookDeliveries = `-- name: ListWebhookDeliveries :many
+SELECT id, endpoint_id, org_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_error, last_status_code, delivered_at, created_at, updated_at
+FROM webhook_deliveries
+WHERE endpoint_id = $1
+  AND org_id = $2
+  AND (
+    $3::text IS NULL
+    OR status = $3
+  )
+  AND (
+    $4::timestamptz IS NULL
+    OR created_at < $4
+  )
+ORDER BY created_at DESC
+LIMIT $5
+`
+
+type ListWebhookDeliveriesParams struct {
+	EndpointID uuid.UUID          `db:"endpoint_id" json:"endpointId"`
+	OrgID      uuid.UUID          `db:"org_id" json:"orgId"`
+	Status     *string            `db:"status" json:"status"`
+	Before     pgtype.Timestamptz `db:"before" json:"before"`
+	Limit      int32              `db:"limit" json:"limit"`
+}
+
+func (q *Queries) ListWebhookDeliveries(ctx context.Context, arg ListWebhookDeliveriesParams) ([]WebhookDelivery, error) {
+	rows, err := q.db.Query(ctx, listWebhookDeliveries,
+		arg.EndpointID,
+		arg.OrgID,
+		arg.Status,
+		arg.Before,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookDelivery
+	for rows.Next() {
+		var i WebhookDelivery
+		if err := rows.Scan(
+			&i.ID,
+			&i.EndpointID,
+			&i.OrgID,
+			&i.EventID,
+			&i.EventType,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastError,
+			&i.LastStatusCode,
+			&i.DeliveredAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookEndpoints = `-- name: ListWebhookEndpoints :many
+SELECT id, org_id, url, description, event_types, secret, enabled, created_at, updated_at
+FROM webhook_endpoints
+WHERE org_id = $1
+ORDER BY created_at
+`
+
+func (q *Queries) ListWebhookEndpoints(ctx context.Context, orgID uuid.UUID) ([]WebhookEndpoint, error) {
+	rows, err := q.db.Query(ctx, listWebhookEndpoints, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookEndpoint
+	for rows.Next() {
+		var i WebhookEndpoint
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrgID,
+			&i.Url,
+			&i.Description,
+			&i.EventTypes,
+			&i.Secret,
+			&i.Enabled,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookEndpointsForEvent = `-- name: ListWebhookEndpointsForEvent :many
+SELECT id, org_id, url, description, event_types, secret, enabled, created_at, updated_at
+FROM webhook_endpoints
+WHERE org_id = $1
+  AND enabled
+  AND (
+    cardinality(event_types) = 0
+    OR $2::text = ANY(event_types)
+  )
+`
+
+type ListWebhookEndpointsForEventParams struct {
+	OrgID     uuid.UUID `db:"org_id" json:"orgId"`
+	EventType string    `db:"event_type" json:"eventType"`
+}
+
+// Lists the enabled endpoints of the org which receive the event type.
+func (q *Queries) ListWebhookEndpointsForEvent(ctx context.Context, arg ListWebhookEndpointsForEventParams) ([]WebhookEndpoint, error) {
+	rows, err := q.db.Query(ctx, listWebhookEndpointsForEvent, arg.OrgID, arg.EventType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookEndpoint
+	for rows.Next() {
+		var i WebhookEndpoint
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrgID,
+			&i.Url,
+			&i.Description,
+			&i.EventTypes,
+			&i.Secret,
+			&i.Enabled,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockAuditChainHead = `-- name: LockAuditChainHead :one
+SELECT chain_id, seq, hash, checkpoint_seq, updated_at
+FROM audit_chain_heads
+WHERE chain_id = $1 FOR
+UPDATE
+`
+
+func (q *Queries) LockAuditChainHead(ctx context.Context, chainID uuid.UUID) (AuditChainHead, error) {
+	row := q.db.QueryRow(ctx, lockAuditChainHead, chainID)
+	var i AuditChainHead
+	err := row.Scan(
+		&i.ChainID,
+		&i.Seq,
+		&i.Hash,
+		&i.CheckpointSeq,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const markAPIKeyRotated = `-- name: MarkAPIKeyRotated :one
+UPDATE api_keys
+SET rotated_to = $1,
+  expires_at = LEAST(
+    coalesce(expires_at, 'infinity'),
+    $2::timestamptz
+  ),
+  updated_at = NOW()
+WHERE id = $3
+  AND revoked_at IS NULL
+  AND rotated_to IS NULL
+  AND (
+    expires_at IS NULL
+    OR expires_at > NOW()
+  )
+RETURNING id, name, description, prefix, key_hash, scopes, org_id, expires_at, last_used_at, revoked_at, rotated_to, created_by, created_at, updated_at
+`
+
+type MarkAPIKeyRotatedParams struct {
+	RotatedTo *uuid.UUID         `db:"rotated_to" json:"rotatedTo"`
+	ExpiresAt pgtype.Timestamptz `db:"expires_at" json:"expiresAt"`
+	ID        uuid.UUID          `db:"id" json:"id"`
+}
+
+// Marks an active key as replaced by a new key, and makes it expire at the end of the overlap window, unless it expires before.
+func (q *Queries) MarkAPIKeyRotated(ctx context.Context, arg MarkAPIKeyRotatedParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, markAPIKeyRotated, arg.RotatedTo, arg.ExpiresAt, arg.ID)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.OrgID,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+		&i.RotatedTo,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const markNotificationJobExpired = `-- name: MarkNotificationJobExpired :exec
+UPDATE notification_jobs
+SET status = 'expired',
+  payload = '{}',
+  updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) MarkNotificationJobExpired(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, markNotificationJobExpired, id)
+	return err
+}
+
+const markNotificationJobFailed = `-- name: MarkNotificationJobFailed :exec
+UPDATE notification_jobs
+SET status = $1,
+  attempts = attempts + 1,
+  next_attempt_at = $2,
+  last_error = $3,
+  updated_at = NOW()
+WHERE id = $4
+`
+
+type MarkNotificationJobFailedParams struct {
+	Status        string             `db:"status" json:"status"`
+	NextAttemptAt pgtype.Timestamptz `db:"next_attempt_at" json:"nextAttemptAt"`
+	LastError     *string            `db:"last_error" json:"lastError"`
+	ID            uuid.UUID          `db:"id" json:"id"`
+}
+
+func (q *Queries) MarkNotificationJobFailed(ctx context.Context, arg MarkNotificationJobFailedParams) error {
+	_, err := q.db.Exec(ctx, markNotificationJobFailed,
+		arg.Status,
+		arg.NextAttemptAt,
+		arg.LastError,
+		arg.ID,
+	)
+	return err
+}
+
+const markNotificationJobSent = `-- name: MarkNotificationJobSent :exec
+UPDATE notification_jobs
+SET status = 'sent',
+  attempts = attempts + 1,
+  payload = '{}',
+  last_error = NULL,
+  sent_at = NOW(),
+  updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) MarkNotificationJobSent(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, markNotificationJobSent, id)
+	return err
+}
+
+const markUserEmailVerified = `-- name: MarkUserEmailVerified :exec
+UPDATE users
+SET email_verified = TRUE,
+  updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) MarkUserEmailVerified(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, markUserEmailVerified, id)
+	return err
+}
+
+const markWebhookDeliveryDelivered = `-- name: MarkWebhookDeliveryDelivered :exec
+UPDATE webhook_deliveries
+SET status = 'delivered',
+  attempts = attempts + 1,
+  last_error = NULL,
+  last_status_code = $1,
+  delivered_at = NOW(),
+  updated_at = NOW()
+WHERE id = $2
+`
+
+type MarkWebhookDeliveryDeliveredParams struct {
+	StatusCode *int32    `db:"status_code" json:"statusCode"`
+	ID         uuid.UUID `db:"id" json:"id"`
+}
+
+func (q *Queries) MarkWebhookDeliveryDelivered(ctx context.Context, arg MarkWebhookDeliveryDeliveredParams) error {
+	_, err := q.db.Exec(ctx, markWebhookDeliveryDelivered, arg.StatusCode, arg.ID)
+	return err
+}
+
+const markWebhookDeliveryFailed = `-- name: MarkWebhookDeliveryFailed :exec
+UPDATE webhook_deliveries
+SET status = $1,
+  attempts = attempts + 1,
+  next_attempt_at = $2,
+  last_error = $3,
+  last_status_code = $4,
+  updated_at = NOW()
+WHERE id = $5
+`
+
+type MarkWebhookDeliveryFailedParams struct {
+	Status        string             `db:"status" json:"status"`
+	NextAttemptAt pgtype.Timestamptz `db:"next_attempt_at" json:"nextAttemptAt"`
+	LastError     *string            `db:"last_error" json:"lastError"`
+	StatusCode    *int32             `db:"status_code" json:"statusCode"`
+	ID            uuid.UUID          `db:"id" json:"id"`
+}
+
+func (q *Queries) MarkWebhookDeliveryFailed(ctx context.Context, arg MarkWebhookDeliveryFailedParams) error {
+	_, err := q.db.Exec(ctx, markWebhookDeliveryFailed,
+		arg.Status,
+		arg.NextAttemptAt,
+		arg.LastError,
+		arg.StatusCode,
+		arg.ID,
+	)
 	return err
 }
 
@@ -1038,10 +3372,11 @@ INSERT INTO verification_tokens (
     user_id,
     TYPE,
     token_hash,
-    expires_at
+    expires_at,
+    new_email
   )
-VALUES ($1, $2, $3, $4, $5)
-RETURNING id, user_id, type, token_hash, expires_at, created_at
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, user_id, type, token_hash, expires_at, created_at, new_email
 `
 
 type NewVerificationTokenParams struct {
@@ -1050,6 +3385,7 @@ type NewVerificationTokenParams struct {
 	Type      string             `db:"type" json:"type"`
 	TokenHash []byte             `db:"token_hash" json:"tokenHash"`
 	ExpiresAt pgtype.Timestamptz `db:"expires_at" json:"expiresAt"`
+	NewEmail  *string            `db:"new_email" json:"newEmail"`
 }
 
 func (q *Queries) NewVerificationToken(ctx context.Context, arg NewVerificationTokenParams) (VerificationToken, error) {
@@ -1059,6 +3395,7 @@ func (q *Queries) NewVerificationToken(ctx context.Context, arg NewVerificationT
 		arg.Type,
 		arg.TokenHash,
 		arg.ExpiresAt,
+		arg.NewEmail,
 	)
 	var i VerificationToken
 	err := row.Scan(
@@ -1068,6 +3405,313 @@ func (q *Queries) NewVerificationToken(ctx context.Context, arg NewVerificationT
 		&i.TokenHash,
 		&i.ExpiresAt,
 		&i.CreatedAt,
+		&i.NewEmail,
+	)
+	return i, err
+}
+
+const purgeDeletedOrgs = `-- name: PurgeDeletedOrgs :execrows
+DELETE FROM orgs
+WHERE id IN (
+    SELECT id
+    FROM orgs
+    WHERE deleted_at < $1
+      AND slug != 'default'
+    LIMIT $2
+  )
+`
+
+type PurgeDeletedOrgsParams struct {
+	Before pgtype.Timestamptz `db:"before" json:"before"`
+	Limit  int32              `db:"limit" json:"limit"`
+}
+
+// Deletes up to limit orgs which were soft deleted before the given time, with everything scoped to them.
+// The default org is never deleted.
+func (q *Queries) PurgeDeletedOrgs(ctx context.Context, arg PurgeDeletedOrgsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeDeletedOrgs, arg.Before, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeExpiredInvitations = `-- name: PurgeExpiredInvitations :execrows
+DELETE FROM invitations
+WHERE id IN (
+    SELECT id
+    FROM invitations
+    WHERE expires_at < $1
+    LIMIT $2
+  )
+`
+
+type PurgeExpiredInvitationsParams struct {
+	Before pgtype.Timestamptz `db:"before" json:"before"`
+	Limit  int32              `db:"limit" json:"limit"`
+}
+
+// Deletes up to limit invitations which expired before the given time, whatever their status.
+func (q *Queries) PurgeExpiredInvitations(ctx context.Context, arg PurgeExpiredInvitationsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeExpiredInvitations, arg.Before, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeExpiredOIDCAccessTokens = `-- name: PurgeExpiredOIDCAccessTokens :execrows
+DELETE FROM oidc_access_tokens
+WHERE id IN (
+    SELECT a.id
+    FROM oidc_access_tokens a
+    WHERE a.expires_at < $1
+      AND NOT EXISTS (
+        SELECT 1
+        FROM oidc_refresh_tokens r
+        WHERE r.access_token_id = a.id
+          AND r.expires_at >= $1
+      )
+    LIMIT $2
+  )
+`
+
+type PurgeExpiredOIDCAccessTokensParams struct {
+	Before pgtype.Timestamptz `db:"before" json:"before"`
+	Limit  int32              `db:"limit" json:"limit"`
+}
+
+// Deletes up to limit OIDC access tokens which expired before the given time.
+// Tokens with a refresh token which has not expired are kept, since deleting them would delete the refresh token.
+func (q *Queries) PurgeExpiredOIDCAccessTokens(ctx context.Context, arg PurgeExpiredOIDCAccessTokensParams) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeExpiredOIDCAccessTokens, arg.Before, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeExpiredOIDCAuthCodes = `-- name: PurgeExpiredOIDCAuthCodes :execrows
+DELETE FROM oidc_auth_codes
+WHERE id IN (
+    SELECT id
+    FROM oidc_auth_codes
+    WHERE expires_at < $1
+    LIMIT $2
+  )
+`
+
+type PurgeExpiredOIDCAuthCodesParams struct {
+	Before pgtype.Timestamptz `db:"before" json:"before"`
+	Limit  int32              `db:"limit" json:"limit"`
+}
+
+// Deletes up to limit OIDC authorization codes which expired before the given time.
+func (q *Queries) PurgeExpiredOIDCAuthCodes(ctx context.Context, arg PurgeExpiredOIDCAuthCodesParams) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeExpiredOIDCAuthCodes, arg.Before, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeExpiredOIDCRefreshTokens = `-- name: PurgeExpiredOIDCRefreshTokens :execrows
+DELETE FROM oidc_refresh_tokens
+WHERE id IN (
+    SELECT id
+    FROM oidc_refresh_tokens
+    WHERE expires_at < $1
+    LIMIT $2
+  )
+`
+
+type PurgeExpiredOIDCRefreshTokensParams struct {
+	Before pgtype.Timestamptz `db:"before" json:"before"`
+	Limit  int32              `db:"limit" json:"limit"`
+}
+
+// Deletes up to limit OIDC refresh tokens which expired before the given time.
+func (q *Queries) PurgeExpiredOIDCRefreshTokens(ctx context.Context, arg PurgeExpiredOIDCRefreshTokensParams) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeExpiredOIDCRefreshTokens, arg.Before, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeExpiredSessions = `-- name: PurgeExpiredSessions :execrows
+DELETE FROM sessions
+WHERE id IN (
+    SELECT id
+    FROM sessions
+    WHERE expires_at < $1
+    LIMIT $2
+  )
+`
+
+type PurgeExpiredSessionsParams struct {
+	Before pgtype.Timestamptz `db:"before" json:"before"`
+	Limit  int32              `db:"limit" json:"limit"`
+}
+
+// Deletes up to limit sessions which expired before the given time.
+func (q *Queries) PurgeExpiredSessions(ctx context.Context, arg PurgeExpiredSessionsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeExpiredSessions, arg.Before, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeExpiredVerificationTokens = `-- name: PurgeExpiredVerificationTokens :execrows
+DELETE FROM verification_tokens
+WHERE id IN (
+    SELECT id
+    FROM verification_tokens
+    WHERE expires_at < $1
+    LIMIT $2
+  )
+`
+
+type PurgeExpiredVerificationTokensParams struct {
+	Before pgtype.Timestamptz `db:"before" json:"before"`
+	Limit  int32              `db:"limit" json:"limit"`
+}
+
+// Deletes up to limit verification tokens which expired before the given time.
+func (q *Queries) PurgeExpiredVerificationTokens(ctx context.Context, arg PurgeExpiredVerificationTokensParams) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeExpiredVerificationTokens, arg.Before, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeInactiveAPIKeys = `-- name: PurgeInactiveAPIKeys :execrows
+DELETE FROM api_keys
+WHERE id IN (
+    SELECT id
+    FROM api_keys
+    WHERE revoked_at < $1
+      OR expires_at < $1
+    LIMIT $2
+  )
+`
+
+type PurgeInactiveAPIKeysParams struct {
+	Before pgtype.Timestamptz `db:"before" json:"before"`
+	Limit  int32              `db:"limit" json:"limit"`
+}
+
+// Deletes up to limit API keys which were revoked or expired before the given time.
+func (q *Queries) PurgeInactiveAPIKeys(ctx context.Context, arg PurgeInactiveAPIKeysParams) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeInactiveAPIKeys, arg.Before, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeNotificationJobs = `-- name: PurgeNotificationJobs :execrows
+DELETE FROM notification_jobs
+WHERE id IN (
+    SELECT id
+    FROM notification_jobs
+    WHERE status != 'pending'
+      AND created_at < $1
+    LIMIT $2
+  )
+`
+
+type PurgeNotificationJobsParams struct {
+	Before pgtype.Timestamptz `db:"before" json:"before"`
+	Limit  int32              `db:"limit" json:"limit"`
+}
+
+// Deletes up to limit notification jobs which are no longer pending, created before the given time.
+func (q *Queries) PurgeNotificationJobs(ctx context.Context, arg PurgeNotificationJobsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeNotificationJobs, arg.Before, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeWebhookDeliveries = `-- name: PurgeWebhookDeliveries :execrows
+DELETE FROM webhook_deliveries
+WHERE id IN (
+    SELECT id
+    FROM webhook_deliveries
+    WHERE status IN ('delivered', 'dead')
+      AND created_at < $1
+    LIMIT $2
+  )
+`
+
+type PurgeWebhookDeliveriesParams struct {
+	Before pgtype.Timestamptz `db:"before" json:"before"`
+	Limit  int32              `db:"limit" json:"limit"`
+}
+
+// Deletes up to limit webhook deliveries which were delivered or are dead, created before the given time.
+func (q *Queries) PurgeWebhookDeliveries(ctx context.Context, arg PurgeWebhookDeliveriesParams) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeWebhookDeliveries, arg.Before, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const redactUserWebhookDeliveries = `-- name: RedactUserWebhookDeliveries :exec
+UPDATE webhook_deliveries
+SET payload = jsonb_set(payload, '{data}', (payload->'data') - 'email'),
+  updated_at = NOW()
+WHERE payload->'data'->>'userId' = $1::text
+  AND payload->'data'->>'email' IS NOT NULL
+`
+
+// Removes the email from the payloads of the webhook deliveries of the user, eg. user.created, when they are erased.
+func (q *Queries) RedactUserWebhookDeliveries(ctx context.Context, userID string) error {
+	_, err := q.db.Exec(ctx, redactUserWebhookDeliveries, userID)
+	return err
+}
+
+const redeliverWebhookDelivery = `-- name: RedeliverWebhookDelivery :one
+UPDATE webhook_deliveries
+SET status = 'pending',
+  attempts = 0,
+  next_attempt_at = NOW(),
+  updated_at = NOW()
+WHERE id = $1
+  AND endpoint_id = $2
+  AND org_id = $3
+RETURNING id, endpoint_id, org_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_error, last_status_code, delivered_at, created_at, updated_at
+`
+
+type RedeliverWebhookDeliveryParams struct {
+	ID         uuid.UUID `db:"id" json:"id"`
+	EndpointID uuid.UUID `db:"endpoint_id" json:"endpointId"`
+	OrgID      uuid.UUID `db:"org_id" json:"orgId"`
+}
+
+func (q *Queries) RedeliverWebhookDelivery(ctx context.Context, arg RedeliverWebhookDeliveryParams) (WebhookDelivery, error) {
+	row := q.db.QueryRow(ctx, redeliverWebhookDelivery, arg.ID, arg.EndpointID, arg.OrgID)
+	var i WebhookDelivery
+	err := row.Scan(
+		&i.ID,
+		&i.EndpointID,
+		&i.OrgID,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.LastError,
+		&i.LastStatusCode,
+		&i.DeliveredAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
@@ -1131,6 +3775,71 @@ func (q *Queries) RemoveDomainFromOrg(ctx context.Context, arg RemoveDomainFromO
 	return err
 }
 
+const retryNotificationJob = `-- name: RetryNotificationJob :one
+UPDATE notification_jobs
+SET status = 'pending',
+  attempts = 0,
+  next_attempt_at = NOW(),
+  updated_at = NOW()
+WHERE id = $1
+  AND status = 'dead'
+RETURNING id, idempotency_key, channel, kind, recipient, payload, status, attempts, next_attempt_at, expires_at, last_error, sent_at, created_at, updated_at
+`
+
+// Schedules a dead job to be sent again immediately, with a new set of attempts.
+func (q *Queries) RetryNotificationJob(ctx context.Context, id uuid.UUID) (NotificationJob, error) {
+	row := q.db.QueryRow(ctx, retryNotificationJob, id)
+	var i NotificationJob
+	err := row.Scan(
+		&i.ID,
+		&i.IdempotencyKey,
+		&i.Channel,
+		&i.Kind,
+		&i.Recipient,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.ExpiresAt,
+		&i.LastError,
+		&i.SentAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const revokeAPIKey = `-- name: RevokeAPIKey :one
+UPDATE api_keys
+SET revoked_at = NOW(),
+  updated_at = NOW()
+WHERE id = $1
+  AND revoked_at IS NULL
+RETURNING id, name, description, prefix, key_hash, scopes, org_id, expires_at, last_used_at, revoked_at, rotated_to, created_by, created_at, updated_at
+`
+
+func (q *Queries) RevokeAPIKey(ctx context.Context, id uuid.UUID) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, revokeAPIKey, id)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.OrgID,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+		&i.RotatedTo,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const revokeInvitation = `-- name: RevokeInvitation :exec
 DELETE FROM invitations
 WHERE id = $1
@@ -1167,6 +3876,46 @@ func (q *Queries) RevokeInvitationByToken(ctx context.Context, token string) err
 	return err
 }
 
+const scheduleUserErasure = `-- name: ScheduleUserErasure :one
+UPDATE users
+SET erasure_scheduled_at = coalesce(
+    erasure_scheduled_at,
+    $1
+  ),
+  updated_at = NOW()
+WHERE id = $2
+RETURNING erasure_scheduled_at
+`
+
+type ScheduleUserErasureParams struct {
+	ErasureScheduledAt pgtype.Timestamptz `db:"erasure_scheduled_at" json:"erasureScheduledAt"`
+	ID                 uuid.UUID          `db:"id" json:"id"`
+}
+
+// Schedules the erasure of the user at the given time, unless it is already scheduled, and returns the time of the erasure.
+func (q *Queries) ScheduleUserErasure(ctx context.Context, arg ScheduleUserErasureParams) (pgtype.Timestamptz, error) {
+	row := q.db.QueryRow(ctx, scheduleUserErasure, arg.ErasureScheduledAt, arg.ID)
+	var erasure_scheduled_at pgtype.Timestamptz
+	err := row.Scan(&erasure_scheduled_at)
+	return erasure_scheduled_at, err
+}
+
+const setAuditChainCheckpoint = `-- name: SetAuditChainCheckpoint :exec
+UPDATE audit_chain_heads
+SET checkpoint_seq = $1
+WHERE chain_id = $2
+`
+
+type SetAuditChainCheckpointParams struct {
+	CheckpointSeq int64     `db:"checkpoint_seq" json:"checkpointSeq"`
+	ChainID       uuid.UUID `db:"chain_id" json:"chainId"`
+}
+
+func (q *Queries) SetAuditChainCheckpoint(ctx context.Context, arg SetAuditChainCheckpointParams) error {
+	_, err := q.db.Exec(ctx, setAuditChainCheckpoint, arg.CheckpointSeq, arg.ChainID)
+	return err
+}
+
 const setUserBackupCodes = `-- name: SetUserBackupCodes :exec
 UPDATE users
 SET backup_codes = $1,
@@ -1209,6 +3958,22 @@ func (q *Queries) SoftDeleteUser(ctx context.Context, email string) error {
 	return err
 }
 
+const touchAPIKey = `-- name: TouchAPIKey :exec
+UPDATE api_keys
+SET last_used_at = NOW()
+WHERE id = $1
+  AND (
+    last_used_at IS NULL
+    OR last_used_at < NOW() - INTERVAL '1 minute'
+  )
+`
+
+// Records the use of the key. last_used_at is updated at most once per minute, to limit the writes.
+func (q *Queries) TouchAPIKey(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, touchAPIKey, id)
+	return err
+}
+
 const unlinkUserFromOrg = `-- name: UnlinkUserFromOrg :exec
 DELETE FROM user_orgs
 WHERE user_id = $1
@@ -1225,6 +3990,25 @@ func (q *Queries) UnlinkUserFromOrg(ctx context.Context, arg UnlinkUserFromOrgPa
 	return err
 }
 
+const updateAuditChainHead = `-- name: UpdateAuditChainHead :exec
+UPDATE audit_chain_heads
+SET seq = $1,
+  hash = $2,
+  updated_at = NOW()
+WHERE chain_id = $3
+`
+
+type UpdateAuditChainHeadParams struct {
+	Seq     int64     `db:"seq" json:"seq"`
+	Hash    []byte    `db:"hash" json:"hash"`
+	ChainID uuid.UUID `db:"chain_id" json:"chainId"`
+}
+
+func (q *Queries) UpdateAuditChainHead(ctx context.Context, arg UpdateAuditChainHeadParams) error {
+	_, err := q.db.Exec(ctx, updateAuditChainHead, arg.Seq, arg.Hash, arg.ChainID)
+	return err
+}
+
 const updateOrg = `-- name: UpdateOrg :one
 UPDATE orgs
 SET name = coalesce($1, name),
@@ -1267,6 +4051,43 @@ func (q *Queries) UpdateOrg(ctx context.Context, arg UpdateOrgParams) (Org, erro
 	return i, err
 }
 
+const updateOrgRole = `-- name: UpdateOrgRole :one
+UPDATE org_roles
+SET description = coalesce($1, description),
+  permissions = coalesce($2, permissions),
+  updated_at = NOW()
+WHERE org_id = $3
+  AND name = $4
+RETURNING id, org_id, name, description, permissions, created_at, updated_at
+`
+
+type UpdateOrgRoleParams struct {
+	Description *string   `db:"description" json:"description"`
+	Permissions []string  `db:"permissions" json:"permissions"`
+	OrgID       uuid.UUID `db:"org_id" json:"orgId"`
+	Name        string    `db:"name" json:"name"`
+}
+
+func (q *Queries) UpdateOrgRole(ctx context.Context, arg UpdateOrgRoleParams) (OrgRole, error) {
+	row := q.db.QueryRow(ctx, updateOrgRole,
+		arg.Description,
+		arg.Permissions,
+		arg.OrgID,
+		arg.Name,
+	)
+	var i OrgRole
+	err := row.Scan(
+		&i.ID,
+		&i.OrgID,
+		&i.Name,
+		&i.Description,
+		&i.Permissions,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const updateOrgWhereSlug = `-- name: UpdateOrgWhereSlug :one
 UPDATE orgs
 SET name = coalesce($1, name),
@@ -1343,49 +4164,55 @@ func (q *Queries) UpdateSessionMFA(ctx context.Context, arg UpdateSessionMFAPara
 	return i, err
 }
 
-const updateUser = `-- name: UpdateUser :one
+const updateUserByID = `-- name: UpdateUserByID :one
 UPDATE users
 SET first_name = coalesce($1, first_name),
   last_name = coalesce($2, last_name),
   avatar_url = coalesce($3, avatar_url),
+  locale = coalesce($4, locale),
   updated_at = NOW()
-WHERE email = $4
+WHERE id = $5
 RETURNING id,
   email,
   email_verified,
   first_name,
   last_name,
   avatar_url,
+  locale,
   created_at,
   updated_at
 `
 
-type UpdateUserParams struct {
-	FirstName *string `db:"first_name" json:"firstName"`
-	LastName  *string `db:"last_name" json:"lastName"`
-	AvatarUrl *string `db:"avatar_url" json:"avatarUrl"`
-	Email     string  `db:"email" json:"email"`
+type UpdateUserByIDParams struct {
+	FirstName *string   `db:"first_name" json:"firstName"`
+	LastName  *string   `db:"last_name" json:"lastName"`
+	AvatarUrl *string   `db:"avatar_url" json:"avatarUrl"`
+	Locale    *string   `db:"locale" json:"locale"`
+	ID        uuid.UUID `db:"id" json:"id"`
 }
 
-type UpdateUserRow struct {
+type UpdateUserByIDRow struct {
 	ID            uuid.UUID          `db:"id" json:"id"`
 	Email         string             `db:"email" json:"email"`
 	EmailVerified bool               `db:"email_verified" json:"emailVerified"`
 	FirstName     *string            `db:"first_name" json:"firstName"`
 	LastName      *string            `db:"last_name" json:"lastName"`
 	AvatarUrl     *string            `db:"avatar_url" json:"avatarUrl"`
+	Locale        *string            `db:"locale" json:"locale"`
 	CreatedAt     pgtype.Timestamptz `db:"created_at" json:"createdAt"`
 	UpdatedAt     pgtype.Timestamptz `db:"updated_at" json:"updatedAt"`
 }
 
-func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (UpdateUserRow, error) {
-	row := q.db.QueryRow(ctx, updateUser,
+// Updates the profile of a user. The user is identified by ID, so that a concurrent change of the email does not make the update miss.
+func (q *Queries) UpdateUserByID(ctx context.Context, arg UpdateUserByIDParams) (UpdateUserByIDRow, error) {
+	row := q.db.QueryRow(ctx, updateUserByID,
 		arg.FirstName,
 		arg.LastName,
 		arg.AvatarUrl,
-		arg.Email,
+		arg.Locale,
+		arg.ID,
 	)
-	var i UpdateUserRow
+	var i UpdateUserByIDRow
 	err := row.Scan(
 		&i.ID,
 		&i.Email,
@@ -1393,12 +4220,31 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (UpdateU
 		&i.FirstName,
 		&i.LastName,
 		&i.AvatarUrl,
+		&i.Locale,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
 	return i, err
 }
 
+const updateUserOrgRole = `-- name: UpdateUserOrgRole :exec
+UPDATE user_orgs
+SET role = $1
+WHERE user_id = $2
+  AND org_id = $3
+`
+
+type UpdateUserOrgRoleParams struct {
+	Role   string    `db:"role" json:"role"`
+	UserID uuid.UUID `db:"user_id" json:"userId"`
+	OrgID  uuid.UUID `db:"org_id" json:"orgId"`
+}
+
+func (q *Queries) UpdateUserOrgRole(ctx context.Context, arg UpdateUserOrgRoleParams) error {
+	_, err := q.db.Exec(ctx, updateUserOrgRole, arg.Role, arg.UserID, arg.OrgID)
+	return err
+}
+
 const updateUserPassword = `-- name: UpdateUserPassword :exec
 UPDATE users
 SET password_hash = $1,
@@ -1449,3 +4295,92 @@ func (q *Queries) UpdateUserSessionAgentAndIP(ctx context.Context, arg UpdateUse
 	)
 	return i, err
 }
+
+const updateWebhookEndpoint = `-- name: UpdateWebhookEndpoint :one
+UPDATE webhook_endpoints
+SET url = $1,
+  description = $2,
+  event_types = $3,
+  enabled = $4,
+  updated_at = NOW()
+WHERE id = $5
+  AND org_id = $6
+RETURNING id, org_id, url, description, event_types, secret, enabled, created_at, updated_at
+`
+
+type UpdateWebhookEndpointParams struct {
+	Url         string    `db:"url" json:"url"`
+	Description *string   `db:"description" json:"description"`
+	EventTypes  []string  `db:"event_types" json:"eventTypes"`
+	Enabled     bool      `db:"enabled" json:"enabled"`
+	ID          uuid.UUID `db:"id" json:"id"`
+	OrgID       uuid.UUID `db:"org_id" json:"orgId"`
+}
+
+func (q *Queries) UpdateWebhookEndpoint(ctx context.Context, arg UpdateWebhookEndpointParams) (WebhookEndpoint, error) {
+	row := q.db.QueryRow(ctx, updateWebhookEndpoint,
+		arg.Url,
+		arg.Description,
+		arg.EventTypes,
+		arg.Enabled,
+		arg.ID,
+		arg.OrgID,
+	)
+	var i WebhookEndpoint
+	err := row.Scan(
+		&i.ID,
+		&i.OrgID,
+		&i.Url,
+		&i.Description,
+		&i.EventTypes,
+		&i.Secret,
+		&i.Enabled,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const writeAuthzTuple = `-- name: WriteAuthzTuple :exec
+INSERT INTO authz_tuples (
+    org_id,
+    object_namespace,
+    object_id,
+    relation,
+    subject_namespace,
+    subject_id,
+    subject_relation
+  )
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+  ) ON CONFLICT DO NOTHING
+`
+
+type WriteAuthzTupleParams struct {
+	OrgID            uuid.UUID `db:"org_id" json:"orgId"`
+	ObjectNamespace  string    `db:"object_namespace" json:"objectNamespace"`
+	ObjectID         string    `db:"object_id" json:"objectId"`
+	Relation         string    `db:"relation" json:"relation"`
+	SubjectNamespace string    `db:"subject_namespace" json:"subjectNamespace"`
+	SubjectID        string    `db:"subject_id" json:"subjectId"`
+	SubjectRelation  string    `db:"subject_relation" json:"subjectRelation"`
+}
+
+func (q *Queries) WriteAuthzTuple(ctx context.Context, arg WriteAuthzTupleParams) error {
+	_, err := q.db.Exec(ctx, writeAuthzTuple,
+		arg.OrgID,
+		arg.ObjectNamespace,
+		arg.ObjectID,
+		arg.Relation,
+		arg.SubjectNamespace,
+		arg.SubjectID,
+		arg.SubjectRelation,
+	)
+	return err
+}
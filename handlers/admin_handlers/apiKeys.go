@@ -0,0 +1,500 @@
+package admin_handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/middlewares"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/internal/tokens"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// defaultAPIKeyRotationOverlap is the default time during which a rotated key keeps working, so that clients can switch to the new key.
+const defaultAPIKeyRotationOverlap = 24 * time.Hour
+
+type APIKeysHandler struct {
+	ListAPIKeysCounter  *prometheus.CounterVec
+	CreateAPIKeyCounter *prometheus.CounterVec
+	RotateAPIKeyCounter *prometheus.CounterVec
+	RevokeAPIKeyCounter *prometheus.CounterVec
+}
+
+func NewAPIKeysHandler() *APIKeysHandler {
+	return &APIKeysHandler{
+		ListAPIKeysCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "admin",
+				Name:      "api_keys_list_requests_total",
+				Help:      "Total number of admin API key list requests",
+			},
+			[]string{"status"},
+		),
+		CreateAPIKeyCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "admin",
+				Name:      "api_keys_create_requests_total",
+				Help:      "Total number of admin API key create requests",
+			},
+			[]string{"status"},
+		),
+		RotateAPIKeyCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "admin",
+				Name:      "api_keys_rotate_requests_total",
+				Help:      "Total number of admin API key rotate requests",
+			},
+			[]string{"status"},
+		),
+		RevokeAPIKeyCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "admin",
+				Name:      "api_keys_revoke_requests_total",
+				Help:      "Total number of admin API key revoke requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+func (h *APIKeysHandler) Register(engine *gin.Engine) {
+	metrics.Collectors = append(metrics.Collectors, h.ListAPIKeysCounter, h.CreateAPIKeyCounter, h.RotateAPIKeyCounter, h.RevokeAPIKeyCounter)
+	engine.GET("/api/admin/api-keys", middlewares.RequireAuth(middlewares.AuthModeAdmin), h.HandleListAPIKeys)
+	engine.POST("/api/admin/api-keys", middlewares.RequireAuth(middlewares.AuthModeAdmin), h.HandleCreateAPIKey)
+	engine.POST("/api/admin/api-keys/:keyId/rotate", middlewares.RequireAuth(middlewares.AuthModeAdmin), h.HandleRotateAPIKey)
+	engine.DELETE("/api/admin/api-keys/:keyId", middlewares.RequireAuth(middlewares.AuthModeAdmin), h.HandleRevokeAPIKey)
+}
+
+// APIKeyResult is an API key stored in the database. The key itself is only returned when it is created.
+type APIKeyResult struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description *string  `json:"description,omitempty"`
+	Prefix      string   `json:"prefix"`
+	Scopes      []string `json:"scopes"`
+	// OrgID is the organization the key is bound to, empty if it can be used for every organization.
+	OrgID *string `json:"orgId,omitempty"`
+	// Status is "active", "expired" or "revoked".
+	Status     string     `json:"status"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	// RotatedTo is the ID of the key which replaced this key, if it was rotated.
+	RotatedTo *string   `json:"rotatedTo,omitempty"`
+	CreatedBy *string   `json:"createdBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CreatedAPIKeyResult is a new API key, with the key itself. The key cannot be retrieved again.
+type CreatedAPIKeyResult struct {
+	APIKeyResult
+	Key string `json:"key"`
+}
+
+type ListAPIKeysParams struct {
+	// OrgID only lists the keys bound to this organization.
+	OrgID string `form:"orgId" binding:"omitempty,uuid"`
+	// IncludeInactive also lists the revoked and expired keys.
+	IncludeInactive bool `form:"includeInactive"`
+}
+
+type ListAPIKeysResult struct {
+	Keys []APIKeyResult `json:"keys"`
+}
+
+type CreateAPIKeyData struct {
+	Name        string `json:"name" binding:"required,max=100"`
+	Description string `json:"description,omitempty" binding:"omitempty,max=500"`
+	// Scopes are the route groups the key can call: "auth", "orgs", "tenant" or "admin".
+	Scopes []string `json:"scopes" binding:"required,min=1,unique,dive,oneof=auth orgs tenant admin"`
+	// OrgID binds the key to an organization, so that it cannot be used for other organizations.
+	// Keys bound to an organization cannot have the "admin" scope.
+	OrgID string `json:"orgId,omitempty" binding:"omitempty,uuid"`
+	// ExpiresAt is the time the key expires at, the key does not expire if not set.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+type RotateAPIKeyData struct {
+	// OverlapSeconds is the time, in seconds, during which the old key keeps working, default 86400 (24 hours), max 30 days.
+	// Set to 0 to disable the old key immediately.
+	OverlapSeconds *int `json:"overlapSeconds,omitempty" binding:"omitempty,min=0,max=2592000"`
+}
+
+func newAPIKeyResult(key db.ApiKey) APIKeyResult {
+	result := APIKeyResult{
+		ID:          key.ID.String(),
+		Name:        key.Name,
+		Description: key.Description,
+		Prefix:      key.Prefix,
+		Scopes:      key.Scopes,
+		Status:      "active",
+		CreatedBy:   key.CreatedBy,
+		CreatedAt:   key.CreatedAt.Time,
+	}
+	if key.OrgID != nil {
+		orgId := key.OrgID.String()
+		result.OrgID = &orgId
+	}
+	if key.RotatedTo != nil {
+		rotatedTo := key.RotatedTo.String()
+		result.RotatedTo = &rotatedTo
+	}
+	if key.ExpiresAt.Valid {
+		result.ExpiresAt = &key.ExpiresAt.Time
+		if time.Now().After(key.ExpiresAt.Time) {
+			result.Status = "expired"
+		}
+	}
+	if key.LastUsedAt.Valid {
+		result.LastUsedAt = &key.LastUsedAt.Time
+	}
+	if key.RevokedAt.Valid {
+		result.RevokedAt = &key.RevokedAt.Time
+		result.Status = "revoked"
+	}
+	return result
+}
+
+// HandleListAPIKeys godoc
+// @Summary List API keys
+// @Description Lists the API keys stored in the database, newest first. The keys of the config file are not listed.
+// @Tags Admin
+// @Produce json
+// @Param orgId query string false "Only the keys bound to this organization"
+// @Param includeInactive query bool false "Also list the revoked and expired keys"
+// @Success 200 {object} ListAPIKeysResult "API keys"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid filter"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/admin/api-keys [get]
+func (h *APIKeysHandler) HandleListAPIKeys(c *gin.Context) {
+	h.ListAPIKeysCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "list_admin_api_keys")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	middlewares.AdminInactivityReset(c) // Reset inactivity timer
+
+	var params ListAPIKeysParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid query parameters!", "Failed to bind query parameters", http.StatusBadRequest, nil), span, log, h.ListAPIKeysCounter, "list_admin_api_keys")
+		return
+	}
+
+	query := db.ListAPIKeysParams{
+		IncludeInactive: params.IncludeInactive,
+	}
+	if params.OrgID != "" {
+		orgId := uuid.MustParse(params.OrgID)
+		query.OrgID = &orgId
+	}
+
+	keys, err := store.Querier.ListAPIKeys(ctx, query)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to list API keys!", http.StatusInternalServerError, err), span, log, h.ListAPIKeysCounter, "list_admin_api_keys")
+		return
+	}
+
+	result := ListAPIKeysResult{
+		Keys: make([]APIKeyResult, 0, len(keys)),
+	}
+	for _, key := range keys {
+		result.Keys = append(result.Keys, newAPIKeyResult(key))
+	}
+
+	h.ListAPIKeysCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, result)
+}
+
+// HandleCreateAPIKey godoc
+// @Summary Create API key
+// @Description Creates an API key with the given scopes. The key is only returned in the response, store it safely.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param data body CreateAPIKeyData true "API key"
+// @Success 201 {object} CreatedAPIKeyResult "Created API key"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid data"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 404 {object} models.ErrorResponse "Organization not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/admin/api-keys [post]
+func (h *APIKeysHandler) HandleCreateAPIKey(c *gin.Context) {
+	h.CreateAPIKeyCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "create_admin_api_key")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	middlewares.AdminInactivityReset(c) // Reset inactivity timer
+
+	var data CreateAPIKeyData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid request body!", "Failed to bind request body", http.StatusBadRequest, err), span, log, h.CreateAPIKeyCounter, "create_admin_api_key")
+		return
+	}
+	if data.ExpiresAt != nil && !data.ExpiresAt.After(time.Now()) {
+		utils.ProcessError(c, models.NewErrorResponse("The expiry must be in the future!", "expiresAt is in the past", http.StatusBadRequest, nil), span, log, h.CreateAPIKeyCounter, "create_admin_api_key")
+		return
+	}
+
+	params := db.CreateAPIKeyParams{
+		Name:   data.Name,
+		Scopes: data.Scopes,
+	}
+	if data.Description != "" {
+		params.Description = &data.Description
+	}
+	if data.ExpiresAt != nil {
+		params.ExpiresAt = pgtype.Timestamptz{Time: *data.ExpiresAt, Valid: true}
+	}
+	if data.OrgID != "" {
+		if slices.Contains(data.Scopes, middlewares.APIKeyScopeAdmin) {
+			utils.ProcessError(c, models.NewErrorResponse("Keys bound to an organization cannot have the admin scope!", "admin scope with orgId", http.StatusBadRequest, nil), span, log, h.CreateAPIKeyCounter, "create_admin_api_key")
+			return
+		}
+		org, err := store.Querier.GetOrgByID(ctx, uuid.MustParse(data.OrgID))
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.ProcessError(c, models.NewErrorResponse("Organization not found!", "No organization found with the given ID", http.StatusNotFound, nil), span, log, h.CreateAPIKeyCounter, "create_admin_api_key")
+			return
+		}
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get organization!", http.StatusInternalServerError, err), span, log, h.CreateAPIKeyCounter, "create_admin_api_key")
+			return
+		}
+		params.OrgID = &org.ID
+	}
+	if email := c.GetString(middlewares.CtxAdminEmail); email != "" {
+		params.CreatedBy = &email
+	}
+
+	created, errResp := createAPIKey(c, store.Querier, params)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.CreateAPIKeyCounter, "create_admin_api_key")
+		return
+	}
+
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionAPIKeyCreated,
+		OrgID:      params.OrgID,
+		ResourceID: &params.ID,
+		Metadata: map[string]any{
+			"email":  c.GetString(middlewares.CtxAdminEmail),
+			"name":   created.Name,
+			"prefix": created.Prefix,
+			"scopes": created.Scopes,
+		},
+	})
+
+	log.Debug("API key created", zap.String("prefix", created.Prefix))
+	h.CreateAPIKeyCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusCreated, created)
+}
+
+// HandleRotateAPIKey godoc
+// @Summary Rotate API key
+// @Description Replaces an active API key with a new key, with the same name, scopes, organization and expiry.
+// @Description The old key keeps working during the overlap window, so that clients can switch to the new key, and then expires.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param keyId path string true "API key ID"
+// @Param data body RotateAPIKeyData false "Rotation options"
+// @Success 201 {object} CreatedAPIKeyResult "New API key"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid key ID or data"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 404 {object} models.ErrorResponse "Active API key not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/admin/api-keys/{keyId}/rotate [post]
+func (h *APIKeysHandler) HandleRotateAPIKey(c *gin.Context) {
+	h.RotateAPIKeyCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "rotate_admin_api_key")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	middlewares.AdminInactivityReset(c) // Reset inactivity timer
+
+	keyId, err := uuid.Parse(c.Param("keyId"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid key ID!", "Failed to parse key ID", http.StatusBadRequest, nil), span, log, h.RotateAPIKeyCounter, "rotate_admin_api_key")
+		return
+	}
+	// The body is optional.
+	var data RotateAPIKeyData
+	if err := c.ShouldBindJSON(&data); err != nil && !errors.Is(err, io.EOF) {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid request body!", "Failed to bind request body", http.StatusBadRequest, err), span, log, h.RotateAPIKeyCounter, "rotate_admin_api_key")
+		return
+	}
+	overlap := defaultAPIKeyRotationOverlap
+	if data.OverlapSeconds != nil {
+		overlap = time.Duration(*data.OverlapSeconds) * time.Second
+	}
+
+	old, err := store.Querier.GetAPIKey(ctx, keyId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("API key not found!", "No API key found with the given ID", http.StatusNotFound, nil), span, log, h.RotateAPIKeyCounter, "rotate_admin_api_key")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get API key!", http.StatusInternalServerError, err), span, log, h.RotateAPIKeyCounter, "rotate_admin_api_key")
+		return
+	}
+
+	tx, err := store.PgPool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to begin transaction!", http.StatusInternalServerError, err), span, log, h.RotateAPIKeyCounter, "rotate_admin_api_key")
+		return
+	}
+	defer tx.Rollback(ctx) // Ensure the transaction is rolled back if not committed
+
+	q := store.Querier.WithTx(tx)
+
+	params := db.CreateAPIKeyParams{
+		Name:        old.Name,
+		Description: old.Description,
+		Scopes:      old.Scopes,
+		OrgID:       old.OrgID,
+		ExpiresAt:   old.ExpiresAt,
+	}
+	if email := c.GetString(middlewares.CtxAdminEmail); email != "" {
+		params.CreatedBy = &email
+	}
+	created, errResp := createAPIKey(c, q, params)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.RotateAPIKeyCounter, "rotate_admin_api_key")
+		return
+	}
+
+	// The old key must still be active, so that a key is not rotated twice, or revived after it was revoked.
+	old, err = q.MarkAPIKeyRotated(ctx, db.MarkAPIKeyRotatedParams{
+		RotatedTo: &params.ID,
+		ExpiresAt: pgtype.Timestamptz{Time: time.Now().Add(overlap), Valid: true},
+		ID:        keyId,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("API key not found!", "The API key is revoked, expired or already rotated", http.StatusNotFound, nil), span, log, h.RotateAPIKeyCounter, "rotate_admin_api_key")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to rotate API key!", http.StatusInternalServerError, err), span, log, h.RotateAPIKeyCounter, "rotate_admin_api_key")
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to commit transaction!", http.StatusInternalServerError, err), span, log, h.RotateAPIKeyCounter, "rotate_admin_api_key")
+		return
+	}
+	middlewares.InvalidateAPIKey(ctx, old.Prefix)
+
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionAPIKeyRotated,
+		OrgID:      old.OrgID,
+		ResourceID: &old.ID,
+		Metadata: map[string]any{
+			"email":     c.GetString(middlewares.CtxAdminEmail),
+			"name":      old.Name,
+			"prefix":    old.Prefix,
+			"newKeyId":  created.ID,
+			"newPrefix": created.Prefix,
+			"expiresAt": old.ExpiresAt.Time,
+		},
+	})
+
+	log.Debug("API key rotated", zap.String("prefix", old.Prefix), zap.String("newPrefix", created.Prefix))
+	h.RotateAPIKeyCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusCreated, created)
+}
+
+// HandleRevokeAPIKey godoc
+// @Summary Revoke API key
+// @Description Revokes an API key immediately, on every instance sharing the cache.
+// @Tags Admin
+// @Produce json
+// @Param keyId path string true "API key ID"
+// @Success 200 {object} APIKeyResult "Revoked API key"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid key ID"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 404 {object} models.ErrorResponse "API key not found or already revoked"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/admin/api-keys/{keyId} [delete]
+func (h *APIKeysHandler) HandleRevokeAPIKey(c *gin.Context) {
+	h.RevokeAPIKeyCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "revoke_admin_api_key")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	middlewares.AdminInactivityReset(c) // Reset inactivity timer
+
+	keyId, err := uuid.Parse(c.Param("keyId"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid key ID!", "Failed to parse key ID", http.StatusBadRequest, nil), span, log, h.RevokeAPIKeyCounter, "revoke_admin_api_key")
+		return
+	}
+
+	key, err := store.Querier.RevokeAPIKey(ctx, keyId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("API key not found!", "No unrevoked API key found with the given ID", http.StatusNotFound, nil), span, log, h.RevokeAPIKeyCounter, "revoke_admin_api_key")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to revoke API key!", http.StatusInternalServerError, err), span, log, h.RevokeAPIKeyCounter, "revoke_admin_api_key")
+		return
+	}
+	middlewares.InvalidateAPIKey(ctx, key.Prefix)
+
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionAPIKeyRevoked,
+		OrgID:      key.OrgID,
+		ResourceID: &key.ID,
+		Metadata: map[string]any{
+			"email":  c.GetString(middlewares.CtxAdminEmail),
+			"name":   key.Name,
+			"prefix": key.Prefix,
+		},
+	})
+
+	log.Debug("API key revoked", zap.String("prefix", key.Prefix))
+	h.RevokeAPIKeyCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, newAPIKeyResult(key))
+}
+
+// createAPIKey generates a new key and stores it with the given params. The ID, prefix and hash of the params are set.
+func createAPIKey(c *gin.Context, q *db.Queries, params db.CreateAPIKeyParams) (*CreatedAPIKeyResult, *models.ErrorResponse) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, models.NewErrorResponse(models.GenericErrorMessage, "Failed to generate API key ID!", http.StatusInternalServerError, err)
+	}
+	key, prefix, hash, err := tokens.NewAPIKey()
+	if err != nil {
+		return nil, models.NewErrorResponse(models.GenericErrorMessage, "Failed to generate API key!", http.StatusInternalServerError, err)
+	}
+	params.ID = id
+	params.Prefix = prefix
+	params.KeyHash = hash
+
+	row, err := q.CreateAPIKey(c.Request.Context(), params)
+	if err != nil {
+		return nil, models.NewErrorResponse(models.GenericErrorMessage, "Failed to store API key!", http.StatusInternalServerError, err)
+	}
+	return &CreatedAPIKeyResult{
+		APIKeyResult: newAPIKeyResult(row),
+		Key:          key,
+	}, nil
+}
@@ -0,0 +1,147 @@
+package admin_handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/middlewares"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type AuditLogsHandler struct {
+	ListAuditLogsCounter *prometheus.CounterVec
+}
+
+func NewAuditLogsHandler() *AuditLogsHandler {
+	return &AuditLogsHandler{
+		ListAuditLogsCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "admin",
+				Name:      "audit_logs_list_requests_total",
+				Help:      "Total number of admin audit log list and export requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+func (h *AuditLogsHandler) Register(engine *gin.Engine) {
+	metrics.Collectors = append(metrics.Collectors, h.ListAuditLogsCounter)
+	engine.GET("/api/admin/audit-logs", middlewares.RequireAuth(middlewares.AuthModeAdmin), h.ListAuditLogs)
+}
+
+type AuditLogsParams struct {
+	audit.ListParams
+
+	// OrgID limits the logs to a single organization, all organizations if empty.
+	OrgID string `form:"orgId" binding:"omitempty,uuid"`
+}
+
+// ListAuditLogs godoc
+// @Summary List audit logs
+// @Description Lists the audit logs of all organizations, including events outside of organizations like admin logins, newest first.
+// @Description With format "ndjson" or "csv", every matching log is streamed instead, ignoring the cursor and limit.
+// @Tags Admin
+// @Produce json
+// @Produce application/x-ndjson
+// @Produce text/csv
+// @Param orgId query string false "Organization ID"
+// @Param action query []string false "Actions to include, eg. auth.login.failed" collectionFormat(multi)
+// @Param actorId query string false "User who performed the actions"
+// @Param resourceType query string false "Type of the resource, eg. session"
+// @Param resourceId query string false "ID of the resource"
+// @Param ip query string false "IP address or CIDR range"
+// @Param from query string false "Start of the time range (inclusive), RFC 3339"
+// @Param to query string false "End of the time range (exclusive), RFC 3339"
+// @Param cursor query string false "Cursor of the next page, from the previous response"
+// @Param limit query int false "Maximum number of logs, default 50, max 1000"
+// @Param format query string false "json (default), ndjson or csv"
+// @Success 200 {object} audit.ListResult "Audit logs"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid filter or cursor"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/admin/audit-logs [get]
+func (h *AuditLogsHandler) ListAuditLogs(c *gin.Context) {
+	h.ListAuditLogsCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "list_admin_audit_logs")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	middlewares.AdminInactivityReset(c) // Reset inactivity timer
+
+	var params AuditLogsParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid query parameters", "Bad Request", http.StatusBadRequest, nil), span, log, h.ListAuditLogsCounter, "list_admin_audit_logs")
+		return
+	}
+	filter, err := params.Filter()
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid filter: "+err.Error(), "Failed to parse the audit log filter", http.StatusBadRequest, nil), span, log, h.ListAuditLogsCounter, "list_admin_audit_logs")
+		return
+	}
+
+	var orgId *uuid.UUID
+	if params.OrgID != "" {
+		id, err := uuid.Parse(params.OrgID)
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse("Invalid organization ID!", "Failed to parse organization ID", http.StatusBadRequest, nil), span, log, h.ListAuditLogsCounter, "list_admin_audit_logs")
+			return
+		}
+		orgId = &id
+	}
+
+	if params.Format == audit.FormatNDJSON || params.Format == audit.FormatCSV {
+		audit.RecordRequest(c, audit.Event{
+			Action: audit.ActionAuditLogsExported,
+			OrgID:  orgId,
+			Metadata: map[string]any{
+				"email":  c.GetString(middlewares.CtxAdminEmail),
+				"format": params.Format,
+				"filter": c.Request.URL.RawQuery,
+			},
+		})
+
+		c.Header("Content-Type", audit.ContentType(params.Format))
+		c.Header("Content-Disposition", `attachment; filename="audit-logs.`+params.Format+`"`)
+		c.Status(http.StatusOK)
+		if err := audit.Export(ctx, c.Writer, c.Writer.Flush, params.Format, orgId, filter); err != nil {
+			// The response has already started, so the error can only be logged.
+			log.Error("Failed to export audit logs", zap.Error(err))
+			span.RecordError(err)
+			h.ListAuditLogsCounter.WithLabelValues("error").Inc()
+			return
+		}
+		h.ListAuditLogsCounter.WithLabelValues("success").Inc()
+		return
+	}
+
+	var cursor *audit.Cursor
+	if params.Cursor != "" {
+		cursor, err = audit.DecodeCursor(params.Cursor)
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse("Invalid cursor!", "Failed to decode the cursor", http.StatusBadRequest, nil), span, log, h.ListAuditLogsCounter, "list_admin_audit_logs")
+			return
+		}
+	}
+	limit := params.Limit
+	if limit == 0 {
+		limit = audit.DefaultListLimit
+	}
+
+	logs, next, err := audit.List(ctx, orgId, filter, cursor, limit)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to list audit logs!", http.StatusInternalServerError, err), span, log, h.ListAuditLogsCounter, "list_admin_audit_logs")
+		return
+	}
+
+	h.ListAuditLogsCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, audit.NewListResult(logs, next))
+}
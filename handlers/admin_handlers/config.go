@@ -5,6 +5,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/internal/audit"
 	"github.com/nbrglm/nexeres/internal/metrics"
 	"github.com/nbrglm/nexeres/internal/middlewares"
 	"github.com/prometheus/client_golang/prometheus"
@@ -45,6 +46,13 @@ func (h *ConfigHandler) Register(engine *gin.Engine) {
 func (h *ConfigHandler) GetConfig(c *gin.Context) {
 	h.ConfigGETCounter.WithLabelValues("received").Inc()
 
+	audit.RecordRequest(c, audit.Event{
+		Action: audit.ActionAdminConfigRead,
+		Metadata: map[string]any{
+			"email": c.GetString(middlewares.CtxAdminEmail),
+		},
+	})
+
 	h.ConfigGETCounter.WithLabelValues("success").Inc()
 	middlewares.AdminInactivityReset(c) // Reset inactivity timer
 	c.JSON(http.StatusOK, config.Config)
@@ -9,11 +9,13 @@ import (
 	"github.com/google/uuid"
 	"github.com/nbrglm/nexeres/config"
 	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
 	"github.com/nbrglm/nexeres/internal/cache"
 	"github.com/nbrglm/nexeres/internal/metrics"
 	"github.com/nbrglm/nexeres/internal/models"
 	"github.com/nbrglm/nexeres/internal/notifications"
 	"github.com/nbrglm/nexeres/internal/otp"
+	"github.com/nbrglm/nexeres/internal/store"
 	"github.com/nbrglm/nexeres/internal/tokens"
 	"github.com/nbrglm/nexeres/opts"
 	"github.com/nbrglm/nexeres/utils"
@@ -121,16 +123,24 @@ func (h *AdminLoginHandler) AdminLogin(c *gin.Context) {
 			return
 		}
 
-		err = notifications.SendAdminLoginEmail(ctx, notifications.SendAdminLoginEmailParams{
-			Email:     requestData.Email,
-			Code:      code,
-			ExpiresAt: expiresAt,
+		err = notifications.QueueAdminLoginEmail(ctx, store.Querier, notifications.QueueAdminLoginEmailParams{
+			Email:          requestData.Email,
+			Code:           code,
+			ExpiresAt:      expiresAt,
+			IdempotencyKey: flowId.String(),
 		})
 		if err != nil {
-			utils.ProcessError(c, models.NewErrorResponse("Unable to send admin login email - Internal Server Error", "Failed to send admin login email", http.StatusInternalServerError, err), span, log, h.AdminLoginCounter, "admin_login")
+			utils.ProcessError(c, models.NewErrorResponse("Unable to send admin login email - Internal Server Error", "Failed to queue admin login email", http.StatusInternalServerError, err), span, log, h.AdminLoginCounter, "admin_login")
 			return
 		}
 
+		audit.RecordRequest(c, audit.Event{
+			Action: audit.ActionAdminLoginCodeSent,
+			Metadata: map[string]any{
+				"email": requestData.Email,
+			},
+		})
+
 		h.AdminLoginCounter.WithLabelValues("success").Inc()
 		c.JSON(http.StatusOK, AdminLoginResult{
 			EmailSent: true,
@@ -139,6 +149,14 @@ func (h *AdminLoginHandler) AdminLogin(c *gin.Context) {
 		return
 	}
 
+	audit.RecordRequest(c, audit.Event{
+		Action: audit.ActionAdminLoginFailed,
+		Metadata: map[string]any{
+			"emailHash": audit.EmailHash(requestData.Email),
+			"reason":    "unknown_email",
+		},
+	})
+
 	// For security reasons, we do not reveal whether the email exists or not
 	h.AdminLoginCounter.WithLabelValues("invalid_request").Inc()
 	utils.ProcessError(c, models.NewErrorResponse("Invalid request", "The provided email does not exist", http.StatusUnauthorized, nil), span, log, h.AdminLoginCounter, "admin_login")
@@ -185,6 +203,12 @@ func (h *AdminLoginHandler) VerifyAdminLogin(c *gin.Context) {
 	flowData, err := cache.GetAdminLoginFlow(ctx, requestData.FlowId)
 	if err != nil {
 		if err == cache.ErrKeyNotFound {
+			audit.RecordRequest(c, audit.Event{
+				Action: audit.ActionAdminLoginFailed,
+				Metadata: map[string]any{
+					"reason": "invalid_flow",
+				},
+			})
 			utils.ProcessError(c, models.NewErrorResponse("Invalid or expired flow", "The provided flow ID is invalid or has expired", http.StatusUnauthorized, nil), span, log, h.AdminLoginVerifyCounter, "verify_admin_login")
 			return
 		}
@@ -193,6 +217,13 @@ func (h *AdminLoginHandler) VerifyAdminLogin(c *gin.Context) {
 	}
 
 	if requestData.Code != flowData.Code {
+		audit.RecordRequest(c, audit.Event{
+			Action: audit.ActionAdminLoginFailed,
+			Metadata: map[string]any{
+				"email":  flowData.Email,
+				"reason": "invalid_code",
+			},
+		})
 		h.AdminLoginVerifyCounter.WithLabelValues("invalid_request").Inc()
 		utils.ProcessError(c, models.NewErrorResponse("Invalid code", "The provided code is incorrect", http.StatusUnauthorized, nil), span, log, h.AdminLoginVerifyCounter, "verify_admin_login")
 		return
@@ -229,6 +260,13 @@ func (h *AdminLoginHandler) VerifyAdminLogin(c *gin.Context) {
 		}
 	}
 
+	audit.RecordRequest(c, audit.Event{
+		Action: audit.ActionAdminLoginSucceeded,
+		Metadata: map[string]any{
+			"email": flowData.Email,
+		},
+	})
+
 	c.Header(tokens.AdminTokenExpiryHeaderName, expiresAt.Format(time.RFC3339))
 
 	h.AdminLoginVerifyCounter.WithLabelValues("success").Inc()
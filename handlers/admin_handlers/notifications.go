@@ -0,0 +1,229 @@
+package admin_handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/middlewares"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/notifications"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// defaultNotificationsLimit is the default number of notification jobs in a page.
+const defaultNotificationsLimit = 50
+
+type NotificationsHandler struct {
+	ListNotificationsCounter *prometheus.CounterVec
+	RetryNotificationCounter *prometheus.CounterVec
+}
+
+func NewNotificationsHandler() *NotificationsHandler {
+	return &NotificationsHandler{
+		ListNotificationsCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "admin",
+				Name:      "notifications_list_requests_total",
+				Help:      "Total number of admin notification job list requests",
+			},
+			[]string{"status"},
+		),
+		RetryNotificationCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "admin",
+				Name:      "notifications_retry_requests_total",
+				Help:      "Total number of admin notification job retry requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+func (h *NotificationsHandler) Register(engine *gin.Engine) {
+	metrics.Collectors = append(metrics.Collectors, h.ListNotificationsCounter, h.RetryNotificationCounter)
+	engine.GET("/api/admin/notifications", middlewares.RequireAuth(middlewares.AuthModeAdmin), h.HandleListNotifications)
+	engine.POST("/api/admin/notifications/:jobId/retry", middlewares.RequireAuth(middlewares.AuthModeAdmin), h.HandleRetryNotification)
+}
+
+// NotificationJobResult is a queued notification. The message itself is not returned, since it may contain tokens or codes.
+type NotificationJobResult struct {
+	ID            string     `json:"id"`
+	Channel       string     `json:"channel"`
+	Kind          string     `json:"kind"`
+	Recipient     string     `json:"recipient"`
+	Status        string     `json:"status"`
+	Attempts      int32      `json:"attempts"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+	LastError     *string    `json:"lastError,omitempty"`
+	SentAt        *time.Time `json:"sentAt,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+type ListNotificationsParams struct {
+	// Status of the jobs: "pending", "sent", "dead" (no attempts left) or "expired".
+	Status string `form:"status" binding:"omitempty,oneof=pending sent dead expired"`
+	// Recipient of the jobs, eg. an email address.
+	Recipient string `form:"recipient"`
+	// Before is the createdAt of the last job of the previous page, RFC 3339.
+	Before string `form:"before"`
+	// Limit is the maximum number of jobs, default 50, max 200.
+	Limit int `form:"limit" binding:"omitempty,min=1,max=200"`
+}
+
+type ListNotificationsResult struct {
+	Jobs []NotificationJobResult `json:"jobs"`
+}
+
+func newNotificationJobResult(job db.NotificationJob) NotificationJobResult {
+	result := NotificationJobResult{
+		ID:        job.ID.String(),
+		Channel:   job.Channel,
+		Kind:      job.Kind,
+		Recipient: job.Recipient,
+		Status:    job.Status,
+		Attempts:  job.Attempts,
+		LastError: job.LastError,
+		CreatedAt: job.CreatedAt.Time,
+	}
+	if job.Status == notifications.StatusPending {
+		result.NextAttemptAt = &job.NextAttemptAt.Time
+	}
+	if job.ExpiresAt.Valid {
+		result.ExpiresAt = &job.ExpiresAt.Time
+	}
+	if job.SentAt.Valid {
+		result.SentAt = &job.SentAt.Time
+	}
+	return result
+}
+
+// HandleListNotifications godoc
+// @Summary List notification jobs
+// @Description Lists the jobs of the notification queue, newest first. Filter by status "dead" to list the notifications which could not be sent.
+// @Tags Admin
+// @Produce json
+// @Param status query string false "Status of the jobs" Enums(pending, sent, dead, expired)
+// @Param recipient query string false "Recipient of the jobs, eg. an email address"
+// @Param before query string false "createdAt of the last job of the previous page, RFC 3339"
+// @Param limit query int false "Maximum number of jobs, default 50, max 200"
+// @Success 200 {object} ListNotificationsResult "Notification jobs"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid filter"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/admin/notifications [get]
+func (h *NotificationsHandler) HandleListNotifications(c *gin.Context) {
+	h.ListNotificationsCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "list_admin_notifications")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	middlewares.AdminInactivityReset(c) // Reset inactivity timer
+
+	var params ListNotificationsParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid query parameters!", "Failed to bind query parameters", http.StatusBadRequest, nil), span, log, h.ListNotificationsCounter, "list_admin_notifications")
+		return
+	}
+
+	query := db.ListNotificationJobsParams{
+		Limit: defaultNotificationsLimit,
+	}
+	if params.Status != "" {
+		query.Status = &params.Status
+	}
+	if params.Recipient != "" {
+		query.Recipient = &params.Recipient
+	}
+	if params.Before != "" {
+		before, err := time.Parse(time.RFC3339Nano, params.Before)
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse("Invalid 'before' time, use RFC 3339!", "Failed to parse the before time", http.StatusBadRequest, nil), span, log, h.ListNotificationsCounter, "list_admin_notifications")
+			return
+		}
+		query.Before = pgtype.Timestamptz{Time: before, Valid: true}
+	}
+	if params.Limit != 0 {
+		query.Limit = int32(params.Limit)
+	}
+
+	jobs, err := store.Querier.ListNotificationJobs(ctx, query)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to list notification jobs!", http.StatusInternalServerError, err), span, log, h.ListNotificationsCounter, "list_admin_notifications")
+		return
+	}
+
+	result := ListNotificationsResult{
+		Jobs: make([]NotificationJobResult, 0, len(jobs)),
+	}
+	for _, job := range jobs {
+		result.Jobs = append(result.Jobs, newNotificationJobResult(job))
+	}
+
+	h.ListNotificationsCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, result)
+}
+
+// HandleRetryNotification godoc
+// @Summary Retry notification job
+// @Description Schedules a dead notification job to be sent again immediately, with a new set of attempts, eg. after a provider outage.
+// @Tags Admin
+// @Produce json
+// @Param jobId path string true "Notification job ID"
+// @Success 202 {object} NotificationJobResult "Scheduled job"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid job ID"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 404 {object} models.ErrorResponse "Dead job not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/admin/notifications/{jobId}/retry [post]
+func (h *NotificationsHandler) HandleRetryNotification(c *gin.Context) {
+	h.RetryNotificationCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "retry_admin_notification")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	middlewares.AdminInactivityReset(c) // Reset inactivity timer
+
+	jobId, err := uuid.Parse(c.Param("jobId"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid job ID!", "Failed to parse job ID", http.StatusBadRequest, nil), span, log, h.RetryNotificationCounter, "retry_admin_notification")
+		return
+	}
+
+	job, err := store.Querier.RetryNotificationJob(ctx, jobId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("Job not found!", "No dead notification job found with the given ID", http.StatusNotFound, nil), span, log, h.RetryNotificationCounter, "retry_admin_notification")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to schedule notification retry!", http.StatusInternalServerError, err), span, log, h.RetryNotificationCounter, "retry_admin_notification")
+		return
+	}
+
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionNotificationRetried,
+		ResourceID: &job.ID,
+		Metadata: map[string]any{
+			"email": c.GetString(middlewares.CtxAdminEmail),
+			"kind":  job.Kind,
+		},
+	})
+
+	log.Debug("Notification retry scheduled", zap.String("jobId", jobId.String()))
+	h.RetryNotificationCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusAccepted, newNotificationJobResult(job))
+}
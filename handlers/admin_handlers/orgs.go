@@ -0,0 +1,130 @@
+package admin_handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/middlewares"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/internal/webhooks"
+	"github.com/nbrglm/nexeres/opts"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type OrgsHandler struct {
+	DeleteOrgCounter *prometheus.CounterVec
+}
+
+func NewOrgsHandler() *OrgsHandler {
+	return &OrgsHandler{
+		DeleteOrgCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "admin",
+				Name:      "org_delete_requests_total",
+				Help:      "Total number of admin organization delete requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+func (h *OrgsHandler) Register(engine *gin.Engine) {
+	metrics.Collectors = append(metrics.Collectors, h.DeleteOrgCounter)
+	engine.DELETE("/api/admin/orgs/:orgId", middlewares.RequireAuth(middlewares.AuthModeAdmin), h.HandleDeleteOrg)
+}
+
+// HandleDeleteOrg godoc
+// @Summary Delete organization
+// @Description Soft deletes an organization, and sends the org.deleted webhook to its endpoints.
+// @Description The organization, and everything scoped to it, is purged by the janitor after the `janitor.retention.deletedOrgs` window.
+// @Description The default organization cannot be deleted.
+// @Tags Admin
+// @Produce json
+// @Param orgId path string true "Organization ID"
+// @Success 204 "Organization deleted"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid organization ID, or the default organization"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 404 {object} models.ErrorResponse "Organization not found or already deleted"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/admin/orgs/{orgId} [delete]
+func (h *OrgsHandler) HandleDeleteOrg(c *gin.Context) {
+	h.DeleteOrgCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "admin_delete_org")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	middlewares.AdminInactivityReset(c) // Reset inactivity timer
+
+	orgId, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid organization ID!", "Failed to parse organization ID", http.StatusBadRequest, nil), span, log, h.DeleteOrgCounter, "admin_delete_org")
+		return
+	}
+	if orgId.String() == opts.DefaultOrgId {
+		utils.ProcessError(c, models.NewErrorResponse("The default organization cannot be deleted!", "Attempted to delete the default organization", http.StatusBadRequest, nil), span, log, h.DeleteOrgCounter, "admin_delete_org")
+		return
+	}
+
+	tx, err := store.PgPool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to begin transaction!", http.StatusInternalServerError, err), span, log, h.DeleteOrgCounter, "admin_delete_org")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	q := store.Querier.WithTx(tx)
+
+	org, err := q.GetOrgByIDForUpdate(ctx, orgId)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && org.DeletedAt.Valid) {
+		utils.ProcessError(c, models.NewErrorResponse("Organization not found!", "No undeleted organization found for the given ID", http.StatusNotFound, nil), span, log, h.DeleteOrgCounter, "admin_delete_org")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get organization!", http.StatusInternalServerError, err), span, log, h.DeleteOrgCounter, "admin_delete_org")
+		return
+	}
+
+	if err := q.SoftDeleteOrg(ctx, orgId); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to delete organization!", http.StatusInternalServerError, err), span, log, h.DeleteOrgCounter, "admin_delete_org")
+		return
+	}
+
+	// The event is sent now, since the endpoints of the organization are deleted with it when it is purged.
+	if err := webhooks.Enqueue(ctx, q, orgId, webhooks.EventOrgDeleted, map[string]any{
+		"orgId": orgId,
+		"slug":  org.Slug,
+	}); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to enqueue webhooks!", http.StatusInternalServerError, err), span, log, h.DeleteOrgCounter, "admin_delete_org")
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to commit transaction!", http.StatusInternalServerError, err), span, log, h.DeleteOrgCounter, "admin_delete_org")
+		return
+	}
+	middlewares.InvalidateTenant(ctx, org.Slug)
+
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionOrgDeleted,
+		OrgID:      &orgId,
+		ResourceID: &orgId,
+		Metadata: map[string]any{
+			"email": c.GetString(middlewares.CtxAdminEmail),
+			"slug":  org.Slug,
+		},
+	})
+
+	log.Debug("Organization deleted", zap.String("orgId", orgId.String()))
+	h.DeleteOrgCounter.WithLabelValues("success").Inc()
+	c.Status(http.StatusNoContent)
+}
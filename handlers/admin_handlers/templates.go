@@ -0,0 +1,213 @@
+package admin_handlers
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/middlewares"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/notifications"
+	"github.com/nbrglm/nexeres/internal/notifications/templates"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type TemplatesHandler struct {
+	ListTemplatesCounter   *prometheus.CounterVec
+	PreviewTemplateCounter *prometheus.CounterVec
+}
+
+func NewTemplatesHandler() *TemplatesHandler {
+	return &TemplatesHandler{
+		ListTemplatesCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "admin",
+				Name:      "templates_list_requests_total",
+				Help:      "Total number of admin notification template list requests",
+			},
+			[]string{"status"},
+		),
+		PreviewTemplateCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "admin",
+				Name:      "templates_preview_requests_total",
+				Help:      "Total number of admin notification template preview requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+func (h *TemplatesHandler) Register(engine *gin.Engine) {
+	metrics.Collectors = append(metrics.Collectors, h.ListTemplatesCounter, h.PreviewTemplateCounter)
+	engine.GET("/api/admin/templates", middlewares.RequireAuth(middlewares.AuthModeAdmin), h.HandleListTemplates)
+	engine.GET("/api/admin/templates/:templateName/preview", middlewares.RequireAuth(middlewares.AuthModeAdmin), h.HandlePreviewTemplate)
+}
+
+// TemplateInfo is a built-in notification template.
+type TemplateInfo struct {
+	Name string `json:"name"`
+	// Channel is "email" or "sms".
+	Channel string `json:"channel"`
+	// Locales are the locales the template is translated to.
+	Locales []string `json:"locales"`
+}
+
+type ListTemplatesResult struct {
+	Templates []TemplateInfo `json:"templates"`
+	// DefaultLocale is the locale notifications are sent in when neither the user nor their organization has one.
+	DefaultLocale string `json:"defaultLocale"`
+}
+
+type PreviewTemplateParams struct {
+	// Locale to render the template in, as a BCP 47 language tag, the default locale if empty.
+	Locale string `form:"locale" binding:"omitempty,bcp47_language_tag"`
+	// OrgID of the organization whose branding and custom templates are used, the default branding if empty.
+	OrgID string `form:"orgId" binding:"omitempty,uuid"`
+}
+
+// PreviewTemplateResult is a template rendered with sample data.
+type PreviewTemplateResult struct {
+	TemplateName string `json:"templateName"`
+	Channel      string `json:"channel"`
+	// Locale the template was rendered in, the closest locale it is translated to. Empty for an organization's custom template.
+	Locale string `json:"locale"`
+
+	// Subject, HTML and PlainText are set for email templates.
+	Subject   string `json:"subject,omitempty"`
+	HTML      string `json:"html,omitempty"`
+	PlainText string `json:"plainText,omitempty"`
+
+	// Body is set for SMS templates.
+	Body string `json:"body,omitempty"`
+}
+
+// HandleListTemplates godoc
+// @Summary List notification templates
+// @Description Lists the built-in email and SMS templates, with the locales they are translated to.
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} ListTemplatesResult "Templates"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Router /api/admin/templates [get]
+func (h *TemplatesHandler) HandleListTemplates(c *gin.Context) {
+	h.ListTemplatesCounter.WithLabelValues("received").Inc()
+
+	_, _, span := internal.WithContext(c.Request.Context(), "list_admin_templates")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	middlewares.AdminInactivityReset(c) // Reset inactivity timer
+
+	result := ListTemplatesResult{
+		Templates:     []TemplateInfo{},
+		DefaultLocale: templates.DefaultLocale(),
+	}
+	for name, locales := range templates.EmailTemplateLocales() {
+		result.Templates = append(result.Templates, TemplateInfo{Name: name, Channel: notifications.ChannelEmail, Locales: locales})
+	}
+	for name, locales := range templates.MessageTemplateLocales() {
+		result.Templates = append(result.Templates, TemplateInfo{Name: name, Channel: notifications.ChannelSMS, Locales: locales})
+	}
+	slices.SortFunc(result.Templates, func(a, b TemplateInfo) int {
+		return cmp.Or(cmp.Compare(a.Channel, b.Channel), cmp.Compare(a.Name, b.Name))
+	})
+
+	h.ListTemplatesCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, result)
+}
+
+// HandlePreviewTemplate godoc
+// @Summary Preview notification template
+// @Description Renders a built-in email or SMS template with sample data, in a locale and optionally with the branding and custom templates of an organization.
+// @Tags Admin
+// @Produce json
+// @Param templateName path string true "Template name, eg. VerifyEmail"
+// @Param locale query string false "Locale, eg. de, the default locale if empty"
+// @Param orgId query string false "Organization ID, for its branding and custom templates"
+// @Success 200 {object} PreviewTemplateResult "Rendered template"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid locale or organization ID"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 404 {object} models.ErrorResponse "Template or organization not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/admin/templates/{templateName}/preview [get]
+func (h *TemplatesHandler) HandlePreviewTemplate(c *gin.Context) {
+	h.PreviewTemplateCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "preview_admin_template")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	middlewares.AdminInactivityReset(c) // Reset inactivity timer
+
+	var params PreviewTemplateParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid query parameters!", "Failed to bind query parameters", http.StatusBadRequest, nil), span, log, h.PreviewTemplateCounter, "preview_admin_template")
+		return
+	}
+	locale := templates.DefaultLocale()
+	if params.Locale != "" {
+		locale, _ = templates.NormalizeLocale(params.Locale)
+	}
+
+	branding := notifications.DefaultBranding()
+	if params.OrgID != "" {
+		org, err := store.Querier.GetOrgByID(ctx, uuid.MustParse(params.OrgID))
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.ProcessError(c, models.NewErrorResponse("Organization not found!", "No organization found with the given ID", http.StatusNotFound, nil), span, log, h.PreviewTemplateCounter, "preview_admin_template")
+			return
+		}
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get organization!", http.StatusInternalServerError, err), span, log, h.PreviewTemplateCounter, "preview_admin_template")
+			return
+		}
+		if branding, err = notifications.BrandingForOrg(&org); err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get organization branding!", http.StatusInternalServerError, err), span, log, h.PreviewTemplateCounter, "preview_admin_template")
+			return
+		}
+	}
+
+	name := c.Param("templateName")
+	var result PreviewTemplateResult
+	email, err := notifications.PreviewEmail(ctx, name, locale, branding)
+	if err == nil {
+		result = PreviewTemplateResult{
+			TemplateName: email.TemplateName,
+			Channel:      notifications.ChannelEmail,
+			Locale:       email.Locale,
+			Subject:      email.Subject,
+			HTML:         email.HTMLBody,
+			PlainText:    email.PlainTextBody,
+		}
+	} else if errors.Is(err, notifications.ErrUnknownTemplate) {
+		var message *templates.RenderedMessageTemplate
+		message, err = notifications.PreviewMessage(name, locale, branding)
+		if err == nil {
+			result = PreviewTemplateResult{
+				TemplateName: message.TemplateName,
+				Channel:      notifications.ChannelSMS,
+				Locale:       message.Locale,
+				Body:         message.Body,
+			}
+		}
+	}
+	if errors.Is(err, notifications.ErrUnknownTemplate) {
+		utils.ProcessError(c, models.NewErrorResponse("Template not found!", "No built-in template found with the given name", http.StatusNotFound, nil), span, log, h.PreviewTemplateCounter, "preview_admin_template")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Failed to render the template!", "Failed to render template with sample data", http.StatusInternalServerError, err), span, log, h.PreviewTemplateCounter, "preview_admin_template")
+		return
+	}
+
+	h.PreviewTemplateCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, result)
+}
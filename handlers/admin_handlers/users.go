@@ -0,0 +1,254 @@
+package admin_handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/middlewares"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/privacy"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// UsersHandler handles the data export and erasure requests which users send to the operators, eg. by email.
+type UsersHandler struct {
+	ExportUserCounter          *prometheus.CounterVec
+	ScheduleUserErasureCounter *prometheus.CounterVec
+	CancelUserErasureCounter   *prometheus.CounterVec
+}
+
+func NewUsersHandler() *UsersHandler {
+	return &UsersHandler{
+		ExportUserCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "admin",
+				Name:      "user_export_requests_total",
+				Help:      "Total number of admin user data export requests",
+			},
+			[]string{"status"},
+		),
+		ScheduleUserErasureCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "admin",
+				Name:      "user_erasure_requests_total",
+				Help:      "Total number of admin user erasure requests",
+			},
+			[]string{"status"},
+		),
+		CancelUserErasureCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "admin",
+				Name:      "user_erasure_cancel_requests_total",
+				Help:      "Total number of admin user erasure cancellation requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+func (h *UsersHandler) Register(engine *gin.Engine) {
+	metrics.Collectors = append(metrics.Collectors, h.ExportUserCounter, h.ScheduleUserErasureCounter, h.CancelUserErasureCounter)
+	requireAdmin := middlewares.RequireAuth(middlewares.AuthModeAdmin)
+	engine.GET("/api/admin/users/:userId/export", requireAdmin, h.HandleExportUser)
+	engine.POST("/api/admin/users/:userId/erasure", requireAdmin, h.HandleScheduleUserErasure)
+	engine.DELETE("/api/admin/users/:userId/erasure", requireAdmin, h.HandleCancelUserErasure)
+}
+
+type ScheduleUserErasureData struct {
+	// Immediate erases the user now, instead of after the grace period.
+	Immediate bool `json:"immediate"`
+}
+
+type ScheduleUserErasureResult struct {
+	// ErasureScheduledAt is the time at which the user's data is erased, nil if the user was erased immediately.
+	ErasureScheduledAt *time.Time `json:"erasureScheduledAt,omitempty"`
+}
+
+// HandleExportUser godoc
+// @Summary Export user data
+// @Description Exports the data of a user, on their request: their profile, organizations, sessions, identities, MFA factors (without secrets) and audit events.
+// @Description The archive is stored for a limited time, and downloaded through the returned pre-signed URL until it expires.
+// @Tags Admin
+// @Produce json
+// @Param userId path string true "User ID"
+// @Param format query string false "Format of the archive, 'zip' (a JSON file per section) or 'json' (a single document)" Enums(zip, json) default(zip)
+// @Success 200 {object} privacy.Export "Export"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid user ID or unknown format"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 404 {object} models.ErrorResponse "User not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/admin/users/{userId}/export [get]
+func (h *UsersHandler) HandleExportUser(c *gin.Context) {
+	h.ExportUserCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "admin_export_user")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	middlewares.AdminInactivityReset(c) // Reset inactivity timer
+
+	userId, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid user ID!", "Failed to parse user ID", http.StatusBadRequest, nil), span, log, h.ExportUserCounter, "admin_export_user")
+		return
+	}
+
+	format := c.DefaultQuery("format", privacy.FormatZIP)
+	if format != privacy.FormatZIP && format != privacy.FormatJSON {
+		utils.ProcessError(c, models.NewErrorResponse("Unknown format, use 'zip' or 'json'!", "Unknown export format", http.StatusBadRequest, nil), span, log, h.ExportUserCounter, "admin_export_user")
+		return
+	}
+
+	export, err := privacy.CreateExport(ctx, userId, format)
+	if errors.Is(err, privacy.ErrUserNotFound) {
+		utils.ProcessError(c, models.NewErrorResponse("User not found!", "No user found for the given ID", http.StatusNotFound, nil), span, log, h.ExportUserCounter, "admin_export_user")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to export the user data!", http.StatusInternalServerError, err), span, log, h.ExportUserCounter, "admin_export_user")
+		return
+	}
+
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionUserDataExported,
+		ResourceID: &userId,
+		Metadata: map[string]any{
+			"email":    c.GetString(middlewares.CtxAdminEmail),
+			"exportId": export.ID,
+			"format":   export.Format,
+		},
+	})
+
+	h.ExportUserCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, export)
+}
+
+// HandleScheduleUserErasure godoc
+// @Summary Erase user data
+// @Description Schedules the erasure of a user after the grace period, on their request, or erases them immediately.
+// @Description If an erasure is already scheduled, its time is returned unchanged, unless the user is erased immediately.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param userId path string true "User ID"
+// @Param data body ScheduleUserErasureData false "Erasure options"
+// @Success 202 {object} ScheduleUserErasureResult "Erasure scheduled"
+// @Success 200 {object} ScheduleUserErasureResult "User erased"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid user ID"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 404 {object} models.ErrorResponse "User not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/admin/users/{userId}/erasure [post]
+func (h *UsersHandler) HandleScheduleUserErasure(c *gin.Context) {
+	h.ScheduleUserErasureCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "admin_schedule_user_erasure")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	middlewares.AdminInactivityReset(c) // Reset inactivity timer
+
+	userId, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid user ID!", "Failed to parse user ID", http.StatusBadRequest, nil), span, log, h.ScheduleUserErasureCounter, "admin_schedule_user_erasure")
+		return
+	}
+
+	var data ScheduleUserErasureData
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&data); err != nil {
+			utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.ScheduleUserErasureCounter, "admin_schedule_user_erasure")
+			return
+		}
+	}
+
+	// The erasure is always scheduled first, so that an immediate erasure which fails is retried by the janitor.
+	scheduledAt, err := privacy.ScheduleErasure(ctx, userId)
+	if errors.Is(err, privacy.ErrUserNotFound) {
+		utils.ProcessError(c, models.NewErrorResponse("User not found!", "No user found for the given ID", http.StatusNotFound, nil), span, log, h.ScheduleUserErasureCounter, "admin_schedule_user_erasure")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to schedule the erasure!", http.StatusInternalServerError, err), span, log, h.ScheduleUserErasureCounter, "admin_schedule_user_erasure")
+		return
+	}
+
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionUserErasureScheduled,
+		ResourceID: &userId,
+		Metadata: map[string]any{
+			"email":              c.GetString(middlewares.CtxAdminEmail),
+			"erasureScheduledAt": scheduledAt,
+			"immediate":          data.Immediate,
+		},
+	})
+
+	if !data.Immediate {
+		h.ScheduleUserErasureCounter.WithLabelValues("success").Inc()
+		c.JSON(http.StatusAccepted, ScheduleUserErasureResult{ErasureScheduledAt: &scheduledAt})
+		return
+	}
+
+	if err := privacy.Erase(ctx, userId); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to erase the user!", http.StatusInternalServerError, err), span, log, h.ScheduleUserErasureCounter, "admin_schedule_user_erasure")
+		return
+	}
+
+	h.ScheduleUserErasureCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, ScheduleUserErasureResult{})
+}
+
+// HandleCancelUserErasure godoc
+// @Summary Cancel user erasure
+// @Description Cancels the scheduled erasure of a user.
+// @Tags Admin
+// @Param userId path string true "User ID"
+// @Success 204 "Erasure cancelled"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid user ID"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 404 {object} models.ErrorResponse "No erasure scheduled"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/admin/users/{userId}/erasure [delete]
+func (h *UsersHandler) HandleCancelUserErasure(c *gin.Context) {
+	h.CancelUserErasureCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "admin_cancel_user_erasure")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	middlewares.AdminInactivityReset(c) // Reset inactivity timer
+
+	userId, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid user ID!", "Failed to parse user ID", http.StatusBadRequest, nil), span, log, h.CancelUserErasureCounter, "admin_cancel_user_erasure")
+		return
+	}
+
+	cancelled, err := privacy.CancelErasure(ctx, userId)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to cancel the erasure!", http.StatusInternalServerError, err), span, log, h.CancelUserErasureCounter, "admin_cancel_user_erasure")
+		return
+	}
+	if !cancelled {
+		utils.ProcessError(c, models.NewErrorResponse("No erasure is scheduled!", "No erasure scheduled for the user", http.StatusNotFound, nil), span, log, h.CancelUserErasureCounter, "admin_cancel_user_erasure")
+		return
+	}
+
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionUserErasureCancelled,
+		ResourceID: &userId,
+		Metadata: map[string]any{
+			"email": c.GetString(middlewares.CtxAdminEmail),
+		},
+	})
+
+	h.CancelUserErasureCounter.WithLabelValues("success").Inc()
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/middlewares"
+	"github.com/nbrglm/nexeres/internal/tokens"
+	"github.com/nbrglm/nexeres/opts"
+)
+
+// auditOrgID returns the organization of audit events for which it is not otherwise known:
+// the tenant organization, or the default organization in single-tenant mode. Otherwise, nil.
+func auditOrgID(c *gin.Context) *uuid.UUID {
+	if tenant := middlewares.GetTenant(c); tenant != nil {
+		return &tenant.ID
+	}
+	if !config.Multitenancy {
+		defaultOrgID := uuid.MustParse(opts.DefaultOrgId)
+		return &defaultOrgID
+	}
+	return nil
+}
+
+// recordSessionAudit fills in the organization and actor of the event from the session token, if not set, and records it.
+func recordSessionAudit(c *gin.Context, event audit.Event) {
+	if claims, ok := c.Value(middlewares.CtxSessionTokenClaims).(*tokens.NexeresClaims); ok {
+		if event.OrgID == nil {
+			if orgID, err := uuid.Parse(claims.OrgId); err == nil {
+				event.OrgID = &orgID
+			}
+		}
+		if event.ActorID == nil {
+			if userID, err := uuid.Parse(claims.Subject); err == nil {
+				event.ActorID = &userID
+			}
+		}
+	}
+	audit.RecordRequest(c, event)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/middlewares"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/permissions"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type AuditLogsHandler struct {
+	ListAuditLogsCounter *prometheus.CounterVec
+}
+
+func NewAuditLogsHandler() *AuditLogsHandler {
+	return &AuditLogsHandler{
+		ListAuditLogsCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "audit_logs_list_requests",
+				Help:      "Total number of organization audit log list and export requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+func (h *AuditLogsHandler) Register(engine *gin.Engine) {
+	metrics.Collectors = append(metrics.Collectors, h.ListAuditLogsCounter)
+
+	requireSession := middlewares.RequireAuth(middlewares.AuthModeSession)
+	engine.GET("/api/orgs/:orgId/audit-logs", requireSession, middlewares.RequirePermission(permissions.AuditLogsRead), h.HandleListAuditLogs)
+}
+
+// HandleListAuditLogs godoc
+// @Summary List Organization Audit Logs
+// @Description Lists the audit logs of an organization, newest first, paginated with the returned cursor.
+// @Description With format "ndjson" or "csv", every matching log is streamed instead, ignoring the cursor and limit.
+// @Tags Orgs
+// @Produce json
+// @Produce application/x-ndjson
+// @Produce text/csv
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Param action query []string false "Actions to include, eg. auth.login.failed" collectionFormat(multi)
+// @Param actorId query string false "User who performed the actions"
+// @Param resourceType query string false "Type of the resource, eg. session"
+// @Param resourceId query string false "ID of the resource"
+// @Param ip query string false "IP address or CIDR range"
+// @Param from query string false "Start of the time range (inclusive), RFC 3339"
+// @Param to query string false "End of the time range (exclusive), RFC 3339"
+// @Param cursor query string false "Cursor of the next page, from the previous response"
+// @Param limit query int false "Maximum number of logs, default 50, max 1000"
+// @Param format query string false "json (default), ndjson or csv"
+// @Success 200 {object} audit.ListResult "Audit logs"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid filter or cursor"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'audit_logs:read'"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/audit-logs [get]
+func (h *AuditLogsHandler) HandleListAuditLogs(c *gin.Context) {
+	h.ListAuditLogsCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "list_org_audit_logs")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	// The organization always comes from the path, which RequirePermission checks against the session,
+	// so that org admins can only ever see their own organization's logs.
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.ListAuditLogsCounter, "list_org_audit_logs")
+		return
+	}
+
+	var params audit.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid query parameters", "Bad Request", http.StatusBadRequest, nil), span, log, h.ListAuditLogsCounter, "list_org_audit_logs")
+		return
+	}
+	filter, err := params.Filter()
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid filter: "+err.Error(), "Failed to parse the audit log filter", http.StatusBadRequest, nil), span, log, h.ListAuditLogsCounter, "list_org_audit_logs")
+		return
+	}
+
+	if params.Format == audit.FormatNDJSON || params.Format == audit.FormatCSV {
+		recordSessionAudit(c, audit.Event{
+			Action: audit.ActionAuditLogsExported,
+			OrgID:  &orgId,
+			Metadata: map[string]any{
+				"format": params.Format,
+				"filter": c.Request.URL.RawQuery,
+			},
+		})
+
+		c.Header("Content-Type", audit.ContentType(params.Format))
+		c.Header("Content-Disposition", `attachment; filename="audit-logs.`+params.Format+`"`)
+		c.Status(http.StatusOK)
+		if err := audit.Export(ctx, c.Writer, c.Writer.Flush, params.Format, &orgId, filter); err != nil {
+			// The response has already started, so the error can only be logged.
+			log.Error("Failed to export audit logs", zap.Error(err))
+			span.RecordError(err)
+			h.ListAuditLogsCounter.WithLabelValues("error").Inc()
+			return
+		}
+		h.ListAuditLogsCounter.WithLabelValues("success").Inc()
+		return
+	}
+
+	var cursor *audit.Cursor
+	if params.Cursor != "" {
+		cursor, err = audit.DecodeCursor(params.Cursor)
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse("Invalid cursor!", "Failed to decode the cursor", http.StatusBadRequest, nil), span, log, h.ListAuditLogsCounter, "list_org_audit_logs")
+			return
+		}
+	}
+	limit := params.Limit
+	if limit == 0 {
+		limit = audit.DefaultListLimit
+	}
+
+	logs, next, err := audit.List(ctx, &orgId, filter, cursor, limit)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to list audit logs!", http.StatusInternalServerError, err), span, log, h.ListAuditLogsCounter, "list_org_audit_logs")
+		return
+	}
+
+	h.ListAuditLogsCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, audit.NewListResult(logs, next))
+}
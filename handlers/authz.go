@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/authz"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// maxTuplesPerWrite is the maximum number of writes plus deletes in a single write-tuples request.
+const maxTuplesPerWrite = 100
+
+type AuthzHandler struct {
+	CheckCounter       *prometheus.CounterVec
+	ExpandCounter      *prometheus.CounterVec
+	ListObjectsCounter *prometheus.CounterVec
+	WriteTuplesCounter *prometheus.CounterVec
+}
+
+func NewAuthzHandler() *AuthzHandler {
+	return &AuthzHandler{
+		CheckCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "authz",
+				Name:      "check_requests",
+				Help:      "Total number of authorization check requests",
+			},
+			[]string{"status"},
+		),
+		ExpandCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "authz",
+				Name:      "expand_requests",
+				Help:      "Total number of authorization expand requests",
+			},
+			[]string{"status"},
+		),
+		ListObjectsCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "authz",
+				Name:      "list_objects_requests",
+				Help:      "Total number of authorization list objects requests",
+			},
+			[]string{"status"},
+		),
+		WriteTuplesCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "authz",
+				Name:      "write_tuples_requests",
+				Help:      "Total number of authorization tuple write requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+// Register registers the authorization routes, if enabled in the config.
+//
+// These routes are authenticated with the API key only, they are meant to be called by backend services.
+func (h *AuthzHandler) Register(engine *gin.Engine) {
+	if !config.Authz.Enable {
+		return
+	}
+	metrics.Collectors = append(metrics.Collectors, h.CheckCounter, h.ExpandCounter, h.ListObjectsCounter, h.WriteTuplesCounter)
+	engine.POST("/api/orgs/:orgId/authz/check", h.HandleCheck)
+	engine.POST("/api/orgs/:orgId/authz/expand", h.HandleExpand)
+	engine.POST("/api/orgs/:orgId/authz/list-objects", h.HandleListObjects)
+	engine.POST("/api/orgs/:orgId/authz/write-tuples", h.HandleWriteTuples)
+}
+
+type AuthzCheckData struct {
+	// The object, eg. "document:readme"
+	Object string `json:"object" binding:"required"`
+	// The relation, eg. "viewer"
+	Relation string `json:"relation" binding:"required"`
+	// The subject, eg. "user:alice" or "team:eng#member"
+	Subject string `json:"subject" binding:"required"`
+}
+
+type AuthzCheckResult struct {
+	Allowed bool `json:"allowed"`
+}
+
+type AuthzExpandData struct {
+	// The object, eg. "document:readme"
+	Object string `json:"object" binding:"required"`
+	// The relation, eg. "viewer"
+	Relation string `json:"relation" binding:"required"`
+}
+
+type AuthzExpandResult struct {
+	Tree *authz.ExpandNode `json:"tree"`
+}
+
+type AuthzListObjectsData struct {
+	// The namespace of the objects, eg. "document"
+	Namespace string `json:"namespace" binding:"required"`
+	// The relation, eg. "viewer"
+	Relation string `json:"relation" binding:"required"`
+	// The subject, eg. "user:alice" or "team:eng#member"
+	Subject string `json:"subject" binding:"required"`
+}
+
+type AuthzListObjectsResult struct {
+	// The IDs of the objects on which the subject has the relation.
+	Objects []string `json:"objects"`
+	// Truncated is true if not all the objects of the namespace were evaluated, see `authz.listObjectsLimit`.
+	Truncated bool `json:"truncated"`
+}
+
+type AuthzWriteTuplesData struct {
+	// Tuples to create, in the format "namespace:id#relation@subject". Existing tuples are ignored.
+	Writes []string `json:"writes,omitempty"`
+	// Tuples to delete, in the format "namespace:id#relation@subject". Missing tuples are ignored.
+	Deletes []string `json:"deletes,omitempty"`
+}
+
+type AuthzWriteTuplesResult struct {
+	Written int `json:"written"`
+	Deleted int `json:"deleted"`
+}
+
+// parseOrgID parses the `:orgId` route parameter.
+func parseOrgID(c *gin.Context) (uuid.UUID, *models.ErrorResponse) {
+	orgId, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		return uuid.Nil, models.NewErrorResponse("Invalid organization ID!", "Failed to parse organization ID", http.StatusBadRequest, nil)
+	}
+	return orgId, nil
+}
+
+// authzErrorResponse maps errors from the authz package to error responses.
+func authzErrorResponse(err error, debug string) *models.ErrorResponse {
+	if errors.Is(err, authz.ErrUnknownRelation) {
+		return models.NewErrorResponse(err.Error(), debug, http.StatusBadRequest, nil)
+	}
+	if errors.Is(err, authz.ErrMaxDepthExceeded) {
+		return models.NewErrorResponse("The relation is nested too deeply to be evaluated!", debug, http.StatusUnprocessableEntity, nil)
+	}
+	return models.NewErrorResponse(models.GenericErrorMessage, debug, http.StatusInternalServerError, err)
+}
+
+// HandleCheck godoc
+// @Summary Check Relation
+// @Description Checks whether the subject has the relation on the object, following usersets and the schema's rewrites.
+// @Tags Authz
+// @Accept json
+// @Produce json
+// @Param X-NEXERES-API-Key header string true "API key"
+// @Param orgId path string true "Organization ID"
+// @Param data body AuthzCheckData true "Check data"
+// @Success 200 {object} AuthzCheckResult "Check result"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid object, relation or subject"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized - Invalid API key"
+// @Failure 422 {object} models.ErrorResponse "Relation nested too deeply"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/authz/check [post]
+func (h *AuthzHandler) HandleCheck(c *gin.Context) {
+	h.CheckCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "authz_check")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.CheckCounter, "authz_check")
+		return
+	}
+
+	var data AuthzCheckData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.CheckCounter, "authz_check")
+		return
+	}
+
+	object, err := authz.ParseObject(data.Object)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(err.Error(), "Invalid object", http.StatusBadRequest, nil), span, log, h.CheckCounter, "authz_check")
+		return
+	}
+	subject, err := authz.ParseSubject(data.Subject)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(err.Error(), "Invalid subject", http.StatusBadRequest, nil), span, log, h.CheckCounter, "authz_check")
+		return
+	}
+
+	allowed, err := authz.Check(ctx, store.Querier, orgId, object, data.Relation, subject)
+	if err != nil {
+		utils.ProcessError(c, authzErrorResponse(err, "Failed to check relation"), span, log, h.CheckCounter, "authz_check")
+		return
+	}
+
+	h.CheckCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, AuthzCheckResult{
+		Allowed: allowed,
+	})
+}
+
+// HandleExpand godoc
+// @Summary Expand Relation
+// @Description Returns the tree of all the subjects having the relation on the object.
+// @Tags Authz
+// @Accept json
+// @Produce json
+// @Param X-NEXERES-API-Key header string true "API key"
+// @Param orgId path string true "Organization ID"
+// @Param data body AuthzExpandData true "Expand data"
+// @Success 200 {object} AuthzExpandResult "Userset tree"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid object or relation"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized - Invalid API key"
+// @Failure 422 {object} models.ErrorResponse "Relation nested too deeply"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/authz/expand [post]
+func (h *AuthzHandler) HandleExpand(c *gin.Context) {
+	h.ExpandCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "authz_expand")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.ExpandCounter, "authz_expand")
+		return
+	}
+
+	var data AuthzExpandData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.ExpandCounter, "authz_expand")
+		return
+	}
+
+	object, err := authz.ParseObject(data.Object)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(err.Error(), "Invalid object", http.StatusBadRequest, nil), span, log, h.ExpandCounter, "authz_expand")
+		return
+	}
+
+	tree, err := authz.Expand(ctx, store.Querier, orgId, object, data.Relation)
+	if err != nil {
+		utils.ProcessError(c, authzErrorResponse(err, "Failed to expand relation"), span, log, h.ExpandCounter, "authz_expand")
+		return
+	}
+
+	h.ExpandCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, AuthzExpandResult{
+		Tree: tree,
+	})
+}
+
+// HandleListObjects godoc
+// @Summary List Objects
+// @Description Lists the objects of a namespace on which the subject has the relation.
+// @Tags Authz
+// @Accept json
+// @Produce json
+// @Param X-NEXERES-API-Key header string true "API key"
+// @Param orgId path string true "Organization ID"
+// @Param data body AuthzListObjectsData true "List objects data"
+// @Success 200 {object} AuthzListObjectsResult "Object IDs"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid namespace, relation or subject"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized - Invalid API key"
+// @Failure 422 {object} models.ErrorResponse "Relation nested too deeply"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/authz/list-objects [post]
+func (h *AuthzHandler) HandleListObjects(c *gin.Context) {
+	h.ListObjectsCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "authz_list_objects")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.ListObjectsCounter, "authz_list_objects")
+		return
+	}
+
+	var data AuthzListObjectsData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.ListObjectsCounter, "authz_list_objects")
+		return
+	}
+
+	subject, err := authz.ParseSubject(data.Subject)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(err.Error(), "Invalid subject", http.StatusBadRequest, nil), span, log, h.ListObjectsCounter, "authz_list_objects")
+		return
+	}
+
+	objects, truncated, err := authz.ListObjects(ctx, store.Querier, orgId, data.Namespace, data.Relation, subject)
+	if err != nil {
+		utils.ProcessError(c, authzErrorResponse(err, "Failed to list objects"), span, log, h.ListObjectsCounter, "authz_list_objects")
+		return
+	}
+
+	h.ListObjectsCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, AuthzListObjectsResult{
+		Objects:   objects,
+		Truncated: truncated,
+	})
+}
+
+// HandleWriteTuples godoc
+// @Summary Write Tuples
+// @Description Creates and deletes relation tuples atomically. At most 100 tuples can be written and deleted per request.
+// @Tags Authz
+// @Accept json
+// @Produce json
+// @Param X-NEXERES-API-Key header string true "API key"
+// @Param orgId path string true "Organization ID"
+// @Param data body AuthzWriteTuplesData true "Tuples to write and delete"
+// @Success 200 {object} AuthzWriteTuplesResult "Write result"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid tuples"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized - Invalid API key"
+// @Failure 404 {object} models.ErrorResponse "Organization not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/authz/write-tuples [post]
+func (h *AuthzHandler) HandleWriteTuples(c *gin.Context) {
+	h.WriteTuplesCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "authz_write_tuples")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.WriteTuplesCounter, "authz_write_tuples")
+		return
+	}
+
+	var data AuthzWriteTuplesData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.WriteTuplesCounter, "authz_write_tuples")
+		return
+	}
+
+	if len(data.Writes)+len(data.Deletes) == 0 || len(data.Writes)+len(data.Deletes) > maxTuplesPerWrite {
+		utils.ProcessError(c, models.NewErrorResponse("Provide between 1 and 100 tuples to write or delete!", "Invalid number of tuples", http.StatusBadRequest, nil), span, log, h.WriteTuplesCounter, "authz_write_tuples")
+		return
+	}
+
+	parse := func(raw []string) ([]authz.Tuple, error) {
+		tuples := make([]authz.Tuple, 0, len(raw))
+		for _, r := range raw {
+			t, err := authz.ParseTuple(r)
+			if err != nil {
+				return nil, err
+			}
+			if err := authz.ValidateTuple(t); err != nil {
+				return nil, err
+			}
+			tuples = append(tuples, t)
+		}
+		return tuples, nil
+	}
+
+	writes, err := parse(data.Writes)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(err.Error(), "Invalid tuple to write", http.StatusBadRequest, nil), span, log, h.WriteTuplesCounter, "authz_write_tuples")
+		return
+	}
+	deletes, err := parse(data.Deletes)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(err.Error(), "Invalid tuple to delete", http.StatusBadRequest, nil), span, log, h.WriteTuplesCounter, "authz_write_tuples")
+		return
+	}
+
+	tx, err := store.PgPool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to begin transaction!", http.StatusInternalServerError, err), span, log, h.WriteTuplesCounter, "authz_write_tuples")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	q := store.Querier.WithTx(tx)
+
+	// Deletes are applied first, so that a tuple in both lists ends up written.
+	for _, t := range deletes {
+		if err := q.DeleteAuthzTuple(ctx, db.DeleteAuthzTupleParams{
+			OrgID:            orgId,
+			ObjectNamespace:  t.Object.Namespace,
+			ObjectID:         t.Object.ID,
+			Relation:         t.Relation,
+			SubjectNamespace: t.Subject.Namespace,
+			SubjectID:        t.Subject.ID,
+			SubjectRelation:  t.Subject.Relation,
+		}); err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to delete tuple!", http.StatusInternalServerError, err), span, log, h.WriteTuplesCounter, "authz_write_tuples")
+			return
+		}
+	}
+
+	for _, t := range writes {
+		if err := q.WriteAuthzTuple(ctx, db.WriteAuthzTupleParams{
+			OrgID:            orgId,
+			ObjectNamespace:  t.Object.Namespace,
+			ObjectID:         t.Object.ID,
+			Relation:         t.Relation,
+			SubjectNamespace: t.Subject.Namespace,
+			SubjectID:        t.Subject.ID,
+			SubjectRelation:  t.Subject.Relation,
+		}); err != nil {
+			if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23503" {
+				utils.ProcessError(c, models.NewErrorResponse("Organization not found!", "Foreign key violation on org_id", http.StatusNotFound, nil), span, log, h.WriteTuplesCounter, "authz_write_tuples")
+				return
+			}
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to write tuple!", http.StatusInternalServerError, err), span, log, h.WriteTuplesCounter, "authz_write_tuples")
+			return
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to commit transaction!", http.StatusInternalServerError, err), span, log, h.WriteTuplesCounter, "authz_write_tuples")
+		return
+	}
+
+	// The tuples are already committed, a failure here only delays the visibility of the change until the cache expires.
+	if err := authz.InvalidateChecks(ctx, orgId); err != nil {
+		log.Warn("Failed to invalidate cached authz checks", zap.String("orgId", orgId.String()), zap.Error(err))
+	}
+
+	h.WriteTuplesCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, AuthzWriteTuplesResult{
+		Written: len(writes),
+		Deleted: len(deletes),
+	})
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/notifications"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// DebugMailboxHandler serves the emails stored by the file and memory email providers, to read them without an SMTP server.
+//
+// It must only be registered in debug mode, and before the API key middleware, so that the mailbox can be opened in a browser.
+type DebugMailboxHandler struct {
+	MailboxCounter *prometheus.CounterVec
+}
+
+func NewDebugMailboxHandler() *DebugMailboxHandler {
+	return &DebugMailboxHandler{
+		MailboxCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "debug",
+				Name:      "mailbox_requests",
+				Help:      "Total number of debug mailbox requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+func (h *DebugMailboxHandler) Register(engine *gin.Engine) {
+	metrics.Collectors = append(metrics.Collectors, h.MailboxCounter)
+	engine.GET("/debug/mailbox", h.HandleMailboxPage)
+	engine.GET("/debug/mailbox/messages", h.HandleListMessages)
+	engine.DELETE("/debug/mailbox/messages", h.HandleClearMessages)
+	engine.GET("/debug/mailbox/messages/:messageId", h.HandleGetMessage)
+	engine.GET("/debug/mailbox/messages/:messageId/html", h.HandleGetMessageHTML)
+}
+
+type MailboxMessagesResult struct {
+	Messages []notifications.MailboxMessage `json:"messages"`
+}
+
+// mailboxUnavailable returns the error response when the email provider does not store emails.
+func mailboxUnavailable() *models.ErrorResponse {
+	return models.NewErrorResponse("The mailbox is only available with the file or memory email provider!", "notifications.Mailbox is nil", http.StatusNotFound, nil)
+}
+
+// HandleListMessages godoc
+// @Summary List mailbox emails
+// @Description Lists the emails stored by the file or memory email provider, newest first. Debug mode only.
+// @Tags Debug
+// @Produce json
+// @Param to query string false "Only the emails to this address"
+// @Success 200 {object} MailboxMessagesResult "Emails"
+// @Failure 404 {object} models.ErrorResponse "The email provider does not store emails"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /debug/mailbox/messages [get]
+func (h *DebugMailboxHandler) HandleListMessages(c *gin.Context) {
+	h.MailboxCounter.WithLabelValues("received").Inc()
+
+	_, log, span := internal.WithContext(c.Request.Context(), "list_mailbox_messages")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	if notifications.Mailbox == nil {
+		utils.ProcessError(c, mailboxUnavailable(), span, log, h.MailboxCounter, "list_mailbox_messages")
+		return
+	}
+
+	messages, err := notifications.Mailbox.Messages(c.Query("to"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to list the mailbox emails!", http.StatusInternalServerError, err), span, log, h.MailboxCounter, "list_mailbox_messages")
+		return
+	}
+
+	h.MailboxCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, MailboxMessagesResult{Messages: messages})
+}
+
+// HandleGetMessage godoc
+// @Summary Get mailbox email
+// @Description Returns an email stored by the file or memory email provider. Debug mode only.
+// @Tags Debug
+// @Produce json
+// @Param messageId path string true "Email ID"
+// @Success 200 {object} notifications.MailboxMessage "Email"
+// @Failure 404 {object} models.ErrorResponse "Email not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /debug/mailbox/messages/{messageId} [get]
+func (h *DebugMailboxHandler) HandleGetMessage(c *gin.Context) {
+	h.MailboxCounter.WithLabelValues("received").Inc()
+
+	_, log, span := internal.WithContext(c.Request.Context(), "get_mailbox_message")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	message, errResp := getMailboxMessage(c.Param("messageId"))
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.MailboxCounter, "get_mailbox_message")
+		return
+	}
+
+	h.MailboxCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, message)
+}
+
+// HandleGetMessageHTML godoc
+// @Summary Get mailbox email HTML
+// @Description Returns the HTML body of an email stored by the file or memory email provider, as a page. Debug mode only.
+// @Tags Debug
+// @Produce html
+// @Param messageId path string true "Email ID"
+// @Success 200 {string} string "HTML body"
+// @Failure 404 {object} models.ErrorResponse "Email not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /debug/mailbox/messages/{messageId}/html [get]
+func (h *DebugMailboxHandler) HandleGetMessageHTML(c *gin.Context) {
+	h.MailboxCounter.WithLabelValues("received").Inc()
+
+	_, log, span := internal.WithContext(c.Request.Context(), "get_mailbox_message_html")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	message, errResp := getMailboxMessage(c.Param("messageId"))
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.MailboxCounter, "get_mailbox_message_html")
+		return
+	}
+
+	h.MailboxCounter.WithLabelValues("success").Inc()
+	// The email must not run scripts in the origin of the API, even in debug mode.
+	c.Header("Content-Security-Policy", "sandbox; default-src 'none'; img-src * data:; style-src 'unsafe-inline'")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(message.HTML))
+}
+
+// HandleClearMessages godoc
+// @Summary Clear mailbox
+// @Description Deletes all emails stored by the file or memory email provider, eg. between integration tests. Debug mode only.
+// @Tags Debug
+// @Success 204 "Mailbox cleared"
+// @Failure 404 {object} models.ErrorResponse "The email provider does not store emails"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /debug/mailbox/messages [delete]
+func (h *DebugMailboxHandler) HandleClearMessages(c *gin.Context) {
+	h.MailboxCounter.WithLabelValues("received").Inc()
+
+	_, log, span := internal.WithContext(c.Request.Context(), "clear_mailbox_messages")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	if notifications.Mailbox == nil {
+		utils.ProcessError(c, mailboxUnavailable(), span, log, h.MailboxCounter, "clear_mailbox_messages")
+		return
+	}
+
+	if err := notifications.Mailbox.Clear(); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to clear the mailbox!", http.StatusInternalServerError, err), span, log, h.MailboxCounter, "clear_mailbox_messages")
+		return
+	}
+
+	h.MailboxCounter.WithLabelValues("success").Inc()
+	c.Status(http.StatusNoContent)
+}
+
+// HandleMailboxPage serves a page listing the emails in the mailbox, with a preview of the selected one.
+func (h *DebugMailboxHandler) HandleMailboxPage(c *gin.Context) {
+	h.MailboxCounter.WithLabelValues("received").Inc()
+
+	_, log, span := internal.WithContext(c.Request.Context(), "mailbox_page")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	if notifications.Mailbox == nil {
+		utils.ProcessError(c, mailboxUnavailable(), span, log, h.MailboxCounter, "mailbox_page")
+		return
+	}
+
+	messages, err := notifications.Mailbox.Messages(c.Query("to"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to list the mailbox emails!", http.StatusInternalServerError, err), span, log, h.MailboxCounter, "mailbox_page")
+		return
+	}
+
+	data := struct {
+		To       string
+		Messages []notifications.MailboxMessage
+		Selected *notifications.MailboxMessage
+	}{
+		To:       c.Query("to"),
+		Messages: messages,
+	}
+	if id := c.Query("id"); id != "" {
+		data.Selected, _ = notifications.Mailbox.Message(id)
+	} else if len(messages) > 0 {
+		data.Selected = &messages[0]
+	}
+
+	h.MailboxCounter.WithLabelValues("success").Inc()
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := mailboxPage.Execute(c.Writer, data); err != nil {
+		log.Warn("Failed to render the mailbox page", zap.Error(err))
+	}
+}
+
+// getMailboxMessage returns the email with the ID from the mailbox, or the error response.
+func getMailboxMessage(id string) (*notifications.MailboxMessage, *models.ErrorResponse) {
+	if notifications.Mailbox == nil {
+		return nil, mailboxUnavailable()
+	}
+	message, err := notifications.Mailbox.Message(id)
+	if errors.Is(err, notifications.ErrMailboxMessageNotFound) {
+		return nil, models.NewErrorResponse("Email not found!", "No email found in the mailbox with the given ID", http.StatusNotFound, nil)
+	}
+	if err != nil {
+		return nil, models.NewErrorResponse(models.GenericErrorMessage, "Failed to read the mailbox email!", http.StatusInternalServerError, err)
+	}
+	return message, nil
+}
+
+var mailboxPage = template.Must(template.New("mailbox").Parse(`<!doctype html>
+<html>
+	<head>
+		<title>Mailbox</title>
+		<meta charset="utf-8" />
+		<meta name="viewport" content="width=device-width, initial-scale=1" />
+		<style>
+			body { margin: 0; font-family: system-ui, sans-serif; display: flex; height: 100vh; }
+			nav { width: 360px; border-right: 1px solid #ddd; overflow-y: auto; }
+			nav form { display: flex; gap: 4px; padding: 8px; border-bottom: 1px solid #ddd; }
+			nav form input { flex: 1; }
+			nav a { display: block; padding: 8px 12px; border-bottom: 1px solid #eee; color: inherit; text-decoration: none; }
+			nav a.selected { background: #eef2ff; }
+			nav small { color: #666; }
+			main { flex: 1; display: flex; flex-direction: column; min-width: 0; }
+			header { padding: 12px; border-bottom: 1px solid #ddd; }
+			header ul { margin: 4px 0 0; padding-left: 20px; word-break: break-all; }
+			iframe { flex: 1; border: 0; }
+			details { border-top: 1px solid #ddd; padding: 8px 12px; max-height: 30vh; overflow-y: auto; }
+			p.empty { padding: 12px; color: #666; }
+		</style>
+	</head>
+	<body>
+		<nav>
+			<form method="get">
+				<input type="email" name="to" placeholder="Recipient" value="{{.To}}" />
+				<button type="submit">Filter</button>
+			</form>
+			{{range .Messages}}
+			<a href="?id={{.ID}}{{if $.To}}&to={{$.To}}{{end}}"{{if and $.Selected (eq .ID $.Selected.ID)}} class="selected"{{end}}>
+				<strong>{{.Subject}}</strong><br />
+				<small>{{.To}} &middot; {{.SentAt.Format "2006-01-02 15:04:05 MST"}}</small>
+			</a>
+			{{else}}
+			<p class="empty">No emails.</p>
+			{{end}}
+		</nav>
+		<main>
+			{{with .Selected}}
+			<header>
+				<strong>{{.Subject}}</strong><br />
+				<small>To {{.To}}{{if .FromName}}, from {{.FromName}}{{end}}, sent {{.SentAt.Format "2006-01-02 15:04:05 MST"}}</small>
+				{{if .Links}}<ul>{{range .Links}}<li><a href="{{.}}" target="_blank" rel="noopener">{{.}}</a></li>{{end}}</ul>{{end}}
+			</header>
+			<iframe src="/debug/mailbox/messages/{{.ID}}/html" sandbox></iframe>
+			<details>
+				<summary>Plain text</summary>
+				<pre>{{.PlainText}}</pre>
+			</details>
+			{{else}}
+			<p class="empty">Select an email.</p>
+			{{end}}
+		</main>
+	</body>
+</html>`))
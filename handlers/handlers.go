@@ -17,8 +17,22 @@ func RegisterAPIRoutes(engine *gin.Engine) {
 		NewLoginHandler(),
 		NewRefreshTokenHandler(),
 		NewLogoutHandler(),
+		NewOrgRolesHandler(),
+		NewOrgSettingsHandler(),
+		NewOrgBrandingHandler(),
+		NewTenantHandler(),
+		NewAuthzHandler(),
+		NewAuditLogsHandler(),
+		NewWebhooksHandler(),
+		NewMeHandler(),
 		admin_handlers.NewAdminLoginHandler(),
 		admin_handlers.NewConfigHandler(),
+		admin_handlers.NewAuditLogsHandler(),
+		admin_handlers.NewNotificationsHandler(),
+		admin_handlers.NewTemplatesHandler(),
+		admin_handlers.NewAPIKeysHandler(),
+		admin_handlers.NewUsersHandler(),
+		admin_handlers.NewOrgsHandler(),
 	}
 
 	// Register API routes
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/hooks"
+	"github.com/nbrglm/nexeres/internal/models"
+)
+
+// newHookRequest returns the request of the login hooks, for the user logging in to the org with the role.
+func newHookRequest(c *gin.Context, user *db.User, org *db.Org, role string) hooks.Request {
+	return hooks.Request{
+		User: hooks.User{
+			ID:            user.ID,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			FirstName:     user.FirstName,
+			LastName:      user.LastName,
+		},
+		Org: hooks.Org{
+			ID:   org.ID,
+			Slug: org.Slug,
+			Name: org.Name,
+		},
+		Role:      role,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// runPreLoginHooks calls the pre-login hooks.
+//
+// If a hook denies the login, or fails closed, the failed login is recorded and an error response is returned.
+func runPreLoginHooks(ctx context.Context, c *gin.Context, req hooks.Request) *models.ErrorResponse {
+	result, err := hooks.PreLogin(ctx, req)
+	if err != nil {
+		auditLoginFailure(c, &req.Org.ID, req.User.Email, &req.User.ID, "hook_failed")
+		return models.NewErrorResponse("Login is temporarily unavailable! Please try again later.", "A pre-login hook failed", http.StatusServiceUnavailable, err)
+	}
+	if result.Decision == hooks.DecisionDeny {
+		auditLoginFailure(c, &req.Org.ID, req.User.Email, &req.User.ID, "hook_denied")
+		message := result.Message
+		if message == "" {
+			message = "Login is not allowed! Please contact your administrator."
+		}
+		return models.NewErrorResponse(message, "A pre-login hook denied the login", http.StatusForbidden, nil)
+	}
+	return nil
+}
+
+// runPreTokenHooks calls the pre-token hooks, and returns the custom claims for the "ext" claim.
+func runPreTokenHooks(ctx context.Context, req hooks.Request) (map[string]any, *models.ErrorResponse) {
+	claims, err := hooks.PreToken(ctx, req)
+	if err != nil {
+		return nil, models.NewErrorResponse("Unable to issue tokens right now! Please try again later.", "A pre-token hook failed", http.StatusServiceUnavailable, err)
+	}
+	return claims, nil
+}
@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/netip"
+	"slices"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -13,12 +15,18 @@ import (
 	"github.com/nbrglm/nexeres/config"
 	"github.com/nbrglm/nexeres/db"
 	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
 	"github.com/nbrglm/nexeres/internal/cache"
+	"github.com/nbrglm/nexeres/internal/hooks"
 	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/middlewares"
 	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/orgsettings"
 	"github.com/nbrglm/nexeres/internal/password"
+	"github.com/nbrglm/nexeres/internal/permissions"
 	"github.com/nbrglm/nexeres/internal/store"
 	"github.com/nbrglm/nexeres/internal/tokens"
+	"github.com/nbrglm/nexeres/internal/webhooks"
 	"github.com/nbrglm/nexeres/opts"
 	"github.com/nbrglm/nexeres/utils"
 	"github.com/prometheus/client_golang/prometheus"
@@ -64,6 +72,10 @@ type UserLoginResult struct {
 	Tokens                   *tokens.Tokens `json:"tokens,omitempty"`
 	RequireEmailVerification bool           `json:"requireEmailVerification"`
 	FlowID                   *string        `json:"flowId,omitempty"`
+
+	// RequireMFA is true if the organization requires multi-factor authentication.
+	// No tokens are issued, and the MFA step must be completed with the returned flow ID.
+	RequireMFA bool `json:"requireMfa"`
 }
 
 // HandleLogin godoc
@@ -76,6 +88,7 @@ type UserLoginResult struct {
 // @Success 200 {object} UserLoginResult "User Login Result"
 // @Failure 400 {object} models.ErrorResponse "Bad Request"
 // @Failure 401 {object} models.ErrorResponse "Unauthorized - Invalid Credentials or User does not belong to any organization"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Login method or IP address not allowed by the organization"
 // @Failure 500 {object} models.ErrorResponse "Internal Server Error"
 // @Router /api/auth/login [post]
 func (h *LoginHandler) HandleLogin(c *gin.Context) {
@@ -112,6 +125,7 @@ func (h *LoginHandler) handleSingleTenantLogin(c *gin.Context) {
 	user, err := q.GetLoginInfoForUser(ctx, loginData.Email)
 	if errors.Is(err, pgx.ErrNoRows) {
 		log.Debug("User not found", zap.String("email", loginData.Email))
+		auditLoginFailure(c, nil, loginData.Email, nil, "user_not_found")
 		utils.ProcessError(c, models.NewErrorResponse("Invalid email or password! Please try again.", "User not found!", http.StatusUnauthorized, nil), span, log, h.LoginCounter, "login")
 		return
 	}
@@ -132,15 +146,87 @@ func (h *LoginHandler) handleSingleTenantLogin(c *gin.Context) {
 	log.Debug("Verifying user password")
 	if !password.VerifyPasswordMatch(*user.PasswordHash, loginData.Password) {
 		log.Debug("Password mismatch", zap.String("email", loginData.Email))
+		auditLoginFailure(c, nil, loginData.Email, &user.ID, "invalid_password")
 		utils.ProcessError(c, models.NewErrorResponse("Invalid credentials! Please try again.", "Password mismatch!", http.StatusUnauthorized, nil), span, log, h.LoginCounter, "login")
 		return
 	}
 
+	membership, err := q.GetUserOrgMembership(ctx, db.GetUserOrgMembershipParams{
+		UserID: user.ID,
+		OrgID:  uuid.MustParse(opts.DefaultOrgId),
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		auditLoginFailure(c, nil, loginData.Email, &user.ID, "not_a_member")
+		utils.ProcessError(c, models.NewErrorResponse("You do not belong to any organization! Please contact your administrator.", "User is not a member of the default organization!", http.StatusUnauthorized, nil), span, log, h.LoginCounter, "login")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to retrieve user membership!", http.StatusInternalServerError, err), span, log, h.LoginCounter, "login")
+		return
+	}
+
+	org, err := q.GetOrgByID(ctx, membership.OrgID)
+	if err != nil {
+		// we return the underlying error here because default org is ALWAYS supposed to be found
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get default organization!", http.StatusInternalServerError, err), span, log, h.LoginCounter, "login")
+		return
+	}
+
+	policy, errResp := loginSecurityPolicy(c, &org)
+	if errResp != nil {
+		auditLoginFailure(c, &org.ID, loginData.Email, &user.ID, "security_policy")
+		utils.ProcessError(c, errResp, span, log, h.LoginCounter, "login")
+		return
+	}
+
+	hookReq := newHookRequest(c, &user, &org, membership.Role)
+	errResp = runPreLoginHooks(ctx, c, hookReq)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.LoginCounter, "login")
+		return
+	}
+
+	if policy.RequireMFA {
+		flow, err := storeMFALoginFlow(ctx, &user, &org, loginData.FlowReturnTo)
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to store flow data!", http.StatusInternalServerError, err), span, log, h.LoginCounter, "login")
+			return
+		}
+		log.Debug("Organization requires MFA, returning flow ID", zap.String("flowId", flow.ID))
+		audit.RecordRequest(c, audit.Event{
+			Action:     audit.ActionLoginMFARequired,
+			OrgID:      &org.ID,
+			ActorID:    &user.ID,
+			ResourceID: &user.ID,
+		})
+		h.LoginCounter.WithLabelValues("mfa_required").Inc()
+		c.JSON(http.StatusOK, &UserLoginResult{
+			Message:    "Multi-factor authentication is required. Please complete the verification to continue.",
+			FlowID:     &flow.ID,
+			RequireMFA: true,
+		})
+		return
+	}
+
+	perms, err := permissions.ResolveRolePermissions(ctx, q, membership.OrgID, membership.Role)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to resolve role permissions!", http.StatusInternalServerError, err), span, log, h.LoginCounter, "login")
+		return
+	}
+
 	avatarUrl := ""
 	if user.AvatarUrl != nil {
 		avatarUrl = *user.AvatarUrl
 	}
 
+	hookReq.Trigger = hooks.TriggerLogin
+	hookReq.Permissions = perms
+	ext, errResp := runPreTokenHooks(ctx, hookReq)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.LoginCounter, "login")
+		return
+	}
+
 	log.Debug("Generating tokens")
 	tokensResult, err := tokens.GenerateTokens(user.ID, tokens.NexeresClaims{
 		OrgSlug: opts.DefaultOrgSlug,
@@ -152,8 +238,10 @@ func (h *LoginHandler) handleSingleTenantLogin(c *gin.Context) {
 		UserFname:     *user.FirstName,
 		UserLname:     *user.LastName,
 		UserAvatarURL: avatarUrl,
-		UserOrgRole:   "member",
-	})
+		UserOrgRole:   membership.Role,
+		Permissions:   perms,
+		Ext:           ext,
+	}, policy.Lifetimes())
 	if err != nil {
 		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to generate token pair!", http.StatusInternalServerError, err), span, log, h.LoginCounter, "login")
 		return
@@ -188,11 +276,26 @@ func (h *LoginHandler) handleSingleTenantLogin(c *gin.Context) {
 		return
 	}
 
+	if err := webhooks.Enqueue(ctx, q, org.ID, webhooks.EventSessionCreated, map[string]any{
+		"sessionId": tokensResult.SessionId,
+		"userId":    user.ID,
+	}); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to enqueue webhooks!", http.StatusInternalServerError, err), span, log, h.LoginCounter, "login")
+		return
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to commit transaction!", http.StatusInternalServerError, err), span, log, h.LoginCounter, "login")
 		return
 	}
 
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionLoginSucceeded,
+		OrgID:      &org.ID,
+		ActorID:    &user.ID,
+		ResourceID: &tokensResult.SessionId,
+	})
+
 	log.Debug("Login successful", zap.String("email", user.Email), zap.String("sessionId", tokensResult.SessionId.String()))
 	c.JSON(http.StatusOK, &UserLoginResult{
 		Message: "Login successful",
@@ -230,6 +333,7 @@ func (h *LoginHandler) handleMultitenantLogin(c *gin.Context) {
 
 	user, err := q.GetLoginInfoForUser(ctx, loginData.Email)
 	if errors.Is(err, pgx.ErrNoRows) {
+		auditLoginFailure(c, nil, loginData.Email, nil, "user_not_found")
 		utils.ProcessError(c, models.NewErrorResponse("Invalid email or password! Please try again.", "User not found!", http.StatusUnauthorized, nil), span, log, h.LoginCounter, "login")
 		return
 	}
@@ -248,6 +352,7 @@ func (h *LoginHandler) handleMultitenantLogin(c *gin.Context) {
 	}
 
 	if !password.VerifyPasswordMatch(*user.PasswordHash, loginData.Password) {
+		auditLoginFailure(c, nil, loginData.Email, &user.ID, "invalid_password")
 		utils.ProcessError(c, models.NewErrorResponse("Invalid credentials! Please try again.", "Password mismatch!", http.StatusUnauthorized, nil), span, log, h.LoginCounter, "login")
 		return
 	}
@@ -255,6 +360,7 @@ func (h *LoginHandler) handleMultitenantLogin(c *gin.Context) {
 	// Fetch the organizations the user belongs to
 	orgs, err := q.GetUserOrgsByEmail(ctx, &user.Email)
 	if errors.Is(err, pgx.ErrNoRows) {
+		auditLoginFailure(c, nil, loginData.Email, &user.ID, "not_a_member")
 		utils.ProcessError(c, models.NewErrorResponse("You do not belong to any organization! Please contact your administrator.", "No organizations found for the user!", http.StatusUnauthorized, nil), span, log, h.LoginCounter, "login")
 		return
 	}
@@ -262,16 +368,80 @@ func (h *LoginHandler) handleMultitenantLogin(c *gin.Context) {
 		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to retrieve user organizations!", http.StatusInternalServerError, err), span, log, h.LoginCounter, "login")
 		return
 	}
+	// On a tenant host, the user can only login to that organization.
+	if tenant := middlewares.GetTenant(c); tenant != nil {
+		idx := slices.IndexFunc(orgs, func(o db.GetUserOrgsByEmailRow) bool {
+			return o.Org.ID == tenant.ID
+		})
+		if idx < 0 {
+			auditLoginFailure(c, nil, loginData.Email, &user.ID, "not_a_member")
+			utils.ProcessError(c, models.NewErrorResponse("You do not belong to this organization! Please contact your administrator.", "User is not a member of the tenant organization!", http.StatusUnauthorized, nil), span, log, h.LoginCounter, "login")
+			return
+		}
+		orgs = orgs[idx : idx+1]
+	}
 	if len(orgs) == 0 {
+		auditLoginFailure(c, nil, loginData.Email, &user.ID, "not_a_member")
 		utils.ProcessError(c, models.NewErrorResponse("You do not belong to any organization! Please contact your administrator.", "No organizations found for the user!", http.StatusUnauthorized, nil), span, log, h.LoginCounter, "login")
 		return
 	}
+	// If the user belongs to a single organization (or is on a tenant host), the org picker is skipped.
 	if len(orgs) == 1 {
+		policy, errResp := loginSecurityPolicy(c, &orgs[0].Org)
+		if errResp != nil {
+			auditLoginFailure(c, &orgs[0].Org.ID, loginData.Email, &user.ID, "security_policy")
+			utils.ProcessError(c, errResp, span, log, h.LoginCounter, "login")
+			return
+		}
+
+		hookReq := newHookRequest(c, &user, &orgs[0].Org, orgs[0].UserOrg.Role)
+		errResp = runPreLoginHooks(ctx, c, hookReq)
+		if errResp != nil {
+			utils.ProcessError(c, errResp, span, log, h.LoginCounter, "login")
+			return
+		}
+
+		if policy.RequireMFA {
+			flow, err := storeMFALoginFlow(ctx, &user, &orgs[0].Org, loginData.FlowReturnTo)
+			if err != nil {
+				utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to store flow data!", http.StatusInternalServerError, err), span, log, h.LoginCounter, "login")
+				return
+			}
+			log.Debug("Organization requires MFA, returning flow ID", zap.String("flowId", flow.ID))
+			audit.RecordRequest(c, audit.Event{
+				Action:     audit.ActionLoginMFARequired,
+				OrgID:      &orgs[0].Org.ID,
+				ActorID:    &user.ID,
+				ResourceID: &user.ID,
+			})
+			h.LoginCounter.WithLabelValues("mfa_required").Inc()
+			c.JSON(http.StatusOK, &UserLoginResult{
+				Message:    "Multi-factor authentication is required. Please complete the verification to continue.",
+				FlowID:     &flow.ID,
+				RequireMFA: true,
+			})
+			return
+		}
+
+		perms, err := permissions.ResolveRolePermissions(ctx, q, orgs[0].Org.ID, orgs[0].UserOrg.Role)
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to resolve role permissions!", http.StatusInternalServerError, err), span, log, h.LoginCounter, "login")
+			return
+		}
+
 		avatarUrl := ""
 		if user.AvatarUrl != nil {
 			avatarUrl = *user.AvatarUrl
 		}
 
+		hookReq.Trigger = hooks.TriggerLogin
+		hookReq.Permissions = perms
+		ext, errResp := runPreTokenHooks(ctx, hookReq)
+		if errResp != nil {
+			utils.ProcessError(c, errResp, span, log, h.LoginCounter, "login")
+			return
+		}
+
 		// If the user belongs to a single organization, create a session for that organization
 		result, err := tokens.GenerateTokens(user.ID, tokens.NexeresClaims{
 			OrgSlug: orgs[0].Org.Slug,
@@ -284,7 +454,9 @@ func (h *LoginHandler) handleMultitenantLogin(c *gin.Context) {
 			UserLname:     *user.LastName,
 			UserAvatarURL: avatarUrl,
 			UserOrgRole:   orgs[0].UserOrg.Role,
-		})
+			Permissions:   perms,
+			Ext:           ext,
+		}, policy.Lifetimes())
 		if err != nil {
 			utils.ProcessError(c, models.NewErrorResponse("An error occurred while processing your request. Please try again later.", "Failed to generate token pair!", http.StatusInternalServerError, err), span, log, h.LoginCounter, "login")
 			return
@@ -315,11 +487,26 @@ func (h *LoginHandler) handleMultitenantLogin(c *gin.Context) {
 			return
 		}
 
+		if err := webhooks.Enqueue(ctx, q, orgs[0].Org.ID, webhooks.EventSessionCreated, map[string]any{
+			"sessionId": result.SessionId,
+			"userId":    user.ID,
+		}); err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to enqueue webhooks!", http.StatusInternalServerError, err), span, log, h.LoginCounter, "login")
+			return
+		}
+
 		if err := tx.Commit(ctx); err != nil {
 			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to commit transaction!", http.StatusInternalServerError, err), span, log, h.LoginCounter, "login")
 			return
 		}
 
+		audit.RecordRequest(c, audit.Event{
+			Action:     audit.ActionLoginSucceeded,
+			OrgID:      &orgs[0].Org.ID,
+			ActorID:    &user.ID,
+			ResourceID: &result.SessionId,
+		})
+
 		c.JSON(http.StatusOK, &UserLoginResult{
 			Message: "Login successful",
 			Tokens:  result,
@@ -373,3 +560,64 @@ func (h *LoginHandler) handleMultitenantLogin(c *gin.Context) {
 		FlowID:  &flow.ID,
 	})
 }
+
+// auditLoginFailure records a failed login attempt for the email, with the hash of the email and the reason in the metadata.
+//
+// If orgID is nil, it is determined with auditOrgID.
+func auditLoginFailure(c *gin.Context, orgID *uuid.UUID, email string, userID *uuid.UUID, reason string) {
+	if orgID == nil {
+		orgID = auditOrgID(c)
+	}
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionLoginFailed,
+		OrgID:      orgID,
+		ResourceID: userID,
+		Metadata: map[string]any{
+			"emailHash": audit.EmailHash(email),
+			"reason":    reason,
+		},
+	})
+}
+
+// loginSecurityPolicy returns the security policy of the org, after checking that
+// password logins are allowed from the client's IP address.
+func loginSecurityPolicy(c *gin.Context, org *db.Org) (*orgsettings.SecurityPolicy, *models.ErrorResponse) {
+	settings, err := orgsettings.ForOrg(org)
+	if err != nil {
+		return nil, models.NewErrorResponse(models.GenericErrorMessage, "Failed to parse organization settings!", http.StatusInternalServerError, err)
+	}
+	if !settings.Security.AllowsLoginMethod(orgsettings.LoginMethodPassword) {
+		return nil, models.NewErrorResponse("Password login is disabled for your organization! Please use a different login method.", "Password login method is not allowed by the organization!", http.StatusForbidden, nil)
+	}
+	if !settings.Security.AllowsIP(c.ClientIP()) {
+		return nil, models.NewErrorResponse("Login is not allowed from your network! Please contact your administrator.", "Client IP address is not in the organization's allowlist!", http.StatusForbidden, nil)
+	}
+	return &settings.Security, nil
+}
+
+// storeMFALoginFlow stores a login flow for the org, which must be completed with multi-factor authentication
+// before tokens are issued.
+func storeMFALoginFlow(ctx context.Context, user *db.User, org *db.Org, returnTo *string) (*cache.FlowData, error) {
+	fId, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	flow := &cache.FlowData{
+		ID:          fId.String(),
+		Type:        cache.FlowTypeLogin,
+		UserID:      user.ID.String(),
+		Email:       user.Email,
+		Orgs:        []models.OrgCompat{*models.NewOrgCompat(org)},
+		MFARequired: true,
+		MFAVerified: false,
+		CreatedAt:   time.Now(),
+		ExpiresAt:   time.Now().Add(10 * time.Minute), // Flow expires in 10 minutes
+	}
+	if returnTo != nil {
+		flow.ReturnTo = *returnTo
+	}
+	if err := cache.StoreFlow(ctx, *flow); err != nil {
+		return nil, err
+	}
+	return flow, nil
+}
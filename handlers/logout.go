@@ -5,12 +5,15 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
+	"github.com/nbrglm/nexeres/db"
 	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
 	"github.com/nbrglm/nexeres/internal/metrics"
 	"github.com/nbrglm/nexeres/internal/middlewares"
 	"github.com/nbrglm/nexeres/internal/models"
 	"github.com/nbrglm/nexeres/internal/store"
 	"github.com/nbrglm/nexeres/internal/tokens"
+	"github.com/nbrglm/nexeres/internal/webhooks"
 	"github.com/nbrglm/nexeres/utils"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -75,18 +78,25 @@ func (h *LogoutHandler) HandleLogout(c *gin.Context) {
 	q := store.Querier.WithTx(tx)
 	log.Debug("Transaction begun successfully")
 
+	// The revoked sessions, with the organization to notify.
+	var revoked []db.DeleteSessionByTokenRow
 	if sessionToken != "" {
 		log.Debug("Handling logout request with session token")
 		tokenHash, _ := tokens.HashTokens(&tokens.Tokens{
 			SessionToken: sessionToken,
 		})
-		err = q.DeleteSessionByToken(ctx, tokenHash)
+		revoked, err = q.DeleteSessionByToken(ctx, tokenHash)
 	} else {
 		log.Debug("Handling logout request with refresh token")
 		_, tokenHash := tokens.HashTokens(&tokens.Tokens{
 			RefreshToken: refreshToken,
 		})
-		err = q.DeleteSessionByRefreshToken(ctx, tokenHash)
+		var rows []db.DeleteSessionByRefreshTokenRow
+		rows, err = q.DeleteSessionByRefreshToken(ctx, tokenHash)
+		for _, row := range rows {
+			// The rows have the same fields, so they can be converted directly.
+			revoked = append(revoked, db.DeleteSessionByTokenRow(row))
+		}
 	}
 
 	if err != nil {
@@ -94,11 +104,33 @@ func (h *LogoutHandler) HandleLogout(c *gin.Context) {
 		return
 	}
 
+	for _, session := range revoked {
+		if err := webhooks.Enqueue(ctx, q, session.OrgID, webhooks.EventSessionRevoked, map[string]any{
+			"sessionId": session.ID,
+			"userId":    session.UserID,
+			"reason":    "logout",
+		}); err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to enqueue webhooks!", http.StatusInternalServerError, err), span, log, h.LogoutCounter, "logout")
+			return
+		}
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to commit transaction", http.StatusInternalServerError, err), span, log, h.LogoutCounter, "logout")
 		return
 	}
 
+	method := "session_token"
+	if sessionToken == "" {
+		method = "refresh_token"
+	}
+	recordSessionAudit(c, audit.Event{
+		Action: audit.ActionLogout,
+		Metadata: map[string]any{
+			"method": method,
+		},
+	})
+
 	log.Debug("Session revoked successfully")
 	c.JSON(http.StatusOK, &LogoutResult{
 		Success: true,
@@ -0,0 +1,787 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/avatars"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/middlewares"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/notifications"
+	"github.com/nbrglm/nexeres/internal/notifications/templates"
+	"github.com/nbrglm/nexeres/internal/password"
+	"github.com/nbrglm/nexeres/internal/privacy"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/internal/tokens"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// MeHandler handles the self-service requests of the user of the session.
+type MeHandler struct {
+	GetMeCounter           *prometheus.CounterVec
+	UpdateMeCounter        *prometheus.CounterVec
+	UploadAvatarCounter    *prometheus.CounterVec
+	ChangeEmailCounter     *prometheus.CounterVec
+	ExportCounter          *prometheus.CounterVec
+	ScheduleErasureCounter *prometheus.CounterVec
+	CancelErasureCounter   *prometheus.CounterVec
+}
+
+func NewMeHandler() *MeHandler {
+	return &MeHandler{
+		GetMeCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "users",
+				Name:      "profile_get_requests",
+				Help:      "Total number of user profile get requests",
+			},
+			[]string{"status"},
+		),
+		UpdateMeCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "users",
+				Name:      "profile_update_requests",
+				Help:      "Total number of user profile update requests",
+			},
+			[]string{"status"},
+		),
+		UploadAvatarCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "users",
+				Name:      "avatar_upload_requests",
+				Help:      "Total number of user avatar upload requests",
+			},
+			[]string{"status"},
+		),
+		ChangeEmailCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "users",
+				Name:      "email_change_requests",
+				Help:      "Total number of user email change requests",
+			},
+			[]string{"status"},
+		),
+		ExportCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "users",
+				Name:      "data_export_requests",
+				Help:      "Total number of user data export requests",
+			},
+			[]string{"status"},
+		),
+		ScheduleErasureCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "users",
+				Name:      "erasure_schedule_requests",
+				Help:      "Total number of user erasure requests",
+			},
+			[]string{"status"},
+		),
+		CancelErasureCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "users",
+				Name:      "erasure_cancel_requests",
+				Help:      "Total number of user erasure cancellation requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+func (h *MeHandler) Register(engine *gin.Engine) {
+	metrics.Collectors = append(metrics.Collectors, h.GetMeCounter, h.UpdateMeCounter, h.UploadAvatarCounter, h.ChangeEmailCounter, h.ExportCounter, h.ScheduleErasureCounter, h.CancelErasureCounter)
+
+	requireSession := middlewares.RequireAuth(middlewares.AuthModeSession)
+	engine.GET("/api/me", requireSession, h.HandleGetMe)
+	engine.PATCH("/api/me", requireSession, h.HandleUpdateMe)
+	engine.POST("/api/me/avatar", requireSession, h.HandleUploadAvatar)
+	engine.POST("/api/me/email", requireSession, h.HandleChangeEmail)
+	engine.GET("/api/me/export", requireSession, h.HandleExport)
+	engine.POST("/api/me/erasure", requireSession, h.HandleScheduleErasure)
+	engine.DELETE("/api/me/erasure", requireSession, h.HandleCancelErasure)
+}
+
+// MeResult is the profile of the user.
+type MeResult struct {
+	ID            string  `json:"id"`
+	Email         string  `json:"email"`
+	EmailVerified bool    `json:"emailVerified"`
+	FirstName     *string `json:"firstName"`
+	LastName      *string `json:"lastName"`
+	AvatarURL     *string `json:"avatarUrl"`
+	// Locale of the user's notifications, as a BCP 47 language tag. If nil, the organization's default locale is used.
+	Locale    *string   `json:"locale"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// ErasureScheduledAt is the time at which the user's data is erased, nil if no erasure is scheduled.
+	ErasureScheduledAt *time.Time `json:"erasureScheduledAt,omitempty"`
+}
+
+// UpdateMeData holds the fields of the profile to update; fields which are not set are left unchanged.
+type UpdateMeData struct {
+	FirstName *string `json:"firstName" binding:"omitempty,max=512"`
+	LastName  *string `json:"lastName" binding:"omitempty,max=512"`
+	// Locale of the user's notifications, as a BCP 47 language tag, eg. "pt-BR".
+	Locale *string `json:"locale" binding:"omitempty,bcp47_language_tag"`
+}
+
+type UploadAvatarResult struct {
+	// AvatarURL is the URL of the avatar, in the default size, now the user's avatar URL.
+	AvatarURL string `json:"avatarUrl"`
+	// Sizes are the URLs of the avatar, by size in pixels, eg. "64".
+	Sizes map[string]string `json:"sizes"`
+}
+
+func newMeResult(user db.GetUserByIDRow) MeResult {
+	result := MeResult{
+		ID:            user.ID.String(),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		AvatarURL:     user.AvatarUrl,
+		Locale:        user.Locale,
+		CreatedAt:     user.CreatedAt.Time,
+		UpdatedAt:     user.UpdatedAt.Time,
+	}
+	if user.ErasureScheduledAt.Valid {
+		result.ErasureScheduledAt = &user.ErasureScheduledAt.Time
+	}
+	return result
+}
+
+// recentLoginWindow is the time after a login during which users without a password can change their email address.
+const recentLoginWindow = 5 * time.Minute
+
+type ChangeEmailData struct {
+	NewEmail string `json:"newEmail" binding:"required,email,max=512"`
+	// Password is the current password of the user, required unless the user has none, eg. users who only log in with OAuth.
+	// Users without a password must have logged in within the last 5 minutes instead.
+	Password string `json:"password"`
+}
+
+type ChangeEmailResult struct {
+	// ExpiresAt is the time at which the confirmation link sent to the new address expires.
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type ErasureResult struct {
+	// ErasureScheduledAt is the time at which the user's data is erased, unless the erasure is cancelled before.
+	ErasureScheduledAt time.Time `json:"erasureScheduledAt"`
+}
+
+// sessionUserID returns the ID of the user of the session.
+func sessionUserID(c *gin.Context) (uuid.UUID, *models.ErrorResponse) {
+	// RequireAuth ensures the claims are present
+	claims := c.MustGet(middlewares.CtxSessionTokenClaims).(*tokens.NexeresClaims)
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, models.NewErrorResponse(models.GenericErrorMessage, "Failed to parse the user ID of the session", http.StatusInternalServerError, err)
+	}
+	return userId, nil
+}
+
+// HandleGetMe godoc
+// @Summary Get My Profile
+// @Description Returns the profile of the user of the session.
+// @Tags Users
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Success 200 {object} MeResult "Profile"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 404 {object} models.ErrorResponse "User not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/me [get]
+func (h *MeHandler) HandleGetMe(c *gin.Context) {
+	h.GetMeCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "get_me")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	userId, errResp := sessionUserID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.GetMeCounter, "get_me")
+		return
+	}
+
+	user, err := store.Querier.GetUserByID(ctx, userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("User not found!", "No user found for the session", http.StatusNotFound, nil), span, log, h.GetMeCounter, "get_me")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get user!", http.StatusInternalServerError, err), span, log, h.GetMeCounter, "get_me")
+		return
+	}
+
+	h.GetMeCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, newMeResult(user))
+}
+
+// HandleUpdateMe godoc
+// @Summary Update My Profile
+// @Description Updates the name and locale of the user of the session. Fields which are not set are left unchanged.
+// @Description The session and refresh tokens carry the new name from the next token refresh.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param data body UpdateMeData true "Profile fields to update"
+// @Success 200 {object} MeResult "Updated profile"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid input data"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 404 {object} models.ErrorResponse "User not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/me [patch]
+func (h *MeHandler) HandleUpdateMe(c *gin.Context) {
+	h.UpdateMeCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "update_me")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	userId, errResp := sessionUserID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.UpdateMeCounter, "update_me")
+		return
+	}
+
+	var data UpdateMeData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.UpdateMeCounter, "update_me")
+		return
+	}
+	for _, name := range []*string{data.FirstName, data.LastName} {
+		if name != nil {
+			*name = strings.TrimSpace(*name)
+			if *name == "" {
+				utils.ProcessError(c, models.NewErrorResponse("The first and last name cannot be empty!", "Empty name", http.StatusBadRequest, nil), span, log, h.UpdateMeCounter, "update_me")
+				return
+			}
+		}
+	}
+	if data.Locale != nil {
+		locale, err := templates.NormalizeLocale(*data.Locale)
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse("Invalid locale!", "Failed to parse locale", http.StatusBadRequest, nil), span, log, h.UpdateMeCounter, "update_me")
+			return
+		}
+		data.Locale = &locale
+	}
+
+	user, err := store.Querier.GetUserByID(ctx, userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("User not found!", "No user found for the session", http.StatusNotFound, nil), span, log, h.UpdateMeCounter, "update_me")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get user!", http.StatusInternalServerError, err), span, log, h.UpdateMeCounter, "update_me")
+		return
+	}
+
+	updated, err := store.Querier.UpdateUserByID(ctx, db.UpdateUserByIDParams{
+		FirstName: data.FirstName,
+		LastName:  data.LastName,
+		Locale:    data.Locale,
+		ID:        userId,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("User not found!", "No user found for the session", http.StatusNotFound, nil), span, log, h.UpdateMeCounter, "update_me")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to update user!", http.StatusInternalServerError, err), span, log, h.UpdateMeCounter, "update_me")
+		return
+	}
+	user.FirstName, user.LastName, user.Locale, user.UpdatedAt = updated.FirstName, updated.LastName, updated.Locale, updated.UpdatedAt
+
+	fields := []string{}
+	if data.FirstName != nil {
+		fields = append(fields, "firstName")
+	}
+	if data.LastName != nil {
+		fields = append(fields, "lastName")
+	}
+	if data.Locale != nil {
+		fields = append(fields, "locale")
+	}
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionUserProfileUpdated,
+		ResourceID: &userId,
+		Metadata: map[string]any{
+			"fields": fields,
+		},
+	})
+
+	h.UpdateMeCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, newMeResult(user))
+}
+
+// HandleUploadAvatar godoc
+// @Summary Upload My Avatar
+// @Description Replaces the avatar of the user of the session with the uploaded JPEG, PNG or GIF image, of at most 5 MiB.
+// @Description The image is stripped of its metadata, cropped to a square and resized to 64, 128, 256 and 512 pixels, which are stored publicly.
+// @Description The 256 pixels avatar becomes the user's avatar URL, which the tokens carry from the next token refresh.
+// @Tags Users
+// @Accept multipart/form-data
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param avatar formData file true "Avatar image"
+// @Success 200 {object} UploadAvatarResult "Avatar uploaded"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Missing or invalid image"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 404 {object} models.ErrorResponse "User not found"
+// @Failure 413 {object} models.ErrorResponse "Image too large"
+// @Failure 415 {object} models.ErrorResponse "Unsupported image type"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/me/avatar [post]
+func (h *MeHandler) HandleUploadAvatar(c *gin.Context) {
+	h.UploadAvatarCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "upload_avatar")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	userId, errResp := sessionUserID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.UploadAvatarCounter, "upload_avatar")
+		return
+	}
+
+	// Leave room for the multipart headers, the size of the image itself is checked below.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, avatars.MaxUploadSize+64<<10)
+	fileHeader, err := c.FormFile("avatar")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			utils.ProcessError(c, models.NewErrorResponse("The image is too large, the maximum size is 5 MiB!", "Request body too large", http.StatusRequestEntityTooLarge, nil), span, log, h.UploadAvatarCounter, "upload_avatar")
+			return
+		}
+		utils.ProcessError(c, models.NewErrorResponse("The avatar image is required!", "Missing 'avatar' form file", http.StatusBadRequest, nil), span, log, h.UploadAvatarCounter, "upload_avatar")
+		return
+	}
+	if fileHeader.Size > avatars.MaxUploadSize {
+		utils.ProcessError(c, models.NewErrorResponse("The image is too large, the maximum size is 5 MiB!", "Avatar file too large", http.StatusRequestEntityTooLarge, nil), span, log, h.UploadAvatarCounter, "upload_avatar")
+		return
+	}
+	if !avatars.IsSupportedType(fileHeader.Header.Get("Content-Type")) {
+		utils.ProcessError(c, models.NewErrorResponse("Unsupported image type, use a JPEG, PNG or GIF image!", "Unsupported declared content type", http.StatusUnsupportedMediaType, nil), span, log, h.UploadAvatarCounter, "upload_avatar")
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to open the uploaded avatar!", http.StatusInternalServerError, err), span, log, h.UploadAvatarCounter, "upload_avatar")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, avatars.MaxUploadSize+1))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to read the uploaded avatar!", http.StatusInternalServerError, err), span, log, h.UploadAvatarCounter, "upload_avatar")
+		return
+	}
+
+	avatar, err := avatars.Process(data)
+	if err != nil {
+		switch {
+		case errors.Is(err, avatars.ErrTooLarge):
+			utils.ProcessError(c, models.NewErrorResponse("The image is too large, the maximum size is 5 MiB and 4096x4096 pixels!", "Avatar image too large", http.StatusRequestEntityTooLarge, nil), span, log, h.UploadAvatarCounter, "upload_avatar")
+		case errors.Is(err, avatars.ErrUnsupportedType):
+			utils.ProcessError(c, models.NewErrorResponse("Unsupported image type, use a JPEG, PNG or GIF image!", "Unsupported detected content type", http.StatusUnsupportedMediaType, nil), span, log, h.UploadAvatarCounter, "upload_avatar")
+		case errors.Is(err, avatars.ErrInvalidImage):
+			utils.ProcessError(c, models.NewErrorResponse("The image could not be read!", "Failed to decode the avatar image", http.StatusBadRequest, nil), span, log, h.UploadAvatarCounter, "upload_avatar")
+		default:
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to process the avatar!", http.StatusInternalServerError, err), span, log, h.UploadAvatarCounter, "upload_avatar")
+		}
+		return
+	}
+
+	user, err := store.Querier.GetUserByID(ctx, userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("User not found!", "No user found for the session", http.StatusNotFound, nil), span, log, h.UploadAvatarCounter, "upload_avatar")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get user!", http.StatusInternalServerError, err), span, log, h.UploadAvatarCounter, "upload_avatar")
+		return
+	}
+
+	urls, err := avatars.Upload(ctx, userId, avatar)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to upload the avatar!", http.StatusInternalServerError, err), span, log, h.UploadAvatarCounter, "upload_avatar")
+		return
+	}
+	avatarUrl := urls[avatars.DefaultSize]
+
+	if _, err := store.Querier.UpdateUserByID(ctx, db.UpdateUserByIDParams{
+		AvatarUrl: &avatarUrl,
+		ID:        userId,
+	}); err != nil {
+		if delErr := avatars.Delete(ctx, userId, &avatarUrl); delErr != nil {
+			log.Warn("Failed to delete the unused avatar", zap.Error(delErr))
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.ProcessError(c, models.NewErrorResponse("User not found!", "No user found for the session", http.StatusNotFound, nil), span, log, h.UploadAvatarCounter, "upload_avatar")
+			return
+		}
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to update user!", http.StatusInternalServerError, err), span, log, h.UploadAvatarCounter, "upload_avatar")
+		return
+	}
+
+	// The previous avatar is no longer referenced, a failure to delete it does not fail the request.
+	if err := avatars.Delete(ctx, userId, user.AvatarUrl); err != nil {
+		log.Warn("Failed to delete the previous avatar", zap.Error(err))
+	}
+
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionUserAvatarUpdated,
+		ResourceID: &userId,
+	})
+
+	result := UploadAvatarResult{AvatarURL: avatarUrl, Sizes: map[string]string{}}
+	for size, url := range urls {
+		result.Sizes[strconv.Itoa(size)] = url
+	}
+	h.UploadAvatarCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, result)
+}
+
+// HandleChangeEmail godoc
+// @Summary Change My Email
+// @Description Requests a change of the email address of the user of the session.
+// @Description A confirmation link is sent to the new address, and a notice to the current one. The address is only changed once the link is
+// @Description confirmed with /api/auth/verify-email/verify, which also revokes every session of the user. A new request replaces the pending one.
+// @Description Users without a password, eg. who only log in with OAuth, must have logged in within the last 5 minutes.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param data body ChangeEmailData true "New email address and current password"
+// @Success 202 {object} ChangeEmailResult "Confirmation link sent"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid input data, or the new email is the current one"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized - Invalid session or password, or the login is not recent"
+// @Failure 404 {object} models.ErrorResponse "User not found"
+// @Failure 409 {object} models.ErrorResponse "Conflict - The new email is already in use"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/me/email [post]
+func (h *MeHandler) HandleChangeEmail(c *gin.Context) {
+	h.ChangeEmailCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "change_email")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	userId, errResp := sessionUserID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+
+	var data ChangeEmailData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+	data.NewEmail = strings.TrimSpace(data.NewEmail)
+
+	tx, err := store.PgPool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to begin transaction!", http.StatusInternalServerError, err), span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	q := store.Querier.WithTx(tx)
+
+	user, err := q.GetUserByID(ctx, userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("User not found!", "No user found for the session", http.StatusNotFound, nil), span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get user!", http.StatusInternalServerError, err), span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+	if strings.EqualFold(data.NewEmail, user.Email) {
+		utils.ProcessError(c, models.NewErrorResponse("The new email is your current email!", "New email equals the current email", http.StatusBadRequest, nil), span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+
+	// A stolen session must not be enough to take over the account, so the password is checked again.
+	loginInfo, err := q.GetLoginInfoForUser(ctx, user.Email)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to retrieve user information!", http.StatusInternalServerError, err), span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+	if loginInfo.PasswordHash != nil && !password.VerifyPasswordMatch(*loginInfo.PasswordHash, data.Password) {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid password! Please try again.", "Password mismatch!", http.StatusUnauthorized, nil), span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+	// Users without a password must have logged in recently instead. The session is created by the login, and kept by refreshes.
+	if loginInfo.PasswordHash == nil {
+		// RequireAuth ensures the claims are present
+		claims := c.MustGet(middlewares.CtxSessionTokenClaims).(*tokens.NexeresClaims)
+		sessionId, err := uuid.Parse(claims.ID)
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to parse the session ID!", http.StatusInternalServerError, err), span, log, h.ChangeEmailCounter, "change_email")
+			return
+		}
+		session, err := q.GetSessionByID(ctx, sessionId)
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.ProcessError(c, models.NewErrorResponse("Invalid session! Please login again.", "No session found for the session token", http.StatusUnauthorized, nil), span, log, h.ChangeEmailCounter, "change_email")
+			return
+		}
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get the session!", http.StatusInternalServerError, err), span, log, h.ChangeEmailCounter, "change_email")
+			return
+		}
+		if time.Since(session.CreatedAt.Time) > recentLoginWindow {
+			utils.ProcessError(c, models.NewErrorResponse("Please login again to change your email!", "The session of a user without a password was not created recently", http.StatusUnauthorized, nil), span, log, h.ChangeEmailCounter, "change_email")
+			return
+		}
+	}
+
+	// The uniqueness is checked again when the change is confirmed, since the address may be taken in between.
+	_, err = q.GetUserByEmail(ctx, data.NewEmail)
+	if err == nil {
+		utils.ProcessError(c, models.NewErrorResponse("The email address is already in use by another account!", "The new email address is already taken.", http.StatusConflict, nil), span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to check the new email!", http.StatusInternalServerError, err), span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+
+	// Only the latest request can be confirmed.
+	if err := q.DeleteUserVerificationTokens(ctx, db.DeleteUserVerificationTokensParams{
+		UserID: userId,
+		Type:   string(tokens.EmailChangeToken),
+	}); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to delete the pending email change!", http.StatusInternalServerError, err), span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+
+	token, hash, err := tokens.GenerateEmailVerificationToken()
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to generate verification token!", http.StatusInternalServerError, err), span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+	tokenId, err := uuid.NewV7()
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to generate token ID!", http.StatusInternalServerError, err), span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+	newToken, err := q.NewVerificationToken(ctx, db.NewVerificationTokenParams{
+		ID:        tokenId,
+		UserID:    userId,
+		Type:      string(tokens.EmailChangeToken),
+		TokenHash: hash,
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  time.Now().Add(24 * time.Hour),
+			Valid: true,
+		},
+		NewEmail: &data.NewEmail,
+	})
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to insert verification token into the database!", http.StatusInternalServerError, err), span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+
+	// The emails are queued in the transaction, so that they are only sent if the token is committed.
+	branding := brandingForUser(ctx, c, q, user.Email)
+	if err := notifications.QueueEmailChangeEmails(ctx, q, notifications.QueueEmailChangeEmailsParams{
+		User: struct {
+			Email     string
+			FirstName *string
+			LastName  *string
+			Locale    *string
+		}{
+			Email:     user.Email,
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+			Locale:    user.Locale,
+		},
+		NewEmail:          data.NewEmail,
+		VerificationToken: token,
+		ExpiresAt:         newToken.ExpiresAt.Time,
+		IdempotencyKey:    tokenId.String(),
+		Branding:          &branding,
+	}); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to queue the email change emails!", http.StatusInternalServerError, err), span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to commit transaction!", http.StatusInternalServerError, err), span, log, h.ChangeEmailCounter, "change_email")
+		return
+	}
+
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionEmailChangeRequested,
+		ResourceID: &userId,
+	})
+
+	h.ChangeEmailCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusAccepted, ChangeEmailResult{ExpiresAt: newToken.ExpiresAt.Time})
+}
+
+// HandleExport godoc
+// @Summary Export My Data
+// @Description Exports the data of the user: their profile, organizations, sessions, identities, MFA factors (without secrets) and audit events.
+// @Description The archive is stored for a limited time, and downloaded through the returned pre-signed URL until it expires.
+// @Tags Users
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param format query string false "Format of the archive, 'zip' (a JSON file per section) or 'json' (a single document)" Enums(zip, json) default(zip)
+// @Success 200 {object} privacy.Export "Export"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Unknown format"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 404 {object} models.ErrorResponse "User not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/me/export [get]
+func (h *MeHandler) HandleExport(c *gin.Context) {
+	h.ExportCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "export_user_data")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	userId, errResp := sessionUserID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.ExportCounter, "export_user_data")
+		return
+	}
+
+	format := c.DefaultQuery("format", privacy.FormatZIP)
+	if format != privacy.FormatZIP && format != privacy.FormatJSON {
+		utils.ProcessError(c, models.NewErrorResponse("Unknown format, use 'zip' or 'json'!", "Unknown export format", http.StatusBadRequest, nil), span, log, h.ExportCounter, "export_user_data")
+		return
+	}
+
+	export, err := privacy.CreateExport(ctx, userId, format)
+	if errors.Is(err, privacy.ErrUserNotFound) {
+		utils.ProcessError(c, models.NewErrorResponse("User not found!", "No user found for the session", http.StatusNotFound, nil), span, log, h.ExportCounter, "export_user_data")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to export the user data!", http.StatusInternalServerError, err), span, log, h.ExportCounter, "export_user_data")
+		return
+	}
+
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionUserDataExported,
+		ResourceID: &userId,
+		Metadata: map[string]any{
+			"exportId": export.ID,
+			"format":   export.Format,
+		},
+	})
+
+	h.ExportCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, export)
+}
+
+// HandleScheduleErasure godoc
+// @Summary Request Erasure of My Data
+// @Description Schedules the erasure of the user after a grace period, during which it can be cancelled.
+// @Description The erasure deletes the user from every organization, with their sessions, identities, MFA factors and objects.
+// @Description If an erasure is already scheduled, its time is returned unchanged.
+// @Tags Users
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Success 202 {object} ErasureResult "Erasure scheduled"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 404 {object} models.ErrorResponse "User not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/me/erasure [post]
+func (h *MeHandler) HandleScheduleErasure(c *gin.Context) {
+	h.ScheduleErasureCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "schedule_user_erasure")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	userId, errResp := sessionUserID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.ScheduleErasureCounter, "schedule_user_erasure")
+		return
+	}
+
+	scheduledAt, err := privacy.ScheduleErasure(ctx, userId)
+	if errors.Is(err, privacy.ErrUserNotFound) {
+		utils.ProcessError(c, models.NewErrorResponse("User not found!", "No user found for the session", http.StatusNotFound, nil), span, log, h.ScheduleErasureCounter, "schedule_user_erasure")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to schedule the erasure!", http.StatusInternalServerError, err), span, log, h.ScheduleErasureCounter, "schedule_user_erasure")
+		return
+	}
+
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionUserErasureScheduled,
+		ResourceID: &userId,
+		Metadata: map[string]any{
+			"erasureScheduledAt": scheduledAt,
+		},
+	})
+
+	h.ScheduleErasureCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusAccepted, ErasureResult{ErasureScheduledAt: scheduledAt})
+}
+
+// HandleCancelErasure godoc
+// @Summary Cancel Erasure of My Data
+// @Description Cancels the scheduled erasure of the user.
+// @Tags Users
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Success 204 "Erasure cancelled"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 404 {object} models.ErrorResponse "No erasure scheduled"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/me/erasure [delete]
+func (h *MeHandler) HandleCancelErasure(c *gin.Context) {
+	h.CancelErasureCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "cancel_user_erasure")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	userId, errResp := sessionUserID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.CancelErasureCounter, "cancel_user_erasure")
+		return
+	}
+
+	cancelled, err := privacy.CancelErasure(ctx, userId)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to cancel the erasure!", http.StatusInternalServerError, err), span, log, h.CancelErasureCounter, "cancel_user_erasure")
+		return
+	}
+	if !cancelled {
+		utils.ProcessError(c, models.NewErrorResponse("No erasure is scheduled!", "No erasure scheduled for the user", http.StatusNotFound, nil), span, log, h.CancelErasureCounter, "cancel_user_erasure")
+		return
+	}
+
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionUserErasureCancelled,
+		ResourceID: &userId,
+	})
+
+	h.CancelErasureCounter.WithLabelValues("success").Inc()
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ObjectsHandler serves the objects of the filesystem object store: the public objects to anyone,
+// and the private objects with the signed URLs of store.FilesystemStore.GetObjectURLWithExpiry.
+//
+// It must only be registered with the filesystem backend, and before the API key middleware, so that the URLs can be opened in a browser.
+type ObjectsHandler struct {
+	GetObjectCounter *prometheus.CounterVec
+}
+
+func NewObjectsHandler() *ObjectsHandler {
+	return &ObjectsHandler{
+		GetObjectCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "objects",
+				Name:      "get_requests",
+				Help:      "Total number of object requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+func (h *ObjectsHandler) Register(engine *gin.Engine) {
+	metrics.Collectors = append(metrics.Collectors, h.GetObjectCounter)
+	engine.GET("/objects/*key", h.HandleGetObject)
+	engine.HEAD("/objects/*key", h.HandleGetObject)
+}
+
+// HandleGetObject godoc
+// @Summary Get Object
+// @Description Serves an object of the filesystem object store. Public objects are served to anyone,
+// @Description private objects only with the expires and signature of a signed URL, eg. the link of a data export.
+// @Tags Objects
+// @Produce octet-stream
+// @Param key path string true "Object key, prefixed with 'public/' or 'private/'"
+// @Param expires query int false "Expiry of the signed URL, as a Unix time, required for private objects"
+// @Param signature query string false "Signature of the signed URL, required for private objects"
+// @Success 200 {file} file "Object"
+// @Failure 403 {object} models.ErrorResponse "Invalid or expired signature"
+// @Failure 404 {object} models.ErrorResponse "Object not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /objects/{key} [get]
+func (h *ObjectsHandler) HandleGetObject(c *gin.Context) {
+	h.GetObjectCounter.WithLabelValues("received").Inc()
+
+	_, log, span := internal.WithContext(c.Request.Context(), "get_object")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	objects, ok := store.Objects.(*store.FilesystemStore)
+	if !ok {
+		utils.ProcessError(c, models.NewErrorResponse("Object not found!", "The object store is not the filesystem store", http.StatusNotFound, nil), span, log, h.GetObjectCounter, "get_object")
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	private := strings.HasPrefix(key, "private/")
+	if private {
+		err := objects.VerifySignature(key, c.Query("expires"), c.Query("signature"))
+		if errors.Is(err, store.ErrURLExpired) {
+			utils.ProcessError(c, models.NewErrorResponse("The link has expired!", "Signed object URL expired", http.StatusForbidden, nil), span, log, h.GetObjectCounter, "get_object")
+			return
+		}
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse("Invalid link!", "Invalid object URL signature", http.StatusForbidden, nil), span, log, h.GetObjectCounter, "get_object")
+			return
+		}
+	}
+
+	file, info, err := objects.OpenObject(key)
+	if errors.Is(err, store.ErrObjectNotFound) || errors.Is(err, store.ErrInvalidObjectKey) {
+		utils.ProcessError(c, models.NewErrorResponse("Object not found!", "No object found for the key", http.StatusNotFound, nil), span, log, h.GetObjectCounter, "get_object")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to open the object!", http.StatusInternalServerError, err), span, log, h.GetObjectCounter, "get_object")
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Type", info.ContentType)
+	if info.CacheControl != "" {
+		c.Header("Cache-Control", info.CacheControl)
+	}
+	// The objects are served from the origin of Nexeres, so they must never run scripts, eg. an uploaded HTML or SVG file.
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "default-src 'none'; sandbox")
+	if private {
+		// Private objects, eg. data exports, are downloaded.
+		c.Header("Content-Disposition", "attachment; filename=\""+path.Base(key)+"\"")
+	}
+
+	h.GetObjectCounter.WithLabelValues("success").Inc()
+	http.ServeContent(c.Writer, c.Request, path.Base(key), info.ModTime, file)
+}
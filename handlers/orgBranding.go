@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/middlewares"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/notifications"
+	"github.com/nbrglm/nexeres/internal/notifications/templates"
+	"github.com/nbrglm/nexeres/internal/orgsettings"
+	"github.com/nbrglm/nexeres/internal/permissions"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/opts"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type OrgBrandingHandler struct {
+	GetBrandingCounter    *prometheus.CounterVec
+	UpdateBrandingCounter *prometheus.CounterVec
+	PutTemplateCounter    *prometheus.CounterVec
+	DeleteTemplateCounter *prometheus.CounterVec
+}
+
+func NewOrgBrandingHandler() *OrgBrandingHandler {
+	return &OrgBrandingHandler{
+		GetBrandingCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "branding_get_requests",
+				Help:      "Total number of organization branding get requests",
+			},
+			[]string{"status"},
+		),
+		UpdateBrandingCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "branding_update_requests",
+				Help:      "Total number of organization branding update requests",
+			},
+			[]string{"status"},
+		),
+		PutTemplateCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "email_template_put_requests",
+				Help:      "Total number of organization email template upload requests",
+			},
+			[]string{"status"},
+		),
+		DeleteTemplateCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "email_template_delete_requests",
+				Help:      "Total number of organization email template delete requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+func (h *OrgBrandingHandler) Register(engine *gin.Engine) {
+	metrics.Collectors = append(metrics.Collectors, h.GetBrandingCounter, h.UpdateBrandingCounter, h.PutTemplateCounter, h.DeleteTemplateCounter)
+
+	requireSession := middlewares.RequireAuth(middlewares.AuthModeSession)
+	engine.GET("/api/orgs/:orgId/settings/branding", requireSession, middlewares.RequirePermission(permissions.SettingsRead), h.HandleGetBranding)
+	engine.PUT("/api/orgs/:orgId/settings/branding", requireSession, middlewares.RequirePermission(permissions.SettingsUpdate), h.HandleUpdateBranding)
+	engine.PUT("/api/orgs/:orgId/settings/branding/templates/:templateName", requireSession, middlewares.RequirePermission(permissions.SettingsUpdate), h.HandlePutEmailTemplate)
+	engine.DELETE("/api/orgs/:orgId/settings/branding/templates/:templateName", requireSession, middlewares.RequirePermission(permissions.SettingsUpdate), h.HandleDeleteEmailTemplate)
+}
+
+type OrgBrandingResult struct {
+	Branding orgsettings.Branding `json:"branding"`
+	// Templates is the list of email templates that can be overridden.
+	Templates []string `json:"templates"`
+}
+
+type PutEmailTemplateData struct {
+	Subject   string `json:"subject" binding:"required"`
+	HTML      string `json:"html" binding:"required"`
+	PlainText string `json:"plainText" binding:"required"`
+}
+
+func overridableTemplateNames() []string {
+	names := []string{}
+	for name := range templates.OverridableTemplates() {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// brandingForUser returns the branding for emails sent to the user.
+//
+// The organization is the tenant of the request, the default organization in single-tenant mode,
+// or the user's organization if they belong to exactly one. Otherwise, the default branding is used.
+// Failures are logged and the default branding is used, so that emails are still sent.
+func brandingForUser(ctx context.Context, c *gin.Context, q *db.Queries, email string) notifications.Branding {
+	org := middlewares.GetTenant(c)
+	if org == nil {
+		if !config.Multitenancy {
+			defaultOrg, err := q.GetOrgByID(ctx, uuid.MustParse(opts.DefaultOrgId))
+			if err != nil {
+				logging.Logger.Warn("Failed to get the default organization for branding", zap.Error(err))
+				return notifications.DefaultBranding()
+			}
+			org = &defaultOrg
+		} else {
+			orgs, err := q.GetUserOrgsByEmail(ctx, &email)
+			if err != nil {
+				logging.Logger.Warn("Failed to get the user's organizations for branding", zap.Error(err))
+				return notifications.DefaultBranding()
+			}
+			if len(orgs) != 1 {
+				return notifications.DefaultBranding()
+			}
+			org = &orgs[0].Org
+		}
+	}
+
+	branding, err := notifications.BrandingForOrg(org)
+	if err != nil {
+		logging.Logger.Warn("Failed to get organization branding", zap.String("orgId", org.ID.String()), zap.Error(err))
+		return notifications.DefaultBranding()
+	}
+	return branding
+}
+
+// HandleGetBranding godoc
+// @Summary Get Organization Branding
+// @Description Returns the branding of an organization, used in emails and hosted pages.
+// @Tags Orgs
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Success 200 {object} OrgBrandingResult "Branding"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'settings:read'"
+// @Failure 404 {object} models.ErrorResponse "Organization not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/settings/branding [get]
+func (h *OrgBrandingHandler) HandleGetBranding(c *gin.Context) {
+	h.GetBrandingCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "get_org_branding")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.GetBrandingCounter, "get_org_branding")
+		return
+	}
+
+	org, err := store.Querier.GetOrgByID(ctx, orgId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("Organization not found!", "No organization found for the given ID", http.StatusNotFound, nil), span, log, h.GetBrandingCounter, "get_org_branding")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get organization!", http.StatusInternalServerError, err), span, log, h.GetBrandingCounter, "get_org_branding")
+		return
+	}
+
+	settings, err := orgsettings.ForOrg(&org)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to parse organization settings!", http.StatusInternalServerError, err), span, log, h.GetBrandingCounter, "get_org_branding")
+		return
+	}
+
+	h.GetBrandingCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, OrgBrandingResult{
+		Branding:  settings.Branding,
+		Templates: overridableTemplateNames(),
+	})
+}
+
+// HandleUpdateBranding godoc
+// @Summary Update Organization Branding
+// @Description Replaces the branding of an organization. The logo is the organization's avatar.
+// @Description The list of custom templates cannot be changed here, use the template endpoints instead.
+// @Tags Orgs
+// @Accept json
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Param data body orgsettings.Branding true "Branding"
+// @Success 200 {object} OrgBrandingResult "Updated branding"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid branding"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'settings:update'"
+// @Failure 404 {object} models.ErrorResponse "Organization not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/settings/branding [put]
+func (h *OrgBrandingHandler) HandleUpdateBranding(c *gin.Context) {
+	h.UpdateBrandingCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "update_org_branding")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.UpdateBrandingCounter, "update_org_branding")
+		return
+	}
+
+	var branding orgsettings.Branding
+	if err := c.ShouldBindJSON(&branding); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.UpdateBrandingCounter, "update_org_branding")
+		return
+	}
+	if err := branding.Validate(); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid branding! Please check your input and try again.", err.Error(), http.StatusBadRequest, nil), span, log, h.UpdateBrandingCounter, "update_org_branding")
+		return
+	}
+
+	result, errResp := updateOrgBranding(ctx, orgId, func(current *orgsettings.Branding) error {
+		// The custom templates are managed by the template endpoints.
+		branding.CustomTemplates = current.CustomTemplates
+		*current = branding
+		return nil
+	})
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.UpdateBrandingCounter, "update_org_branding")
+		return
+	}
+
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionBrandingUpdated,
+		OrgID:      &orgId,
+		ResourceID: &orgId,
+		Metadata: map[string]any{
+			"branding": branding,
+		},
+	})
+
+	h.UpdateBrandingCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, OrgBrandingResult{
+		Branding:  *result,
+		Templates: overridableTemplateNames(),
+	})
+}
+
+// HandlePutEmailTemplate godoc
+// @Summary Put Organization Email Template
+// @Description Overrides an email template for an organization. The template is stored in the object store.
+// @Description The templates use Go's html/template syntax, with the same data as the built-in templates.
+// @Tags Orgs
+// @Accept json
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Param templateName path string true "Template name, eg. VerifyEmail"
+// @Param data body PutEmailTemplateData true "Template contents"
+// @Success 200 {object} OrgBrandingResult "Updated branding"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Unknown template or invalid template syntax"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'settings:update'"
+// @Failure 404 {object} models.ErrorResponse "Organization not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/settings/branding/templates/{templateName} [put]
+func (h *OrgBrandingHandler) HandlePutEmailTemplate(c *gin.Context) {
+	h.PutTemplateCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "put_org_email_template")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.PutTemplateCounter, "put_org_email_template")
+		return
+	}
+
+	templateName := c.Param("templateName")
+	if _, ok := templates.OverridableTemplates()[templateName]; !ok {
+		utils.ProcessError(c, models.NewErrorResponse("Unknown email template! Allowed templates: "+strings.Join(overridableTemplateNames(), ", "), "Template cannot be overridden", http.StatusBadRequest, nil), span, log, h.PutTemplateCounter, "put_org_email_template")
+		return
+	}
+
+	var data PutEmailTemplateData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.PutTemplateCounter, "put_org_email_template")
+		return
+	}
+
+	// Parse and render the template with sample data, so that broken templates are rejected now instead of when sending.
+	tmpl, err := templates.ParseEmailTemplate(templateName, data.Subject, data.HTML, data.PlainText)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid template: "+err.Error(), "Failed to parse template", http.StatusBadRequest, nil), span, log, h.PutTemplateCounter, "put_org_email_template")
+		return
+	}
+	sample := notifications.DefaultBranding()
+	if _, err := templates.RenderEmailTemplate(templates.TemplateData{
+		AppName:      sample.AppName,
+		UserName:     "User",
+		UserEmail:    "user@example.com",
+		ActionURL:    config.Public.GetBaseURL(),
+		ExpiresAt:    time.Now(),
+		SupportURL:   sample.SupportURL,
+		CompanyName:  sample.CompanyName,
+		PrimaryColor: sample.PrimaryColor,
+	}, *tmpl); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid template: "+err.Error(), "Failed to render template", http.StatusBadRequest, nil), span, log, h.PutTemplateCounter, "put_org_email_template")
+		return
+	}
+
+	parts := map[string]struct{ content, contentType string }{
+		notifications.TemplatePartSubject:   {data.Subject, "text/plain; charset=utf-8"},
+		notifications.TemplatePartHTML:      {data.HTML, "text/html; charset=utf-8"},
+		notifications.TemplatePartPlainText: {data.PlainText, "text/plain; charset=utf-8"},
+	}
+	for part, p := range parts {
+		if _, err := store.Objects.UploadObject(ctx, notifications.OrgTemplateKey(orgId.String(), templateName, part), strings.NewReader(p.content), p.contentType, "no-cache"); err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to upload template!", http.StatusInternalServerError, err), span, log, h.PutTemplateCounter, "put_org_email_template")
+			return
+		}
+	}
+
+	result, errResp := updateOrgBranding(ctx, orgId, func(current *orgsettings.Branding) error {
+		if !slices.Contains(current.CustomTemplates, templateName) {
+			current.CustomTemplates = append(current.CustomTemplates, templateName)
+		}
+		return nil
+	})
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.PutTemplateCounter, "put_org_email_template")
+		return
+	}
+	notifications.InvalidateOrgTemplate(orgId.String(), templateName)
+
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionEmailTemplateUpdated,
+		OrgID:      &orgId,
+		ResourceID: &orgId,
+		Metadata: map[string]any{
+			"template": templateName,
+		},
+	})
+
+	h.PutTemplateCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, OrgBrandingResult{
+		Branding:  *result,
+		Templates: overridableTemplateNames(),
+	})
+}
+
+// HandleDeleteEmailTemplate godoc
+// @Summary Delete Organization Email Template
+// @Description Removes an organization's email template override, the built-in template is used again.
+// @Tags Orgs
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Param templateName path string true "Template name, eg. VerifyEmail"
+// @Success 200 {object} OrgBrandingResult "Updated branding"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Unknown template"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'settings:update'"
+// @Failure 404 {object} models.ErrorResponse "Organization not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/settings/branding/templates/{templateName} [delete]
+func (h *OrgBrandingHandler) HandleDeleteEmailTemplate(c *gin.Context) {
+	h.DeleteTemplateCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "delete_org_email_template")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.DeleteTemplateCounter, "delete_org_email_template")
+		return
+	}
+
+	templateName := c.Param("templateName")
+	if _, ok := templates.OverridableTemplates()[templateName]; !ok {
+		utils.ProcessError(c, models.NewErrorResponse("Unknown email template! Allowed templates: "+strings.Join(overridableTemplateNames(), ", "), "Template cannot be overridden", http.StatusBadRequest, nil), span, log, h.DeleteTemplateCounter, "delete_org_email_template")
+		return
+	}
+
+	// Remove the template from the settings first, so that it is not used even if deleting the objects fails.
+	result, errResp := updateOrgBranding(ctx, orgId, func(current *orgsettings.Branding) error {
+		current.CustomTemplates = slices.DeleteFunc(current.CustomTemplates, func(name string) bool {
+			return name == templateName
+		})
+		return nil
+	})
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.DeleteTemplateCounter, "delete_org_email_template")
+		return
+	}
+	notifications.InvalidateOrgTemplate(orgId.String(), templateName)
+
+	for _, part := range []string{notifications.TemplatePartSubject, notifications.TemplatePartHTML, notifications.TemplatePartPlainText} {
+		if err := store.Objects.DeleteObject(ctx, "private/"+notifications.OrgTemplateKey(orgId.String(), templateName, part)); err != nil {
+			log.Warn("Failed to delete email template object", zap.String("part", part), zap.Error(err))
+		}
+	}
+
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionEmailTemplateDeleted,
+		OrgID:      &orgId,
+		ResourceID: &orgId,
+		Metadata: map[string]any{
+			"template": templateName,
+		},
+	})
+
+	h.DeleteTemplateCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, OrgBrandingResult{
+		Branding:  *result,
+		Templates: overridableTemplateNames(),
+	})
+}
+
+// updateOrgBranding updates the branding section of the organization's settings in a transaction,
+// preserving the other sections, and returns the updated branding.
+// The row of the organization is locked, so that concurrent updates of the settings do not drop each other.
+func updateOrgBranding(ctx context.Context, orgId uuid.UUID, update func(current *orgsettings.Branding) error) (*orgsettings.Branding, *models.ErrorResponse) {
+	tx, err := store.PgPool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, models.NewErrorResponse(models.GenericErrorMessage, "Failed to begin transaction!", http.StatusInternalServerError, err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := store.Querier.WithTx(tx)
+
+	org, err := q.GetOrgByIDForUpdate(ctx, orgId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, models.NewErrorResponse("Organization not found!", "No organization found for the given ID", http.StatusNotFound, nil)
+	}
+	if err != nil {
+		return nil, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get organization!", http.StatusInternalServerError, err)
+	}
+
+	settings, err := orgsettings.ForOrg(&org)
+	if err != nil {
+		return nil, models.NewErrorResponse(models.GenericErrorMessage, "Failed to parse organization settings!", http.StatusInternalServerError, err)
+	}
+	if err := update(&settings.Branding); err != nil {
+		return nil, models.NewErrorResponse(err.Error(), "Failed to update branding", http.StatusBadRequest, nil)
+	}
+
+	raw, err := orgsettings.Merge(org.Settings, orgsettings.SectionBranding, settings.Branding)
+	if err != nil {
+		return nil, models.NewErrorResponse(models.GenericErrorMessage, "Failed to merge organization settings!", http.StatusInternalServerError, err)
+	}
+
+	if _, err := q.UpdateOrg(ctx, db.UpdateOrgParams{
+		Settings: raw,
+		ID:       orgId,
+	}); err != nil {
+		return nil, models.NewErrorResponse(models.GenericErrorMessage, "Failed to update organization settings!", http.StatusInternalServerError, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, models.NewErrorResponse(models.GenericErrorMessage, "Failed to commit transaction!", http.StatusInternalServerError, err)
+	}
+	middlewares.InvalidateTenant(ctx, org.Slug)
+	return &settings.Branding, nil
+}
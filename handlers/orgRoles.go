@@ -0,0 +1,762 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/middlewares"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/permissions"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/internal/tokens"
+	"github.com/nbrglm/nexeres/internal/webhooks"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type OrgRolesHandler struct {
+	ListRolesCounter       *prometheus.CounterVec
+	CreateRoleCounter      *prometheus.CounterVec
+	UpdateRoleCounter      *prometheus.CounterVec
+	DeleteRoleCounter      *prometheus.CounterVec
+	AssignRoleCounter      *prometheus.CounterVec
+	CheckPermissionCounter *prometheus.CounterVec
+}
+
+func NewOrgRolesHandler() *OrgRolesHandler {
+	return &OrgRolesHandler{
+		ListRolesCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "role_list_requests",
+				Help:      "Total number of organization role list requests",
+			},
+			[]string{"status"},
+		),
+		CreateRoleCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "role_create_requests",
+				Help:      "Total number of organization role create requests",
+			},
+			[]string{"status"},
+		),
+		UpdateRoleCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "role_update_requests",
+				Help:      "Total number of organization role update requests",
+			},
+			[]string{"status"},
+		),
+		DeleteRoleCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "role_delete_requests",
+				Help:      "Total number of organization role delete requests",
+			},
+			[]string{"status"},
+		),
+		AssignRoleCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "member_role_assign_requests",
+				Help:      "Total number of organization member role assignment requests",
+			},
+			[]string{"status"},
+		),
+		CheckPermissionCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "permission_check_requests",
+				Help:      "Total number of permission check requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+func (h *OrgRolesHandler) Register(engine *gin.Engine) {
+	metrics.Collectors = append(metrics.Collectors, h.ListRolesCounter, h.CreateRoleCounter, h.UpdateRoleCounter, h.DeleteRoleCounter, h.AssignRoleCounter, h.CheckPermissionCounter)
+
+	requireSession := middlewares.RequireAuth(middlewares.AuthModeSession)
+	engine.GET("/api/orgs/:orgId/roles", requireSession, middlewares.RequirePermission(permissions.RolesRead), h.HandleListRoles)
+	engine.POST("/api/orgs/:orgId/roles", requireSession, middlewares.RequirePermission(permissions.RolesManage), h.HandleCreateRole)
+	engine.PATCH("/api/orgs/:orgId/roles/:roleName", requireSession, middlewares.RequirePermission(permissions.RolesManage), h.HandleUpdateRole)
+	engine.DELETE("/api/orgs/:orgId/roles/:roleName", requireSession, middlewares.RequirePermission(permissions.RolesManage), h.HandleDeleteRole)
+	engine.PUT("/api/orgs/:orgId/members/:userId/role", requireSession, middlewares.RequirePermission(permissions.MembersUpdate), h.HandleAssignRole)
+	engine.POST("/api/auth/permissions/check", requireSession, h.HandleCheckPermissions)
+}
+
+type OrgRoleResult struct {
+	Name        string   `json:"name"`
+	Description *string  `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+	// Builtin is true for the 'owner', 'admin' and 'member' roles, which cannot be modified.
+	Builtin bool `json:"builtin"`
+}
+
+type ListOrgRolesResult struct {
+	Roles []OrgRoleResult `json:"roles"`
+}
+
+type CreateOrgRoleData struct {
+	Name        string   `json:"name" binding:"required"`
+	Description *string  `json:"description,omitempty"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+type UpdateOrgRoleData struct {
+	Description *string  `json:"description,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+type AssignOrgRoleData struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type CheckPermissionsData struct {
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+type CheckPermissionsResult struct {
+	// Allowed is true only if every requested permission is granted.
+	Allowed bool `json:"allowed"`
+	// Results maps each requested permission to whether it is granted.
+	Results map[string]bool `json:"results"`
+}
+
+func newOrgRoleResult(role db.OrgRole) OrgRoleResult {
+	return OrgRoleResult{
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: role.Permissions,
+		Builtin:     false,
+	}
+}
+
+// validatePermissions validates the given permissions and returns them normalized.
+func validatePermissions(perms []string) ([]string, error) {
+	for i, p := range perms {
+		perms[i] = strings.TrimSpace(p)
+		if err := permissions.ValidatePermission(perms[i]); err != nil {
+			return nil, err
+		}
+	}
+	return permissions.Normalize(perms), nil
+}
+
+// callerPermissions returns the ID, current role and permissions of the user of the session in the organization.
+//
+// They are read from the database, not from the session token, whose claims can be stale until it is refreshed, eg. after a demotion.
+func callerPermissions(ctx context.Context, c *gin.Context, q *db.Queries, orgId uuid.UUID) (uuid.UUID, string, []string, *models.ErrorResponse) {
+	// RequirePermission ensures the claims are present
+	claims := c.MustGet(middlewares.CtxSessionTokenClaims).(*tokens.NexeresClaims)
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, "", nil, models.NewErrorResponse("Invalid session token!", "Failed to parse user ID from session token", http.StatusUnauthorized, nil)
+	}
+
+	membership, err := q.GetUserOrgMembership(ctx, db.GetUserOrgMembershipParams{
+		UserID: userId,
+		OrgID:  orgId,
+	})
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && membership.Status == "banned") {
+		return uuid.Nil, "", nil, models.NewErrorResponse("You are not a member of this organization!", "User is not an active member of the organization", http.StatusForbidden, nil)
+	}
+	if err != nil {
+		return uuid.Nil, "", nil, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get organization membership!", http.StatusInternalServerError, err)
+	}
+
+	granted, err := permissions.ResolveRolePermissions(ctx, q, orgId, membership.Role)
+	if err != nil {
+		return uuid.Nil, "", nil, models.NewErrorResponse(models.GenericErrorMessage, "Failed to resolve role permissions!", http.StatusInternalServerError, err)
+	}
+	return userId, membership.Role, granted, nil
+}
+
+// HandleListRoles godoc
+// @Summary List Organization Roles
+// @Description Lists the built-in and custom roles of an organization.
+// @Tags Orgs
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Success 200 {object} ListOrgRolesResult "Organization roles"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'roles:read'"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/roles [get]
+func (h *OrgRolesHandler) HandleListRoles(c *gin.Context) {
+	h.ListRolesCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "list_org_roles")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	// RequirePermission ensures the orgId param matches the session's organization
+	orgId, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid organization ID!", "Failed to parse organization ID", http.StatusBadRequest, nil), span, log, h.ListRolesCounter, "list_org_roles")
+		return
+	}
+
+	roles, err := store.Querier.ListOrgRoles(ctx, orgId)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to list organization roles!", http.StatusInternalServerError, err), span, log, h.ListRolesCounter, "list_org_roles")
+		return
+	}
+
+	result := ListOrgRolesResult{
+		Roles: make([]OrgRoleResult, 0, len(permissions.BuiltinRoles)+len(roles)),
+	}
+	for _, name := range []string{permissions.RoleOwner, permissions.RoleAdmin, permissions.RoleMember} {
+		result.Roles = append(result.Roles, OrgRoleResult{
+			Name:        name,
+			Permissions: permissions.BuiltinRoles[name],
+			Builtin:     true,
+		})
+	}
+	for _, role := range roles {
+		result.Roles = append(result.Roles, newOrgRoleResult(role))
+	}
+
+	h.ListRolesCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, result)
+}
+
+// HandleCreateRole godoc
+// @Summary Create Organization Role
+// @Description Creates a custom role in an organization. Members can only grant the permissions they have.
+// @Tags Orgs
+// @Accept json
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Param data body CreateOrgRoleData true "Role data"
+// @Success 201 {object} OrgRoleResult "Created role"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid role name or permissions"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'roles:manage', or permissions not held"
+// @Failure 409 {object} models.ErrorResponse "Conflict - Role already exists"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/roles [post]
+func (h *OrgRolesHandler) HandleCreateRole(c *gin.Context) {
+	h.CreateRoleCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "create_org_role")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid organization ID!", "Failed to parse organization ID", http.StatusBadRequest, nil), span, log, h.CreateRoleCounter, "create_org_role")
+		return
+	}
+
+	var data CreateOrgRoleData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.CreateRoleCounter, "create_org_role")
+		return
+	}
+
+	data.Name = strings.TrimSpace(data.Name)
+	if err := permissions.ValidateRoleName(data.Name); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(err.Error(), "Invalid role name", http.StatusBadRequest, nil), span, log, h.CreateRoleCounter, "create_org_role")
+		return
+	}
+
+	perms, err := validatePermissions(data.Permissions)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(err.Error(), "Invalid permissions", http.StatusBadRequest, nil), span, log, h.CreateRoleCounter, "create_org_role")
+		return
+	}
+
+	// Members can only grant the permissions they have, otherwise 'roles:manage' would grant every permission.
+	_, _, granted, errResp := callerPermissions(ctx, c, store.Querier, orgId)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.CreateRoleCounter, "create_org_role")
+		return
+	}
+	if !permissions.HasAll(granted, perms...) {
+		utils.ProcessError(c, models.NewErrorResponse("You cannot grant permissions you do not have!", "Role permissions exceed the caller's permissions", http.StatusForbidden, nil), span, log, h.CreateRoleCounter, "create_org_role")
+		return
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to generate role ID!", http.StatusInternalServerError, err), span, log, h.CreateRoleCounter, "create_org_role")
+		return
+	}
+
+	role, err := store.Querier.CreateOrgRole(ctx, db.CreateOrgRoleParams{
+		ID:          id,
+		OrgID:       orgId,
+		Name:        data.Name,
+		Description: data.Description,
+		Permissions: perms,
+	})
+	if err != nil {
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+			utils.ProcessError(c, models.NewErrorResponse("A role with this name already exists!", "Role name conflicts with an existing role", http.StatusConflict, nil), span, log, h.CreateRoleCounter, "create_org_role")
+			return
+		}
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to create organization role!", http.StatusInternalServerError, err), span, log, h.CreateRoleCounter, "create_org_role")
+		return
+	}
+
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionRoleCreated,
+		OrgID:      &orgId,
+		ResourceID: &role.ID,
+		Metadata: map[string]any{
+			"role":        role.Name,
+			"permissions": role.Permissions,
+		},
+	})
+	log.Debug("Organization role created", zap.String("orgId", orgId.String()), zap.String("role", role.Name))
+	h.CreateRoleCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusCreated, newOrgRoleResult(role))
+}
+
+// HandleUpdateRole godoc
+// @Summary Update Organization Role
+// @Description Updates the description and/or permissions of a custom role.
+// @Description Members with this role get the new permissions when their session is refreshed. Members can only grant the permissions they have.
+// @Tags Orgs
+// @Accept json
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Param roleName path string true "Role name"
+// @Param data body UpdateOrgRoleData true "Role data"
+// @Success 200 {object} OrgRoleResult "Updated role"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid permissions or built-in role"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'roles:manage', or permissions not held"
+// @Failure 404 {object} models.ErrorResponse "Role not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/roles/{roleName} [patch]
+func (h *OrgRolesHandler) HandleUpdateRole(c *gin.Context) {
+	h.UpdateRoleCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "update_org_role")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid organization ID!", "Failed to parse organization ID", http.StatusBadRequest, nil), span, log, h.UpdateRoleCounter, "update_org_role")
+		return
+	}
+
+	roleName := c.Param("roleName")
+	if permissions.IsBuiltinRole(roleName) {
+		utils.ProcessError(c, models.NewErrorResponse("Built-in roles cannot be modified!", "Attempted to update a built-in role", http.StatusBadRequest, nil), span, log, h.UpdateRoleCounter, "update_org_role")
+		return
+	}
+
+	var data UpdateOrgRoleData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.UpdateRoleCounter, "update_org_role")
+		return
+	}
+
+	params := db.UpdateOrgRoleParams{
+		Description: data.Description,
+		OrgID:       orgId,
+		Name:        roleName,
+	}
+	if data.Permissions != nil {
+		params.Permissions, err = validatePermissions(data.Permissions)
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(err.Error(), "Invalid permissions", http.StatusBadRequest, nil), span, log, h.UpdateRoleCounter, "update_org_role")
+			return
+		}
+
+		// Members can only grant the permissions they have, otherwise 'roles:manage' would grant every permission.
+		_, _, granted, errResp := callerPermissions(ctx, c, store.Querier, orgId)
+		if errResp != nil {
+			utils.ProcessError(c, errResp, span, log, h.UpdateRoleCounter, "update_org_role")
+			return
+		}
+		if !permissions.HasAll(granted, params.Permissions...) {
+			utils.ProcessError(c, models.NewErrorResponse("You cannot grant permissions you do not have!", "Role permissions exceed the caller's permissions", http.StatusForbidden, nil), span, log, h.UpdateRoleCounter, "update_org_role")
+			return
+		}
+	}
+
+	role, err := store.Querier.UpdateOrgRole(ctx, params)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("Role not found!", "No role found with the given name", http.StatusNotFound, nil), span, log, h.UpdateRoleCounter, "update_org_role")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to update organization role!", http.StatusInternalServerError, err), span, log, h.UpdateRoleCounter, "update_org_role")
+		return
+	}
+
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionRoleUpdated,
+		OrgID:      &orgId,
+		ResourceID: &role.ID,
+		Metadata: map[string]any{
+			"role":        role.Name,
+			"permissions": role.Permissions,
+		},
+	})
+	log.Debug("Organization role updated", zap.String("orgId", orgId.String()), zap.String("role", role.Name))
+	h.UpdateRoleCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, newOrgRoleResult(role))
+}
+
+// HandleDeleteRole godoc
+// @Summary Delete Organization Role
+// @Description Deletes a custom role. The role must not be assigned to any member.
+// @Tags Orgs
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Param roleName path string true "Role name"
+// @Success 204 "Role deleted"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Built-in role"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'roles:manage'"
+// @Failure 404 {object} models.ErrorResponse "Role not found"
+// @Failure 409 {object} models.ErrorResponse "Conflict - Role is still assigned to members"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/roles/{roleName} [delete]
+func (h *OrgRolesHandler) HandleDeleteRole(c *gin.Context) {
+	h.DeleteRoleCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "delete_org_role")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid organization ID!", "Failed to parse organization ID", http.StatusBadRequest, nil), span, log, h.DeleteRoleCounter, "delete_org_role")
+		return
+	}
+
+	roleName := c.Param("roleName")
+	if permissions.IsBuiltinRole(roleName) {
+		utils.ProcessError(c, models.NewErrorResponse("Built-in roles cannot be deleted!", "Attempted to delete a built-in role", http.StatusBadRequest, nil), span, log, h.DeleteRoleCounter, "delete_org_role")
+		return
+	}
+
+	tx, err := store.PgPool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to begin transaction!", http.StatusInternalServerError, err), span, log, h.DeleteRoleCounter, "delete_org_role")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	q := store.Querier.WithTx(tx)
+
+	// Lock the role, so that it cannot be assigned between the count and the deletion.
+	_, err = q.GetOrgRoleByNameForUpdate(ctx, db.GetOrgRoleByNameForUpdateParams{
+		OrgID: orgId,
+		Name:  roleName,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("Role not found!", "No role found with the given name", http.StatusNotFound, nil), span, log, h.DeleteRoleCounter, "delete_org_role")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get organization role!", http.StatusInternalServerError, err), span, log, h.DeleteRoleCounter, "delete_org_role")
+		return
+	}
+
+	count, err := q.CountOrgMembersWithRole(ctx, db.CountOrgMembersWithRoleParams{
+		OrgID: orgId,
+		Role:  roleName,
+	})
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to count members with role!", http.StatusInternalServerError, err), span, log, h.DeleteRoleCounter, "delete_org_role")
+		return
+	}
+	if count > 0 {
+		utils.ProcessError(c, models.NewErrorResponse("The role is still assigned to members! Reassign them before deleting the role.", "Role is assigned to members", http.StatusConflict, nil), span, log, h.DeleteRoleCounter, "delete_org_role")
+		return
+	}
+
+	if err := q.DeleteOrgRole(ctx, db.DeleteOrgRoleParams{
+		OrgID: orgId,
+		Name:  roleName,
+	}); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to delete organization role!", http.StatusInternalServerError, err), span, log, h.DeleteRoleCounter, "delete_org_role")
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to commit transaction!", http.StatusInternalServerError, err), span, log, h.DeleteRoleCounter, "delete_org_role")
+		return
+	}
+
+	recordSessionAudit(c, audit.Event{
+		Action: audit.ActionRoleDeleted,
+		OrgID:  &orgId,
+		Metadata: map[string]any{
+			"role": roleName,
+		},
+	})
+	log.Debug("Organization role deleted", zap.String("orgId", orgId.String()), zap.String("role", roleName))
+	h.DeleteRoleCounter.WithLabelValues("success").Inc()
+	c.Status(http.StatusNoContent)
+}
+
+// HandleAssignRole godoc
+// @Summary Assign Member Role
+// @Description Assigns a built-in or custom role to a member of the organization.
+// @Description Only owners can assign or remove the 'owner' role. Members cannot change their own role,
+// @Description and can only assign roles, and change the roles of members, whose permissions they have themselves.
+// @Tags Orgs
+// @Accept json
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Param userId path string true "User ID"
+// @Param data body AssignOrgRoleData true "Role to assign"
+// @Success 204 "Role assigned"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Unknown role"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'members:update', not an owner, own role or permissions not held"
+// @Failure 404 {object} models.ErrorResponse "Member not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/members/{userId}/role [put]
+func (h *OrgRolesHandler) HandleAssignRole(c *gin.Context) {
+	h.AssignRoleCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "assign_org_role")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid organization ID!", "Failed to parse organization ID", http.StatusBadRequest, nil), span, log, h.AssignRoleCounter, "assign_org_role")
+		return
+	}
+	userId, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid user ID!", "Failed to parse user ID", http.StatusBadRequest, nil), span, log, h.AssignRoleCounter, "assign_org_role")
+		return
+	}
+
+	var data AssignOrgRoleData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.AssignRoleCounter, "assign_org_role")
+		return
+	}
+
+	tx, err := store.PgPool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to begin transaction!", http.StatusInternalServerError, err), span, log, h.AssignRoleCounter, "assign_org_role")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	q := store.Querier.WithTx(tx)
+
+	callerId, callerRole, granted, errResp := callerPermissions(ctx, c, q, orgId)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.AssignRoleCounter, "assign_org_role")
+		return
+	}
+	if callerId == userId {
+		utils.ProcessError(c, models.NewErrorResponse("You cannot change your own role!", "Attempted to change the caller's own role", http.StatusForbidden, nil), span, log, h.AssignRoleCounter, "assign_org_role")
+		return
+	}
+
+	rolePerms, ok := permissions.BuiltinRoles[data.Role]
+	if !ok {
+		// Lock the role, so that it cannot be deleted before the assignment is committed.
+		role, err := q.GetOrgRoleByNameForUpdate(ctx, db.GetOrgRoleByNameForUpdateParams{
+			OrgID: orgId,
+			Name:  data.Role,
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.ProcessError(c, models.NewErrorResponse("Role not found!", "No role found with the given name", http.StatusBadRequest, nil), span, log, h.AssignRoleCounter, "assign_org_role")
+			return
+		}
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get organization role!", http.StatusInternalServerError, err), span, log, h.AssignRoleCounter, "assign_org_role")
+			return
+		}
+		rolePerms = role.Permissions
+	}
+
+	membership, err := q.GetUserOrgMembership(ctx, db.GetUserOrgMembershipParams{
+		UserID: userId,
+		OrgID:  orgId,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("Member not found!", "User is not a member of the organization", http.StatusNotFound, nil), span, log, h.AssignRoleCounter, "assign_org_role")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get organization membership!", http.StatusInternalServerError, err), span, log, h.AssignRoleCounter, "assign_org_role")
+		return
+	}
+
+	// Only owners can grant or take away ownership.
+	if (data.Role == permissions.RoleOwner || membership.Role == permissions.RoleOwner) && callerRole != permissions.RoleOwner {
+		utils.ProcessError(c, models.NewErrorResponse("Only owners can change the owner role!", "Non-owner attempted to change an owner role", http.StatusForbidden, nil), span, log, h.AssignRoleCounter, "assign_org_role")
+		return
+	}
+
+	// Members can only assign roles, and change the role of members, with permissions they have themselves.
+	currentPerms, err := permissions.ResolveRolePermissions(ctx, q, orgId, membership.Role)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to resolve role permissions!", http.StatusInternalServerError, err), span, log, h.AssignRoleCounter, "assign_org_role")
+		return
+	}
+	if !permissions.HasAll(granted, rolePerms...) || !permissions.HasAll(granted, currentPerms...) {
+		utils.ProcessError(c, models.NewErrorResponse("You cannot assign or change a role with permissions you do not have!", "Role permissions exceed the caller's permissions", http.StatusForbidden, nil), span, log, h.AssignRoleCounter, "assign_org_role")
+		return
+	}
+
+	// An organization must always keep at least one owner.
+	if membership.Role == permissions.RoleOwner && data.Role != permissions.RoleOwner {
+		owners, err := q.CountOrgMembersWithRole(ctx, db.CountOrgMembersWithRoleParams{
+			OrgID: orgId,
+			Role:  permissions.RoleOwner,
+		})
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to count organization owners!", http.StatusInternalServerError, err), span, log, h.AssignRoleCounter, "assign_org_role")
+			return
+		}
+		if owners <= 1 {
+			utils.ProcessError(c, models.NewErrorResponse("The organization must have at least one owner!", "Attempted to remove the last owner", http.StatusBadRequest, nil), span, log, h.AssignRoleCounter, "assign_org_role")
+			return
+		}
+	}
+
+	if err := q.UpdateUserOrgRole(ctx, db.UpdateUserOrgRoleParams{
+		Role:   data.Role,
+		UserID: userId,
+		OrgID:  orgId,
+	}); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to update member role!", http.StatusInternalServerError, err), span, log, h.AssignRoleCounter, "assign_org_role")
+		return
+	}
+
+	if err := webhooks.Enqueue(ctx, q, orgId, webhooks.EventMemberRoleChanged, map[string]any{
+		"userId":       userId,
+		"previousRole": membership.Role,
+		"role":         data.Role,
+	}); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to enqueue webhooks!", http.StatusInternalServerError, err), span, log, h.AssignRoleCounter, "assign_org_role")
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to commit transaction!", http.StatusInternalServerError, err), span, log, h.AssignRoleCounter, "assign_org_role")
+		return
+	}
+
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionMemberRoleChanged,
+		OrgID:      &orgId,
+		ResourceID: &userId,
+		Metadata: map[string]any{
+			"previousRole": membership.Role,
+			"role":         data.Role,
+		},
+	})
+	log.Debug("Member role assigned", zap.String("orgId", orgId.String()), zap.String("userId", userId.String()), zap.String("role", data.Role))
+	h.AssignRoleCounter.WithLabelValues("success").Inc()
+	c.Status(http.StatusNoContent)
+}
+
+// HandleCheckPermissions godoc
+// @Summary Check Permissions
+// @Description Checks whether the current session grants the given permissions in its organization.
+// @Description The permissions are resolved from the current role of the user, so changes are visible before the session is refreshed.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param data body CheckPermissionsData true "Permissions to check"
+// @Success 200 {object} CheckPermissionsResult "Check result"
+// @Failure 400 {object} models.ErrorResponse "Bad Request"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/auth/permissions/check [post]
+func (h *OrgRolesHandler) HandleCheckPermissions(c *gin.Context) {
+	h.CheckPermissionCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "check_permissions")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	var data CheckPermissionsData
+	if err := c.ShouldBindJSON(&data); err != nil || len(data.Permissions) == 0 {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.CheckPermissionCounter, "check_permissions")
+		return
+	}
+
+	claims, ok := c.MustGet(middlewares.CtxSessionTokenClaims).(*tokens.NexeresClaims)
+	if !ok || claims == nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid session token!", "Session token claims not found", http.StatusUnauthorized, nil), span, log, h.CheckPermissionCounter, "check_permissions")
+		return
+	}
+
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid session token!", "Failed to parse user ID from session token", http.StatusUnauthorized, nil), span, log, h.CheckPermissionCounter, "check_permissions")
+		return
+	}
+	orgId, err := uuid.Parse(claims.OrgId)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid session token!", "Failed to parse organization ID from session token", http.StatusUnauthorized, nil), span, log, h.CheckPermissionCounter, "check_permissions")
+		return
+	}
+
+	membership, err := store.Querier.GetUserOrgMembership(ctx, db.GetUserOrgMembershipParams{
+		UserID: userId,
+		OrgID:  orgId,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("You are not a member of this organization!", "User is not a member of the session organization", http.StatusUnauthorized, nil), span, log, h.CheckPermissionCounter, "check_permissions")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get organization membership!", http.StatusInternalServerError, err), span, log, h.CheckPermissionCounter, "check_permissions")
+		return
+	}
+
+	granted := []string{}
+	if membership.Status != "banned" {
+		granted, err = permissions.ResolveRolePermissions(ctx, store.Querier, orgId, membership.Role)
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to resolve role permissions!", http.StatusInternalServerError, err), span, log, h.CheckPermissionCounter, "check_permissions")
+			return
+		}
+	}
+
+	result := CheckPermissionsResult{
+		Allowed: true,
+		Results: make(map[string]bool, len(data.Permissions)),
+	}
+	for _, p := range slices.Compact(slices.Sorted(slices.Values(data.Permissions))) {
+		ok := permissions.Has(granted, p)
+		result.Results[p] = ok
+		result.Allowed = result.Allowed && ok
+	}
+
+	h.CheckPermissionCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, result)
+}
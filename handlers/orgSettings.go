@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/middlewares"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/orgsettings"
+	"github.com/nbrglm/nexeres/internal/permissions"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type OrgSettingsHandler struct {
+	GetSecurityPolicyCounter    *prometheus.CounterVec
+	UpdateSecurityPolicyCounter *prometheus.CounterVec
+}
+
+func NewOrgSettingsHandler() *OrgSettingsHandler {
+	return &OrgSettingsHandler{
+		GetSecurityPolicyCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "security_policy_get_requests",
+				Help:      "Total number of organization security policy get requests",
+			},
+			[]string{"status"},
+		),
+		UpdateSecurityPolicyCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "security_policy_update_requests",
+				Help:      "Total number of organization security policy update requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+func (h *OrgSettingsHandler) Register(engine *gin.Engine) {
+	metrics.Collectors = append(metrics.Collectors, h.GetSecurityPolicyCounter, h.UpdateSecurityPolicyCounter)
+
+	requireSession := middlewares.RequireAuth(middlewares.AuthModeSession)
+	engine.GET("/api/orgs/:orgId/settings/security", requireSession, middlewares.RequirePermission(permissions.SettingsRead), h.HandleGetSecurityPolicy)
+	engine.PUT("/api/orgs/:orgId/settings/security", requireSession, middlewares.RequirePermission(permissions.SettingsUpdate), h.HandleUpdateSecurityPolicy)
+}
+
+type SecurityPolicyResult struct {
+	Policy orgsettings.SecurityPolicy `json:"policy"`
+}
+
+// HandleGetSecurityPolicy godoc
+// @Summary Get Organization Security Policy
+// @Description Returns the security policy of an organization.
+// @Tags Orgs
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Success 200 {object} SecurityPolicyResult "Security policy"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'settings:read'"
+// @Failure 404 {object} models.ErrorResponse "Organization not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/settings/security [get]
+func (h *OrgSettingsHandler) HandleGetSecurityPolicy(c *gin.Context) {
+	h.GetSecurityPolicyCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "get_org_security_policy")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.GetSecurityPolicyCounter, "get_org_security_policy")
+		return
+	}
+
+	org, err := store.Querier.GetOrgByID(ctx, orgId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("Organization not found!", "No organization found for the given ID", http.StatusNotFound, nil), span, log, h.GetSecurityPolicyCounter, "get_org_security_policy")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get organization!", http.StatusInternalServerError, err), span, log, h.GetSecurityPolicyCounter, "get_org_security_policy")
+		return
+	}
+
+	settings, err := orgsettings.ForOrg(&org)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to parse organization settings!", http.StatusInternalServerError, err), span, log, h.GetSecurityPolicyCounter, "get_org_security_policy")
+		return
+	}
+
+	h.GetSecurityPolicyCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, SecurityPolicyResult{
+		Policy: settings.Security,
+	})
+}
+
+// HandleUpdateSecurityPolicy godoc
+// @Summary Update Organization Security Policy
+// @Description Replaces the security policy of an organization.
+// @Description The new policy applies to new logins and on the next token refresh of existing sessions.
+// @Description 'requireMfa' cannot be enabled yet, since multi-factor authentication cannot be completed.
+// @Tags Orgs
+// @Accept json
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Param data body orgsettings.SecurityPolicy true "Security policy"
+// @Success 200 {object} SecurityPolicyResult "Updated security policy"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid policy, requireMfa enabled, or the IP allowlist excludes your own address"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'settings:update'"
+// @Failure 404 {object} models.ErrorResponse "Organization not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/settings/security [put]
+func (h *OrgSettingsHandler) HandleUpdateSecurityPolicy(c *gin.Context) {
+	h.UpdateSecurityPolicyCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "update_org_security_policy")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.UpdateSecurityPolicyCounter, "update_org_security_policy")
+		return
+	}
+
+	var policy orgsettings.SecurityPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.UpdateSecurityPolicyCounter, "update_org_security_policy")
+		return
+	}
+	if err := policy.Validate(); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid security policy! Please check your input and try again.", err.Error(), http.StatusBadRequest, nil), span, log, h.UpdateSecurityPolicyCounter, "update_org_security_policy")
+		return
+	}
+
+	// Do not let admins lock themselves out.
+	if !policy.AllowsIP(c.ClientIP()) {
+		utils.ProcessError(c, models.NewErrorResponse("The IP allowlist must include your current IP address!", "IP allowlist excludes the requester's IP address", http.StatusBadRequest, nil), span, log, h.UpdateSecurityPolicyCounter, "update_org_security_policy")
+		return
+	}
+
+	tx, err := store.PgPool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to begin transaction!", http.StatusInternalServerError, err), span, log, h.UpdateSecurityPolicyCounter, "update_org_security_policy")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	q := store.Querier.WithTx(tx)
+
+	org, err := q.GetOrgByIDForUpdate(ctx, orgId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("Organization not found!", "No organization found for the given ID", http.StatusNotFound, nil), span, log, h.UpdateSecurityPolicyCounter, "update_org_security_policy")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get organization!", http.StatusInternalServerError, err), span, log, h.UpdateSecurityPolicyCounter, "update_org_security_policy")
+		return
+	}
+
+	// Only the security section is replaced, other settings are preserved.
+	settings, err := orgsettings.Merge(org.Settings, orgsettings.SectionSecurity, policy)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to merge organization settings!", http.StatusInternalServerError, err), span, log, h.UpdateSecurityPolicyCounter, "update_org_security_policy")
+		return
+	}
+
+	if _, err := q.UpdateOrg(ctx, db.UpdateOrgParams{
+		Settings: settings,
+		ID:       orgId,
+	}); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to update organization settings!", http.StatusInternalServerError, err), span, log, h.UpdateSecurityPolicyCounter, "update_org_security_policy")
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to commit transaction!", http.StatusInternalServerError, err), span, log, h.UpdateSecurityPolicyCounter, "update_org_security_policy")
+		return
+	}
+	middlewares.InvalidateTenant(ctx, org.Slug)
+
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionSecurityPolicyUpdated,
+		OrgID:      &orgId,
+		ResourceID: &orgId,
+		Metadata: map[string]any{
+			"policy": policy,
+		},
+	})
+
+	h.UpdateSecurityPolicyCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, SecurityPolicyResult{
+		Policy: policy,
+	})
+}
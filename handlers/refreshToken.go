@@ -5,13 +5,18 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/nbrglm/nexeres/db"
 	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/hooks"
 	"github.com/nbrglm/nexeres/internal/metrics"
 	"github.com/nbrglm/nexeres/internal/middlewares"
 	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/orgsettings"
+	"github.com/nbrglm/nexeres/internal/permissions"
 	"github.com/nbrglm/nexeres/internal/store"
 	"github.com/nbrglm/nexeres/internal/tokens"
 	"github.com/nbrglm/nexeres/utils"
@@ -56,6 +61,7 @@ type RefreshTokenResult struct {
 // @Success 200 {object} RefreshTokenResult "New tokens"
 // @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid or missing tokens"
 // @Failure 401 {object} models.ErrorResponse "Unauthorized - Invalid or expired tokens - Proceed to Login"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - The session belongs to another organization than the tenant host"
 // @Failure 500 {object} models.ErrorResponse "Internal server error"
 // @Router /api/auth/refresh [post]
 func (h *RefreshTokenHandler) HandleRefreshToken(c *gin.Context) {
@@ -85,6 +91,7 @@ func (h *RefreshTokenHandler) HandleRefreshToken(c *gin.Context) {
 	session, err := q.GetSessionByRefreshToken(ctx, refreshTokenHash)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
+			auditRefreshFailure(c, nil, nil, nil, "invalid_token")
 			utils.ProcessError(c, models.NewErrorResponse("Invalid refresh token! Please login again.", "No session found for refresh token", http.StatusUnauthorized, nil), span, log, h.RefreshTokenCounter, "refresh_token")
 			return
 		}
@@ -94,6 +101,13 @@ func (h *RefreshTokenHandler) HandleRefreshToken(c *gin.Context) {
 	}
 	log.Debug("Session retrieved successfully", zap.String("sessionID", session.ID.String()))
 
+	// On a tenant host, only sessions of that organization can be refreshed.
+	if tenant := middlewares.GetTenant(c); tenant != nil && tenant.ID != session.OrgID {
+		auditRefreshFailure(c, &session.OrgID, &session.UserID, &session.ID, "wrong_tenant")
+		utils.ProcessError(c, models.NewErrorResponse("You do not have access to this organization!", "The session belongs to a different organization than the tenant host", http.StatusForbidden, nil), span, log, h.RefreshTokenCounter, "refresh_token")
+		return
+	}
+
 	// We DO NOT CHECK if the token has been revoked here as:
 	// The revocation is done by deleting the session from the database.
 	// So if the session exists, the token is valid and not revoked.
@@ -118,11 +132,70 @@ func (h *RefreshTokenHandler) HandleRefreshToken(c *gin.Context) {
 	}
 	log.Debug("User and organization info retrieved successfully", zap.String("userID", session.UserID.String()), zap.String("orgSlug", newTokenInfo.OrgSlug))
 
+	org, err := q.GetOrgByID(ctx, session.OrgID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.ProcessError(c, models.NewErrorResponse("User or organization not found! Please login again.", "No organization found for session", http.StatusUnauthorized, nil), span, log, h.RefreshTokenCounter, "refresh_token")
+			return
+		}
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Unable to retrieve organization", http.StatusInternalServerError, err), span, log, h.RefreshTokenCounter, "refresh_token")
+		return
+	}
+
+	settings, err := orgsettings.ForOrg(&org)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Unable to parse organization settings", http.StatusInternalServerError, err), span, log, h.RefreshTokenCounter, "refresh_token")
+		return
+	}
+
+	// The policy may have changed since the session was created, so it is enforced on every refresh.
+	if !settings.Security.AllowsIP(c.ClientIP()) {
+		auditRefreshFailure(c, &session.OrgID, &session.UserID, &session.ID, "security_policy")
+		utils.ProcessError(c, models.NewErrorResponse("Access is not allowed from your network! Please contact your administrator.", "Client IP address is not in the organization's allowlist", http.StatusUnauthorized, nil), span, log, h.RefreshTokenCounter, "refresh_token")
+		return
+	}
+	if settings.Security.RequireMFA && !session.MfaVerified {
+		auditRefreshFailure(c, &session.OrgID, &session.UserID, &session.ID, "mfa_required")
+		utils.ProcessError(c, models.NewErrorResponse("Multi-factor authentication is required! Please login again.", "Organization requires MFA but the session is not MFA verified", http.StatusUnauthorized, nil), span, log, h.RefreshTokenCounter, "refresh_token")
+		return
+	}
+
+	// Resolve the permissions again, so that role changes are picked up on refresh.
+	perms, err := permissions.ResolveRolePermissions(ctx, q, session.OrgID, newTokenInfo.UserOrgRole)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Unable to resolve role permissions", http.StatusInternalServerError, err), span, log, h.RefreshTokenCounter, "refresh_token")
+		return
+	}
+
 	avatarUrl := ""
 	if newTokenInfo.UserAvatarUrl != nil {
 		avatarUrl = *newTokenInfo.UserAvatarUrl
 	}
 
+	ext, errResp := runPreTokenHooks(ctx, hooks.Request{
+		Trigger: hooks.TriggerRefresh,
+		User: hooks.User{
+			ID:            session.UserID,
+			Email:         newTokenInfo.UserEmail,
+			EmailVerified: newTokenInfo.UserEmailVerified,
+			FirstName:     newTokenInfo.UserFname,
+			LastName:      newTokenInfo.UserLname,
+		},
+		Org: hooks.Org{
+			ID:   session.OrgID,
+			Slug: newTokenInfo.OrgSlug,
+			Name: newTokenInfo.OrgName,
+		},
+		Role:        newTokenInfo.UserOrgRole,
+		Permissions: perms,
+		IPAddress:   c.ClientIP(),
+		UserAgent:   c.Request.UserAgent(),
+	})
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.RefreshTokenCounter, "refresh_token")
+		return
+	}
+
 	log.Debug("Generating new tokens for user", zap.String("orgSlug", newTokenInfo.OrgSlug))
 
 	newTokenPair, err := tokens.RefreshSessionTokens(session, tokens.NexeresClaims{
@@ -136,7 +209,9 @@ func (h *RefreshTokenHandler) HandleRefreshToken(c *gin.Context) {
 		UserLname:     *newTokenInfo.UserLname,
 		UserAvatarURL: avatarUrl,
 		UserOrgRole:   newTokenInfo.UserOrgRole,
-	})
+		Permissions:   perms,
+		Ext:           ext,
+	}, settings.Security.Lifetimes())
 	if err != nil {
 		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Unable to generate new tokens", http.StatusInternalServerError, err), span, log, h.RefreshTokenCounter, "refresh_token")
 		return
@@ -165,8 +240,28 @@ func (h *RefreshTokenHandler) HandleRefreshToken(c *gin.Context) {
 		return
 	}
 
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionTokenRefreshed,
+		OrgID:      &session.OrgID,
+		ActorID:    &session.UserID,
+		ResourceID: &session.ID,
+	})
+
 	h.RefreshTokenCounter.WithLabelValues("success").Inc()
 	c.JSON(http.StatusOK, RefreshTokenResult{
 		Tokens: newTokenPair,
 	})
 }
+
+// auditRefreshFailure records a failed token refresh, with the reason in the metadata.
+func auditRefreshFailure(c *gin.Context, orgID, userID, sessionID *uuid.UUID, reason string) {
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionRefreshFailed,
+		OrgID:      orgID,
+		ActorID:    userID,
+		ResourceID: sessionID,
+		Metadata: map[string]any{
+			"reason": reason,
+		},
+	})
+}
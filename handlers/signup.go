@@ -12,10 +12,15 @@ import (
 	"github.com/nbrglm/nexeres/config"
 	"github.com/nbrglm/nexeres/db"
 	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
 	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/middlewares"
 	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/notifications/templates"
+	"github.com/nbrglm/nexeres/internal/orgsettings"
 	"github.com/nbrglm/nexeres/internal/password"
 	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/internal/webhooks"
 	"github.com/nbrglm/nexeres/utils"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -50,6 +55,9 @@ type UserSignupData struct {
 	FirstName       string `json:"firstName" binding:"required"`
 	LastName        string `json:"lastName" binding:"required"`
 	InviteToken     string `json:"inviteToken,omitempty"` // Optional invite token for signup
+	// Locale is the preferred locale of the user's notifications, as a BCP 47 language tag, eg. "de".
+	// If not set, the best match of the Accept-Language header is used, if any.
+	Locale string `json:"locale,omitempty" binding:"omitempty,bcp47_language_tag"`
 }
 
 type UserSignupResult struct {
@@ -57,6 +65,20 @@ type UserSignupResult struct {
 	Message string `json:"message"`
 }
 
+// signupLocale returns the locale of a new user: the requested locale if set, otherwise the supported locale
+// which best matches the Accept-Language header. It returns nil if neither is known, so that the organization's default is used.
+func signupLocale(c *gin.Context, requested string) *string {
+	if requested != "" {
+		if locale, err := templates.NormalizeLocale(requested); err == nil {
+			return &locale
+		}
+	}
+	if locale := templates.MatchAcceptLanguage(c.GetHeader("Accept-Language")); locale != "" {
+		return &locale
+	}
+	return nil
+}
+
 // HandleSignup godoc
 // @Summary User Signup
 // @Description Handles user registration requests.
@@ -67,6 +89,7 @@ type UserSignupResult struct {
 // @Success 200 {object} UserSignupResult "User Signup Result"
 // @Failure 400 {object} models.ErrorResponse "Bad Request"
 // @Failure 401 {object} models.ErrorResponse "Unauthorized - Invalid Invite Token or Missing Invite Token or Domain Not Allowed"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - IP address not allowed by the organization"
 // @Failure 500 {object} models.ErrorResponse "Internal Server Error"
 // @Router /api/auth/signup [post]
 func (h *SignupHandler) HandleSignup(c *gin.Context) {
@@ -100,6 +123,7 @@ func (h *SignupHandler) HandleSignup(c *gin.Context) {
 			// Check if the domain matches any verified, auto-join enabled domains for any organization
 			organization, err := store.Querier.GetOrgForDomainIfAutoJoin(ctx, domain)
 			if errors.Is(err, pgx.ErrNoRows) {
+				auditSignupFailure(c, nil, signupData.Email, "no_organization")
 				utils.ProcessError(c, models.NewErrorResponse("Email is not associated to any Organizations! Please contact your administrator.", "No organization found for domain that has auto-join enabled! If you have not verified the domain yet, please do so.", http.StatusUnauthorized, nil), span, log, h.SignupCounter, "signup")
 				return
 			}
@@ -114,6 +138,7 @@ func (h *SignupHandler) HandleSignup(c *gin.Context) {
 		} else {
 			invitation, err := store.Querier.GetInvitationByToken(ctx, signupData.InviteToken)
 			if errors.Is(err, pgx.ErrNoRows) {
+				auditSignupFailure(c, nil, signupData.Email, "invalid_invite")
 				utils.ProcessError(c, models.NewErrorResponse("Invalid invite token! Please check your token and try again.", "No invitation found for the provided token!", http.StatusUnauthorized, nil), span, log, h.SignupCounter, "signup")
 				return
 			}
@@ -122,6 +147,7 @@ func (h *SignupHandler) HandleSignup(c *gin.Context) {
 				return
 			}
 			if invitation.Email != signupData.Email {
+				auditSignupFailure(c, &invitation.OrgID, signupData.Email, "invalid_invite")
 				utils.ProcessError(c, models.NewErrorResponse("Invalid invite token! Please check your token and try again.", "The invite token does not match the provided email!", http.StatusUnauthorized, nil), span, log, h.SignupCounter, "signup")
 				return
 			}
@@ -149,6 +175,29 @@ func (h *SignupHandler) HandleSignup(c *gin.Context) {
 		org = &organization
 	}
 
+	// On a tenant host, users can only signup to that organization.
+	if tenant := middlewares.GetTenant(c); tenant != nil && tenant.ID != org.ID {
+		auditSignupFailure(c, &tenant.ID, signupData.Email, "tenant_mismatch")
+		utils.ProcessError(c, models.NewErrorResponse("Signup is not allowed for this organization! Please contact your administrator.", "The resolved organization does not match the tenant organization!", http.StatusUnauthorized, nil), span, log, h.SignupCounter, "signup")
+		return
+	}
+
+	settings, err := orgsettings.ForOrg(org)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to parse organization settings!", http.StatusInternalServerError, err), span, log, h.SignupCounter, "signup")
+		return
+	}
+	if !settings.Security.AllowsIP(c.ClientIP()) {
+		auditSignupFailure(c, &org.ID, signupData.Email, "security_policy")
+		utils.ProcessError(c, models.NewErrorResponse("Signup is not allowed from your network! Please contact your administrator.", "Client IP address is not in the organization's allowlist!", http.StatusForbidden, nil), span, log, h.SignupCounter, "signup")
+		return
+	}
+	if err := settings.Security.Password.Validate(signupData.Password); err != nil {
+		auditSignupFailure(c, &org.ID, signupData.Email, "password_policy")
+		utils.ProcessError(c, models.NewErrorResponse("Password does not meet your organization's requirements: "+err.Error(), "Password does not comply with the organization's password policy!", http.StatusBadRequest, nil), span, log, h.SignupCounter, "signup")
+		return
+	}
+
 	id, err := uuid.NewV7()
 	if err != nil {
 		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to generate user ID!", http.StatusInternalServerError, err), span, log, h.SignupCounter, "signup")
@@ -177,9 +226,11 @@ func (h *SignupHandler) HandleSignup(c *gin.Context) {
 		PasswordHash: &passwordHash,
 		FirstName:    &signupData.FirstName,
 		LastName:     &signupData.LastName,
+		Locale:       signupLocale(c, signupData.Locale),
 	})
 	if err != nil {
 		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+			auditSignupFailure(c, &org.ID, signupData.Email, "email_taken")
 			utils.ProcessError(c, models.NewErrorResponse("Email is already registered! Please login or use a different email.", "User with this email already exists!", http.StatusBadRequest, nil), span, log, h.SignupCounter, "signup")
 			return
 		}
@@ -197,12 +248,31 @@ func (h *SignupHandler) HandleSignup(c *gin.Context) {
 		return
 	}
 
+	if err := webhooks.Enqueue(ctx, q, org.ID, webhooks.EventUserCreated, map[string]any{
+		"userId": user.ID,
+		"email":  user.Email,
+		"role":   role,
+	}); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to enqueue webhooks!", http.StatusInternalServerError, err), span, log, h.SignupCounter, "signup")
+		return
+	}
+
 	// Commit the transaction, user creating is successful!
 	if err := tx.Commit(ctx); err != nil {
 		utils.ProcessError(c, models.NewErrorResponse("An error occurred while processing your request. Please try again later.", "Failed to commit transaction!", http.StatusInternalServerError, err), span, log, h.SignupCounter, "signup")
 		return
 	}
 
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionSignup,
+		OrgID:      &org.ID,
+		ActorID:    &user.ID,
+		ResourceID: &user.ID,
+		Metadata: map[string]any{
+			"role": role,
+		},
+	})
+
 	// Implementation of the signup logic goes here.
 	// This is a placeholder to illustrate where the actual signup handling code would be placed.
 	c.JSON(http.StatusOK, &UserSignupResult{
@@ -210,3 +280,15 @@ func (h *SignupHandler) HandleSignup(c *gin.Context) {
 		Message: "Signup successful!",
 	})
 }
+
+// auditSignupFailure records a failed signup for the email, with the hash of the email and the reason in the metadata.
+func auditSignupFailure(c *gin.Context, orgID *uuid.UUID, email string, reason string) {
+	audit.RecordRequest(c, audit.Event{
+		Action: audit.ActionSignupFailed,
+		OrgID:  orgID,
+		Metadata: map[string]any{
+			"emailHash": audit.EmailHash(email),
+			"reason":    reason,
+		},
+	})
+}
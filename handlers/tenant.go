@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/middlewares"
+	"github.com/nbrglm/nexeres/internal/notifications"
+	"github.com/nbrglm/nexeres/internal/orgsettings"
+	"github.com/nbrglm/nexeres/internal/tokens"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type TenantHandler struct {
+	TenantInfoCounter *prometheus.CounterVec
+}
+
+func NewTenantHandler() *TenantHandler {
+	return &TenantHandler{
+		TenantInfoCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "tenants",
+				Name:      "tenant_info_requests",
+				Help:      "Total number of tenant info requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+func (h *TenantHandler) Register(engine *gin.Engine) {
+	metrics.Collectors = append(metrics.Collectors, h.TenantInfoCounter)
+	engine.GET("/api/tenant", h.HandleTenantInfo)
+}
+
+type TenantOrgResult struct {
+	ID        string  `json:"id"`
+	Slug      string  `json:"slug"`
+	Name      string  `json:"name"`
+	AvatarURL *string `json:"avatarURL,omitempty"`
+}
+
+// TenantBrandingResult is the branding for hosted pages, with the global branding as fallback for unset fields.
+type TenantBrandingResult struct {
+	AppName      string `json:"appName"`
+	CompanyName  string `json:"companyName"`
+	LogoURL      string `json:"logoURL,omitempty"`
+	PrimaryColor string `json:"primaryColor"`
+	AccentColor  string `json:"accentColor,omitempty"`
+	SupportURL   string `json:"supportURL"`
+}
+
+type TenantInfoResult struct {
+	// Org is the tenant organization, omitted if the request was not made to a tenant host.
+	Org *TenantOrgResult `json:"org,omitempty"`
+
+	// BaseURL is the public URL of the (tenant) host.
+	BaseURL string `json:"baseURL"`
+
+	// Issuer is the issuer of the session tokens.
+	Issuer string `json:"issuer"`
+
+	// CookieDomain is the domain the UI must use for its cookies.
+	CookieDomain string `json:"cookieDomain"`
+
+	// Branding is the branding the UI should use.
+	Branding TenantBrandingResult `json:"branding"`
+}
+
+func tenantBranding(tenant *db.Org) TenantBrandingResult {
+	b := notifications.DefaultBranding()
+	accentColor := ""
+	if tenant != nil {
+		orgBranding, err := notifications.BrandingForOrg(tenant)
+		if err != nil {
+			logging.Logger.Warn("Failed to get tenant branding, using the default", zap.String("orgId", tenant.ID.String()), zap.Error(err))
+		} else {
+			b = orgBranding
+		}
+		if settings, err := orgsettings.ForOrg(tenant); err == nil {
+			accentColor = settings.Branding.AccentColor
+		}
+	}
+	return TenantBrandingResult{
+		AppName:      b.AppName,
+		CompanyName:  b.CompanyName,
+		LogoURL:      b.LogoURL,
+		PrimaryColor: b.PrimaryColor,
+		AccentColor:  accentColor,
+		SupportURL:   b.SupportURL,
+	}
+}
+
+// HandleTenantInfo godoc
+// @Summary Tenant Info
+// @Description Returns the tenant organization resolved from the Host header, along with its issuer, cookie domain and branding.
+// @Description For requests which are not made to a tenant host, the global values are returned, without an organization.
+// @Tags Tenants
+// @Produce json
+// @Success 200 {object} TenantInfoResult "Tenant info"
+// @Failure 404 {object} models.ErrorResponse "Organization not found for the tenant host"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/tenant [get]
+func (h *TenantHandler) HandleTenantInfo(c *gin.Context) {
+	h.TenantInfoCounter.WithLabelValues("received").Inc()
+
+	tenant := middlewares.GetTenant(c)
+	if tenant == nil {
+		h.TenantInfoCounter.WithLabelValues("success").Inc()
+		c.JSON(http.StatusOK, TenantInfoResult{
+			BaseURL:      config.Public.GetBaseURL(),
+			Issuer:       tokens.Issuer(""),
+			CookieDomain: config.Public.Domain,
+			Branding:     tenantBranding(nil),
+		})
+		return
+	}
+
+	h.TenantInfoCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, TenantInfoResult{
+		Org: &TenantOrgResult{
+			ID:        tenant.ID.String(),
+			Slug:      tenant.Slug,
+			Name:      tenant.Name,
+			AvatarURL: tenant.AvatarUrl,
+		},
+		BaseURL:      config.Public.GetTenantBaseURL(tenant.Slug),
+		Issuer:       tokens.Issuer(tenant.Slug),
+		CookieDomain: config.Public.GetTenantHost(tenant.Slug),
+		Branding:     tenantBranding(tenant),
+	})
+}
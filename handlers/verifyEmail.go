@@ -8,14 +8,17 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/nbrglm/nexeres/db"
 	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
 	"github.com/nbrglm/nexeres/internal/metrics"
 	"github.com/nbrglm/nexeres/internal/models"
 	"github.com/nbrglm/nexeres/internal/notifications"
 	"github.com/nbrglm/nexeres/internal/store"
 	"github.com/nbrglm/nexeres/internal/tokens"
+	"github.com/nbrglm/nexeres/internal/webhooks"
 	"github.com/nbrglm/nexeres/utils"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
@@ -144,30 +147,46 @@ func (h *VerifyEmailHandler) HandleSendVerificationEmail(c *gin.Context) {
 		return
 	}
 
-	// Commit the transaction
-	if err := tx.Commit(ctx); err != nil {
-		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to commit transaction!", http.StatusInternalServerError, err), span, log, h.SendEmailCounter, "send_verification_email")
-		return
-	}
-
-	// Send the verification email
-	if err := notifications.SendWelcomeEmail(ctx, notifications.SendWelcomeEmailParams{
+	// Queue the verification email, with the branding of the user's organization.
+	// It is queued in the transaction, so that it is only sent if the token is committed.
+	branding := brandingForUser(ctx, c, q, user.Email)
+	if err := notifications.QueueWelcomeEmail(ctx, q, notifications.QueueWelcomeEmailParams{
 		User: struct {
 			Email     string
 			FirstName *string
 			LastName  *string
+			Locale    *string
 		}{
 			Email:     user.Email,
 			FirstName: user.FirstName,
 			LastName:  user.LastName,
+			Locale:    user.Locale,
 		},
 		VerificationToken: token,
 		ExpiresAt:         newToken.ExpiresAt.Time,
+		IdempotencyKey:    tokenId.String(),
+		Branding:          &branding,
 	}); err != nil {
-		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to send verification email!", http.StatusInternalServerError, err), span, log, h.SendEmailCounter, "send_verification_email")
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to queue verification email!", http.StatusInternalServerError, err), span, log, h.SendEmailCounter, "send_verification_email")
 		return
 	}
 
+	// Commit the transaction
+	if err := tx.Commit(ctx); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to commit transaction!", http.StatusInternalServerError, err), span, log, h.SendEmailCounter, "send_verification_email")
+		return
+	}
+
+	orgID := auditOrgID(c)
+	if id, err := uuid.Parse(branding.OrgID); err == nil {
+		orgID = &id
+	}
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionVerificationSent,
+		OrgID:      orgID,
+		ResourceID: &user.ID,
+	})
+
 	// Respond with success
 	c.JSON(http.StatusOK, SendVerificationEmailResult{
 		Success: true,
@@ -187,12 +206,14 @@ type VerifyEmailTokenResult struct {
 // HandleVerifyEmailToken godoc
 // @Summary Verify Email Token
 // @Description Verifies the email using the provided token.
+// @Description For the tokens of an email change, the user's email is changed to the new address, and every session of the user is revoked.
 // @Tags Auth
 // @Accept json
 // @Produce json
 // @Param data body VerifyEmailTokenData true "Verify Email Token Data"
 // @Success 200 {object} VerifyEmailTokenResult "Verify Email Token Result"
 // @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid Input or Token"
+// @Failure 409 {object} models.ErrorResponse "Conflict - The new email is already in use"
 // @Failure 500 {object} models.ErrorResponse "Internal Server Error"
 // @Router /api/auth/verify-email/verify [post]
 func (h *VerifyEmailHandler) HandleVerifyEmailToken(c *gin.Context) {
@@ -222,6 +243,7 @@ func (h *VerifyEmailHandler) HandleVerifyEmailToken(c *gin.Context) {
 	// Fetch the verification token from the database
 	token, err := q.GetVerificationTokenByHash(ctx, hash)
 	if errors.Is(err, pgx.ErrNoRows) {
+		auditVerifyFailure(c, nil, "invalid_token")
 		utils.ProcessError(c, models.NewErrorResponse("Invalid token! Please check the token and try again.", "No verification token found with the provided token hash.", http.StatusBadRequest, nil), span, log, h.VerifyEmailCounter, "verify_email_token")
 		return
 	}
@@ -229,25 +251,109 @@ func (h *VerifyEmailHandler) HandleVerifyEmailToken(c *gin.Context) {
 		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to retrieve verification token!", http.StatusInternalServerError, err), span, log, h.VerifyEmailCounter, "verify_email_token")
 		return
 	}
-	if token.Type != string(tokens.EmailVerificationToken) {
+	if token.Type != string(tokens.EmailVerificationToken) && token.Type != string(tokens.EmailChangeToken) {
+		auditVerifyFailure(c, &token.UserID, "invalid_token_type")
 		utils.ProcessError(c, models.NewErrorResponse("Invalid token! Please check the token and try again.", "The provided token is not a valid email verification token.", http.StatusBadRequest, nil), span, log, h.VerifyEmailCounter, "verify_email_token")
 		return
 	}
 	if !token.ExpiresAt.Valid || token.ExpiresAt.Time.Before(time.Now()) {
+		auditVerifyFailure(c, &token.UserID, "token_expired")
 		utils.ProcessError(c, models.NewErrorResponse("The token has expired! Please request a new verification email.", "The provided token has expired.", http.StatusBadRequest, nil), span, log, h.VerifyEmailCounter, "verify_email_token")
 		return
 	}
 
-	// Mark the user's email as verified, since a valid token was found
-	err = q.MarkUserEmailVerified(ctx, token.UserID)
+	event := webhooks.EventUserEmailVerified
+	payload := map[string]any{
+		"userId": token.UserID,
+	}
+	auditEvent := audit.Event{
+		Action:     audit.ActionEmailVerified,
+		OrgID:      auditOrgID(c),
+		ActorID:    &token.UserID,
+		ResourceID: &token.UserID,
+	}
+	if token.Type == string(tokens.EmailChangeToken) {
+		if token.NewEmail == nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Email change token without a new email!", http.StatusInternalServerError, nil), span, log, h.VerifyEmailCounter, "verify_email_token")
+			return
+		}
+		// The new address is verified, since the token was sent to it.
+		err := q.ChangeUserEmail(ctx, db.ChangeUserEmailParams{
+			Email: *token.NewEmail,
+			ID:    token.UserID,
+		})
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+			// Another user signed up with the address after the change was requested.
+			auditVerifyFailure(c, &token.UserID, "email_taken")
+			utils.ProcessError(c, models.NewErrorResponse("The email address is already in use by another account!", "The new email address is already taken.", http.StatusConflict, nil), span, log, h.VerifyEmailCounter, "verify_email_token")
+			return
+		}
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to change the user email!", http.StatusInternalServerError, err), span, log, h.VerifyEmailCounter, "verify_email_token")
+			return
+		}
+
+		// The tokens sent to the old address, or to other new addresses, must not be used anymore.
+		for _, tokenType := range []tokens.VerificationTokenType{tokens.EmailChangeToken, tokens.EmailVerificationToken} {
+			if err := q.DeleteUserVerificationTokens(ctx, db.DeleteUserVerificationTokensParams{
+				UserID: token.UserID,
+				Type:   string(tokenType),
+			}); err != nil {
+				utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to delete verification tokens!", http.StatusInternalServerError, err), span, log, h.VerifyEmailCounter, "verify_email_token")
+				return
+			}
+		}
+
+		// The claims of the existing sessions carry the old email, so every session is revoked, and the user logs in again.
+		sessions, err := q.DeleteUserSessions(ctx, token.UserID)
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to revoke the user sessions!", http.StatusInternalServerError, err), span, log, h.VerifyEmailCounter, "verify_email_token")
+			return
+		}
+		for _, session := range sessions {
+			if err := webhooks.Enqueue(ctx, q, session.OrgID, webhooks.EventSessionRevoked, map[string]any{
+				"sessionId": session.ID,
+				"userId":    session.UserID,
+				"reason":    "email_changed",
+			}); err != nil {
+				utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to enqueue webhooks!", http.StatusInternalServerError, err), span, log, h.VerifyEmailCounter, "verify_email_token")
+				return
+			}
+		}
+
+		event = webhooks.EventUserEmailChanged
+		payload["email"] = *token.NewEmail
+		auditEvent.Action = audit.ActionEmailChanged
+		auditEvent.Metadata = map[string]any{
+			"revokedSessions": len(sessions),
+		}
+	} else {
+		// Mark the user's email as verified, since a valid token was found
+		err = q.MarkUserEmailVerified(ctx, token.UserID)
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to mark user email as verified!", http.StatusInternalServerError, err), span, log, h.VerifyEmailCounter, "verify_email_token")
+			return
+		}
+	}
+
+	// The user is not bound to an organization, so every organization of the user is notified.
+	orgs, err := q.GetUserOrgsByID(ctx, &token.UserID)
 	if err != nil {
-		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to mark user email as verified!", http.StatusInternalServerError, err), span, log, h.VerifyEmailCounter, "verify_email_token")
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to get user organizations!", http.StatusInternalServerError, err), span, log, h.VerifyEmailCounter, "verify_email_token")
 		return
 	}
+	for _, org := range orgs {
+		if err := webhooks.Enqueue(ctx, q, org.ID, event, payload); err != nil {
+			utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to enqueue webhooks!", http.StatusInternalServerError, err), span, log, h.VerifyEmailCounter, "verify_email_token")
+			return
+		}
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to commit transaction!", http.StatusInternalServerError, err), span, log, h.VerifyEmailCounter, "verify_email_token")
 		return
 	}
+	audit.RecordRequest(c, auditEvent)
 	log.Debug("Email verified successfully", zap.String("userID", token.UserID.String()))
 
 	c.JSON(http.StatusOK, VerifyEmailTokenResult{
@@ -255,3 +361,15 @@ func (h *VerifyEmailHandler) HandleVerifyEmailToken(c *gin.Context) {
 		Message: "Email verified successfully!",
 	})
 }
+
+// auditVerifyFailure records a failed email verification, with the reason in the metadata.
+func auditVerifyFailure(c *gin.Context, userID *uuid.UUID, reason string) {
+	audit.RecordRequest(c, audit.Event{
+		Action:     audit.ActionVerifyFailed,
+		OrgID:      auditOrgID(c),
+		ResourceID: userID,
+		Metadata: map[string]any{
+			"reason": reason,
+		},
+	})
+}
@@ -0,0 +1,616 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/middlewares"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/permissions"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/internal/webhooks"
+	"github.com/nbrglm/nexeres/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// defaultDeliveriesLimit is the default number of deliveries in a page.
+const defaultDeliveriesLimit = 50
+
+type WebhooksHandler struct {
+	ListWebhooksCounter     *prometheus.CounterVec
+	CreateWebhookCounter    *prometheus.CounterVec
+	UpdateWebhookCounter    *prometheus.CounterVec
+	DeleteWebhookCounter    *prometheus.CounterVec
+	ListDeliveriesCounter   *prometheus.CounterVec
+	RedeliverWebhookCounter *prometheus.CounterVec
+}
+
+func NewWebhooksHandler() *WebhooksHandler {
+	return &WebhooksHandler{
+		ListWebhooksCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "webhook_list_requests",
+				Help:      "Total number of organization webhook list requests",
+			},
+			[]string{"status"},
+		),
+		CreateWebhookCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "webhook_create_requests",
+				Help:      "Total number of organization webhook create requests",
+			},
+			[]string{"status"},
+		),
+		UpdateWebhookCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "webhook_update_requests",
+				Help:      "Total number of organization webhook update requests",
+			},
+			[]string{"status"},
+		),
+		DeleteWebhookCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "webhook_delete_requests",
+				Help:      "Total number of organization webhook delete requests",
+			},
+			[]string{"status"},
+		),
+		ListDeliveriesCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "webhook_delivery_list_requests",
+				Help:      "Total number of organization webhook delivery list requests",
+			},
+			[]string{"status"},
+		),
+		RedeliverWebhookCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "nexeres",
+				Subsystem: "orgs",
+				Name:      "webhook_redeliver_requests",
+				Help:      "Total number of organization webhook redelivery requests",
+			},
+			[]string{"status"},
+		),
+	}
+}
+
+// Register registers the webhook routes, if webhooks are enabled in the config.
+func (h *WebhooksHandler) Register(engine *gin.Engine) {
+	if !config.Webhooks.Enable {
+		return
+	}
+	metrics.Collectors = append(metrics.Collectors, h.ListWebhooksCounter, h.CreateWebhookCounter, h.UpdateWebhookCounter, h.DeleteWebhookCounter, h.ListDeliveriesCounter, h.RedeliverWebhookCounter)
+
+	requireSession := middlewares.RequireAuth(middlewares.AuthModeSession)
+	engine.GET("/api/orgs/:orgId/webhooks", requireSession, middlewares.RequirePermission(permissions.WebhooksRead), h.HandleListWebhooks)
+	engine.POST("/api/orgs/:orgId/webhooks", requireSession, middlewares.RequirePermission(permissions.WebhooksManage), h.HandleCreateWebhook)
+	engine.PUT("/api/orgs/:orgId/webhooks/:webhookId", requireSession, middlewares.RequirePermission(permissions.WebhooksManage), h.HandleUpdateWebhook)
+	engine.DELETE("/api/orgs/:orgId/webhooks/:webhookId", requireSession, middlewares.RequirePermission(permissions.WebhooksManage), h.HandleDeleteWebhook)
+	engine.GET("/api/orgs/:orgId/webhooks/:webhookId/deliveries", requireSession, middlewares.RequirePermission(permissions.WebhooksRead), h.HandleListDeliveries)
+	engine.POST("/api/orgs/:orgId/webhooks/:webhookId/deliveries/:deliveryId/redeliver", requireSession, middlewares.RequirePermission(permissions.WebhooksManage), h.HandleRedeliver)
+}
+
+type WebhookEndpointResult struct {
+	ID          string  `json:"id"`
+	URL         string  `json:"url"`
+	Description *string `json:"description,omitempty"`
+	// EventTypes the endpoint receives, every event type if empty.
+	EventTypes []string  `json:"eventTypes"`
+	Enabled    bool      `json:"enabled"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+type CreateWebhookEndpointResult struct {
+	WebhookEndpointResult
+
+	// Secret signs the deliveries. It is only returned when the endpoint is created.
+	Secret string `json:"secret"`
+}
+
+type ListWebhookEndpointsResult struct {
+	Webhooks []WebhookEndpointResult `json:"webhooks"`
+}
+
+type WebhookEndpointData struct {
+	// URL receiving the deliveries, which must use https.
+	URL         string  `json:"url" binding:"required"`
+	Description *string `json:"description,omitempty"`
+	// EventTypes to deliver, eg. "user.created". Every event type if empty.
+	EventTypes []string `json:"eventTypes,omitempty"`
+	// Enabled defaults to true.
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+type WebhookDeliveryResult struct {
+	ID             string          `json:"id"`
+	EventID        string          `json:"eventId"`
+	EventType      string          `json:"eventType"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"nextAttemptAt,omitempty"`
+	LastError      *string         `json:"lastError,omitempty"`
+	LastStatusCode *int32          `json:"lastStatusCode,omitempty"`
+	DeliveredAt    *time.Time      `json:"deliveredAt,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+}
+
+type ListWebhookDeliveriesParams struct {
+	// Status of the deliveries: "pending", "delivered" or "dead" (the dead letters).
+	Status string `form:"status" binding:"omitempty,oneof=pending delivered dead"`
+	// Before is the createdAt of the last delivery of the previous page, RFC 3339.
+	Before string `form:"before"`
+	// Limit is the maximum number of deliveries, default 50, max 200.
+	Limit int `form:"limit" binding:"omitempty,min=1,max=200"`
+}
+
+type ListWebhookDeliveriesResult struct {
+	Deliveries []WebhookDeliveryResult `json:"deliveries"`
+}
+
+func newWebhookEndpointResult(endpoint db.WebhookEndpoint) WebhookEndpointResult {
+	return WebhookEndpointResult{
+		ID:          endpoint.ID.String(),
+		URL:         endpoint.Url,
+		Description: endpoint.Description,
+		EventTypes:  endpoint.EventTypes,
+		Enabled:     endpoint.Enabled,
+		CreatedAt:   endpoint.CreatedAt.Time,
+		UpdatedAt:   endpoint.UpdatedAt.Time,
+	}
+}
+
+func newWebhookDeliveryResult(delivery db.WebhookDelivery) WebhookDeliveryResult {
+	result := WebhookDeliveryResult{
+		ID:             delivery.ID.String(),
+		EventID:        delivery.EventID.String(),
+		EventType:      delivery.EventType,
+		Payload:        delivery.Payload,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		LastError:      delivery.LastError,
+		LastStatusCode: delivery.LastStatusCode,
+		CreatedAt:      delivery.CreatedAt.Time,
+	}
+	if delivery.Status == webhooks.StatusPending {
+		result.NextAttemptAt = &delivery.NextAttemptAt.Time
+	}
+	if delivery.DeliveredAt.Valid {
+		result.DeliveredAt = &delivery.DeliveredAt.Time
+	}
+	return result
+}
+
+// validateWebhookEndpointData validates the endpoint data, and returns the normalized event types.
+func validateWebhookEndpointData(data *WebhookEndpointData) ([]string, *models.ErrorResponse) {
+	data.URL = strings.TrimSpace(data.URL)
+	if err := webhooks.ValidateURL(data.URL); err != nil {
+		return nil, models.NewErrorResponse("Invalid URL: "+err.Error(), "Invalid webhook URL", http.StatusBadRequest, nil)
+	}
+	eventTypes, err := webhooks.NormalizeEventTypes(data.EventTypes)
+	if err != nil {
+		return nil, models.NewErrorResponse(err.Error(), "Invalid webhook event types", http.StatusBadRequest, nil)
+	}
+	return eventTypes, nil
+}
+
+// parseWebhookID parses the `:webhookId` route parameter.
+func parseWebhookID(c *gin.Context) (uuid.UUID, *models.ErrorResponse) {
+	id, err := uuid.Parse(c.Param("webhookId"))
+	if err != nil {
+		return uuid.Nil, models.NewErrorResponse("Invalid webhook ID!", "Failed to parse webhook ID", http.StatusBadRequest, nil)
+	}
+	return id, nil
+}
+
+// HandleListWebhooks godoc
+// @Summary List Organization Webhooks
+// @Description Lists the webhook endpoints of an organization. The secrets are not returned.
+// @Tags Orgs
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Success 200 {object} ListWebhookEndpointsResult "Webhook endpoints"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'webhooks:read'"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/webhooks [get]
+func (h *WebhooksHandler) HandleListWebhooks(c *gin.Context) {
+	h.ListWebhooksCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "list_org_webhooks")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.ListWebhooksCounter, "list_org_webhooks")
+		return
+	}
+
+	endpoints, err := store.Querier.ListWebhookEndpoints(ctx, orgId)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to list webhook endpoints!", http.StatusInternalServerError, err), span, log, h.ListWebhooksCounter, "list_org_webhooks")
+		return
+	}
+
+	result := ListWebhookEndpointsResult{
+		Webhooks: make([]WebhookEndpointResult, 0, len(endpoints)),
+	}
+	for _, endpoint := range endpoints {
+		result.Webhooks = append(result.Webhooks, newWebhookEndpointResult(endpoint))
+	}
+
+	h.ListWebhooksCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, result)
+}
+
+// HandleCreateWebhook godoc
+// @Summary Create Organization Webhook
+// @Description Registers a webhook endpoint for the organization's events.
+// @Description The returned secret signs the deliveries, and is not returned again.
+// @Tags Orgs
+// @Accept json
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Param data body WebhookEndpointData true "Webhook endpoint data"
+// @Success 201 {object} CreateWebhookEndpointResult "Created webhook endpoint"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid URL or event types"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'webhooks:manage'"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/webhooks [post]
+func (h *WebhooksHandler) HandleCreateWebhook(c *gin.Context) {
+	h.CreateWebhookCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "create_org_webhook")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.CreateWebhookCounter, "create_org_webhook")
+		return
+	}
+
+	var data WebhookEndpointData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.CreateWebhookCounter, "create_org_webhook")
+		return
+	}
+	eventTypes, errResp := validateWebhookEndpointData(&data)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.CreateWebhookCounter, "create_org_webhook")
+		return
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to generate webhook ID!", http.StatusInternalServerError, err), span, log, h.CreateWebhookCounter, "create_org_webhook")
+		return
+	}
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to generate webhook secret!", http.StatusInternalServerError, err), span, log, h.CreateWebhookCounter, "create_org_webhook")
+		return
+	}
+
+	endpoint, err := store.Querier.CreateWebhookEndpoint(ctx, db.CreateWebhookEndpointParams{
+		ID:          id,
+		OrgID:       orgId,
+		Url:         data.URL,
+		Description: data.Description,
+		EventTypes:  eventTypes,
+		Secret:      secret,
+		Enabled:     data.Enabled == nil || *data.Enabled,
+	})
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to create webhook endpoint!", http.StatusInternalServerError, err), span, log, h.CreateWebhookCounter, "create_org_webhook")
+		return
+	}
+
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionWebhookCreated,
+		OrgID:      &orgId,
+		ResourceID: &endpoint.ID,
+		Metadata: map[string]any{
+			"url":        endpoint.Url,
+			"eventTypes": endpoint.EventTypes,
+			"enabled":    endpoint.Enabled,
+		},
+	})
+	log.Debug("Webhook endpoint created", zap.String("orgId", orgId.String()), zap.String("webhookId", endpoint.ID.String()))
+	h.CreateWebhookCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusCreated, CreateWebhookEndpointResult{
+		WebhookEndpointResult: newWebhookEndpointResult(endpoint),
+		Secret:                endpoint.Secret,
+	})
+}
+
+// HandleUpdateWebhook godoc
+// @Summary Update Organization Webhook
+// @Description Replaces the URL, description, event types and enabled state of a webhook endpoint. The secret is kept.
+// @Tags Orgs
+// @Accept json
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Param webhookId path string true "Webhook endpoint ID"
+// @Param data body WebhookEndpointData true "Webhook endpoint data"
+// @Success 200 {object} WebhookEndpointResult "Updated webhook endpoint"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid URL or event types"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'webhooks:manage'"
+// @Failure 404 {object} models.ErrorResponse "Webhook endpoint not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/webhooks/{webhookId} [put]
+func (h *WebhooksHandler) HandleUpdateWebhook(c *gin.Context) {
+	h.UpdateWebhookCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "update_org_webhook")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.UpdateWebhookCounter, "update_org_webhook")
+		return
+	}
+	webhookId, errResp := parseWebhookID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.UpdateWebhookCounter, "update_org_webhook")
+		return
+	}
+
+	var data WebhookEndpointData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid input data", "Bad Request", http.StatusBadRequest, nil), span, log, h.UpdateWebhookCounter, "update_org_webhook")
+		return
+	}
+	eventTypes, errResp := validateWebhookEndpointData(&data)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.UpdateWebhookCounter, "update_org_webhook")
+		return
+	}
+
+	endpoint, err := store.Querier.UpdateWebhookEndpoint(ctx, db.UpdateWebhookEndpointParams{
+		Url:         data.URL,
+		Description: data.Description,
+		EventTypes:  eventTypes,
+		Enabled:     data.Enabled == nil || *data.Enabled,
+		ID:          webhookId,
+		OrgID:       orgId,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("Webhook not found!", "No webhook endpoint found with the given ID", http.StatusNotFound, nil), span, log, h.UpdateWebhookCounter, "update_org_webhook")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to update webhook endpoint!", http.StatusInternalServerError, err), span, log, h.UpdateWebhookCounter, "update_org_webhook")
+		return
+	}
+
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionWebhookUpdated,
+		OrgID:      &orgId,
+		ResourceID: &endpoint.ID,
+		Metadata: map[string]any{
+			"url":        endpoint.Url,
+			"eventTypes": endpoint.EventTypes,
+			"enabled":    endpoint.Enabled,
+		},
+	})
+	log.Debug("Webhook endpoint updated", zap.String("orgId", orgId.String()), zap.String("webhookId", endpoint.ID.String()))
+	h.UpdateWebhookCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, newWebhookEndpointResult(endpoint))
+}
+
+// HandleDeleteWebhook godoc
+// @Summary Delete Organization Webhook
+// @Description Deletes a webhook endpoint, with its pending and past deliveries.
+// @Tags Orgs
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Param webhookId path string true "Webhook endpoint ID"
+// @Success 204 "Webhook endpoint deleted"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'webhooks:manage'"
+// @Failure 404 {object} models.ErrorResponse "Webhook endpoint not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/webhooks/{webhookId} [delete]
+func (h *WebhooksHandler) HandleDeleteWebhook(c *gin.Context) {
+	h.DeleteWebhookCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "delete_org_webhook")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.DeleteWebhookCounter, "delete_org_webhook")
+		return
+	}
+	webhookId, errResp := parseWebhookID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.DeleteWebhookCounter, "delete_org_webhook")
+		return
+	}
+
+	deleted, err := store.Querier.DeleteWebhookEndpoint(ctx, db.DeleteWebhookEndpointParams{
+		ID:    webhookId,
+		OrgID: orgId,
+	})
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to delete webhook endpoint!", http.StatusInternalServerError, err), span, log, h.DeleteWebhookCounter, "delete_org_webhook")
+		return
+	}
+	if deleted == 0 {
+		utils.ProcessError(c, models.NewErrorResponse("Webhook not found!", "No webhook endpoint found with the given ID", http.StatusNotFound, nil), span, log, h.DeleteWebhookCounter, "delete_org_webhook")
+		return
+	}
+
+	recordSessionAudit(c, audit.Event{
+		Action:     audit.ActionWebhookDeleted,
+		OrgID:      &orgId,
+		ResourceID: &webhookId,
+	})
+	log.Debug("Webhook endpoint deleted", zap.String("orgId", orgId.String()), zap.String("webhookId", webhookId.String()))
+	h.DeleteWebhookCounter.WithLabelValues("success").Inc()
+	c.Status(http.StatusNoContent)
+}
+
+// HandleListDeliveries godoc
+// @Summary List Webhook Deliveries
+// @Description Lists the deliveries of a webhook endpoint, newest first. Filter by status "dead" to list the dead letters.
+// @Tags Orgs
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Param webhookId path string true "Webhook endpoint ID"
+// @Param status query string false "Status of the deliveries" Enums(pending, delivered, dead)
+// @Param before query string false "createdAt of the last delivery of the previous page, RFC 3339"
+// @Param limit query int false "Maximum number of deliveries, default 50, max 200"
+// @Success 200 {object} ListWebhookDeliveriesResult "Webhook deliveries"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid filter"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'webhooks:read'"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/webhooks/{webhookId}/deliveries [get]
+func (h *WebhooksHandler) HandleListDeliveries(c *gin.Context) {
+	h.ListDeliveriesCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "list_webhook_deliveries")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.ListDeliveriesCounter, "list_webhook_deliveries")
+		return
+	}
+	webhookId, errResp := parseWebhookID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.ListDeliveriesCounter, "list_webhook_deliveries")
+		return
+	}
+
+	var params ListWebhookDeliveriesParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid query parameters!", "Failed to bind query parameters", http.StatusBadRequest, nil), span, log, h.ListDeliveriesCounter, "list_webhook_deliveries")
+		return
+	}
+
+	query := db.ListWebhookDeliveriesParams{
+		EndpointID: webhookId,
+		OrgID:      orgId,
+		Limit:      defaultDeliveriesLimit,
+	}
+	if params.Status != "" {
+		query.Status = &params.Status
+	}
+	if params.Before != "" {
+		before, err := time.Parse(time.RFC3339Nano, params.Before)
+		if err != nil {
+			utils.ProcessError(c, models.NewErrorResponse("Invalid 'before' time, use RFC 3339!", "Failed to parse the before time", http.StatusBadRequest, nil), span, log, h.ListDeliveriesCounter, "list_webhook_deliveries")
+			return
+		}
+		query.Before = pgtype.Timestamptz{Time: before, Valid: true}
+	}
+	if params.Limit != 0 {
+		query.Limit = int32(params.Limit)
+	}
+
+	deliveries, err := store.Querier.ListWebhookDeliveries(ctx, query)
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to list webhook deliveries!", http.StatusInternalServerError, err), span, log, h.ListDeliveriesCounter, "list_webhook_deliveries")
+		return
+	}
+
+	result := ListWebhookDeliveriesResult{
+		Deliveries: make([]WebhookDeliveryResult, 0, len(deliveries)),
+	}
+	for _, delivery := range deliveries {
+		result.Deliveries = append(result.Deliveries, newWebhookDeliveryResult(delivery))
+	}
+
+	h.ListDeliveriesCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusOK, result)
+}
+
+// HandleRedeliver godoc
+// @Summary Redeliver Webhook Delivery
+// @Description Schedules a delivery to be sent again immediately, with a new set of attempts, eg. a dead letter after the endpoint is fixed.
+// @Tags Orgs
+// @Produce json
+// @Param X-NEXERES-Session-Token header string true "Session token"
+// @Param orgId path string true "Organization ID"
+// @Param webhookId path string true "Webhook endpoint ID"
+// @Param deliveryId path string true "Delivery ID"
+// @Success 202 {object} WebhookDeliveryResult "Scheduled delivery"
+// @Failure 400 {object} models.ErrorResponse "Bad Request - Invalid delivery ID"
+// @Failure 401 {object} models.ErrorResponse "Unauthorized"
+// @Failure 403 {object} models.ErrorResponse "Forbidden - Missing permission 'webhooks:manage'"
+// @Failure 404 {object} models.ErrorResponse "Delivery not found"
+// @Failure 500 {object} models.ErrorResponse "Internal Server Error"
+// @Router /api/orgs/{orgId}/webhooks/{webhookId}/deliveries/{deliveryId}/redeliver [post]
+func (h *WebhooksHandler) HandleRedeliver(c *gin.Context) {
+	h.RedeliverWebhookCounter.WithLabelValues("received").Inc()
+
+	ctx, log, span := internal.WithContext(c.Request.Context(), "redeliver_webhook")
+	defer span.End() // Ensure the span is ended to avoid memory leaks
+
+	orgId, errResp := parseOrgID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.RedeliverWebhookCounter, "redeliver_webhook")
+		return
+	}
+	webhookId, errResp := parseWebhookID(c)
+	if errResp != nil {
+		utils.ProcessError(c, errResp, span, log, h.RedeliverWebhookCounter, "redeliver_webhook")
+		return
+	}
+	deliveryId, err := uuid.Parse(c.Param("deliveryId"))
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse("Invalid delivery ID!", "Failed to parse delivery ID", http.StatusBadRequest, nil), span, log, h.RedeliverWebhookCounter, "redeliver_webhook")
+		return
+	}
+
+	delivery, err := store.Querier.RedeliverWebhookDelivery(ctx, db.RedeliverWebhookDeliveryParams{
+		ID:         deliveryId,
+		EndpointID: webhookId,
+		OrgID:      orgId,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ProcessError(c, models.NewErrorResponse("Delivery not found!", "No webhook delivery found with the given ID", http.StatusNotFound, nil), span, log, h.RedeliverWebhookCounter, "redeliver_webhook")
+		return
+	}
+	if err != nil {
+		utils.ProcessError(c, models.NewErrorResponse(models.GenericErrorMessage, "Failed to schedule webhook redelivery!", http.StatusInternalServerError, err), span, log, h.RedeliverWebhookCounter, "redeliver_webhook")
+		return
+	}
+
+	log.Debug("Webhook redelivery scheduled", zap.String("orgId", orgId.String()), zap.String("deliveryId", deliveryId.String()))
+	h.RedeliverWebhookCounter.WithLabelValues("success").Inc()
+	c.JSON(http.StatusAccepted, newWebhookDeliveryResult(delivery))
+}
@@ -0,0 +1,313 @@
+// Package audit records security-relevant actions, eg. logins, signups and role changes, to the audit_logs table.
+//
+// Events are queued in memory and written by a single background worker in batches, so that request handlers
+// are not slowed down by the writes. The queue is bounded: when it is full, Record waits for up to the configured
+// enqueue timeout and then drops the event. The queue length, wait times and dropped events are exported as metrics.
+//
+// The entries are hash chained per organization and periodically signed, see chain.go, so that tampering with
+// them is detected by `nexeres audit verify`.
+//
+// Audit logs are enabled with `security.auditLogs.enable` in the config file, otherwise Record is a no-op.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// writeAttempts is the number of times a batch is written before its events are dropped.
+const writeAttempts = 3
+
+// writeTimeout is the timeout of a single batch write.
+const writeTimeout = 10 * time.Second
+
+// checkpointTimeout is the timeout of signing the checkpoints of all chains.
+const checkpointTimeout = time.Minute
+
+var (
+	queue chan Event
+	stop  chan struct{}
+	done  chan struct{}
+
+	eventsCounter    *prometheus.CounterVec
+	enqueueWaitTime  prometheus.Histogram
+	batchSizeHist    prometheus.Histogram
+	queueLengthGauge prometheus.GaugeFunc
+)
+
+// InitAudit registers the audit metrics and, if audit logs are enabled, starts the background writer and the sinks.
+//
+// It must be called before metrics.InitMetrics. The writer only accesses the database once events are recorded,
+// so it can be started before the database connection pool is initialized.
+func InitAudit() error {
+	eventsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "nexeres",
+			Subsystem: "audit",
+			Name:      "events",
+			Help:      "Total number of audit events, by status: enqueued, dropped (queue full), invalid, written, failed (write failed)",
+		},
+		[]string{"status"},
+	)
+	enqueueWaitTime = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "nexeres",
+			Subsystem: "audit",
+			Name:      "enqueue_wait_seconds",
+			Help:      "Time requests waited for space in the full audit queue",
+			Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1},
+		},
+	)
+	batchSizeHist = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "nexeres",
+			Subsystem: "audit",
+			Name:      "batch_size",
+			Help:      "Number of audit events written per batch",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 11),
+		},
+	)
+	queueLengthGauge = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "nexeres",
+			Subsystem: "audit",
+			Name:      "queue_length",
+			Help:      "Number of audit events waiting to be written",
+		},
+		func() float64 { return float64(len(queue)) },
+	)
+	initSinkMetrics()
+	metrics.Collectors = append(metrics.Collectors, eventsCounter, enqueueWaitTime, batchSizeHist, queueLengthGauge, sinkMessagesCounter, sinkBufferGauge)
+
+	if !config.Security.AuditLogs.Enable {
+		return nil
+	}
+
+	if err := initSinks(); err != nil {
+		return err
+	}
+
+	queue = make(chan Event, config.Security.AuditLogs.QueueSize)
+	stop = make(chan struct{})
+	done = make(chan struct{})
+	go run()
+	return nil
+}
+
+// Enabled reports whether audit logs are enabled.
+func Enabled() bool {
+	return queue != nil
+}
+
+// Record queues the event to be written.
+//
+// If the queue is full, it waits for up to the configured enqueue timeout, and then drops the event.
+func Record(event Event) {
+	if queue == nil {
+		return
+	}
+
+	if _, ok := Catalogue[event.Action]; !ok {
+		eventsCounter.WithLabelValues("invalid").Inc()
+		logging.Logger.Error("Unknown audit action, the event is not recorded", zap.String("action", string(event.Action)))
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	select {
+	case queue <- event:
+		eventsCounter.WithLabelValues("enqueued").Inc()
+		return
+	default:
+	}
+
+	// The queue is full, apply backpressure to the request for up to the timeout.
+	timeout := config.Security.AuditLogs.EnqueueTimeout
+	if timeout < 0 {
+		eventsCounter.WithLabelValues("dropped").Inc()
+		logging.Logger.Warn("Audit queue is full, dropping event", zap.String("action", string(event.Action)))
+		return
+	}
+
+	start := time.Now()
+	timer := time.NewTimer(time.Duration(timeout) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case queue <- event:
+		enqueueWaitTime.Observe(time.Since(start).Seconds())
+		eventsCounter.WithLabelValues("enqueued").Inc()
+	case <-timer.C:
+		enqueueWaitTime.Observe(time.Since(start).Seconds())
+		eventsCounter.WithLabelValues("dropped").Inc()
+		logging.Logger.Warn("Audit queue is full, dropping event", zap.String("action", string(event.Action)))
+	}
+}
+
+// RecordRequest fills in the IP address and user agent of the request, if not set, and records the event.
+func RecordRequest(c *gin.Context, event Event) {
+	if queue == nil {
+		return
+	}
+	if event.IPAddress == nil {
+		if ip, err := netip.ParseAddr(c.ClientIP()); err == nil {
+			event.IPAddress = &ip
+		}
+	}
+	if event.UserAgent == nil {
+		if userAgent := c.Request.UserAgent(); userAgent != "" {
+			event.UserAgent = &userAgent
+		}
+	}
+	Record(event)
+}
+
+// Shutdown stops the background writer and the sinks, after writing the queued events.
+func Shutdown(ctx context.Context) error {
+	if queue == nil {
+		return nil
+	}
+	close(stop)
+
+	stopped := make(chan struct{})
+	go func() {
+		<-done
+		shutdownSinks()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run writes the queued events in batches, and signs checkpoints of the chains, until Shutdown is called.
+func run() {
+	defer close(done)
+
+	batchSize := config.Security.AuditLogs.BatchSize
+	ticker := time.NewTicker(time.Duration(config.Security.AuditLogs.FlushInterval) * time.Millisecond)
+	defer ticker.Stop()
+	checkpointTicker := time.NewTicker(time.Duration(config.Security.AuditLogs.CheckpointInterval) * time.Second)
+	defer checkpointTicker.Stop()
+
+	batch := make([]Event, 0, batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			write(batch)
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case event := <-queue:
+			batch = append(batch, event)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-checkpointTicker.C:
+			flush()
+			checkpoint()
+		case <-stop:
+			// Drain the queue before exiting.
+			for {
+				select {
+				case event := <-queue:
+					batch = append(batch, event)
+					if len(batch) >= batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// write writes the batch to the database, retrying failed writes, and queues it to the sinks.
+func write(events []Event) {
+	logs := make([]db.AuditLog, 0, len(events))
+	for _, event := range events {
+		id, err := uuid.NewV7()
+		if err != nil {
+			id = uuid.New()
+		}
+
+		var metadata []byte
+		if len(event.Metadata) > 0 {
+			metadata, err = json.Marshal(event.Metadata)
+			if err != nil {
+				logging.Logger.Error("Failed to marshal audit event metadata", zap.String("action", string(event.Action)), zap.Error(err))
+				metadata = nil
+			}
+		}
+
+		logs = append(logs, db.AuditLog{
+			ID:           id,
+			OrgID:        event.OrgID,
+			UserID:       event.ActorID,
+			Action:       string(event.Action),
+			ResourceType: string(Catalogue[event.Action]),
+			ResourceID:   event.ResourceID,
+			IpAddress:    event.IPAddress,
+			UserAgent:    event.UserAgent,
+			Metadata:     metadata,
+			// Postgres stores microseconds, truncate so that the hash matches the stored entry.
+			CreatedAt: pgtype.Timestamptz{Time: event.Time.UTC().Truncate(time.Microsecond), Valid: true},
+			ChainID:   chainID(event.OrgID),
+		})
+	}
+	batchSizeHist.Observe(float64(len(logs)))
+
+	var chained []db.AuditLog
+	var err error
+	for attempt := range writeAttempts {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 200 * time.Millisecond)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+		chained, err = appendToChains(ctx, logs)
+		cancel()
+		if err == nil {
+			eventsCounter.WithLabelValues("written").Add(float64(len(logs)))
+			dispatch(chained)
+			return
+		}
+		logging.Logger.Warn("Failed to write audit events, retrying", zap.Int("attempt", attempt+1), zap.Int("events", len(logs)), zap.Error(err))
+	}
+
+	eventsCounter.WithLabelValues("failed").Add(float64(len(logs)))
+	logging.Logger.Error("Failed to write audit events to the database", zap.Int("events", len(logs)), zap.Error(err))
+
+	// The sinks still receive the events, without their position in the chain.
+	dispatch(logs)
+}
+
+// checkpoint signs the chains with new entries, logging failures, which are retried on the next interval.
+func checkpoint() {
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointTimeout)
+	defer cancel()
+	if err := Checkpoint(ctx); err != nil {
+		logging.Logger.Error("Failed to checkpoint audit logs", zap.Error(err))
+	}
+}
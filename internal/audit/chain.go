@@ -0,0 +1,523 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/internal/tokens"
+)
+
+// The audit logs are tamper-evident: the entries of each organization form a chain, where every entry stores the
+// SHA-256 hash of its content and of the previous entry's hash. Events outside of organizations are chained in a
+// global chain, identified by the nil UUID. The head of each chain, its last seq and hash, is kept in
+// audit_chain_heads, and locked while entries are appended, so that multiple instances can write concurrently.
+//
+// Modifying an entry breaks its hash, and deleting one leaves a gap in the seqs. The heads are periodically signed
+// with the token signing key as checkpoints, so that rewriting the chain from a modified entry onwards, or deleting
+// its latest entries, is detected up to the last checkpoint.
+//
+// Entries deleted for retention are replaced with a signed tombstone, holding the seq and hash of the last deleted
+// entry, from which the chain is verified onwards.
+//
+// After the token signing key is rotated, the checkpoints signed with the previous key are verified with the retired
+// public keys of the config, by the key ID stored with them.
+
+// Kinds of audit checkpoints.
+const (
+	CheckpointKindCheckpoint = "checkpoint"
+	CheckpointKindTombstone  = "tombstone"
+)
+
+// GlobalChainID is the chain of the events outside of organizations, eg. admin logins.
+var GlobalChainID = uuid.Nil
+
+// genesisHash is the previous hash of the first entry of every chain.
+var genesisHash = make([]byte, sha256.Size)
+
+// verifyPageSize is the number of entries fetched at a time while verifying a chain.
+const verifyPageSize = 1000
+
+// chainID returns the chain of the organization's events.
+func chainID(orgID *uuid.UUID) uuid.UUID {
+	if orgID == nil {
+		return GlobalChainID
+	}
+	return *orgID
+}
+
+// writeField writes the length-prefixed field, so that the boundaries between fields are unambiguous.
+func writeField(h hash.Hash, b []byte) {
+	h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(b))))
+	h.Write(b)
+}
+
+// writeOptional writes a marker of whether the field is set, followed by the field, so that a nil field
+// and an empty one hash differently.
+func writeOptional(h hash.Hash, b []byte, set bool) {
+	if !set {
+		h.Write([]byte{0})
+		return
+	}
+	h.Write([]byte{1})
+	writeField(h, b)
+}
+
+func optionalUUID(h hash.Hash, id *uuid.UUID) {
+	if id == nil {
+		writeOptional(h, nil, false)
+		return
+	}
+	writeOptional(h, id[:], true)
+}
+
+// canonicalMetadata returns the metadata in a canonical form, since Postgres returns JSONB in its own format,
+// eg. with sorted keys and different whitespace, which differs from the JSON that was written.
+func canonicalMetadata(metadata []byte) ([]byte, error) {
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	var v any
+	if err := json.Unmarshal(metadata, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// entryHash returns the hash of the entry, chained to the previous entry's hash.
+//
+// The log's created_at must be truncated to microseconds, the precision stored by Postgres.
+func entryHash(prevHash []byte, log *db.AuditLog) ([]byte, error) {
+	if log.Seq == nil {
+		return nil, errors.New("entry is not chained")
+	}
+	metadata, err := canonicalMetadata(log.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+
+	h := sha256.New()
+	writeField(h, prevHash)
+	writeField(h, log.ID[:])
+	writeField(h, log.ChainID[:])
+	writeField(h, binary.BigEndian.AppendUint64(nil, uint64(*log.Seq)))
+	optionalUUID(h, log.OrgID)
+	optionalUUID(h, log.UserID)
+	writeField(h, []byte(log.Action))
+	writeField(h, []byte(log.ResourceType))
+	optionalUUID(h, log.ResourceID)
+	if log.IpAddress != nil {
+		writeOptional(h, []byte(log.IpAddress.String()), true)
+	} else {
+		writeOptional(h, nil, false)
+	}
+	if log.UserAgent != nil {
+		writeOptional(h, []byte(*log.UserAgent), true)
+	} else {
+		writeOptional(h, nil, false)
+	}
+	writeOptional(h, metadata, metadata != nil)
+	writeField(h, binary.BigEndian.AppendUint64(nil, uint64(log.CreatedAt.Time.UnixMicro())))
+	return h.Sum(nil), nil
+}
+
+// appendToChains links the entries to the heads of their chains and writes them, in a single transaction,
+// and returns the chained entries.
+//
+// The heads are locked in the order of their chain IDs, so that concurrent writers do not deadlock.
+func appendToChains(ctx context.Context, logs []db.AuditLog) ([]db.AuditLog, error) {
+	tx, err := store.PgPool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := store.Querier.WithTx(tx)
+
+	var chainIDs []uuid.UUID
+	for _, log := range logs {
+		if !slices.Contains(chainIDs, log.ChainID) {
+			chainIDs = append(chainIDs, log.ChainID)
+		}
+	}
+	slices.SortFunc(chainIDs, func(a, b uuid.UUID) int { return bytes.Compare(a[:], b[:]) })
+
+	heads := make(map[uuid.UUID]*db.AuditChainHead, len(chainIDs))
+	for _, id := range chainIDs {
+		if err := q.EnsureAuditChainHead(ctx, db.EnsureAuditChainHeadParams{ChainID: id, Hash: genesisHash}); err != nil {
+			return nil, err
+		}
+		head, err := q.LockAuditChainHead(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		heads[id] = &head
+	}
+
+	chained, err := chainEntries(heads, logs)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]db.CreateAuditLogsParams, 0, len(chained))
+	for _, log := range chained {
+		// The insert parameters have the same fields as the row.
+		rows = append(rows, db.CreateAuditLogsParams(log))
+	}
+
+	if _, err := q.CreateAuditLogs(ctx, rows); err != nil {
+		return nil, err
+	}
+	for _, id := range chainIDs {
+		if err := q.UpdateAuditChainHead(ctx, db.UpdateAuditChainHeadParams{
+			Seq:     heads[id].Seq,
+			Hash:    heads[id].Hash,
+			ChainID: id,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return chained, nil
+}
+
+// chainEntries links the entries, in order, to the heads of their chains, and advances the heads.
+func chainEntries(heads map[uuid.UUID]*db.AuditChainHead, logs []db.AuditLog) ([]db.AuditLog, error) {
+	chained := make([]db.AuditLog, 0, len(logs))
+	for _, log := range logs {
+		head, ok := heads[log.ChainID]
+		if !ok {
+			return nil, fmt.Errorf("chain %s is not locked", log.ChainID)
+		}
+		seq := head.Seq + 1
+		log.Seq = &seq
+		log.PrevHash = head.Hash
+		hash, err := entryHash(head.Hash, &log)
+		if err != nil {
+			return nil, err
+		}
+		log.Hash = hash
+		head.Seq, head.Hash = seq, hash
+		chained = append(chained, log)
+	}
+	return chained, nil
+}
+
+// checkpointPayload returns the signed content of the checkpoint.
+func checkpointPayload(cp *db.AuditCheckpoint) []byte {
+	return fmt.Appendf(nil, "nexeres-audit-checkpoint\n%s\n%s\n%d\n%x\n%d\n%d",
+		cp.Kind, cp.ChainID, cp.Seq, cp.Hash, cp.DeletedCount, cp.CreatedAt.Time.UnixMicro())
+}
+
+// createCheckpoint signs and writes a checkpoint of the chain at seq.
+func createCheckpoint(ctx context.Context, q *db.Queries, chainID uuid.UUID, kind string, seq int64, hash []byte, deletedCount int64) error {
+	cp, err := newCheckpoint(chainID, kind, seq, hash, deletedCount)
+	if err != nil {
+		return err
+	}
+	// The insert parameters have the same fields as the row.
+	_, err = q.CreateAuditCheckpoint(ctx, db.CreateAuditCheckpointParams(cp))
+	return err
+}
+
+// newCheckpoint returns a checkpoint of the chain at seq, signed with the current token signing key.
+func newCheckpoint(chainID uuid.UUID, kind string, seq int64, hash []byte, deletedCount int64) (db.AuditCheckpoint, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		id = uuid.New()
+	}
+	cp := db.AuditCheckpoint{
+		ID:           id,
+		ChainID:      chainID,
+		Kind:         kind,
+		Seq:          seq,
+		Hash:         hash,
+		DeletedCount: deletedCount,
+		KeyID:        tokens.KeyID(),
+		CreatedAt:    pgtype.Timestamptz{Time: time.Now().UTC().Truncate(time.Microsecond), Valid: true},
+	}
+	cp.Signature, err = tokens.SignData(checkpointPayload(&cp))
+	if err != nil {
+		return cp, fmt.Errorf("failed to sign checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+// Checkpoint signs the heads of the chains with new entries since their last checkpoint.
+func Checkpoint(ctx context.Context) error {
+	heads, err := store.Querier.ListAuditChainHeads(ctx)
+	if err != nil {
+		return err
+	}
+	for _, head := range heads {
+		if head.Seq <= head.CheckpointSeq {
+			continue
+		}
+		if err := checkpointChain(ctx, head.ChainID); err != nil {
+			return fmt.Errorf("chain %s: %w", head.ChainID, err)
+		}
+	}
+	return nil
+}
+
+func checkpointChain(ctx context.Context, chainID uuid.UUID) error {
+	tx, err := store.PgPool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	q := store.Querier.WithTx(tx)
+
+	head, err := q.LockAuditChainHead(ctx, chainID)
+	if err != nil {
+		return err
+	}
+	// Another instance may have signed it in the meantime.
+	if head.Seq <= head.CheckpointSeq {
+		return nil
+	}
+	if err := createCheckpoint(ctx, q, chainID, CheckpointKindCheckpoint, head.Seq, head.Hash, 0); err != nil {
+		return err
+	}
+	if err := q.SetAuditChainCheckpoint(ctx, db.SetAuditChainCheckpointParams{
+		CheckpointSeq: head.Seq,
+		ChainID:       chainID,
+	}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// DeleteBefore deletes the audit logs created before the given time, and returns the number of deleted logs.
+//
+// For every chain, a signed tombstone with the seq and hash of the last deleted entry is written in the same
+// transaction, so that the rest of the chain still verifies.
+func DeleteBefore(ctx context.Context, before time.Time) (int64, error) {
+	heads, err := store.Querier.ListAuditChainHeads(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, head := range heads {
+		deleted, err := deleteChainBefore(ctx, head.ChainID, before)
+		if err != nil {
+			return total, fmt.Errorf("chain %s: %w", head.ChainID, err)
+		}
+		total += deleted
+	}
+
+	deleted, err := store.Querier.DeleteUnchainedAuditLogs(ctx, pgtype.Timestamptz{Time: before, Valid: true})
+	if err != nil {
+		return total, err
+	}
+	return total + deleted, nil
+}
+
+func deleteChainBefore(ctx context.Context, chainID uuid.UUID, before time.Time) (int64, error) {
+	tx, err := store.PgPool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := store.Querier.WithTx(tx)
+
+	// Lock the head, so that entries are not appended while the tombstone is written.
+	if _, err := q.LockAuditChainHead(ctx, chainID); err != nil {
+		return 0, err
+	}
+	last, err := q.GetLastAuditChainLogBefore(ctx, db.GetLastAuditChainLogBeforeParams{
+		ChainID: chainID,
+		Before:  pgtype.Timestamptz{Time: before, Valid: true},
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	deleted, err := q.DeleteAuditChainLogs(ctx, db.DeleteAuditChainLogsParams{
+		ChainID: chainID,
+		Seq:     *last.Seq,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+	if err := createCheckpoint(ctx, q, chainID, CheckpointKindTombstone, *last.Seq, last.Hash, deleted); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// ChainReport is the result of verifying a chain.
+type ChainReport struct {
+	ChainID uuid.UUID
+
+	// FromSeq is the first verified seq, after the latest tombstone.
+	FromSeq int64
+
+	// Entries and Checkpoints are the number of verified entries and checkpoints.
+	Entries     int64
+	Checkpoints int
+
+	// Unverifiable is the number of checkpoints signed with a key which is neither the current key nor a retired key
+	// of the config, eg. after a key rotation. They are used like the others, but their signatures are not checked,
+	// so they do not break the chain.
+	Unverifiable int
+
+	// BrokenAt is the seq where the chain breaks, and Reason describes how. Both are empty if the chain is intact.
+	BrokenAt int64
+	Reason   string
+}
+
+// OK reports whether the chain is intact.
+func (r *ChainReport) OK() bool {
+	return r.Reason == ""
+}
+
+func (r *ChainReport) broken(seq int64, format string, args ...any) *ChainReport {
+	r.BrokenAt = seq
+	r.Reason = fmt.Sprintf(format, args...)
+	return r
+}
+
+// Verify verifies the chain of the organization, or of every organization if orgID is nil,
+// including the global chain.
+//
+// The token public keys must be initialized, to verify the checkpoint signatures.
+// Checkpoints signed with a previous key are verified with the retired keys of the config.
+func Verify(ctx context.Context, orgID *uuid.UUID) ([]*ChainReport, error) {
+	heads, err := store.Querier.ListAuditChainHeads(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var reports []*ChainReport
+	for _, head := range heads {
+		if orgID != nil && head.ChainID != *orgID {
+			continue
+		}
+		report, err := verifyChain(ctx, store.Querier, head)
+		if err != nil {
+			return reports, fmt.Errorf("chain %s: %w", head.ChainID, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// chainReader reads the entries and checkpoints of a chain, it is implemented by *db.Queries.
+type chainReader interface {
+	ListAuditCheckpoints(ctx context.Context, chainID uuid.UUID) ([]db.AuditCheckpoint, error)
+	ListAuditChainLogs(ctx context.Context, arg db.ListAuditChainLogsParams) ([]db.AuditLog, error)
+}
+
+func verifyChain(ctx context.Context, q chainReader, head db.AuditChainHead) (*ChainReport, error) {
+	report := &ChainReport{ChainID: head.ChainID, FromSeq: 1}
+
+	checkpoints, err := q.ListAuditCheckpoints(ctx, head.ChainID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The chain is verified from the latest tombstone onwards, entries before it have been deleted.
+	prevHash := genesisHash
+	for _, cp := range checkpoints {
+		err := tokens.VerifyDataWithKey(cp.KeyID, checkpointPayload(&cp), cp.Signature)
+		switch {
+		case errors.Is(err, tokens.ErrUnknownKey):
+			report.Unverifiable++
+		case err != nil:
+			return report.broken(cp.Seq, "%s %s has an invalid signature", cp.Kind, cp.ID), nil
+		default:
+			report.Checkpoints++
+		}
+		if cp.Kind == CheckpointKindTombstone && cp.Seq >= report.FromSeq {
+			report.FromSeq = cp.Seq + 1
+			prevHash = cp.Hash
+		}
+	}
+	signed := make(map[int64][]db.AuditCheckpoint)
+	for _, cp := range checkpoints {
+		if cp.Kind == CheckpointKindCheckpoint && cp.Seq >= report.FromSeq {
+			signed[cp.Seq] = append(signed[cp.Seq], cp)
+		}
+	}
+
+	seq := report.FromSeq
+walk:
+	for {
+		logs, err := q.ListAuditChainLogs(ctx, db.ListAuditChainLogsParams{
+			ChainID:  head.ChainID,
+			AfterSeq: seq - 1,
+			Limit:    verifyPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, log := range logs {
+			// Entries appended after the head was read are verified by the next run.
+			if *log.Seq > head.Seq {
+				break walk
+			}
+			if *log.Seq != seq {
+				return report.broken(seq, "entries %d to %d are missing", seq, *log.Seq-1), nil
+			}
+			if !bytes.Equal(log.PrevHash, prevHash) {
+				return report.broken(seq, "entry %s does not link to the previous entry", log.ID), nil
+			}
+			hash, err := entryHash(prevHash, &log)
+			if err != nil {
+				return report.broken(seq, "entry %s cannot be hashed: %v", log.ID, err), nil
+			}
+			if !bytes.Equal(hash, log.Hash) {
+				return report.broken(seq, "entry %s was modified", log.ID), nil
+			}
+			for _, cp := range signed[seq] {
+				if !bytes.Equal(cp.Hash, hash) {
+					return report.broken(seq, "entry %s does not match checkpoint %s, the chain was rewritten", log.ID, cp.ID), nil
+				}
+			}
+			prevHash = hash
+			report.Entries++
+			seq++
+		}
+		if len(logs) < verifyPageSize {
+			break
+		}
+	}
+
+	last := seq - 1
+	for _, cp := range checkpoints {
+		if cp.Seq > last {
+			return report.broken(last+1, "entries %d to %d were deleted, %s %s was signed at seq %d", last+1, cp.Seq, cp.Kind, cp.ID, cp.Seq), nil
+		}
+	}
+	if head.Seq != last {
+		return report.broken(last+1, "entries %d to %d were deleted, the chain head is at seq %d", last+1, head.Seq, head.Seq), nil
+	}
+	if !bytes.Equal(head.Hash, prevHash) {
+		return report.broken(last, "the chain head does not match the last entry"), nil
+	}
+	return report, nil
+}
@@ -0,0 +1,413 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/tokens"
+	"go.uber.org/zap"
+)
+
+// writeTestKeys writes a new RSA key pair to the directory, and returns the paths of the private and public keys.
+func writeTestKeys(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath := filepath.Join(dir, "private.pem")
+	publicPath := filepath.Join(dir, "public.pem")
+	if err := os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return privatePath, publicPath
+}
+
+// initTestTokens initializes the token keys with a new key pair, and the retired public keys, and returns the new public key path.
+func initTestTokens(t *testing.T, retired ...string) string {
+	t.Helper()
+	logging.Logger = zap.NewNop()
+	privatePath, publicPath := writeTestKeys(t, t.TempDir())
+	config.JWT = &config.JWTConfig{
+		PrivateKeyFile:        privatePath,
+		PublicKeyFile:         publicPath,
+		RetiredPublicKeyFiles: retired,
+	}
+	if err := tokens.InitTokens(); err != nil {
+		t.Fatal(err)
+	}
+	return publicPath
+}
+
+// fakeChain holds a chain in memory, and implements chainReader.
+type fakeChain struct {
+	head        db.AuditChainHead
+	logs        []db.AuditLog
+	checkpoints []db.AuditCheckpoint
+}
+
+func (f *fakeChain) ListAuditCheckpoints(_ context.Context, chainID uuid.UUID) ([]db.AuditCheckpoint, error) {
+	return f.checkpoints, nil
+}
+
+func (f *fakeChain) ListAuditChainLogs(_ context.Context, arg db.ListAuditChainLogsParams) ([]db.AuditLog, error) {
+	var logs []db.AuditLog
+	for _, log := range f.logs {
+		if *log.Seq > arg.AfterSeq {
+			logs = append(logs, log)
+		}
+	}
+	slices.SortFunc(logs, func(a, b db.AuditLog) int { return int(*a.Seq - *b.Seq) })
+	return logs[:min(len(logs), int(arg.Limit))], nil
+}
+
+// newFakeChain returns a chain of n entries.
+func newFakeChain(t *testing.T, n int) *fakeChain {
+	t.Helper()
+	chainID := uuid.New()
+	head := &db.AuditChainHead{ChainID: chainID, Hash: genesisHash}
+	logs := make([]db.AuditLog, n)
+	for i := range logs {
+		logs[i] = *newTestLog(string(ActionLoginSucceeded))
+		logs[i].OrgID = &chainID
+		logs[i].ChainID = chainID
+	}
+	chained, err := chainEntries(map[uuid.UUID]*db.AuditChainHead{chainID: head}, logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeChain{head: *head, logs: chained}
+}
+
+// checkpoint signs a checkpoint of the chain at the entry with the given seq.
+func (f *fakeChain) checkpoint(t *testing.T, kind string, seq int64, deleted int64) {
+	t.Helper()
+	cp, err := newCheckpoint(f.head.ChainID, kind, seq, f.logs[seq-1].Hash, deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.checkpoints = append(f.checkpoints, cp)
+}
+
+// rechain recomputes the hashes of the entries from the given index, like an attacker rewriting the chain would.
+func (f *fakeChain) rechain(t *testing.T, from int) {
+	t.Helper()
+	prevHash := genesisHash
+	if from > 0 {
+		prevHash = f.logs[from-1].Hash
+	}
+	for i := from; i < len(f.logs); i++ {
+		hash, err := entryHash(prevHash, &f.logs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.logs[i].PrevHash, f.logs[i].Hash = prevHash, hash
+		prevHash = hash
+	}
+	f.head.Hash = prevHash
+}
+
+func TestEntryHash(t *testing.T) {
+	base := newTestLog(string(ActionLoginSucceeded))
+	seq := int64(1)
+	base.Seq = &seq
+	baseHash, err := entryHash(genesisHash, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(log *db.AuditLog)
+		prev   []byte
+		same   bool
+	}{
+		{name: "unchanged", modify: func(log *db.AuditLog) {}, same: true},
+		{name: "metadata key order and whitespace", modify: func(log *db.AuditLog) { log.Metadata = []byte(`{ "reason" : "a=b" }`) }, same: true},
+		{name: "previous hash", modify: func(log *db.AuditLog) {}, prev: bytes.Repeat([]byte{1}, 32)},
+		{name: "action", modify: func(log *db.AuditLog) { log.Action = string(ActionLogout) }},
+		{name: "resource type", modify: func(log *db.AuditLog) { log.ResourceType = string(ResourceOrg) }},
+		{name: "seq", modify: func(log *db.AuditLog) { s := int64(2); log.Seq = &s }},
+		{name: "user", modify: func(log *db.AuditLog) { id := uuid.New(); log.UserID = &id }},
+		{name: "no user", modify: func(log *db.AuditLog) { log.UserID = nil }},
+		{name: "empty user agent", modify: func(log *db.AuditLog) { ua := ""; log.UserAgent = &ua }},
+		{name: "no user agent", modify: func(log *db.AuditLog) { log.UserAgent = nil }},
+		{name: "metadata", modify: func(log *db.AuditLog) { log.Metadata = []byte(`{"reason":"a=c"}`) }},
+		{name: "created at", modify: func(log *db.AuditLog) { log.CreatedAt.Time = log.CreatedAt.Time.Add(1000) }},
+		// The boundaries between fields are part of the hash.
+		{name: "moved field boundary", modify: func(log *db.AuditLog) {
+			log.Action, log.ResourceType = log.Action+log.ResourceType[:1], log.ResourceType[1:]
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := *base
+			tt.modify(&log)
+			prev := genesisHash
+			if tt.prev != nil {
+				prev = tt.prev
+			}
+			hash, err := entryHash(prev, &log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(hash, baseHash) != tt.same {
+				t.Errorf("hash equal to the original: %v, want %v", !tt.same, tt.same)
+			}
+		})
+	}
+
+	unchained := *base
+	unchained.Seq = nil
+	if _, err := entryHash(genesisHash, &unchained); err == nil {
+		t.Error("expected an error for an entry without seq")
+	}
+}
+
+func TestChainEntries(t *testing.T) {
+	first, second := uuid.New(), uuid.New()
+	heads := map[uuid.UUID]*db.AuditChainHead{
+		first:  {ChainID: first, Hash: genesisHash},
+		second: {ChainID: second, Seq: 41, Hash: bytes.Repeat([]byte{7}, 32)},
+	}
+	var logs []db.AuditLog
+	for _, chainID := range []uuid.UUID{first, second, first} {
+		log := *newTestLog(string(ActionLoginSucceeded))
+		log.ChainID = chainID
+		logs = append(logs, log)
+	}
+
+	chained, err := chainEntries(heads, logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int64{1, 42, 2} {
+		if *chained[i].Seq != want {
+			t.Errorf("entry %d has seq %d, want %d", i, *chained[i].Seq, want)
+		}
+	}
+	if !bytes.Equal(chained[0].PrevHash, genesisHash) || !bytes.Equal(chained[2].PrevHash, chained[0].Hash) {
+		t.Error("the entries of the first chain are not linked")
+	}
+	if !bytes.Equal(chained[1].PrevHash, bytes.Repeat([]byte{7}, 32)) {
+		t.Error("the entry of the second chain is not linked to its head")
+	}
+	if heads[first].Seq != 2 || !bytes.Equal(heads[first].Hash, chained[2].Hash) || heads[second].Seq != 42 {
+		t.Errorf("the heads were not advanced: %+v %+v", heads[first], heads[second])
+	}
+
+	orphan := *newTestLog(string(ActionLoginSucceeded))
+	orphan.ChainID = uuid.New()
+	if _, err := chainEntries(heads, []db.AuditLog{orphan}); err == nil {
+		t.Error("expected an error for an entry of a chain which is not locked")
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	initTestTokens(t)
+
+	tests := []struct {
+		name string
+		// tamper modifies the chain of 5 entries, with a checkpoint at seq 3.
+		tamper     func(t *testing.T, f *fakeChain)
+		brokenAt   int64
+		reason     string
+		fromSeq    int64
+		entries    int64
+		checkpoint int
+	}{
+		{
+			name:       "intact",
+			tamper:     func(t *testing.T, f *fakeChain) {},
+			fromSeq:    1,
+			entries:    5,
+			checkpoint: 1,
+		},
+		{
+			name:     "modified field",
+			tamper:   func(t *testing.T, f *fakeChain) { f.logs[3].Action = string(ActionLogout) },
+			brokenAt: 4,
+			reason:   "was modified",
+		},
+		{
+			name:     "modified metadata",
+			tamper:   func(t *testing.T, f *fakeChain) { f.logs[4].Metadata = []byte(`{}`) },
+			brokenAt: 5,
+			reason:   "was modified",
+		},
+		{
+			name: "modified seq",
+			tamper: func(t *testing.T, f *fakeChain) {
+				seq := int64(9)
+				f.logs[3].Seq = &seq
+			},
+			brokenAt: 4,
+			reason:   "entries 4 to 4 are missing",
+		},
+		{
+			name:     "deleted entry",
+			tamper:   func(t *testing.T, f *fakeChain) { f.logs = slices.Delete(f.logs, 1, 2) },
+			brokenAt: 2,
+			reason:   "entries 2 to 2 are missing",
+		},
+		{
+			name:     "deleted latest entry",
+			tamper:   func(t *testing.T, f *fakeChain) { f.logs = f.logs[:4] },
+			brokenAt: 5,
+			reason:   "the chain head is at seq 5",
+		},
+		{
+			name: "deleted entries and head rolled back",
+			tamper: func(t *testing.T, f *fakeChain) {
+				f.logs = f.logs[:2]
+				f.head.Seq, f.head.Hash = 2, f.logs[1].Hash
+			},
+			brokenAt: 3,
+			reason:   "was signed at seq 3",
+		},
+		{
+			name: "rewritten chain",
+			tamper: func(t *testing.T, f *fakeChain) {
+				f.logs[1].Action = string(ActionLogout)
+				f.rechain(t, 1)
+			},
+			brokenAt: 3,
+			reason:   "does not match checkpoint",
+		},
+		{
+			name: "rewritten after the last checkpoint",
+			tamper: func(t *testing.T, f *fakeChain) {
+				f.logs[4].Action = string(ActionLogout)
+				f.rechain(t, 4)
+			},
+			fromSeq:    1,
+			entries:    5,
+			checkpoint: 1,
+		},
+		{
+			name: "tombstone",
+			tamper: func(t *testing.T, f *fakeChain) {
+				f.checkpoint(t, CheckpointKindTombstone, 2, 2)
+				f.logs = f.logs[2:]
+			},
+			fromSeq:    3,
+			entries:    3,
+			checkpoint: 2,
+		},
+		{
+			name:     "deleted without tombstone",
+			tamper:   func(t *testing.T, f *fakeChain) { f.logs = f.logs[2:] },
+			brokenAt: 1,
+			reason:   "entries 1 to 2 are missing",
+		},
+		{
+			name: "forged tombstone",
+			tamper: func(t *testing.T, f *fakeChain) {
+				f.checkpoint(t, CheckpointKindTombstone, 2, 2)
+				f.checkpoints[len(f.checkpoints)-1].Seq = 4
+				f.logs = f.logs[4:]
+			},
+			brokenAt: 4,
+			reason:   "has an invalid signature",
+		},
+		{
+			name: "tombstone with another hash",
+			tamper: func(t *testing.T, f *fakeChain) {
+				cp, err := newCheckpoint(f.head.ChainID, CheckpointKindTombstone, 2, f.logs[0].Hash, 2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.checkpoints = append(f.checkpoints, cp)
+				f.logs = f.logs[2:]
+			},
+			brokenAt: 3,
+			reason:   "does not link to the previous entry",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeChain(t, 5)
+			f.checkpoint(t, CheckpointKindCheckpoint, 3, 0)
+			tt.tamper(t, f)
+
+			report, err := verifyChain(t.Context(), f, f.head)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.reason == "" {
+				if !report.OK() {
+					t.Fatalf("the chain is broken at seq %d: %s", report.BrokenAt, report.Reason)
+				}
+				if report.FromSeq != tt.fromSeq || report.Entries != tt.entries || report.Checkpoints != tt.checkpoint {
+					t.Errorf("verified from seq %d, %d entries, %d checkpoints, want %d, %d, %d",
+						report.FromSeq, report.Entries, report.Checkpoints, tt.fromSeq, tt.entries, tt.checkpoint)
+				}
+				return
+			}
+			if report.OK() {
+				t.Fatalf("the chain is intact, want broken at seq %d", tt.brokenAt)
+			}
+			if report.BrokenAt != tt.brokenAt || !strings.Contains(report.Reason, tt.reason) {
+				t.Errorf("broken at seq %d: %s, want seq %d: %s", report.BrokenAt, report.Reason, tt.brokenAt, tt.reason)
+			}
+		})
+	}
+}
+
+func TestVerifyChainKeyRotation(t *testing.T) {
+	previousKey := initTestTokens(t)
+	f := newFakeChain(t, 3)
+	f.checkpoint(t, CheckpointKindTombstone, 1, 1)
+	f.checkpoint(t, CheckpointKindCheckpoint, 3, 0)
+	f.logs = f.logs[1:]
+
+	// The previous key is not kept: the checkpoints cannot be verified, but the chain is not broken.
+	initTestTokens(t)
+	report, err := verifyChain(t.Context(), f, f.head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Unverifiable != 2 || report.Checkpoints != 0 || report.FromSeq != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// The previous key is retired: the checkpoints are verified with it.
+	initTestTokens(t, previousKey)
+	report, err = verifyChain(t.Context(), f, f.head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Unverifiable != 0 || report.Checkpoints != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// A checkpoint modified after it was signed with the retired key is still detected.
+	f.checkpoints[1].Hash = f.logs[0].Hash
+	report, err = verifyChain(t.Context(), f, f.head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || !strings.Contains(report.Reason, "has an invalid signature") {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,169 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nbrglm/nexeres/config"
+)
+
+// Action is the type of an audit event, in the format "<area>.<subject>.<outcome>".
+type Action string
+
+// ResourceType is the type of the resource an audit event acted on.
+type ResourceType string
+
+const (
+	ResourceUser         ResourceType = "user"
+	ResourceSession      ResourceType = "session"
+	ResourceOrg          ResourceType = "org"
+	ResourceRole         ResourceType = "org_role"
+	ResourceMember       ResourceType = "org_member"
+	ResourceAdmin        ResourceType = "admin"
+	ResourceConfig       ResourceType = "config"
+	ResourceAuditLog     ResourceType = "audit_log"
+	ResourceWebhook      ResourceType = "webhook_endpoint"
+	ResourceNotification ResourceType = "notification_job"
+	ResourceAPIKey       ResourceType = "api_key"
+)
+
+const (
+	ActionLoginSucceeded   Action = "auth.login.succeeded"
+	ActionLoginFailed      Action = "auth.login.failed"
+	ActionLoginMFARequired Action = "auth.login.mfa_required"
+	ActionSignup           Action = "auth.signup.succeeded"
+	ActionSignupFailed     Action = "auth.signup.failed"
+	ActionVerificationSent Action = "auth.verify_email.sent"
+	ActionEmailVerified    Action = "auth.verify_email.succeeded"
+	ActionVerifyFailed     Action = "auth.verify_email.failed"
+	ActionLogout           Action = "auth.logout.succeeded"
+	ActionTokenRefreshed   Action = "auth.refresh.succeeded"
+	ActionRefreshFailed    Action = "auth.refresh.failed"
+
+	ActionUserProfileUpdated   Action = "user.profile.updated"
+	ActionUserAvatarUpdated    Action = "user.avatar.updated"
+	ActionEmailChangeRequested Action = "user.email.change_requested"
+	ActionEmailChanged         Action = "user.email.changed"
+	ActionUserDataExported     Action = "user.data.exported"
+	ActionUserErasureScheduled Action = "user.erasure.scheduled"
+	ActionUserErasureCancelled Action = "user.erasure.cancelled"
+	ActionUserErased           Action = "user.erasure.completed"
+
+	ActionAdminLoginCodeSent  Action = "admin.login.code_sent"
+	ActionAdminLoginSucceeded Action = "admin.login.succeeded"
+	ActionAdminLoginFailed    Action = "admin.login.failed"
+	ActionAdminConfigRead     Action = "admin.config.read"
+	ActionNotificationRetried Action = "admin.notification.retried"
+	ActionAPIKeyCreated       Action = "admin.api_key.created"
+	ActionAPIKeyRotated       Action = "admin.api_key.rotated"
+	ActionAPIKeyRevoked       Action = "admin.api_key.revoked"
+
+	ActionMemberRoleChanged Action = "org.member.role_changed"
+	ActionRoleCreated       Action = "org.role.created"
+	ActionRoleUpdated       Action = "org.role.updated"
+	ActionRoleDeleted       Action = "org.role.deleted"
+
+	ActionOrgDeleted            Action = "org.deleted"
+	ActionSecurityPolicyUpdated Action = "org.security_policy.updated"
+	ActionBrandingUpdated       Action = "org.branding.updated"
+	ActionEmailTemplateUpdated  Action = "org.email_template.updated"
+	ActionEmailTemplateDeleted  Action = "org.email_template.deleted"
+	ActionWebhookCreated        Action = "org.webhook.created"
+	ActionWebhookUpdated        Action = "org.webhook.updated"
+	ActionWebhookDeleted        Action = "org.webhook.deleted"
+
+	ActionAuditLogsExported Action = "audit.logs.exported"
+)
+
+// Catalogue maps every known action to the type of resource it acts on.
+//
+// Events with an action which is not in the catalogue are rejected by Record.
+var Catalogue = map[Action]ResourceType{
+	ActionLoginSucceeded:   ResourceSession,
+	ActionLoginFailed:      ResourceUser,
+	ActionLoginMFARequired: ResourceUser,
+	ActionSignup:           ResourceUser,
+	ActionSignupFailed:     ResourceUser,
+	ActionVerificationSent: ResourceUser,
+	ActionEmailVerified:    ResourceUser,
+	ActionVerifyFailed:     ResourceUser,
+	ActionLogout:           ResourceSession,
+	ActionTokenRefreshed:   ResourceSession,
+	ActionRefreshFailed:    ResourceSession,
+
+	ActionUserProfileUpdated:   ResourceUser,
+	ActionUserAvatarUpdated:    ResourceUser,
+	ActionEmailChangeRequested: ResourceUser,
+	ActionEmailChanged:         ResourceUser,
+	ActionUserDataExported:     ResourceUser,
+	ActionUserErasureScheduled: ResourceUser,
+	ActionUserErasureCancelled: ResourceUser,
+	ActionUserErased:           ResourceUser,
+
+	ActionAdminLoginCodeSent:  ResourceAdmin,
+	ActionAdminLoginSucceeded: ResourceAdmin,
+	ActionAdminLoginFailed:    ResourceAdmin,
+	ActionAdminConfigRead:     ResourceConfig,
+	ActionNotificationRetried: ResourceNotification,
+	ActionAPIKeyCreated:       ResourceAPIKey,
+	ActionAPIKeyRotated:       ResourceAPIKey,
+	ActionAPIKeyRevoked:       ResourceAPIKey,
+
+	ActionMemberRoleChanged: ResourceMember,
+	ActionRoleCreated:       ResourceRole,
+	ActionRoleUpdated:       ResourceRole,
+	ActionRoleDeleted:       ResourceRole,
+
+	ActionOrgDeleted:            ResourceOrg,
+	ActionSecurityPolicyUpdated: ResourceOrg,
+	ActionBrandingUpdated:       ResourceOrg,
+	ActionEmailTemplateUpdated:  ResourceOrg,
+	ActionEmailTemplateDeleted:  ResourceOrg,
+	ActionWebhookCreated:        ResourceWebhook,
+	ActionWebhookUpdated:        ResourceWebhook,
+	ActionWebhookDeleted:        ResourceWebhook,
+
+	ActionAuditLogsExported: ResourceAuditLog,
+}
+
+// Event is a single audit log entry.
+type Event struct {
+	Action Action
+
+	// OrgID is the organization the event belongs to, nil for events outside of an organization, eg. admin logins.
+	OrgID *uuid.UUID
+
+	// ActorID is the user who performed the action, nil if unknown, eg. failed logins, or for admins.
+	ActorID *uuid.UUID
+
+	// ResourceID is the resource the action was performed on, eg. the session created by a login.
+	// Resources without a known ID, eg. deleted roles, are identified in the metadata.
+	ResourceID *uuid.UUID
+
+	// IPAddress and UserAgent of the request, filled in by RecordRequest.
+	IPAddress *netip.Addr
+	UserAgent *string
+
+	// Metadata holds additional details, eg. the reason of a failure.
+	// It must not contain secrets, like passwords or tokens, nor the email addresses of users, since the audit logs
+	// are kept after a user is erased. Users are identified by ID, or by EmailHash if they are unknown, eg. failed logins.
+	// The configured admins, who are not users, are identified by their email.
+	Metadata map[string]any
+
+	// Time of the event, defaults to the time it is recorded.
+	Time time.Time
+}
+
+// EmailHash returns the hex encoded HMAC-SHA256 of the normalized email, keyed with the configured email hash secret,
+// to correlate the events of an email, eg. failed logins, without storing the address in the audit logs.
+// Unlike a plain hash, it cannot be reversed by hashing a list of known addresses without the secret.
+func EmailHash(email string) string {
+	mac := hmac.New(sha256.New, []byte(config.Security.AuditLogs.EmailHashSecret))
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,85 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nbrglm/nexeres/config"
+)
+
+// fileTransport appends messages, one per line, to a file, which is rotated when it reaches the maximum size.
+//
+// Rotated files are renamed to path.1 (newest) up to path.N, and the oldest one is removed.
+type fileTransport struct {
+	cfg  *config.AuditFileConfig
+	file *os.File
+	size int64
+}
+
+func newFileTransport(cfg *config.AuditFileConfig) *fileTransport {
+	return &fileTransport{cfg: cfg}
+}
+
+func (t *fileTransport) open() error {
+	if err := os.MkdirAll(filepath.Dir(t.cfg.Path), 0o750); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(t.cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	t.file = file
+	t.size = info.Size()
+	return nil
+}
+
+func (t *fileTransport) rotate() error {
+	if err := t.file.Close(); err != nil {
+		return err
+	}
+	t.file = nil
+	for i := t.cfg.MaxBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", t.cfg.Path, i), fmt.Sprintf("%s.%d", t.cfg.Path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(t.cfg.Path, t.cfg.Path+".1"); err != nil {
+		return err
+	}
+	return t.open()
+}
+
+func (t *fileTransport) Send(msg []byte) error {
+	if t.file == nil {
+		if err := t.open(); err != nil {
+			return err
+		}
+	}
+
+	line := append(msg[:len(msg):len(msg)], '\n')
+	if t.size > 0 && t.size+int64(len(line)) > int64(t.cfg.MaxSize)*1024*1024 {
+		if err := t.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate %s: %w", t.cfg.Path, err)
+		}
+	}
+
+	n, err := t.file.Write(line)
+	t.size += int64(n)
+	return err
+}
+
+func (t *fileTransport) Close() error {
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
@@ -0,0 +1,183 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/opts"
+)
+
+// Sink formats of config.AuditSinkConfig.Format.
+const (
+	SinkFormatJSON = "json"
+	SinkFormatCEF  = "cef"
+)
+
+// sdID is the SD-ID of the structured data of the syslog messages. 32473 is the private enterprise number
+// reserved for documentation (RFC 5612), since Nexeres has no registered number.
+const sdID = "nexeres@32473"
+
+// hostname is the HOSTNAME of the syslog messages.
+var hostname = func() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "-"
+	}
+	return name
+}()
+
+// failed reports whether the action is a failure, which is sent with a higher severity.
+func failed(action string) bool {
+	return strings.HasSuffix(action, ".failed")
+}
+
+// formatJSON returns the log as a single line of JSON, in the format of the audit log API.
+func formatJSON(log *db.AuditLog) ([]byte, error) {
+	return json.Marshal(NewLogResult(*log))
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+// formatCEF returns the log in the ArcSight Common Event Format.
+func formatCEF(log *db.AuditLog) ([]byte, error) {
+	result := NewLogResult(*log)
+
+	severity := 3
+	outcome := "success"
+	if failed(log.Action) {
+		severity = 6
+		outcome = "failure"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|NBRGLM|Nexeres|%s|%s|%s|%d|",
+		cefHeaderEscaper.Replace(opts.Version),
+		cefHeaderEscaper.Replace(log.Action),
+		cefHeaderEscaper.Replace(log.Action),
+		severity,
+	)
+
+	ext := []string{
+		"rt=" + strconv.FormatInt(log.CreatedAt.Time.UnixMilli(), 10),
+		"act=" + cefExtensionEscaper.Replace(log.Action),
+		"outcome=" + outcome,
+		"externalId=" + result.ID,
+	}
+	add := func(key string, value *string) {
+		if value != nil && *value != "" {
+			ext = append(ext, key+"="+cefExtensionEscaper.Replace(*value))
+		}
+	}
+	if log.IpAddress != nil {
+		if log.IpAddress.Is4() || log.IpAddress.Is4In6() {
+			ip := log.IpAddress.Unmap().String()
+			add("src", &ip)
+		} else {
+			ext = append(ext, "c6a2Label=Source IPv6 Address")
+			add("c6a2", result.IPAddress)
+		}
+	}
+	add("suid", result.ActorID)
+	add("requestClientApplication", result.UserAgent)
+	if result.OrgID != nil {
+		ext = append(ext, "cs1Label=orgId")
+		add("cs1", result.OrgID)
+	}
+	ext = append(ext, "cs2Label=resourceType", "cs2="+cefExtensionEscaper.Replace(log.ResourceType))
+	if result.ResourceID != nil {
+		ext = append(ext, "cs3Label=resourceId")
+		add("cs3", result.ResourceID)
+	}
+	if len(result.Metadata) > 0 {
+		metadata := string(result.Metadata)
+		ext = append(ext, "cs4Label=metadata")
+		add("cs4", &metadata)
+	}
+	if log.Seq != nil {
+		ext = append(ext, "cn1Label=seq", "cn1="+strconv.FormatInt(*log.Seq, 10))
+	}
+	b.WriteString(strings.Join(ext, " "))
+	return []byte(b.String()), nil
+}
+
+var sdParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// formatSyslog wraps the formatted log in an RFC 5424 syslog message.
+func formatSyslog(cfg *config.AuditSyslogConfig, log *db.AuditLog, msg []byte) []byte {
+	severity := 5 // notice
+	if failed(log.Action) {
+		severity = 4 // warning
+	}
+	result := NewLogResult(*log)
+
+	var sd strings.Builder
+	sd.WriteString("[" + sdID)
+	param := func(name string, value *string) {
+		if value != nil && *value != "" {
+			sd.WriteString(" " + name + `="` + sdParamEscaper.Replace(*value) + `"`)
+		}
+	}
+	param("id", &result.ID)
+	param("orgId", result.OrgID)
+	param("actorId", result.ActorID)
+	param("resourceType", &result.ResourceType)
+	param("resourceId", result.ResourceID)
+	param("ip", result.IPAddress)
+	sd.WriteString("]")
+
+	msgID := log.Action
+	if len(msgID) > 32 {
+		msgID = msgID[:32]
+	}
+
+	header := fmt.Sprintf("<%d>1 %s %s %s %d %s %s ",
+		cfg.Facility*8+severity,
+		log.CreatedAt.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		hostname,
+		cfg.AppName,
+		os.Getpid(),
+		msgID,
+		sd.String(),
+	)
+	return append([]byte(header), msg...)
+}
+
+// formatForSink returns the log in the sink's format.
+func formatForSink(cfg *config.AuditSinkConfig, log *db.AuditLog) ([]byte, error) {
+	var msg []byte
+	var err error
+	if cfg.Format == SinkFormatCEF {
+		msg, err = formatCEF(log)
+	} else {
+		msg, err = formatJSON(log)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Syslog != nil && cfg.Type == SinkTypeSyslog {
+		return formatSyslog(cfg.Syslog, log, msg), nil
+	}
+	return msg, nil
+}
+
+// testLog returns the log sent by SendTestEvent.
+func testLog() *db.AuditLog {
+	return &db.AuditLog{
+		ID:           uuid.New(),
+		Action:       "audit.sink.test",
+		ResourceType: string(ResourceAuditLog),
+		Metadata:     []byte(`{"message":"Test event from nexeres audit test-sinks"}`),
+		CreatedAt:    pgtype.Timestamptz{Time: time.Now(), Valid: true},
+	}
+}
@@ -0,0 +1,388 @@
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/store"
+)
+
+// Export formats of ListParams.Format.
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// DefaultListLimit is the default number of logs in a page.
+const DefaultListLimit = 50
+
+// exportPageSize is the number of logs fetched at a time while exporting.
+const exportPageSize = 1000
+
+// ListParams are the query parameters of the audit log endpoints.
+type ListParams struct {
+	// Actions to include, eg. "auth.login.failed". Can be repeated.
+	Actions []string `form:"action"`
+
+	// ActorID is the user who performed the actions.
+	ActorID string `form:"actorId" binding:"omitempty,uuid"`
+
+	// ResourceType and ResourceID of the resource the actions were performed on.
+	ResourceType string `form:"resourceType"`
+	ResourceID   string `form:"resourceId" binding:"omitempty,uuid"`
+
+	// IP is an IP address or a CIDR range, eg. "10.0.0.0/8".
+	IP string `form:"ip"`
+
+	// From (inclusive) and To (exclusive) are RFC 3339 timestamps.
+	From string `form:"from"`
+	To   string `form:"to"`
+
+	// Cursor is the nextCursor of the previous page. Ignored by exports.
+	Cursor string `form:"cursor"`
+
+	// Limit is the maximum number of logs in a page, default 50, max 1000. Ignored by exports.
+	Limit int `form:"limit" binding:"omitempty,min=1,max=1000"`
+
+	// Format is "json" (default) for a page of logs, or "ndjson" or "csv" to stream every matching log.
+	Format string `form:"format" binding:"omitempty,oneof=json ndjson csv"`
+}
+
+// Filter is the parsed form of ListParams.
+type Filter struct {
+	Actions      []string
+	ActorID      *uuid.UUID
+	ResourceType *string
+	ResourceID   *uuid.UUID
+	IPRange      *netip.Prefix
+	From         pgtype.Timestamptz
+	To           pgtype.Timestamptz
+}
+
+// Cursor is a position in the audit logs, ordered by (created_at, id) descending.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// Encode returns the opaque string form of the cursor.
+func (c Cursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()))
+}
+
+// DecodeCursor parses a cursor returned by Cursor.Encode.
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, errors.New("invalid cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &Cursor{CreatedAt: createdAt, ID: parsedID}, nil
+}
+
+// Filter validates the parameters and returns the filter.
+func (p *ListParams) Filter() (*Filter, error) {
+	f := &Filter{}
+	for _, action := range p.Actions {
+		for a := range strings.SplitSeq(action, ",") {
+			a = strings.TrimSpace(a)
+			if a == "" {
+				continue
+			}
+			if _, ok := Catalogue[Action(a)]; !ok {
+				return nil, fmt.Errorf("unknown action %q", a)
+			}
+			f.Actions = append(f.Actions, a)
+		}
+	}
+	if p.ActorID != "" {
+		id, err := uuid.Parse(p.ActorID)
+		if err != nil {
+			return nil, errors.New("invalid actorId")
+		}
+		f.ActorID = &id
+	}
+	if p.ResourceType != "" {
+		f.ResourceType = &p.ResourceType
+	}
+	if p.ResourceID != "" {
+		id, err := uuid.Parse(p.ResourceID)
+		if err != nil {
+			return nil, errors.New("invalid resourceId")
+		}
+		f.ResourceID = &id
+	}
+	if p.IP != "" {
+		prefix, err := parseIPRange(p.IP)
+		if err != nil {
+			return nil, errors.New("invalid ip, must be an IP address or a CIDR range")
+		}
+		f.IPRange = &prefix
+	}
+	if p.From != "" {
+		from, err := time.Parse(time.RFC3339, p.From)
+		if err != nil {
+			return nil, errors.New("invalid from, must be an RFC 3339 timestamp")
+		}
+		f.From = pgtype.Timestamptz{Time: from, Valid: true}
+	}
+	if p.To != "" {
+		to, err := time.Parse(time.RFC3339, p.To)
+		if err != nil {
+			return nil, errors.New("invalid to, must be an RFC 3339 timestamp")
+		}
+		f.To = pgtype.Timestamptz{Time: to, Valid: true}
+	}
+	if f.From.Valid && f.To.Valid && !f.From.Time.Before(f.To.Time) {
+		return nil, errors.New("from must be before to")
+	}
+	return f, nil
+}
+
+func parseIPRange(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// LogResult is an audit log, as returned by the API.
+type LogResult struct {
+	ID           string          `json:"id"`
+	CreatedAt    time.Time       `json:"createdAt"`
+	OrgID        *string         `json:"orgId"`
+	ActorID      *string         `json:"actorId"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resourceType"`
+	ResourceID   *string         `json:"resourceId"`
+	IPAddress    *string         `json:"ipAddress"`
+	UserAgent    *string         `json:"userAgent"`
+	Metadata     json.RawMessage `json:"metadata,omitempty" swaggertype:"object"`
+
+	// Seq and Hash are the position and hex encoded hash of the entry in its organization's hash chain,
+	// omitted for entries written before the chaining was introduced.
+	Seq  *int64  `json:"seq,omitempty"`
+	Hash *string `json:"hash,omitempty"`
+}
+
+func uuidString(id *uuid.UUID) *string {
+	if id == nil {
+		return nil
+	}
+	s := id.String()
+	return &s
+}
+
+// NewLogResult converts the audit log row to its API representation.
+func NewLogResult(log db.AuditLog) LogResult {
+	result := LogResult{
+		ID:           log.ID.String(),
+		CreatedAt:    log.CreatedAt.Time,
+		OrgID:        uuidString(log.OrgID),
+		ActorID:      uuidString(log.UserID),
+		Action:       log.Action,
+		ResourceType: log.ResourceType,
+		ResourceID:   uuidString(log.ResourceID),
+		UserAgent:    log.UserAgent,
+	}
+	if log.IpAddress != nil {
+		ip := log.IpAddress.String()
+		result.IPAddress = &ip
+	}
+	if len(log.Metadata) > 0 {
+		result.Metadata = log.Metadata
+	}
+	if log.Seq != nil {
+		hash := hex.EncodeToString(log.Hash)
+		result.Seq = log.Seq
+		result.Hash = &hash
+	}
+	return result
+}
+
+// List returns a page of the audit logs matching the filter, newest first, and the cursor of the next page,
+// nil if there are no more logs.
+//
+// If orgID is nil, the logs of all organizations are listed; this must only be allowed for platform admins.
+func List(ctx context.Context, orgID *uuid.UUID, filter *Filter, cursor *Cursor, limit int) ([]db.AuditLog, *Cursor, error) {
+	params := db.ListAuditLogsParams{
+		Actions:      filter.Actions,
+		ActorID:      filter.ActorID,
+		ResourceType: filter.ResourceType,
+		ResourceID:   filter.ResourceID,
+		IpRange:      filter.IPRange,
+		From:         filter.From,
+		To:           filter.To,
+		// Fetch one more log to know whether there is a next page.
+		Limit: int32(limit + 1),
+	}
+	if cursor != nil {
+		params.CursorCreatedAt = pgtype.Timestamptz{Time: cursor.CreatedAt, Valid: true}
+		params.CursorID = &cursor.ID
+	}
+
+	var logs []db.AuditLog
+	var err error
+	if orgID != nil {
+		logs, err = store.Querier.ListOrgAuditLogs(ctx, db.ListOrgAuditLogsParams{
+			OrgID:           orgID,
+			Actions:         params.Actions,
+			ActorID:         params.ActorID,
+			ResourceType:    params.ResourceType,
+			ResourceID:      params.ResourceID,
+			IpRange:         params.IpRange,
+			From:            params.From,
+			To:              params.To,
+			CursorCreatedAt: params.CursorCreatedAt,
+			CursorID:        params.CursorID,
+			Limit:           params.Limit,
+		})
+	} else {
+		logs, err = store.Querier.ListAuditLogs(ctx, params)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(logs) <= limit {
+		return logs, nil, nil
+	}
+	logs = logs[:limit]
+	last := logs[len(logs)-1]
+	return logs, &Cursor{CreatedAt: last.CreatedAt.Time, ID: last.ID}, nil
+}
+
+// ContentType returns the content type of the export format.
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+var csvHeader = []string{"id", "created_at", "org_id", "actor_id", "action", "resource_type", "resource_id", "ip_address", "user_agent", "metadata"}
+
+// ListResult is a page of audit logs, as returned by the API.
+type ListResult struct {
+	Logs []LogResult `json:"logs"`
+
+	// NextCursor is the cursor of the next page, omitted on the last page.
+	NextCursor *string `json:"nextCursor,omitempty"`
+}
+
+// NewListResult converts the page of audit logs to its API representation.
+func NewListResult(logs []db.AuditLog, next *Cursor) ListResult {
+	result := ListResult{Logs: make([]LogResult, 0, len(logs))}
+	for _, log := range logs {
+		result.Logs = append(result.Logs, NewLogResult(log))
+	}
+	if next != nil {
+		encoded := next.Encode()
+		result.NextCursor = &encoded
+	}
+	return result
+}
+
+// csvField returns the value as a CSV field, neutralizing values which spreadsheets would evaluate as formulas,
+// since fields like the user agent are controlled by clients.
+func csvField(s *string) string {
+	if s == nil || *s == "" {
+		return ""
+	}
+	if strings.ContainsRune("=+-@\t\r", rune((*s)[0])) {
+		return "'" + *s
+	}
+	return *s
+}
+
+// Export streams every audit log matching the filter to w, newest first, in the NDJSON or CSV format.
+//
+// The logs are fetched in pages, and flush is called after each page, so that large ranges are not buffered in memory.
+func Export(ctx context.Context, w io.Writer, flush func(), format string, orgID *uuid.UUID, filter *Filter) error {
+	var csvWriter *csv.Writer
+	var encoder *json.Encoder
+	if format == FormatCSV {
+		csvWriter = csv.NewWriter(w)
+		if err := csvWriter.Write(csvHeader); err != nil {
+			return err
+		}
+	} else {
+		encoder = json.NewEncoder(w)
+	}
+
+	var cursor *Cursor
+	for {
+		logs, next, err := List(ctx, orgID, filter, cursor, exportPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, log := range logs {
+			result := NewLogResult(log)
+			if csvWriter != nil {
+				err = csvWriter.Write([]string{
+					result.ID,
+					result.CreatedAt.UTC().Format(time.RFC3339Nano),
+					csvField(result.OrgID),
+					csvField(result.ActorID),
+					result.Action,
+					result.ResourceType,
+					csvField(result.ResourceID),
+					csvField(result.IPAddress),
+					csvField(result.UserAgent),
+					string(result.Metadata),
+				})
+			} else {
+				err = encoder.Encode(result)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if csvWriter != nil {
+			csvWriter.Flush()
+			if err := csvWriter.Error(); err != nil {
+				return err
+			}
+		}
+		flush()
+
+		if next == nil {
+			return nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,370 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Audit events are also streamed to the configured sinks, eg. a SIEM over syslog, beside the audit_logs table.
+//
+// Every sink has its own queue and worker, so that a slow or failing sink does not hold up the others, nor the
+// database writes. A message which cannot be sent after a few attempts is appended to the sink's disk buffer,
+// if configured, and the buffer is retried periodically. While the buffer is not empty, new messages are appended
+// to it as well, so that the order of the events is kept.
+
+// Sink types of config.AuditSinkConfig.Type.
+const (
+	SinkTypeSyslog = "syslog"
+	SinkTypeFile   = "file"
+)
+
+// sinkSendAttempts is the number of times a message is sent before it is buffered.
+const sinkSendAttempts = 3
+
+// transport delivers formatted messages to the destination of a sink.
+type transport interface {
+	// Send delivers the message. It can be called again after an error, eg. to reconnect.
+	Send(msg []byte) error
+	Close() error
+}
+
+func newTransport(cfg *config.AuditSinkConfig) (transport, error) {
+	switch cfg.Type {
+	case SinkTypeSyslog:
+		return newSyslogTransport(cfg.Syslog)
+	case SinkTypeFile:
+		return newFileTransport(cfg.File), nil
+	}
+	return nil, fmt.Errorf("unknown sink type %q", cfg.Type)
+}
+
+// sink is a configured audit sink, with its queue and worker.
+type sink struct {
+	cfg       *config.AuditSinkConfig
+	transport transport
+	queue     chan []byte
+	buffer    *diskBuffer
+	done      chan struct{}
+}
+
+var (
+	sinks []*sink
+
+	sinkMessagesCounter *prometheus.CounterVec
+	sinkBufferGauge     *prometheus.GaugeVec
+)
+
+func initSinkMetrics() {
+	sinkMessagesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "nexeres",
+			Subsystem: "audit",
+			Name:      "sink_messages",
+			Help:      "Total number of audit messages per sink, by status: sent, buffered (sink failing), dropped (queue or buffer full), invalid (formatting failed)",
+		},
+		[]string{"sink", "status"},
+	)
+	sinkBufferGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "nexeres",
+			Subsystem: "audit",
+			Name:      "sink_buffer_bytes",
+			Help:      "Size of the disk buffer of each audit sink",
+		},
+		[]string{"sink"},
+	)
+}
+
+// initSinks creates the configured sinks and starts their workers.
+func initSinks() error {
+	for i := range config.Security.AuditLogs.Sinks {
+		cfg := &config.Security.AuditLogs.Sinks[i]
+		t, err := newTransport(cfg)
+		if err != nil {
+			return fmt.Errorf("audit sink %s: %w", cfg.Name, err)
+		}
+		s := &sink{
+			cfg:       cfg,
+			transport: t,
+			queue:     make(chan []byte, cfg.QueueSize),
+			done:      make(chan struct{}),
+		}
+		if cfg.BufferDir != "" {
+			s.buffer, err = openDiskBuffer(filepath.Join(cfg.BufferDir, cfg.Name+".buffer"), int64(cfg.BufferMaxSize)*1024*1024)
+			if err != nil {
+				return fmt.Errorf("audit sink %s: failed to open the disk buffer: %w", cfg.Name, err)
+			}
+			sinkBufferGauge.WithLabelValues(cfg.Name).Set(float64(s.buffer.size))
+		}
+		sinks = append(sinks, s)
+		go s.run()
+	}
+	return nil
+}
+
+// matches reports whether the sink's filter includes the action.
+func (s *sink) matches(action string) bool {
+	if len(s.cfg.Actions) > 0 {
+		included := false
+		for _, pattern := range s.cfg.Actions {
+			if ok, _ := path.Match(pattern, action); ok {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, pattern := range s.cfg.ExcludeActions {
+		if ok, _ := path.Match(pattern, action); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// dispatch queues the logs to the sinks whose filters include them, dropping them if a sink's queue is full.
+func dispatch(logs []db.AuditLog) {
+	for _, s := range sinks {
+		for i := range logs {
+			if !s.matches(logs[i].Action) {
+				continue
+			}
+			msg, err := formatForSink(s.cfg, &logs[i])
+			if err != nil {
+				sinkMessagesCounter.WithLabelValues(s.cfg.Name, "invalid").Inc()
+				logging.Logger.Error("Failed to format audit event", zap.String("sink", s.cfg.Name), zap.String("action", logs[i].Action), zap.Error(err))
+				continue
+			}
+			select {
+			case s.queue <- msg:
+			default:
+				sinkMessagesCounter.WithLabelValues(s.cfg.Name, "dropped").Inc()
+				logging.Logger.Warn("Audit sink queue is full, dropping event", zap.String("sink", s.cfg.Name), zap.String("action", logs[i].Action))
+			}
+		}
+	}
+}
+
+// shutdownSinks stops the workers after they deliver or buffer their queued messages.
+func shutdownSinks() {
+	for _, s := range sinks {
+		close(s.queue)
+	}
+	for _, s := range sinks {
+		<-s.done
+		if err := s.transport.Close(); err != nil {
+			logging.Logger.Warn("Failed to close audit sink", zap.String("sink", s.cfg.Name), zap.Error(err))
+		}
+	}
+}
+
+func (s *sink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(time.Duration(s.cfg.RetryInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-s.queue:
+			if !ok {
+				return
+			}
+			s.deliver(msg)
+		case <-ticker.C:
+			s.retryBuffer()
+		}
+	}
+}
+
+// deliver sends the message, and buffers it if the sink is failing.
+func (s *sink) deliver(msg []byte) {
+	if s.buffer != nil && s.buffer.size > 0 {
+		s.bufferMessage(msg)
+		return
+	}
+
+	var err error
+	for attempt := range sinkSendAttempts {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+		if err = s.transport.Send(msg); err == nil {
+			sinkMessagesCounter.WithLabelValues(s.cfg.Name, "sent").Inc()
+			return
+		}
+	}
+
+	if s.buffer == nil {
+		sinkMessagesCounter.WithLabelValues(s.cfg.Name, "dropped").Inc()
+		logging.Logger.Error("Failed to send audit event to sink, dropping it", zap.String("sink", s.cfg.Name), zap.Error(err))
+		return
+	}
+	logging.Logger.Warn("Failed to send audit event to sink, buffering it", zap.String("sink", s.cfg.Name), zap.Error(err))
+	s.bufferMessage(msg)
+}
+
+func (s *sink) bufferMessage(msg []byte) {
+	if err := s.buffer.Append(msg); err != nil {
+		sinkMessagesCounter.WithLabelValues(s.cfg.Name, "dropped").Inc()
+		logging.Logger.Error("Failed to buffer audit event, dropping it", zap.String("sink", s.cfg.Name), zap.Error(err))
+		return
+	}
+	sinkMessagesCounter.WithLabelValues(s.cfg.Name, "buffered").Inc()
+	sinkBufferGauge.WithLabelValues(s.cfg.Name).Set(float64(s.buffer.size))
+}
+
+// retryBuffer sends the buffered messages, in order, until the sink fails again.
+func (s *sink) retryBuffer() {
+	if s.buffer == nil || s.buffer.size == 0 {
+		return
+	}
+	sent, err := s.buffer.Drain(s.transport.Send)
+	sinkMessagesCounter.WithLabelValues(s.cfg.Name, "sent").Add(float64(sent))
+	sinkBufferGauge.WithLabelValues(s.cfg.Name).Set(float64(s.buffer.size))
+	if err != nil {
+		logging.Logger.Warn("Failed to send buffered audit events to sink", zap.String("sink", s.cfg.Name), zap.Int("sent", sent), zap.Error(err))
+	}
+}
+
+// SendTestEvent sends a test event to the sink synchronously, without filtering or buffering,
+// to check its configuration, eg. against a local syslog listener.
+func SendTestEvent(cfg *config.AuditSinkConfig) error {
+	t, err := newTransport(cfg)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	msg, err := formatForSink(cfg, testLog())
+	if err != nil {
+		return err
+	}
+	return t.Send(msg)
+}
+
+// diskBuffer is a file of length-prefixed messages, kept while a sink is failing.
+//
+// It is only accessed by the sink's worker.
+type diskBuffer struct {
+	path    string
+	maxSize int64
+	size    int64
+}
+
+var errBufferFull = errors.New("buffer is full")
+
+func openDiskBuffer(path string, maxSize int64) (*diskBuffer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	b := &diskBuffer{path: path, maxSize: maxSize}
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return b, nil
+		}
+		return nil, err
+	}
+	b.size = info.Size()
+	return b, nil
+}
+
+// Append appends the message to the buffer.
+func (b *diskBuffer) Append(msg []byte) error {
+	if b.size+4+int64(len(msg)) > b.maxSize {
+		return errBufferFull
+	}
+	file, err := os.OpenFile(b.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	record := binary.BigEndian.AppendUint32(nil, uint32(len(msg)))
+	record = append(record, msg...)
+	n, err := file.Write(record)
+	b.size += int64(n)
+	return err
+}
+
+// Drain sends the buffered messages in order, until send fails, and keeps the unsent ones.
+// It returns the number of sent messages.
+func (b *diskBuffer) Drain(send func([]byte) error) (int, error) {
+	file, err := os.Open(b.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			b.size = 0
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	sent := 0
+	var sendErr error
+	for {
+		var length [4]byte
+		if _, err := io.ReadFull(reader, length[:]); err != nil {
+			// EOF, or a partial record written before a crash.
+			break
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(length[:]))
+		if _, err := io.ReadFull(reader, msg); err != nil {
+			break
+		}
+		if sendErr = send(msg); sendErr != nil {
+			break
+		}
+		offset += 4 + int64(len(msg))
+		sent++
+	}
+
+	if sendErr == nil {
+		if err := os.Remove(b.path); err != nil {
+			return sent, err
+		}
+		b.size = 0
+		return sent, nil
+	}
+	if offset == 0 {
+		return sent, sendErr
+	}
+
+	// Keep the unsent messages, replacing the buffer atomically.
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return sent, err
+	}
+	tmp, err := os.OpenFile(b.path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return sent, err
+	}
+	written, err := io.Copy(tmp, file)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return sent, err
+	}
+	if err := os.Rename(b.path+".tmp", b.path); err != nil {
+		return sent, err
+	}
+	b.size = written
+	return sent, sendErr
+}
@@ -0,0 +1,321 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"go.uber.org/zap"
+)
+
+func newTestLog(action string) *db.AuditLog {
+	orgID := uuid.New()
+	userID := uuid.New()
+	ip := netip.MustParseAddr("192.0.2.10")
+	userAgent := "agent=1\\2\nnext"
+	seq := int64(7)
+	return &db.AuditLog{
+		ID:           uuid.New(),
+		OrgID:        &orgID,
+		UserID:       &userID,
+		Action:       action,
+		ResourceType: string(ResourceUser),
+		IpAddress:    &ip,
+		UserAgent:    &userAgent,
+		Metadata:     []byte(`{"reason":"a=b"}`),
+		CreatedAt:    pgtype.Timestamptz{Time: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC), Valid: true},
+		Seq:          &seq,
+		Hash:         []byte{0xab},
+	}
+}
+
+func newSyslogSinkConfig(network, address, format string) *config.AuditSinkConfig {
+	return &config.AuditSinkConfig{
+		Name:          "siem",
+		Type:          SinkTypeSyslog,
+		Format:        format,
+		QueueSize:     10,
+		RetryInterval: 1,
+		BufferMaxSize: 1,
+		Syslog: &config.AuditSyslogConfig{
+			Network:  network,
+			Address:  address,
+			AppName:  "nexeres",
+			Facility: 10,
+		},
+	}
+}
+
+// readFrame reads an octet counted frame (RFC 6587).
+func readFrame(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	length, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatalf("failed to read the frame length: %v", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+	if err != nil {
+		t.Fatalf("invalid frame length %q: %v", length, err)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		t.Fatalf("failed to read the frame: %v", err)
+	}
+	return string(msg)
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cfg := newSyslogSinkConfig("udp", conn.LocalAddr().String(), SinkFormatJSON)
+	log := newTestLog(string(ActionLoginFailed))
+	msg, err := formatForSink(cfg, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport, err := newTransport(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	if err := transport.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64*1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+
+	// A failure is a warning (4) of the authpriv facility (10).
+	header := fmt.Sprintf("<84>1 2026-10-18T12:00:00.000000Z %s nexeres %d auth.login.failed [nexeres@32473 id=%q", hostname, os.Getpid(), log.ID.String())
+	if !strings.HasPrefix(got, header) {
+		t.Fatalf("unexpected header:\n got: %s\nwant: %s", got, header)
+	}
+	_, body, ok := strings.Cut(got, "] ")
+	if !ok {
+		t.Fatalf("no structured data in %q", got)
+	}
+	var result LogResult
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		t.Fatalf("the message is not JSON: %v", err)
+	}
+	if result.ID != log.ID.String() || result.Action != log.Action {
+		t.Fatalf("unexpected message %+v", result)
+	}
+}
+
+func TestSyslogTCPOctetCounting(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	cfg := newSyslogSinkConfig("tcp", listener.Addr().String(), SinkFormatCEF)
+	transport, err := newTransport(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+
+	var want []string
+	for _, action := range []Action{ActionSignup, ActionLogout} {
+		msg, err := formatForSink(cfg, newTestLog(string(action)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := transport.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, string(msg))
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, w := range want {
+		if got := readFrame(t, r); got != w {
+			t.Fatalf("unexpected frame:\n got: %s\nwant: %s", got, w)
+		}
+	}
+}
+
+func TestFormatCEF(t *testing.T) {
+	log := newTestLog(`auth|x\y.failed`)
+	msg, err := formatCEF(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(msg)
+
+	header, ext, ok := strings.Cut(got, `|6|`)
+	if !ok {
+		t.Fatalf("no failure severity in %q", got)
+	}
+	if want := `|auth\|x\\y.failed|auth\|x\\y.failed`; !strings.HasSuffix(header, want) {
+		t.Fatalf("header %q does not end with %q", header, want)
+	}
+	if !strings.HasPrefix(header, "CEF:0|NBRGLM|Nexeres|") {
+		t.Fatalf("unexpected header %q", header)
+	}
+	for _, field := range []string{
+		"rt=1792324800000",
+		"outcome=failure",
+		"src=192.0.2.10",
+		`requestClientApplication=agent\=1\\2\nnext`,
+		`cs4={"reason":"a\=b"}`,
+		"cn1Label=seq cn1=7",
+	} {
+		if !strings.Contains(ext, field) {
+			t.Errorf("extension %q does not contain %q", ext, field)
+		}
+	}
+	if strings.ContainsAny(got, "\r\n") {
+		t.Errorf("the message contains line breaks: %q", got)
+	}
+}
+
+func TestFormatSyslogEscaping(t *testing.T) {
+	log := newTestLog(string(ActionLogout))
+	log.ResourceType = `a"b]c\d`
+	msg := formatSyslog(&config.AuditSyslogConfig{AppName: "nexeres", Facility: 10}, log, []byte("body"))
+
+	got := string(msg)
+	if !strings.HasPrefix(got, "<85>1 ") {
+		t.Errorf("unexpected priority in %q", got)
+	}
+	if want := `resourceType="a\"b\]c\\d"`; !strings.Contains(got, want) {
+		t.Errorf("%q does not contain %q", got, want)
+	}
+	if !strings.HasSuffix(got, "] body") {
+		t.Errorf("unexpected message end in %q", got)
+	}
+}
+
+func TestSinkBufferReplay(t *testing.T) {
+	logging.Logger = zap.NewNop()
+	initSinkMetrics()
+
+	// Reserve an address, and close the listener so that the sink fails.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	cfg := newSyslogSinkConfig("tcp", address, SinkFormatJSON)
+	transport, err := newTransport(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	buffer, err := openDiskBuffer(t.TempDir()+"/siem.buffer", 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &sink{cfg: cfg, transport: transport, buffer: buffer}
+
+	messages := []string{"first", "second", "third"}
+	for _, msg := range messages {
+		s.deliver([]byte(msg))
+	}
+	if want := int64(len("first") + len("second") + len("third") + 3*4); buffer.size != want {
+		t.Fatalf("buffer size is %d, want %d", buffer.size, want)
+	}
+
+	// The listener comes back, the buffered messages are replayed in order.
+	listener, err = net.Listen("tcp", address)
+	if err != nil {
+		t.Skipf("the address %s was taken in between: %v", address, err)
+	}
+	defer listener.Close()
+
+	s.retryBuffer()
+	if buffer.size != 0 {
+		t.Fatalf("buffer size is %d after the replay, want 0", buffer.size)
+	}
+	if _, err := os.Stat(buffer.path); !os.IsNotExist(err) {
+		t.Fatalf("the buffer file was not removed: %v", err)
+	}
+	// New messages are sent directly once the buffer is empty.
+	s.deliver([]byte("fourth"))
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, want := range append(messages, "fourth") {
+		if got := readFrame(t, r); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestDiskBufferPartialDrain(t *testing.T) {
+	buffer, err := openDiskBuffer(t.TempDir()+"/siem.buffer", 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"one", "two", "three"} {
+		if err := buffer.Append([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var sent []string
+	n, err := buffer.Drain(func(msg []byte) error {
+		if string(msg) == "two" {
+			return io.ErrClosedPipe
+		}
+		sent = append(sent, string(msg))
+		return nil
+	})
+	if err != io.ErrClosedPipe || n != 1 || len(sent) != 1 || sent[0] != "one" {
+		t.Fatalf("Drain returned %d, %v, sent %v", n, err, sent)
+	}
+
+	// The unsent messages are kept, in order.
+	sent = nil
+	if n, err := buffer.Drain(func(msg []byte) error {
+		sent = append(sent, string(msg))
+		return nil
+	}); err != nil || n != 2 {
+		t.Fatalf("Drain returned %d, %v", n, err)
+	}
+	if strings.Join(sent, ",") != "two,three" || buffer.size != 0 {
+		t.Fatalf("sent %v, buffer size %d", sent, buffer.size)
+	}
+
+	if err := buffer.Append(make([]byte, 1024*1024)); err != errBufferFull {
+		t.Fatalf("Append returned %v, want errBufferFull", err)
+	}
+}
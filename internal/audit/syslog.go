@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nbrglm/nexeres/config"
+)
+
+// syslogTimeout is the timeout of connecting to the syslog server and of writing a message.
+const syslogTimeout = 5 * time.Second
+
+// syslogTransport sends RFC 5424 messages to a syslog server.
+//
+// UDP messages are sent one per datagram (RFC 5426). TCP and TLS messages are framed with octet counting
+// (RFC 6587, RFC 5425). The connection is established on the first message, and re-established after errors.
+type syslogTransport struct {
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	conn      net.Conn
+}
+
+func newSyslogTransport(cfg *config.AuditSyslogConfig) (*syslogTransport, error) {
+	t := &syslogTransport{network: cfg.Network, address: cfg.Address}
+	if cfg.Network != "tls" {
+		return t, nil
+	}
+
+	host, _, err := net.SplitHostPort(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	t.tlsConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if cfg.TLS == nil {
+		return t, nil
+	}
+	if cfg.TLS.ServerName != "" {
+		t.tlsConfig.ServerName = cfg.TLS.ServerName
+	}
+	t.tlsConfig.InsecureSkipVerify = cfg.TLS.InsecureSkipVerify
+	if cfg.TLS.CAFile != "" {
+		pem, err := os.ReadFile(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in the CA file")
+		}
+		t.tlsConfig.RootCAs = pool
+	}
+	if cfg.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		t.tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return t, nil
+}
+
+func (t *syslogTransport) connect() error {
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+	var err error
+	switch t.network {
+	case "tls":
+		t.conn, err = tls.DialWithDialer(dialer, "tcp", t.address, t.tlsConfig)
+	default:
+		t.conn, err = dialer.Dial(t.network, t.address)
+	}
+	return err
+}
+
+func (t *syslogTransport) Send(msg []byte) error {
+	if t.conn == nil {
+		if err := t.connect(); err != nil {
+			t.conn = nil
+			return err
+		}
+	}
+
+	frame := msg
+	if t.network != "udp" {
+		frame = append(strconv.AppendInt(nil, int64(len(msg)), 10), ' ')
+		frame = append(frame, msg...)
+	}
+
+	t.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := t.conn.Write(frame); err != nil {
+		t.conn.Close()
+		t.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (t *syslogTransport) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
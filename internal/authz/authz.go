@@ -0,0 +1,194 @@
+// Package authz provides relationship-based authorization, modelled after Google's Zanzibar.
+//
+// Relations are stored as tuples in the format `object#relation@subject`, per org, where:
+//   - object is "namespace:id", eg. "document:readme"
+//   - subject is either a user "namespace:id", eg. "user:alice",
+//     or a userset "namespace:id#relation", eg. "team:eng#member" (every member of the team).
+//
+// The namespaces and their relations are configured in the `authz` section of the config file.
+// A relation can also be granted through other relations of the same object (computedUserset),
+// or through relations of related objects (tupleToUserset), eg. viewers of a folder are viewers of its documents.
+package authz
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+)
+
+// schema maps namespace name to relation name to the relation's definition.
+var schema map[string]map[string]config.AuthzRelationConfig
+
+// nameRegex matches the namespace and relation names.
+var nameRegex = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// idRegex matches object and subject IDs.
+var idRegex = regexp.MustCompile(`^[A-Za-z0-9_.@|/+=-]{1,255}$`)
+
+// InitAuthz compiles and validates the namespace schema from the config.
+//
+// It should be called during startup, before any check is made.
+func InitAuthz() error {
+	compiled := make(map[string]map[string]config.AuthzRelationConfig, len(config.Authz.Namespaces))
+	for _, ns := range config.Authz.Namespaces {
+		if !nameRegex.MatchString(ns.Name) {
+			return fmt.Errorf("invalid namespace name %q", ns.Name)
+		}
+		if _, exists := compiled[ns.Name]; exists {
+			return fmt.Errorf("duplicate namespace %q", ns.Name)
+		}
+		relations := make(map[string]config.AuthzRelationConfig, len(ns.Relations))
+		for _, rel := range ns.Relations {
+			if !nameRegex.MatchString(rel.Name) {
+				return fmt.Errorf("invalid relation name %q in namespace %q", rel.Name, ns.Name)
+			}
+			if _, exists := relations[rel.Name]; exists {
+				return fmt.Errorf("duplicate relation %q in namespace %q", rel.Name, ns.Name)
+			}
+			relations[rel.Name] = rel
+		}
+		compiled[ns.Name] = relations
+	}
+
+	// Validate the rewrites, now that all relations are known
+	for nsName, relations := range compiled {
+		for relName, rel := range relations {
+			for _, rewrite := range rel.Union {
+				switch {
+				case rewrite.ComputedUserset != "" && rewrite.TupleToUserset == nil:
+					if _, ok := relations[rewrite.ComputedUserset]; !ok {
+						return fmt.Errorf("relation %s#%s: computedUserset refers to unknown relation %q", nsName, relName, rewrite.ComputedUserset)
+					}
+				case rewrite.ComputedUserset == "" && rewrite.TupleToUserset != nil:
+					if _, ok := relations[rewrite.TupleToUserset.Tupleset]; !ok {
+						return fmt.Errorf("relation %s#%s: tupleToUserset refers to unknown tupleset relation %q", nsName, relName, rewrite.TupleToUserset.Tupleset)
+					}
+					// The computed relation is looked up on the related objects, which can be of any namespace.
+					if !nameRegex.MatchString(rewrite.TupleToUserset.ComputedUserset) {
+						return fmt.Errorf("relation %s#%s: invalid tupleToUserset computedUserset %q", nsName, relName, rewrite.TupleToUserset.ComputedUserset)
+					}
+				default:
+					return fmt.Errorf("relation %s#%s: each union rule must set exactly one of computedUserset or tupleToUserset", nsName, relName)
+				}
+			}
+		}
+	}
+
+	schema = compiled
+	return nil
+}
+
+// getRelation returns the definition of the given relation, if it exists in the schema.
+func getRelation(namespace, relation string) (config.AuthzRelationConfig, bool) {
+	rel, ok := schema[namespace][relation]
+	return rel, ok
+}
+
+// Object is a reference to an object, eg. "document:readme".
+type Object struct {
+	Namespace string `json:"namespace"`
+	ID        string `json:"id"`
+}
+
+func (o Object) String() string {
+	return o.Namespace + ":" + o.ID
+}
+
+// Subject is a reference to a user, eg. "user:alice", or to a userset, eg. "team:eng#member".
+type Subject struct {
+	Namespace string `json:"namespace"`
+	ID        string `json:"id"`
+	// Relation is empty for a direct subject, and set for a userset.
+	Relation string `json:"relation,omitempty"`
+}
+
+func (s Subject) String() string {
+	if s.Relation == "" {
+		return s.Namespace + ":" + s.ID
+	}
+	return s.Namespace + ":" + s.ID + "#" + s.Relation
+}
+
+// Object returns the object the subject refers to, ignoring its relation.
+func (s Subject) Object() Object {
+	return Object{Namespace: s.Namespace, ID: s.ID}
+}
+
+// Tuple is a single relation tuple, `object#relation@subject`.
+type Tuple struct {
+	Object   Object
+	Relation string
+	Subject  Subject
+}
+
+func (t Tuple) String() string {
+	return t.Object.String() + "#" + t.Relation + "@" + t.Subject.String()
+}
+
+// ParseObject parses an object in the format "namespace:id".
+func ParseObject(s string) (Object, error) {
+	ns, id, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok || !nameRegex.MatchString(ns) || !idRegex.MatchString(id) {
+		return Object{}, fmt.Errorf("invalid object %q, expected format 'namespace:id'", s)
+	}
+	return Object{Namespace: ns, ID: id}, nil
+}
+
+// ParseSubject parses a subject in the format "namespace:id" or "namespace:id#relation".
+func ParseSubject(s string) (Subject, error) {
+	objPart, relation, hasRelation := strings.Cut(strings.TrimSpace(s), "#")
+	obj, err := ParseObject(objPart)
+	if err != nil {
+		return Subject{}, fmt.Errorf("invalid subject %q, expected format 'namespace:id' or 'namespace:id#relation'", s)
+	}
+	if hasRelation && !nameRegex.MatchString(relation) {
+		return Subject{}, fmt.Errorf("invalid subject %q, invalid relation %q", s, relation)
+	}
+	return Subject{Namespace: obj.Namespace, ID: obj.ID, Relation: relation}, nil
+}
+
+// ValidateTuple checks that the tuple's relation exists in the schema,
+// and that a userset subject refers to an existing relation.
+func ValidateTuple(t Tuple) error {
+	if _, ok := getRelation(t.Object.Namespace, t.Relation); !ok {
+		return fmt.Errorf("unknown relation %q for namespace %q", t.Relation, t.Object.Namespace)
+	}
+	if t.Subject.Relation != "" {
+		if _, ok := getRelation(t.Subject.Namespace, t.Subject.Relation); !ok {
+			return fmt.Errorf("unknown relation %q for subject namespace %q", t.Subject.Relation, t.Subject.Namespace)
+		}
+	}
+	return nil
+}
+
+// ParseTuple parses a tuple in the format "namespace:id#relation@subject".
+func ParseTuple(s string) (Tuple, error) {
+	objRel, subject, ok := strings.Cut(strings.TrimSpace(s), "@")
+	if !ok {
+		return Tuple{}, fmt.Errorf("invalid tuple %q, expected format 'namespace:id#relation@subject'", s)
+	}
+	objPart, relation, ok := strings.Cut(objRel, "#")
+	if !ok || !nameRegex.MatchString(relation) {
+		return Tuple{}, fmt.Errorf("invalid tuple %q, expected format 'namespace:id#relation@subject'", s)
+	}
+	obj, err := ParseObject(objPart)
+	if err != nil {
+		return Tuple{}, err
+	}
+	sub, err := ParseSubject(subject)
+	if err != nil {
+		return Tuple{}, err
+	}
+	return Tuple{Object: obj, Relation: relation, Subject: sub}, nil
+}
+
+func tupleFromRow(row db.AuthzTuple) Tuple {
+	return Tuple{
+		Object:   Object{Namespace: row.ObjectNamespace, ID: row.ObjectID},
+		Relation: row.Relation,
+		Subject:  Subject{Namespace: row.SubjectNamespace, ID: row.SubjectID, Relation: row.SubjectRelation},
+	}
+}
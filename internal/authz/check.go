@@ -0,0 +1,296 @@
+package authz
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/cache"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"go.uber.org/zap"
+)
+
+// ErrMaxDepthExceeded is returned when following nested relations goes deeper than `authz.maxDepth`.
+var ErrMaxDepthExceeded = errors.New("maximum relation depth exceeded")
+
+// ErrUnknownRelation is returned when the requested relation is not defined in the schema.
+var ErrUnknownRelation = errors.New("unknown relation")
+
+// Check reports whether the subject has the relation on the object, in the given org.
+//
+// The result is cached for `authz.checkCacheTTL` seconds, or until tuples of the org are written.
+func Check(ctx context.Context, q *db.Queries, orgID uuid.UUID, object Object, relation string, subject Subject) (bool, error) {
+	if _, ok := getRelation(object.Namespace, relation); !ok {
+		return false, fmt.Errorf("%w %q for namespace %q", ErrUnknownRelation, relation, object.Namespace)
+	}
+
+	key := ""
+	if config.Authz.CheckCacheTTL > 0 {
+		// Cache failures are not fatal, the check is just evaluated against the database.
+		rev, err := cache.GetAuthzRevision(ctx, orgID.String())
+		if err != nil {
+			logging.Logger.Warn("Failed to get authz revision", zap.Error(err))
+		} else {
+			key = checkCacheKey(orgID, rev, Tuple{Object: object, Relation: relation, Subject: subject})
+			if c, err := cache.GetAuthzCheck(ctx, key); err == nil {
+				return c.Allowed, nil
+			} else if !errors.Is(err, cache.ErrKeyNotFound) {
+				logging.Logger.Warn("Failed to get cached authz check", zap.Error(err))
+			}
+		}
+	}
+
+	allowed, err := check(ctx, q, orgID, object, relation, subject, map[string]bool{}, 0)
+	if err != nil {
+		return false, err
+	}
+
+	if key != "" {
+		if err := cache.StoreAuthzCheck(ctx, key, cache.AuthzCheckData{Allowed: allowed}, time.Duration(config.Authz.CheckCacheTTL)*time.Second); err != nil {
+			logging.Logger.Warn("Failed to cache authz check", zap.Error(err))
+		}
+	}
+	return allowed, nil
+}
+
+// InvalidateChecks invalidates all the cached checks of the given org.
+//
+// It must be called after tuples of the org are written or deleted.
+func InvalidateChecks(ctx context.Context, orgID uuid.UUID) error {
+	if config.Authz.CheckCacheTTL <= 0 {
+		return nil
+	}
+	_, err := cache.BumpAuthzRevision(ctx, orgID.String())
+	return err
+}
+
+func checkCacheKey(orgID uuid.UUID, revision string, t Tuple) string {
+	hash := sha256.Sum256([]byte(t.String()))
+	return fmt.Sprintf("%s:%s:%s", orgID.String(), revision, hex.EncodeToString(hash[:]))
+}
+
+// check evaluates the relation recursively.
+//
+// visited holds the "object#relation" pairs already evaluated during this check,
+// so that cycles in the tuples do not recurse forever.
+func check(ctx context.Context, q *db.Queries, orgID uuid.UUID, object Object, relation string, subject Subject, visited map[string]bool, depth int) (bool, error) {
+	if depth > config.Authz.MaxDepth {
+		return false, ErrMaxDepthExceeded
+	}
+
+	rel, ok := getRelation(object.Namespace, relation)
+	if !ok {
+		// The relation is not defined for this namespace, eg. when followed through a tupleToUserset.
+		return false, nil
+	}
+
+	// A userset trivially contains itself, eg. "team:eng#member" is a member of "team:eng".
+	if subject.Relation == relation && subject.Object() == object {
+		return true, nil
+	}
+
+	visitKey := object.String() + "#" + relation
+	if visited[visitKey] {
+		return false, nil
+	}
+	visited[visitKey] = true
+
+	rows, err := q.ListAuthzTuplesForObject(ctx, db.ListAuthzTuplesForObjectParams{
+		OrgID:           orgID,
+		ObjectNamespace: object.Namespace,
+		ObjectID:        object.ID,
+		Relation:        relation,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to list tuples for %s: %w", visitKey, err)
+	}
+
+	// Direct tuples, and usersets
+	for _, row := range rows {
+		t := tupleFromRow(row)
+		if t.Subject == subject {
+			return true, nil
+		}
+		if t.Subject.Relation != "" {
+			allowed, err := check(ctx, q, orgID, t.Subject.Object(), t.Subject.Relation, subject, visited, depth+1)
+			if err != nil || allowed {
+				return allowed, err
+			}
+		}
+	}
+
+	// Rewrites
+	for _, rewrite := range rel.Union {
+		if rewrite.ComputedUserset != "" {
+			allowed, err := check(ctx, q, orgID, object, rewrite.ComputedUserset, subject, visited, depth+1)
+			if err != nil || allowed {
+				return allowed, err
+			}
+			continue
+		}
+
+		related, err := q.ListAuthzTuplesForObject(ctx, db.ListAuthzTuplesForObjectParams{
+			OrgID:           orgID,
+			ObjectNamespace: object.Namespace,
+			ObjectID:        object.ID,
+			Relation:        rewrite.TupleToUserset.Tupleset,
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to list tuples for %s#%s: %w", object.String(), rewrite.TupleToUserset.Tupleset, err)
+		}
+		for _, row := range related {
+			t := tupleFromRow(row)
+			allowed, err := check(ctx, q, orgID, t.Subject.Object(), rewrite.TupleToUserset.ComputedUserset, subject, visited, depth+1)
+			if err != nil || allowed {
+				return allowed, err
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// ExpandNode is a node of the tree returned by Expand.
+type ExpandNode struct {
+	// Object and Relation identify the userset this node describes.
+	Object   string `json:"object"`
+	Relation string `json:"relation"`
+
+	// Subjects are the subjects directly related to the object, including usersets.
+	Subjects []string `json:"subjects"`
+
+	// Children are the expanded usersets, computed relations and related objects.
+	Children []*ExpandNode `json:"children,omitempty"`
+
+	// Cycle is true if this userset is already expanded higher up in the tree.
+	Cycle bool `json:"cycle,omitempty"`
+}
+
+// Expand returns the tree of all the subjects having the relation on the object.
+func Expand(ctx context.Context, q *db.Queries, orgID uuid.UUID, object Object, relation string) (*ExpandNode, error) {
+	if _, ok := getRelation(object.Namespace, relation); !ok {
+		return nil, fmt.Errorf("%w %q for namespace %q", ErrUnknownRelation, relation, object.Namespace)
+	}
+	return expand(ctx, q, orgID, object, relation, map[string]bool{}, 0)
+}
+
+func expand(ctx context.Context, q *db.Queries, orgID uuid.UUID, object Object, relation string, path map[string]bool, depth int) (*ExpandNode, error) {
+	if depth > config.Authz.MaxDepth {
+		return nil, ErrMaxDepthExceeded
+	}
+
+	node := &ExpandNode{
+		Object:   object.String(),
+		Relation: relation,
+		Subjects: []string{},
+	}
+
+	rel, ok := getRelation(object.Namespace, relation)
+	if !ok {
+		return node, nil
+	}
+
+	visitKey := object.String() + "#" + relation
+	if path[visitKey] {
+		node.Cycle = true
+		return node, nil
+	}
+	path[visitKey] = true
+	defer delete(path, visitKey)
+
+	rows, err := q.ListAuthzTuplesForObject(ctx, db.ListAuthzTuplesForObjectParams{
+		OrgID:           orgID,
+		ObjectNamespace: object.Namespace,
+		ObjectID:        object.ID,
+		Relation:        relation,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tuples for %s: %w", visitKey, err)
+	}
+
+	for _, row := range rows {
+		t := tupleFromRow(row)
+		node.Subjects = append(node.Subjects, t.Subject.String())
+		if t.Subject.Relation != "" {
+			child, err := expand(ctx, q, orgID, t.Subject.Object(), t.Subject.Relation, path, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	for _, rewrite := range rel.Union {
+		if rewrite.ComputedUserset != "" {
+			child, err := expand(ctx, q, orgID, object, rewrite.ComputedUserset, path, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+			continue
+		}
+
+		related, err := q.ListAuthzTuplesForObject(ctx, db.ListAuthzTuplesForObjectParams{
+			OrgID:           orgID,
+			ObjectNamespace: object.Namespace,
+			ObjectID:        object.ID,
+			Relation:        rewrite.TupleToUserset.Tupleset,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tuples for %s#%s: %w", object.String(), rewrite.TupleToUserset.Tupleset, err)
+		}
+		for _, row := range related {
+			t := tupleFromRow(row)
+			child, err := expand(ctx, q, orgID, t.Subject.Object(), rewrite.TupleToUserset.ComputedUserset, path, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	return node, nil
+}
+
+// ListObjects returns the IDs of the objects of the namespace on which the subject has the relation.
+//
+// Only objects that appear in at least one tuple are candidates, and at most `authz.listObjectsLimit`
+// candidates are evaluated. The second return value is true if the candidates were truncated.
+func ListObjects(ctx context.Context, q *db.Queries, orgID uuid.UUID, namespace, relation string, subject Subject) ([]string, bool, error) {
+	if _, ok := getRelation(namespace, relation); !ok {
+		return nil, false, fmt.Errorf("%w %q for namespace %q", ErrUnknownRelation, relation, namespace)
+	}
+
+	// Fetch one more than the limit, to know if the result is truncated
+	candidates, err := q.ListAuthzObjectIDs(ctx, db.ListAuthzObjectIDsParams{
+		OrgID:           orgID,
+		ObjectNamespace: namespace,
+		Limit:           int32(config.Authz.ListObjectsLimit + 1),
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list candidate objects: %w", err)
+	}
+
+	truncated := len(candidates) > config.Authz.ListObjectsLimit
+	if truncated {
+		candidates = candidates[:config.Authz.ListObjectsLimit]
+	}
+
+	objects := []string{}
+	for _, id := range candidates {
+		allowed, err := Check(ctx, q, orgID, Object{Namespace: namespace, ID: id}, relation, subject)
+		if err != nil {
+			return nil, false, err
+		}
+		if allowed {
+			objects = append(objects, id)
+		}
+	}
+	return objects, truncated, nil
+}
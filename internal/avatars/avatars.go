@@ -0,0 +1,263 @@
+// Package avatars processes and stores the avatars of users.
+//
+// An uploaded image is decoded and re-encoded, which strips its metadata, eg. EXIF location data,
+// cropped to a square and resized to every standard size. Every upload gets a new ID,
+// so the public objects of an avatar never change and can be cached indefinitely.
+package avatars
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // Register the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/store"
+	"go.uber.org/zap"
+)
+
+const (
+	// MaxUploadSize is the maximum size, in bytes, of an uploaded image.
+	MaxUploadSize = 5 << 20 // 5 MiB
+
+	// maxDimension is the maximum width and height, in pixels, of an uploaded image,
+	// checked before decoding it, so that small files cannot decode to huge images.
+	maxDimension = 4096
+
+	// DefaultSize is the size of the avatar stored as the user's avatar URL.
+	DefaultSize = 256
+
+	// cacheControl of the avatar objects, which never change.
+	cacheControl = "public, max-age=31536000, immutable"
+)
+
+// Sizes are the standard sizes, in pixels, avatars are resized to.
+var Sizes = []int{64, 128, 256, 512}
+
+var (
+	// ErrUnsupportedType is returned when the image is not a JPEG, PNG or GIF image.
+	ErrUnsupportedType = errors.New("unsupported image type, use a JPEG, PNG or GIF image")
+	// ErrTooLarge is returned when the image exceeds MaxUploadSize bytes, or maxDimension pixels.
+	ErrTooLarge = errors.New("image too large")
+	// ErrInvalidImage is returned when the image cannot be decoded.
+	ErrInvalidImage = errors.New("invalid image")
+)
+
+// supportedTypes maps the content types of the supported images to the format they are re-encoded in.
+// JPEG images stay JPEG, others are re-encoded as PNG to keep their transparency.
+var supportedTypes = map[string]string{
+	"image/jpeg": "image/jpeg",
+	"image/png":  "image/png",
+	"image/gif":  "image/png",
+}
+
+// Avatar is a processed avatar, with an image per standard size.
+type Avatar struct {
+	// ContentType of the images, "image/jpeg" or "image/png".
+	ContentType string
+	// Images are the encoded images, by size.
+	Images map[int][]byte
+}
+
+// Key returns the object store key (without the 'public/' prefix) of an avatar image.
+func Key(userID, avatarID string, size int, contentType string) string {
+	return fmt.Sprintf("users/%s/avatars/%s/%d%s", userID, avatarID, size, extension(contentType))
+}
+
+// IsSupportedType returns whether images of the content type can be uploaded as avatars.
+func IsSupportedType(contentType string) bool {
+	_, ok := supportedTypes[contentType]
+	return ok
+}
+
+// Process decodes the image, checks its content type and dimensions, and returns it cropped and resized to every standard size.
+func Process(data []byte) (*Avatar, error) {
+	if len(data) > MaxUploadSize {
+		return nil, ErrTooLarge
+	}
+	// The content type is detected from the data, since the one declared by the client cannot be trusted.
+	outputType, ok := supportedTypes[http.DetectContentType(data)]
+	if !ok {
+		return nil, ErrUnsupportedType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if cfg.Width > maxDimension || cfg.Height > maxDimension {
+		return nil, ErrTooLarge
+	}
+	if cfg.Width < 1 || cfg.Height < 1 {
+		return nil, ErrInvalidImage
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	square := cropSquare(img)
+
+	avatar := &Avatar{ContentType: outputType, Images: map[int][]byte{}}
+	for _, size := range Sizes {
+		var buf bytes.Buffer
+		resized := resize(square, size)
+		if outputType == "image/jpeg" {
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 90})
+		} else {
+			err = png.Encode(&buf, resized)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode the %dpx avatar: %w", size, err)
+		}
+		avatar.Images[size] = buf.Bytes()
+	}
+	return avatar, nil
+}
+
+// Upload stores the images of the avatar publicly, and returns their URLs by size.
+// If an upload fails, the images already uploaded are deleted.
+func Upload(ctx context.Context, userID uuid.UUID, avatar *Avatar) (map[int]string, error) {
+	avatarID := uuid.NewString()
+	urls := map[int]string{}
+	var keys []string
+	for _, size := range Sizes {
+		key, err := store.Objects.UploadPublicObject(ctx, Key(userID.String(), avatarID, size, avatar.ContentType), bytes.NewReader(avatar.Images[size]), avatar.ContentType, cacheControl)
+		if err == nil {
+			keys = append(keys, key)
+			urls[size], err = store.Objects.GetObjectURL(ctx, key)
+		}
+		if err != nil {
+			for _, key := range keys {
+				if delErr := store.Objects.DeleteObject(ctx, key); delErr != nil {
+					logging.Logger.Warn("Failed to delete a partially uploaded avatar", zap.String("key", key), zap.Error(delErr))
+				}
+			}
+			return nil, fmt.Errorf("failed to upload the %dpx avatar: %w", size, err)
+		}
+	}
+	return urls, nil
+}
+
+// Delete deletes every image of the avatar with the given URL, if it was uploaded by the user.
+// It is a no-op for avatars stored elsewhere, eg. the pictures of OAuth identities.
+func Delete(ctx context.Context, userID uuid.UUID, avatarURL *string) error {
+	if avatarURL == nil {
+		return nil
+	}
+	prefix := "public/users/" + userID.String() + "/avatars/"
+	i := strings.Index(*avatarURL, prefix)
+	if i < 0 {
+		return nil
+	}
+	rest, _, _ := strings.Cut((*avatarURL)[i+len(prefix):], "?")
+	avatarID, _, ok := strings.Cut(rest, "/")
+	if !ok || uuid.Validate(avatarID) != nil {
+		return nil
+	}
+
+	keys, err := store.Objects.ListObjects(ctx, prefix+avatarID+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list the images of the avatar %s: %w", avatarID, err)
+	}
+	for _, key := range keys {
+		if err := store.Objects.DeleteObject(ctx, key); err != nil && !errors.Is(err, store.ErrObjectNotFound) {
+			return fmt.Errorf("failed to delete the avatar %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// extension returns the file extension of the images of the content type.
+func extension(contentType string) string {
+	if contentType == "image/jpeg" {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+// cropSquare returns the centered square of the image, as RGBA.
+func cropSquare(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	origin := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, origin, draw.Src)
+	return square
+}
+
+// contribution is the weight of a source pixel in a resized pixel.
+type contribution struct {
+	index  int
+	weight float32
+}
+
+// weights returns, for every pixel of a row of the given size, the contributions of the pixels of a source row of n pixels.
+// Every resized pixel is the average of the source pixels it covers, weighted by their coverage.
+func weights(n, size int) [][]contribution {
+	scale := float64(n) / float64(size)
+	result := make([][]contribution, size)
+	for i := range size {
+		lo, hi := float64(i)*scale, float64(i+1)*scale
+		var total float64
+		for j := int(lo); j < n && float64(j) < hi; j++ {
+			w := min(hi, float64(j+1)) - max(lo, float64(j))
+			if w <= 0 {
+				continue
+			}
+			result[i] = append(result[i], contribution{index: j, weight: float32(w)})
+			total += w
+		}
+		for k := range result[i] {
+			result[i][k].weight /= float32(total)
+		}
+	}
+	return result
+}
+
+// resize returns the square image resized to size x size pixels, horizontally then vertically.
+func resize(src *image.RGBA, size int) *image.RGBA {
+	n := src.Bounds().Dx()
+	ws := weights(n, size)
+
+	// tmp holds the n rows of the source, each resized to size pixels.
+	tmp := make([]float32, n*size*4)
+	for y := range n {
+		row := src.Pix[y*src.Stride:]
+		for x, cs := range ws {
+			o := (y*size + x) * 4
+			for _, c := range cs {
+				p := c.index * 4
+				for ch := range 4 {
+					tmp[o+ch] += float32(row[p+ch]) * c.weight
+				}
+			}
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y, cs := range ws {
+		for x := range size {
+			var px [4]float32
+			for _, c := range cs {
+				o := (c.index*size + x) * 4
+				for ch := range 4 {
+					px[ch] += tmp[o+ch] * c.weight
+				}
+			}
+			o := y*dst.Stride + x*4
+			for ch := range 4 {
+				dst.Pix[o+ch] = uint8(min(max(px[ch]+0.5, 0), 255))
+			}
+		}
+	}
+	return dst
+}
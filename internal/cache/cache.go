@@ -2,6 +2,7 @@ package cache
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -11,31 +12,34 @@ import (
 	"github.com/eko/gocache/lib/v4/store"
 	redis_store "github.com/eko/gocache/store/redis/v4"
 	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
 	"github.com/nbrglm/nexeres/internal/models"
+	nexeresStore "github.com/nbrglm/nexeres/internal/store"
 	"github.com/nbrglm/nexeres/opts"
-	"github.com/redis/go-redis/v9"
 )
 
 var cached *marshaler.Marshaler
 
+// InitCache initializes the cache, with the backend selected in the config, see config.CacheConfig.
+//
+// The Redis backend uses the shared client of the store package, so store.InitRedis must be called first.
 func InitCache() error {
-	redisPassword := ""
-	if config.Stores.Redis.Password != nil {
-		redisPassword = *config.Stores.Redis.Password
-	}
-	redisOpts := redis.Options{
-		Addr:     config.Stores.Redis.Address,
-		Password: redisPassword,
-		DB:       config.Stores.Redis.DB,
+	var cacheStore store.StoreInterface
+	switch config.Stores.Cache.Backend {
+	case "redis":
+		if nexeresStore.Redis == nil {
+			return fmt.Errorf("redis client is not initialized")
+		}
+		cacheStore = redis_store.NewRedis(nexeresStore.Redis)
+	case "memory":
+		cacheStore = NewMemoryStore(config.Stores.Cache.MaxEntries)
+	default:
+		return fmt.Errorf("unknown cache backend: %s", config.Stores.Cache.Backend)
 	}
-	redisClient := redis.NewClient(&redisOpts)
-
-	// Initialize the Redis cache
-	redisStore := redis_store.NewRedis(redisClient)
 
 	metrics := cache_metrics.NewPrometheus(fmt.Sprintf("%s_cache", opts.Name))
 
-	cacheManager := gocache.NewMetric(metrics, redisStore)
+	cacheManager := gocache.NewMetric(metrics, cacheStore)
 	cached = marshaler.New(cacheManager)
 	return nil
 }
@@ -159,3 +163,154 @@ func GetAdminSession(ctx context.Context, token string) (*AdminSessionData, erro
 		}
 	}
 }
+
+// AuthzCheckData is the cached result of a relationship-based authorization check.
+type AuthzCheckData struct {
+	Allowed bool `json:"allowed"`
+}
+
+type authzRevisionData struct {
+	Revision string `json:"revision"`
+}
+
+// The revision key lives longer than any check, so that cached checks are never served for a stale revision.
+const authzRevisionExpiration = 24 * time.Hour
+
+// GetAuthzRevision returns the current revision of the tuples of an org.
+//
+// Cached checks are keyed by the revision, so changing it invalidates all cached checks of the org.
+// If no revision exists yet, a new one is created.
+func GetAuthzRevision(ctx context.Context, orgId string) (string, error) {
+	rev, err := cached.Get(ctx, fmt.Sprintf("nexeres_authz_revision:%s", orgId), new(authzRevisionData))
+	if err != nil {
+		if err.Error() == store.NOT_FOUND_ERR {
+			return BumpAuthzRevision(ctx, orgId)
+		}
+		return "", fmt.Errorf("failed to get authz revision: %w", err)
+	}
+	if r, ok := rev.(*authzRevisionData); !ok || r == nil {
+		return "", fmt.Errorf("invalid authz revision data stored")
+	} else {
+		return r.Revision, nil
+	}
+}
+
+// BumpAuthzRevision sets a new revision for the tuples of an org, invalidating all its cached checks.
+func BumpAuthzRevision(ctx context.Context, orgId string) (string, error) {
+	rev := fmt.Sprintf("%d", time.Now().UnixNano())
+	if err := cached.Set(ctx, fmt.Sprintf("nexeres_authz_revision:%s", orgId), authzRevisionData{Revision: rev}, store.WithExpiration(authzRevisionExpiration)); err != nil {
+		return "", fmt.Errorf("failed to set authz revision: %w", err)
+	}
+	return rev, nil
+}
+
+func StoreAuthzCheck(ctx context.Context, key string, check AuthzCheckData, exp time.Duration) error {
+	return cached.Set(ctx, fmt.Sprintf("nexeres_authz_check:%s", key), check, store.WithExpiration(exp))
+}
+
+// GetAuthzCheck retrieves a cached authorization check result by its key.
+//
+// IMP: DO NOT RETURN nil for error if check is not found, return a specific error instead.
+func GetAuthzCheck(ctx context.Context, key string) (*AuthzCheckData, error) {
+	if check, err := cached.Get(ctx, fmt.Sprintf("nexeres_authz_check:%s", key), new(AuthzCheckData)); err != nil {
+		if err.Error() == store.NOT_FOUND_ERR {
+			return nil, ErrKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get authz check: %w", err)
+	} else {
+		if c, ok := check.(*AuthzCheckData); !ok || c == nil {
+			return nil, fmt.Errorf("invalid authz check data stored")
+		} else {
+			return c, nil
+		}
+	}
+}
+
+// HookResultData is the cached response of a login hook.
+type HookResultData struct {
+	Response json.RawMessage `json:"response"`
+}
+
+func StoreHookResult(ctx context.Context, key string, result HookResultData, exp time.Duration) error {
+	return cached.Set(ctx, fmt.Sprintf("nexeres_hook_result:%s", key), result, store.WithExpiration(exp))
+}
+
+// GetHookResult retrieves a cached hook response by its key.
+//
+// IMP: DO NOT RETURN nil for error if result is not found, return a specific error instead.
+func GetHookResult(ctx context.Context, key string) (*HookResultData, error) {
+	if result, err := cached.Get(ctx, fmt.Sprintf("nexeres_hook_result:%s", key), new(HookResultData)); err != nil {
+		if err.Error() == store.NOT_FOUND_ERR {
+			return nil, ErrKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get hook result: %w", err)
+	} else {
+		if r, ok := result.(*HookResultData); !ok || r == nil {
+			return nil, fmt.Errorf("invalid hook result data stored")
+		} else {
+			return r, nil
+		}
+	}
+}
+
+// StoreAPIKey caches an API key stored in the database, by its prefix.
+func StoreAPIKey(ctx context.Context, key db.ApiKey, exp time.Duration) error {
+	return cached.Set(ctx, fmt.Sprintf("nexeres_api_key:%s", key.Prefix), key, store.WithExpiration(exp))
+}
+
+// GetAPIKey retrieves a cached API key by its prefix.
+//
+// IMP: DO NOT RETURN nil for error if key is not found, return a specific error instead.
+func GetAPIKey(ctx context.Context, prefix string) (*db.ApiKey, error) {
+	if key, err := cached.Get(ctx, fmt.Sprintf("nexeres_api_key:%s", prefix), new(db.ApiKey)); err != nil {
+		if err.Error() == store.NOT_FOUND_ERR {
+			return nil, ErrKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	} else {
+		if k, ok := key.(*db.ApiKey); !ok || k == nil {
+			return nil, fmt.Errorf("invalid API key data stored")
+		} else {
+			return k, nil
+		}
+	}
+}
+
+// DeleteAPIKey removes a cached API key, eg. after it is revoked, so that every instance reads it again.
+func DeleteAPIKey(ctx context.Context, prefix string) error {
+	if err := cached.Delete(ctx, fmt.Sprintf("nexeres_api_key:%s", prefix)); err != nil && err.Error() != store.NOT_FOUND_ERR {
+		return fmt.Errorf("failed to delete API key: %w", err)
+	}
+	return nil
+}
+
+// StoreTenant caches the organization of a tenant host, by its slug.
+func StoreTenant(ctx context.Context, org db.Org, exp time.Duration) error {
+	return cached.Set(ctx, fmt.Sprintf("nexeres_tenant:%s", org.Slug), org, store.WithExpiration(exp))
+}
+
+// GetTenant retrieves the cached organization of a tenant host by its slug.
+//
+// IMP: DO NOT RETURN nil for error if org is not found, return a specific error instead.
+func GetTenant(ctx context.Context, slug string) (*db.Org, error) {
+	if org, err := cached.Get(ctx, fmt.Sprintf("nexeres_tenant:%s", slug), new(db.Org)); err != nil {
+		if err.Error() == store.NOT_FOUND_ERR {
+			return nil, ErrKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	} else {
+		if o, ok := org.(*db.Org); !ok || o == nil {
+			return nil, fmt.Errorf("invalid tenant data stored")
+		} else {
+			return o, nil
+		}
+	}
+}
+
+// DeleteTenant removes the cached organization of a tenant host, eg. after its settings are updated.
+func DeleteTenant(ctx context.Context, slug string) error {
+	if err := cached.Delete(ctx, fmt.Sprintf("nexeres_tenant:%s", slug)); err != nil && err.Error() != store.NOT_FOUND_ERR {
+		return fmt.Errorf("failed to delete tenant: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eko/gocache/lib/v4/store"
+)
+
+// MemoryType is the type of the MemoryStore.
+const MemoryType = "memory"
+
+// MemoryStore is an in-process cache store, with expiration and a bound on the number of entries.
+// When it is full, the least recently used entries are evicted.
+//
+// It implements the store interface of gocache, so that it can replace the Redis store,
+// eg. for single instance deployments and tests.
+type MemoryStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	// entries holds the elements of lru, by key.
+	entries map[string]*list.Element
+	// lru holds the entries, the most recently used first.
+	lru *list.List
+	// tags holds the keys of the entries with each tag.
+	tags map[string]map[string]struct{}
+}
+
+type memoryEntry struct {
+	key       string
+	value     any
+	tags      []string
+	expiresAt time.Time // zero if the entry does not expire
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// NewMemoryStore creates a MemoryStore which holds at most maxEntries entries, or an unbounded one if maxEntries is 0.
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+		tags:       map[string]map[string]struct{}{},
+	}
+}
+
+// Get returns the value of the key, or a store.NotFound error if it does not exist or has expired.
+func (s *MemoryStore) Get(ctx context.Context, key any) (any, error) {
+	value, _, err := s.GetWithTTL(ctx, key)
+	return value, err
+}
+
+// GetWithTTL returns the value of the key and its remaining time to live, 0 if it does not expire.
+func (s *MemoryStore) GetWithTTL(_ context.Context, key any) (any, time.Duration, error) {
+	k, err := memoryKey(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[k]
+	if !ok {
+		return nil, 0, store.NotFoundWithCause(errors.New("key not found"))
+	}
+	entry := elem.Value.(*memoryEntry)
+	now := time.Now()
+	if entry.expired(now) {
+		s.remove(elem)
+		return nil, 0, store.NotFoundWithCause(errors.New("key expired"))
+	}
+	s.lru.MoveToFront(elem)
+
+	var ttl time.Duration
+	if !entry.expiresAt.IsZero() {
+		ttl = entry.expiresAt.Sub(now)
+	}
+	return entry.value, ttl, nil
+}
+
+// Set stores the value of the key, with the expiration and tags of the options.
+func (s *MemoryStore) Set(_ context.Context, key any, value any, options ...store.Option) error {
+	k, err := memoryKey(key)
+	if err != nil {
+		return err
+	}
+	opts := store.ApplyOptions(options...)
+
+	entry := &memoryEntry{key: k, value: value, tags: opts.Tags}
+	if opts.Expiration > 0 {
+		entry.expiresAt = time.Now().Add(opts.Expiration)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[k]; ok {
+		s.remove(elem)
+	}
+	s.entries[k] = s.lru.PushFront(entry)
+	for _, tag := range entry.tags {
+		if s.tags[tag] == nil {
+			s.tags[tag] = map[string]struct{}{}
+		}
+		s.tags[tag][k] = struct{}{}
+	}
+
+	if s.maxEntries > 0 && s.lru.Len() > s.maxEntries {
+		s.evict()
+	}
+	return nil
+}
+
+// Delete removes the key.
+func (s *MemoryStore) Delete(_ context.Context, key any) error {
+	k, err := memoryKey(key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[k]; ok {
+		s.remove(elem)
+	}
+	return nil
+}
+
+// Invalidate removes the entries with the tags of the options.
+func (s *MemoryStore) Invalidate(_ context.Context, options ...store.InvalidateOption) error {
+	opts := store.ApplyInvalidateOptions(options...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, tag := range opts.Tags {
+		for k := range s.tags[tag] {
+			if elem, ok := s.entries[k]; ok {
+				s.remove(elem)
+			}
+		}
+		delete(s.tags, tag)
+	}
+	return nil
+}
+
+// Clear removes all the entries.
+func (s *MemoryStore) Clear(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = map[string]*list.Element{}
+	s.lru.Init()
+	s.tags = map[string]map[string]struct{}{}
+	return nil
+}
+
+// GetType returns the type of the store, "memory".
+func (s *MemoryStore) GetType() string {
+	return MemoryType
+}
+
+// evict removes the least recently used entries until the store is not over its bound.
+// Expired entries are removed when they are read, or evicted like the others.
+//
+// s.mu must be held.
+func (s *MemoryStore) evict() {
+	for s.lru.Len() > s.maxEntries {
+		s.remove(s.lru.Back())
+	}
+}
+
+// remove removes the entry of the element.
+//
+// s.mu must be held.
+func (s *MemoryStore) remove(elem *list.Element) {
+	entry := s.lru.Remove(elem).(*memoryEntry)
+	delete(s.entries, entry.key)
+	for _, tag := range entry.tags {
+		delete(s.tags[tag], entry.key)
+		if len(s.tags[tag]) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+}
+
+func memoryKey(key any) (string, error) {
+	k, ok := key.(string)
+	if !ok {
+		return "", fmt.Errorf("invalid cache key type %T, expected string", key)
+	}
+	return k, nil
+}
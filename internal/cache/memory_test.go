@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eko/gocache/lib/v4/store"
+)
+
+// assertKeys checks which of the keys are present in the store.
+func assertKeys(t *testing.T, s *MemoryStore, present []string, missing []string) {
+	t.Helper()
+	for _, key := range present {
+		if _, err := s.Get(t.Context(), key); err != nil {
+			t.Errorf("Get(%q) = %v, want the value", key, err)
+		}
+	}
+	for _, key := range missing {
+		if _, err := s.Get(t.Context(), key); !errors.Is(err, store.NotFound{}) {
+			t.Errorf("Get(%q) = %v, want a NotFound error", key, err)
+		}
+	}
+}
+
+func TestMemoryStoreGetSet(t *testing.T) {
+	s := NewMemoryStore(0)
+	if err := s.Set(t.Context(), "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	value, ttl, err := s.GetWithTTL(t.Context(), "a")
+	if err != nil || value != "1" || ttl != 0 {
+		t.Fatalf("GetWithTTL = %v, %v, %v, want 1, 0, nil", value, ttl, err)
+	}
+
+	// Setting a key again replaces its value.
+	if err := s.Set(t.Context(), "a", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := s.Get(t.Context(), "a"); err != nil || value != "2" {
+		t.Fatalf("Get = %v, %v, want 2", value, err)
+	}
+
+	if err := s.Delete(t.Context(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, s, nil, []string{"a"})
+
+	if err := s.Set(t.Context(), 1, "x"); err == nil {
+		t.Errorf("Set with a non string key succeeded")
+	}
+	if s.GetType() != MemoryType {
+		t.Errorf("GetType = %q", s.GetType())
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	s := NewMemoryStore(0)
+	if err := s.Set(t.Context(), "short", "1", store.WithExpiration(20*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(t.Context(), "long", "2", store.WithExpiration(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(t.Context(), "forever", "3"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, ttl, err := s.GetWithTTL(t.Context(), "long")
+	if err != nil || ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Fatalf("GetWithTTL = %v, %v, want a TTL of about an hour", ttl, err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	assertKeys(t, s, []string{"long", "forever"}, []string{"short"})
+
+	// The expired entry is removed when it is read.
+	s.mu.Lock()
+	_, ok := s.entries["short"]
+	n := s.lru.Len()
+	s.mu.Unlock()
+	if ok || n != 2 {
+		t.Errorf("the expired entry was not removed, %d entries", n)
+	}
+}
+
+func TestMemoryStoreLRUEviction(t *testing.T) {
+	s := NewMemoryStore(3)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := s.Set(t.Context(), key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reading "a" makes "b" the least recently used entry.
+	if _, err := s.Get(t.Context(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(t.Context(), "d", "d"); err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, s, []string{"a", "c", "d"}, []string{"b"})
+
+	// assertKeys read "a", "c" and "d" in that order, so "a" is the least recently used entry,
+	// and setting "c" again uses it once more.
+	if err := s.Set(t.Context(), "c", "c2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(t.Context(), "e", "e"); err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, s, []string{"c", "d", "e"}, []string{"a", "b"})
+
+	if n := s.lru.Len(); n != 3 {
+		t.Errorf("the store holds %d entries, want 3", n)
+	}
+}
+
+func TestMemoryStoreInvalidate(t *testing.T) {
+	s := NewMemoryStore(0)
+	for key, tags := range map[string][]string{
+		"org1:a": {"org1"},
+		"org1:b": {"org1", "users"},
+		"org2:a": {"org2", "users"},
+		"none":   nil,
+	} {
+		if err := s.Set(t.Context(), key, key, store.WithTags(tags)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Invalidate(t.Context(), store.WithInvalidateTags([]string{"org1"})); err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, s, []string{"org2:a", "none"}, []string{"org1:a", "org1:b"})
+
+	// The removed entries are no longer listed under their other tags.
+	if _, ok := s.tags["users"]["org1:b"]; ok {
+		t.Errorf("the invalidated entry is still listed under its other tag")
+	}
+
+	if err := s.Invalidate(t.Context(), store.WithInvalidateTags([]string{"users", "unknown"})); err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, s, []string{"none"}, []string{"org2:a"})
+	if len(s.tags) != 0 {
+		t.Errorf("tags %v are left after the invalidation", s.tags)
+	}
+
+	// An entry set again without its tag is not invalidated by it.
+	if err := s.Set(t.Context(), "k", "1", store.WithTags([]string{"t"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(t.Context(), "k", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Invalidate(t.Context(), store.WithInvalidateTags([]string{"t"})); err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, s, []string{"k"}, nil)
+
+	if err := s.Clear(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, s, nil, []string{"k", "none"})
+}
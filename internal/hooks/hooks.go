@@ -0,0 +1,309 @@
+// Package hooks calls the configured HTTP hooks during logins, so that external systems can block logins,
+// or add custom claims to the session tokens, eg. entitlements from a billing system.
+//
+// Hooks are called synchronously, in the order they are configured, and every request is signed with the
+// hook's secret, like the webhook deliveries. A hook which fails or times out fails the login, unless it is
+// configured to fail open, in which case its result is ignored.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/internal/cache"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/webhooks"
+	"github.com/nbrglm/nexeres/opts"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Headers of a hook request.
+const (
+	HeaderTimestamp = "X-Nexeres-Hook-Timestamp"
+	HeaderSignature = "X-Nexeres-Hook-Signature"
+)
+
+// Kinds of the hook requests.
+const (
+	KindPreLogin = "pre_login"
+	KindPreToken = "pre_token"
+)
+
+// Triggers of the pre-token hooks.
+const (
+	TriggerLogin   = "login"
+	TriggerRefresh = "refresh"
+)
+
+// Decision is the result of the pre-login hooks.
+type Decision string
+
+const (
+	DecisionAllow Decision = "allow"
+	DecisionDeny  Decision = "deny"
+	// DecisionRequireMFA is reserved, and is rejected as an invalid decision by PreLogin,
+	// since there is no endpoint to complete multi-factor authentication yet.
+	DecisionRequireMFA Decision = "require_mfa"
+)
+
+// maxResponseSize is the maximum size of a hook response.
+const maxResponseSize = 64 * 1024
+
+// ErrHookFailed is returned when a hook, which does not fail open, fails or times out.
+var ErrHookFailed = errors.New("hook failed")
+
+var (
+	client = &http.Client{
+		// Redirects are not followed, so that the signed request is only sent to the configured URL.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	callsCounter *prometheus.CounterVec
+	callDuration *prometheus.HistogramVec
+)
+
+// InitHooks registers the hook metrics.
+//
+// It must be called before metrics.InitMetrics.
+func InitHooks() {
+	callsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "nexeres",
+			Subsystem: "hooks",
+			Name:      "calls",
+			Help:      "Total number of hook calls, by status: success, cached, failed (login failed), failed_open (result ignored)",
+		},
+		[]string{"hook", "status"},
+	)
+	callDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "nexeres",
+			Subsystem: "hooks",
+			Name:      "call_duration_seconds",
+			Help:      "Duration of the hook requests",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+		[]string{"hook"},
+	)
+	metrics.Collectors = append(metrics.Collectors, callsCounter, callDuration)
+}
+
+// User is the user logging in.
+type User struct {
+	ID            uuid.UUID `json:"id"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"emailVerified"`
+	FirstName     *string   `json:"firstName,omitempty"`
+	LastName      *string   `json:"lastName,omitempty"`
+}
+
+// Org is the organization the user logs in to.
+type Org struct {
+	ID   uuid.UUID `json:"id"`
+	Slug string    `json:"slug"`
+	Name string    `json:"name"`
+}
+
+// Request is the body of a hook request.
+type Request struct {
+	// Kind is "pre_login" or "pre_token".
+	Kind string `json:"kind"`
+	// Trigger of a pre-token request, "login" or "refresh".
+	Trigger string `json:"trigger,omitempty"`
+
+	User User   `json:"user"`
+	Org  Org    `json:"org"`
+	Role string `json:"role"`
+	// Permissions of the role, only sent to the pre-token hooks.
+	Permissions []string `json:"permissions,omitempty"`
+
+	IPAddress string `json:"ipAddress"`
+	UserAgent string `json:"userAgent"`
+}
+
+// preLoginResponse is the response of a pre-login hook.
+type preLoginResponse struct {
+	Decision Decision `json:"decision"`
+	// Message is shown to the user if the login is denied.
+	Message string `json:"message,omitempty"`
+}
+
+// preTokenResponse is the response of a pre-token hook.
+type preTokenResponse struct {
+	Claims map[string]any `json:"claims"`
+}
+
+// LoginResult is the combined result of the pre-login hooks.
+type LoginResult struct {
+	Decision Decision
+	// Message of the hook which denied the login, if any.
+	Message string
+}
+
+// PreLogin calls the pre-login hooks, and returns the first denial, otherwise allows the login.
+//
+// It returns ErrHookFailed if a hook which does not fail open fails, or responds with an unsupported decision.
+func PreLogin(ctx context.Context, req Request) (*LoginResult, error) {
+	req.Kind = KindPreLogin
+	for i := range config.Hooks.PreLogin {
+		hook := &config.Hooks.PreLogin[i]
+		var resp preLoginResponse
+		ok, err := call(ctx, hook, &req, &resp, func() error {
+			switch resp.Decision {
+			case DecisionAllow, DecisionDeny:
+				return nil
+			case DecisionRequireMFA:
+				return fmt.Errorf("decision %q is not supported yet, multi-factor authentication cannot be completed", resp.Decision)
+			}
+			return fmt.Errorf("invalid decision %q", resp.Decision)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if resp.Decision == DecisionDeny {
+			return &LoginResult{Decision: DecisionDeny, Message: resp.Message}, nil
+		}
+	}
+	return &LoginResult{Decision: DecisionAllow}, nil
+}
+
+// PreToken calls the pre-token hooks, and returns their claims by namespace, to be added to the "ext" claim.
+//
+// It returns ErrHookFailed if a hook which does not fail open fails.
+func PreToken(ctx context.Context, req Request) (map[string]any, error) {
+	req.Kind = KindPreToken
+	var claims map[string]any
+	for i := range config.Hooks.PreToken {
+		hook := &config.Hooks.PreToken[i]
+		var resp preTokenResponse
+		ok, err := call(ctx, hook, &req, &resp, nil)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || len(resp.Claims) == 0 {
+			continue
+		}
+		if claims == nil {
+			claims = make(map[string]any, len(config.Hooks.PreToken))
+		}
+		claims[hook.Namespace] = resp.Claims
+	}
+	return claims, nil
+}
+
+// call calls the hook, or returns its cached response, and decodes it into resp.
+//
+// It returns false if the hook failed open, in which case its result must be ignored,
+// and ErrHookFailed if the hook failed closed.
+func call(ctx context.Context, hook *config.HookConfig, req *Request, resp any, validate func() error) (bool, error) {
+	key := ""
+	if hook.CacheTTL > 0 {
+		var err error
+		if key, err = cacheKey(hook, req); err != nil {
+			return false, err
+		}
+		// Cache failures are not fatal, the hook is just called.
+		if cached, err := cache.GetHookResult(ctx, key); err == nil {
+			if err := json.Unmarshal(cached.Response, resp); err == nil {
+				callsCounter.WithLabelValues(hook.Name, "cached").Inc()
+				return true, nil
+			}
+		} else if !errors.Is(err, cache.ErrKeyNotFound) {
+			logging.Logger.Warn("Failed to get cached hook result", zap.String("hook", hook.Name), zap.Error(err))
+		}
+	}
+
+	body, err := send(ctx, hook, req)
+	if err == nil {
+		err = json.Unmarshal(body, resp)
+	}
+	if err == nil && validate != nil {
+		err = validate()
+	}
+	if err != nil {
+		if hook.FailOpen {
+			callsCounter.WithLabelValues(hook.Name, "failed_open").Inc()
+			logging.Logger.Warn("Hook failed, ignoring its result", zap.String("hook", hook.Name), zap.Error(err))
+			return false, nil
+		}
+		callsCounter.WithLabelValues(hook.Name, "failed").Inc()
+		logging.Logger.Error("Hook failed", zap.String("hook", hook.Name), zap.Error(err))
+		return false, fmt.Errorf("%w: %s: %v", ErrHookFailed, hook.Name, err)
+	}
+	callsCounter.WithLabelValues(hook.Name, "success").Inc()
+
+	if key != "" {
+		if err := cache.StoreHookResult(ctx, key, cache.HookResultData{Response: body}, time.Duration(hook.CacheTTL)*time.Second); err != nil {
+			logging.Logger.Warn("Failed to cache hook result", zap.String("hook", hook.Name), zap.Error(err))
+		}
+	}
+	return true, nil
+}
+
+// send POSTs the signed request to the hook, and returns the response body. Any response other than 2xx is an error.
+func send(ctx context.Context, hook *config.HookConfig, req *Request) ([]byte, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(hook.Timeout)*time.Millisecond)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("User-Agent", "Nexeres-Hooks/"+opts.Version)
+	httpReq.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	httpReq.Header.Set(HeaderSignature, webhooks.Sign(hook.Secret, timestamp, body))
+
+	start := time.Now()
+	resp, err := client.Do(httpReq)
+	callDuration.WithLabelValues(hook.Name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("hook responded with status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+}
+
+// cacheKey returns the cache key of the hook's result for the request.
+//
+// It covers every field sent to the hook, eg. the IP address and user agent, so that a result is only reused
+// for an identical request, and a decision based on the client is not replayed for other clients.
+func cacheKey(hook *config.HookConfig, req *Request) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	hash.Write([]byte(hook.URL))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return fmt.Sprintf("%s:%s:%s", req.Kind, hook.Name, hex.EncodeToString(hash.Sum(nil))), nil
+}
@@ -0,0 +1,315 @@
+// Package janitor purges expired and soft deleted rows from the database in the background,
+// eg. expired sessions and tokens, and users and orgs deleted longer ago than their retention window.
+//
+// Every instance runs the janitor, but a pass is only made by the instance holding a PostgreSQL advisory lock,
+// so that the instances do not delete the same rows at the same time.
+package janitor
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/privacy"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// advisoryLockID is the key of the PostgreSQL advisory lock held during a pass.
+const advisoryLockID int64 = 0x6e78725f6a616e69 // "nxr_jani"
+
+// statementTimeout is the timeout of a single batch delete.
+const statementTimeout = 30 * time.Second
+
+// erasureBatchSize is the maximum number of users erased in a batch,
+// lower than the batch size of the other tasks since every erasure also deletes objects.
+const erasureBatchSize = 50
+
+var (
+	stop chan struct{}
+	done chan struct{}
+
+	purgedCounter *prometheus.CounterVec
+	passesCounter *prometheus.CounterVec
+	passDuration  prometheus.Histogram
+)
+
+// task purges the rows of a table, in batches.
+type task struct {
+	// table is the name of the table, the label of the purged rows metric.
+	table string
+	// retention is the time, in seconds, the rows are kept, -1 if they are never purged.
+	retention int
+	// purge deletes up to limit rows which expired, or were deleted, before the given time.
+	purge func(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error)
+}
+
+// InitJanitor registers the janitor metrics.
+//
+// It must be called before metrics.InitMetrics.
+func InitJanitor() {
+	purgedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "nexeres",
+			Subsystem: "janitor",
+			Name:      "purged_rows",
+			Help:      "Total number of rows purged by the janitor, by table",
+		},
+		[]string{"table"},
+	)
+	passesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "nexeres",
+			Subsystem: "janitor",
+			Name:      "passes",
+			Help:      "Total number of janitor passes, by status: completed, failed (some rows could not be purged), skipped (another instance holds the lock)",
+		},
+		[]string{"status"},
+	)
+	passDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "nexeres",
+			Subsystem: "janitor",
+			Name:      "pass_duration_seconds",
+			Help:      "Duration of the completed and failed janitor passes",
+			Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300},
+		},
+	)
+	metrics.Collectors = append(metrics.Collectors, purgedCounter, passesCounter, passDuration)
+}
+
+// Start starts the janitor, which makes a pass every interval. It is a no-op if the janitor is disabled.
+//
+// It must be called after the database connection pool is initialized.
+func Start() {
+	if !*config.Janitor.Enable {
+		return
+	}
+	stop = make(chan struct{})
+	done = make(chan struct{})
+	go run()
+}
+
+// Shutdown stops the janitor, after the batch in progress is deleted.
+//
+// The rows left are purged by the next pass, of any instance.
+func Shutdown(ctx context.Context) error {
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run makes a pass every interval until Shutdown is called.
+func run() {
+	defer close(done)
+
+	ticker := time.NewTicker(time.Duration(config.Janitor.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			pass()
+		}
+	}
+}
+
+// pass purges the rows of every task, if no other instance is making a pass.
+func pass() {
+	ctx := context.Background()
+	conn, err := store.PgPool.Acquire(ctx)
+	if err != nil {
+		logging.Logger.Error("Failed to acquire a connection for the janitor", zap.Error(err))
+		passesCounter.WithLabelValues("failed").Inc()
+		return
+	}
+	defer conn.Release()
+
+	// The lock is held by the session of the connection, it is released with the session if the instance crashes.
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", advisoryLockID).Scan(&locked); err != nil {
+		logging.Logger.Error("Failed to acquire the janitor lock", zap.Error(err))
+		passesCounter.WithLabelValues("failed").Inc()
+		return
+	}
+	if !locked {
+		logging.Logger.Debug("Janitor pass skipped, another instance holds the lock")
+		passesCounter.WithLabelValues("skipped").Inc()
+		return
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", advisoryLockID); err != nil {
+			logging.Logger.Warn("Failed to release the janitor lock", zap.Error(err))
+		}
+	}()
+
+	start := time.Now()
+	status := "completed"
+	for _, t := range tasks() {
+		if t.retention < 0 {
+			continue
+		}
+		purged, err := purge(t)
+		if purged > 0 {
+			logging.Logger.Info("Janitor purged rows", zap.String("table", t.table), zap.Int64("rows", purged))
+		}
+		if err != nil {
+			logging.Logger.Error("Janitor failed to purge rows", zap.String("table", t.table), zap.Error(err))
+			status = "failed"
+		}
+
+		select {
+		case <-stop:
+			// The pass is interrupted by the shutdown, the remaining tasks are made by the next pass.
+			passesCounter.WithLabelValues(status).Inc()
+			return
+		default:
+		}
+	}
+	passDuration.Observe(time.Since(start).Seconds())
+	passesCounter.WithLabelValues(status).Inc()
+}
+
+// purge deletes the rows of the task in batches, until a batch is not full or Shutdown is called,
+// and returns the number of deleted rows.
+func purge(t task) (int64, error) {
+	before := pgtype.Timestamptz{Time: time.Now().Add(-time.Duration(t.retention) * time.Second), Valid: true}
+	limit := int32(config.Janitor.BatchSize)
+
+	var total int64
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), statementTimeout)
+		n, err := t.purge(ctx, before, limit)
+		cancel()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		purgedCounter.WithLabelValues(t.table).Add(float64(n))
+		if n < int64(limit) {
+			return total, nil
+		}
+
+		select {
+		case <-stop:
+			return total, nil
+		default:
+		}
+	}
+}
+
+// tasks returns the tasks of a pass, in order.
+func tasks() []task {
+	retention := config.Janitor.Retention
+	q := store.Querier
+	return []task{
+		{
+			table:     "sessions",
+			retention: retention.Sessions,
+			purge: func(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error) {
+				return q.PurgeExpiredSessions(ctx, db.PurgeExpiredSessionsParams{Before: before, Limit: limit})
+			},
+		},
+		{
+			table:     "verification_tokens",
+			retention: retention.VerificationTokens,
+			purge: func(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error) {
+				return q.PurgeExpiredVerificationTokens(ctx, db.PurgeExpiredVerificationTokensParams{Before: before, Limit: limit})
+			},
+		},
+		{
+			table:     "invitations",
+			retention: retention.Invitations,
+			purge: func(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error) {
+				return q.PurgeExpiredInvitations(ctx, db.PurgeExpiredInvitationsParams{Before: before, Limit: limit})
+			},
+		},
+		{
+			table:     "oidc_auth_codes",
+			retention: retention.OIDC,
+			purge: func(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error) {
+				return q.PurgeExpiredOIDCAuthCodes(ctx, db.PurgeExpiredOIDCAuthCodesParams{Before: before, Limit: limit})
+			},
+		},
+		// Refresh tokens before access tokens, since access tokens are kept while their refresh token is.
+		{
+			table:     "oidc_refresh_tokens",
+			retention: retention.OIDC,
+			purge: func(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error) {
+				return q.PurgeExpiredOIDCRefreshTokens(ctx, db.PurgeExpiredOIDCRefreshTokensParams{Before: before, Limit: limit})
+			},
+		},
+		{
+			table:     "oidc_access_tokens",
+			retention: retention.OIDC,
+			purge: func(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error) {
+				return q.PurgeExpiredOIDCAccessTokens(ctx, db.PurgeExpiredOIDCAccessTokensParams{Before: before, Limit: limit})
+			},
+		},
+		{
+			table:     "notification_jobs",
+			retention: retention.NotificationJobs,
+			purge: func(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error) {
+				return q.PurgeNotificationJobs(ctx, db.PurgeNotificationJobsParams{Before: before, Limit: limit})
+			},
+		},
+		{
+			table:     "webhook_deliveries",
+			retention: retention.WebhookDeliveries,
+			purge: func(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error) {
+				return q.PurgeWebhookDeliveries(ctx, db.PurgeWebhookDeliveriesParams{Before: before, Limit: limit})
+			},
+		},
+		{
+			table:     "api_keys",
+			retention: retention.APIKeys,
+			purge: func(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error) {
+				return q.PurgeInactiveAPIKeys(ctx, db.PurgeInactiveAPIKeysParams{Before: before, Limit: limit})
+			},
+		},
+		// Expired data exports, and the users whose erasure is due, are purged regardless of the retention.
+		{
+			table:     "data_exports",
+			retention: 0,
+			purge:     privacy.PurgeExpiredExports,
+		},
+		// Users and orgs last, since deleting them also deletes the rows which belong to them.
+		{
+			table:     "users",
+			retention: 0,
+			purge: func(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error) {
+				return privacy.EraseDue(ctx, before, min(limit, erasureBatchSize))
+			},
+		},
+		// Soft deleted users are erased like the users who requested it, so that their objects are deleted too.
+		{
+			table:     "users",
+			retention: retention.DeletedUsers,
+			purge: func(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error) {
+				return privacy.EraseDeleted(ctx, before, min(limit, erasureBatchSize))
+			},
+		},
+		// The org.deleted webhook is sent when an org is soft deleted, since its endpoints are purged with it.
+		{
+			table:     "orgs",
+			retention: retention.DeletedOrgs,
+			purge: func(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error) {
+				return q.PurgeDeletedOrgs(ctx, db.PurgeDeletedOrgsParams{Before: before, Limit: limit})
+			},
+		},
+	}
+}
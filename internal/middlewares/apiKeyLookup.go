@@ -0,0 +1,207 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/internal/cache"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/internal/tokens"
+	"go.uber.org/zap"
+)
+
+// API key scopes, the route groups a key stored in the database can call.
+// The keys of the config file can call every route group.
+const (
+	// APIKeyScopeAuth allows the /api/auth routes, eg. signup, login and token refresh.
+	APIKeyScopeAuth = "auth"
+	// APIKeyScopeOrgs allows the /api/orgs routes, eg. roles, settings and webhooks.
+	APIKeyScopeOrgs = "orgs"
+	// APIKeyScopeTenant allows the /api/tenant route.
+	APIKeyScopeTenant = "tenant"
+	// APIKeyScopeAdmin allows the /api/admin routes. Admins still need to log in.
+	APIKeyScopeAdmin = "admin"
+)
+
+// APIKeyScopes is the list of all API key scopes.
+var APIKeyScopes = []string{APIKeyScopeAuth, APIKeyScopeOrgs, APIKeyScopeTenant, APIKeyScopeAdmin}
+
+// RouteScope returns the scope needed to call the route, eg. "orgs" for "/api/orgs/:orgId/roles",
+// or an empty string if the route does not belong to a route group.
+func RouteScope(route string) string {
+	group, ok := strings.CutPrefix(route, "/api/")
+	if !ok {
+		return ""
+	}
+	group, _, _ = strings.Cut(group, "/")
+	return group
+}
+
+// APIKey is the API key a request was made with.
+type APIKey struct {
+	// ID of the key in the database, nil for the keys of the config file.
+	ID *uuid.UUID
+	// Name of the key.
+	Name string
+	// Prefix is the visible prefix of the key, empty for the keys of the config file.
+	Prefix string
+	// Scopes are the route groups the key can call, see APIKeyScopes.
+	Scopes []string
+	// OrgID is the organization the key is bound to, nil if it can be used for every organization.
+	OrgID *uuid.UUID
+}
+
+// AllowsRoute reports whether the key can call the route.
+func (k *APIKey) AllowsRoute(route string) bool {
+	scope := RouteScope(route)
+	return scope == "" || slices.Contains(k.Scopes, scope)
+}
+
+// AllowsOrg reports whether the key can be used for the organization.
+func (k *APIKey) AllowsOrg(orgID string) bool {
+	return k.OrgID == nil || k.OrgID.String() == orgID
+}
+
+// GetAPIKey returns the API key of the request, set by the APIKeyMiddleware, or nil if there is none.
+func GetAPIKey(ctx *gin.Context) *APIKey {
+	val, exists := ctx.Get(CtxAPIKeyGetter)
+	if !exists {
+		return nil
+	}
+	key, _ := val.(*APIKey)
+	return key
+}
+
+// lookupAPIKey returns the API key with the given value, from the config file or the database, or nil if it is not valid.
+func lookupAPIKey(ctx context.Context, value string) (*APIKey, error) {
+	if key := lookupConfigAPIKey(value); key != nil {
+		return key, nil
+	}
+	if _, ok := tokens.ParseAPIKeyPrefix(value); ok {
+		return lookupStoredAPIKey(ctx, value)
+	}
+	return nil, nil
+}
+
+// lookupConfigAPIKey returns the key of the config file with the given value, or nil.
+//
+// The hashes of the keys are compared in constant time, and every key is compared, so that the time taken does not reveal the keys.
+func lookupConfigAPIKey(value string) *APIKey {
+	hash := sha256.Sum256([]byte(value))
+	var match *config.APIKeyConfig
+	for i := range config.Security.APIKeys {
+		keyHash := sha256.Sum256([]byte(config.Security.APIKeys[i].Key))
+		if subtle.ConstantTimeCompare(hash[:], keyHash[:]) == 1 {
+			match = &config.Security.APIKeys[i]
+		}
+	}
+	if match == nil {
+		return nil
+	}
+	return &APIKey{
+		Name:   match.Name,
+		Scopes: APIKeyScopes,
+	}
+}
+
+// apiKeyCacheTTL is the duration for which the keys stored in the database are cached, in the shared cache.
+//
+// Revoking or rotating a key deletes it from the cache, see InvalidateAPIKey, so the TTL only bounds how long
+// a cached key can outlive a change made directly in the database.
+const apiKeyCacheTTL = 30 * time.Second
+
+// apiKeyTouchInterval is the minimum time between two updates of the last use of a key by this instance.
+const apiKeyTouchInterval = time.Minute
+
+var (
+	apiKeysTouchedMu sync.Mutex
+	// apiKeysTouched holds the time of the last update of the last use of each key, by ID.
+	// Entries older than apiKeyTouchInterval are removed by touchAPIKey.
+	apiKeysTouched = map[uuid.UUID]time.Time{}
+)
+
+// InvalidateAPIKey removes a key stored in the database from the shared cache, eg. after it is revoked,
+// so that no instance accepts it anymore.
+func InvalidateAPIKey(ctx context.Context, prefix string) {
+	if err := cache.DeleteAPIKey(ctx, prefix); err != nil {
+		logging.Logger.Error("Failed to invalidate cached API key", zap.String("prefix", prefix), zap.Error(err))
+	}
+}
+
+// lookupStoredAPIKey returns the key stored in the database with the given value, or nil if it does not exist,
+// does not match, is revoked or has expired.
+//
+// Made up prefixes are not cached, so they cannot fill the cache. Cache failures are not fatal, the database is read.
+func lookupStoredAPIKey(ctx context.Context, value string) (*APIKey, error) {
+	prefix, _ := tokens.ParseAPIKeyPrefix(value)
+
+	row, err := cache.GetAPIKey(ctx, prefix)
+	if err != nil {
+		if !errors.Is(err, cache.ErrKeyNotFound) {
+			logging.Logger.Warn("Failed to get cached API key", zap.String("prefix", prefix), zap.Error(err))
+		}
+		stored, err := store.Querier.GetAPIKeyByPrefix(ctx, prefix)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row = &stored
+		if err := cache.StoreAPIKey(ctx, stored, apiKeyCacheTTL); err != nil {
+			logging.Logger.Warn("Failed to cache API key", zap.String("prefix", prefix), zap.Error(err))
+		}
+	}
+
+	if subtle.ConstantTimeCompare(tokens.HashAPIKey(value), row.KeyHash) != 1 {
+		return nil, nil
+	}
+	if row.RevokedAt.Valid || (row.ExpiresAt.Valid && time.Now().After(row.ExpiresAt.Time)) {
+		return nil, nil
+	}
+
+	touchAPIKey(row.ID)
+	return &APIKey{
+		ID:     &row.ID,
+		Name:   row.Name,
+		Prefix: row.Prefix,
+		Scopes: row.Scopes,
+		OrgID:  row.OrgID,
+	}, nil
+}
+
+// touchAPIKey records the use of the key in the background, at most once per apiKeyTouchInterval.
+func touchAPIKey(id uuid.UUID) {
+	apiKeysTouchedMu.Lock()
+	if time.Since(apiKeysTouched[id]) < apiKeyTouchInterval {
+		apiKeysTouchedMu.Unlock()
+		return
+	}
+	now := time.Now()
+	for touchedID, touchedAt := range apiKeysTouched {
+		if now.Sub(touchedAt) >= apiKeyTouchInterval {
+			delete(apiKeysTouched, touchedID)
+		}
+	}
+	apiKeysTouched[id] = now
+	apiKeysTouchedMu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := store.Querier.TouchAPIKey(ctx, id); err != nil {
+			logging.Logger.Warn("Failed to record the use of an API key", zap.String("id", id.String()), zap.Error(err))
+		}
+	}()
+}
@@ -3,18 +3,22 @@ package middlewares
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
-	"slices"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/nbrglm/nexeres/config"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/nbrglm/nexeres/db"
 	"github.com/nbrglm/nexeres/internal/cache"
 	"github.com/nbrglm/nexeres/internal/logging"
 	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/permissions"
+	"github.com/nbrglm/nexeres/internal/store"
 	"github.com/nbrglm/nexeres/internal/tokens"
 	"go.uber.org/zap"
 )
@@ -86,6 +90,75 @@ func RequireAuth(mode AuthMode) gin.HandlerFunc {
 	}
 }
 
+// RequirePermission checks that the caller's role in the session's organization grants all the given permissions.
+//
+// It must be used after RequireAuth with a mode that requires a session token.
+// If the route has an `:orgId` parameter, the session must also belong to that organization.
+// The membership is read from the database on every request, instead of using the permissions of the token,
+// so that a role change, a ban or a removal applies immediately, not when the session token expires.
+func RequirePermission(required ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		val, exists := ctx.Get(CtxSessionTokenClaims)
+		claims, ok := val.(*tokens.NexeresClaims)
+		if !exists || !ok || claims == nil {
+			logging.Logger.Debug("No session token claims found for permission check")
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, models.NewErrorResponse("No session token provided", "Provide a valid session token!", http.StatusUnauthorized, nil).Filter())
+			return
+		}
+
+		if orgId := ctx.Param("orgId"); orgId != "" && orgId != claims.OrgId {
+			logging.Logger.Debug("Session does not belong to the requested organization", zap.String("orgId", orgId), zap.String("sessionOrgId", claims.OrgId))
+			ctx.AbortWithStatusJSON(http.StatusForbidden, models.NewErrorResponse("You do not have access to this organization!", "The session token belongs to a different organization", http.StatusForbidden, nil).Filter())
+			return
+		}
+
+		granted, err := currentPermissions(ctx.Request.Context(), claims)
+		if errors.Is(err, errNotAMember) {
+			logging.Logger.Debug("User is not an active member of the session's organization", zap.String("userId", claims.Subject), zap.String("orgId", claims.OrgId))
+			ctx.AbortWithStatusJSON(http.StatusForbidden, models.NewErrorResponse("You are not a member of this organization!", "User is not an active member of the organization", http.StatusForbidden, nil).Filter())
+			return
+		}
+		if err != nil {
+			logging.Logger.Error("Failed to resolve the caller's permissions", zap.Error(err))
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, models.NewErrorResponse(models.GenericErrorMessage, "Failed to resolve permissions", http.StatusInternalServerError, err).Filter())
+			return
+		}
+
+		if !permissions.HasAll(granted, required...) {
+			logging.Logger.Debug("Missing required permissions", zap.Strings("required", required), zap.Strings("granted", granted))
+			ctx.AbortWithStatusJSON(http.StatusForbidden, models.NewErrorResponse("You do not have permission to perform this action!", fmt.Sprintf("Missing one of the required permissions: %s", strings.Join(required, ", ")), http.StatusForbidden, nil).Filter())
+			return
+		}
+		ctx.Next()
+	}
+}
+
+var errNotAMember = errors.New("not an active member of the organization")
+
+// currentPermissions returns the permissions of the current role of the session's user in the session's organization.
+func currentPermissions(ctx context.Context, claims *tokens.NexeresClaims) ([]string, error) {
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, errNotAMember
+	}
+	orgId, err := uuid.Parse(claims.OrgId)
+	if err != nil {
+		return nil, errNotAMember
+	}
+
+	membership, err := store.Querier.GetUserOrgMembership(ctx, db.GetUserOrgMembershipParams{
+		UserID: userId,
+		OrgID:  orgId,
+	})
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && membership.Status == "banned") {
+		return nil, errNotAMember
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization membership: %w", err)
+	}
+	return permissions.ResolveRolePermissions(ctx, store.Querier, orgId, membership.Role)
+}
+
 func APIKeyMiddleware() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		apiKey := strings.TrimSpace(ctx.GetHeader(tokens.NEXERES_API_KeyHeaderName))
@@ -96,20 +169,29 @@ func APIKeyMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Validate the API Key
-		exists := slices.ContainsFunc(config.Security.APIKeys, func(key config.APIKeyConfig) bool {
-			if apiKey == key.Key {
-				ctx.Set(CtxAPIKeyGetter, key)
-				return true
-			}
-			return false
-		})
-
-		if !exists {
+		// Validate the API Key, against the keys of the config file and the database
+		key, err := lookupAPIKey(ctx.Request.Context(), apiKey)
+		if err != nil {
+			logging.Logger.Error("Failed to look up API key", zap.Error(err))
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, models.NewErrorResponse(models.GenericErrorMessage, "Failed to look up API key", http.StatusInternalServerError, err).Filter())
+			return
+		}
+		if key == nil {
 			logging.Logger.Warn("Invalid API key provided")
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, models.NewErrorResponse("Unauthorized access!", "Invalid API key", http.StatusUnauthorized, nil).Filter())
 			return
 		}
+		if !key.AllowsRoute(ctx.FullPath()) {
+			logging.Logger.Warn("API key is not allowed to call the route", zap.String("key", key.Name), zap.String("route", ctx.FullPath()))
+			ctx.AbortWithStatusJSON(http.StatusForbidden, models.NewErrorResponse("This API key cannot call this endpoint!", "The API key does not have the scope of the route", http.StatusForbidden, nil).Filter())
+			return
+		}
+		if orgId := ctx.Param("orgId"); orgId != "" && !key.AllowsOrg(orgId) {
+			logging.Logger.Warn("API key is bound to another organization", zap.String("key", key.Name), zap.String("orgId", orgId))
+			ctx.AbortWithStatusJSON(http.StatusForbidden, models.NewErrorResponse("This API key cannot be used for this organization!", "The API key is bound to another organization", http.StatusForbidden, nil).Filter())
+			return
+		}
+		ctx.Set(CtxAPIKeyGetter, key)
 
 		// Session token
 		sessionToken := strings.TrimSpace(ctx.GetHeader(tokens.SessionTokenHeaderName))
@@ -122,6 +204,8 @@ func APIKeyMiddleware() gin.HandlerFunc {
 				claims, err := ValidateSessionToken(ctx.Request.Context(), string(bytes))
 				if err != nil {
 					logging.Logger.Debug("Failed to validate session token", zap.Error(err))
+				} else if claims != nil && !key.AllowsOrg(claims.OrgId) {
+					logging.Logger.Debug("Session token belongs to another organization than the API key", zap.String("key", key.Name), zap.String("sessionOrgId", claims.OrgId))
 				} else if claims != nil {
 					ctx.Set(CtxSessionToken, sessionToken)
 					ctx.Set(CtxSessionTokenClaims, claims)
@@ -168,6 +252,10 @@ func ValidateSessionToken(ctx context.Context, token string) (claims *tokens.Nex
 		}
 	}, jwt.WithExpirationRequired(), jwt.WithIssuedAt(), jwt.WithLeeway(time.Minute*5))
 	if v, ok := parsedToken.Claims.(*tokens.NexeresClaims); ok && parsedToken.Valid {
+		// The issuer depends on the organization when tenant subdomains are enabled.
+		if v.Issuer != tokens.Issuer(v.OrgSlug) {
+			return nil, fmt.Errorf("invalid session token issuer: %s", v.Issuer)
+		}
 		return v, nil
 	}
 	return nil, fmt.Errorf("invalid session token: %w", err)
@@ -1,53 +1,242 @@
 package middlewares
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/nbrglm/nexeres/config"
-	"github.com/redis/go-redis/v9"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/store"
 	"github.com/ulule/limiter/v3"
-	mgin "github.com/ulule/limiter/v3/drivers/middleware/gin"
+	"github.com/ulule/limiter/v3/drivers/store/memory"
 	sredis "github.com/ulule/limiter/v3/drivers/store/redis"
+	"go.uber.org/zap"
+)
+
+// Rate limit policies count requests by one of these values.
+const (
+	RateLimitByIP     = "ip"
+	RateLimitByAPIKey = "apiKey"
+	RateLimitByEmail  = "email"
 )
 
+// maxRateLimitBodySize is the maximum size of the body of a request limited by email.
+// Larger requests are rejected, since their email could not be counted.
+const maxRateLimitBodySize = 64 << 10
+
+// unknownEmail is the value by which requests without an email, or with a body which is not valid JSON, are counted.
+const unknownEmail = "unknown"
+
+// errRequestTooLarge is returned by requestEmail if the body is larger than maxRateLimitBodySize.
+var errRequestTooLarge = errors.New("the request body is too large")
+
+// rateLimitPolicy is a rate limit applied to some routes, see config.RateLimitPolicyConfig.
+type rateLimitPolicy struct {
+	name    string
+	routes  []string
+	by      string
+	limiter *limiter.Limiter
+}
+
+// matches reports whether the policy applies to the route.
+func (p *rateLimitPolicy) matches(route string) bool {
+	if len(p.routes) == 0 {
+		return true
+	}
+	for _, r := range p.routes {
+		if prefix, ok := strings.CutSuffix(r, "*"); ok {
+			if strings.HasPrefix(route, prefix) {
+				return true
+			}
+		} else if r == route {
+			return true
+		}
+	}
+	return false
+}
+
 var (
-	rateLimiter *limiter.Limiter
+	rateLimitPolicies []*rateLimitPolicy
 )
 
-// InitRateLimitStore initializes the rate limit store.
-// This function should be called during application startup to set up the rate limiting store.
+// InitRateLimitStore initializes the rate limit store, with the cache backend selected in the config.
+// This function should be called during application startup to set up the rate limiting store,
+// after store.InitRedis, since the Redis backend uses the shared client.
 func InitRateLimitStore() error {
-	redisPassword := ""
-	if config.Stores.Redis.Password != nil {
-		redisPassword = *config.Stores.Redis.Password
-	}
-	redisOpts := redis.Options{
-		Addr:     config.Stores.Redis.Address,
-		Password: redisPassword,
-		DB:       config.Stores.Redis.DB,
-	}
-	redisClient := redis.NewClient(&redisOpts)
-	rateLimitStore, err := sredis.NewStoreWithOptions(
-		redisClient,
-		limiter.StoreOptions{
-			Prefix: "nexeres_rate_limit",
-		},
-	)
-	if err != nil {
-		return err
+	var rateLimitStore limiter.Store
+	switch config.Stores.Cache.Backend {
+	case "redis":
+		if store.Redis == nil {
+			return fmt.Errorf("redis client is not initialized")
+		}
+		var err error
+		rateLimitStore, err = sredis.NewStoreWithOptions(
+			store.Redis,
+			limiter.StoreOptions{
+				Prefix: "nexeres_rate_limit",
+			},
+		)
+		if err != nil {
+			return err
+		}
+	case "memory":
+		// Expired counters are removed every CleanUpInterval.
+		rateLimitStore = memory.NewStoreWithOptions(limiter.StoreOptions{
+			Prefix:          "nexeres_rate_limit",
+			CleanUpInterval: time.Minute,
+		})
+	default:
+		return fmt.Errorf("unknown cache backend: %s", config.Stores.Cache.Backend)
 	}
 
+	// The global rate limit is a policy for every route, per IP address.
 	rate, err := limiter.NewRateFromFormatted(config.Security.RateLimit.Rate)
 	if err != nil {
 		return err
 	}
+	policies := []*rateLimitPolicy{{
+		name:    "global",
+		by:      RateLimitByIP,
+		limiter: limiter.New(rateLimitStore, rate),
+	}}
+
+	for _, p := range config.Security.RateLimit.Policies {
+		rate, err := limiter.NewRateFromFormatted(p.Rate)
+		if err != nil {
+			return fmt.Errorf("invalid rate of rate limit policy %q: %w", p.Name, err)
+		}
+		policies = append(policies, &rateLimitPolicy{
+			name:    p.Name,
+			routes:  p.Routes,
+			by:      p.By,
+			limiter: limiter.New(rateLimitStore, rate),
+		})
+	}
 
-	rateLimiter = limiter.New(rateLimitStore, rate)
+	rateLimitPolicies = policies
 	return nil
 }
 
-// RateLimitMiddleware returns a middleware that applies rate limiting
-// to open API endpoints based on the configured rate limit.
-// This middleware is used for endpoints that do not require authentication.
+// RateLimitMiddleware returns a middleware that applies the global rate limit and the rate limit policies
+// matching the route of the request. Every limit must pass, otherwise the request is rejected with 429.
+//
+// It must be used after the APIKeyMiddleware, since policies can count requests by API key.
+// The RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers describe the limit closest to being reached.
 func RateLimitMiddleware() gin.HandlerFunc {
-	return mgin.NewMiddleware(rateLimiter)
+	return func(ctx *gin.Context) {
+		route := ctx.FullPath()
+
+		var closest *limiter.Context
+		var reached *rateLimitPolicy
+		var policyHeader []string
+		var email *string
+		for _, policy := range rateLimitPolicies {
+			if !policy.matches(route) {
+				continue
+			}
+
+			var value string
+			switch policy.by {
+			case RateLimitByIP:
+				value = ctx.ClientIP()
+			case RateLimitByAPIKey:
+				if key := GetAPIKey(ctx); key != nil {
+					value = key.Name
+					if key.ID != nil {
+						value = key.ID.String()
+					}
+				}
+			case RateLimitByEmail:
+				if email == nil {
+					e, err := requestEmail(ctx)
+					if err != nil {
+						ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, models.NewErrorResponse("The request is too large!", "Failed to read the email of the request", http.StatusRequestEntityTooLarge, err).Filter())
+						return
+					}
+					email = &e
+				}
+				value = *email
+			}
+			if value == "" {
+				continue
+			}
+
+			limit, err := policy.limiter.Get(ctx.Request.Context(), policy.name+":"+value)
+			if err != nil {
+				logging.Logger.Error("Failed to apply rate limit", zap.String("policy", policy.name), zap.Error(err))
+				ctx.AbortWithStatusJSON(http.StatusInternalServerError, models.NewErrorResponse(models.GenericErrorMessage, "Failed to apply rate limit", http.StatusInternalServerError, err).Filter())
+				return
+			}
+			policyHeader = append(policyHeader, fmt.Sprintf("%d;w=%d", policy.limiter.Rate.Limit, int64(policy.limiter.Rate.Period/time.Second)))
+
+			if closest == nil || (limit.Reached && !closest.Reached) || (limit.Reached == closest.Reached && limit.Remaining < closest.Remaining) {
+				closest = &limit
+			}
+			if limit.Reached && reached == nil {
+				reached = policy
+			}
+		}
+		if closest == nil {
+			ctx.Next()
+			return
+		}
+
+		reset := max(closest.Reset-time.Now().Unix(), 0)
+		ctx.Header("RateLimit-Policy", strings.Join(policyHeader, ", "))
+		ctx.Header("RateLimit-Limit", strconv.FormatInt(closest.Limit, 10))
+		ctx.Header("RateLimit-Remaining", strconv.FormatInt(closest.Remaining, 10))
+		ctx.Header("RateLimit-Reset", strconv.FormatInt(reset, 10))
+
+		if reached != nil {
+			logging.Logger.Debug("Rate limit reached", zap.String("policy", reached.name), zap.String("route", route))
+			ctx.Header("Retry-After", strconv.FormatInt(reset, 10))
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, models.NewErrorResponse("Too many requests, please try again later!", fmt.Sprintf("Rate limit policy %q reached", reached.name), http.StatusTooManyRequests, nil).Filter())
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// requestEmail returns the hash of the "email" field of the JSON body of the request,
+// or unknownEmail if there is none, so that such requests share one limit instead of skipping it.
+//
+// The body is parsed whatever the Content-Type, like the handlers do with ShouldBindJSON.
+// The email is normalized, so that changing its case does not bypass the limits, and hashed,
+// so that the rate limit store does not hold email addresses. The body is restored for the handlers.
+func requestEmail(ctx *gin.Context) (string, error) {
+	if ctx.Request.Body == nil || ctx.Request.Body == http.NoBody {
+		return unknownEmail, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, maxRateLimitBodySize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > maxRateLimitBodySize {
+		return "", errRequestTooLarge
+	}
+	ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var data struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return unknownEmail, nil
+	}
+	email := strings.ToLower(strings.TrimSpace(data.Email))
+	if email == "" {
+		return unknownEmail, nil
+	}
+	hash := sha256.Sum256([]byte(email))
+	return hex.EncodeToString(hash[:]), nil
 }
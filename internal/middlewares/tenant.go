@@ -0,0 +1,119 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/cache"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/models"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/internal/tokens"
+	"go.uber.org/zap"
+)
+
+const CtxTenantOrg = "tenantOrg"
+
+// TenantMiddleware resolves the tenant organization from the Host header,
+// eg. "acme.auth.example.com" resolves to the organization with the slug "acme".
+//
+// The organization is stored in the context, see GetTenant. Requests to other hosts are passed through.
+// It must be used after the APIKeyMiddleware, since session tokens and API keys of other organizations are rejected.
+func TenantMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		host := ctx.Request.Host
+		if config.Public.TrustForwardedHost {
+			if forwarded := ctx.GetHeader("X-Forwarded-Host"); forwarded != "" {
+				host = forwarded
+			}
+		}
+
+		slug, ok := config.Public.GetTenantFromHost(host)
+		if !ok {
+			ctx.Next()
+			return
+		}
+
+		org, err := lookupTenant(ctx.Request.Context(), slug)
+		if errors.Is(err, pgx.ErrNoRows) || (err == nil && org.DeletedAt.Valid) {
+			logging.Logger.Debug("No organization found for tenant host", zap.String("host", host), zap.String("slug", slug))
+			ctx.AbortWithStatusJSON(http.StatusNotFound, models.NewErrorResponse("Organization not found!", "No organization found for the tenant host", http.StatusNotFound, nil).Filter())
+			return
+		}
+		if err != nil {
+			logging.Logger.Error("Failed to resolve tenant organization", zap.String("slug", slug), zap.Error(err))
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, models.NewErrorResponse(models.GenericErrorMessage, "Failed to resolve tenant organization", http.StatusInternalServerError, err).Filter())
+			return
+		}
+
+		if key := GetAPIKey(ctx); key != nil && !key.AllowsOrg(org.ID.String()) {
+			logging.Logger.Debug("API key is bound to another organization than the tenant host", zap.String("orgId", org.ID.String()), zap.String("key", key.Name))
+			ctx.AbortWithStatusJSON(http.StatusForbidden, models.NewErrorResponse("This API key cannot be used for this organization!", "The API key is bound to another organization than the tenant host", http.StatusForbidden, nil).Filter())
+			return
+		}
+
+		if val, exists := ctx.Get(CtxSessionTokenClaims); exists {
+			if claims, ok := val.(*tokens.NexeresClaims); ok && claims.OrgId != org.ID.String() {
+				logging.Logger.Debug("Session does not belong to the tenant organization", zap.String("orgId", org.ID.String()), zap.String("sessionOrgId", claims.OrgId))
+				ctx.AbortWithStatusJSON(http.StatusForbidden, models.NewErrorResponse("You do not have access to this organization!", "The session token belongs to a different organization than the tenant host", http.StatusForbidden, nil).Filter())
+				return
+			}
+		}
+
+		ctx.Set(CtxTenantOrg, &org)
+		ctx.Next()
+	}
+}
+
+// GetTenant returns the tenant organization resolved by the TenantMiddleware,
+// or nil if the request was not made to a tenant host.
+func GetTenant(ctx *gin.Context) *db.Org {
+	val, exists := ctx.Get(CtxTenantOrg)
+	if !exists {
+		return nil
+	}
+	org, _ := val.(*db.Org)
+	return org
+}
+
+// tenantCacheTTL is the duration for which the organizations of tenant hosts are cached, in the shared cache.
+//
+// Updating or deleting an organization deletes it from the cache, see InvalidateTenant.
+const tenantCacheTTL = 30 * time.Second
+
+// InvalidateTenant removes the organization with the given slug from the shared cache, eg. after its settings are updated,
+// so that every instance reads it again.
+func InvalidateTenant(ctx context.Context, slug string) {
+	if err := cache.DeleteTenant(ctx, slug); err != nil {
+		logging.Logger.Error("Failed to invalidate cached tenant", zap.String("slug", slug), zap.Error(err))
+	}
+}
+
+// lookupTenant returns the organization with the given slug, including soft deleted organizations.
+//
+// Unknown slugs are not cached, so that requests to made up hosts cannot fill the cache.
+// Cache failures are not fatal, the database is read.
+func lookupTenant(ctx context.Context, slug string) (db.Org, error) {
+	cachedOrg, err := cache.GetTenant(ctx, slug)
+	if err == nil {
+		return *cachedOrg, nil
+	}
+	if !errors.Is(err, cache.ErrKeyNotFound) {
+		logging.Logger.Warn("Failed to get cached tenant", zap.String("slug", slug), zap.Error(err))
+	}
+
+	org, err := store.Querier.GetOrgBySlug(ctx, slug)
+	if err != nil {
+		return db.Org{}, err
+	}
+	if err := cache.StoreTenant(ctx, org, tenantCacheTTL); err != nil {
+		logging.Logger.Warn("Failed to cache tenant", zap.String("slug", slug), zap.Error(err))
+	}
+	return org, nil
+}
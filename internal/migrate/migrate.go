@@ -0,0 +1,364 @@
+// Package migrate applies the SQL migrations of the database schema.
+//
+// The version of the schema is kept in the schema_migrations table, in the same format as the migrate CLI,
+// so that databases migrated with the CLI can be migrated by the binary, and the other way around.
+// Migrations are applied under a PostgreSQL advisory lock, so that several instances cannot run them at the same time.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// advisoryLockID is the key of the PostgreSQL advisory lock held while migrating.
+const advisoryLockID int64 = 0x6e78725f6d696772 // "nxr_migr"
+
+// ErrDirty is returned when a migration applied with the migrate CLI failed halfway.
+// The schema must be fixed by hand, and the version set with Force.
+var ErrDirty = errors.New("the database schema is dirty")
+
+// Migration is a schema migration, read from the files "<version>_<name>.up.sql" and "<version>_<name>.down.sql".
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// String returns the name of the files of the migration, without the direction, eg. "202610181800_api_keys".
+func (m Migration) String() string {
+	return fmt.Sprintf("%d_%s", m.Version, m.Name)
+}
+
+var migrationFileRegexp = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Load reads the migrations in the root of fsys, sorted by version. Other files are ignored.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := map[uint64]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileRegexp.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version of migration %s: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", m, entry.Name())
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %s has no up file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		switch {
+		case a.Version < b.Version:
+			return -1
+		case a.Version > b.Version:
+			return 1
+		}
+		return 0
+	})
+	return migrations, nil
+}
+
+// Status is the state of the database schema, compared to the migrations.
+type Status struct {
+	// Version of the schema, the version of the last applied migration, 0 if none was applied.
+	Version uint64
+
+	// Dirty reports whether a migration applied with the migrate CLI failed halfway, see ErrDirty.
+	Dirty bool
+
+	// Latest is the version of the last migration, the version the schema should be at.
+	Latest uint64
+
+	// Pending are the migrations not applied yet, in order.
+	Pending []Migration
+}
+
+// Behind reports whether migrations are pending.
+func (s *Status) Behind() bool {
+	return len(s.Pending) > 0
+}
+
+// Ahead reports whether the schema is newer than the last migration, eg. after downgrading the binary.
+func (s *Status) Ahead() bool {
+	return s.Version > s.Latest
+}
+
+// Migrator applies migrations to a database.
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+}
+
+// New creates a Migrator which applies the migrations, as returned by Load, to the database of the pool.
+func New(pool *pgxpool.Pool, migrations []Migration) *Migrator {
+	return &Migrator{pool: pool, migrations: migrations}
+}
+
+// Status returns the state of the database schema.
+func (m *Migrator) Status(ctx context.Context) (*Status, error) {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	return m.status(ctx, conn.Conn())
+}
+
+// Up applies all the pending migrations, and returns the applied migrations.
+// Nothing is applied if the schema is newer than the last migration.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(ctx, func(conn *pgx.Conn) error {
+		status, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if status.Dirty {
+			return fmt.Errorf("%w at version %d", ErrDirty, status.Version)
+		}
+		if !status.Behind() {
+			return nil
+		}
+		applied, err = m.migrate(ctx, conn, status.Version, status.Latest)
+		return err
+	})
+	return applied, err
+}
+
+// Down reverts the given number of migrations, and returns the reverted migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("the number of migrations to revert must be positive")
+	}
+	var applied []Migration
+	err := m.withLock(ctx, func(conn *pgx.Conn) error {
+		status, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if status.Dirty {
+			return fmt.Errorf("%w at version %d", ErrDirty, status.Version)
+		}
+		if status.Version == 0 {
+			return nil
+		}
+		i := m.index(status.Version)
+		if i < 0 {
+			return fmt.Errorf("the schema version %d is not a known migration", status.Version)
+		}
+		target := uint64(0)
+		if i-steps >= 0 {
+			target = m.migrations[i-steps].Version
+		}
+		applied, err = m.migrate(ctx, conn, status.Version, target)
+		return err
+	})
+	return applied, err
+}
+
+// To applies or reverts migrations until the schema is at the given version, 0 to revert all migrations,
+// and returns the applied or reverted migrations.
+func (m *Migrator) To(ctx context.Context, version uint64) ([]Migration, error) {
+	if version != 0 && m.index(version) < 0 {
+		return nil, fmt.Errorf("the version %d is not a known migration", version)
+	}
+	var applied []Migration
+	err := m.withLock(ctx, func(conn *pgx.Conn) error {
+		status, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if status.Dirty {
+			return fmt.Errorf("%w at version %d", ErrDirty, status.Version)
+		}
+		if status.Version != 0 && m.index(status.Version) < 0 {
+			return fmt.Errorf("the schema version %d is not a known migration", status.Version)
+		}
+		applied, err = m.migrate(ctx, conn, status.Version, version)
+		return err
+	})
+	return applied, err
+}
+
+// Force sets the version of the schema, and clears the dirty flag, without applying any migration.
+// It is used after fixing the schema by hand, eg. after a migration failed halfway.
+func (m *Migrator) Force(ctx context.Context, version uint64) error {
+	if version != 0 && m.index(version) < 0 {
+		return fmt.Errorf("the version %d is not a known migration", version)
+	}
+	return m.withLock(ctx, func(conn *pgx.Conn) error {
+		return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			return setVersion(ctx, tx, version)
+		})
+	})
+}
+
+// migrate applies or reverts the migrations between the versions, each in a transaction with the update of the version,
+// so that a failed migration leaves the schema at the version of the previous one.
+func (m *Migrator) migrate(ctx context.Context, conn *pgx.Conn, from, to uint64) ([]Migration, error) {
+	var steps []Migration
+	var versions []uint64
+	if to >= from {
+		for _, migration := range m.migrations {
+			if migration.Version > from && migration.Version <= to {
+				steps = append(steps, migration)
+				versions = append(versions, migration.Version)
+			}
+		}
+	} else {
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if migration.Version <= from && migration.Version > to {
+				if migration.Down == "" {
+					return nil, fmt.Errorf("migration %s has no down file", migration)
+				}
+				steps = append(steps, migration)
+				// The schema is at the previous migration after reverting this one.
+				previous := uint64(0)
+				if i > 0 {
+					previous = m.migrations[i-1].Version
+				}
+				versions = append(versions, previous)
+			}
+		}
+	}
+
+	var applied []Migration
+	for i, migration := range steps {
+		sql := migration.Up
+		if to < from {
+			sql = migration.Down
+		}
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			// Without arguments, the simple protocol is used, which allows several statements.
+			if _, err := tx.Exec(ctx, sql); err != nil {
+				return err
+			}
+			return setVersion(ctx, tx, versions[i])
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %s failed: %w", migration, err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// status returns the state of the schema, at version 0 if the version table does not exist yet.
+func (m *Migrator) status(ctx context.Context, conn *pgx.Conn) (*Status, error) {
+	status := &Status{}
+	if len(m.migrations) > 0 {
+		status.Latest = m.migrations[len(m.migrations)-1].Version
+	}
+
+	var exists bool
+	if err := conn.QueryRow(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to get the schema version: %w", err)
+	}
+	if exists {
+		var version int64
+		err := conn.QueryRow(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &status.Dirty)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("failed to get the schema version: %w", err)
+		}
+		if version > 0 {
+			status.Version = uint64(version)
+		}
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Version > status.Version {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+	return status, nil
+}
+
+// withLock runs fn on a connection holding the advisory lock, once the version table exists.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgx.Conn) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	// Waits until other instances are done migrating.
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", advisoryLockID); err != nil {
+		return fmt.Errorf("failed to acquire the migration lock: %w", err)
+	}
+	defer func() {
+		// The lock is released with the session anyway, if this fails.
+		_, _ = conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", advisoryLockID)
+	}()
+
+	if err := ensureVersionTable(ctx, conn.Conn()); err != nil {
+		return err
+	}
+	return fn(conn.Conn())
+}
+
+// index returns the index of the migration with the version, or -1.
+func (m *Migrator) index(version uint64) int {
+	return slices.IndexFunc(m.migrations, func(migration Migration) bool {
+		return migration.Version == version
+	})
+}
+
+func ensureVersionTable(ctx context.Context, conn *pgx.Conn) error {
+	if _, err := conn.Exec(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)"); err != nil {
+		return fmt.Errorf("failed to create the schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// setVersion sets the version of the schema, like the migrate CLI, the table is empty at version 0.
+func setVersion(ctx context.Context, tx pgx.Tx, version uint64) error {
+	if _, err := tx.Exec(ctx, "TRUNCATE schema_migrations"); err != nil {
+		return fmt.Errorf("failed to set the schema version: %w", err)
+	}
+	if version == 0 {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)", int64(version)); err != nil {
+		return fmt.Errorf("failed to set the schema version: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/notifications/templates"
+	"github.com/nbrglm/nexeres/internal/orgsettings"
+	"github.com/nbrglm/nexeres/internal/store"
+	"go.uber.org/zap"
+)
+
+// Branding holds the branding used to render and send an email.
+//
+// Use DefaultBranding for emails not sent on behalf of an organization, and BrandingForOrg otherwise.
+type Branding struct {
+	AppName      string
+	CompanyName  string
+	SupportURL   string
+	LogoURL      string
+	PrimaryColor string
+
+	// SenderName is the name emails are sent from, empty for the provider's configured name.
+	SenderName string
+
+	// Locale is the organization's default locale, empty for the configured default locale.
+	Locale string
+
+	// OrgID is the organization the branding belongs to, empty for the default branding.
+	OrgID string
+	// CustomTemplates are the names of the templates the organization has overridden.
+	CustomTemplates []string
+}
+
+// DefaultBranding returns the branding from the global `branding` config.
+func DefaultBranding() Branding {
+	return Branding{
+		AppName:      config.Branding.AppName,
+		CompanyName:  config.Branding.CompanyNameShort,
+		SupportURL:   config.Branding.SupportURL,
+		LogoURL:      config.Branding.LogoURL,
+		PrimaryColor: config.Branding.PrimaryColor,
+	}
+}
+
+// BrandingForOrg returns the branding of the organization, falling back to the global config for unset fields.
+func BrandingForOrg(org *db.Org) (Branding, error) {
+	b := DefaultBranding()
+	settings, err := orgsettings.ForOrg(org)
+	if err != nil {
+		return b, err
+	}
+
+	ob := settings.Branding
+	b.OrgID = org.ID.String()
+	b.CustomTemplates = ob.CustomTemplates
+	if ob.DisplayName != "" {
+		b.AppName = ob.DisplayName
+		// The display name is also the default sender name, so that emails are not sent under the global app name.
+		b.SenderName = ob.DisplayName
+	}
+	if ob.CompanyName != "" {
+		b.CompanyName = ob.CompanyName
+	}
+	if ob.SupportURL != "" {
+		b.SupportURL = ob.SupportURL
+	}
+	if ob.PrimaryColor != "" {
+		b.PrimaryColor = ob.PrimaryColor
+	}
+	if ob.SenderName != "" {
+		b.SenderName = ob.SenderName
+	}
+	if ob.DefaultLocale != "" {
+		b.Locale = ob.DefaultLocale
+	}
+	if org.AvatarUrl != nil && *org.AvatarUrl != "" {
+		b.LogoURL = *org.AvatarUrl
+	}
+	return b, nil
+}
+
+// templateData returns the template data with the branding fields filled in.
+func (b *Branding) templateData() templates.TemplateData {
+	return templates.TemplateData{
+		AppName:      b.AppName,
+		CompanyName:  b.CompanyName,
+		SupportURL:   b.SupportURL,
+		LogoURL:      b.LogoURL,
+		PrimaryColor: b.PrimaryColor,
+	}
+}
+
+// locale returns the locale to send a notification in: the user's locale if set,
+// otherwise the organization's default locale, otherwise the configured default locale.
+func (b *Branding) locale(userLocale *string) string {
+	if userLocale != nil && *userLocale != "" {
+		return *userLocale
+	}
+	if b.Locale != "" {
+		return b.Locale
+	}
+	return templates.DefaultLocale()
+}
+
+// Parts of an organization's custom email template, as stored in the object store.
+const (
+	TemplatePartSubject   = "subject.txt"
+	TemplatePartHTML      = "body.html"
+	TemplatePartPlainText = "plain-text.txt"
+)
+
+// OrgTemplateKey returns the object store key (without the 'private/' prefix) of a part of an organization's custom template.
+func OrgTemplateKey(orgID, templateName, part string) string {
+	return fmt.Sprintf("orgs/%s/email-templates/%s/%s", orgID, templateName, part)
+}
+
+// orgTemplateCacheTTL is the duration for which custom templates are cached in memory.
+//
+// Templates are invalidated when changed through this instance, other instances pick up changes after the TTL.
+const orgTemplateCacheTTL = 5 * time.Minute
+
+type cachedOrgTemplate struct {
+	template  *templates.EmailTemplate
+	expiresAt time.Time
+}
+
+var (
+	orgTemplatesMu sync.Mutex
+	orgTemplates   = map[string]cachedOrgTemplate{}
+)
+
+// InvalidateOrgTemplate removes an organization's custom template from the in-memory cache.
+func InvalidateOrgTemplate(orgID, templateName string) {
+	orgTemplatesMu.Lock()
+	defer orgTemplatesMu.Unlock()
+	delete(orgTemplates, orgID+"/"+templateName)
+}
+
+// emailTemplate returns the organization's custom template if it has one, otherwise the given built-in template.
+//
+// Failures to load a custom template are logged, and the built-in template is used, so that emails are still sent.
+func (b *Branding) emailTemplate(ctx context.Context, base *templates.EmailTemplate) *templates.EmailTemplate {
+	if b.OrgID == "" || !slices.Contains(b.CustomTemplates, base.TemplateName) {
+		return base
+	}
+
+	cacheKey := b.OrgID + "/" + base.TemplateName
+	orgTemplatesMu.Lock()
+	cached, ok := orgTemplates[cacheKey]
+	orgTemplatesMu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.template
+	}
+
+	tmpl, err := loadOrgTemplate(ctx, b.OrgID, base.TemplateName)
+	if err != nil {
+		logging.Logger.Warn("Failed to load custom email template, using the default", zap.String("orgId", b.OrgID), zap.String("template", base.TemplateName), zap.Error(err))
+		return base
+	}
+
+	orgTemplatesMu.Lock()
+	orgTemplates[cacheKey] = cachedOrgTemplate{template: tmpl, expiresAt: time.Now().Add(orgTemplateCacheTTL)}
+	orgTemplatesMu.Unlock()
+	return tmpl
+}
+
+func loadOrgTemplate(ctx context.Context, orgID, templateName string) (*templates.EmailTemplate, error) {
+	if store.Objects == nil {
+		return nil, errors.New("object store is not initialized")
+	}
+	parts := map[string]string{}
+	for _, part := range []string{TemplatePartSubject, TemplatePartHTML, TemplatePartPlainText} {
+		data, err := store.Objects.DownloadObject(ctx, "private/"+OrgTemplateKey(orgID, templateName, part))
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", part, err)
+		}
+		parts[part] = string(data)
+	}
+	return templates.ParseEmailTemplate(templateName, parts[TemplatePartSubject], parts[TemplatePartHTML], parts[TemplatePartPlainText])
+}
@@ -0,0 +1,277 @@
+package notifications
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"go.uber.org/zap"
+)
+
+// MailboxMessage is an email stored by the file or memory email provider, instead of being sent.
+type MailboxMessage struct {
+	ID        string `json:"id"`
+	To        string `json:"to"`
+	FromName  string `json:"fromName,omitempty"`
+	Subject   string `json:"subject"`
+	HTML      string `json:"html"`
+	PlainText string `json:"plainText"`
+	// Links are the URLs in the plain text body, eg. the email verification link.
+	Links  []string  `json:"links"`
+	SentAt time.Time `json:"sentAt"`
+}
+
+// MailboxInterface is implemented by the email senders which store emails instead of sending them.
+type MailboxInterface interface {
+	// Messages returns the stored emails, newest first. If to is not empty, only the emails to that address are returned.
+	Messages(to string) ([]MailboxMessage, error)
+	// Message returns the stored email with the ID, or ErrMailboxMessageNotFound.
+	Message(id string) (*MailboxMessage, error)
+	// Clear deletes all stored emails.
+	Clear() error
+}
+
+// Mailbox is the mailbox of the file or memory email provider, nil for the other providers.
+var Mailbox MailboxInterface
+
+// ErrMailboxMessageNotFound is returned when a stored email does not exist.
+var ErrMailboxMessageNotFound = errors.New("mailbox message not found")
+
+// linkRegex matches the URLs in a plain text email.
+var linkRegex = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// newMailboxMessage returns a new stored email, with the links of the plain text body.
+func newMailboxMessage(to, fromName, subject, htmlContent, plainTextContent string) MailboxMessage {
+	links := []string{}
+	for _, link := range linkRegex.FindAllString(plainTextContent, -1) {
+		// Trailing punctuation is part of the sentence, not of the link.
+		links = append(links, strings.TrimRight(link, ".,;:!?)"))
+	}
+	return MailboxMessage{
+		ID:        uuid.NewString(),
+		To:        to,
+		FromName:  fromName,
+		Subject:   subject,
+		HTML:      htmlContent,
+		PlainText: plainTextContent,
+		Links:     links,
+		SentAt:    time.Now().UTC(),
+	}
+}
+
+func NewMemoryEmailSender(maxMessages int) *MemoryEmailSender {
+	return &MemoryEmailSender{
+		MaxMessages: maxMessages,
+	}
+}
+
+// MemoryEmailSender stores emails in memory, newest last, instead of sending them.
+//
+// The emails are lost when the instance stops, and are not shared between instances.
+type MemoryEmailSender struct {
+	MaxMessages int
+
+	mu       sync.Mutex
+	messages []MailboxMessage
+}
+
+// SendEmail stores the email in memory, deleting the oldest email if the mailbox is full.
+func (s *MemoryEmailSender) SendEmail(to, fromName string, subject, htmlContent, plainTextContent string) error {
+	message := newMailboxMessage(to, fromName, subject, htmlContent, plainTextContent)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, message)
+	if len(s.messages) > s.MaxMessages {
+		s.messages = slices.Delete(s.messages, 0, len(s.messages)-s.MaxMessages)
+	}
+
+	logging.Logger.Debug("Stored email in the memory mailbox", zap.String("to", to), zap.String("subject", subject), zap.String("id", message.ID))
+	return nil
+}
+
+func (s *MemoryEmailSender) Messages(to string) ([]MailboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messages := []MailboxMessage{}
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if to == "" || strings.EqualFold(s.messages[i].To, to) {
+			messages = append(messages, s.messages[i])
+		}
+	}
+	return messages, nil
+}
+
+func (s *MemoryEmailSender) Message(id string) (*MailboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, message := range s.messages {
+		if message.ID == id {
+			return &message, nil
+		}
+	}
+	return nil, ErrMailboxMessageNotFound
+}
+
+func (s *MemoryEmailSender) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+	return nil
+}
+
+func NewFileEmailSender(dir string, maxMessages int) (*FileEmailSender, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create the mailbox directory: %w", err)
+	}
+	return &FileEmailSender{
+		Dir:         dir,
+		MaxMessages: maxMessages,
+	}, nil
+}
+
+// FileEmailSender stores emails as JSON files in a directory instead of sending them.
+//
+// The files are named "<sent at, in unix nanoseconds>-<id>.json", so that they sort by the time they were sent,
+// and can be shared by the instances of a local cluster.
+type FileEmailSender struct {
+	Dir         string
+	MaxMessages int
+
+	// mu serializes the writes of this instance, so that the oldest files are deleted once.
+	mu sync.Mutex
+}
+
+// mailboxFileSuffix is the suffix of the stored email files. Files being written have another suffix, so that they are not read.
+const mailboxFileSuffix = ".json"
+
+// SendEmail writes the email to a new file, deleting the oldest files if the mailbox is full.
+func (s *FileEmailSender) SendEmail(to, fromName string, subject, htmlContent, plainTextContent string) error {
+	message := newMailboxMessage(to, fromName, subject, htmlContent, plainTextContent)
+	data, err := json.MarshalIndent(message, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the email: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Write to a temporary file first, so that readers never see a partial email.
+	name := fmt.Sprintf("%020d-%s", message.SentAt.UnixNano(), message.ID)
+	tmp := filepath.Join(s.Dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write the email: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.Dir, name+mailboxFileSuffix)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write the email: %w", err)
+	}
+
+	names, err := s.fileNames()
+	if err != nil {
+		return err
+	}
+	if len(names) > s.MaxMessages {
+		for _, old := range names[:len(names)-s.MaxMessages] {
+			if err := os.Remove(filepath.Join(s.Dir, old)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				logging.Logger.Warn("Failed to delete an old email from the mailbox", zap.String("file", old), zap.Error(err))
+			}
+		}
+	}
+
+	logging.Logger.Debug("Stored email in the mailbox directory", zap.String("to", to), zap.String("subject", subject), zap.String("id", message.ID))
+	return nil
+}
+
+// fileNames returns the names of the stored email files, oldest first.
+func (s *FileEmailSender) fileNames() ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the mailbox directory: %w", err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), mailboxFileSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	// os.ReadDir sorts by name, which is by the time the emails were sent.
+	return names, nil
+}
+
+// readFile reads a stored email file. It returns ErrMailboxMessageNotFound if the file was deleted in the meantime.
+func (s *FileEmailSender) readFile(name string) (*MailboxMessage, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrMailboxMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the email: %w", err)
+	}
+	var message MailboxMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, fmt.Errorf("failed to decode the email %s: %w", name, err)
+	}
+	return &message, nil
+}
+
+func (s *FileEmailSender) Messages(to string) ([]MailboxMessage, error) {
+	names, err := s.fileNames()
+	if err != nil {
+		return nil, err
+	}
+	messages := []MailboxMessage{}
+	for _, name := range slices.Backward(names) {
+		message, err := s.readFile(name)
+		if errors.Is(err, ErrMailboxMessageNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if to == "" || strings.EqualFold(message.To, to) {
+			messages = append(messages, *message)
+		}
+	}
+	return messages, nil
+}
+
+func (s *FileEmailSender) Message(id string) (*MailboxMessage, error) {
+	// The ID is part of the file name, so it must not be able to escape the directory.
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrMailboxMessageNotFound
+	}
+	names, err := s.fileNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if strings.HasSuffix(name, "-"+id+mailboxFileSuffix) {
+			return s.readFile(name)
+		}
+	}
+	return nil, ErrMailboxMessageNotFound
+}
+
+func (s *FileEmailSender) Clear() error {
+	names, err := s.fileNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(s.Dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete the email %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package notifications
 
 import (
 	"context"
+	"net/mail"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/credentials"
@@ -36,12 +37,17 @@ type SESEmailSender struct {
 }
 
 // SendEmail sends an email using AWS SES.
-func (s *SESEmailSender) SendEmail(to string, subject, htmlContent, plainTextContent string) error {
+func (s *SESEmailSender) SendEmail(to, fromName string, subject, htmlContent, plainTextContent string) error {
+	if fromName == "" {
+		fromName = s.FromName
+	}
+	from := (&mail.Address{Name: fromName, Address: s.FromAddress}).String()
+
 	input := &sesv2.SendEmailInput{
 		Destination: &types.Destination{
 			ToAddresses: []string{to},
 		},
-		FromEmailAddress: aws.String(s.FromAddress),
+		FromEmailAddress: aws.String(from),
 		Content: &types.EmailContent{
 			Simple: &types.Message{
 				Subject: &types.Content{
@@ -2,6 +2,7 @@ package notifications
 
 import (
 	"fmt"
+	"net/mail"
 	"net/smtp"
 	"strings"
 
@@ -26,16 +27,21 @@ type SMTPEmailSender struct {
 }
 
 // SendEmail sends an email using SMTP.
-func (s *SMTPEmailSender) SendEmail(to string, subject, htmlContent, plainTextContent string) error {
+func (s *SMTPEmailSender) SendEmail(to, fromName string, subject, htmlContent, plainTextContent string) error {
 	// Create the authentication for the SMTP server
 	auth := smtp.PlainAuth("", s.FromAddress, s.Password, s.Host)
 
 	// Boundary for the multipart message
 	boundary := "----=_NextPart_000_0000_01DA1234.56789ABC"
 
+	from := s.FromAddress
+	if fromName != "" {
+		from = (&mail.Address{Name: fromName, Address: s.FromAddress}).String()
+	}
+
 	// Create the email headers
 	headers := map[string]string{
-		"From":         s.FromAddress,
+		"From":         from,
 		"To":           strings.Join([]string{to}, ","),
 		"Subject":      subject,
 		"MIME-Version": "1.0",
@@ -74,7 +80,7 @@ func (s *SMTPEmailSender) SendEmail(to string, subject, htmlContent, plainTextCo
 		zap.String("subject", subject),
 		zap.String("html_body", htmlContent),
 		zap.String("plain_text_body", plainTextContent),
-		zap.String("from", s.FromAddress),
+		zap.String("from", from),
 		zap.String("host", s.Host),
 		zap.String("port", s.Port),
 		zap.String("message", message.String()),
@@ -30,13 +30,16 @@ type SendGridEmailSender struct {
 }
 
 // SendEmail sends an email using the SendGrid API.
-func (s *SendGridEmailSender) SendEmail(to string, subject, htmlContent, plainTextContent string) error {
+func (s *SendGridEmailSender) SendEmail(to, fromName string, subject, htmlContent, plainTextContent string) error {
+	if fromName == "" {
+		fromName = s.FromName
+	}
 	message := mail.NewSingleEmail(
-		mail.NewEmail(s.FromName, s.FromAddress), // From
-		subject,                                  // Subject
-		mail.NewEmail("", to),                    // To
-		plainTextContent,                         // Plain text content
-		htmlContent,                              // HTML content
+		mail.NewEmail(fromName, s.FromAddress), // From
+		subject,                                // Subject
+		mail.NewEmail("", to),                  // To
+		plainTextContent,                       // Plain text content
+		htmlContent,                            // HTML content
 	)
 	resp, err := s.Client.Send(message)
 	if err != nil {
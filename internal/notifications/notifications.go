@@ -3,21 +3,34 @@
 //
 // Email and SMS senders are implemented as interfaces, allowing for different implementations.
 // Every implementation must have a config object, which is passed during initialization.
+//
+// Notifications are not sent inline by the request handlers, they are written to the notification_jobs table,
+// preferably in the same transaction as the change which caused them, and sent by the queue workers of every instance.
+// Failed sends are retried with exponential backoff, and given up ("dead" status) after the configured number of attempts,
+// from where they can be retried manually. Jobs with an expiry, eg. an OTP email, are not sent after it.
+//
+// Sends are at least once: a job which was sent, but could not be marked as sent, is sent again after its lease.
 package notifications
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
 	"github.com/nbrglm/nexeres/internal/logging"
 	"github.com/nbrglm/nexeres/internal/notifications/templates"
+	"github.com/nbrglm/nexeres/opts"
 	"go.uber.org/zap"
 )
 
 type EmailSenderInterface interface {
-	SendEmail(to string, subject string, htmlContent, plainTextContent string) error // SendEmail sends an email to the specified recipient with the given subject and body.
+	// SendEmail sends an email to the specified recipient with the given subject and body.
+	// If fromName is not empty, it replaces the configured sender name, the sender address is not changed.
+	SendEmail(to, fromName string, subject string, htmlContent, plainTextContent string) error
 }
 
 type SMSSenderInterface interface {
@@ -48,97 +61,311 @@ func InitEmail() {
 	case "ses":
 		EmailSender = NewSESEmailSender(config.Notifications.Email.SES.Region, config.Notifications.Email.SES.AccessKeyID, config.Notifications.Email.SES.SecretAccessKey, config.Notifications.Email.SES.FromAddress, config.Notifications.Email.SES.FromName)
 		EmailEnabled = true
+	case "memory":
+		sender := NewMemoryEmailSender(config.Notifications.Email.Mailbox.MaxMessages)
+		EmailSender, Mailbox = sender, sender
+		EmailEnabled = true
+	case "file":
+		sender, err := NewFileEmailSender(config.Notifications.Email.Mailbox.Dir, config.Notifications.Email.Mailbox.MaxMessages)
+		if err != nil {
+			EmailEnabled = false
+			logging.Logger.Error("Failed to initialize the file email sender, skipping email sender initialization", zap.String("dir", config.Notifications.Email.Mailbox.Dir), zap.Error(err))
+			return
+		}
+		EmailSender, Mailbox = sender, sender
+		EmailEnabled = true
 	default:
 		EmailEnabled = false
 		logging.Logger.Warn("Unknown email provider, skipping email sender initialization", zap.String("provider", config.Notifications.Email.Provider))
 	}
+
+	if Mailbox != nil && !opts.Debug {
+		logging.Logger.Warn("Emails are stored in a mailbox instead of being sent, and the mailbox is only served in debug mode! Use the file or memory email provider for local development and tests only.", zap.String("provider", config.Notifications.Email.Provider))
+	}
 }
 
+// InitSMS initializes the SMS sender based on the configuration.
+// If the SMS sender is not configured, it logs a warning and skips initialization.
+//
+// Sets SMSEnabled to true if the SMS sender is configured.
 func InitSMS() {
 	if config.Notifications.SMS == nil {
 		logging.Logger.Warn("SMS notifications are not configured, skipping SMS sender initialization... this will result in an error every time an SMS is sent")
 		return
 	}
+	switch config.Notifications.SMS.Provider {
+	case "twilio":
+		twilio := config.Notifications.SMS.Twilio
+		SMSSender = NewTwilioSMSSender(twilio.BaseURL, twilio.AccountSID, twilio.AuthToken, twilio.FromNumber, twilio.MessagingServiceSID)
+		SMSEnabled = true
+	case "http":
+		gateway := config.Notifications.SMS.HTTP
+		SMSSender = NewHTTPSMSSender(gateway.URL, gateway.From, gateway.Headers, gateway.Secret, time.Duration(gateway.Timeout)*time.Millisecond)
+		SMSEnabled = true
+	default:
+		SMSEnabled = false
+		logging.Logger.Warn("Unknown SMS provider, skipping SMS sender initialization", zap.String("provider", config.Notifications.SMS.Provider))
+	}
 }
 
 var ErrEmailSenderNotSet = fmt.Errorf("email sender is not set, please set it using the config file! notifications.email.provider and the respective provider config")
 
-type SendAdminLoginEmailParams struct {
+var ErrSMSSenderNotSet = fmt.Errorf("SMS sender is not set, please set it using the config file! notifications.sms.provider and the respective provider config")
+
+// ErrInvalidPhoneNumber is returned when a phone number is not in the E.164 format.
+var ErrInvalidPhoneNumber = errors.New("invalid phone number, use the E.164 format, eg. +14155552671")
+
+// e164Regex matches phone numbers in the E.164 format: a plus, the country code, and up to 15 digits in total.
+var e164Regex = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// ValidatePhoneNumber returns ErrInvalidPhoneNumber if the phone number is not in the E.164 format.
+func ValidatePhoneNumber(phoneNumber string) error {
+	if !e164Regex.MatchString(phoneNumber) {
+		return ErrInvalidPhoneNumber
+	}
+	return nil
+}
+
+type QueueAdminLoginEmailParams struct {
 	Email     string
 	Code      string
 	ExpiresAt time.Time
+
+	// IdempotencyKey identifies the email, eg. the ID of the login flow, so that it is only queued once.
+	IdempotencyKey string
 }
 
-// SendAdminLoginEmail sends an admin login email to the specified recipient.
-// It uses the global EmailSender instance to send the email.
-// The email includes the OTP code and its expiration time.
-func SendAdminLoginEmail(ctx context.Context, params SendAdminLoginEmailParams) error {
-	rendered, err := templates.RenderEmailTemplate(templates.TemplateData{
-		AppName:     config.Branding.AppName,
-		UserName:    "User",
-		UserEmail:   params.Email,
-		ActionURL:   params.Code,
-		ExpiresAt:   params.ExpiresAt,
-		CompanyName: config.Branding.CompanyNameShort,
-		SupportURL:  config.Branding.SupportURL,
-	}, *templates.AdminLoginTemplate)
+// QueueAdminLoginEmail queues an admin login email to the specified recipient.
+// The email includes the OTP code and its expiration time, and is not sent after the code expires.
+func QueueAdminLoginEmail(ctx context.Context, q *db.Queries, params QueueAdminLoginEmailParams) error {
+	branding := DefaultBranding()
+	data := branding.templateData()
+	data.UserName = "User"
+	data.UserEmail = params.Email
+	data.ActionURL = params.Code
+	data.ExpiresAt = params.ExpiresAt
+	rendered, err := templates.RenderEmailTemplate(data, *templates.AdminLoginTemplate)
 	if err != nil {
 		return err
 	}
 
-	logging.Logger.Debug("Sending admin login email", zap.String("to", params.Email), zap.String("subject", rendered.Subject), zap.String("html_body", rendered.HTMLBody), zap.String("plain_text_body", rendered.PlainTextBody))
+	logging.Logger.Debug("Queueing admin login email", zap.String("to", params.Email), zap.String("subject", rendered.Subject))
+
+	return queueEmail(ctx, q, Job{
+		IdempotencyKey: KindAdminLogin + ":" + params.IdempotencyKey,
+		Kind:           KindAdminLogin,
+		Recipient:      params.Email,
+		ExpiresAt:      params.ExpiresAt,
+	}, EmailMessage{
+		FromName:  branding.SenderName,
+		Subject:   rendered.Subject,
+		HTML:      rendered.HTMLBody,
+		PlainText: rendered.PlainTextBody,
+	})
+}
 
-	err = sendEmail(params.Email, rendered.Subject, rendered.HTMLBody, rendered.PlainTextBody)
+type QueueWelcomeEmailParams struct {
+	User struct {
+		Email     string
+		FirstName *string
+		LastName  *string
+		// Locale is the user's preferred locale, the organization's default locale is used if nil.
+		Locale *string
+	}
+	VerificationToken string
+	ExpiresAt         time.Time
+
+	// IdempotencyKey identifies the email, eg. the ID of the verification token, so that it is only queued once.
+	IdempotencyKey string
+
+	// Branding is the branding of the user's organization, the default branding is used if nil.
+	Branding *Branding
+}
+
+// QueueWelcomeEmail queues a welcome email to the specified recipient.
+// The email also includes a link to verify the email address, and is not sent after the link expires.
+//
+// Pass the querier of the transaction which creates the verification token, so that the email is only sent if it is committed.
+func QueueWelcomeEmail(ctx context.Context, q *db.Queries, params QueueWelcomeEmailParams) error {
+	verificationUrl := fmt.Sprintf("%s?token=%s", config.Notifications.Email.Endpoints.VerificationEmail, params.VerificationToken)
+	branding := DefaultBranding()
+	if params.Branding != nil {
+		branding = *params.Branding
+	}
+	data := branding.templateData()
+	data.UserName = getUserName(params.User.FirstName, params.User.LastName)
+	data.UserEmail = params.User.Email
+	data.ActionURL = verificationUrl
+	data.ExpiresAt = params.ExpiresAt
+	tmpl := branding.emailTemplate(ctx, templates.LocalizedEmailTemplate(templates.VerifyEmailTemplate, branding.locale(params.User.Locale)))
+	data.Locale = tmpl.Locale
+	if data.Locale == "" {
+		// Custom templates are not translated, so dates are formatted in the organization's locale.
+		data.Locale = branding.Locale
+	}
+	rendered, err := templates.RenderEmailTemplate(data, *tmpl)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	logging.Logger.Debug("Queueing welcome email", zap.String("to", params.User.Email), zap.String("subject", rendered.Subject))
+
+	return queueEmail(ctx, q, Job{
+		IdempotencyKey: KindVerifyEmail + ":" + params.IdempotencyKey,
+		Kind:           KindVerifyEmail,
+		Recipient:      params.User.Email,
+		ExpiresAt:      params.ExpiresAt,
+	}, EmailMessage{
+		FromName:  branding.SenderName,
+		Subject:   rendered.Subject,
+		HTML:      rendered.HTMLBody,
+		PlainText: rendered.PlainTextBody,
+	})
 }
 
-type SendWelcomeEmailParams struct {
+type QueueEmailChangeEmailsParams struct {
 	User struct {
+		// Email is the user's current email address.
 		Email     string
 		FirstName *string
 		LastName  *string
+		// Locale is the user's preferred locale, the organization's default locale is used if nil.
+		Locale *string
 	}
+	// NewEmail is the address the user's email is being changed to.
+	NewEmail          string
 	VerificationToken string
 	ExpiresAt         time.Time
+
+	// IdempotencyKey identifies the emails, eg. the ID of the verification token, so that they are only queued once.
+	IdempotencyKey string
+
+	// Branding is the branding of the user's organization, the default branding is used if nil.
+	Branding *Branding
 }
 
-// SendWelcomeEmail sends a welcome email to the specified recipient.
-// It uses the global EmailSender instance to send the email.
-// The email also includes a link to verify the email address.
-func SendWelcomeEmail(ctx context.Context, params SendWelcomeEmailParams) error {
-	verificationUrl := fmt.Sprintf("%s?token=%s", config.Notifications.Email.Endpoints.VerificationEmail, params.VerificationToken)
-	rendered, err := templates.RenderEmailTemplate(templates.TemplateData{
-		AppName:     config.Branding.AppName,
-		UserName:    getUserName(params.User.FirstName, params.User.LastName),
-		UserEmail:   params.User.Email,
-		ActionURL:   verificationUrl,
-		ExpiresAt:   params.ExpiresAt,
-		CompanyName: config.Branding.CompanyNameShort,
-		SupportURL:  config.Branding.SupportURL,
-	}, *templates.VerifyEmailTemplate)
-	if err != nil {
-		return err
+// QueueEmailChangeEmails queues the emails of an email change: a link to confirm the new address, sent to the new address,
+// and a notice sent to the current address, so that the user learns about changes they did not request.
+// Neither email is sent after the link expires.
+//
+// Pass the querier of the transaction which creates the verification token, so that the emails are only sent if it is committed.
+func QueueEmailChangeEmails(ctx context.Context, q *db.Queries, params QueueEmailChangeEmailsParams) error {
+	confirmationUrl := fmt.Sprintf("%s?token=%s", config.Notifications.Email.Endpoints.VerificationEmail, params.VerificationToken)
+	branding := DefaultBranding()
+	if params.Branding != nil {
+		branding = *params.Branding
+	}
+	data := branding.templateData()
+	data.UserName = getUserName(params.User.FirstName, params.User.LastName)
+	data.NewEmail = params.NewEmail
+	data.ExpiresAt = params.ExpiresAt
+
+	emails := []struct {
+		kind      string
+		recipient string
+		tmpl      *templates.EmailTemplate
+		actionURL string
+	}{
+		{KindEmailChange, params.NewEmail, templates.ConfirmEmailChangeTemplate, confirmationUrl},
+		// The notice does not include the link, only the owner of the new address may confirm the change.
+		{KindEmailChangeNotice, params.User.Email, templates.EmailChangeNoticeTemplate, ""},
 	}
+	for _, email := range emails {
+		data := data
+		data.UserEmail = email.recipient
+		data.ActionURL = email.actionURL
+		tmpl := branding.emailTemplate(ctx, templates.LocalizedEmailTemplate(email.tmpl, branding.locale(params.User.Locale)))
+		data.Locale = tmpl.Locale
+		if data.Locale == "" {
+			// Custom templates are not translated, so dates are formatted in the organization's locale.
+			data.Locale = branding.Locale
+		}
+		rendered, err := templates.RenderEmailTemplate(data, *tmpl)
+		if err != nil {
+			return err
+		}
+
+		logging.Logger.Debug("Queueing email change email", zap.String("to", email.recipient), zap.String("subject", rendered.Subject))
 
-	logging.Logger.Debug("Sending welcome email", zap.String("to", params.User.Email), zap.String("subject", rendered.Subject), zap.String("html_body", rendered.HTMLBody), zap.String("plain_text_body", rendered.PlainTextBody))
-	err = sendEmail(params.User.Email, rendered.Subject, rendered.HTMLBody, rendered.PlainTextBody)
+		if err := queueEmail(ctx, q, Job{
+			IdempotencyKey: email.kind + ":" + params.IdempotencyKey,
+			Kind:           email.kind,
+			Recipient:      email.recipient,
+			ExpiresAt:      params.ExpiresAt,
+		}, EmailMessage{
+			FromName:  branding.SenderName,
+			Subject:   rendered.Subject,
+			HTML:      rendered.HTMLBody,
+			PlainText: rendered.PlainTextBody,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type QueueVerificationCodeSMSParams struct {
+	// PhoneNumber in the E.164 format.
+	PhoneNumber string
+	Code        string
+	ExpiresAt   time.Time
+
+	// Locale is the user's preferred locale, the organization's default locale is used if nil.
+	Locale *string
+
+	// IdempotencyKey identifies the message, eg. the ID of the login flow, so that it is only queued once.
+	IdempotencyKey string
+
+	// Branding is the branding of the user's organization, the default branding is used if nil.
+	Branding *Branding
+}
+
+// QueueVerificationCodeSMS queues an SMS with a one-time code to the specified phone number.
+// The message is not sent after the code expires.
+//
+// It has no callers yet, it is the groundwork for one-time codes sent by SMS, eg. for phone verification or MFA.
+func QueueVerificationCodeSMS(ctx context.Context, q *db.Queries, params QueueVerificationCodeSMSParams) error {
+	if err := ValidatePhoneNumber(params.PhoneNumber); err != nil {
+		return err
+	}
+	branding := DefaultBranding()
+	if params.Branding != nil {
+		branding = *params.Branding
+	}
+	data := branding.templateData()
+	data.Code = params.Code
+	data.ExpiresAt = params.ExpiresAt
+	rendered, err := templates.RenderMessageTemplate(data, *templates.LocalizedMessageTemplate(templates.VerificationCodeTemplate, branding.locale(params.Locale)))
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return queueSMS(ctx, q, Job{
+		IdempotencyKey: KindVerificationCode + ":" + params.IdempotencyKey,
+		Kind:           KindVerificationCode,
+		Recipient:      params.PhoneNumber,
+		ExpiresAt:      params.ExpiresAt,
+	}, SMSMessage{
+		Body: rendered.Body,
+	})
 }
 
 // sendEmail is a helper function to send an email using the global EmailSender instance.
-func sendEmail(to string, subject string, htmlContent, plainTextContent string) error {
+// It is called by the queue workers, emails are queued with queueEmail.
+func sendEmail(to, fromName string, subject string, htmlContent, plainTextContent string) error {
 	if EmailSender == nil {
 		return ErrEmailSenderNotSet
 	}
-	return EmailSender.SendEmail(to, subject, htmlContent, plainTextContent)
+	return EmailSender.SendEmail(to, fromName, subject, htmlContent, plainTextContent)
+}
+
+// sendSMS is a helper function to send an SMS using the global SMSSender instance.
+// It is called by the queue workers, messages are queued with queueSMS.
+func sendSMS(to string, message string) error {
+	if SMSSender == nil {
+		return ErrSMSSenderNotSet
+	}
+	return SMSSender.SendSMS(to, message)
 }
 
 // getUserName constructs a user name from the provided first and last names.
@@ -0,0 +1,48 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nbrglm/nexeres/internal/notifications/templates"
+)
+
+// ErrUnknownTemplate is returned when previewing a template which is not a built-in template.
+var ErrUnknownTemplate = errors.New("unknown template")
+
+// PreviewEmail renders a built-in email template with sample data, in the locale or the closest one it is translated to.
+//
+// The template is rendered with the branding, including the organization's custom template, if it has overridden it.
+func PreviewEmail(ctx context.Context, name, locale string, branding Branding) (*templates.RenderedEmailTemplate, error) {
+	tmpl := templates.LookupEmailTemplate(name, locale)
+	if tmpl == nil {
+		return nil, ErrUnknownTemplate
+	}
+	tmpl = branding.emailTemplate(ctx, tmpl)
+	data := branding.sampleData(tmpl.Locale)
+	return templates.RenderEmailTemplate(data, *tmpl)
+}
+
+// PreviewMessage renders a built-in message template with sample data, in the locale or the closest one it is translated to.
+func PreviewMessage(name, locale string, branding Branding) (*templates.RenderedMessageTemplate, error) {
+	tmpl := templates.LookupMessageTemplate(name, locale)
+	if tmpl == nil {
+		return nil, ErrUnknownTemplate
+	}
+	return templates.RenderMessageTemplate(branding.sampleData(tmpl.Locale), *tmpl)
+}
+
+// sampleData returns the sample template data with the branding fields filled in.
+func (b *Branding) sampleData(locale string) templates.TemplateData {
+	if locale == "" {
+		// Custom templates are not translated, so dates are formatted in the organization's locale.
+		locale = b.Locale
+	}
+	data := templates.SampleData(locale)
+	data.AppName = b.AppName
+	data.CompanyName = b.CompanyName
+	data.SupportURL = b.SupportURL
+	data.LogoURL = b.LogoURL
+	data.PrimaryColor = b.PrimaryColor
+	return data
+}
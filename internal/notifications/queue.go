@@ -0,0 +1,380 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Channels of the notification jobs.
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+)
+
+// Kinds of the notification jobs.
+const (
+	KindVerifyEmail       = "verify_email"
+	KindAdminLogin        = "admin_login"
+	KindEmailChange       = "email_change"
+	KindEmailChangeNotice = "email_change_notice"
+
+	KindVerificationCode = "verification_code"
+)
+
+// Statuses of the notification_jobs table.
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusDead    = "dead"
+	StatusExpired = "expired"
+)
+
+// depthInterval is the interval between updates of the queue depth metric.
+const depthInterval = 15 * time.Second
+
+// maxErrorLength is the maximum length of the last error stored with a job.
+const maxErrorLength = 1000
+
+var (
+	stop chan struct{}
+	done chan struct{}
+
+	enqueuedCounter *prometheus.CounterVec
+	sendsCounter    *prometheus.CounterVec
+	sendDuration    *prometheus.HistogramVec
+	queueDepth      *prometheus.GaugeVec
+)
+
+// Job is a notification to be queued.
+type Job struct {
+	// IdempotencyKey identifies the notification, a job with an existing key is not queued again.
+	IdempotencyKey string
+	Kind           string
+	Recipient      string
+	// ExpiresAt is the time after which the notification is not sent, never if zero.
+	ExpiresAt time.Time
+}
+
+// EmailMessage is the payload of an email job, the rendered email.
+type EmailMessage struct {
+	FromName  string `json:"fromName"`
+	Subject   string `json:"subject"`
+	HTML      string `json:"html"`
+	PlainText string `json:"plainText"`
+}
+
+// SMSMessage is the payload of an SMS job, the rendered message.
+type SMSMessage struct {
+	Body string `json:"body"`
+}
+
+// InitQueue registers the notification queue metrics.
+//
+// It must be called before metrics.InitMetrics, and before any notification is queued.
+func InitQueue() {
+	enqueuedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "nexeres",
+			Subsystem: "notifications",
+			Name:      "enqueued_jobs",
+			Help:      "Total number of notification jobs queued, by kind and status: queued, duplicate (idempotency key exists)",
+		},
+		[]string{"kind", "status"},
+	)
+	sendsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "nexeres",
+			Subsystem: "notifications",
+			Name:      "send_attempts",
+			Help:      "Total number of notification send attempts, by channel and status: sent, failed (will be retried), dead (no attempts left), expired (not sent)",
+		},
+		[]string{"channel", "status"},
+	)
+	sendDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "nexeres",
+			Subsystem: "notifications",
+			Name:      "send_duration_seconds",
+			Help:      "Duration of the notification sends to the providers",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"channel"},
+	)
+	queueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "nexeres",
+			Subsystem: "notifications",
+			Name:      "queue_jobs",
+			Help:      "Number of notification jobs in the queue, by status: pending, dead",
+		},
+		[]string{"status"},
+	)
+	metrics.Collectors = append(metrics.Collectors, enqueuedCounter, sendsCounter, sendDuration, queueDepth)
+}
+
+// StartWorkers starts the queue workers.
+//
+// It must be called after the database connection pool and the senders are initialized.
+func StartWorkers() {
+	stop = make(chan struct{})
+	done = make(chan struct{})
+
+	var wg sync.WaitGroup
+	for range config.Notifications.Queue.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work()
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		measureDepth()
+	}()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+}
+
+// Shutdown stops the workers, after the jobs in progress are finished.
+//
+// Jobs claimed but not sent are sent once their lease expires.
+func Shutdown(ctx context.Context) error {
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// queueEmail queues the email job, with the given querier, eg. of the transaction which caused it.
+func queueEmail(ctx context.Context, q *db.Queries, job Job, message EmailMessage) error {
+	if EmailSender == nil {
+		return ErrEmailSenderNotSet
+	}
+	return enqueue(ctx, q, ChannelEmail, job, message)
+}
+
+// queueSMS queues the SMS job, with the given querier, eg. of the transaction which caused it.
+func queueSMS(ctx context.Context, q *db.Queries, job Job, message SMSMessage) error {
+	if SMSSender == nil {
+		return ErrSMSSenderNotSet
+	}
+	return enqueue(ctx, q, ChannelSMS, job, message)
+}
+
+// enqueue writes the job to the queue, unless a job with the same idempotency key exists.
+func enqueue(ctx context.Context, q *db.Queries, channel string, job Job, message any) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		return err
+	}
+	var expiresAt pgtype.Timestamptz
+	if !job.ExpiresAt.IsZero() {
+		expiresAt = pgtype.Timestamptz{Time: job.ExpiresAt, Valid: true}
+	}
+
+	n, err := q.CreateNotificationJob(ctx, db.CreateNotificationJobParams{
+		ID:             id,
+		IdempotencyKey: job.IdempotencyKey,
+		Channel:        channel,
+		Kind:           job.Kind,
+		Recipient:      job.Recipient,
+		Payload:        payload,
+		ExpiresAt:      expiresAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to queue notification: %w", err)
+	}
+	if n == 0 {
+		enqueuedCounter.WithLabelValues(job.Kind, "duplicate").Inc()
+		logging.Logger.Debug("Notification already queued", zap.String("idempotencyKey", job.IdempotencyKey))
+		return nil
+	}
+	enqueuedCounter.WithLabelValues(job.Kind, "queued").Inc()
+	return nil
+}
+
+// work polls the queue for due jobs until Shutdown is called.
+func work() {
+	ticker := time.NewTicker(time.Duration(config.Notifications.Queue.PollInterval) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// Keep claiming while full batches are returned, to catch up with a backlog.
+			for process() == config.Notifications.Queue.BatchSize {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+			}
+		}
+	}
+}
+
+// process claims a batch of due jobs and sends them one after the other. It returns the number of claimed jobs.
+func process() int {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	jobs, err := store.Querier.ClaimNotificationJobs(ctx, db.ClaimNotificationJobsParams{
+		LeaseSeconds: int32(config.Notifications.Queue.Lease),
+		Limit:        int32(config.Notifications.Queue.BatchSize),
+	})
+	cancel()
+	if err != nil {
+		logging.Logger.Error("Failed to claim notification jobs", zap.Error(err))
+		return 0
+	}
+
+	for _, job := range jobs {
+		handle(job)
+	}
+	return len(jobs)
+}
+
+// handle sends the job and records the result, scheduling a retry on failure.
+func handle(job db.ClaimNotificationJobsRow) {
+	expired := job.ExpiresAt.Valid && time.Now().After(job.ExpiresAt.Time)
+	var err error
+	if !expired {
+		start := time.Now()
+		err = send(job)
+		sendDuration.WithLabelValues(job.Channel).Observe(time.Since(start).Seconds())
+	}
+
+	// The result is recorded with a new context, since the send may take a while.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if expired {
+		sendsCounter.WithLabelValues(job.Channel, StatusExpired).Inc()
+		logging.Logger.Warn("Notification expired before it could be sent", zap.String("jobId", job.ID.String()), zap.String("kind", job.Kind))
+		if err := store.Querier.MarkNotificationJobExpired(ctx, job.ID); err != nil {
+			logging.Logger.Error("Failed to mark notification job as expired", zap.String("jobId", job.ID.String()), zap.Error(err))
+		}
+		return
+	}
+
+	if err == nil {
+		sendsCounter.WithLabelValues(job.Channel, StatusSent).Inc()
+		if err := store.Querier.MarkNotificationJobSent(ctx, job.ID); err != nil {
+			logging.Logger.Error("Failed to mark notification job as sent", zap.String("jobId", job.ID.String()), zap.Error(err))
+		}
+		return
+	}
+
+	status := StatusPending
+	if int(job.Attempts)+1 >= config.Notifications.Queue.MaxAttempts {
+		status = StatusDead
+		sendsCounter.WithLabelValues(job.Channel, StatusDead).Inc()
+		logging.Logger.Error("Notification failed, no attempts left", zap.String("jobId", job.ID.String()), zap.String("kind", job.Kind), zap.Error(err))
+	} else {
+		sendsCounter.WithLabelValues(job.Channel, "failed").Inc()
+		logging.Logger.Warn("Notification failed, retrying later", zap.String("jobId", job.ID.String()), zap.String("kind", job.Kind), zap.Error(err))
+	}
+
+	lastError := err.Error()
+	if len(lastError) > maxErrorLength {
+		lastError = lastError[:maxErrorLength]
+	}
+	if err := store.Querier.MarkNotificationJobFailed(ctx, db.MarkNotificationJobFailedParams{
+		Status:        status,
+		NextAttemptAt: pgtype.Timestamptz{Time: time.Now().Add(backoff(int(job.Attempts))), Valid: true},
+		LastError:     &lastError,
+		ID:            job.ID,
+	}); err != nil {
+		logging.Logger.Error("Failed to mark notification job as failed", zap.String("jobId", job.ID.String()), zap.Error(err))
+	}
+}
+
+// send sends the job with the sender of its channel.
+func send(job db.ClaimNotificationJobsRow) error {
+	switch job.Channel {
+	case ChannelEmail:
+		var message EmailMessage
+		if err := json.Unmarshal(job.Payload, &message); err != nil {
+			return fmt.Errorf("invalid email payload: %w", err)
+		}
+		return sendEmail(job.Recipient, message.FromName, message.Subject, message.HTML, message.PlainText)
+	case ChannelSMS:
+		var message SMSMessage
+		if err := json.Unmarshal(job.Payload, &message); err != nil {
+			return fmt.Errorf("invalid SMS payload: %w", err)
+		}
+		return sendSMS(job.Recipient, message.Body)
+	default:
+		return fmt.Errorf("unknown notification channel %q", job.Channel)
+	}
+}
+
+// backoff returns the delay before the next attempt, after the given number of previous attempts:
+// the initial backoff doubled for every previous attempt, capped at the maximum, with up to 10% jitter.
+func backoff(attempts int) time.Duration {
+	delay := time.Duration(config.Notifications.Queue.InitialBackoff) * time.Second
+	maxDelay := time.Duration(config.Notifications.Queue.MaxBackoff) * time.Second
+	for range attempts {
+		delay *= 2
+		if delay >= maxDelay {
+			delay = maxDelay
+			break
+		}
+	}
+	return delay + rand.N(delay/10+1)
+}
+
+// measureDepth updates the queue depth metric until Shutdown is called.
+func measureDepth() {
+	ticker := time.NewTicker(depthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			counts, err := store.Querier.CountNotificationJobsByStatus(ctx)
+			cancel()
+			if err != nil {
+				logging.Logger.Warn("Failed to count notification jobs", zap.Error(err))
+				continue
+			}
+			depth := map[string]float64{StatusPending: 0, StatusDead: 0}
+			for _, count := range counts {
+				depth[count.Status] = float64(count.Count)
+			}
+			for status, count := range depth {
+				queueDepth.WithLabelValues(status).Set(count)
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nbrglm/nexeres/internal/webhooks"
+	"github.com/nbrglm/nexeres/opts"
+)
+
+// Headers of a signed HTTP SMS gateway request.
+const (
+	HeaderSMSTimestamp = "X-Nexeres-SMS-Timestamp"
+	HeaderSMSSignature = "X-Nexeres-SMS-Signature"
+)
+
+func NewHTTPSMSSender(url, from string, headers map[string]string, secret string, timeout time.Duration) *HTTPSMSSender {
+	return &HTTPSMSSender{
+		Client: &http.Client{
+			Timeout: timeout,
+			// Redirects are not followed, so that the message is only sent to the configured URL.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		URL:     url,
+		From:    from,
+		Headers: headers,
+		Secret:  secret,
+	}
+}
+
+// HTTPSMSSender implements the SMSSenderInterface by POSTing the messages to a generic HTTP gateway.
+type HTTPSMSSender struct {
+	Client *http.Client
+	URL    string
+	// From is the sender of the messages, passed to the gateway as is.
+	From string
+	// Headers are added to every request, eg. an Authorization header.
+	Headers map[string]string
+	// Secret signs the requests if not empty, see webhooks.Sign.
+	Secret string
+}
+
+// httpSMSRequest is the body of a request to the HTTP gateway.
+type httpSMSRequest struct {
+	To      string `json:"to"`
+	From    string `json:"from,omitempty"`
+	Message string `json:"message"`
+}
+
+// SendSMS sends an SMS using the HTTP gateway. Any response other than 2xx is an error.
+func (s *HTTPSMSSender) SendSMS(to string, message string) error {
+	body, err := json.Marshal(httpSMSRequest{
+		To:      to,
+		From:    s.From,
+		Message: message,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range s.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Nexeres/"+opts.Version)
+	if s.Secret != "" {
+		timestamp := time.Now().Unix()
+		req.Header.Set(HeaderSMSTimestamp, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(HeaderSMSSignature, webhooks.Sign(s.Secret, timestamp, body))
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("SMS gateway responded with status %d: %s", resp.StatusCode, respBody)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nbrglm/nexeres/opts"
+)
+
+func NewTwilioSMSSender(baseURL, accountSID, authToken, fromNumber, messagingServiceSID string) *TwilioSMSSender {
+	return &TwilioSMSSender{
+		Client:              &http.Client{Timeout: 10 * time.Second},
+		BaseURL:             strings.TrimSuffix(baseURL, "/"),
+		AccountSID:          accountSID,
+		AuthToken:           authToken,
+		FromNumber:          fromNumber,
+		MessagingServiceSID: messagingServiceSID,
+	}
+}
+
+// TwilioSMSSender implements the SMSSenderInterface using the Twilio Messages API.
+type TwilioSMSSender struct {
+	Client *http.Client
+	// BaseURL is the base URL of the Twilio API, eg. https://api.twilio.com.
+	BaseURL    string
+	AccountSID string
+	AuthToken  string
+	// FromNumber is the phone number from which messages are sent, unused if MessagingServiceSID is set.
+	FromNumber string
+	// MessagingServiceSID is the messaging service from which messages are sent, optional.
+	MessagingServiceSID string
+}
+
+// SendSMS sends an SMS using the Twilio Messages API.
+func (s *TwilioSMSSender) SendSMS(to string, message string) error {
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("Body", message)
+	if s.MessagingServiceSID != "" {
+		form.Set("MessagingServiceSid", s.MessagingServiceSID)
+	} else {
+		form.Set("From", s.FromNumber)
+	}
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", s.BaseURL, url.PathEscape(s.AccountSID))
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.AccountSID, s.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "Nexeres/"+opts.Version)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		senderErr := &TwilioSenderError{StatusCode: resp.StatusCode}
+		if err := json.Unmarshal(body, senderErr); err != nil {
+			senderErr.Message = string(body)
+		}
+		return senderErr
+	}
+	return nil
+}
+
+// TwilioSenderError implements the error interface for Twilio errors.
+type TwilioSenderError struct {
+	// Code is the Twilio error code, see https://www.twilio.com/docs/api/errors.
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+	StatusCode int    `json:"-"`
+}
+
+func (e *TwilioSenderError) Error() string {
+	return fmt.Sprintf("Twilio error %d: %s (status code: %d)", e.Code, e.Message, e.StatusCode)
+}
@@ -0,0 +1,169 @@
+package notifications
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbrglm/nexeres/internal/webhooks"
+)
+
+func TestTwilioSMSSender(t *testing.T) {
+	var got *http.Request
+	var form map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("failed to parse the form: %v", err)
+		}
+		form = r.PostForm
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	sender := NewTwilioSMSSender(server.URL+"/", "AC123", "token", "+15005550006", "")
+	if err := sender.SendSMS("+14155552671", "Your code is 123456"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Method != http.MethodPost || got.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" {
+		t.Errorf("unexpected request %s %s", got.Method, got.URL.Path)
+	}
+	if user, pass, ok := got.BasicAuth(); !ok || user != "AC123" || pass != "token" {
+		t.Errorf("unexpected basic auth %q:%q", user, pass)
+	}
+	if ct := got.Header.Get("Content-Type"); ct != "application/x-www-form-urlencoded" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	for key, want := range map[string]string{
+		"To":   "+14155552671",
+		"From": "+15005550006",
+		"Body": "Your code is 123456",
+	} {
+		if v := form[key]; len(v) != 1 || v[0] != want {
+			t.Errorf("form field %s is %v, want %q", key, v, want)
+		}
+	}
+	if _, ok := form["MessagingServiceSid"]; ok {
+		t.Errorf("MessagingServiceSid is set without a messaging service")
+	}
+
+	// The messaging service replaces the sender number.
+	sender = NewTwilioSMSSender(server.URL, "AC123", "token", "+15005550006", "MG123")
+	if err := sender.SendSMS("+14155552671", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if v := form["MessagingServiceSid"]; len(v) != 1 || v[0] != "MG123" {
+		t.Errorf("MessagingServiceSid is %v", v)
+	}
+	if _, ok := form["From"]; ok {
+		t.Errorf("From is set with a messaging service")
+	}
+}
+
+func TestTwilioSMSSenderError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"code":21211,"message":"The 'To' number is not a valid phone number."}`)
+	}))
+	defer server.Close()
+
+	err := NewTwilioSMSSender(server.URL, "AC123", "token", "+15005550006", "").SendSMS("+14155552671", "hello")
+	var senderErr *TwilioSenderError
+	if !errors.As(err, &senderErr) {
+		t.Fatalf("expected a TwilioSenderError, got %v", err)
+	}
+	if senderErr.StatusCode != http.StatusBadRequest || senderErr.Code != 21211 || !strings.Contains(senderErr.Message, "not a valid phone number") {
+		t.Errorf("unexpected error %+v", senderErr)
+	}
+}
+
+func TestHTTPSMSSender(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender := NewHTTPSMSSender(server.URL, "Nexeres", map[string]string{"Authorization": "Bearer key"}, "secret", 5*time.Second)
+	if err := sender.SendSMS("+14155552671", "Your code is 123456"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Method != http.MethodPost {
+		t.Errorf("unexpected method %s", got.Method)
+	}
+	if auth := got.Header.Get("Authorization"); auth != "Bearer key" {
+		t.Errorf("unexpected Authorization header %q", auth)
+	}
+	if ct := got.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	var request httpSMSRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		t.Fatal(err)
+	}
+	if request != (httpSMSRequest{To: "+14155552671", From: "Nexeres", Message: "Your code is 123456"}) {
+		t.Errorf("unexpected body %s", body)
+	}
+
+	timestamp, err := strconv.ParseInt(got.Header.Get(HeaderSMSTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("invalid timestamp header: %v", err)
+	}
+	if signature := got.Header.Get(HeaderSMSSignature); signature != webhooks.Sign("secret", timestamp, body) {
+		t.Errorf("invalid signature %q", signature)
+	}
+
+	// Requests are not signed without a secret.
+	sender.Secret = ""
+	if err := sender.SendSMS("+14155552671", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Header.Get(HeaderSMSSignature) != "" || got.Header.Get(HeaderSMSTimestamp) != "" {
+		t.Errorf("the request is signed without a secret")
+	}
+}
+
+func TestHTTPSMSSenderError(t *testing.T) {
+	for _, status := range []int{http.StatusFound, http.StatusBadRequest, http.StatusInternalServerError} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if status == http.StatusFound {
+				// Redirects are not followed.
+				w.Header().Set("Location", "/elsewhere")
+			}
+			w.WriteHeader(status)
+			io.WriteString(w, "rejected")
+		}))
+
+		err := NewHTTPSMSSender(server.URL, "", nil, "", 5*time.Second).SendSMS("+14155552671", "hello")
+		if err == nil || !strings.Contains(err.Error(), strconv.Itoa(status)) || !strings.Contains(err.Error(), "rejected") {
+			t.Errorf("status %d: unexpected error %v", status, err)
+		}
+		server.Close()
+	}
+}
+
+func TestQueueVerificationCodeSMSRejectsInvalidNumbers(t *testing.T) {
+	for _, number := range []string{"", "4155552671", "+04155552671", "+1 415 555 2671", "+1234567890123456"} {
+		// The number is validated before the querier is used.
+		err := QueueVerificationCodeSMS(t.Context(), nil, QueueVerificationCodeSMSParams{
+			PhoneNumber:    number,
+			Code:           "123456",
+			ExpiresAt:      time.Now().Add(time.Minute),
+			IdempotencyKey: "flow",
+		})
+		if !errors.Is(err, ErrInvalidPhoneNumber) {
+			t.Errorf("%q: expected ErrInvalidPhoneNumber, got %v", number, err)
+		}
+	}
+}
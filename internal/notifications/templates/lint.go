@@ -0,0 +1,109 @@
+package templates
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	texttemplate "text/template"
+)
+
+// LintIssue is a problem with a template, found by Lint.
+type LintIssue struct {
+	Locale   string
+	Template string
+	Part     string
+	// Missing is true if the part does not exist in the locale, eg. a missing translation.
+	Missing bool
+	Message string
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s: %s (%s): %s", templatePath(i.Locale, i.Template, i.Part), i.Template, i.Locale, i.Message)
+}
+
+// partDefines maps the parts of the templates to the suffix of the named template they must define, eg. "VerifyEmailHTML".
+var partDefines = map[string]string{
+	partSubject:   "Subject",
+	partHTML:      "HTML",
+	partPlainText: "Text",
+	partMessage:   "Message",
+}
+
+// Lint checks the email and message templates in the given directories, or the embedded templates if nil.
+//
+// Every template must exist in the default locale, and every localized template should be translated to every locale
+// which has a directory. Every existing part must parse, define its named template, and render with sample data.
+func Lint(emailDir, smsDir *string, defaultLocale string) ([]LintIssue, error) {
+	var issues []LintIssue
+	for _, set := range []struct {
+		fsys  fs.FS
+		specs []templateSpec
+		parts []string
+	}{
+		{templatesFS(emailDir), emailTemplateSpecs, []string{partSubject, partHTML, partPlainText}},
+		{templatesFS(smsDir), messageTemplateSpecs, []string{partMessage}},
+	} {
+		locales, err := listLocales(set.fsys)
+		if err != nil {
+			return nil, err
+		}
+		for _, spec := range set.specs {
+			for _, locale := range locales {
+				if !spec.Localized && locale != defaultLocale {
+					continue
+				}
+				for _, part := range set.parts {
+					issue := lintPart(set.fsys, locale, spec.Name, part, locale == defaultLocale)
+					if issue != nil {
+						issues = append(issues, *issue)
+					}
+				}
+			}
+		}
+	}
+	return issues, nil
+}
+
+// lintPart checks a single part of a template, and returns the issue with it, if any.
+func lintPart(fsys fs.FS, locale, name, part string, isDefault bool) *LintIssue {
+	issue := &LintIssue{Locale: locale, Template: name, Part: part}
+	if !templateExists(fsys, locale, name, part) {
+		issue.Missing = true
+		issue.Message = "missing translation"
+		if isDefault {
+			issue.Message = "missing in the default locale"
+		}
+		return issue
+	}
+
+	define := name + partDefines[part]
+	data := SampleData(locale)
+
+	var err error
+	if part != partMessage {
+		var tmpl *template.Template
+		if tmpl, err = template.ParseFS(fsys, templatePath(locale, name, part)); err == nil {
+			if tmpl.Lookup(define) == nil {
+				err = fmt.Errorf("does not define %q", define)
+			} else {
+				err = tmpl.ExecuteTemplate(io.Discard, define, data)
+			}
+		}
+	} else {
+		// Messages are plain text, see MessageTemplate.
+		var tmpl *texttemplate.Template
+		if tmpl, err = texttemplate.ParseFS(fsys, templatePath(locale, name, part)); err == nil {
+			if tmpl.Lookup(define) == nil {
+				err = fmt.Errorf("does not define %q", define)
+			} else {
+				err = tmpl.ExecuteTemplate(io.Discard, define, data)
+			}
+		}
+	}
+	if err != nil {
+		issue.Message = err.Error()
+		return issue
+	}
+	return nil
+}
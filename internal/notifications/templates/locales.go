@@ -0,0 +1,177 @@
+package templates
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/nbrglm/nexeres/config"
+	"golang.org/x/text/language"
+)
+
+// DefaultLocale returns the locale notifications are sent in when no other locale is known, from the config.
+func DefaultLocale() string {
+	return config.Notifications.DefaultLocale
+}
+
+// NormalizeLocale returns the canonical form of a BCP 47 language tag, eg. "pt-br" becomes "pt-BR".
+func NormalizeLocale(locale string) (string, error) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return "", err
+	}
+	return tag.String(), nil
+}
+
+// FallbackChain returns the locales to look for a template in, most specific first, ending with the default locale.
+//
+// Eg. "pt-BR" gives "pt-BR", "pt", "en" with the default locale "en".
+func FallbackChain(locale string) []string {
+	var chain []string
+	if tag, err := language.Parse(locale); err == nil {
+		for ; tag != language.Und; tag = tag.Parent() {
+			chain = append(chain, tag.String())
+		}
+	}
+	if !slices.Contains(chain, DefaultLocale()) {
+		chain = append(chain, DefaultLocale())
+	}
+	return chain
+}
+
+// SupportedLocales returns the locales at least one template is translated to, sorted.
+func SupportedLocales() []string {
+	var locales []string
+	for _, translations := range emailTemplates {
+		for locale := range translations {
+			if !slices.Contains(locales, locale) {
+				locales = append(locales, locale)
+			}
+		}
+	}
+	for _, translations := range messageTemplates {
+		for locale := range translations {
+			if !slices.Contains(locales, locale) {
+				locales = append(locales, locale)
+			}
+		}
+	}
+	slices.Sort(locales)
+	return locales
+}
+
+// MatchLocale returns the supported locale which best matches the given locale, following its fallback chain,
+// eg. "de" for "de-AT". It returns an empty string if no supported locale matches, apart from the default locale.
+func MatchLocale(locale string) string {
+	supported := SupportedLocales()
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return ""
+	}
+	for ; tag != language.Und; tag = tag.Parent() {
+		if slices.Contains(supported, tag.String()) {
+			return tag.String()
+		}
+	}
+	return ""
+}
+
+// MatchAcceptLanguage returns the supported locale which best matches an Accept-Language header,
+// in the order of preference of the header. It returns an empty string if none matches.
+func MatchAcceptLanguage(header string) string {
+	tags, _, err := language.ParseAcceptLanguage(header)
+	if err != nil {
+		return ""
+	}
+	for _, tag := range tags {
+		if locale := MatchLocale(tag.String()); locale != "" {
+			return locale
+		}
+	}
+	return ""
+}
+
+// dateFormat describes how dates are written in a language.
+type dateFormat struct {
+	months [12]string
+	// date is the fmt format of a date, with the arguments day, month name and year.
+	date string
+	// time is the layout of the time of day.
+	time string
+	// dateTime is the fmt format of a date and time, with the arguments date and time.
+	dateTime string
+}
+
+// dateFormats are the date formats by base language. Languages without a format use ISO 8601 dates.
+var dateFormats = map[string]dateFormat{
+	"en": {
+		months:   [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+		date:     "%[2]s %[1]d, %[3]d",
+		time:     "3:04 PM MST",
+		dateTime: "%s at %s",
+	},
+	"es": {
+		months:   [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		date:     "%[1]d de %[2]s de %[3]d",
+		time:     "15:04 MST",
+		dateTime: "%s a las %s",
+	},
+	"de": {
+		months:   [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		date:     "%[1]d. %[2]s %[3]d",
+		time:     "15:04 MST",
+		dateTime: "%s um %s",
+	},
+	"fr": {
+		months:   [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		date:     "%[1]d %[2]s %[3]d",
+		time:     "15:04 MST",
+		dateTime: "%s à %s",
+	},
+	"pt": {
+		months:   [12]string{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"},
+		date:     "%[1]d de %[2]s de %[3]d",
+		time:     "15:04 MST",
+		dateTime: "%s às %s",
+	},
+}
+
+// dateFormatFor returns the date format of the locale's language, and false if there is none.
+func dateFormatFor(locale string) (dateFormat, bool) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return dateFormat{}, false
+	}
+	base, _ := tag.Base()
+	format, ok := dateFormats[base.String()]
+	return format, ok
+}
+
+// FormatDate formats the date of t, in UTC, in the data's locale, eg. "2. Januar 2026" in German.
+func (d TemplateData) FormatDate(t time.Time) string {
+	t = t.UTC()
+	format, ok := dateFormatFor(d.Locale)
+	if !ok {
+		return t.Format("2006-01-02")
+	}
+	return fmt.Sprintf(format.date, t.Day(), format.months[t.Month()-1], t.Year())
+}
+
+// FormatTime formats the time of day of t, in UTC, in the data's locale, eg. "3:04 PM UTC" in English.
+func (d TemplateData) FormatTime(t time.Time) string {
+	t = t.UTC()
+	format, ok := dateFormatFor(d.Locale)
+	if !ok {
+		return t.Format("15:04 MST")
+	}
+	return t.Format(format.time)
+}
+
+// FormatDateTime formats the date and time of t, in UTC, in the data's locale, eg. "Jan 2, 2026 at 3:04 PM UTC" in English.
+func (d TemplateData) FormatDateTime(t time.Time) string {
+	format, ok := dateFormatFor(d.Locale)
+	if !ok {
+		return t.UTC().Format("2006-01-02 15:04 MST")
+	}
+	return fmt.Sprintf(format.dateTime, d.FormatDate(t), d.FormatTime(t))
+}
@@ -8,8 +8,13 @@ import (
 	"embed"
 	"fmt"
 	"html/template"
+	"io/fs"
+	"maps"
+	"os"
 	"path"
+	"slices"
 	"strings"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/nbrglm/nexeres/config"
@@ -19,16 +24,28 @@ import (
 var templateFs embed.FS
 
 type TemplateData struct {
-	AppName     string
-	UserName    string
-	UserEmail   string
-	ActionURL   string
+	AppName   string
+	UserName  string
+	UserEmail string
+	// NewEmail is the address the user's email is being changed to.
+	NewEmail  string
+	ActionURL string
+	// Code is a one-time code sent to the user, eg. in a verification SMS.
+	Code        string
 	ExpiresAt   time.Time
 	IPAddress   string
 	UserAgent   string
 	Location    string
 	SupportURL  string
 	CompanyName string
+
+	// LogoURL is the URL of the logo shown at the top of HTML emails, omitted if empty.
+	LogoURL string
+	// PrimaryColor is the colour of buttons and links in HTML emails, as a hex colour.
+	PrimaryColor string
+
+	// Locale is the locale the dates are formatted in, the template's locale if empty.
+	Locale string
 }
 
 // EmailTemplate represents the structure of an email template.
@@ -38,7 +55,9 @@ type TemplateData struct {
 // are used to define the content of the email. Only these three fields are rendered for the user's email.
 type EmailTemplate struct {
 	// TemplateName is the name of the template, used for identification.
-	TemplateName  string
+	TemplateName string
+	// Locale of the template, empty for templates which are not built-in, eg. an organization's custom templates.
+	Locale        string
 	Subject       *template.Template
 	HTMLBody      *template.Template
 	PlainTextBody *template.Template
@@ -50,50 +69,293 @@ type EmailTemplate struct {
 // This struct is used to hold the final rendered content after processing the email template with data.
 // It is useful for sending the email with the actual content filled in.
 type RenderedEmailTemplate struct {
-	TemplateName  string
+	TemplateName string
+	// Locale of the template, empty for templates which are not built-in.
+	Locale        string
 	Subject       string
 	HTMLBody      string
 	PlainTextBody string
 }
 
+// MessageTemplate represents the structure of a message (SMS) template.
+//
+// Messages are plain text, so the body is a text/template, which does not escape the data.
 type MessageTemplate struct {
+	// TemplateName is the name of the template, used for identification.
+	TemplateName string
+	// Locale of the template.
+	Locale string
+	Body   *texttemplate.Template
 }
 
-// The following variables store the parsed email and sms templates.
+// RenderedMessageTemplate represents the rendered message template.
+type RenderedMessageTemplate struct {
+	TemplateName string
+	// Locale of the template.
+	Locale string
+	Body   string
+}
+
+// The following variables store the parsed email and sms templates, in the default locale.
+// Use LocalizedEmailTemplate and LocalizedMessageTemplate to get them in another locale.
 //
-// Any template that needs to be used for sending notifications should be defined here.
+// Any template that needs to be used for sending notifications should be defined here, and in templateSpecs.
 var (
 	// VerifyEmailTemplate is the template used for verifying email addresses.
 	VerifyEmailTemplate *EmailTemplate
 	AdminLoginTemplate  *EmailTemplate
+	// ConfirmEmailChangeTemplate is the template used for confirming the new address of an email change.
+	ConfirmEmailChangeTemplate *EmailTemplate
+	// EmailChangeNoticeTemplate is the template used for notifying the old address of an email change.
+	EmailChangeNoticeTemplate *EmailTemplate
+
+	// VerificationCodeTemplate is the message template used for sending one-time codes by SMS.
+	VerificationCodeTemplate *MessageTemplate
+)
+
+// templateSpec describes a built-in template.
+type templateSpec struct {
+	Name string
+	// Localized templates are translated, the others are only sent in the default locale, eg. admin emails.
+	Localized bool
+}
+
+var (
+	emailTemplateSpecs = []templateSpec{
+		{Name: "VerifyEmail", Localized: true},
+		{Name: "AdminLogin"},
+		{Name: "ConfirmEmailChange", Localized: true},
+		{Name: "EmailChangeNotice", Localized: true},
+	}
+	messageTemplateSpecs = []templateSpec{
+		{Name: "VerificationCode", Localized: true},
+	}
+)
+
+// Parts of the built-in templates, inside templs/<locale>/<name>/.
+const (
+	partSubject   = "subject.txt"
+	partHTML      = "body.html"
+	partPlainText = "plain-text.txt"
+	partMessage   = "message.txt"
+)
+
+var (
+	// emailTemplates holds the parsed email templates, by name and locale.
+	emailTemplates = map[string]map[string]*EmailTemplate{}
+	// messageTemplates holds the parsed message templates, by name and locale.
+	messageTemplates = map[string]map[string]*MessageTemplate{}
 )
 
 // Must be called to parse all email templates at application startup.
-// This function initializes the email templates used for notifications.
+// This function initializes the email templates used for notifications, in every locale they are translated to.
+//
+// Every template must exist in the default locale, missing translations fall back to it, see FallbackChain.
 func ParseEmailTemplates() (err error) {
-	VerifyEmailTemplate, err = newVerifyEmailTemplate()
+	fsys := templatesFS(config.Notifications.Email.TemplatesDir)
+	locales, err := listLocales(fsys)
 	if err != nil {
 		return err
 	}
-	AdminLoginTemplate, err = newAdminLoginTemplate()
-	if err != nil {
-		return err
+	defaultLocale := DefaultLocale()
+	emailTemplates = map[string]map[string]*EmailTemplate{}
+	for _, spec := range emailTemplateSpecs {
+		emailTemplates[spec.Name] = map[string]*EmailTemplate{}
+		for _, locale := range locales {
+			if !spec.Localized && locale != defaultLocale {
+				continue
+			}
+			if !templateExists(fsys, locale, spec.Name, partSubject) {
+				continue
+			}
+			tmpl, err := parseEmailTemplateFS(fsys, locale, spec.Name)
+			if err != nil {
+				return fmt.Errorf("failed to parse the %s email template (%s): %w", spec.Name, locale, err)
+			}
+			emailTemplates[spec.Name][locale] = tmpl
+		}
+		if emailTemplates[spec.Name][defaultLocale] == nil {
+			return fmt.Errorf("the %s email template is missing in the default locale %q", spec.Name, defaultLocale)
+		}
 	}
+	VerifyEmailTemplate = emailTemplates["VerifyEmail"][defaultLocale]
+	AdminLoginTemplate = emailTemplates["AdminLogin"][defaultLocale]
+	ConfirmEmailChangeTemplate = emailTemplates["ConfirmEmailChange"][defaultLocale]
+	EmailChangeNoticeTemplate = emailTemplates["EmailChangeNotice"][defaultLocale]
 	return nil
 }
 
+// OverridableTemplates returns the email templates organizations can override with their own, by name.
+//
+// Admin emails are not included, since they are not sent on behalf of an organization.
+func OverridableTemplates() map[string]*EmailTemplate {
+	return map[string]*EmailTemplate{
+		VerifyEmailTemplate.TemplateName:        VerifyEmailTemplate,
+		ConfirmEmailChangeTemplate.TemplateName: ConfirmEmailChangeTemplate,
+		EmailChangeNoticeTemplate.TemplateName:  EmailChangeNoticeTemplate,
+	}
+}
+
+// ParseEmailTemplate parses an email template from its contents, eg. an organization's custom template.
+//
+// The contents can either be plain templates, or define the "{name}HTML", "{name}Text" and "{name}Subject" templates
+// like the built-in ones.
+func ParseEmailTemplate(name, subject, htmlBody, plainTextBody string) (*EmailTemplate, error) {
+	subjectTemplate, err := template.New(fmt.Sprintf("%sSubject", name)).Parse(subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse subject template: %w", err)
+	}
+	htmlTemplate, err := template.New(fmt.Sprintf("%sHTML", name)).Parse(htmlBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML template: %w", err)
+	}
+	plainTextTemplate, err := template.New(fmt.Sprintf("%sText", name)).Parse(plainTextBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse plain text template: %w", err)
+	}
+	return &EmailTemplate{
+		TemplateName:  name,
+		Subject:       subjectTemplate,
+		HTMLBody:      htmlTemplate,
+		PlainTextBody: plainTextTemplate,
+	}, nil
+}
+
 // Must be called to parse all message templates at application startup.
-// This function initializes the sms templates used for notifications.
+// This function initializes the sms templates used for notifications, in every locale they are translated to.
 func ParseMessageTemplates() (err error) {
+	var dir *string
+	if config.Notifications.SMS != nil {
+		dir = config.Notifications.SMS.TemplatesDir
+	}
+	fsys := templatesFS(dir)
+	locales, err := listLocales(fsys)
+	if err != nil {
+		return err
+	}
+	defaultLocale := DefaultLocale()
+	messageTemplates = map[string]map[string]*MessageTemplate{}
+	for _, spec := range messageTemplateSpecs {
+		messageTemplates[spec.Name] = map[string]*MessageTemplate{}
+		for _, locale := range locales {
+			if !spec.Localized && locale != defaultLocale {
+				continue
+			}
+			if !templateExists(fsys, locale, spec.Name, partMessage) {
+				continue
+			}
+			body, err := texttemplate.ParseFS(fsys, templatePath(locale, spec.Name, partMessage))
+			if err != nil {
+				return fmt.Errorf("failed to parse the %s message template (%s): %w", spec.Name, locale, err)
+			}
+			messageTemplates[spec.Name][locale] = &MessageTemplate{TemplateName: spec.Name, Locale: locale, Body: body}
+		}
+		if messageTemplates[spec.Name][defaultLocale] == nil {
+			return fmt.Errorf("the %s message template is missing in the default locale %q", spec.Name, defaultLocale)
+		}
+	}
+	VerificationCodeTemplate = messageTemplates["VerificationCode"][defaultLocale]
 	return nil
 }
 
+// LocalizedEmailTemplate returns the template in the first locale of the fallback chain of the given locale it is translated to.
+//
+// Templates which are not built-in, eg. an organization's custom templates, are returned as is.
+func LocalizedEmailTemplate(tmpl *EmailTemplate, locale string) *EmailTemplate {
+	translations, ok := emailTemplates[tmpl.TemplateName]
+	if !ok || tmpl.Locale == "" {
+		return tmpl
+	}
+	for _, l := range FallbackChain(locale) {
+		if t, ok := translations[l]; ok {
+			return t
+		}
+	}
+	return tmpl
+}
+
+// LocalizedMessageTemplate returns the template in the first locale of the fallback chain of the given locale it is translated to.
+func LocalizedMessageTemplate(tmpl *MessageTemplate, locale string) *MessageTemplate {
+	for _, l := range FallbackChain(locale) {
+		if t, ok := messageTemplates[tmpl.TemplateName][l]; ok {
+			return t
+		}
+	}
+	return tmpl
+}
+
+// EmailTemplateLocales returns the names of the built-in email templates, with the locales they are translated to, sorted.
+func EmailTemplateLocales() map[string][]string {
+	result := map[string][]string{}
+	for name, translations := range emailTemplates {
+		result[name] = slices.Sorted(maps.Keys(translations))
+	}
+	return result
+}
+
+// MessageTemplateLocales returns the names of the built-in message templates, with the locales they are translated to, sorted.
+func MessageTemplateLocales() map[string][]string {
+	result := map[string][]string{}
+	for name, translations := range messageTemplates {
+		result[name] = slices.Sorted(maps.Keys(translations))
+	}
+	return result
+}
+
+// LookupEmailTemplate returns the built-in email template with the name, in the locale or the closest one it is translated to.
+// It returns nil if there is no such template.
+func LookupEmailTemplate(name, locale string) *EmailTemplate {
+	base := emailTemplates[name][DefaultLocale()]
+	if base == nil {
+		return nil
+	}
+	return LocalizedEmailTemplate(base, locale)
+}
+
+// LookupMessageTemplate returns the built-in message template with the name, in the locale or the closest one it is translated to.
+// It returns nil if there is no such template.
+func LookupMessageTemplate(name, locale string) *MessageTemplate {
+	base := messageTemplates[name][DefaultLocale()]
+	if base == nil {
+		return nil
+	}
+	return LocalizedMessageTemplate(base, locale)
+}
+
+// SampleData returns template data filled with sample values, to preview and check the templates.
+//
+// The branding fields are placeholders too, replace them to preview the templates with a real branding.
+func SampleData(locale string) TemplateData {
+	return TemplateData{
+		AppName:      "Nexeres",
+		UserName:     "Jane Doe",
+		UserEmail:    "jane.doe@example.com",
+		NewEmail:     "jane@example.org",
+		ActionURL:    "https://example.com/action?token=sample-token",
+		Code:         "123456",
+		ExpiresAt:    time.Now().Add(15 * time.Minute),
+		IPAddress:    "203.0.113.7",
+		UserAgent:    "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0",
+		Location:     "Berlin, Germany",
+		SupportURL:   "https://example.com/support",
+		CompanyName:  "Example Inc.",
+		PrimaryColor: "#2563eb",
+		Locale:       locale,
+	}
+}
+
 // RenderEmailTemplate renders the email template with the provided data.
 // It takes a TemplateData struct and an EmailTemplate struct as input,
 // and returns a RenderedEmailTemplate struct with the rendered content.
 // The function is expected to replace placeholders in the email template with actual data from TemplateData.
 // If the rendering fails, it returns an error and the original template.
 func RenderEmailTemplate(data TemplateData, tmpl EmailTemplate) (*RenderedEmailTemplate, error) {
+	if data.Locale == "" {
+		data.Locale = tmpl.Locale
+	}
+	if data.Locale == "" {
+		data.Locale = DefaultLocale()
+	}
 	var htmlBody, plainTextBody, subject bytes.Buffer
 
 	htmlTmplName := fmt.Sprintf("%sHTML", tmpl.TemplateName)
@@ -111,40 +373,93 @@ func RenderEmailTemplate(data TemplateData, tmpl EmailTemplate) (*RenderedEmailT
 	}
 	return &RenderedEmailTemplate{
 		TemplateName:  tmpl.TemplateName,
+		Locale:        tmpl.Locale,
 		Subject:       strings.TrimSpace(subject.String()),
 		HTMLBody:      strings.TrimSpace(htmlBody.String()),
 		PlainTextBody: strings.TrimSpace(plainTextBody.String()),
 	}, nil
 }
 
-// findAndParseTemplates is a utility function that searches for a template file in the embedded filesystem or a specified directory in the config.
-func findAndParseTemplates(htmlTmplSubPath, plainTextTmplSubPath, subjectTmplSubPath string) (subjectTemplate, htmlTemplate, plainTextTemplate *template.Template, err error) {
-	if config.Notifications.Email.TemplatesDir != nil {
-		htmlTemplate, err = template.ParseFiles(path.Join(*config.Notifications.Email.TemplatesDir, htmlTmplSubPath))
-		if err != nil {
-			return
-		}
-		plainTextTemplate, err = template.ParseFiles(path.Join(*config.Notifications.Email.TemplatesDir, plainTextTmplSubPath))
-		if err != nil {
-			return
-		}
-		subjectTemplate, err = template.ParseFiles(path.Join(*config.Notifications.Email.TemplatesDir, subjectTmplSubPath))
-		if err != nil {
-			return
-		}
-	} else {
-		subjectTemplate, err = template.ParseFS(templateFs, subjectTmplSubPath)
-		if err != nil {
-			return
-		}
-		htmlTemplate, err = template.ParseFS(templateFs, htmlTmplSubPath)
-		if err != nil {
-			return
+// RenderMessageTemplate renders the message template with the provided data.
+// The "{name}Message" template is executed, and the result is trimmed.
+func RenderMessageTemplate(data TemplateData, tmpl MessageTemplate) (*RenderedMessageTemplate, error) {
+	if data.Locale == "" {
+		data.Locale = tmpl.Locale
+	}
+	if data.Locale == "" {
+		data.Locale = DefaultLocale()
+	}
+	var body bytes.Buffer
+	if err := tmpl.Body.ExecuteTemplate(&body, fmt.Sprintf("%sMessage", tmpl.TemplateName), data); err != nil {
+		return nil, err
+	}
+	return &RenderedMessageTemplate{
+		TemplateName: tmpl.TemplateName,
+		Locale:       tmpl.Locale,
+		Body:         strings.TrimSpace(body.String()),
+	}, nil
+}
+
+// templatesFS returns the filesystem of the templates: the given directory if set, otherwise the embedded templates.
+//
+// Both contain the templates at templs/<locale>/<name>/<part>.
+func templatesFS(dir *string) fs.FS {
+	if dir != nil {
+		return os.DirFS(*dir)
+	}
+	return templateFs
+}
+
+// templatePath returns the path of a part of a template.
+func templatePath(locale, name, part string) string {
+	return path.Join("templs", locale, name, part)
+}
+
+// templateExists reports whether the part of the template exists in the locale.
+func templateExists(fsys fs.FS, locale, name, part string) bool {
+	_, err := fs.Stat(fsys, templatePath(locale, name, part))
+	return err == nil
+}
+
+// listLocales returns the locales with a directory in templs/, in their canonical form.
+func listLocales(fsys fs.FS) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, "templs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the template locales: %w", err)
+	}
+	var locales []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
 		}
-		plainTextTemplate, err = template.ParseFS(templateFs, plainTextTmplSubPath)
-		if err != nil {
-			return
+		locale, err := NormalizeLocale(entry.Name())
+		if err != nil || locale != entry.Name() {
+			return nil, fmt.Errorf("invalid template locale directory %q, use a canonical BCP 47 language tag, eg. en or pt-BR", entry.Name())
 		}
+		locales = append(locales, locale)
 	}
-	return subjectTemplate, htmlTemplate, plainTextTemplate, nil
+	return locales, nil
+}
+
+// parseEmailTemplateFS parses the parts of an email template in the locale.
+func parseEmailTemplateFS(fsys fs.FS, locale, name string) (*EmailTemplate, error) {
+	subjectTemplate, err := template.ParseFS(fsys, templatePath(locale, name, partSubject))
+	if err != nil {
+		return nil, err
+	}
+	htmlTemplate, err := template.ParseFS(fsys, templatePath(locale, name, partHTML))
+	if err != nil {
+		return nil, err
+	}
+	plainTextTemplate, err := template.ParseFS(fsys, templatePath(locale, name, partPlainText))
+	if err != nil {
+		return nil, err
+	}
+	return &EmailTemplate{
+		TemplateName:  name,
+		Locale:        locale,
+		Subject:       subjectTemplate,
+		HTMLBody:      htmlTemplate,
+		PlainTextBody: plainTextTemplate,
+	}, nil
 }
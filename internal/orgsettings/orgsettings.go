@@ -0,0 +1,246 @@
+// Package orgsettings provides the typed schema of the `orgs.settings` JSONB column.
+//
+// The settings are stored as a JSON object, with one key per section (eg. "security").
+// Unknown keys are preserved when a section is updated, so that sections can be added over time.
+package orgsettings
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/tokens"
+	"github.com/nbrglm/nexeres/utils"
+)
+
+// Keys of the sections in `orgs.settings`.
+const (
+	SectionSecurity = "security"
+	SectionBranding = "branding"
+)
+
+// Login methods which can be allowed by an organization.
+const (
+	LoginMethodPassword = "password"
+	LoginMethodOAuth    = "oauth"
+	LoginMethodSSO      = "sso"
+)
+
+// Settings is the typed representation of `orgs.settings`.
+type Settings struct {
+	// Security is the security policy of the organization.
+	Security SecurityPolicy `json:"security"`
+
+	// Branding overrides the global branding in emails and hosted pages.
+	Branding Branding `json:"branding"`
+}
+
+// SecurityPolicy holds the security requirements of an organization.
+//
+// The zero value is the default policy, which applies the global configuration.
+type SecurityPolicy struct {
+	// RequireMFA requires members to complete multi-factor authentication before tokens are issued.
+	//
+	// It cannot be enabled yet, see Validate: there is no endpoint to complete multi-factor authentication,
+	// so enabling it would lock every member out of the organization.
+	RequireMFA bool `json:"requireMfa"`
+
+	// Password holds the password requirements for members signing up to the organization.
+	Password PasswordPolicy `json:"password"`
+
+	// SessionTokenLifetime is the lifetime of session tokens, in seconds.
+	// If zero, `jwt.sessionTokenExpiration` from the config is used.
+	SessionTokenLifetime int `json:"sessionTokenLifetime,omitempty" validate:"omitempty,min=60,max=86400"`
+
+	// RefreshTokenLifetime is the lifetime of refresh tokens (and sessions), in seconds.
+	// If zero, `jwt.refreshTokenExpiration` from the config is used.
+	RefreshTokenLifetime int `json:"refreshTokenLifetime,omitempty" validate:"omitempty,min=3600,max=31536000,gtefield=SessionTokenLifetime"`
+
+	// LoginMethods is the list of login methods members can use.
+	// If empty, all login methods are allowed.
+	LoginMethods []string `json:"loginMethods,omitempty" validate:"omitempty,unique,dive,oneof=password oauth sso"`
+
+	// IPAllowlist is a list of IP addresses or CIDR ranges members can login and refresh sessions from.
+	// If empty, all addresses are allowed.
+	IPAllowlist []string `json:"ipAllowlist,omitempty" validate:"omitempty,max=100,dive,cidr|ip"`
+}
+
+// Branding holds the branding of an organization, for white-labelled emails and hosted pages.
+//
+// Empty fields fall back to the global `branding` config. The logo is the organization's avatar.
+type Branding struct {
+	// DisplayName replaces the application name, eg. "Acme Accounts".
+	DisplayName string `json:"displayName,omitempty" validate:"omitempty,max=100"`
+
+	// CompanyName replaces the company name in the footer of emails.
+	CompanyName string `json:"companyName,omitempty" validate:"omitempty,max=100"`
+
+	// PrimaryColor is the colour of buttons and links, as a hex colour.
+	PrimaryColor string `json:"primaryColor,omitempty" validate:"omitempty,hexcolor"`
+
+	// AccentColor is the secondary colour used by hosted pages, as a hex colour.
+	AccentColor string `json:"accentColor,omitempty" validate:"omitempty,hexcolor"`
+
+	// SupportURL is the support link shown in emails.
+	SupportURL string `json:"supportUrl,omitempty" validate:"omitempty,url"`
+
+	// SenderName is the name emails are sent from. The address is not changed.
+	SenderName string `json:"senderName,omitempty" validate:"omitempty,max=100"`
+
+	// DefaultLocale is the locale of notifications to members who have not chosen one, as a BCP 47 language tag, eg. "de".
+	DefaultLocale string `json:"defaultLocale,omitempty" validate:"omitempty,bcp47_language_tag"`
+
+	// CustomTemplates is the list of email templates the organization has overridden in the object store.
+	// It is managed by the template endpoints, and cannot be set directly.
+	CustomTemplates []string `json:"customTemplates,omitempty"`
+}
+
+// Validate validates the branding.
+func (b *Branding) Validate() error {
+	if strings.ContainsAny(b.SenderName, "\r\n<>\"") {
+		return fmt.Errorf("sender name must not contain line breaks, quotes or angle brackets")
+	}
+	return utils.Validator.Struct(b)
+}
+
+// PasswordPolicy holds the password requirements of an organization.
+//
+// These apply in addition to the global requirements (8 to 32 characters).
+type PasswordPolicy struct {
+	// MinLength is the minimum password length, between 8 and 32. If zero, 8 is used.
+	MinLength int `json:"minLength,omitempty" validate:"omitempty,min=8,max=32"`
+
+	RequireUppercase bool `json:"requireUppercase"`
+	RequireLowercase bool `json:"requireLowercase"`
+	RequireDigit     bool `json:"requireDigit"`
+	RequireSymbol    bool `json:"requireSymbol"`
+}
+
+// Parse parses the raw `orgs.settings` column.
+//
+// Missing sections and fields are left at their zero values, which are the defaults.
+func Parse(raw []byte) (*Settings, error) {
+	s := &Settings{}
+	if len(raw) == 0 {
+		return s, nil
+	}
+	if err := json.Unmarshal(raw, s); err != nil {
+		return nil, fmt.Errorf("failed to parse org settings: %w", err)
+	}
+	return s, nil
+}
+
+// ForOrg parses the settings of the given organization.
+func ForOrg(org *db.Org) (*Settings, error) {
+	return Parse(org.Settings)
+}
+
+// Validate validates the security policy.
+func (p *SecurityPolicy) Validate() error {
+	if p.RequireMFA {
+		return fmt.Errorf("requireMfa is not supported yet, multi-factor authentication cannot be completed")
+	}
+	return utils.Validator.Struct(p)
+}
+
+// Merge sets the given section in the raw `orgs.settings` column, preserving all the other keys.
+func Merge(raw []byte, section string, value any) ([]byte, error) {
+	settings := map[string]json.RawMessage{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			return nil, fmt.Errorf("failed to parse org settings: %w", err)
+		}
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode org settings section %q: %w", section, err)
+	}
+	settings[section] = encoded
+	return json.Marshal(settings)
+}
+
+// Lifetimes returns the token lifetimes for the organization.
+func (p *SecurityPolicy) Lifetimes() tokens.Lifetimes {
+	lifetimes := tokens.DefaultLifetimes()
+	if p.SessionTokenLifetime > 0 {
+		lifetimes.SessionToken = time.Duration(p.SessionTokenLifetime) * time.Second
+	}
+	if p.RefreshTokenLifetime > 0 {
+		lifetimes.RefreshToken = time.Duration(p.RefreshTokenLifetime) * time.Second
+	}
+	return lifetimes
+}
+
+// AllowsLoginMethod reports whether members can login with the given method.
+func (p *SecurityPolicy) AllowsLoginMethod(method string) bool {
+	return len(p.LoginMethods) == 0 || slices.Contains(p.LoginMethods, method)
+}
+
+// AllowsIP reports whether the given client IP is in the allowlist.
+//
+// Invalid entries in the allowlist are ignored, they are rejected when the settings are validated.
+func (p *SecurityPolicy) AllowsIP(ip string) bool {
+	if len(p.IPAllowlist) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, entry := range p.IPAllowlist {
+		if strings.Contains(entry, "/") {
+			if prefix, err := netip.ParsePrefix(entry); err == nil && prefix.Contains(addr) {
+				return true
+			}
+		} else if allowed, err := netip.ParseAddr(entry); err == nil && allowed.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the password against the policy, and returns a user-facing error if it does not comply.
+func (p *PasswordPolicy) Validate(password string) error {
+	minLength := max(p.MinLength, 8)
+	if len(password) < minLength {
+		return fmt.Errorf("password must be at least %d characters long", minLength)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c):
+			hasSymbol = true
+		}
+	}
+
+	missing := []string{}
+	if p.RequireUppercase && !hasUpper {
+		missing = append(missing, "an uppercase letter")
+	}
+	if p.RequireLowercase && !hasLower {
+		missing = append(missing, "a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		missing = append(missing, "a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		missing = append(missing, "a symbol")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("password must contain %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
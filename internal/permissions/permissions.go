@@ -0,0 +1,150 @@
+// Package permissions provides the organization roles and permissions model.
+//
+// A role is a named set of permissions. Every organization gets the built-in roles
+// ('owner', 'admin', 'member'), and can define its own roles in the `org_roles` table.
+// The role of a member is stored in `user_orgs.role`, and the resolved permissions are
+// added to the session token, so that products can check them without calling Nexeres.
+//
+// Permissions are strings in the format "resource:action", eg. "billing:read".
+// A permission of "resource:*" grants every action on the resource, and "*" grants everything.
+package permissions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/nbrglm/nexeres/db"
+)
+
+// Permissions used by Nexeres to protect its own organization management endpoints.
+const (
+	// All grants every permission, used by the 'owner' role.
+	All = "*"
+
+	OrgRead   = "org:read"
+	OrgUpdate = "org:update"
+
+	MembersRead   = "members:read"
+	MembersInvite = "members:invite"
+	MembersUpdate = "members:update"
+	MembersRemove = "members:remove"
+
+	RolesRead   = "roles:read"
+	RolesManage = "roles:manage"
+
+	SettingsRead   = "settings:read"
+	SettingsUpdate = "settings:update"
+
+	AuditLogsRead = "audit_logs:read"
+
+	WebhooksRead   = "webhooks:read"
+	WebhooksManage = "webhooks:manage"
+)
+
+// Built-in role names, available in every organization.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// BuiltinRoles maps the built-in role names to their permissions.
+//
+// These roles cannot be modified or deleted, and custom roles cannot use these names.
+var BuiltinRoles = map[string][]string{
+	RoleOwner:  {All},
+	RoleAdmin:  {"org:*", "members:*", "roles:*", "settings:*", AuditLogsRead, "webhooks:*"},
+	RoleMember: {OrgRead, MembersRead},
+}
+
+// IsBuiltinRole reports whether the given role name is one of the built-in roles.
+func IsBuiltinRole(role string) bool {
+	_, ok := BuiltinRoles[role]
+	return ok
+}
+
+// permissionRegex matches "resource:action" and "resource:*", where both parts are lowercase
+// alphanumerics with '-', '_' or '.' separators.
+var permissionRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,62}:([a-z0-9][a-z0-9_.-]{0,62}|\*)$`)
+
+// roleNameRegex matches a valid custom role name, like "billing-manager".
+var roleNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ValidatePermission checks if the given permission is well-formed.
+//
+// The "*" permission is reserved for the 'owner' role and is not valid for custom roles.
+func ValidatePermission(permission string) error {
+	if !permissionRegex.MatchString(permission) {
+		return fmt.Errorf("invalid permission %q, expected format 'resource:action'", permission)
+	}
+	return nil
+}
+
+// ValidateRoleName checks if the given name can be used for a custom role.
+func ValidateRoleName(name string) error {
+	if !roleNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid role name %q, only lowercase letters, numbers, '-' and '_' are allowed", name)
+	}
+	if IsBuiltinRole(name) {
+		return fmt.Errorf("role name %q is reserved for a built-in role", name)
+	}
+	return nil
+}
+
+// Normalize sorts the given permissions and removes duplicates.
+func Normalize(perms []string) []string {
+	result := slices.Clone(perms)
+	slices.Sort(result)
+	return slices.Compact(result)
+}
+
+// Has reports whether the granted permissions satisfy the required permission.
+func Has(granted []string, required string) bool {
+	for _, g := range granted {
+		if g == All || g == required {
+			return true
+		}
+		if resource, ok := strings.CutSuffix(g, ":*"); ok && strings.HasPrefix(required, resource+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAll reports whether the granted permissions satisfy every required permission.
+func HasAll(granted []string, required ...string) bool {
+	for _, r := range required {
+		if !Has(granted, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolveRolePermissions returns the permissions granted by the given role in the given organization.
+//
+// Built-in roles are resolved without a database call. If a custom role does not exist anymore,
+// an empty list is returned, so that members with a deleted role lose all permissions.
+func ResolveRolePermissions(ctx context.Context, q *db.Queries, orgID uuid.UUID, role string) ([]string, error) {
+	if perms, ok := BuiltinRoles[role]; ok {
+		return perms, nil
+	}
+
+	orgRole, err := q.GetOrgRoleByName(ctx, db.GetOrgRoleByNameParams{
+		OrgID: orgID,
+		Name:  role,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get role %q: %w", role, err)
+	}
+	return orgRole.Permissions, nil
+}
@@ -0,0 +1,541 @@
+// Package privacy exports and erases the data of users, on their request.
+//
+// An export is an archive of the user's profile, organizations, sessions, identities, MFA factors and audit events,
+// stored in the object store and downloaded through a pre-signed URL, until it expires.
+//
+// An erasure is scheduled after a grace period, during which it can be cancelled, and is then done by the janitor.
+// It deletes the user, with every row which belongs to them, and their objects, and removes their email from the
+// payloads of their webhook deliveries.
+// The audit logs are kept, since their hash chains must not be altered. They identify users by ID, or by the hash of
+// the email for failed logins and signups, and are deleted by the audit logs retention.
+package privacy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/audit"
+	"github.com/nbrglm/nexeres/internal/avatars"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/internal/webhooks"
+	"go.uber.org/zap"
+)
+
+const (
+	// FormatJSON is a single JSON document with every section of the export.
+	FormatJSON = "json"
+	// FormatZIP is a ZIP archive with a JSON file per section of the export.
+	FormatZIP = "zip"
+)
+
+// auditPageSize is the number of audit events fetched at once for an export.
+const auditPageSize = 1000
+
+// ErrUserNotFound is returned when the user to export does not exist.
+var ErrUserNotFound = errors.New("user not found")
+
+// ExportKey returns the object store key (without the 'private/' prefix) of a data export archive.
+func ExportKey(userID, exportID, format string) string {
+	return fmt.Sprintf("users/%s/exports/%s.%s", userID, exportID, format)
+}
+
+// Export is a data export archive, stored in the object store.
+type Export struct {
+	ID     uuid.UUID `json:"id"`
+	Format string    `json:"format"`
+	// URL is the pre-signed URL to download the archive, valid until ExpiresAt.
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Profile is the profile section of an export.
+type Profile struct {
+	ID                 uuid.UUID  `json:"id"`
+	Email              string     `json:"email"`
+	EmailVerified      bool       `json:"emailVerified"`
+	FirstName          *string    `json:"firstName"`
+	LastName           *string    `json:"lastName"`
+	AvatarURL          *string    `json:"avatarUrl"`
+	Locale             *string    `json:"locale"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	UpdatedAt          time.Time  `json:"updatedAt"`
+	ErasureScheduledAt *time.Time `json:"erasureScheduledAt"`
+}
+
+// Membership is an organization of the user, in the orgs section of an export.
+type Membership struct {
+	OrgID        uuid.UUID  `json:"orgId"`
+	Slug         string     `json:"slug"`
+	Name         string     `json:"name"`
+	Role         string     `json:"role"`
+	Status       string     `json:"status"`
+	JoinedAt     time.Time  `json:"joinedAt"`
+	LastActiveAt *time.Time `json:"lastActiveAt"`
+}
+
+// Session is a session of the user, without its token hashes, in the sessions section of an export.
+type Session struct {
+	ID          uuid.UUID `json:"id"`
+	OrgID       uuid.UUID `json:"orgId"`
+	MFAVerified bool      `json:"mfaVerified"`
+	IPAddress   string    `json:"ipAddress"`
+	UserAgent   string    `json:"userAgent"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// Identity is an OAuth identity linked to the user, in the identities section of an export.
+type Identity struct {
+	ID                uuid.UUID `json:"id"`
+	Provider          string    `json:"provider"`
+	ProviderUserID    string    `json:"providerUserId"`
+	ProviderUserEmail string    `json:"providerUserEmail"`
+	CreatedAt         time.Time `json:"createdAt"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+// MFAFactor is the metadata of an MFA factor of the user, without its secret, in the MFA factors section of an export.
+type MFAFactor struct {
+	ID         uuid.UUID  `json:"id"`
+	Type       string     `json:"type"`
+	Name       string     `json:"name"`
+	Verified   bool       `json:"verified"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+}
+
+// Data is the content of an export.
+type Data struct {
+	ExportedAt  time.Time         `json:"exportedAt"`
+	Profile     Profile           `json:"profile"`
+	Orgs        []Membership      `json:"orgs"`
+	Sessions    []Session         `json:"sessions"`
+	Identities  []Identity        `json:"identities"`
+	MFAFactors  []MFAFactor       `json:"mfaFactors"`
+	AuditEvents []audit.LogResult `json:"auditEvents"`
+}
+
+// CreateExport collects the data of the user, stores it in the object store in the given format,
+// and returns the export with a pre-signed URL to download it.
+//
+// Returns ErrUserNotFound if the user does not exist.
+func CreateExport(ctx context.Context, userID uuid.UUID, format string) (*Export, error) {
+	data, err := collect(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var archive []byte
+	var contentType string
+	switch format {
+	case FormatJSON:
+		archive, err = json.MarshalIndent(data, "", "  ")
+		contentType = "application/json"
+	case FormatZIP:
+		archive, err = zipArchive(data)
+		contentType = "application/zip"
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the export: %w", err)
+	}
+
+	exportID := uuid.New()
+	key, err := store.Objects.UploadObject(ctx, ExportKey(userID.String(), exportID.String(), format), bytes.NewReader(archive), contentType, "no-store")
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload the export: %w", err)
+	}
+
+	privacy := config.Security.Privacy
+	expiresAt := time.Now().Add(time.Duration(privacy.ExportRetention) * time.Second)
+	if _, err := store.Querier.CreateDataExport(ctx, db.CreateDataExportParams{
+		ID:        exportID,
+		UserID:    userID,
+		ObjectKey: key,
+		Format:    format,
+		ExpiresAt: pgtype.Timestamptz{Time: expiresAt, Valid: true},
+	}); err != nil {
+		if delErr := store.Objects.DeleteObject(ctx, key); delErr != nil {
+			logging.Logger.Warn("Failed to delete an unrecorded export", zap.String("key", key), zap.Error(delErr))
+		}
+		return nil, fmt.Errorf("failed to record the export: %w", err)
+	}
+
+	// The URL must not outlive the archive.
+	urlExpiry := min(privacy.ExportURLExpiry, privacy.ExportRetention)
+	url, err := store.Objects.GetObjectURLWithExpiry(ctx, key, int64(urlExpiry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the URL of the export: %w", err)
+	}
+
+	return &Export{
+		ID:        exportID,
+		Format:    format,
+		URL:       url,
+		ExpiresAt: time.Now().Add(time.Duration(urlExpiry) * time.Second),
+	}, nil
+}
+
+// collect reads the data of the user to export.
+func collect(ctx context.Context, userID uuid.UUID) (*Data, error) {
+	q := store.Querier
+	user, err := q.GetUserByID(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the user: %w", err)
+	}
+
+	data := &Data{
+		ExportedAt: time.Now().UTC(),
+		Profile: Profile{
+			ID:                 user.ID,
+			Email:              user.Email,
+			EmailVerified:      user.EmailVerified,
+			FirstName:          user.FirstName,
+			LastName:           user.LastName,
+			AvatarURL:          user.AvatarUrl,
+			Locale:             user.Locale,
+			CreatedAt:          user.CreatedAt.Time,
+			UpdatedAt:          user.UpdatedAt.Time,
+			ErasureScheduledAt: optionalTime(user.ErasureScheduledAt),
+		},
+		Orgs:       []Membership{},
+		Sessions:   []Session{},
+		Identities: []Identity{},
+		MFAFactors: []MFAFactor{},
+	}
+
+	memberships, err := q.GetUserOrgMemberships(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the organizations of the user: %w", err)
+	}
+	for _, m := range memberships {
+		data.Orgs = append(data.Orgs, Membership{
+			OrgID:        m.Org.ID,
+			Slug:         m.Org.Slug,
+			Name:         m.Org.Name,
+			Role:         m.UserOrg.Role,
+			Status:       m.UserOrg.Status,
+			JoinedAt:     m.UserOrg.JoinedAt.Time,
+			LastActiveAt: optionalTime(m.UserOrg.LastActiveAt),
+		})
+	}
+
+	sessions, err := q.GetSessionsByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the sessions of the user: %w", err)
+	}
+	for _, s := range sessions {
+		data.Sessions = append(data.Sessions, Session{
+			ID:          s.ID,
+			OrgID:       s.OrgID,
+			MFAVerified: s.MfaVerified,
+			IPAddress:   s.IpAddress.String(),
+			UserAgent:   s.UserAgent,
+			CreatedAt:   s.CreatedAt.Time,
+			ExpiresAt:   s.ExpiresAt.Time,
+		})
+	}
+
+	identities, err := q.ListUserOAuthIdentities(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the identities of the user: %w", err)
+	}
+	for _, i := range identities {
+		data.Identities = append(data.Identities, Identity{
+			ID:                i.ID,
+			Provider:          i.Provider,
+			ProviderUserID:    i.ProviderUserID,
+			ProviderUserEmail: i.ProviderUserEmail,
+			CreatedAt:         i.CreatedAt.Time,
+			UpdatedAt:         i.UpdatedAt.Time,
+		})
+	}
+
+	factors, err := q.ListUserMFAFactors(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the MFA factors of the user: %w", err)
+	}
+	for _, f := range factors {
+		data.MFAFactors = append(data.MFAFactors, MFAFactor{
+			ID:         f.ID,
+			Type:       f.Type,
+			Name:       f.Name,
+			Verified:   f.Verified,
+			LastUsedAt: optionalTime(f.LastUsedAt),
+			CreatedAt:  f.CreatedAt.Time,
+			UpdatedAt:  f.UpdatedAt.Time,
+		})
+	}
+
+	data.AuditEvents, err = auditEvents(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// auditEvents returns the audit events of every organization performed by the user, or on the user, eg. failed logins,
+// newest first.
+func auditEvents(ctx context.Context, userID uuid.UUID) ([]audit.LogResult, error) {
+	resourceType := string(audit.ResourceUser)
+	filters := []*audit.Filter{
+		{ActorID: &userID},
+		{ResourceType: &resourceType, ResourceID: &userID},
+	}
+
+	events := []audit.LogResult{}
+	seen := map[uuid.UUID]bool{}
+	for _, filter := range filters {
+		var cursor *audit.Cursor
+		for {
+			logs, next, err := audit.List(ctx, nil, filter, cursor, auditPageSize)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get the audit events of the user: %w", err)
+			}
+			for _, log := range logs {
+				if !seen[log.ID] {
+					seen[log.ID] = true
+					events = append(events, audit.NewLogResult(log))
+				}
+			}
+			if next == nil {
+				break
+			}
+			cursor = next
+		}
+	}
+
+	slices.SortFunc(events, func(a, b audit.LogResult) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return events, nil
+}
+
+// zipArchive returns a ZIP archive with a JSON file per section of the data.
+func zipArchive(data *Data) ([]byte, error) {
+	files := []struct {
+		name    string
+		content any
+	}{
+		{"profile.json", data.Profile},
+		{"orgs.json", data.Orgs},
+		{"sessions.json", data.Sessions},
+		{"identities.json", data.Identities},
+		{"mfa_factors.json", data.MFAFactors},
+		{"audit_events.json", data.AuditEvents},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: data.ExportedAt})
+		if err != nil {
+			return nil, err
+		}
+		content, err := json.MarshalIndent(file.content, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PurgeExpiredExports deletes up to limit exports which expired before the given time, with their archives,
+// and returns the number of deleted exports.
+func PurgeExpiredExports(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error) {
+	exports, err := store.Querier.ListExpiredDataExports(ctx, db.ListExpiredDataExportsParams{Before: before, Limit: limit})
+	if err != nil {
+		return 0, err
+	}
+	var deleted int64
+	for _, export := range exports {
+		if err := deleteExport(ctx, export); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// deleteExport deletes the archive of the export, then the export.
+func deleteExport(ctx context.Context, export db.DataExport) error {
+	if err := store.Objects.DeleteObject(ctx, export.ObjectKey); err != nil && !errors.Is(err, store.ErrObjectNotFound) {
+		return fmt.Errorf("failed to delete the export archive %s: %w", export.ObjectKey, err)
+	}
+	return store.Querier.DeleteDataExport(ctx, export.ID)
+}
+
+// ScheduleErasure schedules the erasure of the user after the grace period, unless it is already scheduled,
+// and returns the time of the erasure.
+//
+// Returns ErrUserNotFound if the user does not exist.
+func ScheduleErasure(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	at := time.Now().Add(time.Duration(config.Security.Privacy.ErasureGracePeriod) * time.Second)
+	scheduledAt, err := store.Querier.ScheduleUserErasure(ctx, db.ScheduleUserErasureParams{
+		ErasureScheduledAt: pgtype.Timestamptz{Time: at, Valid: true},
+		ID:                 userID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, ErrUserNotFound
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return scheduledAt.Time, nil
+}
+
+// CancelErasure cancels the scheduled erasure of the user, and returns whether an erasure was scheduled.
+func CancelErasure(ctx context.Context, userID uuid.UUID) (bool, error) {
+	cancelled, err := store.Querier.CancelUserErasure(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return cancelled > 0, nil
+}
+
+// EraseDue erases up to limit users whose erasure is scheduled before the given time,
+// and returns the number of erased users.
+func EraseDue(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error) {
+	userIDs, err := store.Querier.ListDueUserErasures(ctx, db.ListDueUserErasuresParams{Before: before, Limit: limit})
+	if err != nil {
+		return 0, err
+	}
+	return eraseAll(ctx, userIDs, "erasure")
+}
+
+// EraseDeleted erases up to limit users which were soft deleted before the given time,
+// and returns the number of erased users.
+func EraseDeleted(ctx context.Context, before pgtype.Timestamptz, limit int32) (int64, error) {
+	userIDs, err := store.Querier.ListDeletedUsers(ctx, db.ListDeletedUsersParams{Before: before, Limit: limit})
+	if err != nil {
+		return 0, err
+	}
+	return eraseAll(ctx, userIDs, "deleted")
+}
+
+// eraseAll erases the users in order, and returns the number of erased users, until an erasure fails.
+func eraseAll(ctx context.Context, userIDs []uuid.UUID, reason string) (int64, error) {
+	var erased int64
+	for _, userID := range userIDs {
+		if err := erase(ctx, userID, reason); err != nil {
+			return erased, fmt.Errorf("failed to erase the user %s: %w", userID, err)
+		}
+		erased++
+	}
+	return erased, nil
+}
+
+// Erase deletes the user, with every row which belongs to them, their data exports and their avatar,
+// and removes their email from the payloads of their webhook deliveries. It is a no-op if the user does not exist.
+//
+// The organizations of the user are notified with a user.deleted webhook event.
+func Erase(ctx context.Context, userID uuid.UUID) error {
+	return erase(ctx, userID, "erasure")
+}
+
+// erase erases the user, with the given reason in the user.deleted webhook events.
+func erase(ctx context.Context, userID uuid.UUID, reason string) error {
+	q := store.Querier
+	user, err := q.GetUserByID(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get the user: %w", err)
+	}
+
+	// The objects are deleted first, so that an erasure which fails is retried with the user still known.
+	exports, err := q.ListUserDataExports(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get the exports of the user: %w", err)
+	}
+	for _, export := range exports {
+		if err := deleteExport(ctx, export); err != nil {
+			return err
+		}
+	}
+	if err := avatars.Delete(ctx, userID, user.AvatarUrl); err != nil {
+		return err
+	}
+
+	tx, err := store.PgPool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := store.Querier.WithTx(tx)
+
+	memberships, err := qtx.GetUserOrgMemberships(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get the organizations of the user: %w", err)
+	}
+	// The relation tuples and invitations do not reference the user, so they are not deleted with them.
+	if err := qtx.DeleteUserAuthzTuples(ctx, userID.String()); err != nil {
+		return fmt.Errorf("failed to delete the relation tuples of the user: %w", err)
+	}
+	if err := qtx.DeleteInvitationsByEmail(ctx, user.Email); err != nil {
+		return fmt.Errorf("failed to delete the invitations of the user: %w", err)
+	}
+	if err := qtx.DeleteNotificationJobsByRecipient(ctx, user.Email); err != nil {
+		return fmt.Errorf("failed to delete the notifications of the user: %w", err)
+	}
+	if err := qtx.RedactUserWebhookDeliveries(ctx, userID.String()); err != nil {
+		return fmt.Errorf("failed to redact the webhook deliveries of the user: %w", err)
+	}
+	// Deleting the user also deletes their memberships, sessions, tokens, identities and MFA factors.
+	if err := qtx.DeleteUser(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete the user: %w", err)
+	}
+	for _, m := range memberships {
+		if err := webhooks.Enqueue(ctx, qtx, m.Org.ID, webhooks.EventUserDeleted, map[string]any{
+			"userId": userID,
+			"reason": reason,
+		}); err != nil {
+			return fmt.Errorf("failed to enqueue webhooks: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	for _, m := range memberships {
+		audit.Record(audit.Event{
+			Action:     audit.ActionUserErased,
+			OrgID:      &m.Org.ID,
+			ResourceID: &userID,
+		})
+	}
+	logging.Logger.Info("Erased user", zap.String("userId", userID.String()))
+	return nil
+}
+
+// optionalTime returns the time of a nullable timestamp, nil if it is NULL.
+func optionalTime(t pgtype.Timestamptz) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,334 @@
+package store
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidSignature is returned when the signature of a private object URL does not match.
+	ErrInvalidSignature = errors.New("invalid object URL signature")
+	// ErrURLExpired is returned when a signed object URL has expired.
+	ErrURLExpired = errors.New("object URL expired")
+)
+
+// ObjectInfo holds the metadata of an object stored by the filesystem store.
+type ObjectInfo struct {
+	ContentType  string    `json:"contentType"`
+	CacheControl string    `json:"cacheControl"`
+	Size         int64     `json:"-"`
+	ModTime      time.Time `json:"-"`
+}
+
+func NewFilesystemStore(dir, baseURL, secret string) *FilesystemStore {
+	return &FilesystemStore{
+		Dir:     dir,
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  []byte(secret),
+	}
+}
+
+// FilesystemStore stores the objects in a local directory, the objects under Dir/data, and their metadata under Dir/meta.
+//
+// The objects are served at BaseURL, by the objects route: the public objects to anyone,
+// and the private objects only with a URL signed by GetObjectURLWithExpiry, see VerifySignature.
+type FilesystemStore struct {
+	// Dir is the root directory of the store.
+	Dir string
+	// BaseURL is the URL the objects are served at, without a trailing slash.
+	BaseURL string
+
+	// secret signs the URLs of the private objects.
+	secret []byte
+}
+
+// Init creates the directories of the store, if they do not exist.
+func (s *FilesystemStore) Init(ctx context.Context) error {
+	for _, dir := range []string{s.dataDir(), s.metaDir()} {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return fmt.Errorf("failed to create the objects directory %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// GetBucketName returns an empty string, since the filesystem store has no bucket.
+func (s *FilesystemStore) GetBucketName() string {
+	return ""
+}
+
+func (s *FilesystemStore) dataDir() string {
+	return filepath.Join(s.Dir, "data")
+}
+
+func (s *FilesystemStore) metaDir() string {
+	return filepath.Join(s.Dir, "meta")
+}
+
+// paths returns the paths of the object and of its metadata, or ErrInvalidObjectKey if the key would escape the directory.
+func (s *FilesystemStore) paths(key string) (string, string, error) {
+	if err := checkObjectKey(key); err != nil {
+		return "", "", err
+	}
+	return filepath.Join(s.dataDir(), filepath.FromSlash(key)), filepath.Join(s.metaDir(), filepath.FromSlash(key)+".json"), nil
+}
+
+// checkObjectKey returns ErrInvalidObjectKey if the key is not prefixed with 'private/' or 'public/',
+// or has empty, '.' or '..' segments.
+func checkObjectKey(key string) error {
+	if !strings.HasPrefix(key, "private/") && !strings.HasPrefix(key, "public/") || !validSegments(key) {
+		return ErrInvalidObjectKey
+	}
+	return nil
+}
+
+// validSegments returns whether the slash separated path has no empty, '.' or '..' segments, nor backslashes.
+func validSegments(p string) bool {
+	for segment := range strings.SplitSeq(p, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, "\\\x00") {
+			return false
+		}
+	}
+	return true
+}
+
+// writeFile writes the file through a temporary file in the same directory, so that readers never see a partial file.
+func writeFile(name string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *FilesystemStore) uploadObjectInternal(key string, file io.Reader, contentType, cacheControl string) (string, error) {
+	dataPath, metaPath, err := s.paths(key)
+	if err != nil {
+		return "", err
+	}
+	meta, err := json.Marshal(ObjectInfo{ContentType: contentType, CacheControl: cacheControl})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal the metadata of the object with key %s: %w", key, err)
+	}
+	if err := writeFile(dataPath, file); err != nil {
+		return "", fmt.Errorf("failed to write the object with key %s: %w", key, err)
+	}
+	if err := writeFile(metaPath, strings.NewReader(string(meta))); err != nil {
+		return "", fmt.Errorf("failed to write the metadata of the object with key %s: %w", key, err)
+	}
+	return key, nil
+}
+
+func (s *FilesystemStore) UploadObject(ctx context.Context, key string, file io.Reader, contentType, cacheControl string) (string, error) {
+	return s.uploadObjectInternal("private/"+key, file, contentType, cacheControl)
+}
+
+func (s *FilesystemStore) UploadPublicObject(ctx context.Context, key string, file io.Reader, contentType, cacheControl string) (string, error) {
+	return s.uploadObjectInternal("public/"+key, file, contentType, cacheControl)
+}
+
+// DownloadObject reads the object with the given key.
+// The key should be prefixed with 'private/' or 'public/' as per the upload methods.
+func (s *FilesystemStore) DownloadObject(ctx context.Context, key string) ([]byte, error) {
+	dataPath, _, err := s.paths(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(dataPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the object with key %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// OpenObject opens the object with the given key, to serve it, and returns its metadata.
+// The key should be prefixed with 'private/' or 'public/' as per the upload methods.
+//
+// The caller must close the file.
+func (s *FilesystemStore) OpenObject(key string) (*os.File, *ObjectInfo, error) {
+	dataPath, metaPath, err := s.paths(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(dataPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open the object with key %s: %w", key, err)
+	}
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		file.Close()
+		return nil, nil, ErrObjectNotFound
+	}
+
+	info := ObjectInfo{}
+	if meta, err := os.ReadFile(metaPath); err == nil {
+		if err := json.Unmarshal(meta, &info); err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("failed to unmarshal the metadata of the object with key %s: %w", key, err)
+		}
+	}
+	if info.ContentType == "" {
+		// The metadata is missing, eg. for objects copied into the directory by hand.
+		info.ContentType = mime.TypeByExtension(path.Ext(key))
+	}
+	if info.ContentType == "" {
+		info.ContentType = "application/octet-stream"
+	}
+	info.Size = stat.Size()
+	info.ModTime = stat.ModTime()
+	return file, &info, nil
+}
+
+// DeleteObject deletes the object with the given key, and the directories left empty.
+// The key should be prefixed with 'private/' or 'public/' as per the upload methods.
+func (s *FilesystemStore) DeleteObject(ctx context.Context, key string) error {
+	dataPath, metaPath, err := s.paths(key)
+	if err != nil {
+		return err
+	}
+	for _, p := range []struct{ name, root string }{{dataPath, s.dataDir()}, {metaPath, s.metaDir()}} {
+		if err := os.Remove(p.name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete the object with key %s: %w", key, err)
+		}
+		for dir := filepath.Dir(p.name); dir != p.root; dir = filepath.Dir(dir) {
+			// Removing a directory which is not empty fails, which ends the pruning.
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// ListObjects lists the keys of the objects starting with the given prefix, sorted.
+// The prefix should start with 'private/' or 'public/' as per the upload methods.
+func (s *FilesystemStore) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	// Only the deepest directory containing every matching key is walked.
+	start := s.dataDir()
+	if dir, _, ok := cutLast(prefix, "/"); ok {
+		if !validSegments(dir) {
+			return nil, ErrInvalidObjectKey
+		}
+		start = filepath.Join(start, filepath.FromSlash(dir))
+	}
+	err := filepath.WalkDir(start, func(name string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dataDir(), name)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the objects with prefix %s: %w", prefix, err)
+	}
+	return keys, nil
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// objectPath returns the URL path of the object, relative to BaseURL, with every segment escaped.
+func objectPath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// GetObjectURL returns the URL of the object with the given key.
+// The key should be prefixed with 'private/' or 'public/' as per the upload methods.
+// Note: Private objects are only served with the URLs returned by GetObjectURLWithExpiry.
+func (s *FilesystemStore) GetObjectURL(ctx context.Context, key string) (string, error) {
+	if err := checkObjectKey(key); err != nil {
+		return "", err
+	}
+	return s.BaseURL + "/" + objectPath(key), nil
+}
+
+// GetObjectURLWithExpiry returns a URL of the object with the given key, signed with HMAC-SHA256, valid for expiry seconds.
+// The key should be prefixed with 'private/' or 'public/' as per the upload methods.
+func (s *FilesystemStore) GetObjectURLWithExpiry(ctx context.Context, key string, expiry int64) (string, error) {
+	if err := checkObjectKey(key); err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Unix()+expiry, 10)
+	query := url.Values{
+		"expires":   {expires},
+		"signature": {s.sign(key, expires)},
+	}
+	return s.BaseURL + "/" + objectPath(key) + "?" + query.Encode(), nil
+}
+
+// sign returns the signature of the URL of the object, which expires at the given Unix time.
+func (s *FilesystemStore) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks the expiry and signature of a URL returned by GetObjectURLWithExpiry.
+// It returns ErrURLExpired or ErrInvalidSignature if the URL must not be served.
+func (s *FilesystemStore) VerifySignature(key, expires, signature string) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.sign(key, expires))) {
+		return ErrInvalidSignature
+	}
+	if time.Now().Unix() > expiresAt {
+		return ErrURLExpired
+	}
+	return nil
+}
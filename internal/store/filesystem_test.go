@@ -0,0 +1,204 @@
+package store
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckObjectKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"public/avatars/user/256.png", true},
+		{"private/exports/user/export.zip", true},
+		{"public/a..b/file", true},
+		{"public/.hidden", true},
+		{"", false},
+		{"public", false},
+		{"public/", false},
+		{"other/file", false},
+		{"/public/file", false},
+		{"public/../private/file", false},
+		{"public/a/../../file", false},
+		{"public/..", false},
+		{"public/./file", false},
+		{"public//file", false},
+		{"public/file/", false},
+		{`public/..\..\file`, false},
+		{`public\..\..\file`, false},
+		{`public/a\b`, false},
+		{"public/file\x00.png", false},
+	}
+	for _, tt := range tests {
+		err := checkObjectKey(tt.key)
+		if tt.valid && err != nil {
+			t.Errorf("checkObjectKey(%q) = %v, want nil", tt.key, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidObjectKey) {
+			t.Errorf("checkObjectKey(%q) = %v, want ErrInvalidObjectKey", tt.key, err)
+		}
+	}
+}
+
+func TestValidSegments(t *testing.T) {
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{"a", true},
+		{"a/b/c", true},
+		{"a/...", true},
+		{"", false},
+		{"/a", false},
+		{"a/", false},
+		{"a//b", false},
+		{".", false},
+		{"..", false},
+		{"a/../b", false},
+		{`a\b`, false},
+		{"a/\x00", false},
+	}
+	for _, tt := range tests {
+		if got := validSegments(tt.path); got != tt.valid {
+			t.Errorf("validSegments(%q) = %v, want %v", tt.path, got, tt.valid)
+		}
+	}
+}
+
+func newTestFilesystemStore(t *testing.T) *FilesystemStore {
+	t.Helper()
+	s := NewFilesystemStore(t.TempDir(), "https://auth.example.com/objects/", "a-long-random-secret-of-32-chars-or-more")
+	if err := s.Init(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestVerifySignature(t *testing.T) {
+	s := newTestFilesystemStore(t)
+	key := "private/exports/user/export.zip"
+
+	signed, err := s.GetObjectURLWithExpiry(t.Context(), key, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/objects/"+key {
+		t.Fatalf("unexpected path %q", u.Path)
+	}
+	expires, signature := u.Query().Get("expires"), u.Query().Get("signature")
+	if err := s.VerifySignature(key, expires, signature); err != nil {
+		t.Fatalf("VerifySignature of a valid URL = %v", err)
+	}
+
+	past := strconv.FormatInt(time.Now().Unix()-1, 10)
+	later := strconv.FormatInt(time.Now().Unix()+3600, 10)
+	other := NewFilesystemStore(s.Dir, s.BaseURL, "another-long-random-secret-of-32-chars")
+
+	tests := []struct {
+		name      string
+		key       string
+		expires   string
+		signature string
+		want      error
+	}{
+		{"other key", "private/exports/other/export.zip", expires, signature, ErrInvalidSignature},
+		{"extended expiry", key, later, signature, ErrInvalidSignature},
+		{"tampered signature", key, expires, strings.ToUpper(signature), ErrInvalidSignature},
+		{"truncated signature", key, expires, signature[:len(signature)-1], ErrInvalidSignature},
+		{"empty signature", key, expires, "", ErrInvalidSignature},
+		{"invalid expiry", key, "soon", signature, ErrInvalidSignature},
+		{"other secret", key, expires, other.sign(key, expires), ErrInvalidSignature},
+		{"expired", key, past, s.sign(key, past), ErrURLExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.VerifySignature(tt.key, tt.expires, tt.signature); !errors.Is(err, tt.want) {
+				t.Errorf("VerifySignature = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilesystemStoreRejectsInvalidKeys(t *testing.T) {
+	s := newTestFilesystemStore(t)
+	// A file outside of the store, which must not be reachable.
+	outside := filepath.Join(filepath.Dir(s.Dir), "outside.txt")
+	if err := os.WriteFile(outside, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outside)
+
+	for _, key := range []string{"../../outside.txt", "public/../../../outside.txt", `public/..\..\..\outside.txt`, "public/a\x00"} {
+		if _, err := s.UploadPublicObject(t.Context(), strings.TrimPrefix(key, "public/"), strings.NewReader("x"), "text/plain", ""); !errors.Is(err, ErrInvalidObjectKey) {
+			t.Errorf("UploadPublicObject(%q) = %v, want ErrInvalidObjectKey", key, err)
+		}
+		if _, err := s.DownloadObject(t.Context(), key); !errors.Is(err, ErrInvalidObjectKey) {
+			t.Errorf("DownloadObject(%q) = %v, want ErrInvalidObjectKey", key, err)
+		}
+		if _, _, err := s.OpenObject(key); !errors.Is(err, ErrInvalidObjectKey) {
+			t.Errorf("OpenObject(%q) = %v, want ErrInvalidObjectKey", key, err)
+		}
+		if err := s.DeleteObject(t.Context(), key); !errors.Is(err, ErrInvalidObjectKey) {
+			t.Errorf("DeleteObject(%q) = %v, want ErrInvalidObjectKey", key, err)
+		}
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("the file outside of the store was touched: %v", err)
+	}
+}
+
+func TestListObjects(t *testing.T) {
+	s := newTestFilesystemStore(t)
+	for _, key := range []string{"exports/a/1.zip", "exports/a/2.zip", "exports/b/1.zip"} {
+		if _, err := s.UploadObject(t.Context(), key, strings.NewReader("x"), "application/zip", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.UploadPublicObject(t.Context(), "avatars/a/256.png", strings.NewReader("x"), "image/png", ""); err != nil {
+		t.Fatal(err)
+	}
+	// A file beside the store, which a traversal prefix would reach.
+	if err := os.WriteFile(filepath.Join(s.Dir, "outside.txt"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"private/exports/a/", []string{"private/exports/a/1.zip", "private/exports/a/2.zip"}},
+		{"private/exports/", []string{"private/exports/a/1.zip", "private/exports/a/2.zip", "private/exports/b/1.zip"}},
+		{"private/exports/a/1", []string{"private/exports/a/1.zip"}},
+		{"public/", []string{"public/avatars/a/256.png"}},
+		{"private/missing/", []string{}},
+		{"..", []string{}},
+	}
+	for _, tt := range tests {
+		got, err := s.ListObjects(t.Context(), tt.prefix)
+		if err != nil {
+			t.Errorf("ListObjects(%q) = %v", tt.prefix, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ListObjects(%q) = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+
+	for _, prefix := range []string{"../", "../outside", "private/../../", "private/../../outside", `private/..\..\outside/`, "private//"} {
+		if keys, err := s.ListObjects(t.Context(), prefix); !errors.Is(err, ErrInvalidObjectKey) {
+			t.Errorf("ListObjects(%q) = %v, %v, want ErrInvalidObjectKey", prefix, keys, err)
+		}
+	}
+}
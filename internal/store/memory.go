@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		objects: map[string][]byte{},
+	}
+}
+
+// MemoryStore keeps the objects in memory, for tests.
+//
+// The objects are lost when the instance stops, and are not shared between instances.
+// The URLs of the objects use the "memory" scheme, they are not served.
+type MemoryStore struct {
+	mu sync.RWMutex
+	// objects holds the data of the objects, by key. The content type and cache control are not kept, since the objects are not served.
+	objects map[string][]byte
+}
+
+func (s *MemoryStore) Init(ctx context.Context) error {
+	return nil
+}
+
+// GetBucketName returns an empty string, since the memory store has no bucket.
+func (s *MemoryStore) GetBucketName() string {
+	return ""
+}
+
+func (s *MemoryStore) uploadObjectInternal(key string, file io.Reader) (string, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the object with key %s: %w", key, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return key, nil
+}
+
+func (s *MemoryStore) UploadObject(ctx context.Context, key string, file io.Reader, contentType, cacheControl string) (string, error) {
+	return s.uploadObjectInternal("private/"+key, file)
+}
+
+func (s *MemoryStore) UploadPublicObject(ctx context.Context, key string, file io.Reader, contentType, cacheControl string) (string, error) {
+	return s.uploadObjectInternal("public/"+key, file)
+}
+
+// DownloadObject returns a copy of the object with the given key.
+// The key should be prefixed with 'private/' or 'public/' as per the upload methods.
+func (s *MemoryStore) DownloadObject(ctx context.Context, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return slices.Clone(data), nil
+}
+
+// DeleteObject deletes the object with the given key, if it exists.
+// The key should be prefixed with 'private/' or 'public/' as per the upload methods.
+func (s *MemoryStore) DeleteObject(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+// ListObjects lists the keys of the objects starting with the given prefix, sorted.
+// The prefix should start with 'private/' or 'public/' as per the upload methods.
+func (s *MemoryStore) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := []string{}
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+// GetObjectURL returns the URL of the object with the given key, eg. "memory:///public/logo.png".
+// The key should be prefixed with 'private/' or 'public/' as per the upload methods.
+func (s *MemoryStore) GetObjectURL(ctx context.Context, key string) (string, error) {
+	return "memory:///" + key, nil
+}
+
+// GetObjectURLWithExpiry returns the URL of the object with the given key, with its expiry as a Unix time.
+// The key should be prefixed with 'private/' or 'public/' as per the upload methods.
+func (s *MemoryStore) GetObjectURLWithExpiry(ctx context.Context, key string, expiry int64) (string, error) {
+	return fmt.Sprintf("memory:///%s?expires=%d", key, time.Now().Unix()+expiry), nil
+}
@@ -2,12 +2,25 @@ package store
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+
+	"github.com/nbrglm/nexeres/config"
+)
+
+var (
+	// ErrObjectNotFound is returned when the requested object does not exist in the object store.
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrInvalidObjectKey is returned when a key is not prefixed with 'private/' or 'public/', or is not a valid path,
+	// by the stores which map keys to paths, eg. the filesystem store.
+	ErrInvalidObjectKey = errors.New("invalid object key")
 )
 
 // ObjectStore defines the interface for an object storage service.
 //
-// The default implementation is S3/MinIO/S3-like, but it can be extended to support other object storage services.
+// The default implementation is S3/MinIO/S3-like, the filesystem and memory implementations are selected
+// with the objects backend in the config, see config.ObjectsConfig.
 type ObjectStore interface {
 	// Init initializes the object store.
 	// It returns an error if the initialization fails.
@@ -32,20 +45,22 @@ type ObjectStore interface {
 	// Use this method with caution, as it can expose sensitive data if not handled properly.
 	UploadPublicObject(ctx context.Context, key string, file io.Reader, contentType, cacheControl string) (string, error)
 
-	// TODO: Implement this method to download an object from the bucket IF NEED BE.
 	// DownloadObject downloads an object from the specified bucket with the given key.
 	// It returns the data as a byte slice and an error if the download fails.
-	// DownloadObject(ctx context.Context, key string) ([]byte, error)
+	// NOTE: This method expects the key to be prefixed with 'private/' or 'public/' as per the upload methods.
+	//
+	// Returns ErrObjectNotFound if the object does not exist.
+	DownloadObject(ctx context.Context, key string) ([]byte, error)
 
 	// DeleteObject deletes an object from the specified bucket with the given key.
 	// It returns an error if the deletion fails.
 	// NOTE: This method expects the key to be prefixed with 'private/' or 'public/' as per the upload methods.
 	DeleteObject(ctx context.Context, key string) error
 
-	// TODO: Implement this method to return a list of object keys in the bucket IF NEED BE.
-	// ListObjects lists all objects in the specified bucket.
-	// It returns a slice of object keys and an error if the listing fails.
-	// ListObjects(bucket string) ([]string, error)
+	// ListObjects lists the keys of the objects starting with the given prefix, eg. 'public/users/<id>/', sorted.
+	// It returns an error if the listing fails.
+	// NOTE: The prefix is matched against the complete keys, prefixed with 'private/' or 'public/' as per the upload methods.
+	ListObjects(ctx context.Context, prefix string) ([]string, error)
 
 	// GetObjectURL returns the URL of an object in the specified bucket with the given key.
 	// It returns the URL as a string and an error if the retrieval fails.
@@ -59,8 +74,17 @@ type ObjectStore interface {
 
 var Objects ObjectStore
 
-func InitS3Store(ctx context.Context) error {
-	// Initialize the S3 store
-	Objects = NewS3Store()
+// InitObjectStore initializes the object store, with the backend selected in the config, see config.ObjectsConfig.
+func InitObjectStore(ctx context.Context) error {
+	switch config.Stores.Objects.Backend {
+	case "s3":
+		Objects = NewS3Store()
+	case "filesystem":
+		Objects = NewFilesystemStore(config.Stores.Objects.Filesystem.Dir, config.Stores.Objects.Filesystem.BaseURL, config.Stores.Objects.Filesystem.Secret)
+	case "memory":
+		Objects = NewMemoryStore()
+	default:
+		return fmt.Errorf("unknown objects backend: %s", config.Stores.Objects.Backend)
+	}
 	return Objects.Init(ctx)
 }
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nbrglm/nexeres/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// The Redis client shared by the cache and the rate limiter, nil when the cache backend is not "redis".
+//
+// It is a standalone, sentinel (failover) or cluster client, depending on the mode of the Redis config.
+var Redis redis.UniversalClient
+
+// InitRedis initializes the shared Redis client, if the cache backend is "redis".
+//
+// It should be called at the start of the application, before the cache and the rate limiter are initialized.
+func InitRedis() error {
+	if config.Stores.Cache.Backend != "redis" {
+		return nil
+	}
+
+	cfg := config.Stores.Redis
+	options := &redis.UniversalOptions{
+		DB:              cfg.DB,
+		MasterName:      cfg.MasterName,
+		PoolSize:        cfg.Pool.Size,
+		MinIdleConns:    cfg.Pool.MinIdleConns,
+		MaxIdleConns:    cfg.Pool.MaxIdleConns,
+		ConnMaxIdleTime: time.Duration(cfg.Pool.ConnMaxIdleTime) * time.Second,
+		ConnMaxLifetime: time.Duration(cfg.Pool.ConnMaxLifetime) * time.Second,
+		PoolTimeout:     time.Duration(cfg.Pool.PoolTimeout) * time.Millisecond,
+		DialTimeout:     time.Duration(cfg.Pool.DialTimeout) * time.Millisecond,
+		ReadTimeout:     time.Duration(cfg.Pool.ReadTimeout) * time.Millisecond,
+		WriteTimeout:    time.Duration(cfg.Pool.WriteTimeout) * time.Millisecond,
+		MaxRetries:      cfg.Pool.MaxRetries,
+	}
+	if cfg.Username != nil {
+		options.Username = *cfg.Username
+	}
+	if cfg.Password != nil {
+		options.Password = *cfg.Password
+	}
+	if cfg.SentinelUsername != nil {
+		options.SentinelUsername = *cfg.SentinelUsername
+	}
+	if cfg.SentinelPassword != nil {
+		options.SentinelPassword = *cfg.SentinelPassword
+	}
+	if cfg.TLS != nil {
+		tlsConfig, err := redisTLSConfig(cfg.TLS)
+		if err != nil {
+			return err
+		}
+		options.TLSConfig = tlsConfig
+	}
+
+	// The client is chosen by the mode, not by the number of addresses like redis.NewUniversalClient,
+	// so that a cluster can be discovered from a single node.
+	switch cfg.Mode {
+	case "sentinel":
+		options.Addrs = cfg.Addresses
+		Redis = redis.NewFailoverClient(options.Failover())
+	case "cluster":
+		options.Addrs = cfg.Addresses
+		Redis = redis.NewClusterClient(options.Cluster())
+	default:
+		options.Addrs = []string{cfg.Address}
+		Redis = redis.NewClient(options.Simple())
+	}
+	return nil
+}
+
+// redisTLSConfig builds the TLS config of the connections to Redis.
+//
+// The server name is the host of the address of each node if not set.
+func redisTLSConfig(cfg *config.RedisTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Redis CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in the Redis CA file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// CloseRedis closes the shared Redis client, if any.
+func CloseRedis() error {
+	if Redis != nil {
+		return Redis.Close()
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/credentials"
@@ -314,6 +315,28 @@ func (s *S3Store) UploadPublicObject(ctx context.Context, key string, file io.Re
 	return s.uploadObjectInternal(ctx, "public/"+key, file, contentType, cacheControl)
 }
 
+// DownloadObject downloads an object with the given key from the S3 bucket.
+// The key should be prefixed with 'private/' or 'public/' as per the upload methods.
+func (s *S3Store) DownloadObject(ctx context.Context, key string) ([]byte, error) {
+	result, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: s.Bucket,
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if s.isErrorCode(err, "NoSuchKey", "NotFound") {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to download object from bucket %s with key %s: %w", *s.Bucket, key, err)
+	}
+	defer result.Body.Close()
+
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object from bucket %s with key %s: %w", *s.Bucket, key, err)
+	}
+	return data, nil
+}
+
 // DeleteObject deletes an object with the given key from the S3 bucket.
 // The key should be prefixed with 'private/' or 'public/' as per the upload methods.
 func (s *S3Store) DeleteObject(ctx context.Context, key string) error {
@@ -324,6 +347,28 @@ func (s *S3Store) DeleteObject(ctx context.Context, key string) error {
 	return err
 }
 
+// ListObjects lists the keys of the objects starting with the given prefix in the S3 bucket, sorted.
+// The prefix should start with 'private/' or 'public/' as per the upload methods.
+func (s *S3Store) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	paginator := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: s.Bucket,
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in bucket %s with prefix %s: %w", *s.Bucket, prefix, err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+	}
+	// S3 lists keys in UTF-8 binary order, but S3-like stores may not.
+	slices.Sort(keys)
+	return keys, nil
+}
+
 // GetObjectURL returns the URL of an object with the given key.
 // The key should be prefixed with 'private/' or 'public/' as per the upload methods.
 // If the bucket is public, it returns a URL that can be accessed directly.
@@ -340,8 +385,15 @@ func (s *S3Store) GetObjectURL(ctx context.Context, key string) (string, error)
 	return fmt.Sprintf("%s://%s.s3.%s.amazonaws.com/%s", s.EndpointScheme, *s.Bucket, config.Stores.S3.Region, key), nil
 }
 
-// STUB, we just return a value using GetObjectURL
-// TODO: Implement this method to return a pre-signed URL for the object with expiry.
+// GetObjectURLWithExpiry returns a pre-signed GET URL of an object with the given key, valid for expiry seconds.
+// The key should be prefixed with 'private/' or 'public/' as per the upload methods.
 func (s *S3Store) GetObjectURLWithExpiry(ctx context.Context, key string, expiry int64) (string, error) {
-	return s.GetObjectURL(ctx, key)
+	presigned, err := s3.NewPresignClient(s.Client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: s.Bucket,
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(time.Duration(expiry)*time.Second))
+	if err != nil {
+		return "", fmt.Errorf("failed to pre-sign the URL of the object with key %s: %w", key, err)
+	}
+	return presigned.URL, nil
 }
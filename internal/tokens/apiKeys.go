@@ -0,0 +1,51 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// APIKeyPrefix starts the API keys stored in the database, so that they can be told apart from the keys of the config file.
+const APIKeyPrefix = "nxr_"
+
+// apiKeyIDLength is the length of the random, visible part of the prefix of an API key.
+const apiKeyIDLength = 8
+
+// NewAPIKey generates a new API key, in the format "nxr_<8 characters>_<43 characters>".
+//
+// It returns the key (goes to the caller, once), its visible prefix "nxr_<8 characters>" (identifies the key),
+// and the SHA-256 hash of the key (goes to the database).
+func NewAPIKey() (key string, prefix string, hash []byte, err error) {
+	id := make([]byte, apiKeyIDLength*3/4)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+
+	prefix = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(id)
+	key = prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefix, HashAPIKey(key), nil
+}
+
+// ParseAPIKeyPrefix returns the visible prefix of an API key, and false if it is not in the format of NewAPIKey.
+func ParseAPIKeyPrefix(key string) (string, bool) {
+	prefixLength := len(APIKeyPrefix) + apiKeyIDLength
+	if !strings.HasPrefix(key, APIKeyPrefix) || len(key) <= prefixLength+1 || key[prefixLength] != '_' {
+		return "", false
+	}
+	return key[:prefixLength], true
+}
+
+// HashAPIKey hashes the API key using SHA-256.
+//
+// The keys are random, so a fast hash is enough, and keeps the lookup of every request cheap.
+func HashAPIKey(key string) []byte {
+	hash := sha256.Sum256([]byte(key))
+	return hash[:]
+}
@@ -11,6 +11,8 @@ type VerificationTokenType string
 const (
 	EmailVerificationToken VerificationTokenType = "email_verification"
 	PasswordResetToken     VerificationTokenType = "password_reset"
+	// EmailChangeToken confirms the new address of an email change, stored in the token's new_email.
+	EmailChangeToken VerificationTokenType = "email_change"
 )
 
 // Functions related to Email Verification Tokens
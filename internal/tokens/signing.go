@@ -0,0 +1,69 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+)
+
+// SignData signs the data with the token signing key, using RSA PKCS #1 v1.5 with SHA-256.
+//
+// It is used to sign data other than JWTs, eg. audit log checkpoints, so that they can be verified with the public key.
+func SignData(data []byte) ([]byte, error) {
+	if privateKey == nil {
+		return nil, errors.New("tokens are not initialized")
+	}
+	digest := sha256.Sum256(data)
+	return rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+}
+
+// ErrUnknownKey is returned by VerifyDataWithKey if the key is neither the current key nor a configured retired key.
+var ErrUnknownKey = errors.New("the signing key is not known")
+
+// VerifyData verifies a signature created by SignData with the token public key.
+func VerifyData(data, signature []byte) error {
+	if PublicKey == nil {
+		return errors.New("tokens are not initialized")
+	}
+	digest := sha256.Sum256(data)
+	return rsa.VerifyPKCS1v15(PublicKey, crypto.SHA256, digest[:], signature)
+}
+
+// VerifyDataWithKey verifies a signature created by SignData with the key of the given ID,
+// the current public key or a retired one, see config.JWTConfig.RetiredPublicKeyFiles.
+//
+// It returns ErrUnknownKey if no such key is configured, eg. a rotated key which was not kept.
+func VerifyDataWithKey(id string, data, signature []byte) error {
+	if id == KeyID() {
+		return VerifyData(data, signature)
+	}
+	key, ok := retiredKeys[id]
+	if !ok {
+		return ErrUnknownKey
+	}
+	digest := sha256.Sum256(data)
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+}
+
+// KeyID returns an identifier of the token signing key, the hex encoded SHA-256 of the public key, truncated to 16 bytes.
+//
+// It is stored with signatures, so that signatures made with a previous key can be told apart from forged ones.
+func KeyID() string {
+	if PublicKey == nil {
+		return ""
+	}
+	return keyID(PublicKey)
+}
+
+func keyID(key *rsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:16])
+}
@@ -43,6 +43,9 @@ var (
 	PublicKey  *rsa.PublicKey
 )
 
+// retiredKeys are the public keys of the previous signing keys, by key ID, see config.JWTConfig.RetiredPublicKeyFiles.
+var retiredKeys map[string]*rsa.PublicKey
+
 func InitTokens() error {
 	privateKeyData, err := os.ReadFile(config.JWT.PrivateKeyFile)
 	if err != nil {
@@ -60,27 +63,43 @@ func InitTokens() error {
 		return errors.New("private key is nil after parsing or not of type *rsa.PrivateKey")
 	}
 
-	publicKeyData, err := os.ReadFile(config.JWT.PublicKeyFile)
+	PublicKey, err = readPublicKey(config.JWT.PublicKeyFile)
+	if err != nil {
+		return err
+	}
+
+	retiredKeys = make(map[string]*rsa.PublicKey, len(config.JWT.RetiredPublicKeyFiles))
+	for _, file := range config.JWT.RetiredPublicKeyFiles {
+		key, err := readPublicKey(file)
+		if err != nil {
+			return fmt.Errorf("retired key %s: %w", file, err)
+		}
+		retiredKeys[keyID(key)] = key
+	}
+
+	logging.Logger.Info("Tokens initialized successfully", zap.String("privateKeyFile", config.JWT.PrivateKeyFile), zap.String("publicKeyFile", config.JWT.PublicKeyFile))
+
+	return nil
+}
+
+// readPublicKey reads a PEM encoded RSA public key.
+func readPublicKey(file string) (*rsa.PublicKey, error) {
+	publicKeyData, err := os.ReadFile(file)
 	if err != nil {
-		return fmt.Errorf("failed to read public key file: %w", err)
+		return nil, fmt.Errorf("failed to read public key file: %w", err)
 	}
-	block, _ = pem.Decode(publicKeyData)
+	block, _ := pem.Decode(publicKeyData)
 	if block == nil {
-		return errors.New("failed to parse public key PEM")
+		return nil, errors.New("failed to parse public key PEM")
 	}
 	publicKeyInt, err := x509.ParsePKIXPublicKey(block.Bytes)
 	if err != nil {
-		return fmt.Errorf("failed to parse public key: %w", err)
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
 	}
 	if pubKey, ok := publicKeyInt.(*rsa.PublicKey); ok && pubKey != nil {
-		PublicKey = pubKey
-	} else {
-		return errors.New("public key is nil after parsing or not of type *rsa.PublicKey")
+		return pubKey, nil
 	}
-
-	logging.Logger.Info("Tokens initialized successfully", zap.String("privateKeyFile", config.JWT.PrivateKeyFile), zap.String("publicKeyFile", config.JWT.PublicKeyFile))
-
-	return nil
+	return nil, errors.New("public key is nil after parsing or not of type *rsa.PublicKey")
 }
 
 type NexeresClaims struct {
@@ -102,6 +121,14 @@ type NexeresClaims struct {
 	UserLname     string `json:"userLname"`
 	UserAvatarURL string `json:"userAvatarUrl,omitempty"` // Optional user avatar URL
 	UserOrgRole   string `json:"userOrgRole"`
+
+	// The permissions granted to the user in the organization, resolved from UserOrgRole.
+	// See the `permissions` package for the format.
+	Permissions []string `json:"permissions"`
+
+	// Custom claims returned by the pre-token hooks, by hook namespace, eg. {"billing": {"plan": "pro"}}.
+	// See the `hooks` package.
+	Ext map[string]any `json:"ext,omitempty"`
 }
 
 // Tokens represents the result of generating a new token pair.
@@ -126,10 +153,36 @@ type Tokens struct {
 	RefreshTokenExpiry time.Time `json:"refreshTokenExpiry"`
 }
 
+// Lifetimes holds the durations for which the session and refresh tokens are valid.
+type Lifetimes struct {
+	SessionToken time.Duration
+	RefreshToken time.Duration
+}
+
+// DefaultLifetimes returns the token lifetimes from the JWT config.
+//
+// Organizations can override these in their security policy.
+func DefaultLifetimes() Lifetimes {
+	return Lifetimes{
+		SessionToken: time.Duration(config.JWT.SessionTokenExpiration) * time.Second,
+		RefreshToken: time.Duration(config.JWT.RefreshTokenExpiration) * time.Second,
+	}
+}
+
+// Issuer returns the issuer of the session tokens of the given organization.
+//
+// When tenant subdomains are enabled, every organization has its own issuer, its tenant base URL.
+func Issuer(orgSlug string) string {
+	if config.Public.TenantSubdomains && orgSlug != "" {
+		return config.Public.GetTenantBaseURL(orgSlug)
+	}
+	return config.Public.GetBaseURL()
+}
+
 // GenerateTokens generates a new session and refresh token pair for the given user ID and claims.
 //
 // NOTE: This function will NOT store the tokens in the database.
-func GenerateTokens(userId uuid.UUID, claims NexeresClaims) (*Tokens, error) {
+func GenerateTokens(userId uuid.UUID, claims NexeresClaims, lifetimes Lifetimes) (*Tokens, error) {
 	now := time.Now().UTC()
 
 	sessionId, err := uuid.NewV7()
@@ -139,12 +192,12 @@ func GenerateTokens(userId uuid.UUID, claims NexeresClaims) (*Tokens, error) {
 	}
 
 	// Calculate expiration durations
-	sessionTokenExpirationDuration := time.Duration(config.JWT.SessionTokenExpiration) * time.Second
-	refreshTokenExpDuration := time.Duration(config.JWT.RefreshTokenExpiration) * time.Second
+	sessionTokenExpirationDuration := lifetimes.SessionToken
+	refreshTokenExpDuration := lifetimes.RefreshToken
 
 	// Set the standard claims as per RFC 7519 JWT specification.
 	claims.RegisteredClaims = jwt.RegisteredClaims{
-		Issuer:    config.Public.GetBaseURL(),
+		Issuer:    Issuer(claims.OrgSlug),
 		Subject:   userId.String(),
 		Audience:  jwt.ClaimStrings(config.JWT.Audiences),
 		ExpiresAt: jwt.NewNumericDate(now.Add(sessionTokenExpirationDuration)),
@@ -178,16 +231,16 @@ func GenerateTokens(userId uuid.UUID, claims NexeresClaims) (*Tokens, error) {
 // RefreshSession refreshes the session by generating a new session and refresh token pair.
 // All the non-standard claims have to be set before passing in the claims parameter.
 // It takes the old session and claims as the parameter, and gives new tokens.
-func RefreshSessionTokens(session db.Session, claims NexeresClaims) (*Tokens, error) {
+func RefreshSessionTokens(session db.Session, claims NexeresClaims, lifetimes Lifetimes) (*Tokens, error) {
 	now := time.Now().UTC()
 
 	// Calculate expiration durations
-	sessionTokenExpiryDuration := time.Duration(config.JWT.SessionTokenExpiration) * time.Second
-	refreshTokenExpDuration := time.Duration(config.JWT.RefreshTokenExpiration) * time.Second
+	sessionTokenExpiryDuration := lifetimes.SessionToken
+	refreshTokenExpDuration := lifetimes.RefreshToken
 
 	// Set the standard claims as per RFC 7519 JWT specification.
 	claims.RegisteredClaims = jwt.RegisteredClaims{
-		Issuer:    config.Public.GetBaseURL(),
+		Issuer:    Issuer(claims.OrgSlug),
 		Subject:   session.UserID.String(),
 		Audience:  jwt.ClaimStrings(config.JWT.Audiences),
 		ExpiresAt: jwt.NewNumericDate(now.Add(sessionTokenExpiryDuration)),
@@ -0,0 +1,87 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nbrglm/nexeres/config"
+)
+
+// ErrPrivateAddress is returned when an endpoint resolves to an address of a private network,
+// which could be used to reach internal services through the dispatcher (SSRF).
+var ErrPrivateAddress = errors.New("the URL must not point to a loopback, link-local, private or unspecified address")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which netip does not consider private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicAddr returns whether webhooks can be delivered to the address.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() &&
+		!addr.IsLoopback() &&
+		!addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() &&
+		!addr.IsInterfaceLocalMulticast() &&
+		!addr.IsMulticast() &&
+		!addr.IsPrivate() &&
+		!addr.IsUnspecified() &&
+		!sharedAddressSpace.Contains(addr)
+}
+
+// checkHost rejects the hosts which are known to be private without resolving them: IP literals and localhost.
+//
+// The resolved addresses are checked when dialing, see dialControl, since the DNS records can change after the validation.
+func checkHost(host string) error {
+	if config.Webhooks.AllowPrivateNetworks {
+		return nil
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateAddress
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !isPublicAddr(addr) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// dialControl rejects the connections to private addresses, after the host is resolved,
+// so that DNS rebinding cannot bypass the validation of the URL.
+func dialControl(network, address string, _ syscall.RawConn) error {
+	if config.Webhooks.AllowPrivateNetworks {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("failed to parse the dialed address %q: %w", address, err)
+	}
+	if !isPublicAddr(addrPort.Addr()) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// newTransport returns the transport of the dispatcher, which only connects to public addresses.
+//
+// Proxies from the environment are not used, since the dialed address would be the proxy's.
+func newTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   dialControl,
+	}
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
@@ -0,0 +1,49 @@
+package webhooks
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// EventType is the type of a webhook event, in the format "<resource>.<change>".
+type EventType string
+
+const (
+	EventUserCreated       EventType = "user.created"
+	EventUserEmailVerified EventType = "user.email_verified"
+	EventUserEmailChanged  EventType = "user.email_changed"
+	EventUserDeleted       EventType = "user.deleted"
+	EventSessionCreated    EventType = "session.created"
+	EventSessionRevoked    EventType = "session.revoked"
+	EventMemberRoleChanged EventType = "member.role_changed"
+	EventOrgDeleted        EventType = "org.deleted"
+)
+
+// EventTypes lists every event type, which endpoints can subscribe to.
+var EventTypes = []EventType{
+	EventUserCreated,
+	EventUserEmailVerified,
+	EventUserEmailChanged,
+	EventUserDeleted,
+	EventSessionCreated,
+	EventSessionRevoked,
+	EventMemberRoleChanged,
+	EventOrgDeleted,
+}
+
+// NormalizeEventTypes validates the event type filter of an endpoint, and returns it sorted and deduplicated.
+//
+// An empty filter subscribes the endpoint to every event type.
+func NormalizeEventTypes(eventTypes []string) ([]string, error) {
+	normalized := make([]string, 0, len(eventTypes))
+	for _, t := range eventTypes {
+		t = strings.TrimSpace(t)
+		if !slices.Contains(EventTypes, EventType(t)) {
+			return nil, fmt.Errorf("unknown event type %q", t)
+		}
+		normalized = append(normalized, t)
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized), nil
+}
@@ -0,0 +1,119 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+)
+
+// secretPrefix is the prefix of the endpoint secrets, to make them recognizable, eg. by secret scanners.
+const secretPrefix = "whsec_"
+
+// Payload is the body of a webhook delivery.
+type Payload struct {
+	// ID of the event, the same for every endpoint and every attempt, to deduplicate deliveries.
+	ID        uuid.UUID `json:"id"`
+	Type      EventType `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+	OrgID     uuid.UUID `json:"orgId"`
+	// Data holds the details of the event, eg. the ID of the created user.
+	Data map[string]any `json:"data"`
+}
+
+// Enqueue writes the event to the outbox, as one delivery per endpoint of the org subscribed to the event type.
+//
+// It must be called with the querier of the transaction making the change, so that the event is only
+// delivered if the change is committed. It is a no-op if webhooks are disabled.
+func Enqueue(ctx context.Context, q *db.Queries, orgID uuid.UUID, eventType EventType, data map[string]any) error {
+	if !config.Webhooks.Enable {
+		return nil
+	}
+
+	endpoints, err := q.ListWebhookEndpointsForEvent(ctx, db.ListWebhookEndpointsForEventParams{
+		OrgID:     orgID,
+		EventType: string(eventType),
+	})
+	if err != nil || len(endpoints) == 0 {
+		return err
+	}
+
+	eventID, err := uuid.NewV7()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(Payload{
+		ID:        eventID,
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		OrgID:     orgID,
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, endpoint := range endpoints {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return err
+		}
+		if err := q.CreateWebhookDelivery(ctx, db.CreateWebhookDeliveryParams{
+			ID:         id,
+			EndpointID: endpoint.ID,
+			OrgID:      orgID,
+			EventID:    eventID,
+			EventType:  string(eventType),
+			Payload:    payload,
+		}); err != nil {
+			return err
+		}
+	}
+	enqueuedCounter.WithLabelValues(string(eventType)).Add(float64(len(endpoints)))
+	return nil
+}
+
+// NewSecret generates the signing secret of a new endpoint.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Sign returns the signature header of a delivery: "v1=" followed by the hex encoded
+// HMAC-SHA256 of the timestamp, a '.' and the body, keyed with the endpoint secret.
+//
+// Receivers should recompute the signature, compare it in constant time, and reject old timestamps to prevent replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(strconv.AppendInt(nil, timestamp, 10))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateURL checks that the endpoint URL is absolute, and uses https, unless http is allowed in the config.
+// It also rejects the URLs of private networks, eg. http://127.0.0.1, unless they are allowed in the config.
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return errors.New("the URL must be absolute, eg. https://example.com/webhooks")
+	}
+	if u.Scheme != "https" && (u.Scheme != "http" || !config.Webhooks.AllowHTTP) {
+		return errors.New("the URL must use https")
+	}
+	return checkHost(u.Hostname())
+}
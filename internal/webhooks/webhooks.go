@@ -0,0 +1,278 @@
+// Package webhooks delivers identity lifecycle events, eg. signups and logins, to the HTTP endpoints registered by the organizations.
+//
+// Events are written to the webhook_deliveries table (the outbox) by Enqueue, in the same transaction as the change
+// which caused them, so that an event is delivered if and only if the change is committed. A background dispatcher
+// on every instance claims the due deliveries and POSTs them, signed with the endpoint's secret, see Sign.
+// Failed deliveries are retried with exponential backoff, and moved to the dead letters ("dead" status) after the
+// configured number of attempts, from where they can be redelivered manually.
+//
+// Deliveries are at least once: receivers should deduplicate them with the event ID.
+//
+// Webhooks are enabled with `webhooks.enable` in the config file, otherwise Enqueue is a no-op.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nbrglm/nexeres/config"
+	"github.com/nbrglm/nexeres/db"
+	"github.com/nbrglm/nexeres/internal/logging"
+	"github.com/nbrglm/nexeres/internal/metrics"
+	"github.com/nbrglm/nexeres/internal/store"
+	"github.com/nbrglm/nexeres/opts"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Delivery statuses of the webhook_deliveries table.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// Headers of a delivery request.
+const (
+	HeaderID        = "X-Nexeres-Webhook-Id"
+	HeaderEvent     = "X-Nexeres-Webhook-Event"
+	HeaderTimestamp = "X-Nexeres-Webhook-Timestamp"
+	HeaderSignature = "X-Nexeres-Webhook-Signature"
+)
+
+// maxErrorLength is the maximum length of the last error stored with a delivery.
+const maxErrorLength = 1000
+
+var (
+	client *http.Client
+	stop   chan struct{}
+	done   chan struct{}
+
+	enqueuedCounter   *prometheus.CounterVec
+	deliveriesCounter *prometheus.CounterVec
+	deliveryDuration  prometheus.Histogram
+)
+
+// InitWebhooks registers the webhook metrics.
+//
+// It must be called before metrics.InitMetrics, and before any event is enqueued.
+func InitWebhooks() {
+	enqueuedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "nexeres",
+			Subsystem: "webhooks",
+			Name:      "enqueued_deliveries",
+			Help:      "Total number of webhook deliveries written to the outbox, by event type",
+		},
+		[]string{"event"},
+	)
+	deliveriesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "nexeres",
+			Subsystem: "webhooks",
+			Name:      "delivery_attempts",
+			Help:      "Total number of webhook delivery attempts, by status: delivered, failed (will be retried), dead (no attempts left)",
+		},
+		[]string{"status"},
+	)
+	deliveryDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "nexeres",
+			Subsystem: "webhooks",
+			Name:      "delivery_duration_seconds",
+			Help:      "Duration of the webhook delivery requests",
+			Buckets:   prometheus.DefBuckets,
+		},
+	)
+	metrics.Collectors = append(metrics.Collectors, enqueuedCounter, deliveriesCounter, deliveryDuration)
+}
+
+// StartDispatcher starts the background dispatcher, if webhooks are enabled.
+//
+// It must be called after the database connection pool is initialized.
+func StartDispatcher() {
+	if !config.Webhooks.Enable {
+		return
+	}
+	client = &http.Client{
+		Timeout:   time.Duration(config.Webhooks.Timeout) * time.Second,
+		Transport: newTransport(),
+		// Redirects are not followed, so that the signed payload is only sent to the registered URL.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	stop = make(chan struct{})
+	done = make(chan struct{})
+	go run()
+}
+
+// Shutdown stops the dispatcher, after the deliveries in progress are finished.
+//
+// Deliveries claimed but not sent are retried once their lease expires.
+func Shutdown(ctx context.Context) error {
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run polls the outbox for due deliveries until Shutdown is called.
+func run() {
+	defer close(done)
+
+	ticker := time.NewTicker(time.Duration(config.Webhooks.PollInterval) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// Keep claiming while full batches are returned, to catch up with a backlog.
+			for dispatch() == config.Webhooks.BatchSize {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+			}
+		}
+	}
+}
+
+// dispatch claims a batch of due deliveries and sends them concurrently. It returns the number of claimed deliveries.
+func dispatch() int {
+	// The lease covers the request timeout, plus the time to record the result.
+	lease := config.Webhooks.Timeout + 30
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	deliveries, err := store.Querier.ClaimWebhookDeliveries(ctx, db.ClaimWebhookDeliveriesParams{
+		LeaseSeconds: int32(lease),
+		Limit:        int32(config.Webhooks.BatchSize),
+	})
+	cancel()
+	if err != nil {
+		logging.Logger.Error("Failed to claim webhook deliveries", zap.Error(err))
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			deliver(d)
+		}()
+	}
+	wg.Wait()
+	return len(deliveries)
+}
+
+// deliver sends the delivery and records the result, scheduling a retry on failure.
+func deliver(d db.ClaimWebhookDeliveriesRow) {
+	statusCode, err := send(d)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var code *int32
+	if statusCode != 0 {
+		c := int32(statusCode)
+		code = &c
+	}
+
+	if err == nil {
+		deliveriesCounter.WithLabelValues("delivered").Inc()
+		if err := store.Querier.MarkWebhookDeliveryDelivered(ctx, db.MarkWebhookDeliveryDeliveredParams{
+			StatusCode: code,
+			ID:         d.ID,
+		}); err != nil {
+			logging.Logger.Error("Failed to mark webhook delivery as delivered", zap.String("deliveryId", d.ID.String()), zap.Error(err))
+		}
+		return
+	}
+
+	status := StatusPending
+	if int(d.Attempts)+1 >= config.Webhooks.MaxAttempts {
+		status = StatusDead
+		deliveriesCounter.WithLabelValues("dead").Inc()
+		logging.Logger.Warn("Webhook delivery failed, no attempts left", zap.String("deliveryId", d.ID.String()), zap.String("url", d.Url), zap.Error(err))
+	} else {
+		deliveriesCounter.WithLabelValues("failed").Inc()
+		logging.Logger.Debug("Webhook delivery failed, retrying later", zap.String("deliveryId", d.ID.String()), zap.String("url", d.Url), zap.Error(err))
+	}
+
+	lastError := err.Error()
+	if len(lastError) > maxErrorLength {
+		lastError = lastError[:maxErrorLength]
+	}
+	if err := store.Querier.MarkWebhookDeliveryFailed(ctx, db.MarkWebhookDeliveryFailedParams{
+		Status:        status,
+		NextAttemptAt: pgtype.Timestamptz{Time: time.Now().Add(backoff(int(d.Attempts))), Valid: true},
+		LastError:     &lastError,
+		StatusCode:    code,
+		ID:            d.ID,
+	}); err != nil {
+		logging.Logger.Error("Failed to mark webhook delivery as failed", zap.String("deliveryId", d.ID.String()), zap.Error(err))
+	}
+}
+
+// send POSTs the signed payload to the endpoint. Any response other than 2xx is an error.
+func send(d db.ClaimWebhookDeliveriesRow) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, d.Url, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Nexeres-Webhooks/"+opts.Version)
+	req.Header.Set(HeaderID, d.EventID.String())
+	req.Header.Set(HeaderEvent, d.EventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(d.Secret, timestamp, d.Payload))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	deliveryDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain some of the body, so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the next attempt, after the given number of previous attempts:
+// the initial backoff doubled for every previous attempt, capped at the maximum, with up to 10% jitter.
+func backoff(attempts int) time.Duration {
+	delay := time.Duration(config.Webhooks.InitialBackoff) * time.Second
+	maxDelay := time.Duration(config.Webhooks.MaxBackoff) * time.Second
+	for range attempts {
+		delay *= 2
+		if delay >= maxDelay {
+			delay = maxDelay
+			break
+		}
+	}
+	return delay + rand.N(delay/10+1)
+}
@@ -0,0 +1,19 @@
+-- Nexeres - Organization Roles (Down)
+DROP INDEX IF EXISTS idx_org_roles_org_id;
+
+-- Members with a custom role fall back to 'member' before the column is narrowed again.
+UPDATE user_orgs
+SET role = 'member'
+WHERE role NOT IN ('owner', 'admin', 'member');
+
+UPDATE invitations
+SET role = 'member'
+WHERE role NOT IN ('owner', 'admin', 'member');
+
+ALTER TABLE user_orgs
+ALTER COLUMN role TYPE VARCHAR(16);
+
+ALTER TABLE invitations
+ALTER COLUMN role TYPE VARCHAR(16);
+
+DROP TABLE IF EXISTS org_roles;
@@ -0,0 +1,26 @@
+-- Nexeres - Organization Roles
+-- Adds org-defined roles made of named permissions.
+-- The built-in roles ('owner', 'admin', 'member') are defined in code and are not stored here.
+CREATE TABLE IF NOT EXISTS org_roles (
+  id UUID PRIMARY KEY NOT NULL,
+  org_id UUID NOT NULL REFERENCES orgs(id) ON DELETE CASCADE,
+  -- The name of the role, unique per org, eg. 'billing-manager'.
+  -- It is stored in user_orgs.role and invitations.role when assigned.
+  name VARCHAR(64) NOT NULL,
+  description TEXT,
+  -- The permissions granted by this role, eg. 'billing:read', 'members:invite'.
+  -- A permission ending with ':*' grants every action on that resource.
+  permissions TEXT [] NOT NULL DEFAULT ARRAY []::TEXT [],
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (org_id, name)
+);
+
+-- Custom role names can be longer than the built-in ones.
+ALTER TABLE user_orgs
+ALTER COLUMN role TYPE VARCHAR(64);
+
+ALTER TABLE invitations
+ALTER COLUMN role TYPE VARCHAR(64);
+
+CREATE INDEX idx_org_roles_org_id ON org_roles(org_id);
@@ -0,0 +1,4 @@
+-- Nexeres - Relationship-based Authorization
+DROP INDEX IF EXISTS idx_authz_tuples_subject;
+
+DROP TABLE IF EXISTS authz_tuples;
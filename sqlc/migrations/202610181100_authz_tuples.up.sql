@@ -0,0 +1,33 @@
+-- Nexeres - Relationship-based Authorization
+-- Stores relation tuples in the format `object#relation@subject`, per org.
+-- The namespaces and relations are configured in the `authz` section of the config file.
+CREATE TABLE IF NOT EXISTS authz_tuples (
+  org_id UUID NOT NULL REFERENCES orgs(id) ON DELETE CASCADE,
+  -- The object, eg. 'document:readme' is stored as ('document', 'readme').
+  object_namespace VARCHAR(64) NOT NULL,
+  object_id VARCHAR(255) NOT NULL,
+  -- The relation of the subject to the object, eg. 'viewer'.
+  relation VARCHAR(64) NOT NULL,
+  -- The subject, eg. 'user:alice' is stored as ('user', 'alice', '').
+  -- A userset, eg. 'team:eng#member' is stored as ('team', 'eng', 'member').
+  subject_namespace VARCHAR(64) NOT NULL,
+  subject_id VARCHAR(255) NOT NULL,
+  subject_relation VARCHAR(64) NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (
+    org_id,
+    object_namespace,
+    object_id,
+    relation,
+    subject_namespace,
+    subject_id,
+    subject_relation
+  )
+);
+
+CREATE INDEX idx_authz_tuples_subject ON authz_tuples(
+  org_id,
+  subject_namespace,
+  subject_id,
+  subject_relation
+);
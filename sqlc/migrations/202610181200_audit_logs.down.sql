@@ -0,0 +1,7 @@
+-- Nexeres - Audit Logs
+DELETE FROM audit_logs
+WHERE org_id IS NULL;
+
+ALTER TABLE audit_logs
+ALTER COLUMN org_id
+SET NOT NULL;
@@ -0,0 +1,4 @@
+-- Nexeres - Audit Logs
+-- Events which do not belong to an organization, eg. admin logins, are recorded without an org_id.
+ALTER TABLE audit_logs
+ALTER COLUMN org_id DROP NOT NULL;
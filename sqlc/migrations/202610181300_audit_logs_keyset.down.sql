@@ -0,0 +1,4 @@
+-- Nexeres - Audit Logs keyset pagination
+DROP INDEX IF EXISTS idx_audit_logs_created_id;
+
+DROP INDEX IF EXISTS idx_audit_logs_org_created_id;
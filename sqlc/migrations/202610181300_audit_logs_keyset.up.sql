@@ -0,0 +1,5 @@
+-- Nexeres - Audit Logs keyset pagination
+-- Audit logs are listed by (created_at, id), newest first.
+CREATE INDEX IF NOT EXISTS idx_audit_logs_org_created_id ON audit_logs(org_id, created_at DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS idx_audit_logs_created_id ON audit_logs(created_at DESC, id DESC);
@@ -0,0 +1,13 @@
+-- Nexeres - Tamper-evident Audit Logs
+DROP INDEX IF EXISTS idx_audit_checkpoints_chain_seq;
+
+DROP TABLE IF EXISTS audit_checkpoints;
+
+DROP TABLE IF EXISTS audit_chain_heads;
+
+DROP INDEX IF EXISTS idx_audit_logs_chain_seq;
+
+ALTER TABLE audit_logs DROP COLUMN hash,
+  DROP COLUMN prev_hash,
+  DROP COLUMN seq,
+  DROP COLUMN chain_id;
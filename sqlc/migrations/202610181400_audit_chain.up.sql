@@ -0,0 +1,43 @@
+-- Nexeres - Tamper-evident Audit Logs
+-- The entries of each chain, ie. each org, plus one chain for events outside of orgs, are linked with a SHA-256 hash:
+-- hash = SHA-256(prev_hash || entry), where prev_hash is the hash of the previous entry in the chain.
+-- Entries written before chaining was introduced have a NULL seq, and are not verified.
+ALTER TABLE audit_logs
+ADD COLUMN chain_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000',
+  ADD COLUMN seq BIGINT,
+  ADD COLUMN prev_hash BYTEA,
+  ADD COLUMN hash BYTEA;
+
+UPDATE audit_logs
+SET chain_id = org_id
+WHERE org_id IS NOT NULL;
+
+CREATE UNIQUE INDEX idx_audit_logs_chain_seq ON audit_logs(chain_id, seq);
+
+-- The last entry of each chain, locked while entries are appended.
+-- chain_id is the org ID, or the nil UUID for events outside of orgs.
+CREATE TABLE IF NOT EXISTS audit_chain_heads (
+  chain_id UUID PRIMARY KEY NOT NULL,
+  seq BIGINT NOT NULL DEFAULT 0,
+  hash BYTEA NOT NULL,
+  -- The seq of the last signed checkpoint.
+  checkpoint_seq BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Signed checkpoints of the chains, signed with the token signing key.
+-- A 'checkpoint' attests the hash of the entry at seq.
+-- A 'tombstone' is written when the entries up to seq are deleted for retention, the chain is verified from it onwards.
+CREATE TABLE IF NOT EXISTS audit_checkpoints (
+  id UUID PRIMARY KEY NOT NULL,
+  chain_id UUID NOT NULL,
+  kind VARCHAR(32) NOT NULL,
+  seq BIGINT NOT NULL,
+  hash BYTEA NOT NULL,
+  deleted_count BIGINT NOT NULL DEFAULT 0,
+  key_id VARCHAR(64) NOT NULL,
+  signature BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_audit_checkpoints_chain_seq ON audit_checkpoints(chain_id, seq);
@@ -0,0 +1,43 @@
+-- Nexeres - Webhooks
+-- The webhook endpoints of each org, and the outbox of their deliveries.
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+  id UUID PRIMARY KEY NOT NULL,
+  org_id UUID NOT NULL REFERENCES orgs(id) ON DELETE CASCADE,
+  url TEXT NOT NULL,
+  description TEXT,
+  -- The event types sent to the endpoint, all event types if empty.
+  event_types TEXT [] NOT NULL DEFAULT '{}',
+  -- The HMAC-SHA256 signing secret. It is stored as is, since it is needed to sign the payloads.
+  secret TEXT NOT NULL,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_webhook_endpoints_org_id ON webhook_endpoints(org_id);
+
+-- The transactional outbox of webhook deliveries, one row per event and endpoint.
+-- Rows are written in the same transaction as the change which caused the event, and sent by the dispatcher.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  id UUID PRIMARY KEY NOT NULL,
+  endpoint_id UUID NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+  org_id UUID NOT NULL REFERENCES orgs(id) ON DELETE CASCADE,
+  -- The ID of the event, the same for every endpoint the event is delivered to.
+  event_id UUID NOT NULL,
+  event_type VARCHAR(64) NOT NULL,
+  payload JSONB NOT NULL,
+  -- One of 'pending', 'delivered' or 'dead' (the maximum number of attempts failed).
+  status VARCHAR(16) NOT NULL DEFAULT 'pending',
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_error TEXT,
+  last_status_code INT,
+  delivered_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at)
+WHERE status = 'pending';
+
+CREATE INDEX idx_webhook_deliveries_endpoint ON webhook_deliveries(endpoint_id, created_at DESC);
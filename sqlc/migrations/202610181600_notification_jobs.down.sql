@@ -0,0 +1,6 @@
+-- Nexeres - Notification Jobs
+DROP INDEX IF EXISTS idx_notification_jobs_created_at;
+
+DROP INDEX IF EXISTS idx_notification_jobs_due;
+
+DROP TABLE IF EXISTS notification_jobs;
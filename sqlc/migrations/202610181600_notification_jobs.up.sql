@@ -0,0 +1,29 @@
+-- Nexeres - Notification Jobs
+-- The queue of outgoing notifications, eg. verification and login emails, sent by the notification workers.
+CREATE TABLE IF NOT EXISTS notification_jobs (
+  id UUID PRIMARY KEY NOT NULL,
+  -- Jobs with the same idempotency key are only enqueued once, eg. 'verify_email:<token id>'.
+  idempotency_key TEXT NOT NULL UNIQUE,
+  -- The channel of the notification, eg. 'email'.
+  channel VARCHAR(16) NOT NULL,
+  -- The kind of the notification, eg. 'verify_email' or 'admin_login'.
+  kind VARCHAR(64) NOT NULL,
+  recipient TEXT NOT NULL,
+  -- The rendered message. It is cleared once the message is sent, since it may contain tokens or codes.
+  payload JSONB NOT NULL,
+  -- One of 'pending', 'sent', 'dead' (the maximum number of attempts failed) or 'expired' (not sent before expires_at).
+  status VARCHAR(16) NOT NULL DEFAULT 'pending',
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  -- The message is not sent after this time, eg. when the code it contains expires.
+  expires_at TIMESTAMPTZ,
+  last_error TEXT,
+  sent_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_notification_jobs_due ON notification_jobs(next_attempt_at)
+WHERE status = 'pending';
+
+CREATE INDEX idx_notification_jobs_created_at ON notification_jobs(created_at DESC);
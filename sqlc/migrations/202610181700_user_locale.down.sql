@@ -0,0 +1,2 @@
+-- Nexeres - User Locale
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
@@ -0,0 +1,5 @@
+-- Nexeres - User Locale
+-- The locale notifications are sent to the user in, as a BCP 47 language tag, eg. 'pt-BR'.
+-- If NULL, the default locale of the user's organization, or the configured default locale, is used.
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS locale VARCHAR(35);
@@ -0,0 +1,4 @@
+-- Nexeres - API Keys
+DROP INDEX IF EXISTS idx_api_keys_org_id;
+
+DROP TABLE IF EXISTS api_keys;
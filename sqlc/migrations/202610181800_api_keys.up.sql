@@ -0,0 +1,26 @@
+-- Nexeres - API Keys
+-- API keys managed by the admins. Only a hash of each key is stored, the keys of the config file are not stored here.
+CREATE TABLE IF NOT EXISTS api_keys (
+  id UUID PRIMARY KEY NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  description TEXT,
+  -- The visible prefix of the key, eg. 'nxr_3fA9kQ2x', used to look the key up and to identify it.
+  prefix VARCHAR(32) NOT NULL UNIQUE,
+  -- The SHA-256 hash of the full key.
+  key_hash BYTEA NOT NULL,
+  -- The route groups the key can call, eg. 'auth' or 'orgs'.
+  scopes TEXT [] NOT NULL,
+  -- The organization the key is bound to, NULL for a key which can be used for every organization.
+  org_id UUID REFERENCES orgs(id) ON DELETE CASCADE,
+  expires_at TIMESTAMPTZ,
+  last_used_at TIMESTAMPTZ,
+  revoked_at TIMESTAMPTZ,
+  -- The key which replaced this key when it was rotated. This key expires at the end of the overlap window.
+  rotated_to UUID REFERENCES api_keys(id) ON DELETE SET NULL,
+  -- The email of the admin who created the key.
+  created_by TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_api_keys_org_id ON api_keys(org_id);
@@ -0,0 +1,12 @@
+-- Nexeres - Janitor
+-- Entries may refer to purged users and orgs, which are not checked.
+ALTER TABLE audit_logs
+ADD CONSTRAINT audit_logs_org_id_fkey FOREIGN KEY (org_id) REFERENCES orgs(id) ON DELETE CASCADE NOT VALID,
+  ADD CONSTRAINT audit_logs_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE
+SET NULL NOT VALID;
+
+DROP INDEX IF EXISTS idx_orgs_deleted;
+
+DROP INDEX IF EXISTS idx_users_deleted;
+
+DROP INDEX IF EXISTS idx_verification_tokens_expires_at;
@@ -0,0 +1,14 @@
+-- Nexeres - Janitor
+-- Indexes of the rows purged by the janitor, which are not indexed yet.
+CREATE INDEX IF NOT EXISTS idx_verification_tokens_expires_at ON verification_tokens(expires_at);
+
+CREATE INDEX IF NOT EXISTS idx_users_deleted ON users(deleted_at)
+WHERE deleted_at IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_orgs_deleted ON orgs(deleted_at)
+WHERE deleted_at IS NOT NULL;
+
+-- The audit logs outlive the users and orgs they refer to, which are purged after their retention window.
+-- Cascading the deletes would remove or modify chained entries, and break the verification of the chains.
+ALTER TABLE audit_logs DROP CONSTRAINT IF EXISTS audit_logs_org_id_fkey,
+  DROP CONSTRAINT IF EXISTS audit_logs_user_id_fkey;
@@ -0,0 +1,10 @@
+-- Nexeres - Privacy
+DROP INDEX IF EXISTS idx_data_exports_expires_at;
+
+DROP INDEX IF EXISTS idx_data_exports_user_id;
+
+DROP TABLE IF EXISTS data_exports;
+
+DROP INDEX IF EXISTS idx_users_erasure_scheduled_at;
+
+ALTER TABLE users DROP COLUMN IF EXISTS erasure_scheduled_at;
@@ -0,0 +1,25 @@
+-- Nexeres - Privacy
+-- The time at which the user's data is erased, NULL if no erasure was requested.
+-- The erasure can be cancelled until then, after which the janitor erases the user.
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS erasure_scheduled_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_users_erasure_scheduled_at ON users(erasure_scheduled_at)
+WHERE erasure_scheduled_at IS NOT NULL;
+
+-- The archives of the users' data, stored in the object store, which are deleted once they expire.
+-- user_id does not reference users, so that the archives of erased users are still deleted.
+CREATE TABLE IF NOT EXISTS data_exports (
+  id UUID PRIMARY KEY NOT NULL,
+  user_id UUID NOT NULL,
+  -- The key of the archive in the object store, eg. 'private/users/<user id>/exports/<id>.zip'.
+  object_key TEXT NOT NULL,
+  -- The format of the archive, 'json' or 'zip'.
+  format VARCHAR(16) NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_data_exports_user_id ON data_exports(user_id);
+
+CREATE INDEX idx_data_exports_expires_at ON data_exports(expires_at);
@@ -0,0 +1,2 @@
+-- Nexeres - Email Change
+ALTER TABLE verification_tokens DROP COLUMN IF EXISTS new_email;
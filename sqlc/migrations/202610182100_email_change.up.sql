@@ -0,0 +1,5 @@
+-- Nexeres - Email Change
+-- The new email address of the user, for 'email_change' tokens, NULL for the other types.
+-- The address of the user is only changed once the token, sent to the new address, is verified.
+ALTER TABLE verification_tokens
+ADD COLUMN IF NOT EXISTS new_email VARCHAR(512);
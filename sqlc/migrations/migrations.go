@@ -0,0 +1,11 @@
+// Package migrations embeds the SQL migrations of the database schema, so that the binary can apply them.
+//
+// The files are named "<version>_<name>.up.sql" and "<version>_<name>.down.sql", the format of the migrate CLI.
+package migrations
+
+import "embed"
+
+// FS holds the migration files.
+//
+//go:embed *.sql
+var FS embed.FS
@@ -5,7 +5,8 @@ INSERT INTO users (
     password_hash,
     first_name,
     last_name,
-    avatar_url
+    avatar_url,
+    locale
   )
 VALUES (
     $1,
@@ -13,7 +14,8 @@ VALUES (
     sqlc.narg('password_hash'),
     sqlc.narg('first_name'),
     sqlc.narg('last_name'),
-    sqlc.narg('avatar_url')
+    sqlc.narg('avatar_url'),
+    sqlc.narg('locale')
   )
 RETURNING id,
   email,
@@ -21,22 +23,26 @@ RETURNING id,
   first_name,
   last_name,
   avatar_url,
+  locale,
   created_at,
   updated_at;
 
--- name: UpdateUser :one
+-- name: UpdateUserByID :one
+-- Updates the profile of a user. The user is identified by ID, so that a concurrent change of the email does not make the update miss.
 UPDATE users
 SET first_name = coalesce(sqlc.narg('first_name'), first_name),
   last_name = coalesce(sqlc.narg('last_name'), last_name),
   avatar_url = coalesce(sqlc.narg('avatar_url'), avatar_url),
+  locale = coalesce(sqlc.narg('locale'), locale),
   updated_at = NOW()
-WHERE email = sqlc.arg('email')
+WHERE id = sqlc.arg('id')
 RETURNING id,
   email,
   email_verified,
   first_name,
   last_name,
   avatar_url,
+  locale,
   created_at,
   updated_at;
 
@@ -86,6 +92,7 @@ SELECT id,
   first_name,
   last_name,
   avatar_url,
+  locale,
   created_at,
   updated_at
 FROM users
@@ -98,8 +105,10 @@ SELECT id,
   first_name,
   last_name,
   avatar_url,
+  locale,
   created_at,
-  updated_at
+  updated_at,
+  erasure_scheduled_at
 FROM users
 WHERE id = sqlc.arg('id');
 
@@ -153,6 +162,13 @@ SELECT *
 FROM orgs
 WHERE id = sqlc.arg('id');
 
+-- name: GetOrgByIDForUpdate :one
+-- Locks the row of the organization until the end of the transaction, so that concurrent updates of its settings are serialized.
+SELECT *
+FROM orgs
+WHERE id = sqlc.arg('id')
+FOR UPDATE;
+
 -- name: GetOrgBySlug :one
 SELECT *
 FROM orgs
@@ -303,13 +319,19 @@ RETURNING *;
 DELETE FROM sessions
 WHERE id = sqlc.arg('id');
 
--- name: DeleteSessionByToken :exec
+-- name: DeleteSessionByToken :many
 DELETE FROM sessions
-WHERE token_hash = sqlc.arg('token_hash');
+WHERE token_hash = sqlc.arg('token_hash')
+RETURNING id,
+  user_id,
+  org_id;
 
--- name: DeleteSessionByRefreshToken :exec
+-- name: DeleteSessionByRefreshToken :many
 DELETE FROM sessions
-WHERE refresh_token_hash = sqlc.arg('refresh_token_hash');
+WHERE refresh_token_hash = sqlc.arg('refresh_token_hash')
+RETURNING id,
+  user_id,
+  org_id;
 
 -- name: GetSessionsByUserID :many
 SELECT *
@@ -389,13 +411,943 @@ INSERT INTO verification_tokens (
     user_id,
     TYPE,
     token_hash,
-    expires_at
+    expires_at,
+    new_email
   )
-VALUES ($1, $2, $3, $4, $5)
+VALUES ($1, $2, $3, $4, $5, $6)
 RETURNING *;
 
 -- name: GetVerificationTokenByHash :one
 SELECT *
 FROM verification_tokens
 WHERE token_hash = sqlc.arg('token_hash')
-  AND expires_at > NOW();
\ No newline at end of file
+  AND expires_at > NOW();
+
+-- name: GetUserOrgMembership :one
+SELECT *
+FROM user_orgs
+WHERE user_id = sqlc.arg('user_id')
+  AND org_id = sqlc.arg('org_id');
+
+-- name: UpdateUserOrgRole :exec
+UPDATE user_orgs
+SET role = sqlc.arg('role')
+WHERE user_id = sqlc.arg('user_id')
+  AND org_id = sqlc.arg('org_id');
+
+-- name: CountOrgMembersWithRole :one
+SELECT count(*)
+FROM user_orgs
+WHERE org_id = sqlc.arg('org_id')
+  AND role = sqlc.arg('role');
+
+-- name: CreateOrgRole :one
+INSERT INTO org_roles (
+    id,
+    org_id,
+    name,
+    description,
+    permissions
+  )
+VALUES (
+    sqlc.arg('id'),
+    sqlc.arg('org_id'),
+    sqlc.arg('name'),
+    sqlc.narg('description'),
+    sqlc.arg('permissions')
+  )
+RETURNING *;
+
+-- name: UpdateOrgRole :one
+UPDATE org_roles
+SET description = coalesce(sqlc.narg('description'), description),
+  permissions = coalesce(sqlc.narg('permissions'), permissions),
+  updated_at = NOW()
+WHERE org_id = sqlc.arg('org_id')
+  AND name = sqlc.arg('name')
+RETURNING *;
+
+-- name: DeleteOrgRole :exec
+DELETE FROM org_roles
+WHERE org_id = sqlc.arg('org_id')
+  AND name = sqlc.arg('name');
+
+-- name: GetOrgRoleByName :one
+SELECT *
+FROM org_roles
+WHERE org_id = sqlc.arg('org_id')
+  AND name = sqlc.arg('name');
+
+-- name: GetOrgRoleByNameForUpdate :one
+-- Locks the row of the role until the end of the transaction, so that it is not deleted while it is assigned, and the other way around.
+SELECT *
+FROM org_roles
+WHERE org_id = sqlc.arg('org_id')
+  AND name = sqlc.arg('name')
+FOR UPDATE;
+
+-- name: ListOrgRoles :many
+SELECT *
+FROM org_roles
+WHERE org_id = sqlc.arg('org_id')
+ORDER BY name;
+
+-- name: WriteAuthzTuple :exec
+INSERT INTO authz_tuples (
+    org_id,
+    object_namespace,
+    object_id,
+    relation,
+    subject_namespace,
+    subject_id,
+    subject_relation
+  )
+VALUES (
+    sqlc.arg('org_id'),
+    sqlc.arg('object_namespace'),
+    sqlc.arg('object_id'),
+    sqlc.arg('relation'),
+    sqlc.arg('subject_namespace'),
+    sqlc.arg('subject_id'),
+    sqlc.arg('subject_relation')
+  ) ON CONFLICT DO NOTHING;
+
+-- name: DeleteAuthzTuple :exec
+DELETE FROM authz_tuples
+WHERE org_id = sqlc.arg('org_id')
+  AND object_namespace = sqlc.arg('object_namespace')
+  AND object_id = sqlc.arg('object_id')
+  AND relation = sqlc.arg('relation')
+  AND subject_namespace = sqlc.arg('subject_namespace')
+  AND subject_id = sqlc.arg('subject_id')
+  AND subject_relation = sqlc.arg('subject_relation');
+
+-- name: ListAuthzTuplesForObject :many
+SELECT *
+FROM authz_tuples
+WHERE org_id = sqlc.arg('org_id')
+  AND object_namespace = sqlc.arg('object_namespace')
+  AND object_id = sqlc.arg('object_id')
+  AND relation = sqlc.arg('relation')
+ORDER BY subject_namespace,
+  subject_id,
+  subject_relation;
+
+-- name: ListAuthzObjectIDs :many
+SELECT DISTINCT object_id
+FROM authz_tuples
+WHERE org_id = sqlc.arg('org_id')
+  AND object_namespace = sqlc.arg('object_namespace')
+ORDER BY object_id
+LIMIT sqlc.arg('limit');
+
+-- name: CreateAuditLogs :copyfrom
+INSERT INTO audit_logs (
+    id,
+    org_id,
+    user_id,
+    action,
+    resource_type,
+    resource_id,
+    ip_address,
+    user_agent,
+    metadata,
+    created_at,
+    chain_id,
+    seq,
+    prev_hash,
+    hash
+  )
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    $10,
+    $11,
+    $12,
+    $13,
+    $14
+  );
+
+-- name: ListOrgAuditLogs :many
+SELECT *
+FROM audit_logs
+WHERE org_id = sqlc.arg('org_id')
+  AND (
+    sqlc.narg('actions')::text [] IS NULL
+    OR action = ANY(sqlc.narg('actions')::text [])
+  )
+  AND (
+    sqlc.narg('actor_id')::uuid IS NULL
+    OR user_id = sqlc.narg('actor_id')
+  )
+  AND (
+    sqlc.narg('resource_type')::text IS NULL
+    OR resource_type = sqlc.narg('resource_type')
+  )
+  AND (
+    sqlc.narg('resource_id')::uuid IS NULL
+    OR resource_id = sqlc.narg('resource_id')
+  )
+  AND (
+    sqlc.narg('ip_range')::cidr IS NULL
+    OR ip_address <<= sqlc.narg('ip_range')
+  )
+  AND (
+    sqlc.narg('from')::timestamptz IS NULL
+    OR created_at >= sqlc.narg('from')
+  )
+  AND (
+    sqlc.narg('to')::timestamptz IS NULL
+    OR created_at < sqlc.narg('to')
+  )
+  AND (
+    sqlc.narg('cursor_created_at')::timestamptz IS NULL
+    OR (created_at, id) < (
+      sqlc.narg('cursor_created_at'),
+      sqlc.narg('cursor_id')::uuid
+    )
+  )
+ORDER BY created_at DESC,
+  id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: ListAuditLogs :many
+-- Lists the audit logs of all organizations, for platform admins.
+SELECT *
+FROM audit_logs
+WHERE (
+    sqlc.narg('actions')::text [] IS NULL
+    OR action = ANY(sqlc.narg('actions')::text [])
+  )
+  AND (
+    sqlc.narg('actor_id')::uuid IS NULL
+    OR user_id = sqlc.narg('actor_id')
+  )
+  AND (
+    sqlc.narg('resource_type')::text IS NULL
+    OR resource_type = sqlc.narg('resource_type')
+  )
+  AND (
+    sqlc.narg('resource_id')::uuid IS NULL
+    OR resource_id = sqlc.narg('resource_id')
+  )
+  AND (
+    sqlc.narg('ip_range')::cidr IS NULL
+    OR ip_address <<= sqlc.narg('ip_range')
+  )
+  AND (
+    sqlc.narg('from')::timestamptz IS NULL
+    OR created_at >= sqlc.narg('from')
+  )
+  AND (
+    sqlc.narg('to')::timestamptz IS NULL
+    OR created_at < sqlc.narg('to')
+  )
+  AND (
+    sqlc.narg('cursor_created_at')::timestamptz IS NULL
+    OR (created_at, id) < (
+      sqlc.narg('cursor_created_at'),
+      sqlc.narg('cursor_id')::uuid
+    )
+  )
+ORDER BY created_at DESC,
+  id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: EnsureAuditChainHead :exec
+INSERT INTO audit_chain_heads (chain_id, hash)
+VALUES (sqlc.arg('chain_id'), sqlc.arg('hash')) ON CONFLICT DO NOTHING;
+
+-- name: LockAuditChainHead :one
+SELECT *
+FROM audit_chain_heads
+WHERE chain_id = $1 FOR
+UPDATE;
+
+-- name: UpdateAuditChainHead :exec
+UPDATE audit_chain_heads
+SET seq = sqlc.arg('seq'),
+  hash = sqlc.arg('hash'),
+  updated_at = NOW()
+WHERE chain_id = sqlc.arg('chain_id');
+
+-- name: SetAuditChainCheckpoint :exec
+UPDATE audit_chain_heads
+SET checkpoint_seq = sqlc.arg('checkpoint_seq')
+WHERE chain_id = sqlc.arg('chain_id');
+
+-- name: ListAuditChainHeads :many
+SELECT *
+FROM audit_chain_heads
+ORDER BY chain_id;
+
+-- name: CreateAuditCheckpoint :one
+INSERT INTO audit_checkpoints (
+    id,
+    chain_id,
+    kind,
+    seq,
+    hash,
+    deleted_count,
+    key_id,
+    signature,
+    created_at
+  )
+VALUES (
+    sqlc.arg('id'),
+    sqlc.arg('chain_id'),
+    sqlc.arg('kind'),
+    sqlc.arg('seq'),
+    sqlc.arg('hash'),
+    sqlc.arg('deleted_count'),
+    sqlc.arg('key_id'),
+    sqlc.arg('signature'),
+    sqlc.arg('created_at')
+  )
+RETURNING *;
+
+-- name: ListAuditCheckpoints :many
+SELECT *
+FROM audit_checkpoints
+WHERE chain_id = $1
+ORDER BY seq,
+  created_at;
+
+-- name: ListAuditChainLogs :many
+SELECT *
+FROM audit_logs
+WHERE chain_id = sqlc.arg('chain_id')
+  AND seq > sqlc.arg('after_seq')::bigint
+ORDER BY seq
+LIMIT sqlc.arg('limit');
+
+-- name: GetLastAuditChainLogBefore :one
+SELECT *
+FROM audit_logs
+WHERE chain_id = sqlc.arg('chain_id')
+  AND seq IS NOT NULL
+  AND created_at < sqlc.arg('before')
+ORDER BY seq DESC
+LIMIT 1;
+
+-- name: DeleteAuditChainLogs :execrows
+DELETE FROM audit_logs
+WHERE chain_id = sqlc.arg('chain_id')
+  AND seq <= sqlc.arg('seq')::bigint;
+
+-- name: DeleteUnchainedAuditLogs :execrows
+-- Deletes the entries written before the audit logs were chained, created before the given time.
+DELETE FROM audit_logs
+WHERE seq IS NULL
+  AND created_at < sqlc.arg('before');
+
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (
+    id,
+    org_id,
+    url,
+    description,
+    event_types,
+    secret,
+    enabled
+  )
+VALUES (
+    sqlc.arg('id'),
+    sqlc.arg('org_id'),
+    sqlc.arg('url'),
+    sqlc.narg('description'),
+    sqlc.arg('event_types'),
+    sqlc.arg('secret'),
+    sqlc.arg('enabled')
+  )
+RETURNING *;
+
+-- name: ListWebhookEndpoints :many
+SELECT *
+FROM webhook_endpoints
+WHERE org_id = $1
+ORDER BY created_at;
+
+-- name: GetWebhookEndpoint :one
+SELECT *
+FROM webhook_endpoints
+WHERE id = sqlc.arg('id')
+  AND org_id = sqlc.arg('org_id');
+
+-- name: UpdateWebhookEndpoint :one
+UPDATE webhook_endpoints
+SET url = sqlc.arg('url'),
+  description = sqlc.narg('description'),
+  event_types = sqlc.arg('event_types'),
+  enabled = sqlc.arg('enabled'),
+  updated_at = NOW()
+WHERE id = sqlc.arg('id')
+  AND org_id = sqlc.arg('org_id')
+RETURNING *;
+
+-- name: DeleteWebhookEndpoint :execrows
+DELETE FROM webhook_endpoints
+WHERE id = sqlc.arg('id')
+  AND org_id = sqlc.arg('org_id');
+
+-- name: ListWebhookEndpointsForEvent :many
+-- Lists the enabled endpoints of the org which receive the event type.
+SELECT *
+FROM webhook_endpoints
+WHERE org_id = sqlc.arg('org_id')
+  AND enabled
+  AND (
+    cardinality(event_types) = 0
+    OR sqlc.arg('event_type')::text = ANY(event_types)
+  );
+
+-- name: CreateWebhookDelivery :exec
+INSERT INTO webhook_deliveries (
+    id,
+    endpoint_id,
+    org_id,
+    event_id,
+    event_type,
+    payload
+  )
+VALUES (
+    sqlc.arg('id'),
+    sqlc.arg('endpoint_id'),
+    sqlc.arg('org_id'),
+    sqlc.arg('event_id'),
+    sqlc.arg('event_type'),
+    sqlc.arg('payload')
+  );
+
+-- name: ClaimWebhookDeliveries :many
+-- Claims the due deliveries, by moving their next attempt past the lease, so that other instances skip them while they are sent.
+UPDATE webhook_deliveries d
+SET next_attempt_at = NOW() + make_interval(secs => sqlc.arg('lease_seconds')::int),
+  updated_at = NOW()
+FROM webhook_endpoints e
+WHERE d.id IN (
+    SELECT wd.id
+    FROM webhook_deliveries wd
+    WHERE wd.status = 'pending'
+      AND wd.next_attempt_at <= NOW()
+    ORDER BY wd.next_attempt_at
+    LIMIT sqlc.arg('limit') FOR
+    UPDATE SKIP LOCKED
+  )
+  AND e.id = d.endpoint_id
+RETURNING d.id,
+  d.event_id,
+  d.event_type,
+  d.payload,
+  d.attempts,
+  e.url,
+  e.secret;
+
+-- name: MarkWebhookDeliveryDelivered :exec
+UPDATE webhook_deliveries
+SET status = 'delivered',
+  attempts = attempts + 1,
+  last_error = NULL,
+  last_status_code = sqlc.arg('status_code'),
+  delivered_at = NOW(),
+  updated_at = NOW()
+WHERE id = sqlc.arg('id');
+
+-- name: MarkWebhookDeliveryFailed :exec
+UPDATE webhook_deliveries
+SET status = sqlc.arg('status'),
+  attempts = attempts + 1,
+  next_attempt_at = sqlc.arg('next_attempt_at'),
+  last_error = sqlc.arg('last_error'),
+  last_status_code = sqlc.narg('status_code'),
+  updated_at = NOW()
+WHERE id = sqlc.arg('id');
+
+-- name: ListWebhookDeliveries :many
+SELECT *
+FROM webhook_deliveries
+WHERE endpoint_id = sqlc.arg('endpoint_id')
+  AND org_id = sqlc.arg('org_id')
+  AND (
+    sqlc.narg('status')::text IS NULL
+    OR status = sqlc.narg('status')
+  )
+  AND (
+    sqlc.narg('before')::timestamptz IS NULL
+    OR created_at < sqlc.narg('before')
+  )
+ORDER BY created_at DESC
+LIMIT sqlc.arg('limit');
+
+-- name: RedeliverWebhookDelivery :one
+UPDATE webhook_deliveries
+SET status = 'pending',
+  attempts = 0,
+  next_attempt_at = NOW(),
+  updated_at = NOW()
+WHERE id = sqlc.arg('id')
+  AND endpoint_id = sqlc.arg('endpoint_id')
+  AND org_id = sqlc.arg('org_id')
+RETURNING *;
+
+-- name: CreateNotificationJob :execrows
+-- Enqueues a notification, unless a job with the same idempotency key exists, in which case no row is affected.
+INSERT INTO notification_jobs (
+    id,
+    idempotency_key,
+    channel,
+    kind,
+    recipient,
+    payload,
+    expires_at
+  )
+VALUES (
+    sqlc.arg('id'),
+    sqlc.arg('idempotency_key'),
+    sqlc.arg('channel'),
+    sqlc.arg('kind'),
+    sqlc.arg('recipient'),
+    sqlc.arg('payload'),
+    sqlc.narg('expires_at')
+  ) ON CONFLICT (idempotency_key) DO NOTHING;
+
+-- name: ClaimNotificationJobs :many
+-- Claims the due jobs, by moving their next attempt past the lease, so that other workers skip them while they are sent.
+UPDATE notification_jobs
+SET next_attempt_at = NOW() + make_interval(secs => sqlc.arg('lease_seconds')::int),
+  updated_at = NOW()
+WHERE id IN (
+    SELECT nj.id
+    FROM notification_jobs nj
+    WHERE nj.status = 'pending'
+      AND nj.next_attempt_at <= NOW()
+    ORDER BY nj.next_attempt_at
+    LIMIT sqlc.arg('limit') FOR
+    UPDATE SKIP LOCKED
+  )
+RETURNING id,
+  channel,
+  kind,
+  recipient,
+  payload,
+  attempts,
+  expires_at;
+
+-- name: MarkNotificationJobSent :exec
+UPDATE notification_jobs
+SET status = 'sent',
+  attempts = attempts + 1,
+  payload = '{}',
+  last_error = NULL,
+  sent_at = NOW(),
+  updated_at = NOW()
+WHERE id = sqlc.arg('id');
+
+-- name: MarkNotificationJobFailed :exec
+UPDATE notification_jobs
+SET status = sqlc.arg('status'),
+  attempts = attempts + 1,
+  next_attempt_at = sqlc.arg('next_attempt_at'),
+  last_error = sqlc.arg('last_error'),
+  updated_at = NOW()
+WHERE id = sqlc.arg('id');
+
+-- name: MarkNotificationJobExpired :exec
+UPDATE notification_jobs
+SET status = 'expired',
+  payload = '{}',
+  updated_at = NOW()
+WHERE id = sqlc.arg('id');
+
+-- name: CountNotificationJobsByStatus :many
+-- Counts the jobs which are waiting to be sent, or need attention.
+SELECT status,
+  count(*)
+FROM notification_jobs
+WHERE status IN ('pending', 'dead')
+GROUP BY status;
+
+-- name: ListNotificationJobs :many
+SELECT *
+FROM notification_jobs
+WHERE (
+    sqlc.narg('status')::text IS NULL
+    OR status = sqlc.narg('status')
+  )
+  AND (
+    sqlc.narg('recipient')::text IS NULL
+    OR recipient = sqlc.narg('recipient')
+  )
+  AND (
+    sqlc.narg('before')::timestamptz IS NULL
+    OR created_at < sqlc.narg('before')
+  )
+ORDER BY created_at DESC
+LIMIT sqlc.arg('limit');
+
+-- name: RetryNotificationJob :one
+-- Schedules a dead job to be sent again immediately, with a new set of attempts.
+UPDATE notification_jobs
+SET status = 'pending',
+  attempts = 0,
+  next_attempt_at = NOW(),
+  updated_at = NOW()
+WHERE id = sqlc.arg('id')
+  AND status = 'dead'
+RETURNING *;
+
+-- name: CreateAPIKey :one
+INSERT INTO api_keys (
+    id,
+    name,
+    description,
+    prefix,
+    key_hash,
+    scopes,
+    org_id,
+    expires_at,
+    created_by
+  )
+VALUES (
+    sqlc.arg('id'),
+    sqlc.arg('name'),
+    sqlc.narg('description'),
+    sqlc.arg('prefix'),
+    sqlc.arg('key_hash'),
+    sqlc.arg('scopes'),
+    sqlc.narg('org_id'),
+    sqlc.narg('expires_at'),
+    sqlc.narg('created_by')
+  )
+RETURNING *;
+
+-- name: GetAPIKey :one
+SELECT *
+FROM api_keys
+WHERE id = $1;
+
+-- name: GetAPIKeyByPrefix :one
+SELECT *
+FROM api_keys
+WHERE prefix = $1;
+
+-- name: ListAPIKeys :many
+-- Lists the keys, newest first. Revoked and expired keys are only included if include_inactive is true.
+SELECT *
+FROM api_keys
+WHERE (
+    sqlc.narg('org_id')::uuid IS NULL
+    OR org_id = sqlc.narg('org_id')
+  )
+  AND (
+    sqlc.arg('include_inactive')::boolean
+    OR (
+      revoked_at IS NULL
+      AND (
+        expires_at IS NULL
+        OR expires_at > NOW()
+      )
+    )
+  )
+ORDER BY created_at DESC;
+
+-- name: MarkAPIKeyRotated :one
+-- Marks an active key as replaced by a new key, and makes it expire at the end of the overlap window, unless it expires before.
+UPDATE api_keys
+SET rotated_to = sqlc.arg('rotated_to'),
+  expires_at = LEAST(
+    coalesce(expires_at, 'infinity'),
+    sqlc.arg('expires_at')::timestamptz
+  ),
+  updated_at = NOW()
+WHERE id = sqlc.arg('id')
+  AND revoked_at IS NULL
+  AND rotated_to IS NULL
+  AND (
+    expires_at IS NULL
+    OR expires_at > NOW()
+  )
+RETURNING *;
+
+-- name: RevokeAPIKey :one
+UPDATE api_keys
+SET revoked_at = NOW(),
+  updated_at = NOW()
+WHERE id = $1
+  AND revoked_at IS NULL
+RETURNING *;
+
+-- name: TouchAPIKey :exec
+-- Records the use of the key. last_used_at is updated at most once per minute, to limit the writes.
+UPDATE api_keys
+SET last_used_at = NOW()
+WHERE id = $1
+  AND (
+    last_used_at IS NULL
+    OR last_used_at < NOW() - INTERVAL '1 minute'
+  );
+
+-- name: PurgeExpiredSessions :execrows
+-- Deletes up to limit sessions which expired before the given time.
+DELETE FROM sessions
+WHERE id IN (
+    SELECT id
+    FROM sessions
+    WHERE expires_at < sqlc.arg('before')
+    LIMIT sqlc.arg('limit')
+  );
+
+-- name: PurgeExpiredVerificationTokens :execrows
+-- Deletes up to limit verification tokens which expired before the given time.
+DELETE FROM verification_tokens
+WHERE id IN (
+    SELECT id
+    FROM verification_tokens
+    WHERE expires_at < sqlc.arg('before')
+    LIMIT sqlc.arg('limit')
+  );
+
+-- name: PurgeExpiredInvitations :execrows
+-- Deletes up to limit invitations which expired before the given time, whatever their status.
+DELETE FROM invitations
+WHERE id IN (
+    SELECT id
+    FROM invitations
+    WHERE expires_at < sqlc.arg('before')
+    LIMIT sqlc.arg('limit')
+  );
+
+-- name: PurgeExpiredOIDCAuthCodes :execrows
+-- Deletes up to limit OIDC authorization codes which expired before the given time.
+DELETE FROM oidc_auth_codes
+WHERE id IN (
+    SELECT id
+    FROM oidc_auth_codes
+    WHERE expires_at < sqlc.arg('before')
+    LIMIT sqlc.arg('limit')
+  );
+
+-- name: PurgeExpiredOIDCRefreshTokens :execrows
+-- Deletes up to limit OIDC refresh tokens which expired before the given time.
+DELETE FROM oidc_refresh_tokens
+WHERE id IN (
+    SELECT id
+    FROM oidc_refresh_tokens
+    WHERE expires_at < sqlc.arg('before')
+    LIMIT sqlc.arg('limit')
+  );
+
+-- name: PurgeExpiredOIDCAccessTokens :execrows
+-- Deletes up to limit OIDC access tokens which expired before the given time.
+-- Tokens with a refresh token which has not expired are kept, since deleting them would delete the refresh token.
+DELETE FROM oidc_access_tokens
+WHERE id IN (
+    SELECT a.id
+    FROM oidc_access_tokens a
+    WHERE a.expires_at < sqlc.arg('before')
+      AND NOT EXISTS (
+        SELECT 1
+        FROM oidc_refresh_tokens r
+        WHERE r.access_token_id = a.id
+          AND r.expires_at >= sqlc.arg('before')
+      )
+    LIMIT sqlc.arg('limit')
+  );
+
+-- name: ListDeletedUsers :many
+-- Lists up to limit users which were soft deleted before the given time, to be erased.
+SELECT id
+FROM users
+WHERE deleted_at < sqlc.arg('before')
+ORDER BY deleted_at
+LIMIT sqlc.arg('limit');
+
+-- name: PurgeDeletedOrgs :execrows
+-- Deletes up to limit orgs which were soft deleted before the given time, with everything scoped to them.
+-- The default org is never deleted.
+DELETE FROM orgs
+WHERE id IN (
+    SELECT id
+    FROM orgs
+    WHERE deleted_at < sqlc.arg('before')
+      AND slug != 'default'
+    LIMIT sqlc.arg('limit')
+  );
+
+-- name: PurgeNotificationJobs :execrows
+-- Deletes up to limit notification jobs which are no longer pending, created before the given time.
+DELETE FROM notification_jobs
+WHERE id IN (
+    SELECT id
+    FROM notification_jobs
+    WHERE status != 'pending'
+      AND created_at < sqlc.arg('before')
+    LIMIT sqlc.arg('limit')
+  );
+
+-- name: PurgeWebhookDeliveries :execrows
+-- Deletes up to limit webhook deliveries which were delivered or are dead, created before the given time.
+DELETE FROM webhook_deliveries
+WHERE id IN (
+    SELECT id
+    FROM webhook_deliveries
+    WHERE status IN ('delivered', 'dead')
+      AND created_at < sqlc.arg('before')
+    LIMIT sqlc.arg('limit')
+  );
+
+-- name: PurgeInactiveAPIKeys :execrows
+-- Deletes up to limit API keys which were revoked or expired before the given time.
+DELETE FROM api_keys
+WHERE id IN (
+    SELECT id
+    FROM api_keys
+    WHERE revoked_at < sqlc.arg('before')
+      OR expires_at < sqlc.arg('before')
+    LIMIT sqlc.arg('limit')
+  );
+
+-- name: GetUserOrgMemberships :many
+-- Lists the organizations of the user, with the user's membership.
+SELECT sqlc.embed(o),
+  sqlc.embed(uo)
+FROM orgs o
+  INNER JOIN user_orgs uo ON o.id = uo.org_id
+WHERE uo.user_id = sqlc.arg('user_id')
+ORDER BY uo.joined_at;
+
+-- name: ListUserMFAFactors :many
+-- Lists the MFA factors of the user, without their secrets.
+SELECT id,
+  type,
+  name,
+  verified,
+  last_used_at,
+  created_at,
+  updated_at
+FROM mfa_factors
+WHERE user_id = sqlc.arg('user_id')
+ORDER BY created_at;
+
+-- name: ListUserOAuthIdentities :many
+-- Lists the OAuth identities linked to the user, without the data returned by the providers.
+SELECT id,
+  provider,
+  provider_user_id,
+  provider_user_email,
+  created_at,
+  updated_at
+FROM user_oauth_identities
+WHERE user_id = sqlc.arg('user_id')
+ORDER BY created_at;
+
+-- name: CreateDataExport :one
+INSERT INTO data_exports (
+    id,
+    user_id,
+    object_key,
+    format,
+    expires_at
+  )
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: ListUserDataExports :many
+SELECT *
+FROM data_exports
+WHERE user_id = sqlc.arg('user_id');
+
+-- name: ListExpiredDataExports :many
+-- Lists up to limit data exports which expired before the given time.
+SELECT *
+FROM data_exports
+WHERE expires_at < sqlc.arg('before')
+ORDER BY expires_at
+LIMIT sqlc.arg('limit');
+
+-- name: DeleteDataExport :exec
+DELETE FROM data_exports
+WHERE id = $1;
+
+-- name: ScheduleUserErasure :one
+-- Schedules the erasure of the user at the given time, unless it is already scheduled, and returns the time of the erasure.
+UPDATE users
+SET erasure_scheduled_at = coalesce(
+    erasure_scheduled_at,
+    sqlc.arg('erasure_scheduled_at')
+  ),
+  updated_at = NOW()
+WHERE id = sqlc.arg('id')
+RETURNING erasure_scheduled_at;
+
+-- name: CancelUserErasure :execrows
+UPDATE users
+SET erasure_scheduled_at = NULL,
+  updated_at = NOW()
+WHERE id = $1
+  AND erasure_scheduled_at IS NOT NULL;
+
+-- name: ListDueUserErasures :many
+-- Lists up to limit users whose erasure is scheduled before the given time.
+SELECT id
+FROM users
+WHERE erasure_scheduled_at < sqlc.arg('before')
+ORDER BY erasure_scheduled_at
+LIMIT sqlc.arg('limit');
+
+-- name: DeleteUserAuthzTuples :exec
+-- Deletes the relation tuples of every org with the user as their subject or object, eg. 'user:<user id>'.
+DELETE FROM authz_tuples
+WHERE subject_id = sqlc.arg('user_id')
+  OR object_id = sqlc.arg('user_id');
+
+-- name: DeleteInvitationsByEmail :exec
+-- Deletes the invitations of every org sent to the email address.
+DELETE FROM invitations
+WHERE lower(email) = lower(sqlc.arg('email'));
+
+-- name: DeleteNotificationJobsByRecipient :exec
+DELETE FROM notification_jobs
+WHERE recipient = sqlc.arg('recipient');
+
+-- name: RedactUserWebhookDeliveries :exec
+-- Removes the email from the payloads of the webhook deliveries of the user, eg. user.created, when they are erased.
+UPDATE webhook_deliveries
+SET payload = jsonb_set(payload, '{data}', (payload->'data') - 'email'),
+  updated_at = NOW()
+WHERE payload->'data'->>'userId' = sqlc.arg('user_id')::text
+  AND payload->'data'->>'email' IS NOT NULL;
+
+-- name: DeleteUser :exec
+-- Deletes the user, with their memberships, sessions, tokens, identities and MFA factors.
+DELETE FROM users
+WHERE id = $1;
+
+-- name: DeleteUserVerificationTokens :exec
+-- Deletes the verification tokens of the given type of the user.
+DELETE FROM verification_tokens
+WHERE user_id = sqlc.arg('user_id')
+  AND TYPE = sqlc.arg('type');
+
+-- name: ChangeUserEmail :exec
+-- Changes the email address of the user to a verified address.
+UPDATE users
+SET email = sqlc.arg('email'),
+  email_verified = TRUE,
+  updated_at = NOW()
+WHERE id = sqlc.arg('id');
+
+-- name: DeleteUserSessions :many
+-- Revokes every session of the user.
+DELETE FROM sessions
+WHERE user_id = sqlc.arg('user_id')
+RETURNING id,
+  user_id,
+  org_id;